@@ -1,8 +1,9 @@
 package api
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"time"
@@ -12,27 +13,51 @@ import (
 	"github.com/juruen/rmapi/filetree"
 	"github.com/juruen/rmapi/model"
 	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/vault"
 )
 
 type ApiCtx interface {
 	Filetree() *filetree.FileTreeCtx
-	FetchDocument(docId, dstPath string) error
+	FetchDocument(goCtx context.Context, docId, dstPath string) error
+	FetchDocumentReader(goCtx context.Context, docId string) (io.ReadCloser, int64, error)
+	DocumentHistory(docId string) ([]model.DocumentVersion, error)
+	FetchDocumentVersion(goCtx context.Context, docId string, version int, dstPath string) error
+	CheckDocument(goCtx context.Context, docId string, w io.Writer) error
 	CreateDir(parentId, name string, notify bool) (*model.Document, error)
-	UploadDocument(parentId string, sourceDocPath string, notify bool, coverpage *int) (*model.Document, error)
+	UploadDocument(goCtx context.Context, parentId string, sourceDocPath string, notify bool, opts *model.UploadOptions) (*model.Document, error)
+	UploadDocumentReader(goCtx context.Context, parentId, name string, r io.Reader, notify bool, opts *model.UploadOptions) (*model.Document, error)
 	ReplaceDocumentFile(docId, sourceDocPath string, notify bool) error
 	MoveEntry(src, dstDir *model.Node, name string) (*model.Node, error)
+	CopyDocument(docId, parentId, name string, notify bool) (*model.Document, error)
 	DeleteEntry(node *model.Node, recursive, notify bool) error
+	Batch(ops []model.BatchOp, notify bool) error
 	SyncComplete() error
 	Nuke() error
 	Refresh() (string, int64, error)
+	RefreshFull() (string, int64, error)
+	AccountStats() model.AccountStats
 }
 
+// ConflictError is returned by the operations on a document that was
+// changed in the cloud since the tree was loaded
+type ConflictError = sync15.ConflictError
+
+// ErrMoveCycle is returned when a folder is moved into itself or one of its
+// subfolders
+var ErrMoveCycle = sync15.ErrMoveCycle
+
+// IntegrityError is returned when a downloaded document doesn't match the
+// hashes of the sync index
+type IntegrityError = sync15.IntegrityError
+
 type UserToken struct {
 	Auth0 struct {
 		UserID string
 		Email  string
 	} `json:"auth0-profile"`
 	Scopes string
+	// Level is the subscription of the account, e.g. connect
+	Level string `json:"level"`
 	*jwt.StandardClaims
 }
 
@@ -54,6 +79,11 @@ func (s SyncVersion) String() string {
 type UserInfo struct {
 	SyncVersion SyncVersion
 	User        string
+	// Subscription is the subscription level of the token, empty if the
+	// token doesn't tell
+	Subscription string
+	// TokenExpiresAt is the expiry of the user token, renewed when needed
+	TokenExpiresAt time.Time
 }
 
 func ParseToken(userToken string) (token *UserInfo, err error) {
@@ -61,16 +91,20 @@ func ParseToken(userToken string) (token *UserInfo, err error) {
 	_, _, err = (&jwt.Parser{}).ParseUnverified(userToken, &claims)
 
 	if err != nil {
-		return nil, fmt.Errorf("can't parse token %v", err)
+		return nil, fmt.Errorf("can't parse token %v: %w", err, model.ErrAuth)
 	}
 
 	if !claims.VerifyExpiresAt(time.Now().Unix(), false) {
-		return nil, errors.New("token Expired")
+		return nil, fmt.Errorf("token Expired: %w", model.ErrAuth)
 	}
 
 	token = &UserInfo{
-		User:        claims.Auth0.Email,
-		SyncVersion: Version15,
+		User:         claims.Auth0.Email,
+		SyncVersion:  Version15,
+		Subscription: claims.Level,
+	}
+	if claims.StandardClaims != nil && claims.ExpiresAt > 0 {
+		token.TokenExpiresAt = time.Unix(claims.ExpiresAt, 0)
 	}
 
 	scopes := strings.Fields(claims.Scopes)
@@ -85,6 +119,18 @@ func ParseToken(userToken string) (token *UserInfo, err error) {
 	return token, nil
 }
 
+// CachedFileTree returns the file tree of the last sync from the local
+// cache, without authenticating, e.g. for shell completion
+func CachedFileTree() (*filetree.FileTreeCtx, error) {
+	return sync15.CachedFileTree()
+}
+
+// ResealCaches rewrites the local caches encrypted with the key to, or in
+// plain if nil, see sync15.ResealCaches
+func ResealCaches(from, to *vault.Key) error {
+	return sync15.ResealCaches(from, to)
+}
+
 // CreateApiCtx initializes an instance of ApiCtx
 func CreateApiCtx(httpCtx *transport.HttpClientCtx, syncVerison SyncVersion) (ctx ApiCtx, err error) {
 	switch syncVerison {
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -40,13 +41,15 @@ func AuthHttpCtx(reAuth, nonInteractive bool) *transport.HttpClientCtx {
 		authTokens.DeviceToken = deviceToken
 		httpClientCtx.Tokens.DeviceToken = deviceToken
 
-		config.SaveTokens(configPath, authTokens)
+		if err := config.SaveTokens(configPath, authTokens); err != nil {
+			log.Error.Fatalln(err)
+		}
 	}
 
 	if authTokens.UserToken == "" || reAuth {
 		userToken, err := newUserToken(&httpClientCtx)
 
-		if err == transport.ErrUnauthorized {
+		if errors.Is(err, transport.ErrUnauthorized) {
 			log.Trace.Println("Invalid deviceToken, resetting")
 			authTokens.DeviceToken = ""
 		} else if err != nil {
@@ -58,12 +61,69 @@ func AuthHttpCtx(reAuth, nonInteractive bool) *transport.HttpClientCtx {
 		authTokens.UserToken = userToken
 		httpClientCtx.Tokens.UserToken = userToken
 
-		config.SaveTokens(configPath, authTokens)
+		if err := config.SaveTokens(configPath, authTokens); err != nil {
+			log.Error.Fatalln(err)
+		}
 	}
 
+	addRefresher(&httpClientCtx, func(tokens model.AuthTokens) {
+		// the renewed user token is only lost, it is renewed again on
+		// the next run
+		if err := config.SaveTokens(configPath, tokens); err != nil {
+			log.Error.Println(err)
+		}
+	})
 	return &httpClientCtx
 }
 
+// Authenticate returns an http client with valid tokens, without prompting
+// or exiting on failure as AuthHttpCtx does. A device token is registered
+// with the one-time code when there is none, and a new user token is
+// requested when there is none or reAuth is set. The caller is responsible
+// for saving the tokens of the returned client if they changed, and those
+// renewed later by its Refresher.
+func Authenticate(tokens model.AuthTokens, code string, reAuth bool) (*transport.HttpClientCtx, error) {
+	return AuthenticateWith(transport.DefaultOptions, tokens, code, reAuth)
+}
+
+// AuthenticateWith is like Authenticate, the client uses the given http
+// options
+func AuthenticateWith(opts transport.Options, tokens model.AuthTokens, code string, reAuth bool) (*transport.HttpClientCtx, error) {
+	httpClientCtx := transport.HttpClientCtx{Client: transport.NewHTTPClient(opts), Tokens: tokens}
+
+	if tokens.DeviceToken == "" {
+		if code == "" {
+			return nil, fmt.Errorf("missing device token, a one-time code from https://my.remarkable.com/device/browser/connect is needed: %w", model.ErrAuth)
+		}
+		deviceToken, err := newDeviceToken(&httpClientCtx, code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create device token from one-time code: %w", err)
+		}
+		httpClientCtx.Tokens.DeviceToken = deviceToken
+	}
+
+	if httpClientCtx.Tokens.UserToken == "" || reAuth {
+		userToken, err := newUserToken(&httpClientCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user token from device token: %w", err)
+		}
+		httpClientCtx.Tokens.UserToken = userToken
+	}
+
+	addRefresher(&httpClientCtx, nil)
+	return &httpClientCtx, nil
+}
+
+// addRefresher renews the user token of the client before it expires or
+// when it is rejected, onRefresh is called with the renewed tokens
+func addRefresher(httpClientCtx *transport.HttpClientCtx, onRefresh func(model.AuthTokens)) {
+	client := httpClientCtx.Client
+	httpClientCtx.Refresher = transport.NewTokenRefresher(httpClientCtx.Tokens, func(tokens model.AuthTokens) (string, error) {
+		return newUserToken(&transport.HttpClientCtx{Client: client, Tokens: tokens})
+	})
+	httpClientCtx.Refresher.OnRefresh = onRefresh
+}
+
 func readCode() string {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Enter one-time code (go to https://my.remarkable.com/device/browser/connect): ")
@@ -83,13 +143,12 @@ func readCode() string {
 func newDeviceToken(http *transport.HttpClientCtx, code string) (string, error) {
 	uuid := uuid.New()
 
-	req := model.DeviceTokenRequest{code, defaultDeviceDesc, uuid.String()}
+	req := model.DeviceTokenRequest{Code: code, DeviceDesc: defaultDeviceDesc, DeviceId: uuid.String()}
 
 	resp := transport.BodyString{}
 	err := http.Post(transport.EmptyBearer, config.NewTokenDevice, req, &resp)
 
 	if err != nil {
-		log.Error.Fatal("failed to create a new device token")
 		return "", err
 	}
 
@@ -1,15 +1,15 @@
 package sync15
 
 import (
-	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/juruen/rmapi/archive"
@@ -20,23 +20,20 @@ import (
 	"github.com/juruen/rmapi/util"
 )
 
+// ErrMoveCycle is returned when a folder is moved into itself or one of its
+// subfolders
+var ErrMoveCycle = errors.New("cannot move a folder into itself or one of its subfolders")
+
 // An ApiCtx allows you interact with the remote reMarkable API
 type ApiCtx struct {
 	Http        *transport.HttpClientCtx
 	ft          *filetree.FileTreeCtx
-	blobStorage *BlobStorage
+	blobStorage blobStore
 	hashTree    *HashTree
 }
 
 // max number of concurrent requests
-var concurrent = 20
-
-func init() {
-	c := os.Getenv("RMAPI_CONCURRENT")
-	if u, err := strconv.Atoi(c); err == nil {
-		concurrent = u
-	}
-}
+var concurrent = transport.MaxConcurrent
 
 func CreateCtx(http *transport.HttpClientCtx) (*ApiCtx, error) {
 	apiStorage := NewBlobStorage(http)
@@ -47,7 +44,7 @@ func CreateCtx(http *transport.HttpClientCtx) (*ApiCtx, error) {
 	}
 	err = cacheTree.Mirror(apiStorage, concurrent)
 	if err != nil {
-		return nil, fmt.Errorf("failed to mirror %v", err)
+		return nil, fmt.Errorf("failed to mirror %w", err)
 	}
 	saveTree(cacheTree)
 	tree := DocumentsFileTree(cacheTree)
@@ -58,11 +55,53 @@ func (ctx *ApiCtx) Filetree() *filetree.FileTreeCtx {
 	return ctx.ft
 }
 
+// AccountStats describes the documents of the account at the last sync
+func (ctx *ApiCtx) AccountStats() model.AccountStats {
+	return ctx.hashTree.Stats()
+}
+
+// Refresh fetches the entries that changed since the last sync and updates
+// the cached tree
 func (ctx *ApiCtx) Refresh() (string, int64, error) {
 	err := ctx.hashTree.Mirror(ctx.blobStorage, concurrent)
 	if err != nil {
 		return "", 0, err
 	}
+	if err := saveTree(ctx.hashTree); err != nil {
+		log.Warning.Println("failed to save the tree cache: ", err)
+	}
+	ctx.ft = DocumentsFileTree(ctx.hashTree)
+	return ctx.hashTree.Hash, ctx.hashTree.Generation, nil
+}
+
+// RefreshFull discards the cached tree and fetches every entry again.
+// The locally recorded version history is kept.
+func (ctx *ApiCtx) RefreshFull() (string, int64, error) {
+	tree := &HashTree{}
+	err := tree.Mirror(ctx.blobStorage, concurrent)
+	if err != nil {
+		return "", 0, err
+	}
+
+	old := make(map[string]*BlobDoc, len(ctx.hashTree.Docs))
+	for _, d := range ctx.hashTree.Docs {
+		old[d.DocumentID] = d
+	}
+	for _, d := range tree.Docs {
+		prev, ok := old[d.DocumentID]
+		if !ok {
+			continue
+		}
+		if prev.Hash != d.Hash {
+			prev.recordHistory()
+		}
+		d.History = prev.History
+	}
+
+	ctx.hashTree = tree
+	if err := saveTree(ctx.hashTree); err != nil {
+		log.Warning.Println("failed to save the tree cache: ", err)
+	}
 	ctx.ft = DocumentsFileTree(ctx.hashTree)
 	return ctx.hashTree.Hash, ctx.hashTree.Generation, nil
 }
@@ -78,53 +117,92 @@ func (ctx *ApiCtx) Nuke() (err error) {
 }
 
 // FetchDocument downloads a document given its ID and saves it locally into dstPath
-func (ctx *ApiCtx) FetchDocument(docId, dstPath string) error {
+func (ctx *ApiCtx) FetchDocument(goCtx context.Context, docId, dstPath string) error {
 	doc, err := ctx.hashTree.FindDoc(docId)
 	if err != nil {
 		return err
 	}
 
+	return ctx.fetchFiles(goCtx, docId, doc.Hash, doc.Files, dstPath)
+}
+
+// DocumentHistory returns the previous versions of a document that were seen
+// while syncing, oldest first
+func (ctx *ApiCtx) DocumentHistory(docId string) ([]model.DocumentVersion, error) {
+	doc, err := ctx.hashTree.FindDoc(docId)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Versions(), nil
+}
+
+// FetchDocumentVersion downloads a previous version of a document (as listed
+// by DocumentHistory) and saves it locally into dstPath
+func (ctx *ApiCtx) FetchDocumentVersion(goCtx context.Context, docId string, version int, dstPath string) error {
+	doc, err := ctx.hashTree.FindDoc(docId)
+	if err != nil {
+		return err
+	}
+
+	if version < 1 || version > len(doc.History) {
+		return fmt.Errorf("version %d %w, %d versions known", version, model.ErrNotFound, len(doc.History))
+	}
+	h := doc.History[version-1]
+	if openDocCache().get(docId, h.Hash, dstPath) {
+		return nil
+	}
+
+	indexReader, err := ctx.blobStorage.GetReaderContext(goCtx, h.Hash, docId)
+	if err != nil {
+		return fmt.Errorf("cannot get version %d: %w", version, err)
+	}
+	defer indexReader.Close()
+
+	files, err := parseIndex(indexReader)
+	if err != nil {
+		return fmt.Errorf("version %d index error %v", version, err)
+	}
+
+	return ctx.fetchFiles(goCtx, docId, h.Hash, files, dstPath)
+}
+
+// fetchFiles downloads the blobs of a document and zips them into dstPath,
+// unless the version of the document with this index hash is cached
+func (ctx *ApiCtx) fetchFiles(goCtx context.Context, docId, hash string, files []*Entry, dstPath string) error {
+	cache := openDocCache()
+	if cache.get(docId, hash, dstPath) {
+		return nil
+	}
+
 	tmp, err := os.CreateTemp("", "rmapizip")
 
 	if err != nil {
 		log.Error.Println("failed to create tmpfile for zip dir", err)
 		return err
 	}
+	defer os.Remove(tmp.Name())
 	defer tmp.Close()
 
-	w := zip.NewWriter(tmp)
-	defer w.Close()
-	for _, f := range doc.Files {
-		log.Trace.Println("fetching document: ", f.DocumentID)
-		blobReader, err := ctx.blobStorage.GetReader(f.Hash, f.DocumentID)
-		if err != nil {
-			return err
-		}
-		defer blobReader.Close()
-		header := zip.FileHeader{}
-		header.Name = f.DocumentID
-		header.Modified = time.Now()
-		zipWriter, err := w.CreateHeader(&header)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(zipWriter, blobReader)
+	// the blobs that didn't change since the previous download, or the
+	// last cached version, are copied from it instead of downloaded
+	local := openLocalBlobs([]string{dstPath, cache.latest(docId)}, files)
+	defer local.Close()
 
-		if err != nil {
-			return err
-		}
+	if err := ctx.writeArchive(goCtx, docId, files, local, tmp); err != nil {
+		return err
 	}
-	w.Close()
+	// dstPath may be the archive read
+	local.Close()
 	tmpPath := tmp.Name()
 	_, err = util.CopyFile(tmpPath, dstPath)
 
 	if err != nil {
+		// dstPath is left untouched, the previous download stays usable
 		log.Error.Printf("failed to copy %s to %s, er: %s\n", tmpPath, dstPath, err.Error())
 		return err
 	}
 
-	defer os.RemoveAll(tmp.Name())
-
+	cache.put(docId, hash, tmpPath)
 	return nil
 }
 
@@ -210,14 +288,46 @@ func (ctx *ApiCtx) CreateDir(parentId, name string, notify bool) (*model.Documen
 	return doc.ToDocument(), nil
 }
 
-// Sync applies changes to the local tree and syncs with the remote storage
-func Sync(b *BlobStorage, tree *HashTree, operation func(t *HashTree) error, notify bool) error {
+// maxSyncTries is the number of times an operation is applied again when
+// the remote tree changed
+const maxSyncTries = 10
+
+// ConflictError is returned when a document modified by an operation was
+// changed in the cloud (e.g. from the device) since the tree was loaded
+type ConflictError struct {
+	DocumentID string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict: %s was changed in the cloud meanwhile, refresh and try again", e.DocumentID)
+}
+
+// Unwrap makes errors.Is(err, model.ErrConflict) true
+func (e *ConflictError) Unwrap() error {
+	return model.ErrConflict
+}
+
+// Sync applies changes to the local tree and syncs with the remote storage.
+// When the remote tree changed meanwhile, it is fetched again and the
+// operation is applied on top of it.
+func Sync(b SyncStorage, tree *HashTree, operation func(t *HashTree) error, notify bool) error {
+	return SyncDocs(b, tree, nil, operation, notify)
+}
+
+// SyncDocs is like Sync for an operation modifying the documents ids. If
+// one of them changed in the cloud since the tree was loaded, a
+// *ConflictError is returned instead of overwriting the changes.
+func SyncDocs(b SyncStorage, tree *HashTree, ids []string, operation func(t *HashTree) error, notify bool) error {
+	base := make(map[string]string, len(ids))
+	for _, id := range ids {
+		base[id] = docHash(tree, id)
+	}
+
 	syncTry := 0
 	for {
 		syncTry++
-		if syncTry > 10 {
-			log.Error.Println("Something is wrong")
-			break
+		if syncTry > maxSyncTries {
+			return fmt.Errorf("the remote tree keeps changing, gave up after %d tries", maxSyncTries)
 		}
 		log.Info.Println("Syncing...")
 		err := operation(tree)
@@ -246,7 +356,7 @@ func Sync(b *BlobStorage, tree *HashTree, operation func(t *HashTree) error, not
 			break
 		}
 
-		if err != transport.ErrWrongGeneration {
+		if !errors.Is(err, transport.ErrWrongGeneration) {
 			return err
 		}
 
@@ -256,18 +366,33 @@ func Sync(b *BlobStorage, tree *HashTree, operation func(t *HashTree) error, not
 		if err != nil {
 			return err
 		}
+		for _, id := range ids {
+			if docHash(tree, id) != base[id] {
+				saveTree(tree)
+				return &ConflictError{DocumentID: id}
+			}
+		}
 		log.Warning.Println("remote tree has changed, refresh the file tree")
 	}
 	return saveTree(tree)
 }
 
+// docHash returns the hash of a document of the tree, empty if missing
+func docHash(tree *HashTree, id string) string {
+	doc, err := tree.FindDoc(id)
+	if err != nil {
+		return ""
+	}
+	return doc.Hash
+}
+
 // DeleteEntry removes an entry: either an empty directory or a file
 func (ctx *ApiCtx) DeleteEntry(node *model.Node, recursive, notify bool) error {
 	if node.IsDirectory() && len(node.Children) > 0 && !recursive {
 		return errors.New("directory is not empty")
 	}
 
-	err := Sync(ctx.blobStorage, ctx.hashTree, func(t *HashTree) error {
+	err := SyncDocs(ctx.blobStorage, ctx.hashTree, []string{node.Document.ID}, func(t *HashTree) error {
 		return t.Remove(node.Document.ID)
 	}, notify)
 	return err
@@ -283,61 +408,260 @@ func (ctx *ApiCtx) MoveEntry(src, dstDir *model.Node, name string) (*model.Node,
 	}
 	var err error
 
-	err = Sync(ctx.blobStorage, ctx.hashTree, func(t *HashTree) error {
-		doc, err := t.FindDoc(src.Document.ID)
-		if err != nil {
-			return err
-		}
-		doc.Metadata.Version++
-		doc.Metadata.DocName = name
-		doc.Metadata.Parent = dstDir.Id()
-		doc.Metadata.MetadataModified = true
+	err = SyncDocs(ctx.blobStorage, ctx.hashTree, []string{src.Document.ID}, func(t *HashTree) error {
+		return ctx.moveDoc(t, src.Document.ID, dstDir.Id(), name)
+	}, true)
 
-		hashStr, reader, err := doc.MetadataHashAndReader()
+	if err != nil {
+		ctx.reloadTree()
+		return nil, err
+	}
+
+	d, err := ctx.hashTree.FindDoc(src.Document.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Node{Document: d.ToDocument(), Children: src.Children, Parent: dstDir}, nil
+}
+
+// CopyDocument duplicates a document into the parentId directory with the
+// given name, as the Duplicate action of the device: the copy has a new ID
+// and its own metadata, its other files are those of the document, not
+// uploaded again
+func (ctx *ApiCtx) CopyDocument(docId, parentId, name string, notify bool) (*model.Document, error) {
+	if name == "" {
+		return nil, errors.New("file name is invalid")
+	}
+	var copied *BlobDoc
+	err := Sync(ctx.blobStorage, ctx.hashTree, func(t *HashTree) error {
+		src, err := t.FindDoc(docId)
 		if err != nil {
 			return err
 		}
-		err = doc.Rehash()
-		if err != nil {
+		if src.Metadata.CollectionType != model.DocumentType {
+			return fmt.Errorf("%s is not a document", src.Metadata.DocName)
+		}
+		id := uuid.New().String()
+		if err := checkMove(t, id, parentId); err != nil {
 			return err
 		}
-		err = t.Rehash()
-
+		copied, err = ctx.copyDoc(src, id, parentId, name)
 		if err != nil {
 			return err
 		}
+		return t.Add(copied)
+	}, notify)
 
-		err = ctx.blobStorage.UploadBlob(hashStr, addExt(doc.DocumentID, archive.MetadataExt), reader)
+	if err != nil {
+		ctx.reloadTree()
+		return nil, err
+	}
+	return copied.ToDocument(), nil
+}
 
-		if err != nil {
-			return err
+// copyDoc returns a copy of src with the given ID, parent and name, and
+// uploads its metadata and index
+func (ctx *ApiCtx) copyDoc(src *BlobDoc, id, parent, name string) (*BlobDoc, error) {
+	doc := src.duplicate(id, parent, name)
+	hashStr, reader, err := doc.MetadataHashAndReader()
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.blobStorage.UploadBlob(hashStr, addExt(id, archive.MetadataExt), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for _, f := range doc.Files {
+		size += f.Size
+	}
+	doc.Size = size
+	if err := doc.Rehash(); err != nil {
+		return nil, err
+	}
+
+	log.Info.Println("Uploading new doc index...", doc.Hash)
+	indexReader, err := doc.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.blobStorage.UploadBlob(doc.Hash, addExt(id, archive.DocSchemaExt), indexReader)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// moveDoc sets the parent and name of an entry of the tree and uploads its
+// new metadata and index
+func (ctx *ApiCtx) moveDoc(t *HashTree, id, parent, name string) error {
+	doc, err := t.FindDoc(id)
+	if err != nil {
+		return err
+	}
+	if err := checkMove(t, id, parent); err != nil {
+		return err
+	}
+	doc.recordHistory()
+	doc.Metadata.Version++
+	doc.Metadata.DocName = name
+	doc.Metadata.Parent = parent
+	doc.Metadata.MetadataModified = true
+
+	hashStr, reader, err := doc.MetadataHashAndReader()
+	if err != nil {
+		return err
+	}
+	err = doc.Rehash()
+	if err != nil {
+		return err
+	}
+	err = t.Rehash()
+
+	if err != nil {
+		return err
+	}
+
+	err = ctx.blobStorage.UploadBlob(hashStr, addExt(doc.DocumentID, archive.MetadataExt), reader)
+
+	if err != nil {
+		return err
+	}
+
+	log.Info.Println("Uploading new doc index...", doc.Hash)
+	indexReader, err := doc.IndexReader()
+	if err != nil {
+		return err
+	}
+	// defer indexReader.Close()
+	return ctx.blobStorage.UploadBlob(doc.Hash, addExt(doc.DocumentID, archive.DocSchemaExt), indexReader)
+}
+
+// Batch applies moves, renames and deletions in a single update of the
+// root, either all of them are applied or none. A failed operation aborts
+// the batch before the root is written. Deleting a folder deletes its
+// content.
+func (ctx *ApiCtx) Batch(ops []model.BatchOp, notify bool) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(ops))
+	for _, op := range ops {
+		ids = append(ids, op.ID)
+	}
+	err := SyncDocs(ctx.blobStorage, ctx.hashTree, ids, func(t *HashTree) error {
+		for _, op := range ops {
+			var err error
+			switch op.Kind {
+			case model.BatchMove:
+				var doc *BlobDoc
+				if doc, err = t.FindDoc(op.ID); err != nil {
+					break
+				}
+				name := op.Name
+				if name == "" {
+					name = doc.Metadata.DocName
+				}
+				err = ctx.moveDoc(t, op.ID, op.Parent, name)
+			case model.BatchDelete:
+				err = removeTree(t, op.ID)
+			default:
+				err = fmt.Errorf("unknown operation %d", op.Kind)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", op.ID, err)
+			}
 		}
+		return nil
+	}, notify)
 
-		log.Info.Println("Uploading new doc index...", doc.Hash)
-		indexReader, err := doc.IndexReader()
-		if err != nil {
+	if err != nil {
+		ctx.reloadTree()
+	} else {
+		ctx.ft = DocumentsFileTree(ctx.hashTree)
+	}
+	return err
+}
+
+// removeTree removes the entry id and, for a folder, the entries under it
+func removeTree(t *HashTree, id string) error {
+	children := map[string][]string{}
+	for _, doc := range t.Docs {
+		children[doc.Metadata.Parent] = append(children[doc.Metadata.Parent], doc.DocumentID)
+	}
+	ids := []string{id}
+	seen := map[string]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	for _, id := range ids {
+		if err := t.Remove(id); err != nil {
 			return err
 		}
-		// defer indexReader.Close()
-		return ctx.blobStorage.UploadBlob(doc.Hash, addExt(doc.DocumentID, archive.DocSchemaExt), indexReader)
-	}, true)
+	}
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// reloadTree reloads the tree from the cache or the cloud after a failed
+// update: it was changed in place, even when the root couldn't be written
+func (ctx *ApiCtx) reloadTree() {
+	if tree, err := loadTree(); err == nil {
+		ctx.hashTree = tree
 	}
+	ctx.ft = DocumentsFileTree(ctx.hashTree)
+}
 
-	d, err := ctx.hashTree.FindDoc(src.Document.ID)
+// checkMove verifies that the entry id can be moved into parent: the root,
+// the trash, or a folder of the tree that is neither the entry nor one of
+// its subfolders
+func checkMove(t *HashTree, id, parent string) error {
+	if parent == "" || parent == filetree.TrashID {
+		return nil
+	}
+	dst, err := t.FindDoc(parent)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("destination folder %s", err)
+	}
+	if dst.Metadata.CollectionType != model.DirectoryType {
+		return fmt.Errorf("destination %s is not a folder", dst.Metadata.DocName)
 	}
 
-	return &model.Node{Document: d.ToDocument(), Children: src.Children, Parent: dstDir}, nil
+	// the parents of the destination up to the root
+	seen := map[string]bool{}
+	for p := dst; ; {
+		if p.DocumentID == id {
+			src, _ := t.FindDoc(id)
+			return fmt.Errorf("%w: %s into %s", ErrMoveCycle, src.Metadata.DocName, dst.Metadata.DocName)
+		}
+		seen[p.DocumentID] = true
+		next := p.Metadata.Parent
+		if next == "" || next == filetree.TrashID {
+			return nil
+		}
+		if seen[next] {
+			return fmt.Errorf("the parents of %s form a cycle", dst.Metadata.DocName)
+		}
+		if p, err = t.FindDoc(next); err != nil {
+			// an orphan, shown at the root
+			return nil
+		}
+	}
 }
 
 // UploadDocument uploads a local document given by sourceDocPath under the parentId directory
-func (ctx *ApiCtx) UploadDocument(parentId string, sourceDocPath string, notify bool, coverpage *int) (*model.Document, error) {
+func (ctx *ApiCtx) UploadDocument(goCtx context.Context, parentId string, sourceDocPath string, notify bool, opts *model.UploadOptions) (*model.Document, error) {
 	//TODO: overwrite file
 	name, ext := util.DocPathToName(sourceDocPath)
+	if opts != nil && opts.Name != "" {
+		name = opts.Name
+	}
 
 	if name == "" {
 		return nil, errors.New("file name is invalid")
@@ -356,30 +680,114 @@ func (ctx *ApiCtx) UploadDocument(parentId string, sourceDocPath string, notify
 
 	defer os.RemoveAll(tmpDir)
 
-	docFiles, id, err := archive.Prepare(name, parentId, sourceDocPath, ext, tmpDir, coverpage)
+	docFiles, id, err := archive.Prepare(name, parentId, sourceDocPath, ext, tmpDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]uploadFile, len(docFiles.Files))
+	for i, f := range docFiles.Files {
+		files[i] = uploadFile{name: f.Name, path: f.Path}
+	}
+	return ctx.uploadNewDocument(goCtx, parentId, name, id, files, notify, opts)
+}
+
+// UploadDocumentReader uploads a document read from r under the parentId
+// directory with the given name, without temporary files: the pdf, epub,
+// rmdoc or rm format is detected from its content, read into memory to be
+// hashed before the upload.
+func (ctx *ApiCtx) UploadDocumentReader(goCtx context.Context, parentId, name string, r io.Reader, notify bool, opts *model.UploadOptions) (*model.Document, error) {
+	if opts != nil && opts.Name != "" {
+		name = opts.Name
+	}
+	if name == "" {
+		return nil, errors.New("file name is invalid")
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ext := util.DetectFileType(data)
+	if ext == "" {
+		return nil, errors.New("unsupported document format, expected a pdf, epub, rmdoc or rm file")
+	}
+
+	docFiles, id, err := archive.PrepareData(name, parentId, ext, data, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	files := make([]uploadFile, len(docFiles))
+	for i, f := range docFiles {
+		files[i] = uploadFile{name: f.Name, data: f.Data}
+	}
+	return ctx.uploadNewDocument(goCtx, parentId, name, id, files, notify, opts)
+}
+
+// uploadFile is a file of a document to upload, read from path or data
+type uploadFile struct {
+	name string
+	path string
+	data []byte
+}
+
+func (f uploadFile) hashAndSize() (string, int64, error) {
+	if f.path == "" {
+		hash := sha256.Sum256(f.data)
+		return hex.EncodeToString(hash[:]), int64(len(f.data)), nil
+	}
+	hash, size, err := FileHashAndSize(f.path)
+	return hex.EncodeToString(hash), size, err
+}
+
+func (f uploadFile) open() (io.ReadCloser, error) {
+	if f.path == "" {
+		return io.NopCloser(bytes.NewReader(f.data)), nil
+	}
+	return os.Open(f.path)
+}
+
+// uploadNewDocument uploads the files of a new document, then its index and
+// adds it to the tree
+func (ctx *ApiCtx) uploadNewDocument(goCtx context.Context, parentId, name, id string, files []uploadFile, notify bool, opts *model.UploadOptions) (*model.Document, error) {
 	doc := NewBlobDoc(name, id, model.DocumentType, parentId)
-	for _, f := range docFiles.Files {
-		log.Info.Printf("File %s, path: %s", f.Name, f.Path)
-		hash, size, err := FileHashAndSize(f.Path)
+	if opts != nil {
+		doc.Metadata.Pinned = opts.Pinned
+	}
+
+	type hashedFile struct {
+		uploadFile
+		hash string
+		size int64
+	}
+	hashed := make([]hashedFile, len(files))
+	var total int64
+	for i, f := range files {
+		hash, size, err := f.hashAndSize()
 		if err != nil {
 			return nil, err
 		}
-		hashStr := hex.EncodeToString(hash)
+		hashed[i] = hashedFile{uploadFile: f, hash: hash, size: size}
+		total += size
+	}
+	progress := util.NewProgress(total)
+	defer progress.Done()
+
+	for _, f := range hashed {
+		log.Info.Printf("File %s, path: %s", f.name, f.path)
 		fileEntry := &Entry{
-			DocumentID: f.Name,
-			Hash:       hashStr,
+			DocumentID: f.name,
+			Hash:       f.hash,
 			Type:       FileType,
-			Size:       size,
+			Size:       f.size,
 		}
-		reader, err := os.Open(f.Path)
+		reader, err := f.open()
 		if err != nil {
 			return nil, err
 		}
-		err = ctx.blobStorage.UploadBlob(hashStr, fileEntry.DocumentID, reader)
+		err = ctx.blobStorage.UploadBlobContext(goCtx, f.hash, fileEntry.DocumentID, progress.Reader(reader))
+		reader.Close()
 
 		if err != nil {
 			return nil, err
@@ -388,6 +796,11 @@ func (ctx *ApiCtx) UploadDocument(parentId string, sourceDocPath string, notify
 		doc.AddFile(fileEntry)
 	}
 
+	// don't publish a document whose upload was interrupted
+	if err := goCtx.Err(); err != nil {
+		return nil, err
+	}
+
 	log.Info.Printf("Uploading new doc index...%s, size: %d", doc.Hash, doc.Size)
 	indexReader, err := doc.IndexReader()
 	if err != nil {
@@ -415,7 +828,7 @@ func (ctx *ApiCtx) UploadDocument(parentId string, sourceDocPath string, notify
 // remain untouched.
 func (ctx *ApiCtx) ReplaceDocumentFile(docId, sourceDocPath string, notify bool) error {
 	_, ext := util.DocPathToName(sourceDocPath)
-	return Sync(ctx.blobStorage, ctx.hashTree, func(t *HashTree) error {
+	return SyncDocs(ctx.blobStorage, ctx.hashTree, []string{docId}, func(t *HashTree) error {
 		doc, err := t.FindDoc(docId)
 		if err != nil {
 			return err
@@ -431,6 +844,7 @@ func (ctx *ApiCtx) ReplaceDocumentFile(docId, sourceDocPath string, notify bool)
 		if fileEntry == nil {
 			return fmt.Errorf("document does not contain .%s", ext)
 		}
+		doc.recordHistory()
 
 		hash, size, err := FileHashAndSize(sourceDocPath)
 		if err != nil {
@@ -466,6 +880,16 @@ func (ctx *ApiCtx) ReplaceDocumentFile(docId, sourceDocPath string, notify bool)
 	}, notify)
 }
 
+// CachedFileTree returns the file tree of the documents of the local tree
+// cache, without contacting the cloud. It is empty if nothing was cached.
+func CachedFileTree() (*filetree.FileTreeCtx, error) {
+	tree, err := loadTree()
+	if err != nil {
+		return nil, err
+	}
+	return DocumentsFileTree(tree), nil
+}
+
 // DocumentsFileTree reads your remote documents and builds a file tree
 // structure to represent them
 func DocumentsFileTree(tree *HashTree) *filetree.FileTreeCtx {
@@ -496,7 +920,7 @@ func (ctx *ApiCtx) SyncComplete() error {
 	err := ctx.blobStorage.SyncComplete(ctx.hashTree.Generation)
 
 	//sync can be called once per generation, ignore the error if nothing was changed
-	if err == transport.ErrConflict {
+	if errors.Is(err, transport.ErrConflict) {
 		log.Trace.Printf("ignoring error: %v", err)
 		return nil
 	}
@@ -0,0 +1,338 @@
+package sync15
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/juruen/rmapi/archive"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/transport"
+)
+
+// fakeStorage is a SyncStorage in memory, the root is only written at the
+// current generation as the cloud does
+type fakeStorage struct {
+	blobs map[string][]byte
+	root  string
+	gen   int64
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{blobs: map[string][]byte{}}
+}
+
+func (s *fakeStorage) GetRootIndex() (string, int64, error) {
+	return s.root, s.gen, nil
+}
+
+func (s *fakeStorage) GetReader(hash, name string) (io.ReadCloser, error) {
+	b, ok := s.blobs[hash]
+	if !ok {
+		return nil, fmt.Errorf("blob %s of %s: %w", hash, name, transport.ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *fakeStorage) UploadBlob(hash, name string, reader io.Reader) error {
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.blobs[hash] = b
+	return nil
+}
+
+func (s *fakeStorage) WriteRootIndex(hash string, gen int64, notify bool) (int64, error) {
+	if gen != s.gen {
+		return 0, transport.ErrWrongGeneration
+	}
+	if _, ok := s.blobs[hash]; !ok {
+		return 0, fmt.Errorf("root index %s not uploaded", hash)
+	}
+	s.root = hash
+	s.gen++
+	return s.gen, nil
+}
+
+func (s *fakeStorage) GetReaderContext(goCtx context.Context, hash, name string) (io.ReadCloser, error) {
+	return s.GetReader(hash, name)
+}
+
+func (s *fakeStorage) UploadBlobContext(goCtx context.Context, hash, name string, reader io.Reader) error {
+	return s.UploadBlob(hash, name, reader)
+}
+
+func (s *fakeStorage) Stat(goCtx context.Context, hash string) (int64, error) {
+	b, ok := s.blobs[hash]
+	if !ok {
+		return 0, transport.ErrNotFound
+	}
+	return int64(len(b)), nil
+}
+
+func (s *fakeStorage) SyncComplete(gen int64) error {
+	return nil
+}
+
+// publish writes the root of another device, bumping the generation
+func (s *fakeStorage) publish(t *testing.T, tree *HashTree) {
+	index, err := tree.IndexReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.UploadBlob(tree.Hash, "root.docSchema", index)
+	s.root = tree.Hash
+	s.gen++
+}
+
+// addDoc adds an entry to the tree, uploading its blobs
+func addDoc(t *testing.T, s *fakeStorage, tree *HashTree, id, parent, colType string) {
+	doc := NewBlobDoc(id, id, colType, parent)
+	doc.AddFile(&Entry{DocumentID: addExt(id, archive.MetadataExt)})
+	if err := tree.Add(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := renameDoc(s, tree, id, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mirror returns the tree of the cloud
+func (s *fakeStorage) mirror(t *testing.T) *HashTree {
+	tree := &HashTree{}
+	if err := tree.Mirror(s, 1); err != nil {
+		t.Fatal(err)
+	}
+	tree.Generation = s.gen
+	return tree
+}
+
+// renameDoc renames a document of the tree, uploading its new blobs
+func renameDoc(s *fakeStorage, tree *HashTree, id, name string) error {
+	doc, err := tree.FindDoc(id)
+	if err != nil {
+		return err
+	}
+	doc.Metadata.DocName = name
+	hash, reader, err := doc.MetadataHashAndReader()
+	if err != nil {
+		return err
+	}
+	s.UploadBlob(hash, addExt(id, archive.MetadataExt), reader)
+	if err := doc.Rehash(); err != nil {
+		return err
+	}
+	if err := tree.Rehash(); err != nil {
+		return err
+	}
+	index, err := doc.IndexReader()
+	if err != nil {
+		return err
+	}
+	return s.UploadBlob(doc.Hash, addExt(id, archive.DocSchemaExt), index)
+}
+
+func docName(t *testing.T, tree *HashTree, id string) string {
+	doc, err := tree.FindDoc(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Metadata.DocName
+}
+
+func TestSyncDocsConflict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// remote changes the cloud after the tree was loaded
+		remote   func(s *fakeStorage, device *HashTree) error
+		conflict bool
+	}{
+		{
+			name:   "unchanged document",
+			remote: func(s *fakeStorage, device *HashTree) error { return nil },
+		},
+		{
+			name:     "document changed",
+			remote:   func(s *fakeStorage, device *HashTree) error { return renameDoc(s, device, "a", "a from the device") },
+			conflict: true,
+		},
+		{
+			name:   "untracked document changed",
+			remote: func(s *fakeStorage, device *HashTree) error { return renameDoc(s, device, "b", "b from the device") },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			s := newFakeStorage()
+			device := &HashTree{}
+			addDoc(t, s, device, "a", "", model.DocumentType)
+			addDoc(t, s, device, "b", "", model.DocumentType)
+			s.publish(t, device)
+			tree := s.mirror(t)
+
+			if err := tc.remote(s, device); err != nil {
+				t.Fatal(err)
+			}
+			s.publish(t, device)
+
+			tries := 0
+			err := SyncDocs(s, tree, []string{"a"}, func(tree *HashTree) error {
+				tries++
+				return renameDoc(s, tree, "a", "renamed")
+			}, false)
+
+			if tc.conflict {
+				var conflict *ConflictError
+				if !errors.As(err, &conflict) || conflict.DocumentID != "a" {
+					t.Fatalf("got %v, want a conflict on a", err)
+				}
+				if docName(t, tree, "a") != "a from the device" {
+					t.Errorf("the change of the device is lost: %s", docName(t, tree, "a"))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SyncDocs: %v", err)
+			}
+			if tries != 2 {
+				t.Errorf("operation applied %d times, want 2", tries)
+			}
+
+			cloud := s.mirror(t)
+			if got := docName(t, cloud, "a"); got != "renamed" {
+				t.Errorf("a is %q in the cloud", got)
+			}
+			if got, want := docName(t, cloud, "b"), docName(t, device, "b"); got != want {
+				t.Errorf("b is %q in the cloud, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	s := newFakeStorage()
+	device := &HashTree{}
+	addDoc(t, s, device, "work", "", model.DirectoryType)
+	addDoc(t, s, device, "projects", "work", model.DirectoryType)
+	addDoc(t, s, device, "plan", "projects", model.DocumentType)
+	addDoc(t, s, device, "archive", "", model.DirectoryType)
+	addDoc(t, s, device, "notes", "", model.DocumentType)
+	s.publish(t, device)
+
+	newCtx := func() *ApiCtx {
+		tree := s.mirror(t)
+		if err := saveTree(tree); err != nil {
+			t.Fatal(err)
+		}
+		return &ApiCtx{blobStorage: s, hashTree: tree, ft: DocumentsFileTree(tree)}
+	}
+	parent := func(tree *HashTree, id string) string {
+		doc, err := tree.FindDoc(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc.Metadata.Parent
+	}
+
+	// a failed operation aborts the whole batch
+	ctx := newCtx()
+	gen := s.gen
+	err := ctx.Batch([]model.BatchOp{
+		{Kind: model.BatchMove, ID: "notes", Parent: "archive"},
+		{Kind: model.BatchMove, ID: "plan", Parent: "missing"},
+	}, false)
+	if err == nil {
+		t.Fatal("moved into a missing folder")
+	}
+	if s.gen != gen || parent(s.mirror(t), "notes") != "" {
+		t.Errorf("the cloud was changed by a failed batch")
+	}
+	if parent(ctx.hashTree, "notes") != "" {
+		t.Errorf("the tree was not reloaded after a failed batch")
+	}
+
+	// a folder can't be moved into its own subtree
+	err = ctx.Batch([]model.BatchOp{{Kind: model.BatchMove, ID: "work", Parent: "projects"}}, false)
+	if !errors.Is(err, ErrMoveCycle) {
+		t.Errorf("got %v, want ErrMoveCycle", err)
+	}
+
+	err = ctx.Batch([]model.BatchOp{
+		{Kind: model.BatchMove, ID: "notes", Parent: "archive", Name: "old notes"},
+		{Kind: model.BatchDelete, ID: "work"},
+	}, false)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if s.gen != gen+1 {
+		t.Errorf("root written %d times, want once", s.gen-gen)
+	}
+	cloud := s.mirror(t)
+	if parent(cloud, "notes") != "archive" || docName(t, cloud, "notes") != "old notes" {
+		t.Errorf("notes not moved")
+	}
+	for _, id := range []string{"work", "projects", "plan"} {
+		if _, err := cloud.FindDoc(id); err == nil {
+			t.Errorf("%s not deleted with its folder", id)
+		}
+	}
+	if len(cloud.Docs) != 2 {
+		t.Errorf("got %d entries, want 2", len(cloud.Docs))
+	}
+}
+
+func TestRefreshFull(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	s := newFakeStorage()
+	device := &HashTree{}
+	addDoc(t, s, device, "notes", "", model.DocumentType)
+	s.publish(t, device)
+
+	// a cached tree out of step with the cloud under the same hashes
+	tree := s.mirror(t)
+	doc, err := tree.FindDoc("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.Metadata.DocName = "stale"
+	doc.History = []HistoryEntry{{Hash: "previous"}}
+	ctx := &ApiCtx{blobStorage: s, hashTree: tree, ft: DocumentsFileTree(tree)}
+
+	if _, _, err := ctx.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if name := docName(t, ctx.hashTree, "notes"); name != "stale" {
+		t.Fatalf("refresh fetched an unchanged entry again, got %s", name)
+	}
+
+	hash, _, err := ctx.RefreshFull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != s.root {
+		t.Errorf("got root hash %s, want %s", hash, s.root)
+	}
+	if name := docName(t, ctx.hashTree, "notes"); name != "notes" {
+		t.Errorf("the cached entry was kept, got %s", name)
+	}
+	if node := ctx.ft.NodeById("notes"); node == nil || node.Name() != "notes" {
+		t.Errorf("the file tree was not rebuilt: %v", node)
+	}
+	doc, _ = ctx.hashTree.FindDoc("notes")
+	if len(doc.History) != 1 || doc.History[0].Hash != "previous" {
+		t.Errorf("the history was lost: %+v", doc.History)
+	}
+
+	cached, err := loadTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := docName(t, cached, "notes"); name != "notes" {
+		t.Errorf("the cache was not rewritten, got %s", name)
+	}
+}
@@ -0,0 +1,144 @@
+package sync15
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/vault"
+)
+
+// localBlobs are the files of a previous copy of a document, by the hash
+// of their content. Blob hashes are the sha256 of the content, so the
+// files that didn't change don't need to be downloaded again.
+type localBlobs struct {
+	// r is nil for an encrypted cached archive, decrypted in memory
+	r      *zip.ReadCloser
+	files  map[string]*zip.File
+	closed bool
+}
+
+// openLocalBlobs indexes the first of the archives that can be read, only
+// the files with the size of a wanted file are hashed. It returns nil if
+// there is nothing to reuse.
+func openLocalBlobs(paths []string, wanted []*Entry) *localBlobs {
+	sizes := make(map[uint64]bool)
+	hashes := make(map[string]bool)
+	for _, f := range wanted {
+		sizes[uint64(f.Size)] = true
+		hashes[f.Hash] = true
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		var zr *zip.Reader
+		var r *zip.ReadCloser
+		if vault.IsSealedFile(path) {
+			// decrypted in memory, the cache is never written in plain
+			data, err := readSealedFile(vault.DefaultKey, path)
+			if err != nil {
+				continue
+			}
+			if zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+				continue
+			}
+		} else {
+			var err error
+			if r, err = zip.OpenReader(path); err != nil {
+				continue
+			}
+			zr = &r.Reader
+		}
+
+		l := &localBlobs{r: r, files: make(map[string]*zip.File)}
+		for _, f := range zr.File {
+			if !sizes[f.UncompressedSize64] {
+				continue
+			}
+			if hash, err := hashZipFile(f); err == nil && hashes[hash] {
+				l.files[hash] = f
+			}
+		}
+		if len(l.files) > 0 {
+			log.Trace.Printf("reusing the files of %s", path)
+			return l
+		}
+		l.Close()
+	}
+	return nil
+}
+
+func hashZipFile(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// open returns the content of the blob with this hash
+func (l *localBlobs) open(hash string) (io.ReadCloser, bool) {
+	if l == nil {
+		return nil, false
+	}
+	f, ok := l.files[hash]
+	if !ok {
+		return nil, false
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, false
+	}
+	return rc, true
+}
+
+// has tells if the blob with this hash can be reused
+func (l *localBlobs) has(hash string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.files[hash]
+	return ok
+}
+
+// Close closes the archive, it can be called several times
+func (l *localBlobs) Close() error {
+	if l == nil || l.closed {
+		return nil
+	}
+	l.closed = true
+	l.files = nil
+	if l.r == nil {
+		return nil
+	}
+	return l.r.Close()
+}
+
+// latest returns the most recently used cached version of a document
+func (c *docCache) latest(docID string) string {
+	if c == nil {
+		return ""
+	}
+	matches, _ := filepath.Glob(filepath.Join(c.dir, docID, "*.rmdoc"))
+	var latest string
+	var latestTime int64
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err == nil && info.ModTime().UnixNano() > latestTime {
+			latest, latestTime = m, info.ModTime().UnixNano()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,158 @@
+package sync15
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenLocalBlobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.rmdoc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range map[string]string{"doc/page1.rm": "page one", "doc/page2.rm": "page two, edited"} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte(content))
+	}
+	w.Close()
+	f.Close()
+
+	hash := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	wanted := []*Entry{
+		{Hash: hash("page one"), DocumentID: "doc/page1.rm", Size: 8},
+		{Hash: hash("page two"), DocumentID: "doc/page2.rm", Size: 8},
+	}
+
+	local := openLocalBlobs([]string{filepath.Join(t.TempDir(), "missing.rmdoc"), path}, wanted)
+	if local == nil {
+		t.Fatal("archive not opened")
+	}
+	defer local.Close()
+
+	if !local.has(wanted[0].Hash) || local.has(wanted[1].Hash) {
+		t.Errorf("unexpected reusable files: %v", local.files)
+	}
+	r, ok := local.open(wanted[0].Hash)
+	if !ok {
+		t.Fatal("unchanged file not opened")
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "page one" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	if openLocalBlobs([]string{path}, wanted[1:]) != nil {
+		t.Errorf("nothing to reuse but archive returned")
+	}
+}
+
+func TestFetchVerified(t *testing.T) {
+	sum := sha256.Sum256([]byte("page one"))
+	entry := &Entry{Hash: hex.EncodeToString(sum[:]), DocumentID: "doc/page1.rm", Size: 8}
+
+	// corrupted once, then downloaded again
+	responses := []string{"page 0ne", "page one"}
+	calls := 0
+	get := func() (io.ReadCloser, error) {
+		r := io.NopCloser(strings.NewReader(responses[calls%len(responses)]))
+		calls++
+		return r, nil
+	}
+	r, err := fetchVerified(context.Background(), "doc", entry, nil, get)
+	if err != nil {
+		t.Fatalf("fetchVerified: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "page one" || calls != 2 {
+		t.Errorf("got %q after %d downloads", b, calls)
+	}
+
+	responses = []string{"page 0ne"}
+	calls = 0
+	_, err = fetchVerified(context.Background(), "doc", entry, nil, get)
+	var integrityErr *IntegrityError
+	if !errors.As(err, &integrityErr) || calls != maxBlobTries {
+		t.Errorf("got %v after %d downloads, want an IntegrityError", err, calls)
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.rmdoc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	var files []*Entry
+	for _, name := range []string{"doc.content", "doc/page1.rm", "doc/page2.rm"} {
+		fw, _ := w.Create(name)
+		fw.Write([]byte("content of " + name))
+		sum := sha256.Sum256([]byte("content of " + name))
+		files = append(files, &Entry{Hash: hex.EncodeToString(sum[:]), DocumentID: name, Size: int64(len("content of " + name))})
+	}
+	w.Close()
+	f.Close()
+
+	local := openLocalBlobs([]string{path}, files)
+	defer local.Close()
+	var buf bytes.Buffer
+	if err := (&ApiCtx{}).writeArchive(context.Background(), "doc", files, local, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if size := archiveSize(files); int64(buf.Len()) != size {
+		t.Errorf("expected an archive of %d bytes, got %d", size, buf.Len())
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(r.File) != 3 {
+		t.Fatalf("invalid archive: %v", err)
+	}
+
+	if archiveSize([]*Entry{{DocumentID: "doc.content"}}) != -1 {
+		t.Errorf("the size of an archive with files of unknown size should be unknown")
+	}
+}
+
+func TestFetchDocumentReaderCached(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cache := openDocCache()
+	if cache == nil {
+		t.Skip("no cache directory")
+	}
+	src := filepath.Join(t.TempDir(), "doc.rmdoc")
+	if err := os.WriteFile(src, []byte("cached archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.put("doc1", "v1", src)
+
+	doc := &BlobDoc{Entry: Entry{DocumentID: "doc1", Hash: "v1"}}
+	ctx := &ApiCtx{hashTree: &HashTree{Docs: []*BlobDoc{doc}}}
+	r, size, err := ctx.FetchDocumentReader(context.Background(), "doc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, _ := io.ReadAll(r)
+	if string(b) != "cached archive" || size != int64(len(b)) {
+		t.Errorf("unexpected cached document %q of %d bytes", b, size)
+	}
+
+	if _, _, err := ctx.FetchDocumentReader(context.Background(), "missing"); err == nil {
+		t.Errorf("expected an error for a missing document")
+	}
+}
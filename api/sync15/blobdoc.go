@@ -22,8 +22,20 @@ type BlobDoc struct {
 	Files []*Entry
 	Entry
 	Metadata archive.MetadataFile
+	History  []HistoryEntry `json:",omitempty"`
 }
 
+// HistoryEntry records a previous index hash of a document
+type HistoryEntry struct {
+	Hash         string
+	Size         int64
+	Version      int
+	LastModified string
+}
+
+// max number of previous versions kept per document
+const maxHistory = 50
+
 func NewBlobDoc(name, documentId, colType, parentId string) *BlobDoc {
 	return &BlobDoc{
 		Metadata: archive.MetadataFile{
@@ -155,8 +167,45 @@ func (d *BlobDoc) Line() string {
 	return sb.String()
 }
 
+// recordHistory remembers the current index hash before it gets replaced
+func (d *BlobDoc) recordHistory() {
+	if d.Hash == "" {
+		return
+	}
+	if n := len(d.History); n > 0 && d.History[n-1].Hash == d.Hash {
+		return
+	}
+	d.History = append(d.History, HistoryEntry{
+		Hash:         d.Hash,
+		Size:         d.Size,
+		Version:      d.Metadata.Version,
+		LastModified: d.Metadata.LastModified,
+	})
+	if len(d.History) > maxHistory {
+		d.History = d.History[len(d.History)-maxHistory:]
+	}
+}
+
+// Versions returns the known previous versions, oldest first
+func (d *BlobDoc) Versions() []model.DocumentVersion {
+	versions := make([]model.DocumentVersion, 0, len(d.History))
+	for i, h := range d.History {
+		versions = append(versions, model.DocumentVersion{
+			Number:         i + 1,
+			Hash:           h.Hash,
+			Version:        h.Version,
+			ModifiedClient: toRFC3339(h.LastModified),
+			Size:           h.Size,
+		})
+	}
+	return versions
+}
+
 // Mirror updates the document to be the same as the remote
 func (d *BlobDoc) Mirror(e *Entry, r RemoteStorage) error {
+	if d.Hash != e.Hash {
+		d.recordHistory()
+	}
 	d.Entry = *e
 	entryIndex, err := r.GetReader(e.Hash, e.DocumentID)
 	if err != nil {
@@ -206,17 +255,48 @@ func (d *BlobDoc) Mirror(e *Entry, r RemoteStorage) error {
 	return nil
 
 }
-func (d *BlobDoc) ToDocument() *model.Document {
-	var lastModified string
-	unixTime, err := strconv.ParseInt(d.Metadata.LastModified, 10, 64)
-	if err == nil {
-		//HACK: convert wrong nano timestamps to millis
-		if len(d.Metadata.LastModified) > 18 {
-			unixTime /= 1000000
+
+// toRFC3339 converts a metadata timestamp (unix millis) to RFC3339
+func toRFC3339(timestamp string) string {
+	unixTime, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ""
+	}
+	//HACK: convert wrong nano timestamps to millis
+	if len(timestamp) > 18 {
+		unixTime /= 1000000
+	}
+
+	t := time.Unix(unixTime/1000, 0)
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// duplicate returns a copy of the document with the given ID, parent and
+// name, whose files are renamed after the ID. Its metadata and hash are
+// left to update.
+func (d *BlobDoc) duplicate(id, parent, name string) *BlobDoc {
+	doc := &BlobDoc{Entry: Entry{DocumentID: id, Type: d.Type}, Metadata: d.Metadata}
+	doc.Metadata.DocName = name
+	doc.Metadata.Parent = parent
+	doc.Metadata.Version = 0
+	doc.Metadata.LastModified = archive.UnixTimestamp()
+	doc.Metadata.MetadataModified = false
+	for _, f := range d.Files {
+		// <id>.content, <id>/<page>.rm, <id>.thumbnails/<page>.jpg...
+		file := *f
+		if rest, ok := strings.CutPrefix(f.DocumentID, d.DocumentID); ok {
+			file.DocumentID = id + rest
 		}
+		doc.Files = append(doc.Files, &file)
+	}
+	return doc
+}
 
-		t := time.Unix(unixTime/1000, 0)
-		lastModified = t.UTC().Format(time.RFC3339Nano)
+func (d *BlobDoc) ToDocument() *model.Document {
+	lastModified := toRFC3339(d.Metadata.LastModified)
+	var size int64
+	for _, f := range d.Files {
+		size += f.Size
 	}
 	return &model.Document{
 		ID:             d.DocumentID,
@@ -226,5 +306,27 @@ func (d *BlobDoc) ToDocument() *model.Document {
 		Type:           d.Metadata.CollectionType,
 		CurrentPage:    d.Metadata.LastOpenedPage,
 		ModifiedClient: lastModified,
+		Size:           size,
+		Hash:           d.Hash,
+		ContentHash:    d.contentHash(),
+	}
+}
+
+// contentHash hashes the hashes of the PDF, EPUB and page files of the
+// document, named relative to it, so that the copies of a document and the
+// documents imported twice have the same one
+func (d *BlobDoc) contentHash() string {
+	var lines []string
+	for _, f := range d.Files {
+		name := strings.TrimPrefix(f.DocumentID, d.DocumentID)
+		if strings.HasSuffix(name, ".pdf") || strings.HasSuffix(name, ".epub") || strings.HasSuffix(name, ".rm") {
+			lines = append(lines, name+" "+f.Hash)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
 	}
+	sort.Strings(lines)
+	h := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(h[:])
 }
@@ -1,8 +1,10 @@
 package sync15
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net/http"
 
 	"github.com/juruen/rmapi/config"
 	"github.com/juruen/rmapi/log"
@@ -22,13 +24,36 @@ func NewBlobStorage(http *transport.HttpClientCtx) *BlobStorage {
 }
 
 func (b *BlobStorage) GetReader(hash, filename string) (io.ReadCloser, error) {
-	return b.http.GetStream(transport.UserBearer, config.BlobUrl+hash, filename)
+	return b.GetReaderContext(context.Background(), hash, filename)
+}
+
+// GetReaderContext is like GetReader, the download is aborted when goCtx is cancelled
+func (b *BlobStorage) GetReaderContext(goCtx context.Context, hash, filename string) (io.ReadCloser, error) {
+	return b.http.GetStreamContext(goCtx, transport.UserBearer, config.BlobUrl+hash, filename)
+}
+
+// Stat checks that a blob is stored with a HEAD request, without
+// downloading it, and returns its size, -1 if the server doesn't tell it
+func (b *BlobStorage) Stat(goCtx context.Context, hash string) (int64, error) {
+	response, err := b.http.RequestContext(goCtx, transport.UserBearer, http.MethodHead, config.BlobUrl+hash, nil, nil, 0)
+	if response != nil {
+		response.Body.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return response.ContentLength, nil
 }
 
 func (b *BlobStorage) UploadBlob(hash, filename string, reader io.Reader) error {
+	return b.UploadBlobContext(context.Background(), hash, filename, reader)
+}
+
+// UploadBlobContext is like UploadBlob, the upload is aborted when goCtx is cancelled
+func (b *BlobStorage) UploadBlobContext(goCtx context.Context, hash, filename string, reader io.Reader) error {
 	log.Trace.Println("uploading blob ", filename)
 
-	return b.http.PutStream(transport.UserBearer, config.BlobUrl+hash, reader, filename)
+	return b.http.PutStreamContext(goCtx, transport.UserBearer, config.BlobUrl+hash, reader, filename)
 }
 
 // SyncComplete no longer used
@@ -9,6 +9,7 @@ import (
 	"sort"
 
 	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/vault"
 )
 
 func HashEntries(entries []*Entry) (string, error) {
@@ -54,6 +55,16 @@ func loadTree() (*HashTree, error) {
 		if err != nil {
 			return nil, err
 		}
+		if vault.IsSealed(b) {
+			if vault.DefaultKey == nil {
+				log.Warning.Println("cache encrypted and the storage is locked, resyncing")
+				return tree, nil
+			}
+			if b, err = vault.Open(vault.DefaultKey, b); err != nil {
+				log.Error.Println("cache can't be decrypted, resyncing")
+				return tree, nil
+			}
+		}
 		err = json.Unmarshal(b, tree)
 		if err != nil {
 			log.Error.Println("cache corrupt, resyncing")
@@ -81,6 +92,11 @@ func saveTree(tree *HashTree) error {
 	if err != nil {
 		return err
 	}
+	if vault.DefaultKey != nil {
+		if b, err = vault.Seal(vault.DefaultKey, b); err != nil {
+			return err
+		}
+	}
 	err = os.WriteFile(cacheFile, b, 0644)
 	return err
 }
@@ -0,0 +1,236 @@
+package sync15
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
+)
+
+// documentCacheSize is the max size in bytes of the downloaded documents
+// kept in the cache, set in MB by RMAPI_CACHE_SIZE, 0 disables the cache
+var documentCacheSize int64 = 2 << 30
+
+func init() {
+	if mb, err := strconv.ParseInt(os.Getenv("RMAPI_CACHE_SIZE"), 10, 64); err == nil && mb >= 0 {
+		documentCacheSize = mb << 20
+	}
+}
+
+// docCache keeps the downloaded documents by id and hash of their index,
+// so that an unchanged document isn't downloaded again. The least
+// recently used documents are removed past maxSize. They are encrypted
+// with vault.DefaultKey when set.
+type docCache struct {
+	dir     string
+	maxSize int64
+}
+
+// openDocCache returns the cache of the user cache directory, nil when
+// disabled or unavailable
+func openDocCache() *docCache {
+	if documentCacheSize <= 0 {
+		return nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.Trace.Println("no document cache: ", err)
+		return nil
+	}
+	return &docCache{dir: filepath.Join(cacheDir, "rmapi", "documents"), maxSize: documentCacheSize}
+}
+
+func (c *docCache) path(docID, hash string) string {
+	return filepath.Join(c.dir, docID, hash+".rmdoc")
+}
+
+// get copies a cached document to dstPath, false if it isn't cached
+func (c *docCache) get(docID, hash, dstPath string) bool {
+	if c == nil || hash == "" {
+		return false
+	}
+	cached := c.path(docID, hash)
+	var err error
+	if vault.IsSealedFile(cached) {
+		err = openSealedFile(vault.DefaultKey, cached, dstPath)
+	} else {
+		_, err = util.CopyFile(cached, dstPath)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warning.Printf("failed to read the cached document %s: %v", cached, err)
+		}
+		return false
+	}
+	// mark as recently used
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	log.Info.Printf("document %s found in cache", docID)
+	return true
+}
+
+// open returns a cached document and its size, false if it isn't cached
+func (c *docCache) open(docID, hash string) (io.ReadCloser, int64, bool) {
+	if c == nil || hash == "" {
+		return nil, 0, false
+	}
+	cached := c.path(docID, hash)
+	f, err := os.Open(cached)
+	if err != nil {
+		return nil, 0, false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, false
+	}
+	var rc io.ReadCloser = f
+	size := stat.Size()
+	if vault.IsSealedFile(cached) {
+		if vault.DefaultKey == nil {
+			f.Close()
+			return nil, 0, false
+		}
+		r, err := vault.NewReader(f, vault.DefaultKey)
+		if err != nil {
+			f.Close()
+			return nil, 0, false
+		}
+		rc = struct {
+			io.Reader
+			io.Closer
+		}{r, f}
+		size = vault.PlainSize(size)
+	}
+	// mark as recently used
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	log.Info.Printf("document %s found in cache", docID)
+	return rc, size, true
+}
+
+// put adds a downloaded document to the cache, failures are only logged
+func (c *docCache) put(docID, hash, srcPath string) {
+	if c == nil || hash == "" {
+		return
+	}
+	cached := c.path(docID, hash)
+	if err := os.MkdirAll(filepath.Dir(cached), 0700); err != nil {
+		log.Warning.Printf("failed to cache document %s: %v", docID, err)
+		return
+	}
+	// the copy is renamed once complete, a partial copy is never read
+	var err error
+	if vault.DefaultKey != nil {
+		err = sealFile(vault.DefaultKey, srcPath, cached)
+	} else {
+		_, err = util.CopyFile(srcPath, cached)
+	}
+	if err != nil {
+		log.Warning.Printf("failed to cache document %s: %v", docID, err)
+		return
+	}
+	c.prune()
+}
+
+// sealFile writes src encrypted with key to dst
+func sealFile(key *vault.Key, src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return util.WriteFileAtomic(dst, func(w io.Writer) error {
+		sw, err := vault.NewWriter(w, key)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(sw, r); err != nil {
+			return err
+		}
+		return sw.Close()
+	})
+}
+
+// openSealedFile writes the plain content of the file sealed by sealFile
+func openSealedFile(key *vault.Key, src, dst string) error {
+	r, closer, err := sealedFileReader(key, src)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	return util.WriteFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// readSealedFile returns the plain content of the file sealed by sealFile,
+// it is decrypted in memory and never written in plain
+func readSealedFile(key *vault.Key, path string) ([]byte, error) {
+	r, closer, err := sealedFileReader(key, path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return io.ReadAll(r)
+}
+
+func sealedFileReader(key *vault.Key, path string) (io.Reader, io.Closer, error) {
+	if key == nil {
+		return nil, nil, errors.New("the cache is encrypted and the storage is locked")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := vault.NewReader(f, key)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, f, nil
+}
+
+// prune removes the least recently used documents until the cache fits in
+// maxSize
+func (c *docCache) prune() {
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	var total int64
+	filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".rmdoc" {
+			return nil
+		}
+		files = append(files, cachedFile{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if total <= c.maxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			continue
+		}
+		total -= f.size
+		// the folder of the document, removed once empty
+		os.Remove(filepath.Dir(f.path))
+	}
+}
@@ -0,0 +1,121 @@
+package sync15
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/vault"
+)
+
+func TestDocCache(t *testing.T) {
+	dir := t.TempDir()
+	cache := &docCache{dir: filepath.Join(dir, "cache"), maxSize: 10}
+
+	src := filepath.Join(dir, "doc.rmdoc")
+	if err := os.WriteFile(src, []byte("123456"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "out.rmdoc")
+
+	if cache.get("doc1", "v1", dst) {
+		t.Fatalf("empty cache returned a document")
+	}
+	cache.put("doc1", "v1", src)
+	if !cache.get("doc1", "v1", dst) {
+		t.Fatalf("document not cached")
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "123456" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if cache.get("doc1", "v2", dst) {
+		t.Errorf("another version returned")
+	}
+
+	// past the max size, the least recently used document is removed
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.path("doc1", "v1"), old, old)
+	cache.put("doc2", "v1", src)
+	if _, err := os.Stat(cache.path("doc1", "v1")); !os.IsNotExist(err) {
+		t.Errorf("old document not pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cache.dir, "doc1")); !os.IsNotExist(err) {
+		t.Errorf("empty folder not removed: %v", err)
+	}
+	if !cache.get("doc2", "v1", dst) {
+		t.Errorf("new document pruned")
+	}
+
+	var disabled *docCache
+	disabled.put("doc1", "v1", src)
+	if disabled.get("doc1", "v1", dst) {
+		t.Errorf("disabled cache returned a document")
+	}
+}
+
+func TestDocCacheEncrypted(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	cache := openDocCache()
+	if cache == nil {
+		t.Skip("no cache directory")
+	}
+	key, err := vault.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { vault.DefaultKey = nil }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "doc.rmdoc")
+	if err := os.WriteFile(src, []byte("secret notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "out.rmdoc")
+
+	// a plain cache is encrypted, then read back
+	cache.put("doc1", "v1", src)
+	if err := ResealCaches(nil, key); err != nil {
+		t.Fatal(err)
+	}
+	if !vault.IsSealedFile(cache.path("doc1", "v1")) {
+		t.Fatalf("cached document not encrypted")
+	}
+	if cache.get("doc1", "v1", dst) {
+		t.Errorf("encrypted document read without the key")
+	}
+
+	vault.DefaultKey = key
+	cache.put("doc2", "v1", src)
+	if b, _ := os.ReadFile(cache.path("doc2", "v1")); strings.Contains(string(b), "secret") {
+		t.Errorf("document cached in plain")
+	}
+	for _, id := range []string{"doc1", "doc2"} {
+		if !cache.get(id, "v1", dst) {
+			t.Fatalf("%s not cached", id)
+		}
+		if b, _ := os.ReadFile(dst); string(b) != "secret notes" {
+			t.Errorf("%s: unexpected content %q", id, b)
+		}
+		r, size, ok := cache.open(id, "v1")
+		if !ok {
+			t.Fatalf("%s not opened", id)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != "secret notes" || size != int64(len(b)) {
+			t.Errorf("%s: unexpected stream %q of %d bytes", id, b, size)
+		}
+	}
+
+	// and back to plain
+	if err := ResealCaches(key, nil); err != nil {
+		t.Fatal(err)
+	}
+	vault.DefaultKey = nil
+	if b, _ := os.ReadFile(cache.path("doc2", "v1")); string(b) != "secret notes" {
+		t.Errorf("document not decrypted: %q", b)
+	}
+}
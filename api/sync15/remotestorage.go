@@ -1,6 +1,9 @@
 package sync15
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type RemoteStorage interface {
 	GetRootIndex() (hash string, generation int64, err error)
@@ -11,3 +14,19 @@ type RemoteStorageWriter interface {
 	UpdateRootIndex(hash string, generation int64) (gen int64, err error)
 	GetWriter(hash, name string, writer io.WriteCloser) error
 }
+
+// SyncStorage is the remote storage updated by Sync, see BlobStorage
+type SyncStorage interface {
+	RemoteStorage
+	UploadBlob(hash, name string, reader io.Reader) error
+	WriteRootIndex(hash string, generation int64, notify bool) (gen int64, err error)
+}
+
+// blobStore is the storage of the documents of an ApiCtx, see BlobStorage
+type blobStore interface {
+	SyncStorage
+	GetReaderContext(goCtx context.Context, hash, name string) (io.ReadCloser, error)
+	UploadBlobContext(goCtx context.Context, hash, name string, reader io.Reader) error
+	Stat(goCtx context.Context, hash string) (int64, error)
+	SyncComplete(gen int64) error
+}
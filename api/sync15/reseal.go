@@ -0,0 +1,84 @@
+package sync15
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
+)
+
+// ResealCaches rewrites the tree cache and the cached documents encrypted
+// with to, or in plain if nil, when the encryption of the storage changes.
+// They are read with from, or in plain if nil; the cached documents that
+// can't be read are removed and downloaded again when needed.
+func ResealCaches(from, to *vault.Key) error {
+	defer func(key *vault.Key) { vault.DefaultKey = key }(vault.DefaultKey)
+
+	vault.DefaultKey = from
+	tree, err := loadTree()
+	if err != nil {
+		return err
+	}
+	// an empty tree is the cache missing or unreadable
+	if tree.Hash != "" {
+		vault.DefaultKey = to
+		if err := saveTree(tree); err != nil {
+			return err
+		}
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+	return filepath.Walk(filepath.Join(cacheDir, "rmapi", "documents"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".rmdoc" {
+			return nil
+		}
+		if err := resealFile(path, from, to); err != nil {
+			log.Warning.Printf("removing the cached document %s: %v", path, err)
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// resealFile rewrites a cached document encrypted with to, or in plain.
+// The document is decrypted in memory and replaced atomically so that it
+// isn't read while replaced.
+func resealFile(path string, from, to *vault.Key) error {
+	var data []byte
+	var err error
+	if vault.IsSealedFile(path) {
+		data, err = readSealedFile(from, path)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(path, func(w io.Writer) error {
+		if to == nil {
+			_, err := w.Write(data)
+			return err
+		}
+		sw, err := vault.NewWriter(w, to)
+		if err != nil {
+			return err
+		}
+		if _, err := sw.Write(data); err != nil {
+			return err
+		}
+		return sw.Close()
+	})
+}
@@ -0,0 +1,158 @@
+package sync15
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+)
+
+// Sizes of the records of the archives written by writeArchive: the
+// files are stored with a data descriptor and an extended timestamp
+const (
+	zipLocalHeaderLen    = 30
+	zipDataDescriptorLen = 16
+	zipCentralHeaderLen  = 46
+	zipDirectoryEndLen   = 22
+	zipExtendedTimeLen   = 9
+	zipMaxSize           = math.MaxUint32
+	zipMaxEntries        = math.MaxUint16
+)
+
+// FetchDocumentReader returns a document as a .rmdoc stream and its size
+// in bytes, without writing it to a file: the cached copy of its version,
+// or the archive written while its files are downloaded. The size is -1
+// if it can't be known in advance. Closing the stream stops the download.
+func (ctx *ApiCtx) FetchDocumentReader(goCtx context.Context, docId string) (io.ReadCloser, int64, error) {
+	doc, err := ctx.hashTree.FindDoc(docId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cache := openDocCache()
+	if f, size, ok := cache.open(docId, doc.Hash); ok {
+		return f, size, nil
+	}
+
+	size := archiveSize(doc.Files)
+	goCtx, cancel := context.WithCancel(goCtx)
+	pr, pw := io.Pipe()
+	go func() {
+		defer cancel()
+		local := openLocalBlobs([]string{cache.latest(docId)}, doc.Files)
+		defer local.Close()
+
+		w := &countingWriter{w: pw}
+		err := ctx.writeArchive(goCtx, docId, doc.Files, local, w)
+		if err == nil && size >= 0 && w.n != size {
+			// the sizes of the index are wrong, the reader is told
+			err = fmt.Errorf("the archive of %s has %d bytes instead of %d", docId, w.n, size)
+		}
+		pw.CloseWithError(err)
+	}()
+	return &archiveStream{PipeReader: pr, cancel: cancel}, size, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// archiveStream is the read end of an archive being written
+type archiveStream struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (s *archiveStream) Close() error {
+	s.cancel()
+	return s.PipeReader.Close()
+}
+
+// archiveSize returns the size of the archive writeArchive writes with the
+// files, -1 for the archives that need zip64 records or with files of
+// unknown size
+func archiveSize(files []*Entry) int64 {
+	if len(files) >= zipMaxEntries {
+		return -1
+	}
+	var size int64
+	for _, f := range files {
+		// an empty size may be missing from the index
+		if f.Size <= 0 || f.Size >= zipMaxSize {
+			return -1
+		}
+		name := int64(len(f.DocumentID))
+		size += zipLocalHeaderLen + name + zipExtendedTimeLen + f.Size + zipDataDescriptorLen
+		size += zipCentralHeaderLen + name + zipExtendedTimeLen
+	}
+	size += zipDirectoryEndLen
+	if size >= zipMaxSize {
+		return -1
+	}
+	return size
+}
+
+// writeArchive writes the files of a document to w as a zip archive, the
+// files of local are copied instead of downloaded
+func (ctx *ApiCtx) writeArchive(goCtx context.Context, docId string, files []*Entry, local *localBlobs, w io.Writer) error {
+	var total int64
+	var reused int
+	for _, f := range files {
+		if local.has(f.Hash) {
+			reused++
+		} else {
+			total += f.Size
+		}
+	}
+	if reused > 0 {
+		log.Info.Printf("%d of %d files of %s unchanged, not downloaded", reused, len(files), docId)
+	}
+	progress := util.NewProgress(total)
+	defer progress.Done()
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	for _, f := range files {
+		var blobReader io.ReadCloser
+		if r, ok := local.open(f.Hash); ok {
+			blobReader = r
+		} else {
+			log.Trace.Println("fetching document: ", f.DocumentID)
+			r, err := fetchVerified(goCtx, docId, f, progress, func() (io.ReadCloser, error) {
+				return ctx.blobStorage.GetReaderContext(goCtx, f.Hash, f.DocumentID)
+			})
+			if err != nil {
+				return err
+			}
+			blobReader = r
+		}
+		header := zip.FileHeader{}
+		header.Name = f.DocumentID
+		header.Modified = time.Now()
+		zipWriter, err := zw.CreateHeader(&header)
+		if err != nil {
+			blobReader.Close()
+			return err
+		}
+		_, err = io.Copy(zipWriter, blobReader)
+		// closed right away, downloaded blobs are spooled to temporary files
+		blobReader.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
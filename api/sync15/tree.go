@@ -5,14 +5,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/juruen/rmapi/archive"
+	"github.com/juruen/rmapi/filetree"
 	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
 	"github.com/juruen/rmapi/transport"
 	"golang.org/x/sync/errgroup"
 )
@@ -103,7 +107,7 @@ func parseIndex(f io.Reader) ([]*Entry, error) {
 	scanner := bufio.NewScanner(f)
 	eof := scanner.Scan()
 	if !eof {
-		return nil, fmt.Errorf("empty index file")
+		return nil, fmt.Errorf("empty index file: %w", model.ErrParse)
 	}
 	schema := scanner.Text()
 	expectedCount := 0
@@ -114,12 +118,12 @@ func parseIndex(f io.Reader) ([]*Entry, error) {
 	case SchemaVersionV4:
 		eof := scanner.Scan()
 		if !eof {
-			return nil, fmt.Errorf("expecting a schema v4 line")
+			return nil, fmt.Errorf("expecting a schema v4 line: %w", model.ErrParse)
 		}
 		line := scanner.Text()
 		expectedCount, _, err = parseSchemaV4(line)
 		if err != nil {
-			return nil, fmt.Errorf("can't parse v4 line %v", err)
+			return nil, fmt.Errorf("can't parse v4 line %v: %w", err, model.ErrParse)
 		}
 		fallthrough
 	case SchemaVersionV3:
@@ -132,13 +136,13 @@ func parseIndex(f io.Reader) ([]*Entry, error) {
 			count++
 			entry, err := parseEntry(line)
 			if err != nil {
-				return nil, fmt.Errorf("cant parse line '%s', %w", line, err)
+				return nil, fmt.Errorf("cant parse line '%s', %v: %w", line, err, model.ErrParse)
 			}
 
 			entries = append(entries, entry)
 		}
 	default:
-		return nil, fmt.Errorf("unsupported schema %s", schema)
+		return nil, fmt.Errorf("schema %s: %w", schema, model.ErrUnsupportedVersion)
 	}
 	if schema == SchemaVersionV4 {
 		if count != expectedCount {
@@ -166,6 +170,8 @@ type HashTree struct {
 	Generation   int64
 	Docs         []*BlobDoc
 	CacheVersion int
+	// LastSync is the time of the last successful Mirror
+	LastSync time.Time
 }
 
 func (t *HashTree) FindDoc(id string) (*BlobDoc, error) {
@@ -175,7 +181,7 @@ func (t *HashTree) FindDoc(id string) (*BlobDoc, error) {
 			return d, nil
 		}
 	}
-	return nil, fmt.Errorf("doc %s not found", id)
+	return nil, fmt.Errorf("doc %s %w", id, model.ErrNotFound)
 }
 
 func (t *HashTree) Remove(id string) error {
@@ -195,7 +201,7 @@ func (t *HashTree) Remove(id string) error {
 		t.Rehash()
 		return nil
 	}
-	return fmt.Errorf("%s not found", id)
+	return fmt.Errorf("%s %w", id, model.ErrNotFound)
 }
 
 func (t *HashTree) Rehash() error {
@@ -220,30 +226,32 @@ func addExt(name string, ext archive.RmExt) string {
 // / Mirror makes the tree look like the storage
 func (t *HashTree) Mirror(r RemoteStorage, maxconcurrent int) error {
 	rootHash, gen, err := r.GetRootIndex()
-	if err != nil && err != transport.ErrNotFound {
+	if err != nil && !errors.Is(err, transport.ErrNotFound) {
 		return err
 	}
 	if rootHash == "" && gen == 0 {
 		log.Info.Println("Empty cloud")
 		t.Docs = nil
 		t.Generation = 0
+		t.LastSync = time.Now()
 		return nil
 	}
 
 	if rootHash == t.Hash {
+		t.LastSync = time.Now()
 		return nil
 	}
 	log.Info.Printf("remote root hash different")
 
 	rootIndexReader, err := r.GetReader(rootHash, addExt("root", archive.DocSchemaExt))
 	if err != nil {
-		return fmt.Errorf("cannot get root hash %v", err)
+		return fmt.Errorf("cannot get root hash %w", err)
 	}
 	defer rootIndexReader.Close()
 
 	entries, err := parseIndex(rootIndexReader)
 	if err != nil {
-		return fmt.Errorf("cannot parse rootIndex, %v", err)
+		return fmt.Errorf("cannot parse rootIndex, %w", err)
 	}
 
 	head := make([]*BlobDoc, 0)
@@ -304,6 +312,7 @@ EXIT:
 	t.Docs = head
 	t.Generation = gen
 	t.Hash = rootHash
+	t.LastSync = time.Now()
 	return nil
 }
 
@@ -352,3 +361,32 @@ func BuildTree(provider RemoteStorage) (*HashTree, error) {
 	return &tree, nil
 
 }
+
+// Stats counts the documents of the tree and the size of their files
+func (t *HashTree) Stats() model.AccountStats {
+	stats := model.AccountStats{
+		RootHash:   t.Hash,
+		Generation: t.Generation,
+		LastSync:   t.LastSync,
+	}
+	for _, d := range t.Docs {
+		if d.Metadata.Deleted {
+			continue
+		}
+		if d.Metadata.CollectionType == model.DirectoryType {
+			stats.Folders++
+		} else {
+			stats.Documents++
+		}
+		if d.Metadata.Parent == filetree.TrashID {
+			stats.Trashed++
+		}
+		for _, f := range d.Files {
+			stats.Size += f.Size
+		}
+		if modified, err := time.Parse(time.RFC3339Nano, toRFC3339(d.Metadata.LastModified)); err == nil && modified.After(stats.LastModified) {
+			stats.LastModified = modified
+		}
+	}
+	return stats
+}
@@ -1,6 +1,7 @@
 package sync15
 
 import (
+	"errors"
 	"io"
 	"strings"
 	"testing"
@@ -120,3 +121,144 @@ e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855:80000000:someid
 	}
 
 }
+
+func TestRecordHistory(t *testing.T) {
+	doc := &BlobDoc{
+		Entry: Entry{
+			Hash:       "hash1",
+			DocumentID: "someid",
+		},
+	}
+	doc.Metadata.LastModified = "1700000000000"
+
+	doc.recordHistory()
+	doc.recordHistory()
+	if len(doc.History) != 1 {
+		t.Errorf("expected 1 history entry, got %d", len(doc.History))
+		return
+	}
+
+	doc.Hash = "hash2"
+	doc.recordHistory()
+	versions := doc.Versions()
+	if len(versions) != 2 {
+		t.Errorf("expected 2 versions, got %d", len(versions))
+		return
+	}
+	if versions[0].Number != 1 || versions[0].Hash != "hash1" {
+		t.Errorf("wrong first version %v", versions[0])
+	}
+	if versions[0].ModifiedClient != "2023-11-14T22:13:20Z" {
+		t.Errorf("wrong modified time %s", versions[0].ModifiedClient)
+	}
+}
+
+func TestTreeStats(t *testing.T) {
+	doc := func(id, parent, collectionType, lastModified string, deleted bool, sizes ...int64) *BlobDoc {
+		d := &BlobDoc{Entry: Entry{DocumentID: id}}
+		d.Metadata.Parent = parent
+		d.Metadata.CollectionType = collectionType
+		d.Metadata.LastModified = lastModified
+		d.Metadata.Deleted = deleted
+		for _, size := range sizes {
+			d.Files = append(d.Files, &Entry{Size: size})
+		}
+		return d
+	}
+	tree := &HashTree{Hash: "root", Generation: 7, Docs: []*BlobDoc{
+		doc("folder", "", "CollectionType", "1700000000000", false, 100),
+		doc("a", "folder", "DocumentType", "1710000000000", false, 1000, 2000),
+		doc("b", "trash", "DocumentType", "1705000000000", false, 500),
+		doc("c", "", "DocumentType", "1720000000000", true, 9999),
+	}}
+
+	stats := tree.Stats()
+	if stats.Documents != 2 || stats.Folders != 1 || stats.Trashed != 1 {
+		t.Errorf("got %d documents, %d folders, %d trashed", stats.Documents, stats.Folders, stats.Trashed)
+	}
+	if stats.Size != 3600 {
+		t.Errorf("got size %d", stats.Size)
+	}
+	if stats.RootHash != "root" || stats.Generation != 7 {
+		t.Errorf("got root %s generation %d", stats.RootHash, stats.Generation)
+	}
+	if stats.LastModified.Unix() != 1710000000 {
+		t.Errorf("got last modified %v", stats.LastModified)
+	}
+}
+
+func TestCheckMove(t *testing.T) {
+	doc := func(id, parent, collectionType string) *BlobDoc {
+		d := &BlobDoc{Entry: Entry{DocumentID: id}}
+		d.Metadata.DocName = id
+		d.Metadata.Parent = parent
+		d.Metadata.CollectionType = collectionType
+		return d
+	}
+	tree := &HashTree{Docs: []*BlobDoc{
+		doc("a", "", "CollectionType"),
+		doc("b", "a", "CollectionType"),
+		doc("c", "b", "CollectionType"),
+		doc("d", "c", "DocumentType"),
+		doc("x", "y", "CollectionType"),
+		doc("y", "x", "CollectionType"),
+	}}
+
+	for _, tc := range []struct {
+		id, parent string
+		cycle      bool
+		ok         bool
+	}{
+		{"c", "", false, true},
+		{"c", "trash", false, true},
+		{"c", "a", false, true},
+		{"d", "a", false, true},
+		{"a", "a", true, false},
+		{"a", "c", true, false},
+		{"b", "c", true, false},
+		{"a", "d", false, false},
+		{"a", "missing", false, false},
+		{"a", "x", false, false},
+	} {
+		err := checkMove(tree, tc.id, tc.parent)
+		if (err == nil) != tc.ok {
+			t.Errorf("move %s into %s: got %v", tc.id, tc.parent, err)
+		}
+		if errors.Is(err, ErrMoveCycle) != tc.cycle {
+			t.Errorf("move %s into %s: got %v, cycle %v", tc.id, tc.parent, err, tc.cycle)
+		}
+	}
+}
+
+func TestDuplicateDoc(t *testing.T) {
+	src := &BlobDoc{Entry: Entry{DocumentID: "old", Hash: "index"}, Files: []*Entry{
+		{DocumentID: "old.content", Hash: "h1", Size: 10},
+		{DocumentID: "old.metadata", Hash: "h2", Size: 20},
+		{DocumentID: "old/page.rm", Hash: "h3", Size: 30},
+	}}
+	src.Metadata.DocName = "Weekly"
+	src.Metadata.Parent = "templates"
+	src.Metadata.Version = 4
+	src.Metadata.Pinned = true
+
+	doc := src.duplicate("new", "planner", "Week 12")
+	if doc.DocumentID != "new" || doc.Metadata.DocName != "Week 12" || doc.Metadata.Parent != "planner" {
+		t.Errorf("unexpected copy %s %+v", doc.DocumentID, doc.Metadata)
+	}
+	if doc.Metadata.Version != 0 || !doc.Metadata.Pinned {
+		t.Errorf("unexpected metadata %+v", doc.Metadata)
+	}
+	want := []string{"new.content", "new.metadata", "new/page.rm"}
+	for i, f := range doc.Files {
+		if f.DocumentID != want[i] || f.Hash != src.Files[i].Hash {
+			t.Errorf("file %d: got %s %s", i, f.DocumentID, f.Hash)
+		}
+	}
+
+	if _, _, err := doc.MetadataHashAndReader(); err != nil {
+		t.Fatal(err)
+	}
+	if src.Files[0].DocumentID != "old.content" || src.Files[1].Hash != "h2" {
+		t.Errorf("the files of the document changed: %v %v", src.Files[0], src.Files[1])
+	}
+}
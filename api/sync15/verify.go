@@ -0,0 +1,127 @@
+package sync15
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juruen/rmapi/archive"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// maxBlobTries is the number of times a blob whose content doesn't match
+// its hash is downloaded before giving up
+const maxBlobTries = 3
+
+// IntegrityError is returned when a downloaded file of a document doesn't
+// match the hash in the sync index, even after downloading it again
+type IntegrityError struct {
+	DocumentID string
+	File       string
+	Hash       string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity error: %s of document %s doesn't match its hash %s", e.File, e.DocumentID, e.Hash)
+}
+
+// Unwrap makes errors.Is(err, model.ErrParse) true
+func (e *IntegrityError) Unwrap() error {
+	return model.ErrParse
+}
+
+// fetchVerified downloads a blob with get into a temporary file and checks
+// its content against the hash of the entry, it is downloaded again on
+// mismatch. The temporary file is removed when the returned reader is closed.
+func fetchVerified(goCtx context.Context, docId string, f *Entry, progress *util.Progress, get func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	for try := 1; ; try++ {
+		if err := goCtx.Err(); err != nil {
+			return nil, err
+		}
+
+		tmp, hash, err := spoolBlob(progress, get)
+		if err != nil {
+			return nil, err
+		}
+		if f.Hash == "" || hash == f.Hash {
+			return tmp, nil
+		}
+		tmp.Close()
+
+		log.Warning.Printf("%s of document %s doesn't match its hash (try %d of %d)", f.DocumentID, docId, try, maxBlobTries)
+		if try == maxBlobTries {
+			return nil, &IntegrityError{DocumentID: docId, File: f.DocumentID, Hash: f.Hash}
+		}
+	}
+}
+
+// spoolBlob copies a blob to a temporary file, returning it rewound along
+// with the sha256 of its content
+func spoolBlob(progress *util.Progress, get func() (io.ReadCloser, error)) (*tempFile, string, error) {
+	r, err := get()
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	f, err := os.CreateTemp("", "rmapiblob")
+	if err != nil {
+		return nil, "", err
+	}
+	tmp := &tempFile{f}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), progress.Reader(r)); err != nil {
+		tmp.Close()
+		return nil, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, "", err
+	}
+	return tmp, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tempFile is a temporary file removed once closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+// CheckDocument checks the files of a document in the cloud, without the
+// local caches. With w set, every file is downloaded and checked against
+// its hash (IntegrityError), and the document is written to w as a .rmdoc
+// archive. Otherwise the files are only checked to be stored, with their
+// size, without downloading them.
+func (ctx *ApiCtx) CheckDocument(goCtx context.Context, docId string, w io.Writer) error {
+	doc, err := ctx.hashTree.FindDoc(docId)
+	if err != nil {
+		return err
+	}
+	if w != nil {
+		return ctx.writeArchive(goCtx, docId, doc.Files, nil, w)
+	}
+
+	index := &Entry{Hash: doc.Hash, DocumentID: addExt(docId, archive.DocSchemaExt)}
+	for _, f := range append([]*Entry{index}, doc.Files...) {
+		size, err := ctx.blobStorage.Stat(goCtx, f.Hash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.DocumentID, err)
+		}
+		if size >= 0 && f.Size > 0 && size != f.Size {
+			// the content can't match the hash
+			return &IntegrityError{DocumentID: docId, File: f.DocumentID, Hash: f.Hash}
+		}
+	}
+	return nil
+}
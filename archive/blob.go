@@ -2,6 +2,7 @@ package archive
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -47,8 +48,12 @@ func (d *DocumentFiles) AddMap(name, filepath string, filetype RmExt) {
 }
 
 // Prepare prepares a file for uploading (creates needed temp files or unpacks a zip)
-func Prepare(name, parentId, sourceDocPath, ext, tmpDir string, coverpage *int) (files *DocumentFiles, id string, err error) {
+func Prepare(name, parentId, sourceDocPath, ext, tmpDir string, opts *model.UploadOptions) (files *DocumentFiles, id string, err error) {
 	files = &DocumentFiles{}
+	var coverpage *int
+	if opts != nil {
+		coverpage = opts.Coverpage
+	}
 	if ext == util.ZIP || ext == util.RMDOC {
 		var metadataPath string
 		id, files, metadataPath, err = Unpack(sourceDocPath, tmpDir)
@@ -97,29 +102,202 @@ func Prepare(name, parentId, sourceDocPath, ext, tmpDir string, coverpage *int)
 		}
 		files.AddMap(objectName, filePath, ContentExt)
 	}
+	if opts != nil {
+		err = applyUploadOptions(files, opts)
+	}
 	return files, id, err
 }
 
+// NameData is a file of a document prepared in memory
+type NameData struct {
+	Name     string
+	Data     []byte
+	FileType RmExt
+}
+
+// PrepareData prepares a document read into memory for uploading, like
+// Prepare without temporary files. ext is its format, see
+// util.DetectFileType.
+func PrepareData(name, parentId, ext string, data []byte, opts *model.UploadOptions) (files []NameData, id string, err error) {
+	var coverpage *int
+	if opts != nil {
+		coverpage = opts.Coverpage
+	}
+	if ext == util.ZIP || ext == util.RMDOC {
+		id, files, err = unpackData(name, parentId, data)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		id = uuid.New().String()
+		objectName := id + "." + ext
+		doctype := ext
+		var pageIds []string
+		if ext == util.RM {
+			pageId := uuid.New().String()
+			objectName = fmt.Sprintf("%s/%s.rm", id, pageId)
+			doctype = "notebook"
+			pageIds = []string{pageId}
+		}
+		files = append(files, NameData{Name: objectName, Data: data, FileType: RmExt(doctype)})
+
+		metadata, err := json.Marshal(newMetadata(name, parentId, model.DocumentType))
+		if err != nil {
+			return nil, "", err
+		}
+		files = append(files, NameData{Name: id + "." + string(MetadataExt), Data: metadata, FileType: MetadataExt})
+
+		content, err := createZipContent(doctype, pageIds, coverpage)
+		if err != nil {
+			return nil, "", err
+		}
+		files = append(files, NameData{Name: id + "." + string(ContentExt), Data: []byte(content), FileType: ContentExt})
+	}
+	if opts != nil {
+		for i, f := range files {
+			update := uploadOptionsUpdate(f.FileType, opts)
+			if update == nil {
+				continue
+			}
+			if files[i].Data, err = updateJSONData(f.Data, update); err != nil {
+				return nil, "", fmt.Errorf("can't parse %s: %v", f.Name, err)
+			}
+		}
+	}
+	return files, id, nil
+}
+
+// unpackData reads the files of a rmapi .zip or .rmdoc archive, with the
+// metadata fixed or created for the new parent and name
+func unpackData(name, parentId string, data []byte) (id string, files []NameData, err error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", nil, err
+	}
+	metadata := -1
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// the names become those of the blobs of the document
+		if path.IsAbs(f.Name) || path.Clean(f.Name) != f.Name || strings.HasPrefix(f.Name, "../") {
+			return "", nil, fmt.Errorf("%s: illegal file path", f.Name)
+		}
+		ext := strings.TrimPrefix(path.Ext(f.Name), ".")
+		if ext == string(ContentExt) {
+			id = strings.TrimSuffix(f.Name, path.Ext(f.Name))
+		}
+		if ext == string(MetadataExt) {
+			metadata = len(files)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", nil, err
+		}
+		files = append(files, NameData{Name: f.Name, Data: content, FileType: RmExt(ext)})
+	}
+	if id == "" {
+		return "", nil, errors.New("could not determine the Document UUID")
+	}
+
+	if metadata < 0 {
+		log.Warning.Println("missing metadata, creating...", name)
+		content, err := json.Marshal(newMetadata(name, parentId, model.DocumentType))
+		if err != nil {
+			return "", nil, err
+		}
+		files = append(files, NameData{Name: id + "." + string(MetadataExt), Data: content, FileType: MetadataExt})
+	} else if files[metadata].Data, err = fixMetadataData(parentId, name, files[metadata].Data); err != nil {
+		return "", nil, err
+	}
+	return id, files, nil
+}
+
+// applyUploadOptions sets the pinned flag and document tags in the prepared
+// metadata and content files
+func applyUploadOptions(files *DocumentFiles, opts *model.UploadOptions) error {
+	for _, f := range files.Files {
+		update := uploadOptionsUpdate(f.FileType, opts)
+		if update == nil {
+			continue
+		}
+		if err := updateJSON(f.Path, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadOptionsUpdate returns the change of the options to the json object
+// of a file of the given type, nil if there is none
+func uploadOptionsUpdate(fileType RmExt, opts *model.UploadOptions) func(map[string]interface{}) {
+	switch {
+	case fileType == MetadataExt && opts.Pinned:
+		return func(m map[string]interface{}) {
+			m["pinned"] = true
+		}
+	case fileType == ContentExt && len(opts.Tags) > 0:
+		return func(m map[string]interface{}) {
+			m["tags"] = NewTags(opts.Tags)
+		}
+	}
+	return nil
+}
+
+// updateJSON rewrites a json object file in place, keeping unknown fields
+func updateJSON(path string, update func(map[string]interface{})) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = updateJSONData(data, update)
+	if err != nil {
+		return fmt.Errorf("can't parse %s: %v", filepath.Base(path), err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// updateJSONData returns a json object changed by update, keeping unknown
+// fields
+func updateJSONData(data []byte, update func(map[string]interface{})) ([]byte, error) {
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	update(m)
+	return json.Marshal(m)
+}
+
 // FixMetadata fixes the metadata with the new parent and filename
 func FixMetadata(parentId, name, path string) error {
-	meta := MetadataFile{}
 	metaData, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(metaData, &meta)
+	metaData, err = fixMetadataData(parentId, name, metaData)
 	if err != nil {
 		return err
 	}
-	meta.Parent = parentId
-	meta.DocName = name
-	meta.LastModified = UnixTimestamp()
+	return os.WriteFile(path, metaData, 0600)
+}
 
-	metaData, err = json.Marshal(meta)
+// fixMetadataData returns the metadata with the new parent and filename
+func fixMetadataData(parentId, name string, metaData []byte) ([]byte, error) {
+	meta := MetadataFile{}
+	err := json.Unmarshal(metaData, &meta)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return os.WriteFile(path, metaData, 0600)
+	meta.Parent = parentId
+	meta.DocName = name
+	meta.LastModified = UnixTimestamp()
+	return json.Marshal(meta)
 }
 
 // Unpack unpacks a rmapi .zip file
@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+func TestPrepareUploadOptions(t *testing.T) {
+	opts := &model.UploadOptions{
+		Tags:   []string{"work", "books"},
+		Pinned: true,
+	}
+
+	files, id, err := Prepare("doc", "parent", "zipdoc_test.pdf", "pdf", t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" {
+		t.Fatal("missing document id")
+	}
+
+	for _, f := range files.Files {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f.FileType {
+		case MetadataExt:
+			meta := MetadataFile{}
+			if err := json.Unmarshal(data, &meta); err != nil {
+				t.Fatal(err)
+			}
+			if !meta.Pinned {
+				t.Error("expected document to be pinned")
+			}
+			if meta.DocName != "doc" || meta.Parent != "parent" {
+				t.Errorf("unexpected metadata %+v", meta)
+			}
+		case ContentExt:
+			content := Content{}
+			if err := json.Unmarshal(data, &content); err != nil {
+				t.Fatal(err)
+			}
+			if len(content.DocumentTags) != 2 || content.DocumentTags[0].Name != "work" {
+				t.Errorf("unexpected tags %+v", content.DocumentTags)
+			}
+			if content.FileType != "pdf" {
+				t.Errorf("content fields lost, file type %q", content.FileType)
+			}
+		}
+	}
+}
+
+func TestPrepareData(t *testing.T) {
+	pdf, err := os.ReadFile("zipdoc_test.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext := util.DetectFileType(pdf); ext != util.PDF {
+		t.Fatalf("detected %q for a pdf", ext)
+	}
+
+	epub := zipData(t, map[string]string{"mimetype": "application/epub+zip"})
+	if ext := util.DetectFileType(epub); ext != util.EPUB {
+		t.Errorf("detected %q for an epub", ext)
+	}
+	if ext := util.DetectFileType([]byte("hello")); ext != "" {
+		t.Errorf("detected %q for text", ext)
+	}
+
+	files, id, err := PrepareData("doc", "parent", util.PDF, pdf, &model.UploadOptions{Pinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Name != id+".pdf" || len(files[0].Data) != len(pdf) {
+		t.Fatalf("unexpected files %v", files)
+	}
+	meta := MetadataFile{}
+	if err := json.Unmarshal(files[1].Data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Pinned || meta.DocName != "doc" || meta.Parent != "parent" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+
+	rmdoc := zipData(t, map[string]string{
+		"abc.content":  "{}",
+		"abc.metadata": `{"visibleName":"old","parent":"other"}`,
+		"abc/p1.rm":    "reMarkable .lines file, version=6",
+	})
+	if ext := util.DetectFileType(rmdoc); ext != util.RMDOC {
+		t.Fatalf("detected %q for a rmdoc", ext)
+	}
+	files, id, err = PrepareData("doc", "parent", util.RMDOC, rmdoc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "abc" || len(files) != 3 {
+		t.Fatalf("unexpected document %s with files %v", id, files)
+	}
+	for _, f := range files {
+		if f.FileType != MetadataExt {
+			continue
+		}
+		if err := json.Unmarshal(f.Data, &meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.DocName != "doc" || meta.Parent != "parent" {
+			t.Errorf("metadata not fixed %+v", meta)
+		}
+	}
+
+	if _, _, err := PrepareData("doc", "", util.RMDOC, zipData(t, map[string]string{"../abc.content": "{}"}), nil); err == nil {
+		t.Error("expected an error for a path outside the document")
+	}
+}
+
+// zipData returns a zip archive of the files, stored in the order of their
+// names
+func zipData(t *testing.T, files map[string]string) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(files[name]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
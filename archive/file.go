@@ -1,6 +1,8 @@
 package archive
 
 import (
+	"time"
+
 	"github.com/juruen/rmapi/encoding/rm"
 )
 
@@ -102,15 +104,34 @@ type Content struct {
 	Orientation string `json:"orientation"`
 	PageCount   int    `json:"pageCount"`
 	// Pages is a list of page IDs
-	Pages          []string `json:"pages"`
-	Tags           []string `json:"pageTags"`
-	RedirectionMap []int    `json:"redirectionPageMap"`
-	TextScale      int      `json:"textScale"`
-	CoverPageNumber *int    `json:"coverPageNumber,omitempty"`
+	Pages           []string `json:"pages"`
+	Tags            []string `json:"pageTags"`
+	RedirectionMap  []int    `json:"redirectionPageMap"`
+	TextScale       int      `json:"textScale"`
+	CoverPageNumber *int     `json:"coverPageNumber,omitempty"`
+	// DocumentTags are the tags of the whole document
+	DocumentTags []Tag `json:"tags,omitempty"`
 
 	Transform Transform `json:"transform"`
 }
 
+// Tag is a document tag as stored in the .content file.
+type Tag struct {
+	Name string `json:"name"`
+	// Timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// NewTags creates document tags with the current timestamp.
+func NewTags(names []string) []Tag {
+	now := time.Now().UnixMilli()
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, Tag{Name: name, Timestamp: now})
+	}
+	return tags
+}
+
 // ExtraMetadata is a struct contained into a Content struct.
 type ExtraMetadata struct {
 	LastBrushColor           string `json:"LastBrushColor"`
@@ -243,7 +243,18 @@ func UnixTimestamp() string {
 func CreateMetadata(id, name, parent, colType, fpath string) (fileName string, filePath string, err error) {
 	fileName = id + "." + string(MetadataExt)
 	filePath = path.Join(fpath, fileName)
-	meta := MetadataFile{
+	c, err := json.Marshal(newMetadata(name, parent, colType))
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(filePath, c, 0600)
+	return
+}
+
+// newMetadata returns the metadata of a new entry
+func newMetadata(name, parent, colType string) MetadataFile {
+	return MetadataFile{
 		DocName:        name,
 		Version:        0,
 		CollectionType: colType,
@@ -251,12 +262,4 @@ func CreateMetadata(id, name, parent, colType, fpath string) (fileName string, f
 		Synced:         true,
 		LastModified:   UnixTimestamp(),
 	}
-
-	c, err := json.Marshal(meta)
-	if err != nil {
-		return
-	}
-
-	err = os.WriteFile(filePath, c, 0600)
-	return
 }
@@ -0,0 +1,177 @@
+// Package backup keeps a local archive of the library: the .rmdoc files of
+// the documents, their PDF exports, and a manifest describing the entries
+// they were downloaded from, so that the archive can be checked against the
+// cloud and restored from.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// ManifestName is the name of the manifest in the archive directory
+const ManifestName = "manifest.json"
+
+const manifestVersion = 1
+
+// ErrNoManifest is returned by Load for a directory without a manifest
+var ErrNoManifest = errors.New("no archive manifest")
+
+// File is a file of the archive
+type File struct {
+	// Path is relative to the archive directory, with slashes
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Entry is a folder or a document of the library, as it was in the cloud
+// when it was archived
+type Entry struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent,omitempty"`
+	Name   string `json:"name"`
+	// Path is the path of the entry in the library
+	Path string `json:"path"`
+	// Type is model.DirectoryType or model.DocumentType
+	Type     string `json:"type"`
+	Version  int    `json:"version"`
+	Modified string `json:"modified,omitempty"`
+	// Hash is the hash of the entry in the sync index, it changes with any
+	// of its files
+	Hash string `json:"hash,omitempty"`
+	// Files are the .rmdoc file of a document and its PDF export
+	Files []File `json:"files,omitempty"`
+}
+
+// NewEntry returns the entry of a node of the file tree at path
+func NewEntry(node *model.Node, path string) Entry {
+	doc := node.Document
+	return Entry{
+		ID:       doc.ID,
+		Parent:   doc.Parent,
+		Name:     doc.Name,
+		Path:     path,
+		Type:     doc.Type,
+		Version:  doc.Version,
+		Modified: doc.ModifiedClient,
+		Hash:     doc.Hash,
+	}
+}
+
+// IsDirectory tells whether the entry is a folder
+func (e *Entry) IsDirectory() bool {
+	return e.Type == model.DirectoryType
+}
+
+// File returns the file of the entry with an extension, without the dot,
+// nil if there is none
+func (e *Entry) File(ext string) *File {
+	for i := range e.Files {
+		if path.Ext(e.Files[i].Path) == "."+ext {
+			return &e.Files[i]
+		}
+	}
+	return nil
+}
+
+// Manifest lists the entries of an archive, folders before their content
+type Manifest struct {
+	Version int `json:"version"`
+	// Created is the time the archive was last written
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Load reads the manifest of the archive in dir, ErrNoManifest is returned
+// if there is none
+func Load(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNoManifest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("bad manifest %s: %v: %w", dir, err, model.ErrParse)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("manifest %s: unsupported version %d", dir, m.Version)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest to the archive in dir, the entries sorted by path
+func (m *Manifest) Save(dir string) error {
+	m.Version = manifestVersion
+	sort.SliceStable(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	return util.WriteFileAtomic(filepath.Join(dir, ManifestName), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
+}
+
+// Entry returns the entry of an ID, nil if there is none
+func (m *Manifest) Entry(id string) *Entry {
+	for i := range m.Entries {
+		if m.Entries[i].ID == id {
+			return &m.Entries[i]
+		}
+	}
+	return nil
+}
+
+// HashFile hashes a file of the archive in dir, path relative to it
+func HashFile(dir, path string) (File, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path)))
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: path, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Check tells whether the file is in the archive in dir with its size, and
+// with its content too if full is set
+func (f File) Check(dir string, full bool) error {
+	stat, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Path)))
+	if err != nil {
+		return err
+	}
+	if stat.Size() != f.Size {
+		return fmt.Errorf("%s: size %d instead of %d", f.Path, stat.Size(), f.Size)
+	}
+	if !full {
+		return nil
+	}
+	got, err := HashFile(dir, f.Path)
+	if err != nil {
+		return err
+	}
+	if got.SHA256 != f.SHA256 {
+		return fmt.Errorf("%s: content doesn't match its hash", f.Path)
+	}
+	return nil
+}
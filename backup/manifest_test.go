@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(dir); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("expected ErrNoManifest, got %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "Notes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path, content string) File {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(path)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := HashFile(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	m := &Manifest{Entries: []Entry{
+		{ID: "d1", Name: "Todo", Path: "/Notes/Todo", Type: model.DocumentType, Hash: "h1", Files: []File{write("Notes/Todo.rmdoc", "todo")}},
+		{ID: "f1", Name: "Notes", Path: "/Notes", Type: model.DirectoryType, Hash: "hf"},
+		{ID: "d2", Name: "Plan", Path: "/Plan", Type: model.DocumentType, Hash: "h2", Files: []File{write("Plan.rmdoc", "plan")}},
+		{ID: "d3", Name: "Old", Path: "/Old", Type: model.DocumentType, Hash: "h3", Files: []File{write("Old.rmdoc", "old")}},
+	}}
+	if err := m.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != 4 || loaded.Entries[0].Path != "/Notes" || loaded.Entry("d2").Files[0].SHA256 != m.Entry("d2").Files[0].SHA256 {
+		t.Fatalf("unexpected manifest %+v", loaded)
+	}
+	if f := loaded.Entry("d1").File("rmdoc"); f == nil || f.Path != "Notes/Todo.rmdoc" || loaded.Entry("d1").File("pdf") != nil {
+		t.Errorf("unexpected files %+v", loaded.Entry("d1").Files)
+	}
+
+	current := []Entry{
+		{ID: "f1", Name: "Notes", Path: "/Notes", Type: model.DirectoryType, Hash: "hf2"},
+		{ID: "d1", Name: "Todo", Path: "/Todo", Type: model.DocumentType, Hash: "h1"},
+		{ID: "d2", Name: "Plan", Path: "/Plan", Type: model.DocumentType, Hash: "h2b"},
+		{ID: "d4", Name: "New", Path: "/New", Type: model.DocumentType, Hash: "h4"},
+	}
+	diffs := func(full bool) string {
+		var got []string
+		for _, diff := range loaded.Verify(dir, current, full) {
+			got = append(got, fmt.Sprintf("%s %s", diff.Status, diff.Entry.Path))
+		}
+		return strings.Join(got, ", ")
+	}
+	if got, want := diffs(false), "new /New, deleted /Old, changed /Plan, moved /Todo"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// same size, other content
+	write("Plan.rmdoc", "PLAN")
+	if got, want := diffs(false), "new /New, deleted /Old, changed /Plan, moved /Todo"; got != want {
+		t.Errorf("size check: got %q, want %q", got, want)
+	}
+	if got, want := diffs(true), "new /New, deleted /Old, corrupt /Plan, changed /Plan, moved /Todo"; got != want {
+		t.Errorf("full check: got %q, want %q", got, want)
+	}
+	os.Remove(filepath.Join(dir, "Old.rmdoc"))
+	if got := diffs(false); !strings.Contains(got, "corrupt /Old") {
+		t.Errorf("missing file not reported: %q", got)
+	}
+}
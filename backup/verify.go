@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"sort"
+)
+
+// Status is how an entry of an archive differs from the cloud
+type Status string
+
+const (
+	// StatusNew is an entry of the cloud that isn't archived
+	StatusNew Status = "new"
+	// StatusChanged is a document modified since it was archived
+	StatusChanged Status = "changed"
+	// StatusMoved is an entry renamed or moved since it was archived
+	StatusMoved Status = "moved"
+	// StatusDeleted is an archived entry no longer in the cloud
+	StatusDeleted Status = "deleted"
+	// StatusCorrupt is an entry whose files are missing from the archive or
+	// don't match their hash
+	StatusCorrupt Status = "corrupt"
+)
+
+// Difference is an entry of the archive or of the cloud that doesn't match
+// the other
+type Difference struct {
+	Status Status
+	Entry  Entry
+	// Err is the problem of a corrupt entry
+	Err error
+}
+
+// Verify compares the archive in dir with the current entries of the
+// cloud, and checks that its files are there, with their content if full
+// is set. The differences are sorted by path.
+func (m *Manifest) Verify(dir string, current []Entry, full bool) []Difference {
+	var diffs []Difference
+	archived := make(map[string]*Entry, len(m.Entries))
+	for i := range m.Entries {
+		entry := &m.Entries[i]
+		archived[entry.ID] = entry
+		for _, f := range entry.Files {
+			if err := f.Check(dir, full); err != nil {
+				diffs = append(diffs, Difference{Status: StatusCorrupt, Entry: *entry, Err: err})
+				break
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(current))
+	for _, entry := range current {
+		seen[entry.ID] = true
+		old, ok := archived[entry.ID]
+		switch {
+		case !ok:
+			diffs = append(diffs, Difference{Status: StatusNew, Entry: entry})
+		case !entry.IsDirectory() && entry.Hash != old.Hash:
+			diffs = append(diffs, Difference{Status: StatusChanged, Entry: entry})
+		case entry.Path != old.Path:
+			diffs = append(diffs, Difference{Status: StatusMoved, Entry: entry})
+		}
+	}
+	for _, entry := range m.Entries {
+		if !seen[entry.ID] {
+			diffs = append(diffs, Difference{Status: StatusDeleted, Entry: entry})
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Entry.Path < diffs[j].Entry.Path })
+	return diffs
+}
@@ -0,0 +1,529 @@
+// Package client is a high level API to the reMarkable cloud, for Go
+// programs that want to browse, download, upload and convert documents
+// without going through the rmapi command line.
+//
+//	c, err := client.New(client.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	err = c.ConvertToPDF(ctx, "/Notes/journal", "journal.pdf", client.ConvertOptions{})
+//
+// Tokens are read from and saved to the rmapi config file (see
+// config.ConfigPath), so a program can share the authentication of the
+// command line tool. Encrypted tokens (rmapi encrypt) are read with the key
+// of the keychain or the passphrase of RMAPI_PASSPHRASE. Paths accept the
+// same syntax as the commands: absolute paths, name~N for duplicate names
+// and uuid:<id>.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
+)
+
+const authRetries = 2
+
+// Options configures a Client
+type Options struct {
+	// ConfigPath is the file holding the authentication tokens, the one
+	// of the command line tool (config.ConfigPath) by default
+	ConfigPath string
+	// Code is the one-time code from
+	// https://my.remarkable.com/device/browser/connect, only needed the
+	// first time to register the device
+	Code string
+	// HTTP tunes the timeouts, connection pool and proxy of the client,
+	// transport.DefaultOptions by default
+	HTTP *transport.Options
+	// SVGConverter is the external tool converting the uploaded svg files,
+	// see rmconvert.SVGConverterNames, the built-in converter by default
+	SVGConverter string
+}
+
+// ConvertOptions configures the conversions, zero values use the defaults
+// of mgeta
+type ConvertOptions = rmconvert.ExportOptions
+
+// Client is a connection to the reMarkable cloud with its file tree
+type Client struct {
+	api          api.ApiCtx
+	user         *api.UserInfo
+	svgConverter string
+	queue        *queue.Queue
+}
+
+// ErrQueued is returned by the operations queued because the cloud was
+// unreachable, see SetQueue
+var ErrQueued = errors.New("queued while the cloud is unreachable")
+
+// New authenticates and loads the file tree
+func New(opts Options) (*Client, error) {
+	configPath := opts.ConfigPath
+	if configPath == "" {
+		var err error
+		configPath, err = config.ConfigPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if vault.DefaultKey == nil {
+		settings, err := config.LoadEncryption(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if settings != nil {
+			if vault.DefaultKey, err = vault.Unlock(settings, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tokens := config.LoadTokens(configPath)
+	httpOpts := transport.DefaultOptions
+	if opts.HTTP != nil {
+		httpOpts = *opts.HTTP
+	}
+
+	var err error
+	for i := 0; i < authRetries; i++ {
+		httpCtx, authErr := api.AuthenticateWith(httpOpts, tokens, opts.Code, i > 0)
+		if authErr != nil {
+			return nil, authErr
+		}
+		if httpCtx.Tokens != tokens {
+			tokens = httpCtx.Tokens
+			if err := config.SaveTokens(configPath, tokens); err != nil {
+				return nil, err
+			}
+		}
+		httpCtx.Refresher.OnRefresh = func(tokens model.AuthTokens) {
+			// the renewed user token is only lost, it is renewed again
+			// by the next client
+			if err := config.SaveTokens(configPath, tokens); err != nil {
+				log.Error.Println(err)
+			}
+		}
+
+		var user *api.UserInfo
+		user, err = api.ParseToken(tokens.UserToken)
+		if err != nil {
+			continue
+		}
+
+		var apiCtx api.ApiCtx
+		apiCtx, err = api.CreateApiCtx(httpCtx, user.SyncVersion)
+		if err != nil {
+			continue
+		}
+
+		return &Client{api: apiCtx, user: user, svgConverter: opts.SVGConverter}, nil
+	}
+
+	return nil, fmt.Errorf("failed to build documents tree: %v", err)
+}
+
+// NewFromApi wraps an already authenticated api.ApiCtx
+func NewFromApi(apiCtx api.ApiCtx, user *api.UserInfo) *Client {
+	return &Client{api: apiCtx, user: user}
+}
+
+// SetSVGConverter sets the external tool converting the uploaded svg
+// files, see Options.SVGConverter
+func (c *Client) SetSVGConverter(tool string) {
+	c.svgConverter = tool
+}
+
+// SetQueue sets the queue keeping the batches (Batch, MoveAll and
+// DeleteAll) that fail because the cloud is unreachable, they then return
+// an error wrapping ErrQueued. The queue of rmapi, sent by rmapi queue
+// flush, is in queue.DefaultDir.
+func (c *Client) SetQueue(q *queue.Queue) {
+	c.queue = q
+}
+
+// User returns the email of the account
+func (c *Client) User() string {
+	return c.user.User
+}
+
+// Api returns the underlying api.ApiCtx, for operations not covered by Client
+func (c *Client) Api() api.ApiCtx {
+	return c.api
+}
+
+// Tree returns the file tree
+func (c *Client) Tree() *filetree.FileTreeCtx {
+	return c.api.Filetree()
+}
+
+// Refresh updates the file tree with the changes made since it was loaded,
+// full discards the cache and rebuilds the whole tree
+func (c *Client) Refresh(full bool) error {
+	refresh := c.api.Refresh
+	if full {
+		refresh = c.api.RefreshFull
+	}
+	_, _, err := refresh()
+	return err
+}
+
+// Stat returns the node at path
+func (c *Client) Stat(path string) (*model.Node, error) {
+	return c.Tree().NodeByPath(path, nil)
+}
+
+// List returns the entries of the directory at path, sorted by name
+func (c *Client) List(path string) ([]*model.Node, error) {
+	node, err := c.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	var nodes []*model.Node
+	err = filetree.Walk(node, filetree.WalkOptions{MaxDepth: 1}, func(n *model.Node, _ []string) error {
+		if n != node {
+			nodes = append(nodes, n)
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+// Walk visits the node at path and its descendants, see filetree.Walk
+func (c *Client) Walk(path string, opts filetree.WalkOptions, fn filetree.WalkFunc) error {
+	node, err := c.Stat(path)
+	if err != nil {
+		return err
+	}
+	return filetree.Walk(node, opts, fn)
+}
+
+// Download saves the document at path as a .rmdoc file
+func (c *Client) Download(goCtx context.Context, path, dstPath string) error {
+	node, err := c.document(path)
+	if err != nil {
+		return err
+	}
+	return c.api.FetchDocument(goCtx, node.Id(), dstPath)
+}
+
+// DownloadReader returns the document at path as a .rmdoc stream and its
+// size, -1 if unknown, without writing it to a file. The stream must be
+// closed.
+func (c *Client) DownloadReader(goCtx context.Context, path string) (io.ReadCloser, int64, error) {
+	node, err := c.document(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.api.FetchDocumentReader(goCtx, node.Id())
+}
+
+// ConvertToPDF downloads the document at path and converts it to a PDF
+func (c *Client) ConvertToPDF(goCtx context.Context, path, pdfPath string, opts ConvertOptions) error {
+	_, err := c.Export(goCtx, path, pdfPath, "pdf", opts)
+	return err
+}
+
+// Export downloads the document at path and converts it to outPath with
+// the exporter of format, see rmconvert.Exporters for the available ones
+func (c *Client) Export(goCtx context.Context, path, outPath, format string, opts ConvertOptions) (*rmconvert.ExportResult, error) {
+	exporter, err := rmconvert.LookupExporter(format)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "rmclient")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rmdocPath := filepath.Join(tmpDir, "document."+util.RMDOC)
+	if err := c.Download(goCtx, path, rmdocPath); err != nil {
+		return nil, err
+	}
+
+	return exporter.Export(goCtx, rmdocPath, outPath, opts)
+}
+
+// Upload uploads a local pdf, epub, rmdoc, image or svg file into the
+// directory at dirPath. Images and svg files are converted to a PDF page.
+func (c *Client) Upload(goCtx context.Context, srcPath, dirPath string, opts *model.UploadOptions) (*model.Document, error) {
+	dir, err := c.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.UploadInto(goCtx, srcPath, dir, opts)
+}
+
+// UploadInto is like Upload into the directory dir of the tree
+func (c *Client) UploadInto(goCtx context.Context, srcPath string, dir *model.Node, opts *model.UploadOptions) (*model.Document, error) {
+	if dir.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", dir.Name())
+	}
+	name := UploadName(srcPath, opts)
+	if _, err := dir.FindByName(name); err == nil {
+		return nil, fmt.Errorf("entry already exists (%s)", name)
+	}
+
+	uploadPath, cleanup, err := PrepareUpload(goCtx, srcPath, name, c.svgConverter)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	doc, err := c.api.UploadDocument(goCtx, dir.Id(), uploadPath, true, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.Tree().AddDocument(doc)
+	return doc, nil
+}
+
+// UploadName returns the name of the document uploaded from srcPath, the
+// name of opts or the local file name without its extension
+func UploadName(srcPath string, opts *model.UploadOptions) string {
+	if opts != nil && opts.Name != "" {
+		return opts.Name
+	}
+	name, _ := util.DocPathToName(srcPath)
+	return name
+}
+
+// PrepareUpload returns the file uploaded for srcPath. Images and svg files
+// are converted to a PDF of the document name in a temporary folder, with
+// svgConverter (see Options.SVGConverter), removed by cleanup; the other
+// files are uploaded as they are.
+func PrepareUpload(goCtx context.Context, srcPath, name, svgConverter string) (uploadPath string, cleanup func(), err error) {
+	_, ext := util.DocPathToName(srcPath)
+	if !util.IsImageType(ext) && ext != util.SVG {
+		return srcPath, func() {}, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "rmclient")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+
+	// keep the document name, only the extension changes
+	uploadPath = filepath.Join(tmpDir, name+"."+util.PDF)
+	if ext == util.SVG {
+		err = rmconvert.ConvertSVGToPDFExternal(goCtx, svgConverter, srcPath, uploadPath)
+	} else {
+		err = rmconvert.ConvertImagesToPDF([]string{srcPath}, uploadPath)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to convert %s: %v", srcPath, err)
+	}
+	return uploadPath, cleanup, nil
+}
+
+// UploadReader uploads a pdf, epub, rmdoc or rm document read from r into
+// the directory at dirPath with the given name, without writing it to a
+// file. Its format is detected from its content.
+func (c *Client) UploadReader(goCtx context.Context, r io.Reader, dirPath, name string, opts *model.UploadOptions) (*model.Document, error) {
+	dir, err := c.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dir.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", dirPath)
+	}
+
+	if opts != nil && opts.Name != "" {
+		name = opts.Name
+	}
+	if _, err := dir.FindByName(name); err == nil {
+		return nil, fmt.Errorf("entry already exists (%s)", name)
+	}
+
+	doc, err := c.api.UploadDocumentReader(goCtx, dir.Id(), name, r, true, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.Tree().AddDocument(doc)
+	return doc, nil
+}
+
+// Mkdir creates the directory at dirPath, its parent must exist
+func (c *Client) Mkdir(dirPath string) (*model.Node, error) {
+	dirPath = strings.TrimSuffix(dirPath, "/")
+	parentPath, name := path.Dir(dirPath), path.Base(dirPath)
+	if name == "" || name == "." || name == "/" {
+		return nil, errors.New("missing directory name")
+	}
+
+	parent, err := c.Stat(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	if parent.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", parentPath)
+	}
+
+	doc, err := c.api.CreateDir(parent.Id(), name, true)
+	if err != nil {
+		return nil, err
+	}
+	c.Tree().AddDocument(doc)
+	return c.Tree().NodeById(doc.ID), nil
+}
+
+// Move moves the entry at srcPath into the directory dstDirPath with the
+// given name (or its current one if empty)
+func (c *Client) Move(srcPath, dstDirPath, name string) (*model.Node, error) {
+	src, err := c.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	dstDir, err := c.Stat(dstDirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dstDir.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", dstDirPath)
+	}
+	if name == "" {
+		name = src.Name()
+	}
+
+	moved, err := c.api.MoveEntry(src, dstDir, name)
+	if err != nil {
+		return nil, err
+	}
+	c.Tree().MoveNode(src, moved)
+	return src, nil
+}
+
+// Copy duplicates the document at srcPath into the directory dstDirPath
+// with the given name (or its current one if empty). The copy shares the
+// files of the document in the cloud, only its metadata is uploaded.
+func (c *Client) Copy(srcPath, dstDirPath, name string) (*model.Document, error) {
+	src, err := c.document(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	dstDir, err := c.Stat(dstDirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dstDir.IsFile() {
+		return nil, fmt.Errorf("%s is not a directory", dstDirPath)
+	}
+	if name == "" {
+		name = src.Name()
+	}
+	if _, err := dstDir.FindByName(name); err == nil {
+		return nil, fmt.Errorf("entry already exists (%s)", name)
+	}
+
+	doc, err := c.api.CopyDocument(src.Id(), dstDir.Id(), name, true)
+	if err != nil {
+		return nil, err
+	}
+	c.Tree().AddDocument(doc)
+	return doc, nil
+}
+
+// Delete deletes the entry at path, directories must be empty unless
+// recursive is set
+func (c *Client) Delete(path string, recursive bool) error {
+	node, err := c.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := c.api.DeleteEntry(node, recursive, true); err != nil {
+		return err
+	}
+	c.Tree().DeleteNode(node)
+	return nil
+}
+
+// Batch applies moves, renames and deletions by ID in a single sync, so
+// that reorganizing many entries takes one round trip and either every
+// operation is applied or none
+func (c *Client) Batch(ops []model.BatchOp) error {
+	err := c.api.Batch(ops, true)
+	if err == nil || c.queue == nil || !transport.IsUnreachable(err) {
+		return err
+	}
+	op, qerr := c.queue.AddChange(queue.Op{Kind: queue.Batch, Batch: ops})
+	if qerr != nil {
+		return fmt.Errorf("%w, and failed to queue the batch: %v", err, qerr)
+	}
+	return fmt.Errorf("batch %w as operation %d: %v", ErrQueued, op.ID, err)
+}
+
+// MoveAll moves the entries at srcPaths into the directory dstDirPath in a
+// single sync
+func (c *Client) MoveAll(srcPaths []string, dstDirPath string) error {
+	dstDir, err := c.Stat(dstDirPath)
+	if err != nil {
+		return err
+	}
+	if dstDir.IsFile() {
+		return fmt.Errorf("%s is not a directory", dstDirPath)
+	}
+
+	ops := make([]model.BatchOp, 0, len(srcPaths))
+	for _, path := range srcPaths {
+		src, err := c.Stat(path)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, model.BatchOp{Kind: model.BatchMove, ID: src.Id(), Parent: dstDir.Id()})
+	}
+	return c.Batch(ops)
+}
+
+// DeleteAll deletes the entries at paths in a single sync, directories
+// must be empty unless recursive is set
+func (c *Client) DeleteAll(paths []string, recursive bool) error {
+	ops := make([]model.BatchOp, 0, len(paths))
+	for _, path := range paths {
+		node, err := c.Stat(path)
+		if err != nil {
+			return err
+		}
+		if node.IsDirectory() && len(node.Children) > 0 && !recursive {
+			return fmt.Errorf("%s: directory is not empty", path)
+		}
+		ops = append(ops, model.BatchOp{Kind: model.BatchDelete, ID: node.Id()})
+	}
+	return c.Batch(ops)
+}
+
+// document returns the node at path, which must be a document
+func (c *Client) document(path string) (*model.Node, error) {
+	node, err := c.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDirectory() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	return node, nil
+}
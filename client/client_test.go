@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeApi is an api.ApiCtx over a file tree in memory, the methods the
+// tests don't override panic
+type fakeApi struct {
+	api.ApiCtx
+	tree    *filetree.FileTreeCtx
+	batches [][]model.BatchOp
+	// batchErr is returned by Batch, which records nothing then
+	batchErr error
+	// uploaded are the names of the files uploaded, by document name
+	uploaded map[string]string
+}
+
+func (f *fakeApi) Filetree() *filetree.FileTreeCtx {
+	return f.tree
+}
+
+func (f *fakeApi) Batch(ops []model.BatchOp, notify bool) error {
+	if f.batchErr != nil {
+		return f.batchErr
+	}
+	f.batches = append(f.batches, ops)
+	return nil
+}
+
+func (f *fakeApi) CreateDir(parentId, name string, notify bool) (*model.Document, error) {
+	return &model.Document{ID: "dir-" + name, Parent: parentId, Name: name, Type: model.DirectoryType}, nil
+}
+
+func (f *fakeApi) UploadDocument(goCtx context.Context, parentId string, sourceDocPath string, notify bool, opts *model.UploadOptions) (*model.Document, error) {
+	if _, err := os.Stat(sourceDocPath); err != nil {
+		return nil, err
+	}
+	name, _ := util.DocPathToName(sourceDocPath)
+	if opts.Name != "" {
+		name = opts.Name
+	}
+	f.uploaded[name] = filepath.Base(sourceDocPath)
+	return &model.Document{ID: "doc-" + name, Parent: parentId, Name: name, Type: model.DocumentType}, nil
+}
+
+func newFakeClient() (*Client, *fakeApi) {
+	tree := filetree.CreateFileTreeCtx()
+	for _, doc := range []model.Document{
+		{ID: "work", Name: "Work", Type: model.DirectoryType},
+		{ID: "plan", Name: "Plan", Type: model.DocumentType, Parent: "work"},
+		{ID: "archive", Name: "Archive", Type: model.DirectoryType},
+		{ID: "empty", Name: "Empty", Type: model.DirectoryType},
+		{ID: "notes", Name: "Notes", Type: model.DocumentType},
+	} {
+		tree.AddDocument(&doc)
+	}
+	tree.FinishAdd()
+	fake := &fakeApi{tree: &tree, uploaded: map[string]string{}}
+	return &Client{api: fake}, fake
+}
+
+func TestMoveAll(t *testing.T) {
+	c, fake := newFakeClient()
+
+	assert.NoError(t, c.MoveAll([]string{"/Notes", "/Work/Plan"}, "/Archive"))
+	assert.Equal(t, [][]model.BatchOp{{
+		{Kind: model.BatchMove, ID: "notes", Parent: "archive"},
+		{Kind: model.BatchMove, ID: "plan", Parent: "archive"},
+	}}, fake.batches)
+
+	assert.Error(t, c.MoveAll([]string{"/Notes"}, "/Work/Plan"))
+	assert.Error(t, c.MoveAll([]string{"/Missing"}, "/Archive"))
+	assert.Len(t, fake.batches, 1)
+}
+
+func TestDeleteAll(t *testing.T) {
+	c, fake := newFakeClient()
+
+	assert.ErrorContains(t, c.DeleteAll([]string{"/Notes", "/Work"}, false), "not empty")
+	assert.Empty(t, fake.batches)
+
+	assert.NoError(t, c.DeleteAll([]string{"/Notes", "/Empty"}, false))
+	assert.NoError(t, c.DeleteAll([]string{"/Work"}, true))
+	assert.Equal(t, [][]model.BatchOp{
+		{{Kind: model.BatchDelete, ID: "notes"}, {Kind: model.BatchDelete, ID: "empty"}},
+		{{Kind: model.BatchDelete, ID: "work"}},
+	}, fake.batches)
+}
+
+func TestBatchQueued(t *testing.T) {
+	c, fake := newFakeClient()
+	fake.batchErr = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	ops := []model.BatchOp{{Kind: model.BatchDelete, ID: "notes"}}
+
+	// without a queue the error is returned as is
+	err := c.Batch(ops)
+	assert.ErrorIs(t, err, fake.batchErr)
+	assert.NotErrorIs(t, err, ErrQueued)
+
+	q, err := queue.Open(t.TempDir())
+	assert.NoError(t, err)
+	c.SetQueue(q)
+	assert.ErrorIs(t, c.DeleteAll([]string{"/Notes"}, false), ErrQueued)
+	if assert.Len(t, q.Ops, 1) {
+		assert.Equal(t, queue.Batch, q.Ops[0].Kind)
+		assert.Equal(t, ops, q.Ops[0].Batch)
+	}
+
+	// the errors of the cloud are not queued
+	fake.batchErr = errors.New("conflict")
+	assert.NotErrorIs(t, c.Batch(ops), ErrQueued)
+	assert.Len(t, q.Ops, 1)
+}
+
+func TestStat(t *testing.T) {
+	c, _ := newFakeClient()
+
+	for _, tc := range []struct {
+		path string
+		id   string
+	}{
+		{"/", ""},
+		{"/Work", "work"},
+		{"/Work/Plan", "plan"},
+		{"Work/Plan", "plan"},
+		{"/Work/../Notes", "notes"},
+	} {
+		node, err := c.Stat(tc.path)
+		if assert.NoError(t, err, tc.path) {
+			assert.Equal(t, tc.id, node.Id(), tc.path)
+		}
+	}
+	_, err := c.Stat("/Work/Missing")
+	assert.ErrorIs(t, err, model.ErrNotFound)
+}
+
+func TestMkdir(t *testing.T) {
+	c, _ := newFakeClient()
+
+	node, err := c.Mkdir("/Work/Projects/")
+	assert.NoError(t, err)
+	assert.Equal(t, "dir-Projects", node.Id())
+	assert.Equal(t, "work", node.Parent.Id())
+	assert.Same(t, node, mustStat(t, c, "/Work/Projects"))
+
+	node, err = c.Mkdir("Inbox")
+	assert.NoError(t, err)
+	assert.True(t, node.Parent.IsRoot())
+
+	for _, path := range []string{"/", "", "/Missing/Projects", "/Notes/Projects"} {
+		_, err := c.Mkdir(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	c, fake := newFakeClient()
+	dir := t.TempDir()
+
+	pdf := filepath.Join(dir, "paper.pdf")
+	assert.NoError(t, os.WriteFile(pdf, []byte("%PDF-1.4"), 0644))
+	doc, err := c.Upload(context.Background(), pdf, "/Work", &model.UploadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "paper", doc.Name)
+	assert.Equal(t, "paper.pdf", fake.uploaded["paper"])
+	assert.Equal(t, "work", mustStat(t, c, "/Work/paper").Parent.Id())
+
+	// images are converted to a PDF of the document name
+	f, err := os.Create(filepath.Join(dir, "scan.png"))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, image.NewGray(image.Rect(0, 0, 10, 10))))
+	f.Close()
+	_, err = c.Upload(context.Background(), f.Name(), "/", &model.UploadOptions{Name: "Receipt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Receipt.pdf", fake.uploaded["Receipt"])
+
+	_, err = c.Upload(context.Background(), pdf, "/Work", &model.UploadOptions{})
+	assert.ErrorContains(t, err, "already exists")
+	_, err = c.Upload(context.Background(), pdf, "/Notes", &model.UploadOptions{})
+	assert.ErrorContains(t, err, "not a directory")
+}
+
+func mustStat(t *testing.T, c *Client, path string) *model.Node {
+	node, err := c.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// LoadAliases reads the command aliases of the config file, the aliases key
+// maps a name to the command line it runs, the arguments given to the alias
+// are appended:
+//
+//	aliases:
+//	  backup: mgeta -i -o ~/remarkable /
+//	  inbox: ls /Inbox
+//
+// There are no aliases if the file doesn't exist.
+func LoadAliases(path string) (map[string]string, error) {
+	var settings struct {
+		Aliases map[string]string `yaml:"aliases"`
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return settings.Aliases, nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ToolCalibration adjusts how the strokes of a tool are drawn in the
+// exports, to match the appearance of the device
+type ToolCalibration struct {
+	// Width multiplies the width of the strokes, unchanged if 0
+	Width float32 `yaml:"width"`
+	// Opacity replaces the opacity of the tool, from 0 to 1, unchanged if
+	// not set
+	Opacity *float32 `yaml:"opacity"`
+	// Color replaces the color of the strokes, #rrggbb or black, gray or
+	// white, unchanged if empty
+	Color string `yaml:"color"`
+}
+
+// LoadCalibration reads the calibration of the drawing tools of the config
+// file, by tool name under the tools key:
+//
+//	tools:
+//	  pencil:
+//	    width: 1.3
+//	    opacity: 0.6
+//	  highlighter:
+//	    color: "#ffe066"
+//	    opacity: 0.35
+//
+// There is no calibration if the file doesn't exist.
+func LoadCalibration(path string) (map[string]ToolCalibration, error) {
+	var settings struct {
+		Tools map[string]ToolCalibration `yaml:"tools"`
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return settings.Tools, nil
+}
@@ -2,11 +2,13 @@ package config
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/juruen/rmapi/log"
 	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
 	"gopkg.in/yaml.v2"
 )
 
@@ -77,19 +79,62 @@ func LoadTokens(path string) model.AuthTokens {
 		log.Error.Fatalln("failed to parse", path)
 	}
 
+	// see 'rmapi encrypt'
+	if tokens.DeviceToken, err = openToken(tokens.DeviceToken); err != nil {
+		log.Error.Fatalln("failed to decrypt the device token:", err)
+	}
+	if tokens.UserToken, err = openToken(tokens.UserToken); err != nil {
+		log.Error.Fatalln("failed to decrypt the user token:", err)
+	}
+
 	return tokens
 }
 
-func SaveTokens(path string, tokens model.AuthTokens) {
-	content, err := yaml.Marshal(tokens)
-
+// SaveTokens writes the tokens to the config file, keeping its other
+// settings. They are encrypted when vault.DefaultKey is set, nothing is
+// written if they can't be.
+func SaveTokens(path string, tokens model.AuthTokens) error {
+	deviceToken, err := sealToken(tokens.DeviceToken)
 	if err != nil {
-		log.Warning.Println("failed to marsha tokens", err)
+		return fmt.Errorf("failed to encrypt the tokens: %v", err)
+	}
+	userToken, err := sealToken(tokens.UserToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt the tokens: %v", err)
 	}
 
-	os.WriteFile(path, content, 0600)
+	var settings yaml.MapSlice
+	if content, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(content, &settings); err != nil {
+			log.Warning.Printf("failed to parse %s, its settings are lost: %v", path, err)
+			settings = nil
+		}
+	}
+	settings = setKey(settings, "devicetoken", deviceToken)
+	settings = setKey(settings, "usertoken", userToken)
 
+	content, err := yaml.Marshal(settings)
 	if err != nil {
-		log.Warning.Println("failed to save config to", path)
+		return fmt.Errorf("failed to marshal the tokens: %v", err)
+	}
+
+	err = util.WriteFileAtomicPerm(path, 0600, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save the tokens to %s: %v", path, err)
+	}
+	return nil
+}
+
+// setKey sets the value of a top level key, appending it if missing
+func setKey(settings yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range settings {
+		if settings[i].Key == key {
+			settings[i].Value = value
+			return settings
+		}
 	}
+	return append(settings, yaml.MapItem{Key: key, Value: value})
 }
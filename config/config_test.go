@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,7 +30,7 @@ func TestSaveLoadConfig(t *testing.T) {
 
 	defer os.Remove(path)
 
-	SaveTokens(path, tokens)
+	assert.NoError(t, SaveTokens(path, tokens))
 
 	savedTokens := LoadTokens(path)
 
@@ -36,6 +38,32 @@ func TestSaveLoadConfig(t *testing.T) {
 	assert.Equal(t, "bar", savedTokens.UserToken)
 }
 
+func TestSaveTokensKeepsSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rmapi.conf")
+	content := "devicetoken: old\nexport:\n  dir:\n    path: /notes\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, SaveTokens(path, model.AuthTokens{DeviceToken: "foo", UserToken: "bar"}))
+
+	tokens := LoadTokens(path)
+	assert.Equal(t, "foo", tokens.DeviceToken)
+	assert.Equal(t, "bar", tokens.UserToken)
+
+	export, err := LoadExportConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "/notes", export.Dir.Path)
+}
+
+func TestSaveTokensError(t *testing.T) {
+	// a folder in the way of the config file
+	path := t.TempDir()
+	assert.Error(t, SaveTokens(path, model.AuthTokens{DeviceToken: "foo", UserToken: "bar"}))
+	partials, _ := filepath.Glob(path + ".*" + util.PartialSuffix)
+	assert.Empty(t, partials)
+}
+
 func TestConfigPath(t *testing.T) {
 	// let's not mess with the user's home dir
 	home := "HOME"
@@ -114,3 +142,76 @@ func TestConfigPath(t *testing.T) {
 		wg.Wait()
 	}
 }
+
+func TestLoadAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rmapi.conf")
+	content := "devicetoken: foo\naliases:\n  backup: mgeta -i -o backup /\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := LoadAliases(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"backup": "mgeta -i -o backup /"}, aliases)
+
+	aliases, err = LoadAliases(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.NoError(t, err)
+	assert.Empty(t, aliases)
+}
+
+func TestLoadCalibration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rmapi.conf")
+	content := "devicetoken: foo\ntools:\n  pencil:\n    width: 1.5\n    opacity: 0\n  highlighter:\n    color: \"#ffe066\"\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tools, err := LoadCalibration(path)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1.5), tools["pencil"].Width)
+	// an opacity of 0 is set, unlike a missing one
+	if assert.NotNil(t, tools["pencil"].Opacity) {
+		assert.Equal(t, float32(0), *tools["pencil"].Opacity)
+	}
+	assert.Nil(t, tools["highlighter"].Opacity)
+	assert.Equal(t, "#ffe066", tools["highlighter"].Color)
+
+	tools, err = LoadCalibration(filepath.Join(t.TempDir(), "missing.conf"))
+	assert.NoError(t, err)
+	assert.Empty(t, tools)
+}
+
+func TestEncryptedTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rmapi.conf")
+	content := "aliases:\n  inbox: ls /Inbox\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, key, err := vault.Setup(vault.ModePassphrase, "secret")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, SaveEncryption(path, settings))
+	vault.DefaultKey = key
+	defer func() { vault.DefaultKey = nil }()
+	assert.NoError(t, SaveTokens(path, model.AuthTokens{DeviceToken: "foo", UserToken: "bar"}))
+
+	b, _ := os.ReadFile(path)
+	assert.NotContains(t, string(b), "foo")
+	assert.Equal(t, model.AuthTokens{DeviceToken: "foo", UserToken: "bar"}, LoadTokens(path))
+
+	loaded, err := LoadEncryption(path)
+	assert.NoError(t, err)
+	assert.Equal(t, settings, loaded)
+	aliases, _ := LoadAliases(path)
+	assert.Equal(t, "ls /Inbox", aliases["inbox"])
+
+	// back to plain
+	assert.NoError(t, SaveEncryption(path, nil))
+	vault.DefaultKey = nil
+	assert.NoError(t, SaveTokens(path, model.AuthTokens{DeviceToken: "foo", UserToken: "bar"}))
+	b, _ = os.ReadFile(path)
+	assert.Contains(t, string(b), "devicetoken: foo")
+	assert.NotContains(t, string(b), "encryption")
+}
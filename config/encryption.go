@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
+	"gopkg.in/yaml.v2"
+)
+
+// sealedPrefix marks the token values encrypted with vault.DefaultKey
+const sealedPrefix = "sealed:"
+
+// LoadEncryption reads how the tokens and caches are encrypted, the
+// encryption key of the config file set by 'rmapi encrypt':
+//
+//	encryption:
+//	  mode: keychain
+//	  check: ...
+//
+// It returns nil when they are stored in plain.
+func LoadEncryption(path string) (*vault.Settings, error) {
+	var settings struct {
+		Encryption *vault.Settings `yaml:"encryption"`
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return settings.Encryption, nil
+}
+
+// SaveEncryption writes the encryption settings to the config file,
+// keeping its other settings; nil removes them
+func SaveEncryption(path string, encryption *vault.Settings) error {
+	var settings yaml.MapSlice
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if encryption != nil {
+		settings = setKey(settings, "encryption", encryption)
+	} else {
+		for i := range settings {
+			if settings[i].Key == "encryption" {
+				settings = append(settings[:i], settings[i+1:]...)
+				break
+			}
+		}
+	}
+
+	content, err = yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomicPerm(path, 0600, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// sealToken encrypts a token with vault.DefaultKey, if set
+func sealToken(token string) (string, error) {
+	if vault.DefaultKey == nil || token == "" {
+		return token, nil
+	}
+	sealed, err := vault.Seal(vault.DefaultKey, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openToken decrypts a token sealed by sealToken, plain tokens are
+// returned as they are
+func openToken(token string) (string, error) {
+	if !strings.HasPrefix(token, sealedPrefix) {
+		return token, nil
+	}
+	if vault.DefaultKey == nil {
+		return "", fmt.Errorf("the tokens are encrypted and the storage is locked")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed token: %v", err)
+	}
+	plain, err := vault.Open(vault.DefaultKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ExportConfig holds the settings of the export targets, under the export
+// key of the config file:
+//
+//	export:
+//	  readwise:
+//	    token: <access token>
+//	  notion:
+//	    token: <integration secret>
+//	    database: <database id>
+//	  dir:
+//	    path: ~/Obsidian/reMarkable
+//	  email:
+//	    host: smtp.example.com
+//	    port: 587
+//	    username: me@example.com
+//	    password: <password>
+//	    to: [me@example.com]
+//	  s3:
+//	    region: eu-west-1
+//	    access_key_id: <key id>
+//	    secret_access_key: <secret>
+//	  gdrive:
+//	    client_id: <oauth client id>
+//	    client_secret: <oauth client secret>
+//	    refresh_token: <refresh token>
+//	  dropbox:
+//	    app_key: <app key>
+//	    app_secret: <app secret>
+//	    refresh_token: <refresh token>
+//	  webhook:
+//	    url: https://example.com/hooks/rmapi
+//	    secret: <signing secret>
+//	  watermark:
+//	    text: "CONFIDENTIAL {date}"
+//	    style: "position:br, scalefactor:0.2 rel, rotation:0"
+type ExportConfig struct {
+	Readwise  ReadwiseConfig  `yaml:"readwise"`
+	Notion    NotionConfig    `yaml:"notion"`
+	Dir       DirConfig       `yaml:"dir"`
+	Email     EmailConfig     `yaml:"email"`
+	S3        S3Config        `yaml:"s3"`
+	Drive     OAuthConfig     `yaml:"gdrive"`
+	Dropbox   OAuthConfig     `yaml:"dropbox"`
+	Webhook   WebhookConfig   `yaml:"webhook"`
+	Watermark WatermarkConfig `yaml:"watermark"`
+}
+
+// ReadwiseConfig configures the readwise export target
+type ReadwiseConfig struct {
+	Token string `yaml:"token"`
+	// Category of the documents in Readwise, books by default
+	Category string `yaml:"category"`
+}
+
+// NotionConfig configures the notion export target, a page is created in
+// the database for each document
+type NotionConfig struct {
+	Token    string `yaml:"token"`
+	Database string `yaml:"database"`
+	// TitleProperty is the title property of the database, Name by default
+	TitleProperty string `yaml:"title_property"`
+}
+
+// DirConfig configures the dir export target, a folder of Markdown notes
+// such as an Obsidian vault
+type DirConfig struct {
+	Path string `yaml:"path"`
+}
+
+// EmailConfig configures the SMTP server used to email the exported files
+type EmailConfig struct {
+	Host string `yaml:"host"`
+	// Port is 587 (STARTTLS) by default, 465 uses implicit TLS
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address, Username by default
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+	// MaxSize is the maximum size of the attachments of a message in MB, 20
+	// by default
+	MaxSize int `yaml:"max_size"`
+}
+
+// S3Config configures the s3 sink, the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION environment
+// variables are used for the missing settings
+type S3Config struct {
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	// Endpoint is the URL of an S3 compatible service (e.g. MinIO), the
+	// buckets are then addressed by path
+	Endpoint string `yaml:"endpoint"`
+}
+
+// OAuthConfig holds the credentials of an OAuth service: an access token, or
+// a refresh token and the client (app) credentials to get access tokens
+type OAuthConfig struct {
+	AccessToken  string `yaml:"access_token"`
+	RefreshToken string `yaml:"refresh_token"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// AppKey and AppSecret are the names Dropbox uses for the client
+	// credentials
+	AppKey    string `yaml:"app_key"`
+	AppSecret string `yaml:"app_secret"`
+}
+
+// WebhookConfig configures the webhook notified of the changes found by
+// mgeta
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Secret signs the payloads (X-Rmapi-Signature header), optional
+	Secret string `yaml:"secret"`
+}
+
+// WatermarkConfig is the watermark stamped on the PDFs exported by mgeta
+// when none is given with its flags
+type WatermarkConfig struct {
+	Text string `yaml:"text"`
+	// Image is the path of an image or PDF stamped instead of the text
+	Image string `yaml:"image"`
+	// Style is the description of the stamp in pdfcpu's syntax
+	Style string `yaml:"style"`
+}
+
+// LoadExportConfig reads the export settings of the config file, they are
+// empty if the file doesn't exist
+func LoadExportConfig(path string) (ExportConfig, error) {
+	var settings struct {
+		Export ExportConfig `yaml:"export"`
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings.Export, nil
+	}
+	if err != nil {
+		return settings.Export, err
+	}
+
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return settings.Export, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return settings.Export, nil
+}
@@ -0,0 +1,103 @@
+// Package device reaches the tablet over SSH with the ssh executable of the
+// system, on the USB network (root@10.11.99.1) or over WiFi. The SSH
+// password is shown in Settings > Help > Copyrights and licenses of the
+// tablet; keys set up with ssh-copy-id avoid typing it.
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultHost is the address of the tablet connected by USB
+const DefaultHost = "root@10.11.99.1"
+
+// Device is a tablet reached over SSH
+type Device struct {
+	// Host is the user and address of the tablet, DefaultHost if empty
+	Host string
+	// SSHPath is the ssh executable, ssh if empty
+	SSHPath string
+	// Options are extra options of ssh, e.g. -i and a key file
+	Options []string
+}
+
+// command returns the ssh command running script on the tablet
+func (d *Device) command(goCtx context.Context, script string) *exec.Cmd {
+	sshPath, host := d.SSHPath, d.Host
+	if sshPath == "" {
+		sshPath = "ssh"
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+	args := append([]string{}, d.Options...)
+	// no prompt for unknown host keys in scripts, the tablet gets a new
+	// key on each update
+	args = append(args, "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=accept-new", host, script)
+	return exec.CommandContext(goCtx, sshPath, args...)
+}
+
+// Run runs a shell script on the tablet with stdin and stdout, either can
+// be nil
+func (d *Device) Run(goCtx context.Context, script string, stdin io.Reader, stdout io.Writer) error {
+	cmd := d.command(goCtx, script)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %v: %s", d.hostName(), err, msg)
+		}
+		return fmt.Errorf("%s: %v", d.hostName(), err)
+	}
+	return nil
+}
+
+func (d *Device) hostName() string {
+	if d.Host == "" {
+		return DefaultHost
+	}
+	return d.Host
+}
+
+// ReadFile returns the content of a file of the tablet
+func (d *Device) ReadFile(goCtx context.Context, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.Run(goCtx, "cat "+quote(path), nil, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile replaces a file of the tablet with the content of r, once it
+// has been entirely written
+func (d *Device) WriteFile(goCtx context.Context, path string, r io.Reader) error {
+	tmp := quote(path + ".rmapi")
+	return d.Run(goCtx, fmt.Sprintf("cat > %s && mv %s %s", tmp, tmp, quote(path)), r, nil)
+}
+
+// quote quotes a word for the shell of the tablet
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// LibraryDir returns the local folder of the templates, RMAPI_TEMPLATES or
+// rmapi/templates in the user config dir
+func LibraryDir() (string, error) {
+	if dir := os.Getenv("RMAPI_TEMPLATES"); dir != "" {
+		return dir, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "rmapi", "templates"), nil
+}
@@ -0,0 +1,195 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// PixelFormat is the layout of the pixels of a framebuffer
+type PixelFormat int
+
+const (
+	// Gray8 is a byte per pixel
+	Gray8 PixelFormat = iota
+	// RGB565 is a little endian 16 bit word per pixel
+	RGB565
+	// BGRA is four bytes per pixel
+	BGRA
+)
+
+func (f PixelFormat) bytes() int {
+	switch f {
+	case RGB565:
+		return 2
+	case BGRA:
+		return 4
+	}
+	return 1
+}
+
+// Framebuffer is the screen memory of a tablet model
+type Framebuffer struct {
+	// Width and Height are the visible pixels of a row and the rows
+	Width, Height int
+	// Stride is the bytes of a row, with padding
+	Stride int
+	Format PixelFormat
+	// Rotated is set when the rows are the columns of the portrait screen,
+	// from its bottom
+	Rotated bool
+	// script writes the memory to its output
+	script string
+}
+
+func (fb Framebuffer) size() int {
+	return fb.Stride * fb.Height
+}
+
+// Screen is the screen of a tablet, see Device.Screen
+type Screen struct {
+	dev *Device
+	fb  Framebuffer
+}
+
+// Screen returns the screen of the tablet, after finding its model. The
+// reMarkable 1 and 2 are supported.
+func (d *Device) Screen(goCtx context.Context) (*Screen, error) {
+	var buf bytes.Buffer
+	script := "cat /sys/devices/soc0/machine; cat /usr/share/remarkable/update.conf /etc/os-release 2>/dev/null; true"
+	if err := d.Run(goCtx, script, nil, &buf); err != nil {
+		return nil, err
+	}
+	model, version := parseDeviceInfo(buf.String())
+	fb, err := framebuffer(model, version)
+	if err != nil {
+		return nil, err
+	}
+	return &Screen{dev: d, fb: fb}, nil
+}
+
+// Framebuffer returns the framebuffer of the screen
+func (s *Screen) Framebuffer() Framebuffer {
+	return s.fb
+}
+
+// CaptureRaw returns the content of the framebuffer, to tell unchanged
+// frames apart before decoding them
+func (s *Screen) CaptureRaw(goCtx context.Context) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(s.fb.size())
+	if err := s.dev.Run(goCtx, s.fb.script, nil, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Capture returns the screen as displayed, in portrait unless landscape
+// is set
+func (s *Screen) Capture(goCtx context.Context, landscape bool) (*image.Gray, error) {
+	data, err := s.CaptureRaw(goCtx)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeFrame(s.fb, data, landscape)
+}
+
+// parseDeviceInfo reads the model of the tablet, the first line, and its
+// software version from update.conf or os-release
+func parseDeviceInfo(info string) (string, string) {
+	lines := strings.Split(info, "\n")
+	model := strings.TrimSpace(strings.TrimRight(lines[0], "\x00"))
+	version := ""
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || (key != "REMARKABLE_RELEASE_VERSION" && key != "IMG_VERSION") {
+			continue
+		}
+		if version = strings.Trim(value, `"`); version != "" {
+			break
+		}
+	}
+	return model, version
+}
+
+// versionAtLeast tells if a version like 3.5.2.1807 is major.minor or
+// later
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.Split(version, ".")
+	v := [2]int{}
+	for i := 0; i < len(v) && i < len(parts); i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false
+		}
+		v[i] = n
+	}
+	return v[0] > major || (v[0] == major && v[1] >= minor)
+}
+
+// framebuffer returns the framebuffer of a tablet model. The reMarkable 2
+// has no framebuffer device, its screen is in the memory of xochitl, in
+// the mapping following /dev/fb0.
+func framebuffer(model, version string) (Framebuffer, error) {
+	switch model {
+	case "reMarkable 1.0":
+		fb := Framebuffer{Width: 1404, Height: 1872, Stride: 1408 * 2, Format: RGB565}
+		fb.script = fmt.Sprintf("head -c %d /dev/fb0", fb.size())
+		return fb, nil
+	case "reMarkable 2.0":
+		fb := Framebuffer{Width: 1872, Height: 1404, Stride: 1872, Format: Gray8, Rotated: true}
+		if versionAtLeast(version, 3, 7) {
+			fb.Stride, fb.Format = 1872*4, BGRA
+		}
+		fb.script = fmt.Sprintf(`pid=$(pidof xochitl) || { echo "xochitl is not running" >&2; exit 1; }
+addr=$(grep -A1 /dev/fb0 /proc/$pid/maps | tail -n1 | sed 's/-.*//')
+skip=$((0x$addr + 8))
+dd if=/proc/$pid/mem bs=4096 skip=$((skip / 4096)) count=$((%d / 4096 + 2)) 2>/dev/null | tail -c +$((skip %% 4096 + 1)) | head -c %d`, fb.size(), fb.size())
+		return fb, nil
+	case "":
+		return Framebuffer{}, fmt.Errorf("unknown tablet model")
+	}
+	return Framebuffer{}, fmt.Errorf("screenshots of the %s are not supported", model)
+}
+
+// DecodeFrame returns the image of the content of a framebuffer, turned to
+// portrait unless landscape is set
+func DecodeFrame(fb Framebuffer, data []byte, landscape bool) (*image.Gray, error) {
+	if len(data) < fb.size() {
+		return nil, fmt.Errorf("incomplete frame of %d bytes instead of %d", len(data), fb.size())
+	}
+	n := fb.Format.bytes()
+	gray := func(x, y int) uint8 {
+		p := data[y*fb.Stride+x*n:]
+		switch fb.Format {
+		case RGB565:
+			v := uint16(p[0]) | uint16(p[1])<<8
+			r, g, b := (v>>11)&0x1f, (v>>5)&0x3f, v&0x1f
+			return uint8((299*uint32(r)*255/31 + 587*uint32(g)*255/63 + 114*uint32(b)*255/31) / 1000)
+		case BGRA:
+			return uint8((114*uint32(p[0]) + 587*uint32(p[1]) + 299*uint32(p[2])) / 1000)
+		}
+		return p[0]
+	}
+
+	if !fb.Rotated || landscape {
+		img := image.NewGray(image.Rect(0, 0, fb.Width, fb.Height))
+		for y := 0; y < fb.Height; y++ {
+			for x := 0; x < fb.Width; x++ {
+				img.Pix[y*img.Stride+x] = gray(x, y)
+			}
+		}
+		return img, nil
+	}
+	// the first row is the left column of the screen, from the bottom
+	img := image.NewGray(image.Rect(0, 0, fb.Height, fb.Width))
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			img.Pix[(fb.Width-1-x)*img.Stride+y] = gray(x, y)
+		}
+	}
+	return img, nil
+}
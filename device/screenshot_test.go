@@ -0,0 +1,94 @@
+package device
+
+import (
+	"testing"
+)
+
+func TestParseDeviceInfo(t *testing.T) {
+	model, version := parseDeviceInfo("reMarkable 2.0\x00\nNAME=\"Codex\"\nIMG_VERSION=\"3.5.2.1807\"\n")
+	if model != "reMarkable 2.0" || version != "3.5.2.1807" {
+		t.Errorf("got %q %q", model, version)
+	}
+	model, version = parseDeviceInfo("reMarkable 1.0\n[General]\nREMARKABLE_RELEASE_VERSION=2.15.1.1189\n")
+	if model != "reMarkable 1.0" || version != "2.15.1.1189" {
+		t.Errorf("got %q %q", model, version)
+	}
+
+	for _, tt := range []struct {
+		version string
+		want    bool
+	}{
+		{"3.7.0.1930", true},
+		{"3.10.2", true},
+		{"4.0", true},
+		{"3.6.1.1894", false},
+		{"2.15", false},
+		{"", false},
+	} {
+		if got := versionAtLeast(tt.version, 3, 7); got != tt.want {
+			t.Errorf("versionAtLeast(%q) = %v", tt.version, got)
+		}
+	}
+
+	if fb, err := framebuffer("reMarkable 2.0", "3.8.2"); err != nil || fb.Format != BGRA || fb.size() != 1872*1404*4 {
+		t.Errorf("unexpected framebuffer %+v, %v", fb, err)
+	}
+	if _, err := framebuffer("reMarkable Ferrari", ""); err == nil {
+		t.Error("no error for an unsupported model")
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	// 2x3 pixels, rows padded to 6 bytes
+	fb := Framebuffer{Width: 2, Height: 3, Stride: 6, Format: RGB565}
+	data := []byte{
+		0xff, 0xff, 0x00, 0x00, 0, 0,
+		0x00, 0x00, 0xff, 0xff, 0, 0,
+		0xef, 0x7b, 0xff, 0xff, 0, 0,
+	}
+	img, err := DecodeFrame(fb, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+		t.Fatalf("unexpected size %v", b)
+	}
+	if img.GrayAt(0, 0).Y != 255 || img.GrayAt(1, 0).Y != 0 || img.GrayAt(0, 1).Y != 0 {
+		t.Errorf("unexpected pixels %v", img.Pix)
+	}
+	if v := img.GrayAt(0, 2).Y; v < 120 || v > 135 {
+		t.Errorf("mid gray decoded as %d", v)
+	}
+
+	if _, err := DecodeFrame(fb, data[:10], false); err == nil {
+		t.Error("no error for an incomplete frame")
+	}
+
+	// landscape rows of 3 pixels, the first one is the left column of the
+	// portrait screen from the bottom
+	fb = Framebuffer{Width: 3, Height: 2, Stride: 3, Format: Gray8, Rotated: true}
+	data = []byte{
+		1, 2, 3,
+		4, 5, 6,
+	}
+	img, err = DecodeFrame(fb, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		3, 6,
+		2, 5,
+		1, 4,
+	}
+	if string(img.Pix) != string(want) || img.Bounds().Dx() != 2 {
+		t.Errorf("got %v, want %v", img.Pix, want)
+	}
+	if img, _ = DecodeFrame(fb, data, true); string(img.Pix) != string(data) {
+		t.Errorf("landscape frame turned: %v", img.Pix)
+	}
+
+	fb = Framebuffer{Width: 1, Height: 1, Stride: 4, Format: BGRA}
+	if img, _ = DecodeFrame(fb, []byte{200, 200, 200, 255}, false); img.Pix[0] != 200 {
+		t.Errorf("BGRA gray decoded as %d", img.Pix[0])
+	}
+}
@@ -0,0 +1,212 @@
+package device
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// TemplatesDir is the folder of the templates on the tablet
+	TemplatesDir = "/usr/share/remarkable/templates"
+	// TemplatesIndex lists the templates offered by the tablet
+	TemplatesIndex = "templates.json"
+	// defaultIconCode is the icon of the blank template
+	defaultIconCode = "\ue9fe"
+)
+
+// Template is an entry of templates.json
+type Template struct {
+	Name string `json:"name"`
+	// Filename is the name of the template files without extension, the
+	// template of the pages in the documents
+	Filename   string   `json:"filename"`
+	IconCode   string   `json:"iconCode"`
+	Landscape  bool     `json:"landscape,omitempty"`
+	Categories []string `json:"categories"`
+}
+
+// TemplateIndex is the content of templates.json, its unknown fields kept
+type TemplateIndex struct {
+	fields    map[string]json.RawMessage
+	templates []json.RawMessage
+}
+
+// ParseTemplateIndex reads templates.json
+func ParseTemplateIndex(data []byte) (*TemplateIndex, error) {
+	idx := &TemplateIndex{}
+	if err := json.Unmarshal(data, &idx.fields); err != nil {
+		return nil, fmt.Errorf("can't parse %s: %v", TemplatesIndex, err)
+	}
+	if raw, ok := idx.fields["templates"]; ok {
+		if err := json.Unmarshal(raw, &idx.templates); err != nil {
+			return nil, fmt.Errorf("can't parse %s: %v", TemplatesIndex, err)
+		}
+	}
+	return idx, nil
+}
+
+// Templates returns the templates of the index
+func (idx *TemplateIndex) Templates() ([]Template, error) {
+	result := make([]Template, 0, len(idx.templates))
+	for _, raw := range idx.templates {
+		var t Template
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, fmt.Errorf("can't parse %s: %v", TemplatesIndex, err)
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// Add adds a template to the index, in place of the one with the same
+// file name
+func (idx *TemplateIndex) Add(t Template) error {
+	if t.IconCode == "" {
+		t.IconCode = defaultIconCode
+	}
+	if t.Categories == nil {
+		t.Categories = []string{}
+	}
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	templates, err := idx.Templates()
+	if err != nil {
+		return err
+	}
+	for i, existing := range templates {
+		if existing.Filename == t.Filename {
+			idx.templates[i] = raw
+			return nil
+		}
+	}
+	idx.templates = append(idx.templates, raw)
+	return nil
+}
+
+// Marshal returns the content of templates.json
+func (idx *TemplateIndex) Marshal() ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(idx.fields)+1)
+	for k, v := range idx.fields {
+		fields[k] = v
+	}
+	templates, err := json.Marshal(idx.templates)
+	if err != nil {
+		return nil, err
+	}
+	fields["templates"] = templates
+	return json.MarshalIndent(fields, "", "    ")
+}
+
+// TemplateIndex returns the templates.json of the tablet
+func (d *Device) TemplateIndex(goCtx context.Context) (*TemplateIndex, error) {
+	data, err := d.ReadFile(goCtx, path.Join(TemplatesDir, TemplatesIndex))
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplateIndex(data)
+}
+
+// DownloadTemplates copies the templates folder of the tablet to dir and
+// returns the names of the files written
+func (d *Device) DownloadTemplates(goCtx context.Context, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := d.Run(goCtx, "tar -C "+quote(TemplatesDir)+" -cf - .", nil, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	files, err := extractTemplates(pr, dir)
+	// unblock the tar of the tablet on error
+	pr.CloseWithError(errors.New("extraction stopped"))
+	if runErr := <-done; err == nil {
+		err = runErr
+	}
+	return files, err
+}
+
+// extractTemplates writes the regular files of the top folder of a tar
+// archive to dir
+func extractTemplates(r io.Reader, dir string) ([]string, error) {
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		// the templates are at the top of the folder, symlinks are left
+		// out
+		if header.Typeflag != tar.TypeReg || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return files, err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return files, err
+		}
+		files = append(files, name)
+	}
+}
+
+// UploadTemplate copies the template files, a .png image and optionally
+// its .svg, to the tablet and adds the template to its templates.json. The
+// tablet shows it once restarted, see Restart.
+func (d *Device) UploadTemplate(goCtx context.Context, files []string, t Template) error {
+	idx, err := d.TemplateIndex(goCtx)
+	if err != nil {
+		return err
+	}
+	if err := idx.Add(t); err != nil {
+		return err
+	}
+	index, err := idx.Marshal()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = d.WriteFile(goCtx, path.Join(TemplatesDir, t.Filename+filepath.Ext(file)), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return d.WriteFile(goCtx, path.Join(TemplatesDir, TemplatesIndex), bytes.NewReader(index))
+}
+
+// Restart restarts the interface of the tablet, which reads the templates
+// on startup
+func (d *Device) Restart(goCtx context.Context) error {
+	return d.Run(goCtx, "systemctl restart xochitl", nil, nil)
+}
@@ -0,0 +1,124 @@
+package device
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTemplateIndex(t *testing.T) {
+	idx, err := ParseTemplateIndex([]byte(`{"version": 2, "templates": [
+		{"name": "Blank", "filename": "Blank", "iconCode": "", "categories": ["Creative"], "custom": true},
+		{"name": "Lined", "filename": "P Lines medium", "iconCode": "", "categories": ["Lines"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Add(Template{Name: "Dots", Filename: "dots", Categories: []string{"Grids"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Add(Template{Name: "Lined", Filename: "P Lines medium", Landscape: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := idx.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err = ParseTemplateIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := idx.Templates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 3 || templates[2].Filename != "dots" || templates[2].IconCode != defaultIconCode {
+		t.Errorf("unexpected templates %+v", templates)
+	}
+	if !templates[1].Landscape || templates[1].Categories == nil {
+		t.Errorf("template not replaced %+v", templates[1])
+	}
+
+	var raw struct {
+		Version   int
+		Templates []map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw.Version != 2 || raw.Templates[0]["custom"] != true {
+		t.Errorf("unknown fields lost: %s", data)
+	}
+}
+
+func TestExtractTemplates(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		name     string
+		typeflag byte
+	}{
+		{"./", tar.TypeDir},
+		{"./templates.json", tar.TypeReg},
+		{"./dots.png", tar.TypeReg},
+		{"./Blank.png", tar.TypeSymlink},
+		{"./sub/other.png", tar.TypeReg},
+	} {
+		content := "content of " + f.name
+		header := &tar.Header{Name: f.name, Typeflag: f.typeflag, Mode: 0644}
+		if f.typeflag == tar.TypeReg {
+			header.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if f.typeflag == tar.TypeReg {
+			tw.Write([]byte(content))
+		}
+	}
+	tw.Close()
+
+	dir := t.TempDir()
+	files, err := extractTemplates(&buf, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != "templates.json" || files[1] != "dots.png" {
+		t.Errorf("unexpected files %v", files)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "dots.png"))
+	if err != nil || string(data) != "content of ./dots.png" {
+		t.Errorf("got %q, %v", data, err)
+	}
+}
+
+func TestDeviceReadFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh is a shell script")
+	}
+	dir := t.TempDir()
+	// runs the script locally, the last argument
+	ssh := filepath.Join(dir, "ssh")
+	if err := os.WriteFile(ssh, []byte("#!/bin/sh\nfor last; do :; done\nexec sh -c \"$last\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	d := &Device{SSHPath: ssh}
+
+	path := filepath.Join(dir, "it's here.json")
+	if err := d.WriteFile(context.Background(), path, bytes.NewReader([]byte("{}"))); err != nil {
+		t.Fatal(err)
+	}
+	data, err := d.ReadFile(context.Background(), path)
+	if err != nil || string(data) != "{}" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if _, err := d.ReadFile(context.Background(), filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -114,11 +114,18 @@ const (
 type Rm struct {
 	Version Version
 	Layers  []Layer
+	// Text is the typed text of the page (v6 only), nil if there is none
+	Text *Text
+	// Highlights are the highlights of the text of the document (v6 only)
+	Highlights []Highlight
 }
 
 // A Layer contains lines.
 type Layer struct {
-	Lines []Line
+	// Name and Hidden are only known for v6 files
+	Name   string
+	Hidden bool
+	Lines  []Line
 }
 
 // A Line is composed of points.
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/juruen/rmapi/model"
 )
 
 // UnmarshalBinary implements encoding.UnmarshalBinary for
@@ -77,7 +79,7 @@ func (r *reader) checkHeader() error {
 	}
 
 	if n != HeaderLen {
-		return fmt.Errorf("Wrong header size")
+		return fmt.Errorf("Wrong header size: %w", model.ErrParse)
 	}
 
 	switch string(buf) {
@@ -88,7 +90,7 @@ func (r *reader) checkHeader() error {
 	case HeaderV3:
 		r.version = V3
 	default:
-		return fmt.Errorf("Unknown header: %s", string(buf))
+		return fmt.Errorf("Unknown header: %s: %w", string(buf), model.ErrUnsupportedVersion)
 	}
 
 	return nil
@@ -97,7 +99,7 @@ func (r *reader) checkHeader() error {
 func (r *reader) readNumber() (uint32, error) {
 	var nb uint32
 	if err := binary.Read(r, binary.LittleEndian, &nb); err != nil {
-		return 0, fmt.Errorf("Wrong number read")
+		return 0, fmt.Errorf("Wrong number read: %w", model.ErrParse)
 	}
 	return nb, nil
 }
@@ -106,25 +108,25 @@ func (r *reader) readLine() (Line, error) {
 	var line Line
 
 	if err := binary.Read(r, binary.LittleEndian, &line.BrushType); err != nil {
-		return line, fmt.Errorf("Failed to read line")
+		return line, fmt.Errorf("Failed to read line: %w", model.ErrParse)
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &line.BrushColor); err != nil {
-		return line, fmt.Errorf("Failed to read line")
+		return line, fmt.Errorf("Failed to read line: %w", model.ErrParse)
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &line.Padding); err != nil {
-		return line, fmt.Errorf("Failed to read line")
+		return line, fmt.Errorf("Failed to read line: %w", model.ErrParse)
 	}
 
 	if err := binary.Read(r, binary.LittleEndian, &line.BrushSize); err != nil {
-		return line, fmt.Errorf("Failed to read line")
+		return line, fmt.Errorf("Failed to read line: %w", model.ErrParse)
 	}
 
 	// this new attribute has been added in v5 and is also in v6
 	if r.version == V5 || r.version == V6 {
 		if err := binary.Read(r, binary.LittleEndian, &line.Unknown); err != nil {
-			return line, fmt.Errorf("Failed to read line")
+			return line, fmt.Errorf("Failed to read line: %w", model.ErrParse)
 		}
 	}
 
@@ -155,22 +157,22 @@ func (r *reader) readPoint() (Point, error) {
 	var point Point
 
 	if err := binary.Read(r, binary.LittleEndian, &point.X); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 	if err := binary.Read(r, binary.LittleEndian, &point.Y); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 	if err := binary.Read(r, binary.LittleEndian, &point.Speed); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 	if err := binary.Read(r, binary.LittleEndian, &point.Direction); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 	if err := binary.Read(r, binary.LittleEndian, &point.Width); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 	if err := binary.Read(r, binary.LittleEndian, &point.Pressure); err != nil {
-		return point, fmt.Errorf("Failed to read point")
+		return point, fmt.Errorf("Failed to read point: %w", model.ErrParse)
 	}
 
 	return point, nil
@@ -1,9 +1,13 @@
 package rm
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/juruen/rmapi/model"
 )
 
 func testUnmarshalBinary(t *testing.T, fn string, ver Version) *Rm {
@@ -43,3 +47,40 @@ func TestUnmarshalBinaryV5(t *testing.T) {
 func TestUnmarshalBinaryV3(t *testing.T) {
 	testUnmarshalBinary(t, "test_v3.rm", V3)
 }
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	unknown := []byte(strings.Repeat("x", HeaderLen))
+	if err := New().UnmarshalBinary(unknown); !errors.Is(err, model.ErrUnsupportedVersion) {
+		t.Errorf("unknown header: got %v, want ErrUnsupportedVersion", err)
+	}
+
+	b, err := os.ReadFile("test_v5.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New().UnmarshalBinary(b[:HeaderLen+10]); !errors.Is(err, model.ErrParse) {
+		t.Errorf("truncated file: got %v, want ErrParse", err)
+	}
+
+	truncated := append([]byte(HeaderV6), 0xff)
+	if err := New().UnmarshalBinary(truncated); !errors.Is(err, model.ErrParse) {
+		t.Errorf("truncated v6 file: got %v, want ErrParse", err)
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	for _, fn := range []string{"test_v3.rm", "test_v5.rm"} {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fn, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := New().UnmarshalBinary(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+
+	"github.com/juruen/rmapi/model"
 )
 
 // V6 specific constants
@@ -12,12 +14,14 @@ const (
 	HEADER_V6 = "reMarkable .lines file, version=6          "
 
 	// Block types
-	BLOCK_MIGRATION_INFO   = 0x00
-	BLOCK_PAGE_INFO        = 0x02
-	BLOCK_TREE_NODE        = 0x04
-	BLOCK_SCENE_ITEM       = 0x05  // Lines, Groups, etc.
-	BLOCK_TEXT_ITEM        = 0x06  // Text
-	BLOCK_AUTHOR_IDS       = 0x09
+	BLOCK_MIGRATION_INFO = 0x00
+	BLOCK_SCENE_TREE     = 0x01 // Node of the scene tree and its parent
+	BLOCK_TREE_NODE      = 0x02 // Label and visibility of a node
+	BLOCK_SCENE_GROUP    = 0x04 // Group item, places a node in its parent
+	BLOCK_SCENE_ITEM     = 0x05 // Lines
+	BLOCK_TEXT_ITEM      = 0x06 // Text
+	BLOCK_AUTHOR_IDS     = 0x09
+	BLOCK_PAGE_INFO      = 0x0A
 
 	// Tag types (lower 4 bits of tag varint)
 	TAG_BYTE1   = 0x01
@@ -53,6 +57,8 @@ type V6Point struct {
 
 // V6Line represents a line in v6 format
 type V6Line struct {
+	// Parent is the node of the scene tree (layer or group) of the line
+	Parent         V6CrdtId
 	Color          int32
 	Tool           int32
 	Points         []V6Point
@@ -70,12 +76,12 @@ type V6CrdtId struct {
 func ParseV6(data []byte) (*Rm, error) {
 	// Skip header (43 bytes)
 	if len(data) < HeaderLen {
-		return nil, fmt.Errorf("file too small")
+		return nil, fmt.Errorf("file too small: %w", model.ErrParse)
 	}
 
 	header := string(data[:HeaderLen])
 	if header != HeaderV6 {
-		return nil, fmt.Errorf("not a v6 file")
+		return nil, fmt.Errorf("not a v6 file: %w", model.ErrUnsupportedVersion)
 	}
 
 	r := bytes.NewReader(data[HeaderLen:])
@@ -83,7 +89,7 @@ func ParseV6(data []byte) (*Rm, error) {
 	// Parse all blocks
 	blocks, err := parseV6Blocks(r)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%v: %w", err, model.ErrParse)
 	}
 
 	// Extract lines from blocks
@@ -92,15 +98,11 @@ func ParseV6(data []byte) (*Rm, error) {
 	// Convert to Rm format
 	rm := &Rm{
 		Version: V6,
-		Layers:  make([]Layer, 1),
+		Layers:  groupV6Lines(blocks, lines),
 	}
 
-	if len(lines) > 0 {
-		rm.Layers[0].Lines = make([]Line, len(lines))
-		for i, v6line := range lines {
-			rm.Layers[0].Lines[i] = convertV6Line(v6line)
-		}
-	}
+	rm.Text = extractTextFromV6Blocks(blocks)
+	rm.Highlights = extractHighlightsFromV6Blocks(blocks)
 
 	return rm, nil
 }
@@ -203,7 +205,8 @@ func parseSceneItemBlock(data []byte, blockVersion byte) (*V6Line, error) {
 	if _, err := expectTag(r, 1, TAG_ID); err != nil {
 		return nil, err
 	}
-	if _, err := readCrdtId(r); err != nil {
+	parentID, err := readCrdtId(r)
+	if err != nil {
 		return nil, err
 	}
 
@@ -252,7 +255,7 @@ func parseSceneItemBlock(data []byte, blockVersion byte) (*V6Line, error) {
 
 	// Read subblock tag and length
 	if _, err := expectTag(r, 6, TAG_LENGTH4); err != nil {
-		return nil, nil  // No value subblock, skip
+		return nil, nil // No value subblock, skip
 	}
 
 	var subblockLen uint32
@@ -276,6 +279,7 @@ func parseSceneItemBlock(data []byte, blockVersion byte) (*V6Line, error) {
 	if err != nil {
 		return nil, err
 	}
+	line.Parent = parentID
 
 	return line, nil
 }
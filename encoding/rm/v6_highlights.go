@@ -0,0 +1,155 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Glyph items are the highlights of the text of a PDF or EPUB page
+const (
+	BLOCK_GLYPH_ITEM = 0x03
+	ITEM_TYPE_GLYPH  = 0x01
+)
+
+// Rect is a rectangle in page coordinates
+type Rect struct {
+	X, Y, W, H float64
+}
+
+// Highlight is a highlighted range of the text of the underlying document
+type Highlight struct {
+	// Text is the highlighted text
+	Text string
+	// Color is the v6 color id of the highlighter
+	Color int32
+	// Start is the offset of the text in the page, -1 if unknown
+	Start  int
+	Length int
+	// Rects cover the highlighted text on the page
+	Rects []Rect
+}
+
+// extractHighlightsFromV6Blocks returns the highlights of the page in file
+// order, the ones that can't be parsed are skipped
+func extractHighlightsFromV6Blocks(blocks []V6Block) []Highlight {
+	var highlights []Highlight
+	for _, block := range blocks {
+		if block.BlockType != BLOCK_GLYPH_ITEM {
+			continue
+		}
+		h, err := parseGlyphItemBlock(block.Data)
+		if err == nil && h != nil {
+			highlights = append(highlights, *h)
+		}
+	}
+	return highlights
+}
+
+// parseGlyphItemBlock parses a glyph item, it has the same structure as
+// the line items (see parseSceneItemBlock) and its value is:
+//   - tagged int at index 2 (optional): start
+//   - tagged int at index 3: length
+//   - tagged int at index 4: color_id
+//   - tagged string at index 5: text
+//   - tagged subblock at index 6: rectangle count (varint), x, y, w, h (float64)
+func parseGlyphItemBlock(data []byte) (*Highlight, error) {
+	r := bytes.NewReader(data)
+
+	// parent_id, item_id, left_id and right_id
+	for i := 1; i <= 4; i++ {
+		if _, err := expectTag(r, i, TAG_ID); err != nil {
+			return nil, err
+		}
+		if _, err := readCrdtId(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := expectTag(r, 5, TAG_BYTE4); err != nil {
+		return nil, err
+	}
+	var deletedLength uint32
+	if err := binary.Read(r, binary.LittleEndian, &deletedLength); err != nil {
+		return nil, err
+	}
+	if deletedLength > 0 || r.Len() == 0 {
+		return nil, nil
+	}
+
+	value, err := readSubblock(r, 6)
+	if err != nil {
+		return nil, err
+	}
+	itemType, err := value.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if itemType != ITEM_TYPE_GLYPH {
+		return nil, fmt.Errorf("unexpected item type 0x%x", itemType)
+	}
+
+	h := &Highlight{Start: -1}
+
+	// start is missing in files written by some firmware versions
+	pos, _ := value.Seek(0, io.SeekCurrent)
+	if _, err := expectTag(value, 2, TAG_BYTE4); err == nil {
+		var start uint32
+		if err := binary.Read(value, binary.LittleEndian, &start); err != nil {
+			return nil, err
+		}
+		h.Start = int(start)
+	} else if _, err := value.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var length uint32
+	if _, err := expectTag(value, 3, TAG_BYTE4); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(value, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	h.Length = int(length)
+
+	if _, err := expectTag(value, 4, TAG_BYTE4); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(value, binary.LittleEndian, &h.Color); err != nil {
+		return nil, err
+	}
+
+	if h.Text, err = readString(value, 5); err != nil {
+		return nil, err
+	}
+	if h.Start < 0 {
+		h.Length = len(h.Text)
+	}
+
+	rects, err := readSubblock(value, 6)
+	if err != nil {
+		return nil, err
+	}
+	count, err := readVarint(rects)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(rects.Len()/32) {
+		return nil, fmt.Errorf("too many rectangles: %d", count)
+	}
+	for i := uint64(0); i < count; i++ {
+		var v [4]float64
+		for j := range v {
+			var bits uint64
+			if err := binary.Read(rects, binary.LittleEndian, &bits); err != nil {
+				return nil, err
+			}
+			v[j] = math.Float64frombits(bits)
+		}
+		h.Rects = append(h.Rects, Rect{X: v[0], Y: v[1], W: v[2], H: v[3]})
+	}
+
+	return h, nil
+}
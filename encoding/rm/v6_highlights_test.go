@@ -0,0 +1,63 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func glyphItemBlock(deleted uint32, start int, text string) V6Block {
+	var data, value, rects bytes.Buffer
+	for i := 1; i <= 4; i++ {
+		putID(&data, i, V6CrdtId{1, uint64(i)})
+	}
+	putVarint(&data, 5<<4|TAG_BYTE4)
+	binary.Write(&data, binary.LittleEndian, deleted)
+
+	value.WriteByte(ITEM_TYPE_GLYPH)
+	if start >= 0 {
+		putVarint(&value, 2<<4|TAG_BYTE4)
+		binary.Write(&value, binary.LittleEndian, uint32(start))
+	}
+	putVarint(&value, 3<<4|TAG_BYTE4)
+	binary.Write(&value, binary.LittleEndian, uint32(len(text)))
+	putVarint(&value, 4<<4|TAG_BYTE4)
+	binary.Write(&value, binary.LittleEndian, int32(4))
+	putString(&value, 5, text)
+
+	putVarint(&rects, 1)
+	for _, v := range []float64{10, 20, 300, 15} {
+		binary.Write(&rects, binary.LittleEndian, math.Float64bits(v))
+	}
+	putSubblock(&value, 6, rects.Bytes())
+
+	if deleted == 0 {
+		putSubblock(&data, 6, value.Bytes())
+	}
+	return V6Block{BlockType: BLOCK_GLYPH_ITEM, Data: data.Bytes()}
+}
+
+func TestExtractHighlights(t *testing.T) {
+	blocks := []V6Block{
+		glyphItemBlock(0, 120, "the first passage"),
+		glyphItemBlock(5, 0, ""),
+		glyphItemBlock(0, -1, "no start"),
+	}
+
+	highlights := extractHighlightsFromV6Blocks(blocks)
+	if len(highlights) != 2 {
+		t.Fatalf("expected 2 highlights, got %d", len(highlights))
+	}
+
+	h := highlights[0]
+	if h.Text != "the first passage" || h.Start != 120 || h.Length != 17 || h.Color != 4 {
+		t.Errorf("wrong highlight: %+v", h)
+	}
+	if len(h.Rects) != 1 || h.Rects[0] != (Rect{10, 20, 300, 15}) {
+		t.Errorf("wrong rectangles: %v", h.Rects)
+	}
+	if highlights[1].Start != -1 || highlights[1].Length != len("no start") {
+		t.Errorf("wrong highlight without start: %+v", highlights[1])
+	}
+}
@@ -0,0 +1,270 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// v6RootID is the node of the scene tree whose children are the layers
+var v6RootID = V6CrdtId{0, 1}
+
+// v6TreeNode is the label and visibility of a node of the scene tree
+type v6TreeNode struct {
+	Label   string
+	Visible bool
+}
+
+// groupV6Lines sorts the lines into the layers of the page. Layers are the
+// children of the root of the scene tree and lines may be in groups nested
+// in a layer. Without a scene tree every line is in a single layer.
+func groupV6Lines(blocks []V6Block, lines []V6Line) []Layer {
+	parents := make(map[V6CrdtId]V6CrdtId)
+	nodes := make(map[V6CrdtId]v6TreeNode)
+	var layerIDs []V6CrdtId
+	isLayer := make(map[V6CrdtId]bool)
+
+	addNode := func(id, parent V6CrdtId) {
+		parents[id] = parent
+		if parent == v6RootID && !isLayer[id] {
+			isLayer[id] = true
+			layerIDs = append(layerIDs, id)
+		}
+	}
+
+	for _, block := range blocks {
+		switch block.BlockType {
+		case BLOCK_SCENE_TREE:
+			if id, parent, err := parseSceneTreeBlock(block.Data); err == nil {
+				addNode(id, parent)
+			}
+		case BLOCK_SCENE_GROUP:
+			if id, parent, err := parseSceneGroupBlock(block.Data); err == nil {
+				addNode(id, parent)
+			}
+		case BLOCK_TREE_NODE:
+			if id, node, err := parseTreeNodeBlock(block.Data); err == nil {
+				nodes[id] = node
+			}
+		}
+	}
+
+	if len(layerIDs) == 0 {
+		layer := Layer{Lines: make([]Line, 0, len(lines))}
+		for _, line := range lines {
+			layer.Lines = append(layer.Lines, convertV6Line(line))
+		}
+		return []Layer{layer}
+	}
+
+	// layerOf returns the layer holding a node, the depth is bounded in
+	// case of a cycle
+	layerOf := func(id V6CrdtId) V6CrdtId {
+		for i := 0; i < len(parents)+1; i++ {
+			parent, ok := parents[id]
+			if !ok || parent == v6RootID {
+				return id
+			}
+			id = parent
+		}
+		return id
+	}
+
+	var layers []Layer
+	index := make(map[V6CrdtId]int)
+	addLayer := func(id V6CrdtId) int {
+		node, ok := nodes[id]
+		index[id] = len(layers)
+		layers = append(layers, Layer{Name: node.Label, Hidden: ok && !node.Visible})
+		return index[id]
+	}
+	for _, id := range layerIDs {
+		addLayer(id)
+	}
+
+	for _, line := range lines {
+		layer := layerOf(line.Parent)
+		i, ok := index[layer]
+		if !ok {
+			i = addLayer(layer)
+		}
+		layers[i].Lines = append(layers[i].Lines, convertV6Line(line))
+	}
+
+	return layers
+}
+
+// parseSceneTreeBlock parses a scene tree block
+// Structure:
+//   - tagged ID at index 1: tree_id, the node
+//   - tagged ID at index 2: node_id
+//   - tagged bool at index 3: is_update
+//   - tagged subblock at index 4 > tagged ID at index 1: parent_id
+func parseSceneTreeBlock(data []byte) (V6CrdtId, V6CrdtId, error) {
+	r := bytes.NewReader(data)
+
+	var id, parent V6CrdtId
+	if _, err := expectTag(r, 1, TAG_ID); err != nil {
+		return id, parent, err
+	}
+	id, err := readCrdtId(r)
+	if err != nil {
+		return id, parent, err
+	}
+
+	if _, err := expectTag(r, 2, TAG_ID); err != nil {
+		return id, parent, err
+	}
+	if _, err := readCrdtId(r); err != nil {
+		return id, parent, err
+	}
+
+	if _, err := expectTag(r, 3, TAG_BYTE1); err != nil {
+		return id, parent, err
+	}
+	if _, err := r.ReadByte(); err != nil {
+		return id, parent, err
+	}
+
+	sub, err := readSubblock(r, 4)
+	if err != nil {
+		return id, parent, err
+	}
+	if _, err := expectTag(sub, 1, TAG_ID); err != nil {
+		return id, parent, err
+	}
+	parent, err = readCrdtId(sub)
+	return id, parent, err
+}
+
+// parseSceneGroupBlock parses a group item, it has the same structure as
+// the line items (see parseSceneItemBlock) and its value is the node of
+// the group:
+//   - tagged subblock at index 6: item type, tagged ID at index 2: node_id
+func parseSceneGroupBlock(data []byte) (V6CrdtId, V6CrdtId, error) {
+	r := bytes.NewReader(data)
+
+	var id, parent V6CrdtId
+	if _, err := expectTag(r, 1, TAG_ID); err != nil {
+		return id, parent, err
+	}
+	parent, err := readCrdtId(r)
+	if err != nil {
+		return id, parent, err
+	}
+
+	// item_id, left_id and right_id
+	for i := 2; i <= 4; i++ {
+		if _, err := expectTag(r, i, TAG_ID); err != nil {
+			return id, parent, err
+		}
+		if _, err := readCrdtId(r); err != nil {
+			return id, parent, err
+		}
+	}
+
+	if _, err := expectTag(r, 5, TAG_BYTE4); err != nil {
+		return id, parent, err
+	}
+	var deletedLength uint32
+	if err := binary.Read(r, binary.LittleEndian, &deletedLength); err != nil {
+		return id, parent, err
+	}
+	if deletedLength > 0 || r.Len() == 0 {
+		return id, parent, fmt.Errorf("deleted group")
+	}
+
+	value, err := readSubblock(r, 6)
+	if err != nil {
+		return id, parent, err
+	}
+	// item type
+	if _, err := value.ReadByte(); err != nil {
+		return id, parent, err
+	}
+	if _, err := expectTag(value, 2, TAG_ID); err != nil {
+		return id, parent, err
+	}
+	id, err = readCrdtId(value)
+	return id, parent, err
+}
+
+// parseTreeNodeBlock parses the label and visibility of a node
+// Structure:
+//   - tagged ID at index 1: node_id
+//   - tagged subblock at index 2: timestamp (ID at index 1), label (string at index 2)
+//   - tagged subblock at index 3: timestamp (ID at index 1), visible (bool at index 2)
+func parseTreeNodeBlock(data []byte) (V6CrdtId, v6TreeNode, error) {
+	r := bytes.NewReader(data)
+	node := v6TreeNode{Visible: true}
+
+	var id V6CrdtId
+	if _, err := expectTag(r, 1, TAG_ID); err != nil {
+		return id, node, err
+	}
+	id, err := readCrdtId(r)
+	if err != nil {
+		return id, node, err
+	}
+
+	label, err := readSubblock(r, 2)
+	if err != nil {
+		return id, node, err
+	}
+	if _, err := expectTag(label, 1, TAG_ID); err != nil {
+		return id, node, err
+	}
+	if _, err := readCrdtId(label); err != nil {
+		return id, node, err
+	}
+	if node.Label, err = readString(label, 2); err != nil {
+		return id, node, err
+	}
+
+	visible, err := readSubblock(r, 3)
+	if err != nil {
+		return id, node, err
+	}
+	if _, err := expectTag(visible, 1, TAG_ID); err != nil {
+		return id, node, err
+	}
+	if _, err := readCrdtId(visible); err != nil {
+		return id, node, err
+	}
+	if _, err := expectTag(visible, 2, TAG_BYTE1); err != nil {
+		return id, node, err
+	}
+	b, err := visible.ReadByte()
+	if err != nil {
+		return id, node, err
+	}
+	node.Visible = b != 0
+
+	return id, node, nil
+}
+
+// readString reads a tagged string: a subblock holding its length, an
+// is_ascii flag and the bytes
+func readString(r *bytes.Reader, index int) (string, error) {
+	value, err := readSubblock(r, index)
+	if err != nil {
+		return "", err
+	}
+	length, err := readVarint(value)
+	if err != nil {
+		return "", err
+	}
+	// is_ascii flag
+	if _, err := value.ReadByte(); err != nil {
+		return "", err
+	}
+	if length > uint64(value.Len()) {
+		return "", fmt.Errorf("string too long: %d", length)
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(value, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
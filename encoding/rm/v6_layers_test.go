@@ -0,0 +1,89 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func putString(b *bytes.Buffer, index int, s string) {
+	var value bytes.Buffer
+	putVarint(&value, uint64(len(s)))
+	value.WriteByte(1)
+	value.WriteString(s)
+	putSubblock(b, index, value.Bytes())
+}
+
+func treeNodeBlock(id V6CrdtId, label string, visible bool) V6Block {
+	var data, labelBlock, visibleBlock bytes.Buffer
+	putID(&data, 1, id)
+
+	putID(&labelBlock, 1, V6CrdtId{})
+	putString(&labelBlock, 2, label)
+	putSubblock(&data, 2, labelBlock.Bytes())
+
+	putID(&visibleBlock, 1, V6CrdtId{})
+	putVarint(&visibleBlock, 2<<4|TAG_BYTE1)
+	if visible {
+		visibleBlock.WriteByte(1)
+	} else {
+		visibleBlock.WriteByte(0)
+	}
+	putSubblock(&data, 3, visibleBlock.Bytes())
+
+	return V6Block{BlockType: BLOCK_TREE_NODE, Data: data.Bytes()}
+}
+
+func sceneGroupBlock(parent, item, node V6CrdtId) V6Block {
+	var data, value bytes.Buffer
+	putID(&data, 1, parent)
+	putID(&data, 2, item)
+	putID(&data, 3, V6CrdtId{})
+	putID(&data, 4, V6CrdtId{})
+	putVarint(&data, 5<<4|TAG_BYTE4)
+	binary.Write(&data, binary.LittleEndian, uint32(0))
+
+	value.WriteByte(0x02)
+	putID(&value, 2, node)
+	putSubblock(&data, 6, value.Bytes())
+
+	return V6Block{BlockType: BLOCK_SCENE_GROUP, Data: data.Bytes()}
+}
+
+func TestGroupV6Lines(t *testing.T) {
+	layer1 := V6CrdtId{0, 11}
+	layer2 := V6CrdtId{2, 5}
+	group := V6CrdtId{2, 9}
+
+	blocks := []V6Block{
+		treeNodeBlock(v6RootID, "", true),
+		treeNodeBlock(layer1, "Layer 1", true),
+		treeNodeBlock(layer2, "Sketch", false),
+		sceneGroupBlock(v6RootID, V6CrdtId{0, 13}, layer1),
+		sceneGroupBlock(v6RootID, V6CrdtId{2, 6}, layer2),
+		// a group inside the second layer
+		sceneGroupBlock(layer2, V6CrdtId{2, 10}, group),
+	}
+	lines := []V6Line{
+		{Parent: layer1, Points: []V6Point{{X: 1}}},
+		{Parent: group, Points: []V6Point{{X: 2}}},
+		{Parent: layer2, Points: []V6Point{{X: 3}}},
+	}
+
+	layers := groupV6Lines(blocks, lines)
+	if len(layers) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(layers))
+	}
+	if layers[0].Name != "Layer 1" || layers[0].Hidden || len(layers[0].Lines) != 1 {
+		t.Errorf("wrong first layer: %+v", layers[0])
+	}
+	if layers[1].Name != "Sketch" || !layers[1].Hidden || len(layers[1].Lines) != 2 {
+		t.Errorf("wrong second layer: %+v", layers[1])
+	}
+
+	// without a scene tree, lines end up in one layer
+	layers = groupV6Lines(nil, lines)
+	if len(layers) != 1 {
+		t.Errorf("expected 1 layer, got %d", len(layers))
+	}
+}
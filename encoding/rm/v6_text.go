@@ -0,0 +1,445 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// BLOCK_ROOT_TEXT holds the typed text of a page
+const BLOCK_ROOT_TEXT = 0x07
+
+// Text is the typed text of a page
+type Text struct {
+	// X and Y are the position of the text box
+	X float64
+	Y float64
+	// Width is the width of the text box
+	Width float32
+	// Content holds the paragraphs separated by newlines
+	Content string
+	// Paragraphs are the paragraphs of Content with their style and
+	// emphasis
+	Paragraphs []TextParagraph
+}
+
+// ParagraphStyle is the style of a paragraph of typed text
+type ParagraphStyle uint8
+
+const (
+	StyleBasic           ParagraphStyle = 0
+	StylePlain           ParagraphStyle = 1
+	StyleHeading         ParagraphStyle = 2
+	StyleBold            ParagraphStyle = 3
+	StyleBullet          ParagraphStyle = 4
+	StyleBullet2         ParagraphStyle = 5
+	StyleCheckbox        ParagraphStyle = 6
+	StyleCheckboxChecked ParagraphStyle = 7
+)
+
+// TextParagraph is a paragraph of typed text
+type TextParagraph struct {
+	Style ParagraphStyle
+	Spans []TextSpan
+}
+
+// TextSpan is a run of characters of a paragraph with the same emphasis
+type TextSpan struct {
+	Text   string
+	Bold   bool
+	Italic bool
+}
+
+// Inline formatting codes, items of the text sequence switching the
+// emphasis of the characters that follow
+const (
+	formatBoldOn    = 1
+	formatBoldOff   = 2
+	formatItalicOn  = 3
+	formatItalicOff = 4
+)
+
+// v6TextItem is an item of the CRDT sequence holding the characters of the
+// text. Each character has its own ID, the ones of an item with several
+// characters are consecutive.
+type v6TextItem struct {
+	ID            V6CrdtId
+	LeftID        V6CrdtId
+	RightID       V6CrdtId
+	DeletedLength uint32
+	Value         string
+	// Format is the inline formatting code of an item without Value, 0
+	// for none
+	Format uint32
+}
+
+// extractTextFromV6Blocks returns the typed text of the page, nil if the
+// page has none or it can't be parsed
+func extractTextFromV6Blocks(blocks []V6Block) *Text {
+	for _, block := range blocks {
+		if block.BlockType != BLOCK_ROOT_TEXT {
+			continue
+		}
+		text, err := parseRootTextBlock(block.Data)
+		if err == nil && text.Content != "" {
+			return text
+		}
+	}
+	return nil
+}
+
+// parseRootTextBlock parses a root text block
+// Structure:
+//   - tagged ID at index 1: block_id
+//   - tagged subblock at index 2:
+//   - tagged subblock at index 1 > subblock at index 1: text items
+//   - tagged subblock at index 2 > subblock at index 1: paragraph styles
+//   - tagged subblock at index 3: x, y (float64)
+//   - tagged float at index 4: width
+func parseRootTextBlock(data []byte) (*Text, error) {
+	r := bytes.NewReader(data)
+
+	if _, err := expectTag(r, 1, TAG_ID); err != nil {
+		return nil, err
+	}
+	if _, err := readCrdtId(r); err != nil {
+		return nil, err
+	}
+
+	contents, err := readSubblock(r, 2)
+	if err != nil {
+		return nil, err
+	}
+	itemsBlock, err := readSubblock(contents, 1)
+	if err != nil {
+		return nil, err
+	}
+	itemsBlock, err = readSubblock(itemsBlock, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := readVarint(itemsBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]v6TextItem, 0, count)
+	for i := uint64(0); i < count; i++ {
+		item, err := parseTextItem(itemsBlock)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	chars := orderTextChars(items)
+	text := &Text{Content: textContent(chars)}
+
+	// the styles are optional
+	var styles map[V6CrdtId]ParagraphStyle
+	if stylesBlock, err := readSubblock(contents, 2); err == nil {
+		styles, _ = parseParagraphStyles(stylesBlock)
+	}
+	text.Paragraphs = textParagraphs(chars, styles)
+
+	position, err := readSubblock(r, 3)
+	if err != nil {
+		return text, nil
+	}
+	binary.Read(position, binary.LittleEndian, &text.X)
+	binary.Read(position, binary.LittleEndian, &text.Y)
+
+	if _, err := expectTag(r, 4, TAG_BYTE4); err == nil {
+		binary.Read(r, binary.LittleEndian, &text.Width)
+	}
+
+	return text, nil
+}
+
+// parseTextItem parses a text item
+// Structure (inside a subblock at index 0):
+//   - tagged ID at index 2: item_id
+//   - tagged ID at index 3: left_id
+//   - tagged ID at index 4: right_id
+//   - tagged int at index 5: deleted_length
+//   - tagged subblock at index 6 (optional): string, followed by a tagged
+//     int at index 2 holding the formatting code of empty strings
+func parseTextItem(r *bytes.Reader) (v6TextItem, error) {
+	var item v6TextItem
+
+	sub, err := readSubblock(r, 0)
+	if err != nil {
+		return item, err
+	}
+
+	ids := []*V6CrdtId{&item.ID, &item.LeftID, &item.RightID}
+	for i, id := range ids {
+		if _, err := expectTag(sub, i+2, TAG_ID); err != nil {
+			return item, err
+		}
+		if *id, err = readCrdtId(sub); err != nil {
+			return item, err
+		}
+	}
+
+	if _, err := expectTag(sub, 5, TAG_BYTE4); err != nil {
+		return item, err
+	}
+	if err := binary.Read(sub, binary.LittleEndian, &item.DeletedLength); err != nil {
+		return item, err
+	}
+
+	if sub.Len() == 0 {
+		return item, nil
+	}
+
+	value, err := readSubblock(sub, 6)
+	if err != nil {
+		return item, err
+	}
+	length, err := readVarint(value)
+	if err != nil {
+		return item, err
+	}
+	// is_ascii flag
+	if _, err := value.ReadByte(); err != nil {
+		return item, err
+	}
+	if length > uint64(value.Len()) {
+		return item, fmt.Errorf("string too long: %d", length)
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(value, s); err != nil {
+		return item, err
+	}
+	item.Value = string(s)
+
+	if value.Len() > 0 {
+		if _, err := expectTag(value, 2, TAG_BYTE4); err != nil {
+			return item, err
+		}
+		if err := binary.Read(value, binary.LittleEndian, &item.Format); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// parseParagraphStyles parses the paragraph styles of a text, by ID of the
+// newline starting the paragraph, the zero ID for the first one
+// Structure (inside a subblock at index 1):
+//   - varint: count
+//   - for each style: ID, tagged ID at index 1 (timestamp), tagged
+//     subblock at index 2 holding 17 and the style
+func parseParagraphStyles(r *bytes.Reader) (map[V6CrdtId]ParagraphStyle, error) {
+	sub, err := readSubblock(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	count, err := readVarint(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	styles := make(map[V6CrdtId]ParagraphStyle)
+	for i := uint64(0); i < count; i++ {
+		id, err := readCrdtId(sub)
+		if err != nil {
+			return styles, err
+		}
+		if _, err := expectTag(sub, 1, TAG_ID); err != nil {
+			return styles, err
+		}
+		if _, err := readCrdtId(sub); err != nil {
+			return styles, err
+		}
+		value, err := readSubblock(sub, 2)
+		if err != nil {
+			return styles, err
+		}
+		var b [2]byte
+		if _, err := io.ReadFull(value, b[:]); err != nil {
+			return styles, err
+		}
+		styles[id] = ParagraphStyle(b[1])
+	}
+	return styles, nil
+}
+
+// readSubblock reads a tagged subblock and returns a reader over its data
+func readSubblock(r *bytes.Reader, index int) (*bytes.Reader, error) {
+	if _, err := expectTag(r, index, TAG_LENGTH4); err != nil {
+		return nil, err
+	}
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(r.Len()) {
+		return nil, fmt.Errorf("subblock too long: %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+type v6TextChar struct {
+	id      V6CrdtId
+	left    V6CrdtId
+	right   V6CrdtId
+	deleted bool
+	value   string
+	format  uint32
+}
+
+// orderTextItems returns the text of the sequence
+func orderTextItems(items []v6TextItem) string {
+	return textContent(orderTextChars(items))
+}
+
+// textContent returns the characters of a sequence as a string
+func textContent(chars []v6TextChar) string {
+	var b strings.Builder
+	for _, c := range chars {
+		b.WriteString(c.value)
+	}
+	return b.String()
+}
+
+// orderTextChars returns the characters and formatting codes of the
+// sequence that aren't deleted, in order. Each character comes after its
+// left neighbour and before its right one, ties are broken by ID so that
+// the result doesn't depend on the order of the items in the file.
+func orderTextChars(items []v6TextItem) []v6TextChar {
+	// one entry per character, formatting codes take one position
+	var chars []v6TextChar
+	for _, item := range items {
+		values := strings.Split(item.Value, "")
+		deleted := item.DeletedLength > 0
+		if deleted {
+			values = make([]string, item.DeletedLength)
+		} else if item.Format != 0 {
+			values = []string{""}
+		}
+		id, left := item.ID, item.LeftID
+		for i, v := range values {
+			right := V6CrdtId{id.Part1, id.Part2 + 1}
+			if i == len(values)-1 {
+				right = item.RightID
+			}
+			chars = append(chars, v6TextChar{id, left, right, deleted, v, item.Format})
+			left, id = id, right
+		}
+	}
+
+	byID := make(map[V6CrdtId]int, len(chars))
+	for i, c := range chars {
+		byID[c.id] = i
+	}
+
+	// edges from each character to the ones that must come after it,
+	// references to unknown IDs (like the zero ID marking both ends) are
+	// ignored
+	after := make([][]int, len(chars))
+	pending := make([]int, len(chars))
+	addEdge := func(from, to int) {
+		after[from] = append(after[from], to)
+		pending[to]++
+	}
+	for i, c := range chars {
+		if l, ok := byID[c.left]; ok && l != i {
+			addEdge(l, i)
+		}
+		if r, ok := byID[c.right]; ok && r != i {
+			addEdge(i, r)
+		}
+	}
+
+	less := func(a, b int) bool {
+		if chars[a].id.Part1 != chars[b].id.Part1 {
+			return chars[a].id.Part1 < chars[b].id.Part1
+		}
+		return chars[a].id.Part2 < chars[b].id.Part2
+	}
+
+	var ready []int
+	for i := range chars {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	var ordered []v6TextChar
+	visited := 0
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		i := ready[0]
+		ready = ready[1:]
+		visited++
+
+		if !chars[i].deleted {
+			ordered = append(ordered, chars[i])
+		}
+		for _, next := range after[i] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	// a cycle means a corrupt sequence, keep the file order for what is left
+	if visited < len(chars) {
+		for i, c := range chars {
+			if pending[i] > 0 && !c.deleted {
+				ordered = append(ordered, c)
+			}
+		}
+	}
+
+	return ordered
+}
+
+// textParagraphs splits the characters of a text into paragraphs of spans
+// of the same emphasis. A paragraph has the style of the newline starting
+// it, plain by default.
+func textParagraphs(chars []v6TextChar, styles map[V6CrdtId]ParagraphStyle) []TextParagraph {
+	style := func(id V6CrdtId) ParagraphStyle {
+		if s, ok := styles[id]; ok {
+			return s
+		}
+		return StylePlain
+	}
+
+	paragraphs := []TextParagraph{{Style: style(V6CrdtId{})}}
+	var bold, italic bool
+	for _, c := range chars {
+		switch {
+		case c.format != 0:
+			switch c.format {
+			case formatBoldOn, formatBoldOff:
+				bold = c.format == formatBoldOn
+			case formatItalicOn, formatItalicOff:
+				italic = c.format == formatItalicOn
+			}
+			continue
+		case c.value == "\n":
+			paragraphs = append(paragraphs, TextParagraph{Style: style(c.id)})
+			continue
+		}
+
+		p := &paragraphs[len(paragraphs)-1]
+		if n := len(p.Spans); n > 0 && p.Spans[n-1].Bold == bold && p.Spans[n-1].Italic == italic {
+			p.Spans[n-1].Text += c.value
+		} else {
+			p.Spans = append(p.Spans, TextSpan{Text: c.value, Bold: bold, Italic: italic})
+		}
+	}
+	return paragraphs
+}
@@ -0,0 +1,153 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func putVarint(b *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+func putID(b *bytes.Buffer, index int, id V6CrdtId) {
+	putVarint(b, uint64(index<<4|TAG_ID))
+	b.WriteByte(id.Part1)
+	putVarint(b, id.Part2)
+}
+
+func putSubblock(b *bytes.Buffer, index int, data []byte) {
+	putVarint(b, uint64(index<<4|TAG_LENGTH4))
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+func textItem(id, left, right V6CrdtId, deleted uint32, value string) []byte {
+	var item bytes.Buffer
+	putID(&item, 2, id)
+	putID(&item, 3, left)
+	putID(&item, 4, right)
+	putVarint(&item, 5<<4|TAG_BYTE4)
+	binary.Write(&item, binary.LittleEndian, deleted)
+	if value != "" {
+		var s bytes.Buffer
+		putVarint(&s, uint64(len(value)))
+		s.WriteByte(1)
+		s.WriteString(value)
+		putSubblock(&item, 6, s.Bytes())
+	}
+
+	var b bytes.Buffer
+	putSubblock(&b, 0, item.Bytes())
+	return b.Bytes()
+}
+
+func TestParseRootTextBlock(t *testing.T) {
+	end := V6CrdtId{}
+
+	// "Hello world" with " big" typed in between and a deleted item, the
+	// items are not in text order
+	var items bytes.Buffer
+	putVarint(&items, 4)
+	items.Write(textItem(V6CrdtId{1, 20}, V6CrdtId{1, 14}, end, 0, " world"))
+	items.Write(textItem(V6CrdtId{1, 10}, end, V6CrdtId{1, 20}, 0, "Hello"))
+	items.Write(textItem(V6CrdtId{1, 30}, V6CrdtId{1, 14}, V6CrdtId{1, 20}, 0, " big"))
+	items.Write(textItem(V6CrdtId{1, 40}, V6CrdtId{1, 33}, V6CrdtId{1, 20}, 3, ""))
+
+	var inner, contents, data bytes.Buffer
+	putSubblock(&inner, 1, items.Bytes())
+	putSubblock(&contents, 1, inner.Bytes())
+	putID(&data, 1, end)
+	putSubblock(&data, 2, contents.Bytes())
+
+	var position bytes.Buffer
+	binary.Write(&position, binary.LittleEndian, float64(-468))
+	binary.Write(&position, binary.LittleEndian, float64(234))
+	putSubblock(&data, 3, position.Bytes())
+	putVarint(&data, 4<<4|TAG_BYTE4)
+	binary.Write(&data, binary.LittleEndian, float32(936))
+
+	text, err := parseRootTextBlock(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text.Content != "Hello big world" {
+		t.Errorf("wrong content: %q", text.Content)
+	}
+	if text.X != -468 || text.Y != 234 || text.Width != 936 {
+		t.Errorf("wrong position: %v, %v, %v", text.X, text.Y, text.Width)
+	}
+}
+
+// formatItem is a text item holding an inline formatting code
+func formatItem(id, left, right V6CrdtId, code uint32) []byte {
+	var item, s bytes.Buffer
+	putID(&item, 2, id)
+	putID(&item, 3, left)
+	putID(&item, 4, right)
+	putVarint(&item, 5<<4|TAG_BYTE4)
+	binary.Write(&item, binary.LittleEndian, uint32(0))
+	putVarint(&s, 0)
+	s.WriteByte(1)
+	putVarint(&s, 2<<4|TAG_BYTE4)
+	binary.Write(&s, binary.LittleEndian, code)
+	putSubblock(&item, 6, s.Bytes())
+
+	var b bytes.Buffer
+	putSubblock(&b, 0, item.Bytes())
+	return b.Bytes()
+}
+
+func TestTextParagraphs(t *testing.T) {
+	end := V6CrdtId{}
+
+	// "Title\nsome *bold* text" with the first paragraph a heading
+	var items bytes.Buffer
+	putVarint(&items, 5)
+	items.Write(textItem(V6CrdtId{1, 10}, end, end, 0, "Title\nsome "))
+	items.Write(formatItem(V6CrdtId{1, 30}, V6CrdtId{1, 20}, end, formatBoldOn))
+	items.Write(textItem(V6CrdtId{1, 31}, V6CrdtId{1, 30}, end, 0, "bold"))
+	items.Write(formatItem(V6CrdtId{1, 35}, V6CrdtId{1, 34}, end, formatBoldOff))
+	items.Write(textItem(V6CrdtId{1, 36}, V6CrdtId{1, 35}, end, 0, " text"))
+
+	var styles bytes.Buffer
+	putVarint(&styles, 2)
+	for _, style := range []struct {
+		id    V6CrdtId
+		style ParagraphStyle
+	}{{end, StyleHeading}, {V6CrdtId{1, 15}, StyleBullet}} {
+		styles.WriteByte(style.id.Part1)
+		putVarint(&styles, style.id.Part2)
+		putID(&styles, 1, V6CrdtId{1, 1})
+		putSubblock(&styles, 2, []byte{17, byte(style.style)})
+	}
+
+	var inner, stylesInner, contents, data bytes.Buffer
+	putSubblock(&inner, 1, items.Bytes())
+	putSubblock(&contents, 1, inner.Bytes())
+	putSubblock(&stylesInner, 1, styles.Bytes())
+	putSubblock(&contents, 2, stylesInner.Bytes())
+	putID(&data, 1, end)
+	putSubblock(&data, 2, contents.Bytes())
+
+	text, err := parseRootTextBlock(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text.Content != "Title\nsome bold text" {
+		t.Errorf("wrong content: %q", text.Content)
+	}
+	want := []TextParagraph{
+		{Style: StyleHeading, Spans: []TextSpan{{Text: "Title"}}},
+		{Style: StyleBullet, Spans: []TextSpan{{Text: "some "}, {Text: "bold", Bold: true}, {Text: " text"}}},
+	}
+	if fmt.Sprint(text.Paragraphs) != fmt.Sprint(want) {
+		t.Errorf("got paragraphs %+v, want %+v", text.Paragraphs, want)
+	}
+}
@@ -2,6 +2,8 @@ package filetree
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/juruen/rmapi/model"
 	"github.com/juruen/rmapi/util"
@@ -13,6 +15,10 @@ type FileTreeCtx struct {
 	pendingParent map[string]map[string]struct{}
 }
 
+// FileTreeVistor is the visitor of WalkTree, Visit returns StopVisiting to
+// end the walk.
+//
+// Deprecated: use Walk, which supports errors, SkipDir, depth limits and filters
 type FileTreeVistor struct {
 	Visit func(node *model.Node, path []string) bool
 }
@@ -148,13 +154,11 @@ func (ctx *FileTreeCtx) NodesByPath(path string, currentNode *model.Node, ignore
 		return []*model.Node{currentNode}, nil
 	}
 
-	i := 0
-	if entries[i] == "" {
-		currentNode = ctx.Root()
-		i++
+	currentNode, i, err := ctx.startNode(entries, currentNode)
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
 	var result []*model.Node
 
 	for i < length {
@@ -179,8 +183,14 @@ func (ctx *FileTreeCtx) NodesByPath(path string, currentNode *model.Node, ignore
 
 		if isLast {
 			result, err = currentNode.FindByPattern(entry)
+			if err != nil {
+				// name~N or id:<uuid>
+				if n, findErr := findChild(currentNode, entry, DefaultPathMatch); findErr == nil {
+					result, err = []*model.Node{n}, nil
+				}
+			}
 		} else {
-			currentNode, err = currentNode.FindByName(entry)
+			currentNode, err = findChild(currentNode, entry, DefaultPathMatch)
 		}
 
 		if err != nil {
@@ -209,7 +219,15 @@ func (ctx *FileTreeCtx) NodesByPath(path string, currentNode *model.Node, ignore
 	}
 
 }
+
+// NodeByPath resolves path relative to current (or the root if path is
+// absolute), matching names according to DefaultPathMatch
 func (ctx *FileTreeCtx) NodeByPath(path string, current *model.Node) (*model.Node, error) {
+	return ctx.NodeByPathMatch(path, current, DefaultPathMatch)
+}
+
+// NodeByPathMatch is like NodeByPath with an explicit name matching
+func (ctx *FileTreeCtx) NodeByPathMatch(path string, current *model.Node, match PathMatch) (*model.Node, error) {
 	if current == nil {
 		current = ctx.Root()
 	}
@@ -220,10 +238,9 @@ func (ctx *FileTreeCtx) NodeByPath(path string, current *model.Node) (*model.Nod
 		return current, nil
 	}
 
-	i := 0
-	if entries[i] == "" {
-		current = ctx.Root()
-		i++
+	current, i, err := ctx.startNode(entries, current)
+	if err != nil {
+		return nil, err
 	}
 
 	for i < len(entries) {
@@ -243,8 +260,7 @@ func (ctx *FileTreeCtx) NodeByPath(path string, current *model.Node) (*model.Nod
 			continue
 		}
 
-		var err error
-		current, err = current.FindByName(entries[i])
+		current, err = findChild(current, entries[i], match)
 
 		if err != nil {
 			return nil, err
@@ -256,23 +272,52 @@ func (ctx *FileTreeCtx) NodeByPath(path string, current *model.Node) (*model.Nod
 	return current, nil
 }
 
+// UUIDPrefix addresses a document or folder anywhere in the tree by its ID,
+// e.g. uuid:<id> or uuid:<id>/child. IDPrefix is accepted as an alias at the
+// start of a path.
+const UUIDPrefix = "uuid:"
+
+// HasUUIDPrefix reports whether path starts at a document or folder given
+// by its ID rather than at the root or the current directory
+func HasUUIDPrefix(path string) bool {
+	return strings.HasPrefix(path, UUIDPrefix) || strings.HasPrefix(path, IDPrefix)
+}
+
+// startNode returns the node where the resolution of a split path begins
+// and the index of the first entry left to resolve
+func (ctx *FileTreeCtx) startNode(entries []string, current *model.Node) (*model.Node, int, error) {
+	if entries[0] == "" {
+		return ctx.Root(), 1, nil
+	}
+
+	id, ok := strings.CutPrefix(entries[0], UUIDPrefix)
+	if !ok {
+		id, ok = strings.CutPrefix(entries[0], IDPrefix)
+	}
+	if ok {
+		node := ctx.NodeById(id)
+		if id == "" || node == nil {
+			return nil, 0, fmt.Errorf("entry with uuid '%s' doesnt exist", id)
+		}
+		return node, 1, nil
+	}
+
+	return current, 0, nil
+}
+
 func (ctx *FileTreeCtx) NodeToPath(targetNode *model.Node) (string, error) {
 	resultPath := ""
 	found := false
 
-	visitor := FileTreeVistor{
-		func(currentNode *model.Node, path []string) bool {
-			if targetNode != currentNode {
-				return ContinueVisiting
-			}
-
-			found = true
-			resultPath = BuildPath(path, currentNode.Name())
-			return StopVisiting
-		},
-	}
+	Walk(ctx.root, WalkOptions{}, func(currentNode *model.Node, path []string) error {
+		if targetNode != currentNode {
+			return nil
+		}
 
-	WalkTree(ctx.root, visitor)
+		found = true
+		resultPath = BuildPath(path, currentNode.DisplayName())
+		return SkipAll
+	})
 
 	if found {
 		return resultPath, nil
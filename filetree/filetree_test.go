@@ -1,9 +1,11 @@
 package filetree
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -198,3 +200,181 @@ func TestNodeToPath(t *testing.T) {
 	path, _ = ctx.NodeToPath(ctx.root.Children["9"])
 	assert.Equal(t, "/file5", path)
 }
+
+func TestWalk(t *testing.T) {
+	ctx := CreateFileTreeCtx()
+
+	// dir1/dir12/file1
+	// dir2/file2
+	// file3
+	ctx.AddDocument(createDirectory("1", "", "dir1"))
+	ctx.AddDocument(createDirectory("2", "1", "dir12"))
+	ctx.AddDocument(createFile("3", "2", "file1"))
+	ctx.AddDocument(createDirectory("4", "", "dir2"))
+	ctx.AddDocument(createFile("5", "4", "file2"))
+	ctx.AddDocument(createFile("6", "", "file3"))
+
+	visit := func(opts WalkOptions, skip string) []string {
+		var visited []string
+		err := Walk(ctx.Root(), opts, func(node *model.Node, path []string) error {
+			if node.Name() == TrashID {
+				return SkipDir
+			}
+			visited = append(visited, BuildPath(path, node.Name()))
+			if node.Name() == skip {
+				return SkipDir
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		return visited
+	}
+
+	assert.Equal(t, []string{"/", "/dir1", "/dir1/dir12", "/dir1/dir12/file1", "/dir2", "/dir2/file2", "/file3"},
+		visit(WalkOptions{}, ""))
+
+	assert.Equal(t, []string{"/", "/dir1", "/dir2", "/dir2/file2", "/file3"},
+		visit(WalkOptions{}, "dir1"))
+
+	assert.Equal(t, []string{"/", "/dir1", "/dir2", "/file3"},
+		visit(WalkOptions{MaxDepth: 1}, ""))
+
+	files := WalkOptions{Filter: func(node *model.Node) bool { return node.IsFile() }}
+	assert.Equal(t, []string{"/dir1/dir12/file1", "/dir2/file2", "/file3"},
+		visit(files, ""))
+
+	count := 0
+	err := Walk(ctx.Root(), WalkOptions{}, func(node *model.Node, path []string) error {
+		count++
+		if count == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 2, count)
+}
+
+func TestNodeByPathMatch(t *testing.T) {
+	ctx := CreateFileTreeCtx()
+
+	ctx.AddDocument(createDirectory("1", "", "Books"))
+	ctx.AddDocument(createFile("2", "1", "The Hobbit"))
+	ctx.AddDocument(createFile("3", "1", "notes"))
+	ctx.AddDocument(createFile("4", "1", "Notes"))
+
+	_, err := ctx.NodeByPathMatch("/books/the hobbit", ctx.Root(), MatchExact)
+	assert.EqualError(t, err, "entry 'books' doesnt exist, did you mean 'Books'?")
+
+	node, err := ctx.NodeByPathMatch("/books/the hobbit", ctx.Root(), MatchIgnoreCase)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", node.Id())
+
+	_, err = ctx.NodeByPathMatch("/Books/the-hobbit", ctx.Root(), MatchIgnoreCase)
+	assert.NotNil(t, err)
+
+	node, err = ctx.NodeByPathMatch("/Books/the-hobbit", ctx.Root(), MatchFuzzy)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", node.Id())
+
+	// an exact match wins over looser ones
+	node, err = ctx.NodeByPathMatch("/Books/notes", ctx.Root(), MatchIgnoreCase)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", node.Id())
+
+	_, err = ctx.NodeByPathMatch("/Books/NOTES", ctx.Root(), MatchIgnoreCase)
+	assert.EqualError(t, err, "'NOTES' is ambiguous, it matches 'Notes', 'notes'")
+
+	_, err = ctx.NodeByPathMatch("/Books/The Hobit", ctx.Root(), MatchExact)
+	assert.EqualError(t, err, "entry 'The Hobit' doesnt exist, did you mean 'The Hobbit'?")
+}
+
+func TestDuplicateNames(t *testing.T) {
+	ctx := CreateFileTreeCtx()
+
+	ctx.AddDocument(createDirectory("1", "", "dir"))
+	ctx.AddDocument(createFile("b", "1", "notes"))
+	ctx.AddDocument(createFile("a", "1", "notes"))
+	ctx.AddDocument(createFile("c", "1", "notes"))
+
+	node, err := ctx.NodeByPath("/dir/notes", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", node.Id())
+	assert.Equal(t, "notes", node.DisplayName())
+
+	node, err = ctx.NodeByPath("/dir/notes~2", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", node.Id())
+	assert.Equal(t, "notes~2", node.DisplayName())
+
+	path, _ := ctx.NodeToPath(ctx.NodeById("c"))
+	assert.Equal(t, "/dir/notes~3", path)
+
+	_, err = ctx.NodeByPath("/dir/notes~4", nil)
+	assert.NotNil(t, err)
+
+	node, err = ctx.NodeByPath("/id:1/id:c", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "c", node.Id())
+
+	nodes, err := ctx.NodesByPath("/dir/notes~3", nil, false)
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "c", nodes[0].Id())
+}
+
+func TestUUIDPath(t *testing.T) {
+	ctx := CreateFileTreeCtx()
+
+	ctx.AddDocument(createDirectory("1", "", "dir1"))
+	ctx.AddDocument(createDirectory("2", "1", "dir12"))
+	ctx.AddDocument(createFile("3", "2", "file1"))
+
+	node, err := ctx.NodeByPath("uuid:3", ctx.Root())
+	assert.Nil(t, err)
+	assert.Equal(t, "file1", node.Name())
+
+	node, err = ctx.NodeByPath("uuid:1/dir12/file1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "3", node.Id())
+
+	nodes, err := ctx.NodesByPath("uuid:2", nil, true)
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Equal(t, "3", nodes[0].Id())
+
+	_, err = ctx.NodeByPath("uuid:42", nil)
+	assert.EqualError(t, err, "entry with uuid '42' doesnt exist")
+
+	node, err = ctx.NodeByPath("id:1/dir12", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", node.Id())
+}
+
+func TestLocalNamer(t *testing.T) {
+	root := &model.Node{Document: &model.Document{}, Children: map[string]*model.Node{}}
+	add := func(parent *model.Node, id, name string) *model.Node {
+		n := &model.Node{Document: &model.Document{ID: id, Name: name}, Children: map[string]*model.Node{}, Parent: parent}
+		parent.Children[id] = n
+		return n
+	}
+	folder := add(root, "f0000000-0000", "Work: 2024")
+	colon := add(folder, "a1111111-1111", "a:b")
+	plain := add(folder, "b2222222-2222", "a_b")
+	emoji := add(folder, "c3333333-3333", "Ideas 💡")
+
+	dup := add(folder, "d4444444-4444", "Ideas 💡")
+
+	namer := LocalNamer{Rules: util.FilenameRulesPortable, Replacement: "_"}
+	for _, namer := range []LocalNamer{namer, namer.Cached()} {
+		assert.Equal(t, "a_b", namer.Name(plain), "valid name changed")
+		assert.Equal(t, "a_b~a1111111", namer.Name(colon), "colliding name not suffixed")
+		assert.Equal(t, "Ideas 💡", namer.Name(emoji))
+		assert.Equal(t, "Ideas 💡~2", namer.Name(dup))
+		assert.Equal(t, []string{"", "Work_ 2024"}, namer.Dir(colon, 2))
+	}
+
+	for child, name := range folder.ChildrenDisplayNames() {
+		assert.Equal(t, child.DisplayName(), name)
+	}
+}
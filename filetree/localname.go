@@ -0,0 +1,126 @@
+package filetree
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// LocalNamer gives the names of the local copies of the entries, with the
+// characters the local file system rejects replaced
+type LocalNamer struct {
+	Rules       util.FilenameRules
+	Replacement string
+	// cache holds the local names of the children of the folders named so
+	// far, see Cached
+	cache *namerCache
+}
+
+// namerCache holds the local names of the children of each folder
+type namerCache struct {
+	mu      sync.Mutex
+	folders map[*model.Node]*folderNames
+}
+
+// folderNames are the local names of the children of a folder
+type folderNames struct {
+	// names is the sanitized display name of each child
+	names map[*model.Node]string
+	// changed tells the children whose name was sanitized
+	changed map[*model.Node]bool
+	// count is the number of children per lower-cased name
+	count map[string]int
+}
+
+// DefaultLocalNamer follows the rules of the running system and replaces
+// the rejected characters with _
+var DefaultLocalNamer = LocalNamer{Rules: util.FilenameRulesOS, Replacement: "_"}
+
+// Name returns the local name of a node. A name that had to be changed
+// and now matches the name of a sibling, ignoring case, is suffixed with
+// the start of the node ID so that it stays the same from run to run.
+// The root has no name.
+func (n LocalNamer) Name(node *model.Node) string {
+	if node.IsRoot() {
+		return ""
+	}
+	if node.Parent == nil {
+		return util.SanitizeFilename(node.DisplayName(), n.Rules, n.Replacement)
+	}
+
+	folder := n.folder(node.Parent)
+	name, ok := folder.names[node]
+	if !ok {
+		// missing from the children of its parent
+		return util.SanitizeFilename(node.DisplayName(), n.Rules, n.Replacement)
+	}
+	// the node itself is one of them
+	if folder.changed[node] && folder.count[strings.ToLower(name)] > 1 {
+		return WithID(name, node)
+	}
+	return name
+}
+
+// Cached returns a copy of the namer that works out the local names of the
+// children of a folder once, for the walks naming every entry of a tree.
+// The tree must not change while it is used.
+func (n LocalNamer) Cached() LocalNamer {
+	n.cache = &namerCache{folders: make(map[*model.Node]*folderNames)}
+	return n
+}
+
+// folder returns the local names of the children of parent
+func (n LocalNamer) folder(parent *model.Node) *folderNames {
+	if n.cache == nil {
+		return n.folderNames(parent)
+	}
+	n.cache.mu.Lock()
+	defer n.cache.mu.Unlock()
+	folder, ok := n.cache.folders[parent]
+	if !ok {
+		folder = n.folderNames(parent)
+		n.cache.folders[parent] = folder
+	}
+	return folder
+}
+
+func (n LocalNamer) folderNames(parent *model.Node) *folderNames {
+	display := parent.ChildrenDisplayNames()
+	folder := &folderNames{
+		names:   make(map[*model.Node]string, len(display)),
+		changed: make(map[*model.Node]bool),
+		count:   make(map[string]int, len(display)),
+	}
+	for child, d := range display {
+		name := util.SanitizeFilename(d, n.Rules, n.Replacement)
+		folder.names[child] = name
+		folder.changed[child] = name != d
+		folder.count[strings.ToLower(name)]++
+	}
+	return folder
+}
+
+// WithID suffixes the local name of a node with the start of its ID, to
+// tell it apart from another entry of the same name
+func WithID(name string, node *model.Node) string {
+	id := node.Id()
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return name + model.DuplicateSeparator + id
+}
+
+// Dir returns the local names of the depth closest ancestors of a node,
+// outermost first, the local folder of the node relative to the walk root
+// for a path of that depth given by Walk
+func (n LocalNamer) Dir(node *model.Node, depth int) []string {
+	dir := make([]string, depth)
+	parent := node.Parent
+	for i := depth - 1; i >= 0 && parent != nil; i-- {
+		dir[i] = n.Name(parent)
+		parent = parent.Parent
+	}
+	return dir
+}
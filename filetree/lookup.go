@@ -0,0 +1,245 @@
+package filetree
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+)
+
+// PathMatch is how the components of a path are matched against entry names
+type PathMatch int
+
+const (
+	// MatchExact requires the exact entry name
+	MatchExact PathMatch = iota
+	// MatchIgnoreCase falls back to a case-insensitive match
+	MatchIgnoreCase
+	// MatchFuzzy also ignores punctuation and whitespace
+	MatchFuzzy
+)
+
+// DefaultPathMatch is the matching used by NodeByPath and NodesByPath
+var DefaultPathMatch = MatchExact
+
+// ParsePathMatch parses exact, icase or fuzzy
+func ParsePathMatch(s string) (PathMatch, error) {
+	switch strings.ToLower(s) {
+	case "", "exact":
+		return MatchExact, nil
+	case "icase":
+		return MatchIgnoreCase, nil
+	case "fuzzy":
+		return MatchFuzzy, nil
+	}
+	return MatchExact, fmt.Errorf("unknown path matching: %s", s)
+}
+
+// NotFoundError is returned when a path component doesn't exist, with
+// similarly named entries as suggestions
+type NotFoundError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *NotFoundError) Error() string {
+	msg := fmt.Sprintf("entry '%s' doesnt exist", e.Name)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean '%s'?", strings.Join(e.Suggestions, "', '"))
+	}
+	return msg
+}
+
+// Unwrap makes errors.Is(err, model.ErrNotFound) true
+func (e *NotFoundError) Unwrap() error {
+	return model.ErrNotFound
+}
+
+// IDPrefix addresses an entry by its ID instead of its name, e.g. id:<uuid>
+const IDPrefix = "id:"
+
+const maxSuggestions = 3
+
+// findChild looks up the child called name, falling back to a looser match
+// if allowed. A loose match must be unique.
+//
+// Entries sharing their name with siblings are addressed as name, name~2,
+// name~3... (see model.Node.DisplayName), any entry can be addressed as
+// id:<uuid>.
+func findChild(node *model.Node, name string, match PathMatch) (*model.Node, error) {
+	if id, ok := strings.CutPrefix(name, IDPrefix); ok {
+		if n, ok := node.Children[id]; ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("entry with id '%s' doesnt exist", id)
+	}
+
+	if n := findExact(node, name); n != nil {
+		return n, nil
+	}
+
+	if n := findDuplicate(node, name); n != nil {
+		return n, nil
+	}
+
+	if match >= MatchIgnoreCase {
+		if n, err := findUnique(node, name, strings.EqualFold); n != nil || err != nil {
+			return n, err
+		}
+	}
+
+	if match >= MatchFuzzy {
+		fuzzyEqual := func(a, b string) bool { return normalizeName(a) == normalizeName(b) }
+		if n, err := findUnique(node, name, fuzzyEqual); n != nil || err != nil {
+			return n, err
+		}
+	}
+
+	return nil, &NotFoundError{Name: name, Suggestions: suggest(node, name)}
+}
+
+// findExact returns the child called name. If there are several the first
+// one is returned, with a warning as the user may have meant another one.
+func findExact(node *model.Node, name string) *model.Node {
+	n, err := node.FindByName(name)
+	if err != nil {
+		return nil
+	}
+
+	dups := n.Duplicates()
+	if len(dups) > 1 {
+		log.Warning.Printf("%d entries are named '%s', using the first one, the others are '%s'",
+			len(dups), name, strings.Join(displayNames(dups[1:]), "', '"))
+	}
+	return dups[0]
+}
+
+// findDuplicate returns the child addressed as name~N
+func findDuplicate(node *model.Node, name string) *model.Node {
+	i := strings.LastIndex(name, model.DuplicateSeparator)
+	if i < 0 {
+		return nil
+	}
+
+	index, err := strconv.Atoi(name[i+len(model.DuplicateSeparator):])
+	if err != nil || index < 2 {
+		return nil
+	}
+
+	n, err := node.FindByName(name[:i])
+	if err != nil {
+		return nil
+	}
+
+	dups := n.Duplicates()
+	if index > len(dups) {
+		return nil
+	}
+	return dups[index-1]
+}
+
+func displayNames(nodes []*model.Node) []string {
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, n.DisplayName())
+	}
+	return names
+}
+
+func findUnique(node *model.Node, name string, equal func(a, b string) bool) (*model.Node, error) {
+	var found []*model.Node
+	for _, n := range node.Children {
+		if equal(n.Name(), name) {
+			found = append(found, n)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+
+	names := displayNames(found)
+	sort.Strings(names)
+	return nil, fmt.Errorf("'%s' is ambiguous, it matches '%s'", name, strings.Join(names, "', '"))
+}
+
+// suggest returns the names of the children that look like name
+func suggest(node *model.Node, name string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	var candidates []candidate
+	for _, n := range node.Children {
+		d := 0
+		if normalizeName(n.Name()) != normalizeName(name) {
+			d = levenshtein(strings.ToLower(n.Name()), strings.ToLower(name))
+			if d > maxDistance {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate{n.DisplayName(), d})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	var result []string
+	for _, c := range candidates {
+		if len(result) == maxSuggestions {
+			break
+		}
+		result = append(result, c.name)
+	}
+	return result
+}
+
+// normalizeName lowercases name and drops everything but letters and digits
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
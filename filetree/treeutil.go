@@ -1,7 +1,9 @@
 package filetree
 
 import (
+	"errors"
 	"path"
+	"sort"
 
 	"github.com/juruen/rmapi/model"
 )
@@ -11,24 +13,84 @@ const (
 	ContinueVisiting = false
 )
 
-func WalkTree(node *model.Node, visitor FileTreeVistor) {
-	doWalkTree(node, make([]string, 0), visitor)
+// SkipDir can be returned by a WalkFunc to skip the children of the
+// directory being visited
+var SkipDir = errors.New("skip this directory")
+
+// SkipAll can be returned by a WalkFunc to stop the walk without error
+var SkipAll = errors.New("skip everything")
+
+// WalkFunc is called for each visited node. path holds the display names of
+// the node's ancestors starting at the walk root (empty for the root itself).
+// Returning an error other than SkipDir or SkipAll stops the walk and the
+// error is returned by Walk.
+type WalkFunc func(node *model.Node, path []string) error
+
+// WalkOptions restricts the nodes visited by Walk
+type WalkOptions struct {
+	// MaxDepth limits how deep the walk goes, the walk root is at depth 0
+	// and its children at depth 1. 0 means no limit.
+	MaxDepth int
+	// Filter selects the nodes passed to the WalkFunc. Directories that
+	// don't match are still descended, return SkipDir to prune them.
+	Filter func(node *model.Node) bool
 }
 
-func doWalkTree(node *model.Node, path []string, visitor FileTreeVistor) bool {
-	if visitor.Visit(node, path) {
-		return StopVisiting
+// Walk visits node and its descendants depth first, children in name order
+func Walk(node *model.Node, opts WalkOptions, fn WalkFunc) error {
+	err := doWalk(node, make([]string, 0), 0, opts, fn)
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func doWalk(node *model.Node, path []string, depth int, opts WalkOptions, fn WalkFunc) error {
+	if opts.Filter == nil || opts.Filter(node) {
+		if err := fn(node, path); err != nil {
+			return err
+		}
+	}
+
+	if opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+		return nil
 	}
 
-	newPath := appendEntryPath(path, node.Name())
+	newPath := appendEntryPath(path, node.DisplayName())
 
-	for _, c := range node.Children {
-		if doWalkTree(c, newPath, visitor) {
-			return StopVisiting
+	for _, c := range sortedChildren(node) {
+		err := doWalk(c, newPath, depth+1, opts, fn)
+		if err == SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
 		}
 	}
 
-	return ContinueVisiting
+	return nil
+}
+
+func sortedChildren(node *model.Node) []*model.Node {
+	children := node.Nodes()
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Name() != children[j].Name() {
+			return children[i].Name() < children[j].Name()
+		}
+		return children[i].Id() < children[j].Id()
+	})
+	return children
+}
+
+// WalkTree visits the tree with a FileTreeVistor, kept for compatibility,
+// use Walk instead
+func WalkTree(node *model.Node, visitor FileTreeVistor) {
+	Walk(node, WalkOptions{}, func(node *model.Node, path []string) error {
+		if visitor.Visit(node, path) {
+			return SkipAll
+		}
+		return nil
+	})
 }
 
 func appendEntryPath(currentPath []string, entry string) []string {
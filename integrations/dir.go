@@ -0,0 +1,97 @@
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/util"
+)
+
+// dir writes a Markdown note for each document, in folders mirroring the
+// ones of the cloud. The notes have a YAML front matter, as used by
+// Obsidian.
+type dir struct {
+	path string
+}
+
+func newDir(cfg config.ExportConfig) (Target, error) {
+	path := cfg.Dir.Path
+	if path == "" {
+		return nil, errors.New("dir: missing export.dir.path in the config file")
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return &dir{path: path}, nil
+}
+
+func (d *dir) Name() string { return "dir" }
+
+func (d *dir) Push(goCtx context.Context, docs []Document) error {
+	for _, doc := range docs {
+		if err := goCtx.Err(); err != nil {
+			return err
+		}
+		if doc.Empty() {
+			continue
+		}
+
+		notePath := filepath.Join(d.path, filepath.FromSlash(strings.TrimPrefix(doc.Path, "/"))+".md")
+		err := util.WriteFileAtomic(notePath, func(w io.Writer) error {
+			_, err := io.WriteString(w, markdownNote(doc))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownNote returns the note of a document
+func markdownNote(doc Document) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %q\n", doc.Title)
+	fmt.Fprintf(&b, "source: reMarkable\n")
+	fmt.Fprintf(&b, "path: %q\n", doc.Path)
+	if doc.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", doc.ID)
+	}
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n", doc.Title)
+
+	if len(doc.Highlights) > 0 {
+		b.WriteString("\n## Highlights\n")
+		page := 0
+		for _, h := range doc.Highlights {
+			if h.Page != page {
+				page = h.Page
+				fmt.Fprintf(&b, "\n### Page %d\n", page)
+			}
+			fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(h.Text, "\n", "\n> "))
+		}
+	}
+
+	pages, notes := notesByPage(doc.Notes)
+	if len(pages) > 0 {
+		b.WriteString("\n## Notes\n")
+		for _, page := range pages {
+			fmt.Fprintf(&b, "\n### Page %d\n", page)
+			for _, text := range notes[page] {
+				fmt.Fprintf(&b, "\n%s\n", text)
+			}
+		}
+	}
+	return b.String()
+}
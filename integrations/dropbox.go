@@ -0,0 +1,105 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/juruen/rmapi/config"
+)
+
+const (
+	dropboxUploadURL = "https://content.dropboxapi.com/2/files/upload"
+	dropboxTokenURL  = "https://api.dropboxapi.com/oauth2/token"
+	// larger files need an upload session
+	dropboxMaxUpload = 150 << 20
+)
+
+// dropbox uploads files with the Dropbox API, overwriting existing ones
+type dropbox struct {
+	url   string
+	root  string
+	token *oauthToken
+}
+
+func newDropbox(location string, cfg config.ExportConfig) (Sink, error) {
+	token, err := newOAuthToken(dropboxTokenURL, cfg.Dropbox)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox: %v in export.dropbox of the config file", err)
+	}
+	return &dropbox{url: dropboxUploadURL, root: path.Join("/", location), token: token}, nil
+}
+
+func (d *dropbox) Name() string { return "dropbox://" + d.root }
+
+func (d *dropbox) Put(goCtx context.Context, relPath, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() > dropboxMaxUpload {
+		return fmt.Errorf("dropbox: %s is larger than 150 MB", localPath)
+	}
+
+	token, err := d.token.token(goCtx)
+	if err != nil {
+		return fmt.Errorf("dropbox: %v", err)
+	}
+
+	arg, err := json.Marshal(map[string]interface{}{
+		"path":       path.Join(d.root, relPath),
+		"mode":       "overwrite",
+		"autorename": false,
+		"mute":       true,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(goCtx, http.MethodPost, d.url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", asciiJSON(string(arg)))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("dropbox: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("dropbox: %s: %v", relPath, err)
+	}
+	return nil
+}
+
+// asciiJSON escapes the non ASCII characters of a JSON document, HTTP
+// headers can't hold them
+func asciiJSON(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case r > 0xffff:
+			// surrogate pair
+			r -= 0x10000
+			fmt.Fprintf(&b, "\\u%04x\\u%04x", 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		default:
+			fmt.Fprintf(&b, "\\u%04x", r)
+		}
+	}
+	return b.String()
+}
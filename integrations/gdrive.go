@@ -0,0 +1,229 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/juruen/rmapi/config"
+)
+
+const (
+	driveURL        = "https://www.googleapis.com"
+	driveTokenURL   = "https://oauth2.googleapis.com/token"
+	driveFolderMIME = "application/vnd.google-apps.folder"
+)
+
+// drive uploads files in a Google Drive folder, creating the sub folders,
+// and updates the files already there instead of adding duplicates
+type drive struct {
+	url string
+	// folder is the id of the folder of the sink
+	folder string
+	// subPath are the folders under folder of the location
+	subPath string
+	token   *oauthToken
+
+	mu sync.Mutex
+	// folders caches the ids of the folders by path
+	folders map[string]string
+}
+
+func newDrive(location string, cfg config.ExportConfig) (Sink, error) {
+	folder, subPath, _ := strings.Cut(location, "/")
+	if folder == "" {
+		folder = "root"
+	}
+	token, err := newOAuthToken(driveTokenURL, cfg.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("gdrive: %v in export.gdrive of the config file", err)
+	}
+	return &drive{
+		url:     driveURL,
+		folder:  folder,
+		subPath: subPath,
+		token:   token,
+		folders: map[string]string{"": folder},
+	}, nil
+}
+
+func (d *drive) Name() string { return "gdrive://" + path.Join(d.folder, d.subPath) }
+
+func (d *drive) Put(goCtx context.Context, relPath, localPath string) error {
+	fullPath := path.Join(d.subPath, relPath)
+	parent, err := d.folderID(goCtx, path.Dir(fullPath))
+	if err != nil {
+		return fmt.Errorf("gdrive: %v", err)
+	}
+
+	name := path.Base(fullPath)
+	existing, err := d.find(goCtx, name, parent, false)
+	if err != nil {
+		return fmt.Errorf("gdrive: %v", err)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(localPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if existing != "" {
+		err = d.call(goCtx, http.MethodPatch, "/upload/drive/v3/files/"+existing+"?uploadType=media", contentType, f, nil)
+	} else {
+		var body io.Reader
+		var multipartType string
+		body, multipartType, err = driveMultipart(map[string]interface{}{"name": name, "parents": []string{parent}}, contentType, f)
+		if err != nil {
+			return err
+		}
+		err = d.call(goCtx, http.MethodPost, "/upload/drive/v3/files?uploadType=multipart", multipartType, body, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("gdrive: %s: %v", relPath, err)
+	}
+	return nil
+}
+
+// folderID returns the id of a folder given by its path under the folder
+// of the sink, creating the missing folders
+func (d *drive) folderID(goCtx context.Context, dir string) (string, error) {
+	if dir == "." || dir == "/" {
+		dir = ""
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.folder
+	current := ""
+	for _, name := range strings.Split(dir, "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		if cached, ok := d.folders[current]; ok {
+			id = cached
+			continue
+		}
+
+		found, err := d.find(goCtx, name, id, true)
+		if err != nil {
+			return "", err
+		}
+		if found == "" {
+			meta, err := json.Marshal(map[string]interface{}{"name": name, "mimeType": driveFolderMIME, "parents": []string{id}})
+			if err != nil {
+				return "", err
+			}
+			var created struct {
+				ID string `json:"id"`
+			}
+			if err := d.call(goCtx, http.MethodPost, "/drive/v3/files", "application/json", bytes.NewReader(meta), &created); err != nil {
+				return "", err
+			}
+			found = created.ID
+		}
+		d.folders[current] = found
+		id = found
+	}
+	return id, nil
+}
+
+// find returns the id of the file or folder with the given name in a
+// folder, empty if there is none
+func (d *drive) find(goCtx context.Context, name, parent string, folder bool) (string, error) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", quote.Replace(name), quote.Replace(parent))
+	if folder {
+		q += " and mimeType = '" + driveFolderMIME + "'"
+	} else {
+		q += " and mimeType != '" + driveFolderMIME + "'"
+	}
+
+	var result struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	query := url.Values{"q": {q}, "fields": {"files(id)"}, "spaces": {"drive"}}
+	if err := d.call(goCtx, http.MethodGet, "/drive/v3/files?"+query.Encode(), "", nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.Files) == 0 {
+		return "", nil
+	}
+	return result.Files[0].ID, nil
+}
+
+func (d *drive) call(goCtx context.Context, method, path, contentType string, body io.Reader, result interface{}) error {
+	token, err := d.token.token(goCtx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(goCtx, method, d.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// driveMultipart returns the body of a multipart upload: the metadata of
+// the file and its content
+func driveMultipart(meta interface{}, contentType string, content io.Reader) (io.Reader, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	metaPart, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewEncoder(metaPart).Encode(meta); err != nil {
+		return nil, "", err
+	}
+
+	mediaPart, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(mediaPart, content); err != nil {
+		return nil, "", err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, "multipart/related; boundary=" + mw.Boundary(), nil
+}
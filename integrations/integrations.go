@@ -0,0 +1,87 @@
+// Package integrations pushes the highlights and notes of documents to note
+// taking apps: Readwise, Notion or a folder of Markdown notes (e.g. an
+// Obsidian vault), and sends exported files by email or to cloud storages
+// (S3, Google Drive, Dropbox).
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/rmconvert"
+)
+
+// Document is what is exported of a document
+type Document struct {
+	ID    string
+	Title string
+	// Path is the path of the document in the cloud
+	Path       string
+	Highlights []rmconvert.Highlight
+	// Notes are the typed text and the recognised handwriting of the pages
+	Notes []rmconvert.PageText
+}
+
+// Empty reports whether the document has nothing to export
+func (d *Document) Empty() bool {
+	return len(d.Highlights) == 0 && len(d.Notes) == 0
+}
+
+// Target is a destination of the export
+type Target interface {
+	Name() string
+	Push(goCtx context.Context, docs []Document) error
+}
+
+var targets = map[string]func(cfg config.ExportConfig) (Target, error){
+	"readwise": newReadwise,
+	"notion":   newNotion,
+	"dir":      newDir,
+}
+
+// TargetNames returns the names of the targets, sorted
+func TargetNames() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTarget returns the target with the given name, configured with cfg
+func NewTarget(name string, cfg config.ExportConfig) (Target, error) {
+	newTarget, ok := targets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %s, available targets: %s", name, strings.Join(TargetNames(), ", "))
+	}
+	return newTarget(cfg)
+}
+
+// httpClient is used for the calls to the APIs of the services
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// notesByPage groups the notes by page, typed text first
+func notesByPage(notes []rmconvert.PageText) ([]int, map[int][]string) {
+	byPage := make(map[int][]string)
+	var pages []int
+	sorted := append([]rmconvert.PageText(nil), notes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Source > sorted[j].Source })
+	for _, n := range sorted {
+		text := strings.TrimSpace(n.Text)
+		if text == "" {
+			continue
+		}
+		if _, ok := byPage[n.Page]; !ok {
+			pages = append(pages, n.Page)
+		}
+		byPage[n.Page] = append(byPage[n.Page], text)
+	}
+	sort.Ints(pages)
+	return pages, byPage
+}
@@ -0,0 +1,184 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/rmconvert"
+)
+
+var testDoc = Document{
+	ID:    "abc",
+	Title: "Book",
+	Path:  "/Reading/Book",
+	Highlights: []rmconvert.Highlight{
+		{Page: 3, Text: "a passage", Color: "yellow"},
+	},
+	Notes: []rmconvert.PageText{
+		{Page: 1, Source: rmconvert.TextSourceOCR, Text: "handwriting"},
+		{Page: 1, Source: rmconvert.TextSourceTyped, Text: "typed"},
+	},
+}
+
+func TestNewTarget(t *testing.T) {
+	if _, err := NewTarget("readwise", config.ExportConfig{}); err == nil {
+		t.Errorf("expected an error without a token")
+	}
+	if _, err := NewTarget("evernote", config.ExportConfig{}); err == nil || !strings.Contains(err.Error(), "notion") {
+		t.Errorf("expected an error listing the targets, got %v", err)
+	}
+}
+
+func TestDirPush(t *testing.T) {
+	root := t.TempDir()
+	target, err := NewTarget("dir", config.ExportConfig{Dir: config.DirConfig{Path: root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty := Document{Title: "Empty", Path: "/Empty"}
+	if err := target.Push(context.Background(), []Document{testDoc, empty}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "Reading", "Book.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "---\ntitle: \"Book\"\nsource: reMarkable\npath: \"/Reading/Book\"\nid: abc\n---\n\n# Book\n\n" +
+		"## Highlights\n\n### Page 3\n\n> a passage\n\n## Notes\n\n### Page 1\n\ntyped\n\nhandwriting\n"
+	if string(data) != want {
+		t.Errorf("unexpected note:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Empty.md")); err == nil {
+		t.Errorf("note written for an empty document")
+	}
+}
+
+func TestReadwisePush(t *testing.T) {
+	var got struct {
+		Highlights []readwiseHighlight `json:"highlights"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	target := &readwise{url: server.URL, token: "secret", category: "books"}
+	if err := target.Push(context.Background(), []Document{testDoc}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Highlights) != 1 || got.Highlights[0].Title != "Book" || got.Highlights[0].Location != 3 {
+		t.Errorf("unexpected highlights %+v", got.Highlights)
+	}
+
+	target.token = "wrong"
+	if err := target.Push(context.Background(), []Document{testDoc}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestNotionPush(t *testing.T) {
+	doc := testDoc
+	doc.Highlights = nil
+	for i := 0; i < 150; i++ {
+		doc.Highlights = append(doc.Highlights, rmconvert.Highlight{Page: 1, Text: "passage"})
+	}
+
+	var blocks int
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		var body struct {
+			Children []interface{} `json:"children"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		blocks += len(body.Children)
+		w.Write([]byte(`{"id": "page-id"}`))
+	}))
+	defer server.Close()
+
+	target := &notion{url: server.URL, token: "secret", database: "db", titleProperty: "Name"}
+	if err := target.Push(context.Background(), []Document{doc}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 headings and 150 quotes, then the notes: a heading, a page
+	// heading and 2 paragraphs
+	if blocks != 156 {
+		t.Errorf("expected 156 blocks, got %d", blocks)
+	}
+	if strings.Join(calls, ",") != "POST /pages,PATCH /blocks/page-id/children" {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestMailerMessage(t *testing.T) {
+	pdfPath := filepath.Join(t.TempDir(), "Notes.pdf")
+	content := bytes.Repeat([]byte("%PDF-1.7 "), 20)
+	if err := os.WriteFile(pdfPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMailer(config.EmailConfig{Host: "localhost", Username: "me@example.com", To: []string{"you@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.message("Notes", []string{pdfPath}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("From") != "me@example.com" || msg.Header.Get("To") != "you@example.com" {
+		t.Errorf("wrong headers %v", msg.Header)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var attachment []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() == "Notes.pdf" {
+			attachment, _ = io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		}
+	}
+	if !bytes.Equal(attachment, content) {
+		t.Errorf("attachment not preserved: %q", attachment)
+	}
+
+	m.cfg.MaxSize = 0
+	if _, err := m.message("Notes", []string{pdfPath}, time.Now()); err == nil {
+		t.Errorf("expected an error for attachments over the limit")
+	}
+}
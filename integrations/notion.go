@@ -0,0 +1,168 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/juruen/rmapi/config"
+)
+
+const (
+	notionURL     = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+	// limits of the Notion API
+	notionMaxBlocks = 100
+	notionMaxText   = 2000
+)
+
+// notion creates a page in a database for each document, with the
+// highlights as quotes and the notes as paragraphs
+type notion struct {
+	url           string
+	token         string
+	database      string
+	titleProperty string
+}
+
+func newNotion(cfg config.ExportConfig) (Target, error) {
+	if cfg.Notion.Token == "" || cfg.Notion.Database == "" {
+		return nil, errors.New("notion: missing export.notion.token or export.notion.database in the config file")
+	}
+	titleProperty := cfg.Notion.TitleProperty
+	if titleProperty == "" {
+		titleProperty = "Name"
+	}
+	return &notion{url: notionURL, token: cfg.Notion.Token, database: cfg.Notion.Database, titleProperty: titleProperty}, nil
+}
+
+func (n *notion) Name() string { return "notion" }
+
+func (n *notion) Push(goCtx context.Context, docs []Document) error {
+	for _, doc := range docs {
+		if doc.Empty() {
+			continue
+		}
+		if err := n.pushDocument(goCtx, doc); err != nil {
+			return fmt.Errorf("notion: %s: %v", doc.Path, err)
+		}
+	}
+	return nil
+}
+
+func (n *notion) pushDocument(goCtx context.Context, doc Document) error {
+	blocks := notionBlocks(doc)
+
+	first := blocks
+	if len(first) > notionMaxBlocks {
+		first = first[:notionMaxBlocks]
+	}
+	page := map[string]interface{}{
+		"parent": map[string]string{"database_id": n.database},
+		"properties": map[string]interface{}{
+			n.titleProperty: map[string]interface{}{"title": notionText(doc.Title)},
+		},
+		"children": first,
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := n.call(goCtx, http.MethodPost, "/pages", page, &created); err != nil {
+		return err
+	}
+
+	// the blocks that don't fit in the creation request are appended
+	for start := notionMaxBlocks; start < len(blocks); start += notionMaxBlocks {
+		end := min(start+notionMaxBlocks, len(blocks))
+		children := map[string]interface{}{"children": blocks[start:end]}
+		if err := n.call(goCtx, http.MethodPatch, "/blocks/"+created.ID+"/children", children, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *notion) call(goCtx context.Context, method, path string, payload, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(goCtx, method, n.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Notion-Version", notionVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// notionBlocks returns the content of the page of a document
+func notionBlocks(doc Document) []interface{} {
+	block := func(kind, text string) interface{} {
+		return map[string]interface{}{
+			"object": "block",
+			"type":   kind,
+			kind:     map[string]interface{}{"rich_text": notionText(text)},
+		}
+	}
+
+	var blocks []interface{}
+	if len(doc.Highlights) > 0 {
+		blocks = append(blocks, block("heading_2", "Highlights"))
+		page := 0
+		for _, h := range doc.Highlights {
+			if h.Page != page {
+				page = h.Page
+				blocks = append(blocks, block("heading_3", fmt.Sprintf("Page %d", page)))
+			}
+			blocks = append(blocks, block("quote", h.Text))
+		}
+	}
+
+	pages, notes := notesByPage(doc.Notes)
+	if len(pages) > 0 {
+		blocks = append(blocks, block("heading_2", "Notes"))
+		for _, page := range pages {
+			blocks = append(blocks, block("heading_3", fmt.Sprintf("Page %d", page)))
+			for _, text := range notes[page] {
+				blocks = append(blocks, block("paragraph", text))
+			}
+		}
+	}
+	return blocks
+}
+
+// notionText returns a rich text, split in the pieces of at most
+// notionMaxText characters accepted by the API
+func notionText(s string) []interface{} {
+	var result []interface{}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		n := min(len(runes), notionMaxText)
+		result = append(result, map[string]interface{}{
+			"type": "text",
+			"text": map[string]string{"content": string(runes[:n])},
+		})
+		runes = runes[n:]
+	}
+	if result == nil {
+		result = []interface{}{}
+	}
+	return result
+}
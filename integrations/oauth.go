@@ -0,0 +1,102 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+)
+
+// oauthToken returns access tokens, refreshed when they expire
+type oauthToken struct {
+	tokenURL     string
+	refreshToken string
+	clientID     string
+	clientSecret string
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func newOAuthToken(tokenURL string, cfg config.OAuthConfig) (*oauthToken, error) {
+	t := &oauthToken{
+		tokenURL:     tokenURL,
+		refreshToken: cfg.RefreshToken,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		accessToken:  cfg.AccessToken,
+	}
+	if t.clientID == "" {
+		t.clientID, t.clientSecret = cfg.AppKey, cfg.AppSecret
+	}
+	if t.refreshToken != "" && t.clientID == "" {
+		return nil, errors.New("a refresh token needs the client credentials")
+	}
+	if t.refreshToken == "" && t.accessToken == "" {
+		return nil, errors.New("missing access_token or refresh_token")
+	}
+	return t, nil
+}
+
+// token returns a valid access token
+func (t *oauthToken) token(goCtx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.refreshToken == "" || (t.accessToken != "" && time.Now().Before(t.expiry)) {
+		return t.accessToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.refreshToken},
+		"client_id":     {t.clientID},
+		"client_secret": {t.clientSecret},
+	}
+	req, err := http.NewRequestWithContext(goCtx, http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("token refresh failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	t.accessToken = result.AccessToken
+	// refreshed a minute early, the token shouldn't expire during a call
+	t.expiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return t.accessToken, nil
+}
+
+// checkResponse returns an error with the start of the body for the
+// responses other than 2xx
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
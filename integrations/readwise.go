@@ -0,0 +1,87 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/juruen/rmapi/config"
+)
+
+const readwiseURL = "https://readwise.io/api/v2/highlights/"
+
+// readwise creates the highlights with the Readwise API, which ignores the
+// ones it already has
+type readwise struct {
+	url      string
+	token    string
+	category string
+}
+
+func newReadwise(cfg config.ExportConfig) (Target, error) {
+	if cfg.Readwise.Token == "" {
+		return nil, errors.New("readwise: missing export.readwise.token in the config file")
+	}
+	category := cfg.Readwise.Category
+	if category == "" {
+		category = "books"
+	}
+	return &readwise{url: readwiseURL, token: cfg.Readwise.Token, category: category}, nil
+}
+
+func (r *readwise) Name() string { return "readwise" }
+
+type readwiseHighlight struct {
+	Text         string `json:"text"`
+	Title        string `json:"title"`
+	SourceType   string `json:"source_type"`
+	Category     string `json:"category"`
+	Location     int    `json:"location"`
+	LocationType string `json:"location_type"`
+	Note         string `json:"note,omitempty"`
+}
+
+// Push sends the highlights, notes are not exported as Readwise only holds
+// highlights
+func (r *readwise) Push(goCtx context.Context, docs []Document) error {
+	var highlights []readwiseHighlight
+	for _, doc := range docs {
+		for _, h := range doc.Highlights {
+			highlights = append(highlights, readwiseHighlight{
+				Text:         h.Text,
+				Title:        doc.Title,
+				SourceType:   "rmapi",
+				Category:     r.category,
+				Location:     h.Page,
+				LocationType: "page",
+			})
+		}
+	}
+	if len(highlights) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"highlights": highlights})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(goCtx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+r.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("readwise: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("readwise: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package integrations
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+)
+
+// s3 uploads files with PUT requests signed with AWS signature version 4
+type s3 struct {
+	bucket string
+	prefix string
+	region string
+	// endpoint is set for S3 compatible services, addressed by path
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func newS3(location string, cfg config.ExportConfig) (Sink, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, errors.New("s3: missing bucket, use s3://bucket/prefix")
+	}
+
+	env := func(value, name string) string {
+		if value != "" {
+			return value
+		}
+		return os.Getenv(name)
+	}
+	s := &s3{
+		bucket:          bucket,
+		prefix:          prefix,
+		region:          env(cfg.S3.Region, "AWS_REGION"),
+		endpoint:        strings.TrimSuffix(cfg.S3.Endpoint, "/"),
+		accessKeyID:     env(cfg.S3.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		secretAccessKey: env(cfg.S3.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    env(cfg.S3.SessionToken, "AWS_SESSION_TOKEN"),
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKeyID == "" || s.secretAccessKey == "" {
+		return nil, errors.New("s3: missing export.s3.access_key_id or export.s3.secret_access_key in the config file")
+	}
+	return s, nil
+}
+
+func (s *s3) Name() string { return "s3://" + path.Join(s.bucket, s.prefix) }
+
+// objectURL returns the URL of an object, virtual hosted style for AWS
+func (s *s3) objectURL(key string) string {
+	escaped := s3EscapePath("/" + key)
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s.bucket + escaped
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s.bucket, s.region, escaped)
+}
+
+func (s *s3) Put(goCtx context.Context, relPath, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the payload hash is part of the signature
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := path.Join(s.prefix, relPath)
+	req, err := http.NewRequestWithContext(goCtx, http.MethodPut, s.objectURL(key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType := mime.TypeByExtension(filepath.Ext(localPath)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	s.sign(req, hex.EncodeToString(hash.Sum(nil)), time.Now())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("s3: %s: %v", key, err)
+	}
+	return nil
+}
+
+// sign adds the AWS signature version 4 of the request, its headers are
+// all signed
+func (s *s3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+// s3EscapePath escapes every character of a path but the unreserved ones
+// and the slashes, as in the canonical request
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
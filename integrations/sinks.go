@@ -0,0 +1,53 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/juruen/rmapi/config"
+)
+
+// Sink stores exported files in a cloud storage
+type Sink interface {
+	// Name returns the URL of the sink
+	Name() string
+	// Put uploads a local file to relPath, a slash separated path relative
+	// to the location of the sink, replacing any file already there
+	Put(goCtx context.Context, relPath, localPath string) error
+}
+
+var sinks = map[string]func(location string, cfg config.ExportConfig) (Sink, error){
+	"s3":      newS3,
+	"gdrive":  newDrive,
+	"dropbox": newDropbox,
+}
+
+// SinkSchemes returns the URL schemes of the sinks, sorted
+func SinkSchemes() []string {
+	schemes := make([]string, 0, len(sinks))
+	for scheme := range sinks {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewSink returns the sink of a URL:
+//   - s3://bucket/prefix
+//   - gdrive://folder-id/sub/folders, root for the root of My Drive
+//   - dropbox:///path
+func NewSink(sinkURL string, cfg config.ExportConfig) (Sink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad sink %s: %v", sinkURL, err)
+	}
+	newSink, ok := sinks[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %s, the supported schemes are %s", sinkURL, strings.Join(SinkSchemes(), ", "))
+	}
+	return newSink(path.Join(u.Host, u.Path), cfg)
+}
@@ -0,0 +1,121 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+)
+
+// Events sent to webhooks
+const (
+	EventDocumentConverted = "document.converted"
+	EventDocumentFailed    = "document.failed"
+	EventSyncCompleted     = "sync.completed"
+)
+
+// WebhookEvent is the JSON payload posted to a webhook
+type WebhookEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Document, Pages and Outputs are set for the document events
+	Document *WebhookDocument `json:"document,omitempty"`
+	Pages    int              `json:"pages,omitempty"`
+	// Outputs are the local paths of the files written
+	Outputs  []string `json:"outputs,omitempty"`
+	Duration float64  `json:"duration_seconds"`
+	// Error is the error of a failed document
+	Error string `json:"error,omitempty"`
+	// Summary is set for sync.completed
+	Summary *WebhookSummary `json:"summary,omitempty"`
+}
+
+// WebhookDocument identifies the document of an event
+type WebhookDocument struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Path is the path in the cloud
+	Path string `json:"path"`
+}
+
+// WebhookSummary counts the documents processed by a sync
+type WebhookSummary struct {
+	Processed int            `json:"processed"`
+	OK        int            `json:"ok"`
+	Failed    int            `json:"failed"`
+	Errors    []WebhookError `json:"errors,omitempty"`
+}
+
+// WebhookError is a failure of a sync
+type WebhookError struct {
+	Path  string `json:"path"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// Webhook posts events to a URL
+type Webhook struct {
+	url    string
+	secret string
+}
+
+// NewWebhook returns the webhook of the config, url overrides the one of
+// the config if not empty
+func NewWebhook(url string, cfg config.WebhookConfig) (*Webhook, error) {
+	if url == "" {
+		url = cfg.URL
+	}
+	if url == "" {
+		return nil, errors.New("webhook: missing url, set export.webhook.url in the config file")
+	}
+	return &Webhook{url: url, secret: cfg.Secret}, nil
+}
+
+// Send posts an event. With a secret, the X-Rmapi-Signature header holds
+// sha256= and the hex HMAC-SHA256 of the body.
+func (w *Webhook) Send(goCtx context.Context, event WebhookEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(goCtx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Rmapi-Event", event.Event)
+	if w.secret != "" {
+		req.Header.Set("X-Rmapi-Signature", "sha256="+hex.EncodeToString(hmacSHA256([]byte(w.secret), string(body))))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	return nil
+}
+
+// VerifySignature reports whether signature is the X-Rmapi-Signature of
+// body, for the receivers written in Go
+func VerifySignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
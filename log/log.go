@@ -1,9 +1,13 @@
 package log
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
@@ -15,6 +19,41 @@ var (
 	TraceLevel     int
 )
 
+// Level is the verbosity of the loggers, each level includes the ones below
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarning
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = map[Level]string{
+	LevelError:   "error",
+	LevelWarning: "warn",
+	LevelInfo:    "info",
+	LevelDebug:   "debug",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name (error, warn, info or debug)
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(s)
+	if s == "warning" {
+		s = "warn"
+	}
+	for level, name := range levelNames {
+		if name == s {
+			return level, nil
+		}
+	}
+	return LevelWarning, fmt.Errorf("unknown log level: %s", s)
+}
+
 func Init(
 	traceHandle io.Writer,
 	infoHandle io.Writer,
@@ -42,17 +81,80 @@ func init() {
 }
 
 func InitLog() {
-	info := io.Discard
-	trace := io.Discard
-	traceRmapi := os.Getenv("RMAPI_TRACE")
-	switch traceRmapi {
+	Setup(EnvLevel(), false)
+}
+
+// EnvLevel returns the level set by RMAPI_TRACE (1: debug, 2: info), warn by default
+func EnvLevel() Level {
+	switch os.Getenv("RMAPI_TRACE") {
 	case "1":
-		TracingEnabled = true
-		trace = os.Stdout
-		fallthrough
+		return LevelDebug
 	case "2":
-		info = os.Stdout
+		return LevelInfo
+	}
+	return LevelWarning
+}
+
+// Setup configures the loggers to output messages up to the given level,
+// either as text or as one JSON object per line
+// ({"time": ..., "level": ..., "msg": ...}) for automation.
+func Setup(level Level, jsonFormat bool) {
+	TracingEnabled = level >= LevelDebug
+
+	handles := map[Level]io.Writer{
+		LevelDebug:   os.Stdout,
+		LevelInfo:    os.Stdout,
+		LevelWarning: os.Stdout,
+		LevelError:   os.Stderr,
+	}
+	for l := range handles {
+		if l > level {
+			handles[l] = io.Discard
+		}
+	}
+
+	if !jsonFormat {
+		Init(handles[LevelDebug], handles[LevelInfo], handles[LevelWarning], handles[LevelError])
+		return
+	}
+
+	Trace = log.New(newJSONWriter(handles[LevelDebug], LevelDebug), "", 0)
+	Info = log.New(newJSONWriter(handles[LevelInfo], LevelInfo), "", 0)
+	Warning = log.New(newJSONWriter(handles[LevelWarning], LevelWarning), "", 0)
+	Error = log.New(newJSONWriter(handles[LevelError], LevelError), "", 0)
+}
+
+type jsonEntry struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// jsonWriter wraps each message written by a log.Logger in a JSON line
+type jsonWriter struct {
+	out   io.Writer
+	level Level
+}
+
+func newJSONWriter(out io.Writer, level Level) io.Writer {
+	if out == io.Discard {
+		return out
 	}
+	return &jsonWriter{out: out, level: level}
+}
 
-	Init(trace, info, os.Stdout, os.Stderr)
+func (w *jsonWriter) Write(p []byte) (int, error) {
+	entry := jsonEntry{
+		Time:  time.Now().Format(time.RFC3339),
+		Level: w.level.String(),
+		Msg:   strings.TrimSuffix(string(p), "\n"),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{
+		"error":   LevelError,
+		"warn":    LevelWarning,
+		"WARNING": LevelWarning,
+		"info":    LevelInfo,
+		"debug":   LevelDebug,
+	} {
+		got, err := ParseLevel(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(newJSONWriter(&buf, LevelWarning), "", 0)
+	logger.Printf("page %d not found", 3)
+
+	var entry jsonEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid json %q: %v", buf.String(), err)
+	}
+	if entry.Level != "warn" || entry.Msg != "page 3 not found" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
@@ -1,19 +1,47 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/juruen/rmapi/api"
 	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/filetree"
 	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/rmconvert"
 	"github.com/juruen/rmapi/shell"
+	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/util"
+	"github.com/juruen/rmapi/vault"
 	"github.com/juruen/rmapi/version"
 )
 
 const AUTH_RETRIES = 3
 
+// loadCalibration sets the calibration of the drawing tools of the config
+// file, see config.LoadCalibration
+func loadCalibration() error {
+	configPath, err := config.ConfigPath()
+	if err != nil {
+		return nil
+	}
+	tools, err := config.LoadCalibration(configPath)
+	if err != nil {
+		return err
+	}
+	return rmconvert.SetCalibration(tools)
+}
+
 func parseOfflineCommands(cmd []string) bool {
 	if len(cmd) == 0 {
 		return false
@@ -25,6 +53,12 @@ func parseOfflineCommands(cmd []string) bool {
 		if err != nil {
 			log.Error.Fatalln(err)
 		}
+		// the key of the encrypted storage goes with the config file
+		if settings, err := config.LoadEncryption(configFile); err == nil && settings != nil {
+			if err := vault.Remove(settings); err != nil {
+				log.Warning.Printf("failed to remove the key from the keychain: %v", err)
+			}
+		}
 		if err := os.Remove(configFile); err != nil {
 			log.Error.Fatalln(err)
 		}
@@ -32,28 +66,266 @@ func parseOfflineCommands(cmd []string) bool {
 	case "version":
 		fmt.Println(version.Version)
 		return true
+	case "bench":
+		// hidden: measures the conversions on a sample document
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunBench(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "ocr":
+		// local files only, no need to log in
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunOCR(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "templates":
+		// reaches the tablet over SSH, no need to log in
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunTemplates(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "screenshot":
+		// reaches the tablet over SSH, no need to log in
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunScreenshot(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "queue":
+		// only flushing the queue needs the cloud
+		if len(cmd) > 1 && cmd[1] == "flush" {
+			return false
+		}
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunQueue(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "encrypt":
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunEncrypt(goCtx, cmd[1:]); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return true
+	case "completion":
+		if len(cmd) < 2 {
+			log.Error.Fatalf("missing shell, use one of %s", strings.Join(shell.CompletionShells, ", "))
+		}
+		script, err := shell.CompletionScript(cmd[1])
+		if err != nil {
+			log.Error.Fatalln(err)
+		}
+		fmt.Print(script)
+		return true
+	case "__complete":
+		// called by the completion scripts, remote paths come from the
+		// tree cache so that completion doesn't wait for the cloud
+		if len(cmd) < 2 {
+			return true
+		}
+		if err := unlockStorage(false); err != nil {
+			log.Trace.Println(err)
+		}
+		tree, err := api.CachedFileTree()
+		if err != nil {
+			log.Trace.Println(err)
+		}
+		for _, candidate := range shell.Complete(tree, cmd[1], cmd[2:]) {
+			fmt.Println(candidate)
+		}
+		return true
+	}
+	return false
+}
+
+// unlockStorage sets the key of the encrypted tokens and caches, see
+// 'rmapi encrypt'. The passphrase is asked only when interactive.
+func unlockStorage(interactive bool) error {
+	configPath, err := config.ConfigPath()
+	if err != nil {
+		return nil
+	}
+	settings, err := config.LoadEncryption(configPath)
+	if err != nil || settings == nil {
+		return err
+	}
+	var ask func() (string, error)
+	if interactive {
+		ask = shell.AskPassphrase
+	}
+	key, err := vault.Unlock(settings, ask)
+	if err != nil {
+		return err
+	}
+	vault.DefaultKey = key
+	return nil
+}
+
+// queuedCommand tells whether a command is a put or a cp that can be
+// queued while the cloud is unreachable
+func queuedCommand(cmd []string) bool {
+	if len(cmd) == 0 || !slices.Contains(shell.QueuedCommands, cmd[0]) {
+		return false
+	}
+	for _, arg := range cmd[1:] {
+		switch arg {
+		case "-queue", "--queue", "-queue=true", "--queue=true":
+			return true
+		}
 	}
 	return false
 }
 
+// envFloat returns the number in an environment variable, 0 if unset
+func envFloat(name string) float64 {
+	v, _ := strconv.ParseFloat(os.Getenv(name), 64)
+	return v
+}
+
+// printHint prints what to do about an error, if anything
+func printHint(err error) {
+	if hint := shell.ErrorHint(err); hint != "" {
+		fmt.Fprintln(os.Stderr, "Hint:", hint)
+	}
+}
+
+// setupTransport sets the http options of the cloud client
+func setupTransport(timeout time.Duration, proxy string) error {
+	if timeout == 0 {
+		timeout = -1
+	}
+	transport.DefaultOptions.Timeout = timeout
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy url: %s", proxy)
+		}
+		transport.DefaultOptions.Proxy = proxyURL
+	}
+	return nil
+}
+
+func setupLogging(levelName, format string, verbose, quiet bool) error {
+	if quiet {
+		util.ProgressEnabled = false
+	}
+
+	level := log.EnvLevel()
+	switch {
+	case levelName != "":
+		var err error
+		level, err = log.ParseLevel(levelName)
+		if err != nil {
+			return err
+		}
+	case verbose:
+		level = log.LevelInfo
+	case quiet:
+		level = log.LevelError
+	}
+
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+
+	log.Setup(level, format == "json")
+	return nil
+}
+
+// runScript runs the commands of a script file, see shell.RunScript
+func runScript(goCtx context.Context, apiCtx api.ApiCtx, userInfo *api.UserInfo, path string, keepGoing bool) error {
+	if path == "-" {
+		return shell.RunScript(goCtx, apiCtx, userInfo, os.Stdin, "stdin", keepGoing)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return shell.RunScript(goCtx, apiCtx, userInfo, f, path, keepGoing)
+}
+
 func main() {
 	ni := flag.Bool("ni", false, "not interactive (prevents asking for code)")
+	verbose := flag.Bool("verbose", false, "log informational messages")
+	quiet := flag.Bool("quiet", false, "only log errors and don't display transfer progress")
+	logLevel := flag.String("log-level", "", "log level: error, warn, info or debug (default: warn, or RMAPI_TRACE)")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	pathMatch := flag.String("path-match", os.Getenv("RMAPI_PATH_MATCH"), "remote name matching: exact, icase or fuzzy")
+	httpTimeout := flag.Duration("http-timeout", 5*time.Minute, "timeout of a cloud request including its transfer, 0 for none")
+	proxy := flag.String("proxy", "", "proxy URL for the cloud requests (default HTTPS_PROXY)")
+	rps := flag.Float64("rps", envFloat("RMAPI_RPS"), "max requests per second to the cloud, 0 for no limit (default RMAPI_RPS)")
+	maxMemory := flag.String("max-memory", os.Getenv("RMAPI_MAX_MEMORY"), "memory budget of the page rendering, e.g. 512M or 2G, 0 for no limit (default RMAPI_MAX_MEMORY)")
+	fontPath := flag.String("font", os.Getenv("RMAPI_FONT"), "TrueType or OpenType font file of the typed text and the OCR text layer (default RMAPI_FONT, or the bundled DejaVu Sans)")
+	script := flag.String("f", "", "run the commands of a script file, one per line (- for stdin)")
+	keepGoing := flag.Bool("continue", false, "with -f, run the remaining commands when one fails")
 	flag.Usage = func() {
 		fmt.Println(`
+  (none)	starts the interactive shell
   help		detailed commands, but the user needs to be logged in
 
 Offline Commands:
   version	prints the version
+  completion	prints the completion script of a shell (bash, zsh, fish, powershell)
+  encrypt	encrypts the tokens and caches stored on disk (on, off, status)
   reset		removes the config file `)
 
 		flag.PrintDefaults()
 	}
 	flag.Parse()
+	if err := setupLogging(*logLevel, *logFormat, *verbose, *quiet); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if match, err := filetree.ParsePathMatch(*pathMatch); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	} else {
+		filetree.DefaultPathMatch = match
+	}
+	if budget, err := util.ParseSize(*maxMemory); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -max-memory:", err)
+		os.Exit(1)
+	} else {
+		rmconvert.MemoryBudget = budget
+	}
+	if *fontPath != "" {
+		font, err := rmconvert.LoadFont(*fontPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid -font:", err)
+			os.Exit(1)
+		}
+		rmconvert.TextFont = font
+	}
+	if err := loadCalibration(); err != nil {
+		log.Warning.Printf("ignoring the tools calibration of the config file: %v", err)
+	}
+	transport.SetRateLimit(*rps, transport.MaxConcurrent)
+	if err := setupTransport(*httpTimeout, *proxy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	otherFlags := flag.Args()
 	if parseOfflineCommands(otherFlags) {
 		return
 	}
 
+	if err := unlockStorage(!*ni); err != nil {
+		log.Error.Println("failed to unlock the encrypted storage: ", err)
+		printHint(err)
+		os.Exit(1)
+	}
+
 	var ctx api.ApiCtx
 	var err error
 	var userInfo *api.UserInfo
@@ -73,17 +345,61 @@ Offline Commands:
 		} else {
 			break
 		}
+		// a new token won't help without a connection
+		if transport.IsUnreachable(err) {
+			break
+		}
 	}
 
+	if err != nil && transport.IsUnreachable(err) && queuedCommand(otherFlags) {
+		log.Warning.Printf("the cloud is unreachable: %v", err)
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := shell.RunQueued(goCtx, otherFlags); err != nil {
+			log.Error.Fatalln(err)
+		}
+		return
+	}
 	if err != nil {
-		log.Error.Fatal("failed to build documents tree, last error: ", err)
+		log.Error.Println("failed to build documents tree, last error: ", err)
+		printHint(err)
+		os.Exit(1)
 	}
 
-	err = shell.RunCLI(ctx, userInfo, otherFlags)
+	if n := shell.QueuedOps(); n > 0 && (len(otherFlags) == 0 || otherFlags[0] != "queue") {
+		log.Warning.Printf("%d operations were queued while the cloud was unreachable, send them with 'rmapi queue flush'", n)
+	}
+
+	if len(otherFlags) == 0 && *script == "" {
+		if err := shell.RunShell(ctx, userInfo); err != nil {
+			log.Error.Fatal(err)
+		}
+		return
+	}
+
+	// Ctrl-C cancels in-flight transfers and conversions, a second one
+	// terminates right away
+	goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-goCtx.Done()
+		stop()
+	}()
+
+	if *script != "" {
+		err = runScript(goCtx, ctx, userInfo, *script, *keepGoing)
+	} else {
+		err = shell.RunCLI(goCtx, ctx, userInfo, otherFlags)
+	}
+	stop()
 
 	if err != nil {
 		log.Error.Println("Error: ", err)
+		printHint(err)
 
+		var exitErr *shell.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		os.Exit(1)
 	}
 }
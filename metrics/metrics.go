@@ -0,0 +1,208 @@
+// Package metrics exposes counters and histograms in the Prometheus text
+// format, for the users running rmapi as a service.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a counter or a histogram of the registry
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+}
+
+// Metrics of rmapi
+var (
+	DocumentsSynced  = NewCounter("rmapi_documents_synced_total", "Documents downloaded and converted.", "")
+	DocumentsFailed  = NewCounter("rmapi_documents_failed_total", "Documents that failed to sync, by stage.", "stage")
+	PagesRendered    = NewCounter("rmapi_pages_rendered_total", "Pages of the converted documents.", "")
+	APIErrors        = NewCounter("rmapi_api_errors_total", "Failed requests to the cloud API, by status code (0 for network errors).", "status")
+	BytesTransferred = NewCounter("rmapi_transferred_bytes_total", "Bytes sent to and received from the cloud API.", "direction")
+	OCRDuration      = NewHistogram("rmapi_ocr_duration_seconds", "Time to OCR a page.", []float64{0.5, 1, 2, 5, 10, 30, 60})
+)
+
+// Counter is a monotonic counter, optionally split by the values of one
+// label
+type Counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter, label is empty for a counter without
+// label
+func NewCounter(name, help, label string) *Counter {
+	c := &Counter{name: name, help: help, label: label, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds 1 to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter
+func (c *Counter) Add(v float64) {
+	c.WithLabel("").Add(v)
+}
+
+// WithLabel returns the counter of a value of the label
+func (c *Counter) WithLabel(value string) LabeledCounter {
+	return LabeledCounter{c, value}
+}
+
+// Value returns the value of the counter for a value of the label
+func (c *Counter) Value(value string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[value]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", c.name, c.label, escapeLabel(v), formatValue(c.values[v]))
+	}
+}
+
+// LabeledCounter is the counter of a value of the label
+type LabeledCounter struct {
+	c     *Counter
+	value string
+}
+
+// Inc adds 1 to the counter
+func (l LabeledCounter) Inc() {
+	l.Add(1)
+}
+
+// Add adds v to the counter
+func (l LabeledCounter) Add(v float64) {
+	l.c.mu.Lock()
+	defer l.c.mu.Unlock()
+	l.c.values[l.value] += v
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets,
+// in increasing order
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	register(h)
+	return h
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatValue(h.sum), h.name, h.count)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value, the format only has \\, \" and \n
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Write writes every metric in the Prometheus text format
+func Write(w io.Writer) {
+	registryMu.Lock()
+	metrics := append([]metric(nil), registry...)
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// Serve serves the metrics on /metrics of addr (e.g. :9090) in the
+// background. The returned server is closed to stop.
+func Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := &Counter{name: "test_total", help: "Test.", label: "path", values: map[string]float64{}}
+	c.WithLabel(`a"b`).Add(2)
+	c.WithLabel("a").Inc()
+	h := &Histogram{name: "test_seconds", help: "Test.", buckets: []float64{1, 5}, counts: make([]uint64, 2)}
+	h.Observe(0.5)
+	h.Observe(3)
+	h.Observe(10)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	h.write(&buf)
+
+	want := `# HELP test_total Test.
+# TYPE test_total counter
+test_total{path="a"} 1
+test_total{path="a\"b"} 2
+# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="5"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 13.5
+test_seconds_count 3
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	if _, err := Serve("127.0.0.1:-1"); err == nil {
+		t.Errorf("expected an error for an invalid address")
+	}
+
+	DocumentsSynced.Inc()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "\nrmapi_documents_synced_total ") {
+		t.Errorf("documents counter missing:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// AccountStats describes the content of an account from the sync index of
+// the last sync
+type AccountStats struct {
+	Documents int `json:"documents"`
+	Folders   int `json:"folders"`
+	// Trashed are the documents and folders in the trash, counted in
+	// Documents and Folders too
+	Trashed int `json:"trashed"`
+	// Size is the size of the files of every document in bytes, as listed
+	// in the sync index
+	Size int64 `json:"size"`
+	// RootHash and Generation identify the state of the cloud, the
+	// generation increases with every change synced by a device
+	RootHash   string `json:"rootHash"`
+	Generation int64  `json:"generation"`
+	// LastSync is when the tree was last synced with the cloud
+	LastSync time.Time `json:"lastSync"`
+	// LastModified is the most recent modification of a document
+	LastModified time.Time `json:"lastModified"`
+}
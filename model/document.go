@@ -14,6 +14,56 @@ type Document struct {
 	Type           string
 	CurrentPage    int
 	Parent         string
+	// Size is the total size in bytes of the files of a document
+	Size int64
+	// Hash is the hash of the entry in the sync index, it changes with any
+	// of its files
+	Hash string
+	// ContentHash identifies the content of a document, its PDF or EPUB
+	// file and its pages, whatever its name, folder and settings. It is
+	// empty for the documents without any, e.g. blank notebooks.
+	ContentHash string
+}
+
+// DocumentVersion is a previous snapshot of a document, identified by the
+// hash of its index blob
+type DocumentVersion struct {
+	Number         int
+	Hash           string
+	Version        int
+	ModifiedClient string
+	Size           int64
+}
+
+// UploadOptions sets the remote metadata of a document on upload
+type UploadOptions struct {
+	// Name overrides the name derived from the local file name
+	Name      string
+	Tags      []string
+	Pinned    bool
+	Coverpage *int
+}
+
+// BatchOpKind is the kind of a BatchOp
+type BatchOpKind int
+
+const (
+	// BatchMove moves and/or renames an entry
+	BatchMove BatchOpKind = iota
+	// BatchDelete deletes an entry
+	BatchDelete
+)
+
+// BatchOp is an operation of a batch applied in a single sync
+type BatchOp struct {
+	Kind BatchOpKind `json:"kind"`
+	// ID is the entry moved or deleted
+	ID string `json:"id"`
+	// Parent is the ID of the destination directory of a move, empty for
+	// the root and "trash" for the trash
+	Parent string `json:"parent,omitempty"`
+	// Name is the new name of a moved entry, empty to keep it
+	Name string `json:"name,omitempty"`
 }
 
 type BlobRootStorageRequest struct {
@@ -0,0 +1,26 @@
+package model
+
+import "errors"
+
+// Classes of errors of the api and rmconvert packages. The errors returned
+// wrap one of them, test them with errors.Is:
+//
+//	if errors.Is(err, model.ErrNotFound) {
+var (
+	// ErrNotFound is returned when a document, a version or a path doesn't
+	// exist
+	ErrNotFound = errors.New("not found")
+	// ErrAuth is returned when the tokens are missing, expired or rejected
+	// by the cloud
+	ErrAuth = errors.New("authentication failed")
+	// ErrConflict is returned when the cloud was changed by another device
+	// during an update
+	ErrConflict = errors.New("conflict")
+	// ErrUnsupportedVersion is returned for file formats and sync schemas
+	// that are not supported
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrParse is returned for malformed files, indexes and tokens
+	ErrParse = errors.New("parse error")
+	// ErrTooLarge is returned for archives exceeding the extraction limits
+	ErrTooLarge = errors.New("too large")
+)
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -31,6 +32,71 @@ func (node *Node) Name() string {
 	return node.Document.Name
 }
 
+// DuplicateSeparator separates a name from the index of an entry that shares
+// its name with siblings, e.g. "notes~2"
+const DuplicateSeparator = "~"
+
+// Duplicates returns the siblings with the same name as node, node included,
+// ordered by ID. The first one is addressed by its name and the others by
+// their name followed by ~2, ~3...
+func (node *Node) Duplicates() []*Node {
+	if node.Parent == nil {
+		return []*Node{node}
+	}
+
+	result := make([]*Node, 0, 1)
+	for _, n := range node.Parent.Children {
+		if n.Name() == node.Name() {
+			result = append(result, n)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id() < result[j].Id()
+	})
+	return result
+}
+
+// DisplayName returns the name of the node, suffixed with its index if
+// siblings have the same name, so that it can be told apart from them
+func (node *Node) DisplayName() string {
+	dups := node.Duplicates()
+	for i, n := range dups {
+		if n == node {
+			return duplicateName(node.Name(), i)
+		}
+	}
+	return node.Name()
+}
+
+// ChildrenDisplayNames returns the DisplayName of each child of node, in a
+// single pass over the children
+func (node *Node) ChildrenDisplayNames() map[*Node]string {
+	byName := make(map[string][]*Node, len(node.Children))
+	for _, child := range node.Children {
+		byName[child.Name()] = append(byName[child.Name()], child)
+	}
+
+	names := make(map[*Node]string, len(node.Children))
+	for name, dups := range byName {
+		sort.Slice(dups, func(i, j int) bool {
+			return dups[i].Id() < dups[j].Id()
+		})
+		for i, n := range dups {
+			names[n] = duplicateName(name, i)
+		}
+	}
+	return names
+}
+
+// duplicateName returns the name of the entry at index among the entries
+// of the same name
+func duplicateName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s%s%d", name, DuplicateSeparator, index+1)
+}
+
 func (node *Node) Id() string {
 	return node.Document.ID
 }
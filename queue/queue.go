@@ -0,0 +1,254 @@
+// Package queue keeps the uploads and the metadata changes that couldn't be
+// sent because the cloud was unreachable, the uploads with a copy of their
+// file, so that they can be sent once the connection is back.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+const (
+	queueVersion = 1
+	queueFile    = "queue.json"
+	filesDir     = "files"
+)
+
+// Kind is what a queued operation does
+type Kind string
+
+const (
+	// Upload uploads File into Dir, it is the kind of the operations
+	// queued before the metadata changes were
+	Upload Kind = ""
+	// Copy copies the document Target into Dir as Name, its name if empty
+	Copy Kind = "copy"
+	// Mkdir creates the folder Name in Dir
+	Mkdir Kind = "mkdir"
+	// Batch moves and deletes the entries of Batch in a single sync
+	Batch Kind = "batch"
+)
+
+// Op is a queued upload or metadata change
+type Op struct {
+	ID      int       `json:"id"`
+	Created time.Time `json:"created"`
+	Kind    Kind      `json:"kind,omitempty"`
+	// Source is the local file given to put
+	Source string `json:"source,omitempty"`
+	// File is the copy of the file to upload, relative to the queue dir
+	File string `json:"file,omitempty"`
+	// Target is the remote path of the document copied
+	Target string `json:"target,omitempty"`
+	// Batch are the moves and deletions by ID of a Batch operation
+	Batch []model.BatchOp `json:"batch,omitempty"`
+	// Dir is the remote folder of the document or of the folder created,
+	// created if missing when CreateParents is set
+	Dir           string   `json:"dir,omitempty"`
+	CreateParents bool     `json:"create_parents,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Pinned        bool     `json:"pinned,omitempty"`
+	Coverpage     *int     `json:"coverpage,omitempty"`
+	// Attempts counts the failed uploads, LastError is the error of the last
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Queue holds the pending uploads, oldest first
+type Queue struct {
+	Version int  `json:"version"`
+	Ops     []Op `json:"ops"`
+	dir     string
+}
+
+// DefaultDir returns the location of the queue, RMAPI_QUEUE or rmapi/queue
+// in the user config dir: the queued files are not a cache
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("RMAPI_QUEUE"); dir != "" {
+		return dir, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "rmapi", "queue"), nil
+}
+
+// Open loads the queue stored in dir, an empty queue is returned if there
+// is none yet
+func Open(dir string) (*Queue, error) {
+	q := &Queue{Version: queueVersion, dir: dir}
+
+	b, err := os.ReadFile(filepath.Join(dir, queueFile))
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, q); err != nil {
+		return nil, fmt.Errorf("invalid queue %s: %v: %w", dir, err, model.ErrParse)
+	}
+	if q.Version != queueVersion {
+		return nil, fmt.Errorf("queue %s has version %d: %w", dir, q.Version, model.ErrUnsupportedVersion)
+	}
+	return q, nil
+}
+
+// Path returns the location of the file of an operation, "" for the
+// operations without one
+func (q *Queue) Path(op Op) string {
+	if op.File == "" {
+		return ""
+	}
+	return filepath.Join(q.dir, filepath.FromSlash(op.File))
+}
+
+// String describes an operation, e.g. for queue ls
+func (op Op) String() string {
+	switch op.Kind {
+	case Copy:
+		return fmt.Sprintf("copy %s -> %s", op.Target, path.Join(op.Dir, op.Name))
+	case Mkdir:
+		return fmt.Sprintf("mkdir %s", path.Join(op.Dir, op.Name))
+	case Batch:
+		moves, deletions := 0, 0
+		for _, b := range op.Batch {
+			if b.Kind == model.BatchDelete {
+				deletions++
+			} else {
+				moves++
+			}
+		}
+		return fmt.Sprintf("batch of %d moves and %d deletions", moves, deletions)
+	default:
+		return fmt.Sprintf("%s -> %s", op.Source, path.Join(op.Dir, op.Name))
+	}
+}
+
+// Op returns the operation with an ID, nil if there is none
+func (q *Queue) Op(id int) *Op {
+	for i := range q.Ops {
+		if q.Ops[i].ID == id {
+			return &q.Ops[i]
+		}
+	}
+	return nil
+}
+
+// Add queues an upload of the file at path, copied into the queue, and
+// saves the queue. The ID and creation time of op are set.
+func (q *Queue) Add(op Op, path string) (Op, error) {
+	op.Kind = Upload
+	op.ID = q.nextID()
+	op.File = filepath.ToSlash(filepath.Join(filesDir, strconv.Itoa(op.ID), filepath.Base(path)))
+
+	dst := q.Path(op)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return op, err
+	}
+	if err := copyFile(path, dst); err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+		return op, err
+	}
+
+	op, err := q.add(op)
+	if err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+	}
+	return op, err
+}
+
+// AddChange queues a metadata change, an operation without a file, and
+// saves the queue. The ID and creation time of op are set.
+func (q *Queue) AddChange(op Op) (Op, error) {
+	if op.Kind == Upload {
+		return op, errors.New("an upload needs a file")
+	}
+	op.ID = q.nextID()
+	op.File = ""
+	return q.add(op)
+}
+
+func (q *Queue) nextID() int {
+	id := 1
+	for _, queued := range q.Ops {
+		if queued.ID >= id {
+			id = queued.ID + 1
+		}
+	}
+	return id
+}
+
+func (q *Queue) add(op Op) (Op, error) {
+	op.Created = time.Now().UTC()
+	q.Ops = append(q.Ops, op)
+	if err := q.Save(); err != nil {
+		q.Ops = q.Ops[:len(q.Ops)-1]
+		return op, err
+	}
+	return op, nil
+}
+
+// Failed records a failed attempt to send an operation
+func (q *Queue) Failed(id int, err error) {
+	if op := q.Op(id); op != nil {
+		op.Attempts++
+		op.LastError = err.Error()
+	}
+}
+
+// Remove drops an operation and its file
+func (q *Queue) Remove(id int) error {
+	for i, op := range q.Ops {
+		if op.ID != id {
+			continue
+		}
+		if op.File != "" {
+			if err := os.RemoveAll(filepath.Dir(q.Path(op))); err != nil {
+				return err
+			}
+		}
+		q.Ops = append(q.Ops[:i], q.Ops[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("no queued operation %d: %w", id, model.ErrNotFound)
+}
+
+// Save writes the queue back to its dir
+func (q *Queue) Save() error {
+	return util.WriteFileAtomic(filepath.Join(q.dir, queueFile), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(q)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
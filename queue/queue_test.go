@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+)
+
+func TestQueue(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	q, err := Open(dir)
+	if err != nil || len(q.Ops) != 0 {
+		t.Fatalf("expected an empty queue, got %+v %v", q, err)
+	}
+
+	src := filepath.Join(t.TempDir(), "paper.pdf")
+	if err := os.WriteFile(src, []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := q.Add(Op{Source: src, Dir: "/Papers", Name: "paper", Tags: []string{"work"}}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.Add(Op{Source: src, Dir: "/", Name: "copy"}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != 1 || second.ID != 2 || first.File != "files/1/paper.pdf" {
+		t.Fatalf("unexpected ops %+v %+v", first, second)
+	}
+	// the queue keeps its copy when the source goes away
+	os.Remove(src)
+	if b, err := os.ReadFile(q.Path(first)); err != nil || string(b) != "%PDF" {
+		t.Fatalf("file not copied: %q %v", b, err)
+	}
+
+	q.Failed(1, errors.New("dial tcp: connection refused"))
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Ops) != 2 || loaded.Op(1).Attempts != 1 || loaded.Op(1).LastError == "" || loaded.Op(1).Tags[0] != "work" {
+		t.Fatalf("unexpected queue %+v", loaded.Ops)
+	}
+
+	if err := loaded.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "files", "1")); !os.IsNotExist(err) {
+		t.Errorf("file of a removed op kept: %v", err)
+	}
+	if err := loaded.Remove(1); !errors.Is(err, model.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	// IDs are not reused while the queue isn't empty
+	if third, err := loaded.Add(Op{Name: "third"}, q.Path(second)); err != nil || third.ID != 3 {
+		t.Errorf("unexpected op %+v %v", third, err)
+	}
+}
+
+func TestAddChange(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	q, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.AddChange(Op{Dir: "/", Name: "upload"}); err == nil {
+		t.Error("upload queued without a file")
+	}
+	mkdir, err := q.AddChange(Op{Kind: Mkdir, Dir: "/Papers", Name: "2024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := q.AddChange(Op{Kind: Batch, Batch: []model.BatchOp{
+		{Kind: model.BatchMove, ID: "a", Parent: "b"},
+		{Kind: model.BatchDelete, ID: "c"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mkdir.ID != 1 || batch.ID != 2 || q.Path(mkdir) != "" {
+		t.Fatalf("unexpected ops %+v %+v", mkdir, batch)
+	}
+	copied := Op{Kind: Copy, Target: "/Templates/Weekly", Dir: "/Planner"}
+	for op, want := range map[*Op]string{
+		&mkdir:  "mkdir /Papers/2024",
+		&batch:  "batch of 1 moves and 1 deletions",
+		&copied: "copy /Templates/Weekly -> /Planner",
+	} {
+		if got := op.String(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	loaded, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Ops) != 2 || loaded.Op(2).Batch[1].Kind != model.BatchDelete || loaded.Op(1).Kind != Mkdir {
+		t.Fatalf("unexpected queue %+v", loaded.Ops)
+	}
+	if err := loaded.Remove(1); err != nil || len(loaded.Ops) != 1 {
+		t.Errorf("failed to remove an op without a file: %v", err)
+	}
+}
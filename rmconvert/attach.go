@@ -0,0 +1,57 @@
+package rmconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// attachSource embeds the .rmdoc file a PDF was exported from as a file
+// attachment of the PDF
+func attachSource(pdfPath, rmdocPath string) error {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	if err := api.AddAttachmentsFile(pdfPath, "", []string{rmdocPath}, false, conf); err != nil {
+		return fmt.Errorf("failed to attach %s: %v", filepath.Base(rmdocPath), err)
+	}
+	return nil
+}
+
+// ExtractSource writes the .rmdoc files attached to a PDF by an export with
+// AttachSource to outDir and returns their paths
+func ExtractSource(pdfPath, outDir string) ([]string, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	f, err := os.Open(pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := api.Attachments(f, conf)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the attachments of %s: %v", pdfPath, err)
+	}
+
+	var names []string
+	for _, a := range attachments {
+		if strings.EqualFold(filepath.Ext(a.FileName), ".rmdoc") {
+			names = append(names, a.FileName)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no .rmdoc attached to %s", pdfPath)
+	}
+	if err := api.ExtractAttachmentsFile(pdfPath, outDir, names, conf); err != nil {
+		return nil, fmt.Errorf("failed to extract the attachments of %s: %v", pdfPath, err)
+	}
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(outDir, name)
+	}
+	return paths, nil
+}
@@ -0,0 +1,145 @@
+package rmconvert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/tdewolff/canvas/renderers"
+)
+
+// mmPerInch renders pages with their size on the device, in the millimeters
+// of the PDF renderer
+const mmPerInch = 25.4
+
+// BackgroundPDF returns the PDF annotated by the document, false if the
+// document is a notebook
+func (d *RmDoc) BackgroundPDF() ([]byte, bool) {
+	if d.Content.FileType != "pdf" {
+		return nil, false
+	}
+	data, err := d.ReadFile("pdf")
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
+
+// BackgroundPages returns for each page, in document order, the 0-based
+// page of the background PDF shown under it, or -1 for the pages inserted
+// in the document and for notebooks
+func (d *RmDoc) BackgroundPages() []int {
+	ids := d.PageIDs()
+	pages := make([]int, len(ids))
+	for i := range pages {
+		pages[i] = -1
+	}
+	if d.Content.FileType != "pdf" {
+		return pages
+	}
+
+	switch {
+	case len(d.Content.CPages.Pages) > 0:
+		for i, page := range d.Content.OrderedPages() {
+			if page.Redir != nil {
+				pages[i] = page.Redir.Value
+			}
+		}
+	case len(d.Content.RedirectionPageMap) > 0:
+		for i := range pages {
+			if i < len(d.Content.RedirectionPageMap) {
+				pages[i] = d.Content.RedirectionPageMap[i]
+			}
+		}
+	default:
+		// no pages were inserted
+		for i := range pages {
+			pages[i] = i
+		}
+	}
+	return pages
+}
+
+// ComposeAnnotatedPDF writes the pages of an annotated PDF to pdfPath, the
+// strokes drawn over the page of the background PDF given by
+// BackgroundPages. Inserted pages are written on a blank page.
+func ComposeAnnotatedPDF(goCtx context.Context, doc *RmDoc, background []byte, pdfPath string) error {
+	tempDir, err := os.MkdirTemp("", "rmdoc_annotated_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+
+	backgroundCount, err := api.PageCount(bytes.NewReader(background), conf)
+	if err != nil {
+		return fmt.Errorf("failed to read the background PDF: %v", err)
+	}
+
+	ids := doc.PageIDs()
+	if len(ids) == 0 {
+		return fmt.Errorf("no pages found in document")
+	}
+	backgroundPages := doc.BackgroundPages()
+
+	var pageFiles []string
+	for i, id := range ids {
+		if err := goCtx.Err(); err != nil {
+			return err
+		}
+
+		page := &Page{Width: 1404, Height: 1872}
+		if doc.HasPage(id) {
+			if page, err = doc.Page(id); err != nil {
+				return fmt.Errorf("page %d: %v", i+1, err)
+			}
+		}
+
+		var pdf bytes.Buffer
+		if bg := backgroundPages[i]; bg >= 0 && bg < backgroundCount {
+			err = composePage(&pdf, page, background, bg, conf)
+		} else {
+			err = page.render(mmPerInch).Write(&pdf, renderers.PDF())
+		}
+		if err != nil {
+			return fmt.Errorf("page %d: %v", i+1, err)
+		}
+
+		pageFile := filepath.Join(tempDir, fmt.Sprintf("page_%04d.pdf", i+1))
+		if err := os.WriteFile(pageFile, pdf.Bytes(), 0600); err != nil {
+			return err
+		}
+		pageFiles = append(pageFiles, pageFile)
+	}
+
+	return MergePDFs(pageFiles, pdfPath)
+}
+
+// composePage writes the page bg (0-based) of the background PDF with the
+// strokes of page stamped over it
+func composePage(pdf *bytes.Buffer, page *Page, background []byte, bg int, conf *model.Configuration) error {
+	var backgroundPage bytes.Buffer
+	if err := api.Collect(bytes.NewReader(background), &backgroundPage, []string{strconv.Itoa(bg + 1)}, conf); err != nil {
+		return fmt.Errorf("failed to extract background page %d: %v", bg+1, err)
+	}
+
+	var strokes bytes.Buffer
+	if err := page.renderStrokes(mmPerInch).Write(&strokes, renderers.PDF()); err != nil {
+		return err
+	}
+
+	// scaled to the background page like the device fits it on the screen
+	wm, err := api.PDFWatermarkForReadSeeker(bytes.NewReader(strokes.Bytes()), 1, "scalefactor:1 rel, rotation:0", true, false, types.POINTS)
+	if err != nil {
+		return err
+	}
+	return api.AddWatermarks(bytes.NewReader(backgroundPage.Bytes()), pdf, nil, wm, conf)
+}
@@ -0,0 +1,108 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"context"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/juruen/rmapi/util"
+)
+
+// samplePage is a page of handwritten notes, the page of the sample
+// documents of the benchmarks
+//
+//go:embed samples/notes_v5.rm
+var samplePage []byte
+
+// WriteSampleDocument writes a .rmdoc of pages pages of handwritten notes,
+// to measure the conversions on the same document everywhere
+func WriteSampleDocument(path string, pages int) error {
+	if pages < 1 {
+		return fmt.Errorf("a document needs at least one page")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	content := `{"fileType": "notebook", "cPages": {"pages": [`
+	for i := 0; i < pages; i++ {
+		if i > 0 {
+			content += ", "
+		}
+		content += fmt.Sprintf(`{"id": "page-%04d", "idx": {"value": "a%04d"}}`, i, i)
+		fw, err := w.Create(fmt.Sprintf("sample/page-%04d.rm", i))
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(samplePage); err != nil {
+			return err
+		}
+	}
+	content += "]}}"
+
+	fw, err := w.Create("sample.content")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write([]byte(content)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// BenchResult is the measure of a conversion
+type BenchResult struct {
+	Format         string        `json:"format"`
+	DPI            int           `json:"dpi"`
+	Pages          int           `json:"pages"`
+	Duration       time.Duration `json:"duration"`
+	PagesPerSecond float64       `json:"pages_per_second"`
+	// Allocated is the memory allocated by the conversion, in bytes
+	Allocated uint64 `json:"allocated"`
+	// PeakRSS is the peak resident set size of the process until the end
+	// of the conversion, in bytes, see util.PeakRSS
+	PeakRSS int64 `json:"peak_rss"`
+}
+
+// Benchmark converts rmdocPath to format at dpi into outDir and measures
+// the conversion
+func Benchmark(goCtx context.Context, rmdocPath, outDir, format string, dpi int) (BenchResult, error) {
+	result := BenchResult{Format: format, DPI: dpi}
+	exporter, err := LookupExporter(format)
+	if err != nil {
+		return result, err
+	}
+	if result.Pages, err = PageCount(rmdocPath); err != nil {
+		return result, err
+	}
+
+	// the garbage of the previous conversions isn't counted
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	outPath := OutputPath(exporter, filepath.Join(outDir, fmt.Sprintf("bench-%d", dpi)))
+	if _, err := exporter.Export(goCtx, rmdocPath, outPath, ExportOptions{DPI: dpi}.withDefaults()); err != nil {
+		return result, err
+	}
+	result.Duration = time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	result.Allocated = after.TotalAlloc - before.TotalAlloc
+	result.PeakRSS = util.PeakRSS()
+	result.PagesPerSecond = float64(result.Pages) / result.Duration.Seconds()
+	return result, nil
+}
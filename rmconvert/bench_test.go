@@ -0,0 +1,57 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBenchmark(t *testing.T) {
+	dir := t.TempDir()
+	rmdocPath := filepath.Join(dir, "sample.rmdoc")
+	if err := WriteSampleDocument(rmdocPath, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Benchmark(context.Background(), rmdocPath, dir, "pdf", 72)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Pages != 3 || result.PagesPerSecond <= 0 || result.Allocated == 0 {
+		t.Errorf("got %+v", result)
+	}
+	if _, err := Benchmark(context.Background(), rmdocPath, dir, "doc", 72); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+// BenchmarkExport converts the sample document to the formats that render
+// the pages, go test -bench Export -benchmem
+func BenchmarkExport(b *testing.B) {
+	const pages = 4
+	dir := b.TempDir()
+	rmdocPath := filepath.Join(dir, "sample.rmdoc")
+	if err := WriteSampleDocument(rmdocPath, pages); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, format := range []string{"pdf", "png", "svg"} {
+		exporter, err := LookupExporter(format)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, dpi := range []int{150, 300} {
+			b.Run(fmt.Sprintf("%s/%d", format, dpi), func(b *testing.B) {
+				outPath := OutputPath(exporter, filepath.Join(dir, "out"))
+				opts := ExportOptions{DPI: dpi}.withDefaults()
+				for i := 0; i < b.N; i++ {
+					if _, err := exporter.Export(context.Background(), rmdocPath, outPath, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(pages*b.N)/b.Elapsed().Seconds(), "pages/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package rmconvert
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juruen/rmapi/config"
+)
+
+// toolNames are the names of the tools of GetToolProperties, by tool
+var toolNames = map[int]string{
+	ToolFineliner:   "fineliner",
+	ToolPencil:      "pencil",
+	ToolBallpoint:   "ballpoint",
+	ToolMarker:      "marker",
+	ToolHighlighter: "highlighter",
+	ToolEraser:      "eraser",
+}
+
+// calibration adjusts the properties of the tools by name, see
+// SetCalibration
+var calibration map[string]config.ToolCalibration
+
+// SetCalibration sets how the strokes of the tools are drawn in the
+// exports, by tool name (fineliner, pencil, ballpoint, marker, highlighter
+// or eraser), from the tools section of the config file
+func SetCalibration(tools map[string]config.ToolCalibration) error {
+	for name, tool := range tools {
+		if !isToolName(name) {
+			names := make([]string, 0, len(toolNames))
+			for _, name := range toolNames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown tool %q (tools: %s)", name, strings.Join(names, ", "))
+		}
+		if tool.Width < 0 {
+			return fmt.Errorf("%s: invalid width %g", name, tool.Width)
+		}
+		if tool.Opacity != nil && (*tool.Opacity < 0 || *tool.Opacity > 1) {
+			return fmt.Errorf("%s: invalid opacity %g, it is between 0 and 1", name, *tool.Opacity)
+		}
+		if _, ok := lookupColor(tool.Color); tool.Color != "" && !ok {
+			return fmt.Errorf("%s: invalid color %q, use #rrggbb, black, gray or white", name, tool.Color)
+		}
+	}
+	calibration = tools
+	return nil
+}
+
+func isToolName(name string) bool {
+	for _, toolName := range toolNames {
+		if name == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+// calibrate applies the calibration of its tool to props
+func calibrate(props ToolProperties) ToolProperties {
+	tool, ok := calibration[props.Name]
+	if !ok {
+		return props
+	}
+	if tool.Width > 0 {
+		props.StrokeWidth *= tool.Width
+	}
+	if tool.Opacity != nil {
+		props.Opacity = *tool.Opacity
+	}
+	if tool.Color != "" {
+		props.Color = tool.Color
+	}
+	return props
+}
+
+// lookupColor returns the color of a tool, a color name or #rrggbb
+func lookupColor(s string) (color.RGBA, bool) {
+	switch strings.ToLower(s) {
+	case "black":
+		return color.RGBA{0, 0, 0, 255}, true
+	case "white":
+		return color.RGBA{255, 255, 255, 255}, true
+	case "#777777", "gray", "grey":
+		return color.RGBA{119, 119, 119, 255}, true
+	}
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	rgb, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 255}, true
+}
@@ -0,0 +1,103 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/juruen/rmapi/util"
+)
+
+func init() {
+	RegisterExporter(cbzExporter{})
+}
+
+// cbzExporter writes the pages as a comic book archive, a zip of PNG
+// images read in name order by comic readers
+type cbzExporter struct{}
+
+func (cbzExporter) Name() string         { return "cbz" }
+func (cbzExporter) Extensions() []string { return []string{"cbz"} }
+
+// comicInfo is the ComicInfo.xml metadata understood by most readers
+type comicInfo struct {
+	XMLName   xml.Name `xml:"ComicInfo"`
+	Title     string   `xml:"Title"`
+	PageCount int      `xml:"PageCount"`
+}
+
+func (cbzExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	opts = opts.withDefaults()
+
+	pageOrder, pages, err := loadRmdocPages(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(pageOrder) == 0 {
+		return nil, fmt.Errorf("no pages found in document")
+	}
+	pageOrder, pages = sheetPages(pageOrder, pages)
+
+	result := &ExportResult{Files: []string{outPath}}
+	err = util.WriteFileAtomic(outPath, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+
+		// zero padded names so that readers sorting names get the page order
+		digits := max(len(fmt.Sprint(len(pageOrder))), 3)
+		for i, id := range pageOrder {
+			if err := goCtx.Err(); err != nil {
+				return err
+			}
+
+			var img bytes.Buffer
+			if err := writePNG(&img, pages[id].renderWith(opts.forPage(i+1, len(pageOrder))), pngEncoder); err != nil {
+				return fmt.Errorf("page %d: %v", i+1, err)
+			}
+
+			// PNGs are already compressed
+			fw, err := zw.CreateHeader(&zip.FileHeader{
+				Name:   fmt.Sprintf("%0*d.png", digits, i+1),
+				Method: zip.Store,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(img.Bytes()); err != nil {
+				return err
+			}
+
+			if text := strings.TrimSpace(pages[id].Text); text != "" {
+				result.Text = append(result.Text, PageText{Page: i + 1, Source: TextSourceTyped, Text: text})
+			}
+		}
+
+		info, err := xml.MarshalIndent(comicInfo{
+			Title:     strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath)),
+			PageCount: len(pageOrder),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fw, err := zw.Create("ComicInfo.xml")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, xml.Header); err != nil {
+			return err
+		}
+		if _, err := fw.Write(info); err != nil {
+			return err
+		}
+
+		return zw.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,212 @@
+package rmconvert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/juruen/rmapi/util"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/tdewolff/canvas"
+	canvaspdf "github.com/tdewolff/canvas/renderers/pdf"
+)
+
+// CombineInput is a converted document to be included in a combined PDF
+type CombineInput struct {
+	Title string
+	Path  string
+}
+
+// CombinePDFs merges the given PDFs into a single file, adding one bookmark
+// per input document pointing to its first page. With toc, the file starts
+// with a table of contents listing the documents and their first page,
+// linked to it.
+func CombinePDFs(inputs []CombineInput, outputFile string, toc bool) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no input files provided")
+	}
+
+	files := make([]string, 0, len(inputs))
+	bookmarks := make([]pdfcpu.Bookmark, 0, len(inputs))
+	page := 1
+
+	for _, input := range inputs {
+		count, err := api.PageCountFile(input.Path)
+		if err != nil {
+			return fmt.Errorf("failed to count pages of %s: %v", input.Path, err)
+		}
+		if count == 0 {
+			continue
+		}
+
+		files = append(files, input.Path)
+		bookmarks = append(bookmarks, pdfcpu.Bookmark{
+			Title:    input.Title,
+			PageFrom: page,
+		})
+		page += count
+	}
+
+	var contents *tableOfContents
+	if toc && len(files) > 0 {
+		dims, err := api.PageDimsFile(files[0])
+		if err != nil || len(dims) == 0 {
+			return fmt.Errorf("failed to read the page size of %s: %v", files[0], err)
+		}
+		contents = layoutTableOfContents(bookmarks, dims[0].Width, dims[0].Height)
+
+		tempDir, err := os.MkdirTemp("", "rmapi_toc_*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+		tocPath := filepath.Join(tempDir, "toc.pdf")
+		if err := contents.write(tocPath); err != nil {
+			return fmt.Errorf("failed to write the table of contents: %v", err)
+		}
+		files = append([]string{tocPath}, files...)
+		for i := range bookmarks {
+			bookmarks[i].PageFrom += contents.pages
+		}
+	}
+
+	// the bookmarks and links are added before the file is renamed, so
+	// that an interrupted run never leaves a file without them
+	return util.WritePathAtomic(outputFile, func(partialPath string) error {
+		return combinePartial(files, partialPath, bookmarks, contents)
+	})
+}
+
+// combinePartial merges files into path and adds the bookmarks and the links
+// of the table of contents, if any
+func combinePartial(files []string, path string, bookmarks []pdfcpu.Bookmark, contents *tableOfContents) error {
+	if err := MergePDFs(files, path); err != nil {
+		return err
+	}
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+
+	err := api.AddBookmarksFile(path, "", bookmarks, true, conf)
+	if err != nil {
+		return fmt.Errorf("failed to add bookmarks: %v", err)
+	}
+
+	if contents != nil {
+		if err := api.AddAnnotationsMapFile(path, "", contents.links(), conf, false); err != nil {
+			return fmt.Errorf("failed to link the table of contents: %v", err)
+		}
+	}
+	return nil
+}
+
+// tableOfContents is the layout of the pages listing the documents of a
+// combined PDF, in millimeters from the top left of the pages
+type tableOfContents struct {
+	width, height float64
+	margin        float64
+	titleSize     float64
+	size          float64
+	lineHeight    float64
+	entries       []tocEntry
+	pages         int
+}
+
+// tocEntry is a line of the table of contents, on page (0-based) with its
+// baseline at y
+type tocEntry struct {
+	title     string
+	startPage int
+	page      int
+	y         float64
+}
+
+// layoutTableOfContents places the documents of bookmarks on pages of
+// width x height points, the size of the pages of the documents. The
+// bookmarks are the first page of the documents without the table of
+// contents, which shifts them by its page count.
+func layoutTableOfContents(bookmarks []pdfcpu.Bookmark, width, height float64) *tableOfContents {
+	const mmPerPoint = 25.4 / 72
+	toc := &tableOfContents{width: width * mmPerPoint, height: height * mmPerPoint}
+	toc.margin = toc.width / 10
+	toc.titleSize = toc.width / 20
+	toc.size = toc.width / 40
+	toc.lineHeight = toc.size * 1.8
+
+	top := toc.margin + toc.titleSize*2.5
+	perPage := max(int((toc.height-toc.margin-top)/toc.lineHeight), 1)
+	toc.pages = (len(bookmarks) + perPage - 1) / perPage
+	for i, bookmark := range bookmarks {
+		toc.entries = append(toc.entries, tocEntry{
+			title:     bookmark.Title,
+			startPage: bookmark.PageFrom + toc.pages,
+			page:      i / perPage,
+			y:         top + float64(i%perPage)*toc.lineHeight,
+		})
+	}
+	return toc
+}
+
+// write renders the pages of the table of contents to a PDF file
+func (toc *tableOfContents) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	titleFace := textFace(toc.titleSize, true, false, canvas.Black)
+	face := textFace(toc.size, false, false, canvas.Black)
+	renderer := canvaspdf.New(f, toc.width, toc.height, nil)
+	for page := 0; page < toc.pages; page++ {
+		if page > 0 {
+			renderer.NewPage(toc.width, toc.height)
+		}
+		c := canvas.New(toc.width, toc.height)
+		ctx := canvas.NewContext(c)
+		ctx.SetCoordSystem(canvas.CartesianIV)
+		if page == 0 {
+			ctx.DrawText(toc.margin, toc.margin+toc.titleSize, canvas.NewTextLine(titleFace, "Contents", canvas.Left))
+		}
+		for _, entry := range toc.entries {
+			if entry.page != page {
+				continue
+			}
+			number := strconv.Itoa(entry.startPage)
+			// the title is shortened to leave room for the page number
+			available := toc.width - 2*toc.margin - face.TextWidth(number) - 2*toc.size
+			title := entry.title
+			for runes := []rune(title); face.TextWidth(title) > available && len(runes) > 0; {
+				runes = runes[:len(runes)-1]
+				title = string(runes) + "…"
+			}
+			ctx.DrawText(toc.margin, entry.y, canvas.NewTextLine(face, title, canvas.Left))
+			ctx.DrawText(toc.width-toc.margin, entry.y, canvas.NewTextLine(face, number, canvas.Right))
+		}
+		c.RenderTo(renderer)
+	}
+	if err := renderer.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// links returns the link annotations of the lines of the table of contents
+// to the first page of their document, by page of the combined PDF
+func (toc *tableOfContents) links() map[int][]model.AnnotationRenderer {
+	const pointsPerMM = 72 / 25.4
+	links := map[int][]model.AnnotationRenderer{}
+	for _, entry := range toc.entries {
+		// in points from the bottom left of the page
+		rect := types.NewRectangle(toc.margin*pointsPerMM, (toc.height-entry.y-toc.size*0.4)*pointsPerMM,
+			(toc.width-toc.margin)*pointsPerMM, (toc.height-entry.y+toc.size)*pointsPerMM)
+		dest := &model.Destination{Typ: model.DestXYZ, PageNr: entry.startPage, Left: -1, Top: -1}
+		links[entry.page+1] = append(links[entry.page+1],
+			model.NewLinkAnnotation(*rect, 0, "", "", "", 0, nil, dest, "", nil, false, 0, model.BSSolid))
+	}
+	return links
+}
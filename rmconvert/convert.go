@@ -2,31 +2,91 @@ package rmconvert
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
 )
 
 // ConvertRmdocToPDF converts a .rmdoc file to PDF with optional OCR
 // This is the main entry point for PDF conversion
-func ConvertRmdocToPDF(rmdocPath, pdfPath string, dpi int, enableOCR bool, tessPath, lang string, psm int) error {
+//
+// The PDF is rendered into a temporary file next to pdfPath and only renamed
+// once complete, so a failed or cancelled conversion never leaves a truncated
+// PDF behind.
+func ConvertRmdocToPDF(goCtx context.Context, rmdocPath, pdfPath string, dpi int, enableOCR bool, tessPath, lang string, psm int) error {
+	_, err := convertRmdocToPDFAtomic(goCtx, rmdocPath, pdfPath, pdfOptions(dpi, enableOCR, tessPath, lang, psm))
+	return err
+}
+
+// ConvertRmdocToPDFWithText is like ConvertRmdocToPDF and also returns the
+// text of the document: the typed text of each page and, with OCR, the
+// recognised text, e.g. to index it for search
+func ConvertRmdocToPDFWithText(goCtx context.Context, rmdocPath, pdfPath string, dpi int, enableOCR bool, tessPath, lang string, psm int) ([]PageText, error) {
+	var text []PageText
+	err := util.WritePathAtomic(pdfPath, func(partialPath string) error {
+		var err error
+		text, _, err = convertRmdocToPDFWithText(goCtx, rmdocPath, partialPath, pdfOptions(dpi, enableOCR, tessPath, lang, psm))
+		return err
+	})
+	return text, err
+}
+
+// pdfOptions returns the options of the PDF conversion functions
+func pdfOptions(dpi int, enableOCR bool, tessPath, lang string, psm int) ExportOptions {
+	return ExportOptions{DPI: dpi, OCR: enableOCR, TesseractPath: tessPath, Language: lang, PSM: psm}.withDefaults()
+}
+
+// convertRmdocToPDFWithText writes pdfPath in place, the callers write it
+// through a partial file
+func convertRmdocToPDFWithText(goCtx context.Context, rmdocPath, pdfPath string, opts ExportOptions) ([]PageText, []int, error) {
+	ocrResults, err := convertRmdocToPDF(goCtx, rmdocPath, pdfPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	text, err := ExtractTypedText(rmdocPath)
+	if err != nil {
+		log.Warning.Printf("failed to extract the text of %s: %v", rmdocPath, err)
+	}
+
+	return append(text, ocrText(ocrResults)...), TimedOutPages(ocrResults), nil
+}
+
+func convertRmdocToPDFAtomic(goCtx context.Context, rmdocPath, pdfPath string, opts ExportOptions) ([]PageOCR, error) {
+	var ocrResults []PageOCR
+	err := util.WritePathAtomic(pdfPath, func(partialPath string) error {
+		var err error
+		ocrResults, err = convertRmdocToPDF(goCtx, rmdocPath, partialPath, opts)
+		return err
+	})
+	return ocrResults, err
+}
+
+func convertRmdocToPDF(goCtx context.Context, rmdocPath, pdfPath string, opts ExportOptions) ([]PageOCR, error) {
 	// Try OCR-enabled rendering if requested
-	if enableOCR {
-		err := ConvertRmdocToSearchablePDF(rmdocPath, pdfPath, dpi, tessPath, lang, psm)
-		if err == nil {
-			return nil
+	if opts.OCR {
+		ocrResults, err := convertRmdocToSearchablePDF(goCtx, rmdocPath, pdfPath, opts)
+		if err == nil || goCtx.Err() != nil {
+			return ocrResults, err
 		}
-		fmt.Printf("OCR rendering failed (%v), falling back to non-OCR rendering\n", err)
+		log.Warning.Printf("OCR rendering failed (%v), falling back to non-OCR rendering", err)
+		os.Remove(pdfPath)
 	}
 
 	// Use image-based rendering (supports v3/v5/v6)
-	return ConvertRmdocToImagePDF(rmdocPath, pdfPath, dpi)
+	return nil, convertRmdocToImagePDF(goCtx, rmdocPath, pdfPath, opts)
 }
 
-// extractZip extracts a zip file to the specified directory
+// extractZip extracts an archive into dest within ExtractLimits, a
+// *ZipLimitError is returned for an archive that exceeds them
 func extractZip(src, dest string) error {
 	reader, err := zip.OpenReader(src)
 	if err != nil {
@@ -34,6 +94,12 @@ func extractZip(src, dest string) error {
 	}
 	defer reader.Close()
 
+	limits := ExtractLimits
+	if err := limits.check(src, reader.File); err != nil {
+		return err
+	}
+	remaining := limits.MaxTotalSize
+
 	// Create destination directory
 	os.MkdirAll(dest, 0755)
 
@@ -68,7 +134,7 @@ func extractZip(src, dest string) error {
 			return err
 		}
 
-		_, err = io.Copy(targetFile, fileReader)
+		err = limits.limitedCopy(src, targetFile, fileReader, &remaining)
 		fileReader.Close()
 		targetFile.Close()
 
@@ -80,16 +146,33 @@ func extractZip(src, dest string) error {
 	return nil
 }
 
+// ContentValue is a field of the .content file, with the time it was last
+// changed so that the edits of several devices can be merged
+type ContentValue[T any] struct {
+	Timestamp string `json:"timestamp"`
+	Value     T      `json:"value"`
+}
+
 // ContentPage represents a page in the .content file
 type ContentPage struct {
-	ID       string `json:"id"`
-	Modified string `json:"modifed"`
-	Template struct {
-		Value string `json:"value"`
-	} `json:"template"`
-	Idx struct {
-		Value string `json:"value"`
-	} `json:"idx"`
+	ID       string               `json:"id"`
+	Modified string               `json:"modifed"`
+	Template ContentValue[string] `json:"template"`
+	// Idx orders the pages, its values compare as strings. Moving a page
+	// changes its index.
+	Idx ContentValue[string] `json:"idx"`
+	// Redir is the page of the background PDF shown under the page, nil for
+	// the pages inserted in the document
+	Redir *ContentValue[int] `json:"redir,omitempty"`
+	// Deleted is set for the pages that were removed, they stay in the list
+	Deleted        *ContentValue[int]     `json:"deleted,omitempty"`
+	ScrollTime     *ContentValue[string]  `json:"scrollTime,omitempty"`
+	VerticalScroll *ContentValue[float64] `json:"verticalScroll,omitempty"`
+}
+
+// IsDeleted tells whether the page was removed from the document
+func (p ContentPage) IsDeleted() bool {
+	return p.Deleted != nil && p.Deleted.Value != 0
 }
 
 // ContentFile represents the structure of a .content file
@@ -97,7 +180,61 @@ type ContentFile struct {
 	CPages struct {
 		Pages []ContentPage `json:"pages"`
 	} `json:"cPages"`
-	PageCount int `json:"pageCount"`
+	PageCount int    `json:"pageCount"`
+	FileType  string `json:"fileType"`
+	// Orientation is "portrait" or "landscape"
+	Orientation string `json:"orientation"`
+	// RedirectionPageMap is the page of the background PDF of each page,
+	// -1 for inserted pages, in the files written before cPages
+	RedirectionPageMap []int `json:"redirectionPageMap"`
+	// Pages are the page IDs in order in the files written before cPages,
+	// older archives only have numbered .rm files
+	Pages []string `json:"pages"`
+	// CoverPageNumber is the index of the page shown as the cover on the
+	// device, nil when unset and -1 for the last opened page
+	CoverPageNumber *int `json:"coverPageNumber,omitempty"`
+}
+
+// OrderedPages returns the pages of cPages that weren't deleted, sorted by
+// their index
+func (c *ContentFile) OrderedPages() []ContentPage {
+	var pages []ContentPage
+	for _, page := range c.CPages.Pages {
+		if !page.IsDeleted() {
+			pages = append(pages, page)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Idx.Value < pages[j].Idx.Value
+	})
+	return pages
+}
+
+// PageOrder returns the page IDs in order: the cPages that weren't deleted,
+// or the pages list of older files. Legacy archives without either have
+// numbered .rm files (0.rm, 1.rm...), rmFiles are the names of the .rm files
+// without extension, sorted by number.
+func (c *ContentFile) PageOrder(rmFiles []string) []string {
+	var ids []string
+	switch {
+	case len(c.CPages.Pages) > 0:
+		for _, page := range c.OrderedPages() {
+			ids = append(ids, page.ID)
+		}
+	case len(c.Pages) > 0:
+		ids = append(ids, c.Pages...)
+	default:
+		ids = append(ids, rmFiles...)
+		sort.SliceStable(ids, func(i, j int) bool {
+			a, errA := strconv.Atoi(ids[i])
+			b, errB := strconv.Atoi(ids[j])
+			if errA == nil && errB == nil {
+				return a < b
+			}
+			return ids[i] < ids[j]
+		})
+	}
+	return ids
 }
 
 // getPageOrderAndDocDir reads the .content file and returns the correct page order and document directory
@@ -129,6 +266,12 @@ func getPageOrderAndDocDir(extractDir string) ([]string, string, error) {
 		return nil, "", fmt.Errorf("no .content file found")
 	}
 
+	// the pages are in the folder named after the .content file, in .rmdoc
+	// and legacy .zip archives alike
+	if dir := strings.TrimSuffix(contentFile, ".content"); isDir(dir) {
+		docDir = dir
+	}
+
 	if docDir == "" {
 		return nil, "", fmt.Errorf("no document directory found")
 	}
@@ -145,24 +288,63 @@ func getPageOrderAndDocDir(extractDir string) ([]string, string, error) {
 		return nil, "", err
 	}
 
-	// Extract page IDs in order
-	var pageOrder []string
-	for _, page := range content.CPages.Pages {
-		pageOrder = append(pageOrder, page.ID)
+	// If no pages in content file, try to find .rm files directly
+	var rmFiles []string
+	files, err := os.ReadDir(docDir)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".rm") {
+			rmFiles = append(rmFiles, strings.TrimSuffix(file.Name(), ".rm"))
+		}
 	}
 
-	// If no pages in content file, try to find .rm files directly
-	if len(pageOrder) == 0 {
-		files, err := os.ReadDir(docDir)
+	return content.PageOrder(rmFiles), docDir, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// PageCount returns the number of pages of a .rmdoc file, read from its
+// .content file without extracting the pages
+func PageCount(rmdocPath string) (int, error) {
+	zr, err := zip.OpenReader(rmdocPath)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	rmFiles := 0
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".rm") {
+			rmFiles++
+		}
+		if !strings.HasSuffix(f.Name, ".content") {
+			continue
+		}
+
+		r, err := f.Open()
 		if err != nil {
-			return nil, "", err
+			return 0, err
 		}
-		for _, file := range files {
-			if strings.HasSuffix(file.Name(), ".rm") {
-				pageOrder = append(pageOrder, strings.TrimSuffix(file.Name(), ".rm"))
-			}
+		var content ContentFile
+		err = json.NewDecoder(r).Decode(&content)
+		r.Close()
+		if err != nil {
+			return 0, fmt.Errorf("bad .content file: %v", err)
+		}
+		if len(content.CPages.Pages) > 0 {
+			return len(content.OrderedPages()), nil
+		}
+		if len(content.Pages) > 0 {
+			return len(content.Pages), nil
+		}
+		if content.PageCount > 0 {
+			return content.PageCount, nil
 		}
 	}
-
-	return pageOrder, docDir, nil
+	return rmFiles, nil
 }
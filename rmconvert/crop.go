@@ -0,0 +1,66 @@
+package rmconvert
+
+import (
+	"math"
+
+	"github.com/tdewolff/canvas"
+)
+
+// DefaultCropMargin is the margin around the content of the cropped pages,
+// in device pixels, about 4 mm
+const DefaultCropMargin = 40
+
+// contentBounds returns the bounding box in device pixels of what is drawn
+// on the page: the strokes with their width, and the typed text. It returns
+// false for a blank page.
+func (page *Page) contentBounds() (canvas.Rect, bool) {
+	bounds := canvas.Rect{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+	add := func(x0, y0, x1, y1 float64) {
+		bounds.X0, bounds.Y0 = min(bounds.X0, x0), min(bounds.Y0, y0)
+		bounds.X1, bounds.Y1 = max(bounds.X1, x1), max(bounds.Y1, y1)
+	}
+
+	for i := range page.Strokes {
+		stroke := &page.Strokes[i]
+		// erased ink is white, it doesn't show
+		if stroke.Tool == ToolEraser {
+			continue
+		}
+		hw := float64(GetToolProperties(stroke.Tool, stroke.Color, stroke.Width).StrokeWidth) / 2
+		for _, p := range stroke.Points {
+			add(float64(p.X)-hw, float64(p.Y)-hw, float64(p.X)+hw, float64(p.Y)+hw)
+		}
+	}
+
+	for _, span := range page.typedText() {
+		face := textFace(span.Size, span.Bold, span.Italic, canvas.Black)
+		metrics := face.Metrics()
+		add(span.X, span.Y-metrics.Ascent, span.X+face.TextWidth(span.Text), span.Y+metrics.Descent)
+	}
+
+	return bounds, bounds.X0 <= bounds.X1
+}
+
+// cropBounds returns the region of a page of width x height device pixels
+// kept when cropping: its content and a margin, in whole device pixels.
+// It returns false for blank pages, which are kept whole.
+func (page *Page) cropBounds(width, height, margin float64) (canvas.Rect, bool) {
+	bounds, ok := page.contentBounds()
+	if !ok {
+		return canvas.Rect{}, false
+	}
+	r := canvas.Rect{
+		X0: max(math.Floor(bounds.X0-margin), 0),
+		Y0: max(math.Floor(bounds.Y0-margin), 0),
+		X1: min(math.Ceil(bounds.X1+margin), width),
+		Y1: min(math.Ceil(bounds.Y1+margin), height),
+	}
+	return r, r.X0 < r.X1 && r.Y0 < r.Y1
+}
+
+// crop trims a canvas the page was drawn on at scale times device pixels
+// to a region of the page in device pixels
+func crop(c *canvas.Canvas, scale float64, r canvas.Rect) {
+	// the canvas has y pointing up, from the bottom of the page
+	c.Clip(canvas.Rect{X0: r.X0 * scale, Y0: c.H - r.Y1*scale, X1: r.X1 * scale, Y1: c.H - r.Y0*scale})
+}
@@ -0,0 +1,116 @@
+package rmconvert
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/tdewolff/canvas"
+)
+
+// DebugRender colors the strokes of the rendered pages by an attribute of
+// their points or by their drawing order, from blue for the lowest values
+// to red for the highest, to diagnose the parsing of pages and brushes
+type DebugRender string
+
+const (
+	// DebugRenderOff draws the strokes with their colors
+	DebugRenderOff DebugRender = ""
+	// DebugRenderPressure colors the strokes by the pen pressure
+	DebugRenderPressure DebugRender = "pressure"
+	// DebugRenderSpeed colors the strokes by the pen speed
+	DebugRenderSpeed DebugRender = "speed"
+	// DebugRenderOrder colors each stroke by its position in the page,
+	// the first strokes in blue and the last ones in red
+	DebugRenderOrder DebugRender = "order"
+)
+
+// ParseDebugRender parses the value of the -debug-render flag, empty for no
+// debug rendering
+func ParseDebugRender(s string) (DebugRender, error) {
+	switch mode := DebugRender(s); mode {
+	case DebugRenderOff, DebugRenderPressure, DebugRenderSpeed, DebugRenderOrder:
+		return mode, nil
+	}
+	return DebugRenderOff, fmt.Errorf("invalid debug render %q (available: pressure, speed, order)", s)
+}
+
+// pointValue returns the attribute of a point a debug render colors by
+func (mode DebugRender) pointValue(p *Point) float64 {
+	if mode == DebugRenderSpeed {
+		return float64(p.Speed)
+	}
+	return float64(p.Pressure)
+}
+
+// drawDebugStrokes draws the strokes of the page with the colors of mode,
+// at their width but opaque. The attributes of the points are scaled to
+// the range of the page, as it differs between versions of the format.
+func drawDebugStrokes(ctx *canvas.Context, page *Page, scale float64, mode DebugRender) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := range page.Strokes {
+		for j := range page.Strokes[i].Points {
+			v := mode.pointValue(&page.Strokes[i].Points[j])
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	ratio := func(v float64) float64 {
+		if hi <= lo {
+			return 0
+		}
+		return (v - lo) / (hi - lo)
+	}
+
+	ctx.SetFill(canvas.Paint{})
+	ctx.SetStrokeCapper(canvas.RoundCap)
+	ctx.SetStrokeJoiner(canvas.RoundJoin)
+	path := &canvas.Path{}
+	for i := range page.Strokes {
+		stroke := &page.Strokes[i]
+		if len(stroke.Points) < 2 {
+			continue
+		}
+		props := GetToolProperties(stroke.Tool, stroke.Color, stroke.Width)
+		ctx.SetStrokeWidth(float64(props.StrokeWidth) * scale)
+
+		if mode == DebugRenderOrder {
+			ctx.SetStrokeColor(heatColor(float64(i) / float64(max(len(page.Strokes)-1, 1))))
+			path.Reset()
+			path.MoveTo(float64(stroke.Points[0].X)*scale, float64(stroke.Points[0].Y)*scale)
+			for _, p := range stroke.Points[1:] {
+				path.LineTo(float64(p.X)*scale, float64(p.Y)*scale)
+			}
+			ctx.DrawPath(0, 0, path)
+			continue
+		}
+
+		// each segment has the color of the mean of its points
+		for j := 1; j < len(stroke.Points); j++ {
+			p, q := &stroke.Points[j-1], &stroke.Points[j]
+			ctx.SetStrokeColor(heatColor(ratio((mode.pointValue(p) + mode.pointValue(q)) / 2)))
+			path.Reset()
+			path.MoveTo(float64(p.X)*scale, float64(p.Y)*scale)
+			path.LineTo(float64(q.X)*scale, float64(q.Y)*scale)
+			ctx.DrawPath(0, 0, path)
+		}
+	}
+}
+
+// heatColor returns the color of a ratio between 0 and 1, from blue to
+// red through cyan, green and yellow
+func heatColor(ratio float64) color.RGBA {
+	// the hue from 240° (blue) to 0° (red), on the sextants of the
+	// color wheel
+	h := (1 - math.Max(0, math.Min(1, ratio))) * 4
+	x := uint8(255 * (1 - math.Abs(math.Mod(h, 2)-1)))
+	switch {
+	case h < 1:
+		return color.RGBA{255, x, 0, 255}
+	case h < 2:
+		return color.RGBA{x, 255, 0, 255}
+	case h < 3:
+		return color.RGBA{0, 255, x, 255}
+	default:
+		return color.RGBA{0, x, 255, 255}
+	}
+}
@@ -0,0 +1,251 @@
+package rmconvert
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers"
+)
+
+// Status of a page in a DocumentDiff
+const (
+	PageUnchanged = "unchanged"
+	PageChanged   = "changed"
+	PageAdded     = "added"
+	PageRemoved   = "removed"
+)
+
+// PageDiff describes how a page changed between two versions of a document.
+// Pages are matched by ID, so moved pages are not reported as changed.
+type PageDiff struct {
+	PageID string
+	// OldPage and NewPage are the 1-based page numbers, 0 if the page is
+	// missing from that version
+	OldPage int
+	NewPage int
+	Status  string
+	// Strokes present in both versions, added in the new one and removed
+	// from the old one
+	Common  []Stroke
+	Added   []Stroke
+	Removed []Stroke
+}
+
+// OldStrokes returns the number of strokes of the old version of the page
+func (d *PageDiff) OldStrokes() int {
+	return len(d.Common) + len(d.Removed)
+}
+
+// NewStrokes returns the number of strokes of the new version of the page
+func (d *PageDiff) NewStrokes() int {
+	return len(d.Common) + len(d.Added)
+}
+
+// DocumentDiff is the result of comparing two versions of a document
+type DocumentDiff struct {
+	Pages []PageDiff
+}
+
+// Changed reports whether any page differs
+func (d *DocumentDiff) Changed() bool {
+	for _, p := range d.Pages {
+		if p.Status != PageUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffRmdocs compares the pages and strokes of two .rmdoc files. Strokes
+// are compared by a hash of their tool, color, width and points.
+func DiffRmdocs(oldPath, newPath string) (*DocumentDiff, error) {
+	oldOrder, oldPages, err := loadRmdocPages(oldPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", oldPath, err)
+	}
+	newOrder, newPages, err := loadRmdocPages(newPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", newPath, err)
+	}
+
+	oldIndex := make(map[string]int, len(oldOrder))
+	for i, id := range oldOrder {
+		oldIndex[id] = i + 1
+	}
+
+	diff := &DocumentDiff{}
+	seen := make(map[string]bool, len(newOrder))
+
+	for i, id := range newOrder {
+		seen[id] = true
+		pageDiff := diffPage(oldPages[id], newPages[id])
+		pageDiff.PageID = id
+		pageDiff.OldPage = oldIndex[id]
+		pageDiff.NewPage = i + 1
+		if pageDiff.OldPage == 0 {
+			pageDiff.Status = PageAdded
+		}
+		diff.Pages = append(diff.Pages, pageDiff)
+	}
+
+	for i, id := range oldOrder {
+		if seen[id] {
+			continue
+		}
+		pageDiff := diffPage(oldPages[id], nil)
+		pageDiff.PageID = id
+		pageDiff.OldPage = i + 1
+		pageDiff.Status = PageRemoved
+		diff.Pages = append(diff.Pages, pageDiff)
+	}
+
+	return diff, nil
+}
+
+// diffPage compares the strokes of two pages, either may be nil
+func diffPage(oldPage, newPage *Page) PageDiff {
+	var result PageDiff
+
+	// strokes of the old page not matched yet, by hash
+	remaining := make(map[[32]byte][]Stroke)
+	if oldPage != nil {
+		for _, s := range oldPage.Strokes {
+			h := strokeHash(s)
+			remaining[h] = append(remaining[h], s)
+		}
+	}
+
+	if newPage != nil {
+		for _, s := range newPage.Strokes {
+			h := strokeHash(s)
+			if len(remaining[h]) > 0 {
+				remaining[h] = remaining[h][1:]
+				result.Common = append(result.Common, s)
+			} else {
+				result.Added = append(result.Added, s)
+			}
+		}
+	}
+
+	// keep the file order of removed strokes
+	if oldPage != nil {
+		for _, s := range oldPage.Strokes {
+			h := strokeHash(s)
+			if len(remaining[h]) > 0 {
+				remaining[h] = remaining[h][1:]
+				result.Removed = append(result.Removed, s)
+			}
+		}
+	}
+
+	result.Status = PageUnchanged
+	if len(result.Added) > 0 || len(result.Removed) > 0 {
+		result.Status = PageChanged
+	}
+	return result
+}
+
+// strokeHash identifies a stroke, coordinates are rounded so that float
+// noise doesn't make identical strokes differ
+func strokeHash(s Stroke) [32]byte {
+	h := sha256.New()
+	buf := make([]byte, 4)
+	put := func(v float32) {
+		binary.LittleEndian.PutUint32(buf, uint32(int32(math.Round(float64(v)*100))))
+		h.Write(buf)
+	}
+
+	put(float32(s.Tool))
+	put(float32(s.Color))
+	put(s.Width)
+	for _, p := range s.Points {
+		put(p.X)
+		put(p.Y)
+	}
+
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// loadRmdocPages returns the page IDs of a .rmdoc file in order and the
+// parsed pages, pages without a .rm file are blank
+func loadRmdocPages(rmdocPath string) ([]string, map[string]*Page, error) {
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open .rmdoc: %v", err)
+	}
+	defer doc.Close()
+
+	return doc.Pages()
+}
+
+// Colors of the diff image
+var (
+	diffCommonColor  = color.RGBA{0xbb, 0xbb, 0xbb, 0xff}
+	diffAddedColor   = color.RGBA{0x1a, 0x9c, 0x3b, 0xff}
+	diffRemovedColor = color.RGBA{0xd7, 0x26, 0x1e, 0xff}
+)
+
+// RenderToPNG draws the page with the strokes present in both versions in
+// light grey, the added ones in green and the removed ones in red
+func (d *PageDiff) RenderToPNG(w io.Writer, dpi int) error {
+	const rmWidth = 1404.0
+	const rmHeight = 1872.0
+	const rmDPI = 226.0
+	scale := float64(dpi) / rmDPI
+
+	width := rmWidth * scale
+	height := rmHeight * scale
+
+	c := canvas.New(width, height)
+	ctx := canvas.NewContext(c)
+	ctx.SetCoordSystem(canvas.CartesianIV)
+
+	ctx.SetFillColor(canvas.White)
+	ctx.MoveTo(0, 0)
+	ctx.LineTo(width, 0)
+	ctx.LineTo(width, height)
+	ctx.LineTo(0, height)
+	ctx.Close()
+	ctx.Fill()
+
+	layers := []struct {
+		strokes []Stroke
+		color   color.RGBA
+	}{
+		{d.Common, diffCommonColor},
+		{d.Removed, diffRemovedColor},
+		{d.Added, diffAddedColor},
+	}
+	for _, layer := range layers {
+		for _, stroke := range layer.strokes {
+			renderDiffStroke(ctx, &stroke, layer.color, scale)
+		}
+	}
+
+	return c.Write(w, renderers.PNG())
+}
+
+func renderDiffStroke(ctx *canvas.Context, stroke *Stroke, c color.RGBA, scale float64) {
+	if len(stroke.Points) < 2 {
+		return
+	}
+
+	props := GetToolProperties(stroke.Tool, stroke.Color, stroke.Width)
+	ctx.SetStrokeColor(c)
+	ctx.SetStrokeWidth(math.Max(float64(props.StrokeWidth), 2) * scale)
+	ctx.SetStrokeCapper(canvas.RoundCap)
+	ctx.SetStrokeJoiner(canvas.RoundJoin)
+
+	ctx.MoveTo(float64(stroke.Points[0].X)*scale, float64(stroke.Points[0].Y)*scale)
+	for _, p := range stroke.Points[1:] {
+		ctx.LineTo(float64(p.X)*scale, float64(p.Y)*scale)
+	}
+	ctx.Stroke()
+}
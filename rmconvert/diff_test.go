@@ -0,0 +1,43 @@
+package rmconvert
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func testStroke(x float32) Stroke {
+	return Stroke{Tool: ToolBallpoint, Width: 2, Points: []Point{{X: x, Y: 10}, {X: x + 50, Y: 60}}}
+}
+
+func TestDiffPage(t *testing.T) {
+	oldPage := &Page{Strokes: []Stroke{testStroke(10), testStroke(20), testStroke(20)}}
+	newPage := &Page{Strokes: []Stroke{testStroke(20), testStroke(30), testStroke(10)}}
+
+	diff := diffPage(oldPage, newPage)
+	if diff.Status != PageChanged {
+		t.Errorf("expected changed page, got %s", diff.Status)
+	}
+	if len(diff.Common) != 2 || len(diff.Added) != 1 || len(diff.Removed) != 1 {
+		t.Fatalf("expected 2 common, 1 added and 1 removed strokes, got %d, %d and %d",
+			len(diff.Common), len(diff.Added), len(diff.Removed))
+	}
+	if diff.Added[0].Points[0].X != 30 || diff.Removed[0].Points[0].X != 20 {
+		t.Errorf("wrong strokes reported as added/removed")
+	}
+	if diff.OldStrokes() != 3 || diff.NewStrokes() != 3 {
+		t.Errorf("wrong stroke counts %d -> %d", diff.OldStrokes(), diff.NewStrokes())
+	}
+
+	if diff := diffPage(oldPage, oldPage); diff.Status != PageUnchanged {
+		t.Errorf("expected unchanged page, got %s", diff.Status)
+	}
+
+	var buf bytes.Buffer
+	if err := diff.RenderToPNG(&buf, 20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(&buf); err != nil {
+		t.Errorf("diff image is not a PNG: %v", err)
+	}
+}
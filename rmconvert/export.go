@@ -0,0 +1,477 @@
+package rmconvert
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers"
+)
+
+// ExportOptions configures an export, zero values use the defaults of mgeta
+type ExportOptions struct {
+	DPI int
+	// OCR recognises the handwritten text with tesseract, for the formats
+	// that can hold text
+	OCR           bool
+	TesseractPath string
+	Language      string
+	PSM           int
+	// OCRRegions recognises the handwriting of the pages separately in
+	// each area with strokes instead of on the whole page, which is faster
+	// and more accurate on sparse pages
+	OCRRegions bool
+	// OCRRotate turns the pages with sideways or upside down text upright
+	// before OCR, see Tesseract.DetectOrientation
+	OCRRotate bool
+	// OCRDeskew, OCRBinarize and OCRDespeckle clean up the pages before
+	// OCR, see Preprocess
+	OCRDeskew    bool
+	OCRBinarize  bool
+	OCRDespeckle bool
+	// OCRTimeout is how long the OCR of a page may take, after which the
+	// page is left without text, no limit if zero
+	OCRTimeout time.Duration
+	// SVG configures the svg format, DefaultSVGOptions if nil
+	SVG *SVGOptions
+	// TemplatesDir is a folder with the template images of the device
+	// (/usr/share/remarkable/templates), drawn under the pages of the PDF
+	// and image formats. Pages are blank if empty.
+	TemplatesDir string
+	// DebugRender colors the strokes of the PDF and image formats by their
+	// pressure, speed or order instead of their colors
+	DebugRender DebugRender
+	// Invert draws the pages of the PDF, image and svg formats white on
+	// black, for dark screens
+	Invert bool
+	// Background is the color of the pages of the PDF, image and svg
+	// formats, see ParseBackground. The pages are white, or black when
+	// inverted, if nil; svg pages have no background then. PDF pages are
+	// white instead of transparent.
+	Background color.Color
+	// Crop trims the pages of the PDF, image and svg formats to their
+	// strokes and typed text, with a margin of CropMargin device pixels
+	Crop       bool
+	CropMargin float64
+	// Width and Height are the size in pixels of the pages of the image
+	// formats, instead of the size at DPI. The pages keep their aspect
+	// ratio, fitting in both if both are set.
+	Width, Height int
+	// Watermark is stamped over the pages of the pdf format, see
+	// Watermark.Expand for the placeholders
+	Watermark *Watermark
+	// Header and Footer are drawn at the top and the bottom of the pages
+	// of the PDF, image and svg formats, {page} and {pages} being replaced
+	// by the page number and count, and the placeholders of ForDocument
+	// by the caller. PageNumbers draws the page number at the bottom right.
+	Header, Footer string
+	PageNumbers    bool
+	// AttachSource embeds the .rmdoc file in the PDF of the pdf format, to
+	// recover the strokes from the archived PDF, see ExtractSource
+	AttachSource bool
+
+	// marks are the header, footer and page number of a page, see forPage
+	marks pageMarks
+}
+
+func (o ExportOptions) withDefaults() ExportOptions {
+	if o.DPI <= 0 {
+		o.DPI = 300
+	}
+	if o.TesseractPath == "" {
+		o.TesseractPath = "tesseract"
+	}
+	if o.Language == "" {
+		o.Language = "eng"
+	}
+	if o.PSM <= 0 {
+		o.PSM = 6
+	}
+	if o.SVG == nil {
+		svg := DefaultSVGOptions
+		o.SVG = &svg
+	}
+	return o
+}
+
+// ExportResult describes the output of an export
+type ExportResult struct {
+	// Files are the files written, formats without multi-page support
+	// write one file per page (see PagePath)
+	Files []string
+	// Text is the typed text and, with OCR, the recognised text
+	Text []PageText
+	// OCRTimeouts are the pages left without recognised text because
+	// their OCR timed out
+	OCRTimeouts []int
+}
+
+// Exporter converts .rmdoc files to an output format
+type Exporter interface {
+	// Name identifies the format, e.g. for mgeta -format
+	Name() string
+	// Extensions are the file extensions of the format, without the dot.
+	// The first one is used for the files written.
+	Extensions() []string
+	// Export converts rmdocPath to outPath, whose extension is the one of
+	// the format
+	Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error)
+}
+
+var (
+	exportersMu sync.RWMutex
+	exporters   = make(map[string]Exporter)
+)
+
+// RegisterExporter makes an exporter available by its name, replacing any
+// exporter with the same name
+func RegisterExporter(e Exporter) {
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+	exporters[strings.ToLower(e.Name())] = e
+}
+
+// Exporters returns the registered exporters sorted by name
+func Exporters() []Exporter {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+
+	result := make([]Exporter, 0, len(exporters))
+	for _, e := range exporters {
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result
+}
+
+// ExporterNames returns the names of the registered exporters, sorted
+func ExporterNames() []string {
+	var names []string
+	for _, e := range Exporters() {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// LookupExporter returns the exporter of a format, by name or extension
+func LookupExporter(format string) (Exporter, error) {
+	format = strings.ToLower(strings.TrimPrefix(format, "."))
+
+	exportersMu.RLock()
+	e, ok := exporters[format]
+	exportersMu.RUnlock()
+	if ok {
+		return e, nil
+	}
+
+	for _, e := range Exporters() {
+		for _, ext := range e.Extensions() {
+			if ext == format {
+				return e, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unknown format %s, available formats: %s", format, strings.Join(ExporterNames(), ", "))
+}
+
+// OutputPath returns the path of the file written by an exporter for a
+// document, base being its path without extension
+func OutputPath(e Exporter, base string) string {
+	return base + "." + e.Extensions()[0]
+}
+
+// PagePath returns the path of a page written by the formats that write
+// one file per page, e.g. notes_003.png for the third page of notes.png
+func PagePath(outPath string, page int) string {
+	ext := filepath.Ext(outPath)
+	return fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(outPath, ext), page, ext)
+}
+
+func init() {
+	RegisterExporter(pdfExporter{})
+	RegisterExporter(&pageExporter{name: "png", extensions: []string{"png"}, write: rasterWriter(renderers.PNG)})
+	RegisterExporter(&pageExporter{name: "tiff", extensions: []string{"tiff", "tif"}, write: rasterWriter(renderers.TIFF)})
+	RegisterExporter(&pageExporter{name: "svg", extensions: []string{"svg"}, write: func(w io.Writer, page *Page, opts ExportOptions) error {
+		svg := *opts.SVG
+		svg.Invert = svg.Invert || opts.Invert
+		if opts.Background != nil {
+			svg.Background = opts.Background
+		}
+		if opts.Crop {
+			svg.Crop, svg.CropMargin = true, opts.CropMargin
+		}
+		svg.marks = opts.marks
+		return page.GenerateSVG(w, svg)
+	}})
+	RegisterExporter(&textExporter{name: "markdown", extensions: []string{"md", "markdown"}, write: writeMarkdown})
+	RegisterExporter(&textExporter{name: "html", extensions: []string{"html", "htm"}, write: writeHTML})
+}
+
+// pdfExporter renders the pages into a PDF, searchable with OCR
+type pdfExporter struct{}
+
+func (pdfExporter) Name() string         { return "pdf" }
+func (pdfExporter) Extensions() []string { return []string{"pdf"} }
+
+func (pdfExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	opts = opts.withDefaults()
+	// the pages are embedded as opaque images at DPI
+	if isTransparent(opts.Background) {
+		opts.Background = nil
+	}
+	opts.Width, opts.Height = 0, 0
+
+	// every step runs on a partial file renamed last, so that a failed step
+	// never leaves an incomplete PDF looking up to date at outPath
+	var result *ExportResult
+	err := util.WritePathAtomic(outPath, func(partialPath string) error {
+		var err error
+		if result, err = exportPDF(goCtx, rmdocPath, partialPath, opts); err != nil {
+			return err
+		}
+		if opts.Watermark != nil {
+			if err := stampPDF(partialPath, opts.Watermark); err != nil {
+				return err
+			}
+		}
+		if opts.AttachSource {
+			if err := attachSource(partialPath, rmdocPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Files = []string{outPath}
+	return result, nil
+}
+
+// exportPDF writes the PDF of a notebook or an annotated PDF in place
+func exportPDF(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	if doc, err := OpenRmDoc(rmdocPath); err == nil {
+		defer doc.Close()
+		if background, ok := doc.BackgroundPDF(); ok {
+			return exportAnnotatedPDF(goCtx, doc, background, rmdocPath, outPath, opts)
+		}
+	}
+
+	text, timedOut, err := convertRmdocToPDFWithText(goCtx, rmdocPath, outPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportResult{Files: []string{outPath}, Text: text, OCRTimeouts: timedOut}, nil
+}
+
+// exportAnnotatedPDF writes the strokes of an annotated PDF over its pages.
+// With OCR the handwriting is recognised on the rendered pages, the text is
+// returned but not added to the PDF.
+func exportAnnotatedPDF(goCtx context.Context, doc *RmDoc, background []byte, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	var text []PageText
+	var timedOut []int
+	var err error
+	if opts.OCR {
+		// OCR runs on the rendered pages, without the background PDF
+		text, timedOut, err = recognizedText(goCtx, doc, opts)
+	} else {
+		text, err = doc.TypedText()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ComposeAnnotatedPDF(goCtx, doc, background, outPath); err != nil {
+		return nil, err
+	}
+	if opts.Header != "" || opts.Footer != "" || opts.PageNumbers {
+		if err := stampPageMarks(outPath, opts); err != nil {
+			return nil, err
+		}
+	}
+	return &ExportResult{Files: []string{outPath}, Text: text, OCRTimeouts: timedOut}, nil
+}
+
+// pageExporter writes each page to a file
+type pageExporter struct {
+	name       string
+	extensions []string
+	write      func(w io.Writer, page *Page, opts ExportOptions) error
+}
+
+// rasterWriter renders pages at the resolution of the options with a
+// canvas renderer
+func rasterWriter(renderer func(opts ...interface{}) canvas.Writer) func(io.Writer, *Page, ExportOptions) error {
+	return func(w io.Writer, page *Page, opts ExportOptions) error {
+		return page.renderWith(opts).Write(w, renderer())
+	}
+}
+
+func (e *pageExporter) Name() string         { return e.name }
+func (e *pageExporter) Extensions() []string { return e.extensions }
+
+func (e *pageExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	opts = opts.withDefaults()
+
+	pageOrder, pages, err := loadRmdocPages(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(pageOrder) == 0 {
+		return nil, fmt.Errorf("no pages found in document")
+	}
+	pageOrder, pages = sheetPages(pageOrder, pages)
+
+	result := &ExportResult{}
+	for i, id := range pageOrder {
+		if err := goCtx.Err(); err != nil {
+			return nil, err
+		}
+
+		pagePath := PagePath(outPath, i+1)
+		err := util.WriteFileAtomic(pagePath, func(w io.Writer) error {
+			return e.write(w, pages[id], opts.forPage(i+1, len(pageOrder)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %v", i+1, err)
+		}
+		result.Files = append(result.Files, pagePath)
+		if text := strings.TrimSpace(pages[id].Text); text != "" {
+			result.Text = append(result.Text, PageText{Page: i + 1, Source: TextSourceTyped, Text: text})
+		}
+	}
+	removeStalePages(outPath, len(pageOrder))
+	return result, nil
+}
+
+// removeStalePages removes the pages of a previous export of outPath past
+// the last page, e.g. after pages of the document were deleted
+func removeStalePages(outPath string, pages int) {
+	for page := pages + 1; ; page++ {
+		if err := os.Remove(PagePath(outPath, page)); err != nil {
+			if !os.IsNotExist(err) {
+				log.Warning.Printf("failed to remove a stale page: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// textExporter writes the text of the document, with OCR for the
+// handwritten pages
+type textExporter struct {
+	name       string
+	extensions []string
+	write      func(w io.Writer, title string, pages []*Page, text []PageText, dpi int) error
+}
+
+func (e *textExporter) Name() string         { return e.name }
+func (e *textExporter) Extensions() []string { return e.extensions }
+
+func (e *textExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	opts = opts.withDefaults()
+
+	pageOrder, pagesByID, err := loadRmdocPages(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]*Page, len(pageOrder))
+	for i, id := range pageOrder {
+		pages[i] = pagesByID[id]
+	}
+
+	var text []PageText
+	var timedOut []int
+	if opts.OCR {
+		// OCR runs on the rendered pages, no PDF is written
+		doc, err := OpenRmDoc(rmdocPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open .rmdoc: %v", err)
+		}
+		text, timedOut, err = recognizedText(goCtx, doc, opts)
+		doc.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		text, err = ExtractTypedText(rmdocPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	title := strings.TrimSuffix(filepath.Base(outPath), filepath.Ext(outPath))
+	err = util.WriteFileAtomic(outPath, func(w io.Writer) error {
+		return e.write(w, title, pages, text, opts.DPI)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ExportResult{Files: []string{outPath}, Text: text, OCRTimeouts: timedOut}, nil
+}
+
+// pageTexts groups the text by page, typed text first
+func pageTexts(text []PageText, pages int) [][]string {
+	result := make([][]string, pages)
+	sorted := append([]PageText(nil), text...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Source > sorted[j].Source })
+	for _, t := range sorted {
+		if t.Page >= 1 && t.Page <= pages {
+			result[t.Page-1] = append(result[t.Page-1], t.Text)
+		}
+	}
+	return result
+}
+
+func writeMarkdown(w io.Writer, title string, pages []*Page, text []PageText, _ int) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	for i, paragraphs := range pageTexts(text, len(pages)) {
+		fmt.Fprintf(&b, "\n## Page %d\n", i+1)
+		for _, p := range paragraphs {
+			fmt.Fprintf(&b, "\n%s\n", p)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeHTML writes a page with the image and the text of each page
+func writeHTML(w io.Writer, title string, pages []*Page, text []PageText, dpi int) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n<h1>%s</h1>\n",
+		html.EscapeString(title), html.EscapeString(title))
+
+	texts := pageTexts(text, len(pages))
+	for i, page := range pages {
+		var img bytes.Buffer
+		if err := page.ConvertToPNG(&img, dpi); err != nil {
+			return fmt.Errorf("page %d: %v", i+1, err)
+		}
+
+		fmt.Fprintf(&b, "<section id=\"page-%d\">\n<h2>Page %d</h2>\n", i+1, i+1)
+		fmt.Fprintf(&b, "<img style=\"max-width: 100%%\" alt=\"Page %d\" src=\"data:image/png;base64,%s\">\n", i+1, base64.StdEncoding.EncodeToString(img.Bytes()))
+		for _, p := range texts[i] {
+			fmt.Fprintf(&b, "<p>%s</p>\n", strings.ReplaceAll(html.EscapeString(p), "\n", "<br>\n"))
+		}
+		b.WriteString("</section>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
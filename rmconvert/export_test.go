@@ -0,0 +1,300 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/juruen/rmapi/util"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func TestLookupExporter(t *testing.T) {
+	for format, name := range map[string]string{
+		"pdf":  "pdf",
+		"PNG":  "png",
+		".tif": "tiff",
+		"md":   "markdown",
+		"html": "html",
+		"svg":  "svg",
+	} {
+		e, err := LookupExporter(format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if e.Name() != name {
+			t.Errorf("%s: got exporter %s, want %s", format, e.Name(), name)
+		}
+	}
+
+	_, err := LookupExporter("docx")
+	if err == nil || !strings.Contains(err.Error(), "markdown") {
+		t.Errorf("expected an error listing the formats, got %v", err)
+	}
+}
+
+func TestExporters(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatalf("Failed to create test .rmdoc: %v", err)
+	}
+
+	// the pages of a previous export of a longer document
+	for page := 2; page <= 4; page++ {
+		if err := os.WriteFile(PagePath(filepath.Join(tempDir, "notes.png"), page), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{"png", "svg", "markdown", "html", "cbz", "highlights-json", "text-json"} {
+		e, err := LookupExporter(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outPath := OutputPath(e, filepath.Join(tempDir, "notes"))
+		result, err := e.Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30})
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if len(result.Files) == 0 {
+			t.Errorf("%s: no files written", format)
+		}
+		for _, f := range result.Files {
+			if stat, err := os.Stat(f); err != nil || stat.Size() == 0 {
+				t.Errorf("%s: %s missing or empty", format, f)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "notes_001.png")); err != nil {
+		t.Errorf("page not written: %v", err)
+	}
+	for page := 2; page <= 4; page++ {
+		if _, err := os.Stat(PagePath(filepath.Join(tempDir, "notes.png"), page)); !os.IsNotExist(err) {
+			t.Errorf("stale page %d left behind: %v", page, err)
+		}
+	}
+
+	r, err := zip.OpenReader(filepath.Join(tempDir, "notes.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "001.png,ComicInfo.xml" {
+		t.Errorf("unexpected cbz entries %v", names)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatal(err)
+	}
+
+	watermark := (&Watermark{Text: "CONFIDENTIAL {name} {date}"}).Expand("/Work/Plans", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if watermark.Text != "CONFIDENTIAL Plans 2024-03-01" {
+		t.Errorf("unexpected expanded text %q", watermark.Text)
+	}
+
+	outPath := filepath.Join(tempDir, "notes.pdf")
+	if _, err := (pdfExporter{}).Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30, Watermark: watermark}); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := api.HasWatermarksFile(outPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("expected a watermark")
+	}
+
+	// the page marks of annotated PDFs
+	outPath = filepath.Join(tempDir, "marks.pdf")
+	if _, err := (pdfExporter{}).Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stampPageMarks(outPath, ExportOptions{Header: "100% {page}", PageNumbers: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := api.HasWatermarksFile(outPath, nil); err != nil || !ok {
+		t.Errorf("expected the page marks to be stamped: %v", err)
+	}
+
+	// the source .rmdoc is attached and recovered
+	outPath = filepath.Join(tempDir, "source.pdf")
+	if _, err := (pdfExporter{}).Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30, AttachSource: true}); err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ExtractSource(outPath, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(rmdocPath)
+	if data, err := os.ReadFile(extracted[0]); err != nil || !bytes.Equal(data, original) {
+		t.Errorf("the extracted %v differs from the source: %v", extracted, err)
+	}
+
+	if err := (&Watermark{Text: "x", Style: "rotation:abc"}).Check(); err == nil {
+		t.Error("expected an error for an invalid style")
+	}
+
+	// a failed stamp leaves neither the unstamped PDF nor the partial file
+	outPath = filepath.Join(tempDir, "failed.pdf")
+	if _, err := (pdfExporter{}).Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30, Watermark: &Watermark{Text: "x", Style: "rotation:abc"}}); err == nil {
+		t.Error("expected an error for an invalid watermark")
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("unstamped PDF left behind: %v", err)
+	}
+	if partials, _ := filepath.Glob(outPath + ".*" + util.PartialSuffix); len(partials) > 0 {
+		t.Errorf("partial files left behind: %v", partials)
+	}
+}
+
+func TestCombinePDFs(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatal(err)
+	}
+	var inputs []CombineInput
+	for _, title := range []string{"First", "Second"} {
+		outPath := filepath.Join(tempDir, title+".pdf")
+		if _, err := (pdfExporter{}).Export(context.Background(), rmdocPath, outPath, ExportOptions{DPI: 30}); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, CombineInput{Title: title, Path: outPath})
+	}
+
+	combined := filepath.Join(tempDir, "combined.pdf")
+	if err := CombinePDFs(inputs, combined, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(combined, nil); err != nil {
+		t.Errorf("invalid combined PDF: %v", err)
+	}
+	if partials, _ := filepath.Glob(combined + ".*" + util.PartialSuffix); len(partials) > 0 {
+		t.Errorf("partial files left behind: %v", partials)
+	}
+	count, err := api.PageCountFile(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d pages, want the contents and 2 documents", count)
+	}
+
+	f, err := os.Open(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	bookmarks, err := api.Bookmarks(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarks) != 2 || bookmarks[0].PageFrom != 2 || bookmarks[1].PageFrom != 3 {
+		t.Errorf("unexpected bookmarks %+v", bookmarks)
+	}
+
+	toc := layoutTableOfContents([]pdfcpu.Bookmark{{Title: "First", PageFrom: 1}, {Title: "Second", PageFrom: 2}}, 445, 594)
+	links := toc.links()
+	if toc.pages != 1 || len(links[1]) != 2 {
+		t.Errorf("got %d pages of contents and links %v", toc.pages, links)
+	}
+}
+
+func TestTextLayout(t *testing.T) {
+	page := &Page{Width: 1404, Height: 1872, TextBox: &rm.Text{X: -468, Y: 234, Width: 936, Paragraphs: []rm.TextParagraph{
+		{Style: rm.StylePlain, Spans: []rm.TextSpan{{Text: "typed "}, {Text: "words", Bold: true}}},
+	}}}
+	lines := typedTextLines(page)
+	if len(lines) != 1 || lines[0].Text != "typed words" || lines[0].Source != TextSourceTyped {
+		t.Fatalf("expected one typed line, got %+v", lines)
+	}
+	words := lines[0].Words
+	if len(words) != 2 || words[0].BBox[2] > words[1].BBox[0] || words[0].Confidence != nil {
+		t.Errorf("unexpected words %+v", words)
+	}
+	if box := lines[0].BBox; box[0] != words[0].BBox[0] || box[2] != words[1].BBox[2] || box[1] >= box[3] {
+		t.Errorf("unexpected line box %v", box)
+	}
+
+	ocr := PageOCR{ImgW: 702, ImgH: 936, Words: []Word{
+		{Text: "hand", X1: 10, Y1: 10, X2: 50, Y2: 30, Confidence: 90, Line: 1},
+		{Text: "written", X1: 60, Y1: 12, X2: 120, Y2: 32, Confidence: 80, Line: 1},
+		{Text: "below", X1: 10, Y1: 50, X2: 60, Y2: 70, Confidence: -1, Line: 2},
+	}}
+	lines = ocrTextLines(ocr, 1404, 0)
+	if len(lines) != 2 || lines[0].Text != "hand written" || lines[0].Source != TextSourceOCR {
+		t.Fatalf("expected two OCR lines, got %+v", lines)
+	}
+	if lines[0].BBox != [4]float64{20, 20, 240, 64} {
+		t.Errorf("expected the line in device pixels, got %v", lines[0].BBox)
+	}
+	if c := lines[0].Words[1].Confidence; c == nil || *c != 80 {
+		t.Errorf("expected the confidence of the word, got %v", c)
+	}
+	if lines[1].Words[0].Confidence != nil {
+		t.Errorf("unknown confidence should be left out")
+	}
+
+	layout := TextLayout{Pages: []TextLayoutPage{{Page: 1, Lines: lines}}}
+	text := layout.pageTexts()
+	if len(text) != 1 || text[0].Text != "hand written\nbelow" {
+		t.Errorf("unexpected page text %+v", text)
+	}
+}
+
+func TestWriteHighlights(t *testing.T) {
+	docs := []DocumentHighlights{
+		{Title: "Book", Highlights: []Highlight{
+			{Page: 2, Text: "first", Color: "yellow"},
+			{Page: 2, Text: "second, \"quoted\"", Color: "green"},
+			{Page: 5, Text: "third", Color: "yellow"},
+		}},
+		{Title: "Paper"},
+	}
+
+	var md bytes.Buffer
+	if err := WriteHighlightsMarkdown(&md, docs); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Book\n\n## Page 2\n\n> first\n\n> second, \"quoted\"\n\n## Page 5\n\n> third\n\n# Paper\n"
+	if md.String() != want {
+		t.Errorf("unexpected markdown:\n%s", md.String())
+	}
+
+	var csv bytes.Buffer
+	if err := WriteHighlightsCSV(&csv, docs); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != 4 || lines[2] != `"second, ""quoted""",Book,2,page,,green` {
+		t.Errorf("unexpected csv:\n%s", csv.String())
+	}
+
+	var js bytes.Buffer
+	if err := WriteHighlightsJSON(&js, docs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(js.String(), `"highlights": []`) {
+		t.Errorf("documents without highlights should have an empty list:\n%s", js.String())
+	}
+}
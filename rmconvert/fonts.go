@@ -0,0 +1,228 @@
+package rmconvert
+
+import (
+	"embed"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/font"
+)
+
+// bundledFonts are the files of DejaVu Sans Condensed, see fonts/LICENSE
+//
+//go:embed fonts/*.ttf
+var bundledFonts embed.FS
+
+// Font is a font family the typed text and the OCR text layer of PDFs are
+// written with. It is embedded in the exports, with only the glyphs they
+// use.
+type Font struct {
+	// Name is the family name of the font
+	Name string
+	// files are the TrueType or OpenType files of the styles of the
+	// family, the missing styles are synthesized from the regular one
+	files map[canvas.FontStyle][]byte
+
+	once   sync.Once
+	family *canvas.FontFamily
+}
+
+// TextFont is the font of the exports, the bundled DejaVu Sans Condensed
+// unless set with the global -font flag
+var TextFont = bundledFont()
+
+func bundledFont() *Font {
+	f := &Font{Name: "DejaVu Sans Condensed", files: map[canvas.FontStyle][]byte{}}
+	for style, name := range map[canvas.FontStyle]string{
+		canvas.FontRegular:                     "DejaVuSansCondensed.ttf",
+		canvas.FontBold:                        "DejaVuSansCondensed-Bold.ttf",
+		canvas.FontRegular | canvas.FontItalic: "DejaVuSansCondensed-Oblique.ttf",
+		canvas.FontBold | canvas.FontItalic:    "DejaVuSansCondensed-BoldOblique.ttf",
+	} {
+		b, err := bundledFonts.ReadFile("fonts/" + name)
+		if err != nil {
+			panic(err)
+		}
+		f.files[style] = b
+	}
+	return f
+}
+
+// fontStyleSuffixes are the suffixes of the file names of the styles of a
+// family, after the name of the family
+var fontStyleSuffixes = map[canvas.FontStyle][]string{
+	canvas.FontBold:                        {"-Bold", "Bold", "bd"},
+	canvas.FontRegular | canvas.FontItalic: {"-Italic", "-Oblique", "Italic", "i"},
+	canvas.FontBold | canvas.FontItalic:    {"-BoldItalic", "-BoldOblique", "BoldItalic", "bi"},
+}
+
+// LoadFont loads a TrueType, OpenType or WOFF font file. The bold, italic
+// and bold italic files of the family are loaded too when they are next to
+// it, named like NotoSans-Regular.ttf and NotoSans-Bold.ttf.
+func LoadFont(path string) (*Font, error) {
+	regular, err := readFontFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sfnt, err := font.ParseSFNT(regular, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ext), "-Regular")
+	f := &Font{Name: fontName(sfnt, font.NameFontFamily), files: map[canvas.FontStyle][]byte{canvas.FontRegular: regular}}
+	if f.Name == "" {
+		f.Name = filepath.Base(base)
+	}
+	for style, suffixes := range fontStyleSuffixes {
+		for _, suffix := range suffixes {
+			if b, err := readFontFile(base + suffix + ext); err == nil {
+				f.files[style] = b
+				break
+			}
+		}
+	}
+	return f, nil
+}
+
+// readFontFile reads a font file as TrueType or OpenType, the format of
+// the PDF and SVG exports
+func readFontFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = font.ToSFNT(b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return b, nil
+}
+
+// fontName returns a name of the name table of a font, empty if it has
+// none
+func fontName(sfnt *font.SFNT, id font.NameID) string {
+	for _, record := range sfnt.Name.Get(id) {
+		if name := record.String(); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// fontFamily returns the family of the font to draw on a canvas
+func (f *Font) fontFamily() *canvas.FontFamily {
+	f.once.Do(func() {
+		f.family = canvas.NewFontFamily(f.Name)
+		for style, b := range f.files {
+			f.family.MustLoadFont(b, 0, style)
+		}
+	})
+	return f.family
+}
+
+// fontStyle returns the style of a face of the font
+func fontStyle(bold, italic bool) canvas.FontStyle {
+	style := canvas.FontRegular
+	if bold {
+		style = canvas.FontBold
+	}
+	if italic {
+		style |= canvas.FontItalic
+	}
+	return style
+}
+
+// fontSubset is a font reduced to the glyphs of a text
+type fontSubset struct {
+	// data is the font file of the subset
+	data []byte
+	// cff is set for OpenType fonts with PostScript outlines
+	cff bool
+	// glyphs are the glyph IDs of the subset by character, the missing
+	// characters have the glyph 0
+	glyphs map[rune]uint16
+	// runes are the characters of the glyphs of the subset, by glyph ID
+	runes []rune
+	// widths are the advances of the glyphs of the subset by glyph ID, in
+	// thousandths of the font size
+	widths []int
+
+	// the metrics of the font in thousandths of the font size, and its
+	// PostScript name, for the font descriptors of PDFs
+	postScriptName             string
+	ascent, descent, capHeight int
+	bbox                       [4]int
+	italicAngle                float64
+}
+
+// subset returns the file of style, or of the regular style if the font
+// doesn't have it, with only the
+// glyphs of text. The tables are those of a standalone font file, or those
+// needed in a PDF only.
+func (f *Font) subset(style canvas.FontStyle, text string, forPDF bool) (*fontSubset, error) {
+	b, ok := f.files[style]
+	if !ok {
+		b = f.files[canvas.FontRegular]
+	}
+	// parsed for each subset as subsetting changes the font
+	sfnt, err := font.ParseSFNT(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(text)
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	units := 1000 / float64(sfnt.Head.UnitsPerEm)
+	scale := func(v int16) int { return int(math.Round(float64(v) * units)) }
+	s := &fontSubset{
+		glyphs:         map[rune]uint16{},
+		runes:          []rune{0},
+		widths:         []int{int(math.Round(float64(sfnt.GlyphAdvance(0)) * units))},
+		postScriptName: strings.ReplaceAll(fontName(sfnt, font.NamePostScript), " ", ""),
+		ascent:         scale(sfnt.Hhea.Ascender),
+		descent:        scale(sfnt.Hhea.Descender),
+		bbox:           [4]int{scale(sfnt.Head.XMin), scale(sfnt.Head.YMin), scale(sfnt.Head.XMax), scale(sfnt.Head.YMax)},
+		italicAngle:    sfnt.Post.ItalicAngle,
+	}
+	if sfnt.OS2 != nil {
+		s.capHeight = scale(sfnt.OS2.SCapHeight)
+	}
+	if s.postScriptName == "" {
+		s.postScriptName = strings.ReplaceAll(f.Name, " ", "")
+	}
+	glyphIDs := []uint16{0}
+	for i, r := range runes {
+		if i > 0 && r == runes[i-1] {
+			continue
+		}
+		glyphID := sfnt.GlyphIndex(r)
+		if glyphID == 0 {
+			s.glyphs[r] = 0
+			continue
+		}
+		s.glyphs[r] = uint16(len(glyphIDs))
+		s.runes = append(s.runes, r)
+		s.widths = append(s.widths, int(math.Round(float64(sfnt.GlyphAdvance(glyphID))*units)))
+		glyphIDs = append(glyphIDs, glyphID)
+	}
+
+	tables := font.KeepMinTables
+	if forPDF {
+		tables = font.KeepPDFTables
+	}
+	subset, err := sfnt.Subset(glyphIDs, font.SubsetOptions{Tables: tables})
+	if err != nil {
+		return nil, err
+	}
+	s.data = subset.Write()
+	s.cff = subset.IsCFF
+	return s, nil
+}
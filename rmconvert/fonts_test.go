@@ -0,0 +1,71 @@
+package rmconvert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+)
+
+func TestLoadFont(t *testing.T) {
+	dir := t.TempDir()
+	for name, style := range map[string]canvas.FontStyle{
+		"Sample-Regular.ttf": canvas.FontRegular,
+		"Sample-Bold.ttf":    canvas.FontBold,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), bundledFont().files[style], 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := LoadFont(filepath.Join(dir, "Sample-Regular.ttf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "DejaVu Sans Condensed" {
+		t.Errorf("got name %q", f.Name)
+	}
+	// the bold file next to it is found, the italic styles are synthesized
+	if len(f.files) != 2 || f.files[canvas.FontBold] == nil {
+		t.Errorf("expected the regular and bold styles, got %d styles", len(f.files))
+	}
+
+	if _, err := LoadFont(filepath.Join(dir, "missing.ttf")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bad.ttf"), []byte("not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFont(filepath.Join(dir, "bad.ttf")); err == nil {
+		t.Error("expected an error for an invalid file")
+	}
+}
+
+func TestFontSubset(t *testing.T) {
+	subset, err := TextFont.subset(canvas.FontBold, "Привет café ☃", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subset.data) > 20<<10 {
+		t.Errorf("expected a small subset, got %d bytes", len(subset.data))
+	}
+	// .notdef and the characters once each
+	if len(subset.runes) != 13 || len(subset.widths) != len(subset.runes) {
+		t.Errorf("got %d glyphs and %d widths", len(subset.runes), len(subset.widths))
+	}
+
+	// the subset is a font file with the glyphs of the text
+	f, err := canvas.LoadFont(subset.data, 0, canvas.FontBold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range "Пé" {
+		if got := f.GlyphIndex(r); got == 0 || got != subset.glyphs[r] {
+			t.Errorf("glyph of %c: got %d, want %d", r, got, subset.glyphs[r])
+		}
+	}
+	if subset.glyphs['x'] != 0 {
+		t.Error("expected no glyph for characters not in the text")
+	}
+}
@@ -0,0 +1,444 @@
+package rmconvert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juruen/rmapi/encoding/rm"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the generated pages and the expected images of testdata/golden")
+
+const (
+	goldenDir = "testdata/golden"
+	goldenDPI = 100
+	// goldenHashTolerance is the number of bits of the 256 bit perceptual
+	// hashes that may differ
+	goldenHashTolerance = 8
+	// goldenToneTolerance is how much the average color of a cell of the
+	// image, about an eighth of an inch, may differ, out of 255
+	goldenToneTolerance = 12
+)
+
+// goldenCases are the pages of testdata/golden rendered and compared with
+// their expected image, <name>.png. The tools pages have a stroke of each
+// tool in each color, the text page typed text in each style, they are
+// generated with -update.
+var goldenCases = []struct {
+	name string
+	page string
+}{
+	{"notes_v3", "notes_v3.rm"},
+	{"notes_v5", "notes_v5.rm"},
+	{"tools_v5", "tools_v5.rm"},
+	{"tools_v6", "tools_v6.rm"},
+	{"text_v6", "text_v6.rm"},
+}
+
+// go test ./rmconvert -run TestGolden -update rewrites the expected images
+// after an intended rendering change, review them before committing
+func TestGoldenImages(t *testing.T) {
+	if *updateGolden {
+		writeToolsPages(t)
+	}
+
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strokes, err := os.ReadFile(filepath.Join(goldenDir, tc.page))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := OpenRmDoc(writeTestZip(t, map[string][]byte{
+				"doc.content": []byte(`{"cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}]}}`),
+				"doc/p1.rm":   strokes,
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer doc.Close()
+			img := renderPage(doc, "p1", ExportOptions{DPI: goldenDPI}.withDefaults())
+
+			goldenPath := filepath.Join(goldenDir, tc.name+".png")
+			if *updateGolden {
+				if err := writePNGFile(img, goldenPath); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			f, err := os.Open(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			want, err := png.Decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := compareImages(img, want); err != nil {
+				t.Errorf("%v, got image differs from %s (rewrite it with -update if intended)", err, goldenPath)
+			}
+		})
+	}
+}
+
+func TestCompareImages(t *testing.T) {
+	page := CreateTestPage()
+	want := rasterize(page.render(goldenDPI))
+	if err := compareImages(want, want); err != nil {
+		t.Errorf("identical images: %v", err)
+	}
+
+	// a missing stroke
+	missing := *page
+	missing.Strokes = page.Strokes[:1]
+	if err := compareImages(rasterize(missing.render(goldenDPI)), want); err == nil {
+		t.Error("expected a difference without the second stroke")
+	}
+
+	// gray instead of black strokes
+	gray := *page
+	gray.Strokes = []Stroke{page.Strokes[0], page.Strokes[1]}
+	for i := range gray.Strokes {
+		gray.Strokes[i].Color = ColorGray
+		gray.Strokes[i].Width = 30
+	}
+	black := *page
+	black.Strokes = []Stroke{page.Strokes[0], page.Strokes[1]}
+	for i := range black.Strokes {
+		black.Strokes[i].Width = 30
+	}
+	if err := compareImages(rasterize(gray.render(goldenDPI)), rasterize(black.render(goldenDPI))); err == nil {
+		t.Error("expected a difference between gray and black strokes")
+	}
+}
+
+// compareImages tells whether two renderings of a page look the same: the
+// perceptual hashes of their shapes and the average colors of their
+// regions are within the golden tolerances
+func compareImages(got, want image.Image) error {
+	if got.Bounds().Size() != want.Bounds().Size() {
+		return fmt.Errorf("size %v, want %v", got.Bounds().Size(), want.Bounds().Size())
+	}
+
+	if d := hashDistance(differenceHash(got), differenceHash(want)); d > goldenHashTolerance {
+		return fmt.Errorf("perceptual hashes differ by %d bits", d)
+	}
+	gotCells, wantCells := imageCells(got, toneWidth, toneHeight), imageCells(want, toneWidth, toneHeight)
+	for i := range gotCells {
+		for c := 0; c < 3; c++ {
+			if d := math.Abs(gotCells[i][c] - wantCells[i][c]); d > goldenToneTolerance {
+				return fmt.Errorf("average color of cell %d differs by %.0f", i, d)
+			}
+		}
+	}
+	return nil
+}
+
+// The grids of cells of the perceptual hash and of the comparison of the
+// colors
+const (
+	hashWidth  = 17
+	hashHeight = 16
+	toneWidth  = 48
+	toneHeight = 64
+)
+
+// imageCells returns the average color of the cells of a grid of width x
+// height over an image, row by row, in 0-255
+func imageCells(img image.Image, width, height int) [][3]float64 {
+	bounds := img.Bounds()
+	cells := make([][3]float64, width*height)
+	counts := make([]int, len(cells))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := row*width + (x-bounds.Min.X)*width/bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			cells[i][0] += float64(r >> 8)
+			cells[i][1] += float64(g >> 8)
+			cells[i][2] += float64(b >> 8)
+			counts[i]++
+		}
+	}
+	for i := range cells {
+		for c := range cells[i] {
+			cells[i][c] /= float64(counts[i])
+		}
+	}
+	return cells
+}
+
+// differenceHash is the dHash of an image: a bit per pair of horizontally
+// adjacent cells of a hashWidth x hashHeight grid telling whether the left
+// one is brighter
+func differenceHash(img image.Image) [4]uint64 {
+	cells := imageCells(img, hashWidth, hashHeight)
+	var hash [4]uint64
+	bit := 0
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			left, right := cells[y*hashWidth+x], cells[y*hashWidth+x+1]
+			if left[0]+left[1]+left[2] > right[0]+right[1]+right[2] {
+				hash[bit/64] |= 1 << (bit % 64)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+func hashDistance(a, b [4]uint64) int {
+	d := 0
+	for i := range a {
+		d += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return d
+}
+
+// toolsPageLines returns a stroke of each tool in each color, a row per
+// tool and a column per color. A black band is drawn under the column of
+// white strokes so that they show.
+func toolsPageLines(tools []rm.BrushType, colors []rm.BrushColor) []rm.Line {
+	wave := func(x0, y0 float32) []rm.Point {
+		points := make([]rm.Point, 40)
+		for i := range points {
+			t := float64(i) / float64(len(points)-1)
+			points[i] = rm.Point{X: x0 + float32(t*240), Y: y0 + float32(20*math.Sin(t*2*math.Pi)), Width: 6, Pressure: 0.5 + float32(t)/2}
+		}
+		return points
+	}
+
+	var lines []rm.Line
+	for j, color := range colors {
+		if color == rm.White {
+			x := float32(100 + 320*j + 120)
+			lines = append(lines, rm.Line{BrushType: rm.FinelinerV5, BrushColor: rm.Black, BrushSize: 200,
+				Points: []rm.Point{{X: x, Y: 80}, {X: x, Y: float32(80 + 100*len(tools))}}})
+		}
+	}
+	for i, tool := range tools {
+		for j, color := range colors {
+			lines = append(lines, rm.Line{BrushType: tool, BrushColor: color, BrushSize: 6, Points: wave(float32(100+320*j), float32(130+100*i))})
+		}
+	}
+	return lines
+}
+
+// writeToolsPages writes the tools pages of the golden tests, in the v5 and
+// v6 formats
+func writeToolsPages(t *testing.T) {
+	v5 := toolsPageLines([]rm.BrushType{
+		rm.BallPointV5, rm.MarkerV5, rm.FinelinerV5, rm.SharpPencilV5, rm.TiltPencilV5, rm.BrushV5, rm.HighlighterV5,
+		rm.BallPoint, rm.Marker, rm.Fineliner, rm.SharpPencil, rm.TiltPencil, rm.Brush, rm.Highlighter,
+		rm.Eraser, rm.EraseArea,
+	}, []rm.BrushColor{rm.Black, rm.Grey, rm.White})
+	if err := os.WriteFile(filepath.Join(goldenDir, "tools_v5.rm"), encodeV5(v5), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// v6 tool and color IDs, unknown colors are drawn black
+	v6 := toolsPageLines([]rm.BrushType{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 13, 14, 15, 16, 17, 18, 21},
+		[]rm.BrushColor{0, 1, 2, 6})
+	if err := os.WriteFile(filepath.Join(goldenDir, "tools_v6.rm"), encodeV6(v6, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// typed text in each paragraph style and emphasis, under a stroke
+	text := &rm.Text{X: -468, Y: 234, Width: 936, Paragraphs: []rm.TextParagraph{
+		{Style: rm.StyleHeading, Spans: []rm.TextSpan{{Text: "Typed text"}}},
+		{Style: rm.StylePlain, Spans: []rm.TextSpan{{Text: "Plain text with "}, {Text: "bold", Bold: true}, {Text: ", "},
+			{Text: "italic", Italic: true}, {Text: " and "}, {Text: "bold italic", Bold: true, Italic: true},
+			{Text: " words, long enough to wrap over several lines of the text box."}}},
+		{Style: rm.StyleBold, Spans: []rm.TextSpan{{Text: "Bold paragraph"}}},
+		{Style: rm.StyleBullet, Spans: []rm.TextSpan{{Text: "Bullet"}}},
+		{Style: rm.StyleBullet2, Spans: []rm.TextSpan{{Text: "Nested bullet"}}},
+		{Style: rm.StyleCheckbox, Spans: []rm.TextSpan{{Text: "Checkbox"}}},
+		{Style: rm.StyleCheckboxChecked, Spans: []rm.TextSpan{{Text: "Checked checkbox"}}},
+	}}
+	underline := toolsPageLines([]rm.BrushType{4}, []rm.BrushColor{0})
+	if err := os.WriteFile(filepath.Join(goldenDir, "text_v6.rm"), encodeV6(underline, text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// encodeV5 writes a v5 page of a single layer
+func encodeV5(lines []rm.Line) []byte {
+	var b bytes.Buffer
+	b.WriteString(rm.HeaderV5)
+	binary.Write(&b, binary.LittleEndian, uint32(1))
+	binary.Write(&b, binary.LittleEndian, uint32(len(lines)))
+	for _, line := range lines {
+		for _, v := range []any{uint32(line.BrushType), uint32(line.BrushColor), uint32(0), float32(line.BrushSize), float32(0), uint32(len(line.Points))} {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+		for _, p := range line.Points {
+			binary.Write(&b, binary.LittleEndian, []float32{p.X, p.Y, p.Speed, p.Direction, p.Width, p.Pressure})
+		}
+	}
+	return b.Bytes()
+}
+
+// encodeV6 writes a v6 page with a scene item block per line, the brush
+// types of the lines being v6 tool IDs and their brush size twice the
+// thickness scale, and a root text block if text isn't nil
+func encodeV6(lines []rm.Line, text *rm.Text) []byte {
+	var file bytes.Buffer
+	file.WriteString(rm.HeaderV6)
+	for i, line := range lines {
+		var item bytes.Buffer
+		item.WriteByte(rm.ITEM_TYPE_LINE)
+		putV6Tag(&item, 1, rm.TAG_BYTE4)
+		binary.Write(&item, binary.LittleEndian, uint32(line.BrushType))
+		putV6Tag(&item, 2, rm.TAG_BYTE4)
+		binary.Write(&item, binary.LittleEndian, uint32(line.BrushColor))
+		putV6Tag(&item, 3, rm.TAG_BYTE8)
+		binary.Write(&item, binary.LittleEndian, float64(line.BrushSize)/2)
+		putV6Tag(&item, 4, rm.TAG_BYTE4)
+		binary.Write(&item, binary.LittleEndian, float32(0))
+		putV6Tag(&item, 5, rm.TAG_LENGTH4)
+		binary.Write(&item, binary.LittleEndian, uint32(14*len(line.Points)))
+		for _, p := range line.Points {
+			binary.Write(&item, binary.LittleEndian, p.X)
+			binary.Write(&item, binary.LittleEndian, p.Y)
+			binary.Write(&item, binary.LittleEndian, uint16(p.Speed*4))
+			binary.Write(&item, binary.LittleEndian, uint16(p.Width*4))
+			item.WriteByte(uint8(p.Direction))
+			item.WriteByte(uint8(p.Pressure * 255))
+		}
+
+		var data bytes.Buffer
+		putV6ID(&data, 1, 0, 11)
+		putV6ID(&data, 2, 1, uint64(100+i))
+		putV6ID(&data, 3, 0, 0)
+		putV6ID(&data, 4, 0, 0)
+		putV6Tag(&data, 5, rm.TAG_BYTE4)
+		binary.Write(&data, binary.LittleEndian, uint32(0))
+		putV6Subblock(&data, 6, item.Bytes())
+		putV6Block(&file, rm.BLOCK_SCENE_ITEM, data.Bytes())
+	}
+	if text != nil {
+		putV6Block(&file, rm.BLOCK_ROOT_TEXT, encodeV6Text(text))
+	}
+	return file.Bytes()
+}
+
+// encodeV6Text returns the data of the root text block of a text, a
+// sequence of text items with formatting codes switching the emphasis of
+// the spans
+func encodeV6Text(text *rm.Text) []byte {
+	var items, styles bytes.Buffer
+	count, styleCount := 0, 0
+	next := uint64(1)
+	var bold, italic bool
+	addItem := func(value string, format uint32) {
+		var item, s bytes.Buffer
+		putV6ID(&item, 2, 1, next)
+		left := uint64(0)
+		if next > 1 {
+			left = next - 1
+		}
+		putV6ID(&item, 3, 1, left)
+		putV6ID(&item, 4, 0, 0)
+		putV6Tag(&item, 5, rm.TAG_BYTE4)
+		binary.Write(&item, binary.LittleEndian, uint32(0))
+		putV6Varint(&s, uint64(len(value)))
+		s.WriteByte(1)
+		s.WriteString(value)
+		if format != 0 {
+			putV6Tag(&s, 2, rm.TAG_BYTE4)
+			binary.Write(&s, binary.LittleEndian, format)
+		}
+		putV6Subblock(&item, 6, s.Bytes())
+		putV6Subblock(&items, 0, item.Bytes())
+		count++
+		next += uint64(max(len(value), 1))
+	}
+	addStyle := func(part1 uint8, part2 uint64, style rm.ParagraphStyle) {
+		styles.WriteByte(part1)
+		putV6Varint(&styles, part2)
+		putV6ID(&styles, 1, 1, 1)
+		putV6Subblock(&styles, 2, []byte{17, byte(style)})
+		styleCount++
+	}
+
+	for i, paragraph := range text.Paragraphs {
+		if i == 0 {
+			addStyle(0, 0, paragraph.Style)
+		} else {
+			addStyle(1, next, paragraph.Style)
+			addItem("\n", 0)
+		}
+		for _, span := range paragraph.Spans {
+			if span.Bold != bold {
+				addItem("", map[bool]uint32{true: 1, false: 2}[span.Bold])
+				bold = span.Bold
+			}
+			if span.Italic != italic {
+				addItem("", map[bool]uint32{true: 3, false: 4}[span.Italic])
+				italic = span.Italic
+			}
+			addItem(span.Text, 0)
+		}
+	}
+
+	var itemsBlock, stylesBlock, contents, data, position bytes.Buffer
+	putV6Varint(&itemsBlock, uint64(count))
+	itemsBlock.Write(items.Bytes())
+	var inner bytes.Buffer
+	putV6Subblock(&inner, 1, itemsBlock.Bytes())
+	putV6Subblock(&contents, 1, inner.Bytes())
+	putV6Varint(&stylesBlock, uint64(styleCount))
+	stylesBlock.Write(styles.Bytes())
+	inner.Reset()
+	putV6Subblock(&inner, 1, stylesBlock.Bytes())
+	putV6Subblock(&contents, 2, inner.Bytes())
+
+	putV6ID(&data, 1, 0, 0)
+	putV6Subblock(&data, 2, contents.Bytes())
+	binary.Write(&position, binary.LittleEndian, text.X)
+	binary.Write(&position, binary.LittleEndian, text.Y)
+	putV6Subblock(&data, 3, position.Bytes())
+	putV6Tag(&data, 4, rm.TAG_BYTE4)
+	binary.Write(&data, binary.LittleEndian, text.Width)
+	return data.Bytes()
+}
+
+func putV6Varint(b *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+func putV6Tag(b *bytes.Buffer, index int, tagType byte) {
+	putV6Varint(b, uint64(index<<4)|uint64(tagType))
+}
+
+func putV6ID(b *bytes.Buffer, index int, part1 uint8, part2 uint64) {
+	putV6Tag(b, index, rm.TAG_ID)
+	b.WriteByte(part1)
+	putV6Varint(b, part2)
+}
+
+func putV6Subblock(b *bytes.Buffer, index int, data []byte) {
+	putV6Tag(b, index, rm.TAG_LENGTH4)
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+}
+
+func putV6Block(b *bytes.Buffer, blockType byte, data []byte) {
+	binary.Write(b, binary.LittleEndian, uint32(len(data)))
+	b.Write([]byte{0, 1, 2, blockType})
+	b.Write(data)
+}
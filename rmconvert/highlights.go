@@ -0,0 +1,204 @@
+package rmconvert
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juruen/rmapi/util"
+)
+
+func init() {
+	RegisterExporter(&highlightsExporter{name: "highlights-md", extension: "highlights.md", write: WriteHighlightsMarkdown})
+	RegisterExporter(&highlightsExporter{name: "highlights-json", extension: "highlights.json", write: WriteHighlightsJSON})
+	RegisterExporter(&highlightsExporter{name: "highlights-csv", extension: "highlights.csv", write: WriteHighlightsCSV})
+}
+
+// Highlight is a highlighted passage of a PDF or EPUB document
+type Highlight struct {
+	Page  int    `json:"page"` // 1-based page number
+	Text  string `json:"text"`
+	Color string `json:"color"`
+}
+
+// DocumentHighlights are the highlights of a document in page order
+type DocumentHighlights struct {
+	Title      string      `json:"title"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// highlightColors are the names of the v6 color ids
+var highlightColors = map[int32]string{
+	0: "black", 1: "gray", 2: "white", 3: "yellow", 4: "green", 5: "pink",
+	6: "blue", 7: "red", 8: "gray", 9: "yellow", 10: "green", 11: "cyan",
+	12: "magenta", 13: "yellow",
+}
+
+// HighlightColorName returns the name of a v6 color id, "yellow" (the
+// default highlighter color) if it is unknown
+func HighlightColorName(color int32) string {
+	if name, ok := highlightColors[color]; ok {
+		return name
+	}
+	return "yellow"
+}
+
+// legacyHighlights is the .highlights/<page>.json file written by firmware
+// before the v6 format
+type legacyHighlights struct {
+	Highlights [][]struct {
+		Color  int32  `json:"color"`
+		Start  int    `json:"start"`
+		Length int    `json:"length"`
+		Text   string `json:"text"`
+	} `json:"highlights"`
+}
+
+// ExtractHighlights returns the highlights of the pages of a .rmdoc file,
+// from the v6 pages and from the .highlights files of older firmware
+func ExtractHighlights(rmdocPath string) ([]Highlight, error) {
+	tempDir, err := os.MkdirTemp("", "rmdoc_highlights_*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := extractZip(rmdocPath, tempDir); err != nil {
+		return nil, fmt.Errorf("failed to extract .rmdoc: %v", err)
+	}
+
+	pageOrder, docDir, err := getPageOrderAndDocDir(tempDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page order: %v", err)
+	}
+
+	var result []Highlight
+	for i, pageID := range pageOrder {
+		var found []Highlight
+
+		rmFile := filepath.Join(docDir, pageID+".rm")
+		if _, err := os.Stat(rmFile); err == nil {
+			page, err := ParseRMFile(rmFile)
+			if err != nil {
+				return nil, err
+			}
+			for _, h := range page.Highlights {
+				found = append(found, Highlight{Page: i + 1, Text: h.Text, Color: h.Color})
+			}
+		}
+
+		if len(found) == 0 {
+			data, err := os.ReadFile(filepath.Join(docDir+".highlights", pageID+".json"))
+			if err == nil {
+				var legacy legacyHighlights
+				if err := json.Unmarshal(data, &legacy); err != nil {
+					return nil, fmt.Errorf("page %d: bad highlights: %v", i+1, err)
+				}
+				for _, group := range legacy.Highlights {
+					for _, h := range group {
+						found = append(found, Highlight{Page: i + 1, Text: h.Text, Color: HighlightColorName(h.Color)})
+					}
+				}
+			}
+		}
+
+		for _, h := range found {
+			if h.Text = strings.TrimSpace(h.Text); h.Text != "" {
+				result = append(result, h)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// WriteHighlightsMarkdown writes the highlights as quotes, one section per
+// document and page
+func WriteHighlightsMarkdown(w io.Writer, docs []DocumentHighlights) error {
+	var b strings.Builder
+	for i, doc := range docs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "# %s\n", doc.Title)
+		page := 0
+		for _, h := range doc.Highlights {
+			if h.Page != page {
+				page = h.Page
+				fmt.Fprintf(&b, "\n## Page %d\n", page)
+			}
+			fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(h.Text, "\n", "\n> "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHighlightsJSON writes the documents and their highlights as a JSON
+// array
+func WriteHighlightsJSON(w io.Writer, docs []DocumentHighlights) error {
+	if docs == nil {
+		docs = []DocumentHighlights{}
+	}
+	for i := range docs {
+		if docs[i].Highlights == nil {
+			docs[i].Highlights = []Highlight{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(docs)
+}
+
+// WriteHighlightsCSV writes one row per highlight with the columns of the
+// Readwise CSV import: Highlight, Title, Location, Location Type, Note, Color
+func WriteHighlightsCSV(w io.Writer, docs []DocumentHighlights) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"Highlight", "Title", "Location", "Location Type", "Note", "Color"}); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		for _, h := range doc.Highlights {
+			if err := cw.Write([]string{h.Text, doc.Title, strconv.Itoa(h.Page), "page", "", h.Color}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// highlightsExporter writes a report of the highlights of a document
+type highlightsExporter struct {
+	name      string
+	extension string
+	write     func(w io.Writer, docs []DocumentHighlights) error
+}
+
+func (e *highlightsExporter) Name() string         { return e.name }
+func (e *highlightsExporter) Extensions() []string { return []string{e.extension} }
+
+func (e *highlightsExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	highlights, err := ExtractHighlights(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := DocumentHighlights{
+		Title:      strings.TrimSuffix(filepath.Base(outPath), "."+e.extension),
+		Highlights: highlights,
+	}
+	err = util.WriteFileAtomic(outPath, func(w io.Writer) error {
+		return e.write(w, []DocumentHighlights{doc})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ExportResult{Files: []string{outPath}}, nil
+}
@@ -1,21 +1,102 @@
 package rmconvert
 
 import (
+	"context"
 	"fmt"
 	"image"
-	"image/png"
+	"image/color"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 
+	"github.com/juruen/rmapi/log"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/canvas/renderers"
 )
 
 // ConvertPageToPNG renders a reMarkable page to a PNG image
 func (page *Page) ConvertToPNG(writer io.Writer, dpi int) error {
+	return writePNG(writer, page.render(float64(dpi)), pngEncoder)
+}
+
+// render draws the page on a canvas whose units are 1/dpi inch, i.e.
+// pixels when written as a raster image
+func (page *Page) render(dpi float64) *canvas.Canvas {
+	return page.draw(dpi, drawOptions{background: true})
+}
+
+// renderStrokes draws the strokes of the page on a transparent canvas, to
+// be laid over a background
+func (page *Page) renderStrokes(dpi float64) *canvas.Canvas {
+	return page.draw(dpi, drawOptions{})
+}
+
+// renderWith draws the page at the resolution of the options, or at the
+// size they give, over its template when the options give the templates
+// folder, cropped to its content with Crop, with the page marks of forPage
+func (page *Page) renderWith(opts ExportOptions) *canvas.Canvas {
+	template := loadTemplate(opts.TemplatesDir, page.Template)
+	if template != nil && opts.Invert {
+		template = invertTemplate(template)
+	}
+
+	region, cropped, dpi := page.renderRegion(opts)
+	c := page.draw(dpi, drawOptions{background: true, fill: opts.Background, template: template, debug: opts.DebugRender, invert: opts.Invert})
+	if cropped {
+		crop(c, dpi/deviceDPI, region)
+	}
+	// over the cropped page
+	if opts.marks != (pageMarks{}) {
+		drawPageMarks(c, opts.marks, dpi/deviceDPI, opts.Invert)
+	}
+	return c
+}
+
+// renderRegion returns the region of the page drawn by renderWith in device
+// pixels, whether it is cropped, and the resolution it is drawn at
+func (page *Page) renderRegion(opts ExportOptions) (canvas.Rect, bool, float64) {
+	region, cropped := canvas.Rect{X1: 1404, Y1: 1872}, false
+	if opts.Crop {
+		if r, ok := page.cropBounds(region.X1, region.Y1, opts.CropMargin); ok {
+			region, cropped = r, true
+		}
+	}
+	dpi := float64(opts.DPI)
+	if opts.Width > 0 || opts.Height > 0 {
+		dpi = fitDPI(region, opts.Width, opts.Height)
+	}
+	return region, cropped, dpi
+}
+
+// fitDPI returns the resolution at which a region of device pixels fits in
+// width x height pixels keeping its aspect ratio, a zero width or height
+// being free
+func fitDPI(region canvas.Rect, width, height int) float64 {
+	scale := math.Inf(1)
+	if width > 0 {
+		scale = float64(width) / region.W()
+	}
+	if height > 0 {
+		scale = min(scale, float64(height)/region.H())
+	}
+	return scale * deviceDPI
+}
+
+// drawOptions are how a page is drawn
+type drawOptions struct {
+	// background fills the page with fill, white or black when inverted
+	// if nil, and draws the template
+	background bool
+	fill       color.Color
+	template   image.Image
+	debug      DebugRender
+	// invert draws the page white on black
+	invert bool
+}
+
+func (page *Page) draw(dpi float64, opts drawOptions) *canvas.Canvas {
 	// reMarkable dimensions: 1404 x 1872 device pixels
 	// Convert to desired DPI
 	const rmWidth = 1404.0
@@ -24,44 +105,67 @@ func (page *Page) ConvertToPNG(writer io.Writer, dpi int) error {
 	// Calculate dimensions at target DPI
 	// reMarkable is approximately 226 DPI
 	const rmDPI = 226.0
-	scale := float64(dpi) / rmDPI
+	scale := dpi / rmDPI
 
 	width := rmWidth * scale
 	height := rmHeight * scale
 
-	// Create canvas with calculated dimensions
+	// Create canvas with calculated dimensions, y pointing down like the
+	// device coordinates
 	c := canvas.New(width, height)
 	ctx := canvas.NewContext(c)
+	ctx.SetCoordSystem(canvas.CartesianIV)
+
+	// Fill the background, left transparent for compositing
+	if fill := backgroundColor(opts.fill, opts.invert); opts.background && !isTransparent(fill) {
+		ctx.SetFillColor(fill)
+		ctx.MoveTo(0, 0)
+		ctx.LineTo(width, 0)
+		ctx.LineTo(width, height)
+		ctx.LineTo(0, height)
+		ctx.Close()
+		ctx.Fill()
+	}
+
+	// Templates are the size of the screen, scaled to the page width
+	if opts.background && opts.template != nil {
+		ctx.DrawImage(0, 0, opts.template, canvas.DPMM(float64(opts.template.Bounds().Dx())/width))
+	}
+
+	// The typed text is under the strokes
+	textColor := canvas.Black
+	if opts.invert {
+		textColor = canvas.White
+	}
+	drawTypedText(ctx, page, scale, textColor)
+
+	if opts.debug != DebugRenderOff {
+		drawDebugStrokes(ctx, page, scale, opts.debug)
+		return c
+	}
 
-	// Set white background
-	ctx.SetFillColor(canvas.White)
-	ctx.MoveTo(0, 0)
-	ctx.LineTo(width, 0)
-	ctx.LineTo(width, height)
-	ctx.LineTo(0, height)
-	ctx.Close()
-	ctx.Fill()
-
-	// Render each stroke
-	for _, stroke := range page.Strokes {
-		if len(stroke.Points) < 2 {
+	// Render each stroke, the path is reused since the canvas copies it
+	ctx.SetFill(canvas.Paint{})
+	path := &canvas.Path{}
+	for i := range page.Strokes {
+		if len(page.Strokes[i].Points) < 2 {
 			continue
 		}
 
-		err := renderStrokeToPNG(ctx, &stroke, scale)
+		err := renderStrokeToPNG(ctx, path, &page.Strokes[i], scale, opts.invert)
 		if err != nil {
-			fmt.Printf("Warning: failed to render stroke: %v\n", err)
+			log.Warning.Printf("failed to render stroke: %v", err)
 			continue
 		}
 	}
 
-	// Render to PNG
-	pngWriter := renderers.PNG()
-	return c.Write(writer, pngWriter)
+	return c
 }
 
-// renderStrokeToPNG renders a single stroke to the PNG context
-func renderStrokeToPNG(ctx *canvas.Context, stroke *Stroke, scale float64) error {
+// renderStrokeToPNG renders a single stroke to the PNG context, path is
+// overwritten with the outline of the stroke. invert draws it in the
+// inverted color of its tool.
+func renderStrokeToPNG(ctx *canvas.Context, path *canvas.Path, stroke *Stroke, scale float64, invert bool) error {
 	if len(stroke.Points) < 2 {
 		return fmt.Errorf("stroke must have at least 2 points")
 	}
@@ -70,54 +174,53 @@ func renderStrokeToPNG(ctx *canvas.Context, stroke *Stroke, scale float64) error
 
 	// Set stroke properties
 	color := parseColor(props.Color)
+	if invert {
+		color = invertColor(color)
+	}
+	if props.Opacity < 1 {
+		// premultiplied alpha
+		a := max(props.Opacity, 0)
+		color.R = uint8(float32(color.R) * a)
+		color.G = uint8(float32(color.G) * a)
+		color.B = uint8(float32(color.B) * a)
+		color.A = uint8(255 * a)
+	}
 	ctx.SetStrokeColor(color)
 	ctx.SetStrokeWidth(float64(props.StrokeWidth) * scale)
 	ctx.SetStrokeCapper(canvas.RoundCap)
 	ctx.SetStrokeJoiner(canvas.RoundJoin)
 
 	// Start path by moving to first point
+	path.Reset()
 	firstPoint := stroke.Points[0]
-	ctx.MoveTo(float64(firstPoint.X)*scale, float64(firstPoint.Y)*scale)
+	path.MoveTo(float64(firstPoint.X)*scale, float64(firstPoint.Y)*scale)
 
 	// Add subsequent points
 	for i := 1; i < len(stroke.Points); i++ {
 		point := stroke.Points[i]
-		ctx.LineTo(float64(point.X)*scale, float64(point.Y)*scale)
+		path.LineTo(float64(point.X)*scale, float64(point.Y)*scale)
 	}
 
 	// Stroke the path
-	ctx.Stroke()
+	ctx.DrawPath(0, 0, path)
 
 	return nil
 }
 
 // ConvertRmdocToImagePDF converts a .rmdoc file to PDF using image-based rendering
 // This approach renders each page to PNG and then creates a PDF from the images
-func ConvertRmdocToImagePDF(rmdocPath, pdfPath string, dpi int) error {
-	if dpi <= 0 {
-		dpi = 300 // Default DPI
-	}
-
-	// Create temporary directory for PNGs
-	tempDir, err := os.MkdirTemp("", "rmdoc_images_*")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Extract .rmdoc file
-	extractDir := filepath.Join(tempDir, "extracted")
-	err = extractZip(rmdocPath, extractDir)
-	if err != nil {
-		return fmt.Errorf("failed to extract .rmdoc: %v", err)
-	}
+func ConvertRmdocToImagePDF(goCtx context.Context, rmdocPath, pdfPath string, dpi int) error {
+	return convertRmdocToImagePDF(goCtx, rmdocPath, pdfPath, ExportOptions{DPI: dpi}.withDefaults())
+}
 
-	// Find the document directory and get page order
-	pageOrder, docDir, err := getPageOrderAndDocDir(extractDir)
+func convertRmdocToImagePDF(goCtx context.Context, rmdocPath, pdfPath string, opts ExportOptions) error {
+	doc, err := OpenRmDoc(rmdocPath)
 	if err != nil {
-		return fmt.Errorf("failed to get page order: %v", err)
+		return fmt.Errorf("failed to open .rmdoc: %v", err)
 	}
+	defer doc.Close()
 
+	pageOrder := doc.PageIDs()
 	if len(pageOrder) == 0 {
 		return fmt.Errorf("no pages found in document")
 	}
@@ -128,36 +231,79 @@ func ConvertRmdocToImagePDF(rmdocPath, pdfPath string, dpi int) error {
 		return fmt.Errorf("failed to create PDF directory: %v", err)
 	}
 
-	// Convert each .rm file to PNG
-	var pngFiles []string
-	successCount := 0
+	// The pages are appended to the PDF as they are rendered
+	pdf, err := createImagePDF(pdfPath)
+	if err != nil {
+		return fmt.Errorf("failed to create PDF: %v", err)
+	}
 
-	for i, pageID := range pageOrder {
-		rmFile := filepath.Join(docDir, pageID+".rm")
-		if _, err := os.Stat(rmFile); err != nil {
+	var pageIDs []string
+	for _, pageID := range pageOrder {
+		if !doc.HasPage(pageID) {
 			// Page might not exist, skip it
-			fmt.Printf("Warning: page %s not found, skipping\n", pageID)
+			log.Warning.Printf("page %s not found, skipping", pageID)
 			continue
 		}
+		pageIDs = append(pageIDs, pageID)
+	}
+	pageIDs = doc.sheetIDs(pageIDs)
 
-		pngPath := filepath.Join(tempDir, fmt.Sprintf("page_%04d.png", i+1))
-		err := convertRMToPNG(rmFile, pngPath, dpi)
-		if err != nil {
-			// Print warning but continue with other pages
-			fmt.Printf("Warning: failed to convert page %s to PNG: %v\n", pageID, err)
-			continue
+	// Pages are rendered in parallel within the memory budget
+	plan := planRendering(opts.DPI, len(pageIDs))
+	err = renderPages(goCtx, doc, pageIDs, opts, plan, func(pageID string, img image.Image) error {
+		if err := pdf.AddImage(img); err != nil {
+			return fmt.Errorf("failed to add page %s to PDF: %v", pageID, err)
 		}
-
-		pngFiles = append(pngFiles, pngPath)
-		successCount++
+		return nil
+	})
+	if err != nil {
+		pdf.Abort()
+		return err
 	}
 
-	if successCount == 0 {
+	if len(pdf.pages) == 0 {
+		pdf.Abort()
 		return fmt.Errorf("no pages were successfully converted")
 	}
 
-	// Create PDF from PNGs using pdfcpu
-	return createPDFFromImages(pngFiles, pdfPath)
+	return pdf.Close()
+}
+
+// renderPage rasterizes a page of a document over its template, to be given
+// back with releaseImage. A page that can't be parsed is rendered blank.
+func renderPage(doc *RmDoc, pageID string, opts ExportOptions) *image.RGBA {
+	return rasterize(pageOrBlank(doc, pageID).renderWith(opts))
+}
+
+// pageOrBlank parses a page of a document, a blank page if it can't be
+// parsed
+func pageOrBlank(doc *RmDoc, pageID string) *Page {
+	page, err := doc.Page(pageID)
+	if err != nil {
+		log.Warning.Printf("failed to parse page %s, creating empty page: %v", pageID, err)
+		id, _ := splitSheetID(pageID)
+		page = &Page{
+			Width:    1404,
+			Height:   1872,
+			Strokes:  []Stroke{},
+			Template: doc.templates[id],
+		}
+	}
+	return page
+}
+
+// writePNGFile writes an image to a PNG file, for the tools reading the
+// pages from files
+func writePNGFile(img image.Image, pngFile string) error {
+	file, err := os.Create(pngFile)
+	if err != nil {
+		return fmt.Errorf("failed to create PNG file: %v", err)
+	}
+	if err := pagePNGEncoder.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 // convertRMToPNG converts a single .rm file to PNG
@@ -166,7 +312,7 @@ func convertRMToPNG(rmFile, pngFile string, dpi int) error {
 	page, err := ParseRMFile(rmFile)
 	if err != nil {
 		// If parsing fails, create empty page
-		fmt.Printf("Warning: failed to parse %s, creating empty page: %v\n", rmFile, err)
+		log.Warning.Printf("failed to parse %s, creating empty page: %v", rmFile, err)
 		page = &Page{
 			Width:   1404,
 			Height:  1872,
@@ -215,80 +361,10 @@ func ConvertRMFileToImage(rmFilePath, imagePath string, dpi int) error {
 	return convertRMToPNG(rmFilePath, imagePath, dpi)
 }
 
-// RenderPageToImage renders a Page struct directly to an image.Image
+// RenderToImage renders a page to a grayscale image at the given DPI. The
+// page is rasterized in memory, without encoding it as PNG.
 func (page *Page) RenderToImage(dpi int) (image.Image, error) {
-	const rmWidth = 1404.0
-	const rmHeight = 1872.0
-	const rmDPI = 226.0
-	scale := float64(dpi) / rmDPI
-
-	width := int(rmWidth * scale)
-	height := int(rmHeight * scale)
-
-	// Create canvas
-	c := canvas.New(float64(width), float64(height))
-	ctx := canvas.NewContext(c)
-
-	// Set white background
-	ctx.SetFillColor(canvas.White)
-	ctx.MoveTo(0, 0)
-	ctx.LineTo(float64(width), 0)
-	ctx.LineTo(float64(width), float64(height))
-	ctx.LineTo(0, float64(height))
-	ctx.Close()
-	ctx.Fill()
-
-	// Render each stroke
-	for _, stroke := range page.Strokes {
-		if len(stroke.Points) < 2 {
-			continue
-		}
-
-		err := renderStrokeToPNG(ctx, &stroke, scale)
-		if err != nil {
-			fmt.Printf("Warning: failed to render stroke: %v\n", err)
-			continue
-		}
-	}
-
-	// Render via PNG encoding/decoding
-	var buf []byte
-	writer := &bufferWriter{buf: &buf}
-	pngWriter := renderers.PNG()
-	err := c.Write(writer, pngWriter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to render to PNG: %v", err)
-	}
-
-	// Decode back to image.Image
-	img, err := png.Decode(&bufferReader{buf: buf})
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode PNG: %v", err)
-	}
-
-	return img, nil
-}
-
-// Helper types for in-memory buffer operations
-type bufferWriter struct {
-	buf *[]byte
-}
-
-func (w *bufferWriter) Write(p []byte) (n int, err error) {
-	*w.buf = append(*w.buf, p...)
-	return len(p), nil
-}
-
-type bufferReader struct {
-	buf []byte
-	pos int
-}
-
-func (r *bufferReader) Read(p []byte) (n int, err error) {
-	if r.pos >= len(r.buf) {
-		return 0, io.EOF
-	}
-	n = copy(p, r.buf[r.pos:])
-	r.pos += n
-	return n, nil
+	img := rasterize(page.render(float64(dpi)))
+	defer releaseImage(img)
+	return grayImage(img), nil
 }
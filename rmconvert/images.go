@@ -0,0 +1,72 @@
+package rmconvert
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// rmPageDim is the reMarkable screen size in PDF points (1404x1872 px at 226 DPI)
+var rmPageDim = &types.Dim{Width: 447, Height: 596}
+
+// ConvertImagesToPDF wraps PNG/JPEG images into a PDF with one page per image,
+// each scaled to fit a reMarkable sized page, so that scans and photos can be
+// uploaded as regular documents.
+func ConvertImagesToPDF(imagePaths []string, pdfPath string) error {
+	if len(imagePaths) == 0 {
+		return fmt.Errorf("no images to convert")
+	}
+
+	for _, p := range imagePaths {
+		if err := checkImage(p); err != nil {
+			return err
+		}
+	}
+
+	// ImportImagesFile appends to an existing output file
+	if _, err := os.Stat(pdfPath); err == nil {
+		return fmt.Errorf("output file already exists: %s", pdfPath)
+	}
+
+	imp := pdfcpu.DefaultImportConfig()
+	imp.PageDim = rmPageDim
+	imp.PageSize = ""
+	imp.UserDim = true
+	imp.Pos = types.Full
+
+	conf := model.NewDefaultConfiguration()
+	conf.CreateBookmarks = false
+
+	if err := api.ImportImagesFile(imagePaths, pdfPath, imp, conf); err != nil {
+		return fmt.Errorf("failed to create PDF from images: %v", err)
+	}
+
+	return nil
+}
+
+// checkImage makes sure the file is a PNG or JPEG image pdfcpu can embed
+func checkImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %v", path, err)
+	}
+
+	if format != "png" && format != "jpeg" {
+		return fmt.Errorf("unsupported image format %s: %s", format, path)
+	}
+
+	return nil
+}
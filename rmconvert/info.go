@@ -0,0 +1,79 @@
+package rmconvert
+
+// DocumentInfo describes a document without rendering it, e.g. to list its
+// pages before choosing what to convert
+type DocumentInfo struct {
+	ID string `json:"id"`
+	// FileType is "notebook", "pdf" or "epub"
+	FileType string `json:"fileType"`
+	// Orientation is "portrait" or "landscape"
+	Orientation string `json:"orientation"`
+	// HasBackgroundPDF tells whether the document annotates a PDF, see
+	// RmDoc.BackgroundPDF
+	HasBackgroundPDF bool       `json:"hasBackgroundPdf"`
+	Pages            []PageInfo `json:"pages"`
+}
+
+// PageInfo describes a page of a document
+type PageInfo struct {
+	ID string `json:"id"`
+	// Template is the name of the page template, see RmDoc.Templates
+	Template string `json:"template,omitempty"`
+	// HasStrokes tells whether the page has a .rm file, pages that were
+	// never written on don't
+	HasStrokes bool `json:"hasStrokes"`
+	// BackgroundPage is the 0-based page of the background PDF shown under
+	// the page, -1 for notebooks and inserted pages
+	BackgroundPage int `json:"backgroundPage"`
+}
+
+// Info describes the document and its pages, in document order
+func (d *RmDoc) Info() DocumentInfo {
+	info := DocumentInfo{
+		ID:          d.ID,
+		FileType:    d.Content.FileType,
+		Orientation: d.Content.Orientation,
+	}
+	if info.Orientation == "" {
+		info.Orientation = "portrait"
+	}
+	_, info.HasBackgroundPDF = d.files[d.ID+".pdf"]
+	info.HasBackgroundPDF = info.HasBackgroundPDF && d.Content.FileType == "pdf"
+
+	ids := d.PageIDs()
+	templates := d.Templates()
+	backgroundPages := d.BackgroundPages()
+	info.Pages = make([]PageInfo, len(ids))
+	for i, id := range ids {
+		info.Pages[i] = PageInfo{
+			ID:             id,
+			Template:       templates[i],
+			HasStrokes:     d.HasPage(id),
+			BackgroundPage: backgroundPages[i],
+		}
+	}
+	return info
+}
+
+// ReadDocumentInfo describes a .rmdoc file, see RmDoc.Info
+func ReadDocumentInfo(rmdocPath string) (*DocumentInfo, error) {
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	info := doc.Info()
+	return &info, nil
+}
+
+// GetPageOrder returns the page IDs of a .rmdoc file in document order
+func GetPageOrder(rmdocPath string) ([]string, error) {
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	defer doc.Close()
+
+	return doc.PageIDs(), nil
+}
@@ -0,0 +1,40 @@
+package rmconvert
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// invertColor returns the color of the same hue and saturation whose
+// lightness is inverted: black and white are swapped, greys mirrored around
+// the middle and the highlighter colors darkened so that they show on a
+// black page
+func invertColor(c color.RGBA) color.RGBA {
+	hi := max(c.R, c.G, c.B)
+	lo := min(c.R, c.G, c.B)
+	// lightness is (hi+lo)/2, adding the same amount to each channel keeps
+	// the hue and the saturation
+	d := 255 - int(hi) - int(lo)
+	return color.RGBA{uint8(int(c.R) + d), uint8(int(c.G) + d), uint8(int(c.B) + d), c.A}
+}
+
+// invertedTemplates caches the inverted template images by template
+var invertedTemplates sync.Map
+
+// invertTemplate returns a template image with inverted colors, for the
+// pages drawn white on black
+func invertTemplate(template image.Image) image.Image {
+	if img, ok := invertedTemplates.Load(template); ok {
+		return img.(image.Image)
+	}
+	img := image.NewNRGBA(template.Bounds())
+	draw.Draw(img, img.Bounds(), template, template.Bounds().Min, draw.Src)
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := invertColor(color.RGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255})
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = c.R, c.G, c.B
+	}
+	invertedTemplates.Store(template, img)
+	return img
+}
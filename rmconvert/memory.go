@@ -0,0 +1,124 @@
+package rmconvert
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+)
+
+// MemoryBudget bounds the memory used to render the pages of a document
+// into a PDF, in bytes, 0 for no limit. Set by the global -max-memory
+// flag.
+var MemoryBudget int64
+
+// renderPlan is how the pages of a document are rendered within
+// MemoryBudget
+type renderPlan struct {
+	// workers is the number of pages rendered in parallel
+	workers int
+	// gray keeps the rendered pages as 8 bit grayscale instead of 32 bit
+	// RGBA until they are written, losing the colors of the strokes
+	gray bool
+}
+
+// pageMemory estimates the memory of rendering a page at dpi until it is
+// written: the RGBA raster, the outlines of the strokes, about as large on
+// a full page of notes, and the page waiting to be written
+func pageMemory(dpi int, gray bool) int64 {
+	const rmDPI = 226.0
+	scale := float64(dpi) / rmDPI
+	pixels := int64(1404*scale+0.5) * int64(1872*scale+0.5)
+	if gray {
+		return pixels * (4 + 4 + 1)
+	}
+	return pixels * (4 + 4 + 4)
+}
+
+// planRendering returns how to render pages at dpi: as many in parallel as
+// there are CPUs and the budget allows, and in grayscale when even a single
+// page would exceed the budget
+func planRendering(dpi, pages int) renderPlan {
+	workers := max(1, min(runtime.NumCPU(), pages))
+	if MemoryBudget <= 0 {
+		return renderPlan{workers: workers}
+	}
+
+	if n := MemoryBudget / pageMemory(dpi, false); n >= 1 {
+		return renderPlan{workers: min(workers, int(n))}
+	}
+
+	if MemoryBudget < pageMemory(dpi, true) {
+		log.Warning.Printf("a page at %d DPI needs about %s, more than the memory budget of %s, lower the DPI",
+			dpi, util.FormatSize(pageMemory(dpi, true)), util.FormatSize(MemoryBudget))
+	}
+	log.Info.Printf("memory budget of %s: rendering one page at a time in grayscale", util.FormatSize(MemoryBudget))
+	return renderPlan{workers: 1, gray: true}
+}
+
+// renderPages renders the pages of a document with the workers of plan and
+// calls add with each rendered page, in order. At most plan.workers pages
+// are rendered or waiting to be added at any time.
+func renderPages(goCtx context.Context, doc *RmDoc, pageIDs []string, opts ExportOptions, plan renderPlan, add func(pageID string, img image.Image) error) error {
+	done := make([]chan image.Image, len(pageIDs))
+	for i := range done {
+		done[i] = make(chan image.Image, 1)
+	}
+
+	// a slot is taken before rendering a page and given back once it was
+	// added
+	slots := make(chan struct{}, plan.workers)
+	stop := make(chan struct{})
+	// the pages being rendered are waited for, as they read the document
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, pageID := range pageIDs {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				img := renderPage(doc, pageID, opts.forPage(i+1, len(pageIDs)))
+				if plan.gray {
+					gray := grayImage(img)
+					releaseImage(img)
+					done[i] <- gray
+					return
+				}
+				done[i] <- img
+			}()
+		}
+	}()
+
+	for i, pageID := range pageIDs {
+		var img image.Image
+		select {
+		case img = <-done[i]:
+		case <-goCtx.Done():
+			return goCtx.Err()
+		}
+
+		err := add(pageID, img)
+		if rgba, ok := img.(*image.RGBA); ok {
+			releaseImage(rgba)
+		}
+		<-slots
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
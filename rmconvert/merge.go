@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/juruen/rmapi/util"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 )
@@ -14,6 +15,13 @@ func MergePDFs(inputFiles []string, outputFile string) error {
 		return fmt.Errorf("no input files provided")
 	}
 
+	// Written next to the output and renamed once complete
+	return util.WritePathAtomic(outputFile, func(partialPath string) error {
+		return mergePDFs(inputFiles, partialPath)
+	})
+}
+
+func mergePDFs(inputFiles []string, outputFile string) error {
 	if len(inputFiles) == 1 {
 		// If only one file, just copy it
 		return copyFile(inputFiles[0], outputFile)
@@ -27,7 +35,6 @@ func MergePDFs(inputFiles []string, outputFile string) error {
 	if err != nil {
 		return fmt.Errorf("failed to merge PDFs: %v", err)
 	}
-
 	return nil
 }
 
@@ -0,0 +1,110 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/util"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// OCRFile adds a searchable text layer to a PDF whose pages are images,
+// e.g. scans or the PDFs exported without OCR, and writes it to outPath,
+// which may be inPath. The largest image of each page is recognised,
+// assuming that it fills the page; pages without JPEG or PNG images are
+// left without text.
+func (p *Pipeline) OCRFile(goCtx context.Context, inPath, outPath string) ([]PageOCR, error) {
+	if p.Engine == nil && !tesseractAvailable(p.Options) {
+		return nil, fmt.Errorf("tesseract not found: %s", p.Options.TesseractPath)
+	}
+
+	var results []PageOCR
+	err := pdfPageImages(goCtx, inPath, func(page RenderedPage) error {
+		ocr, ok, err := p.recognize(goCtx, page)
+		if ok {
+			results = append(results, ocr)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = util.WriteFileAtomic(outPath, func(w io.Writer) error {
+		f, err := os.Open(inPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > 0 {
+		if err := p.TextLayer(outPath, results); err != nil {
+			return nil, fmt.Errorf("failed to add the text layer: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// pdfPageImages calls each with the largest image of every page of a PDF
+func pdfPageImages(goCtx context.Context, pdfPath string, each func(RenderedPage) error) error {
+	f, err := os.Open(pdfPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	conf.Cmd = model.EXTRACTIMAGES
+	ctx, err := api.ReadValidateAndOptimize(f, conf)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", pdfPath, err)
+	}
+
+	for number := 1; number <= ctx.PageCount; number++ {
+		if err := goCtx.Err(); err != nil {
+			return err
+		}
+		images, err := pdfcpu.ExtractPageImages(ctx, number, false)
+		if err != nil {
+			return fmt.Errorf("failed to extract the images of page %d: %v", number, err)
+		}
+
+		var largest *model.Image
+		for _, img := range images {
+			if img.Thumb || img.IsImgMask {
+				continue
+			}
+			if largest == nil || img.Width*img.Height > largest.Width*largest.Height {
+				largest = &img
+			}
+		}
+		if largest == nil {
+			log.Warning.Printf("page %d has no image, skipping", number)
+			continue
+		}
+
+		img, _, err := image.Decode(largest)
+		if err != nil {
+			log.Warning.Printf("page %d: unsupported %s image: %v", number, largest.FileType, err)
+			continue
+		}
+		if err := each(RenderedPage{Number: number, ID: largest.Name, Image: img}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package rmconvert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var reTessdataDir = regexp.MustCompile(`available languages in "([^"]+)"`)
+
+// ErrTesseractNotFound is returned by CheckTesseractLanguages when the
+// tesseract executable can't be found
+var ErrTesseractNotFound = errors.New("tesseract not found")
+
+// MissingLanguagesError is returned by CheckTesseractLanguages for the
+// languages whose traineddata files tesseract can't find
+type MissingLanguagesError struct {
+	Missing []string
+	// Dir is the tessdata folder of tesseract, empty if unknown
+	Dir string
+}
+
+func (e *MissingLanguagesError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tesseract language packs not installed: %s\n", strings.Join(e.Missing, ", "))
+
+	apt := make([]string, len(e.Missing))
+	dnf := make([]string, len(e.Missing))
+	for i, lang := range e.Missing {
+		apt[i] = "tesseract-ocr-" + strings.ReplaceAll(strings.ToLower(lang), "_", "-")
+		dnf[i] = "tesseract-langpack-" + lang
+	}
+	fmt.Fprintf(&b, "install them with:\n")
+	fmt.Fprintf(&b, "  Debian, Ubuntu: apt install %s\n", strings.Join(apt, " "))
+	fmt.Fprintf(&b, "  Fedora: dnf install %s\n", strings.Join(dnf, " "))
+	fmt.Fprintf(&b, "  macOS: brew install tesseract-lang\n")
+	if e.Dir != "" {
+		fmt.Fprintf(&b, "  or copy <lang>.traineddata from https://github.com/tesseract-ocr/tessdata to %s\n", e.Dir)
+	}
+	b.WriteString("see tesseract --list-langs")
+	return b.String()
+}
+
+// TesseractLanguages returns the languages installed for the tesseract
+// executable at path and the folder of their traineddata files, empty if
+// tesseract doesn't tell
+func TesseractLanguages(goCtx context.Context, path string) ([]string, string, error) {
+	cmd := exec.CommandContext(goCtx, path, "--list-langs")
+	cmd.WaitDelay = tesseractWaitDelay
+	// older versions list the languages on stderr
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list the tesseract languages: %w: %s", err, output)
+	}
+	langs, dir := parseTesseractLanguages(string(output))
+	return langs, dir, nil
+}
+
+// parseTesseractLanguages reads the output of tesseract --list-langs
+func parseTesseractLanguages(output string) ([]string, string) {
+	var langs []string
+	dir := ""
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "List of available languages") {
+			if m := reTessdataDir.FindStringSubmatch(line); m != nil {
+				dir = m[1]
+			}
+			continue
+		}
+		if strings.ContainsAny(line, " :") {
+			// warnings, e.g. about the locale
+			continue
+		}
+		langs = append(langs, line)
+	}
+	return langs, dir
+}
+
+// requiredLanguages returns the languages the OCR of the options needs:
+// those of Language, e.g. "eng+fra", and osd to detect the orientation
+func requiredLanguages(opts ExportOptions) []string {
+	var langs []string
+	for _, lang := range strings.Split(opts.Language, "+") {
+		// ~lang excludes a language tesseract would load
+		lang = strings.TrimPrefix(strings.TrimSpace(lang), "~")
+		if lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	if opts.OCRRotate {
+		langs = append(langs, "osd")
+	}
+	return langs
+}
+
+// CheckTesseractLanguages verifies that tesseract has the languages of
+// the options before running OCR on many pages. It returns a
+// *MissingLanguagesError listing the missing ones, or ErrTesseractNotFound.
+func CheckTesseractLanguages(goCtx context.Context, opts ExportOptions) error {
+	opts = opts.withDefaults()
+	path, err := exec.LookPath(opts.TesseractPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTesseractNotFound, err)
+	}
+	installed, dir, err := TesseractLanguages(goCtx, path)
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string]bool, len(installed))
+	for _, lang := range installed {
+		available[lang] = true
+	}
+	var missing []string
+	for _, lang := range requiredLanguages(opts) {
+		if !available[lang] {
+			missing = append(missing, lang)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingLanguagesError{Missing: missing, Dir: dir}
+	}
+	return nil
+}
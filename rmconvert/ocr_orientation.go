@@ -0,0 +1,149 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/juruen/rmapi/log"
+)
+
+// osdMinConfidence is the confidence of tesseract's orientation detection
+// under which the page is recognised as it is. Handwriting gets lower
+// confidences than print.
+const osdMinConfidence = 2
+
+var (
+	reOSDRotate     = regexp.MustCompile(`Rotate:\s*(\d+)`)
+	reOSDConfidence = regexp.MustCompile(`Orientation confidence:\s*([\d.]+)`)
+)
+
+// osdWarning reports once that the orientation can't be detected, usually
+// because osd.traineddata is missing
+var osdWarning sync.Once
+
+// detectOrientation runs tesseract's orientation and script detection on a
+// PNG file and returns the clockwise rotation in degrees that makes its
+// text upright, 0 when unsure
+func detectOrientation(goCtx context.Context, tessPath, pngPath string) (int, error) {
+	cmd := exec.CommandContext(goCtx, tessPath, pngPath, "stdout", "--psm", "0", "-l", "osd")
+	cmd.WaitDelay = tesseractWaitDelay
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("tesseract orientation detection failed: %v: %s", err, output)
+	}
+	rotation, confidence, err := parseOSD(output)
+	if err != nil {
+		return 0, err
+	}
+	if confidence < osdMinConfidence {
+		return 0, nil
+	}
+	return rotation, nil
+}
+
+// parseOSD reads the rotation and its confidence in the output of
+// tesseract --psm 0
+func parseOSD(output []byte) (int, float64, error) {
+	m := reOSDRotate.FindSubmatch(output)
+	if m == nil {
+		return 0, 0, fmt.Errorf("no rotation in the orientation detection output: %s", output)
+	}
+	rotation, _ := strconv.Atoi(string(m[1]))
+	if rotation%90 != 0 {
+		return 0, 0, fmt.Errorf("unexpected rotation %d", rotation)
+	}
+	confidence := 0.0
+	if m := reOSDConfidence.FindSubmatch(output); m != nil {
+		confidence, _ = strconv.ParseFloat(string(m[1]), 64)
+	}
+	return rotation % 360, confidence, nil
+}
+
+// rotateImage returns an image turned clockwise by a multiple of 90
+// degrees
+func rotateImage(src image.Image, degrees int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if degrees == 90 || degrees == 270 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := src.At(b.Min.X+x, b.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			default:
+				dst.Set(x, y, c)
+			}
+		}
+	}
+	return dst
+}
+
+// unrotate maps the words recognised on an image turned clockwise by
+// degrees back to the image before the rotation, of width x height pixels,
+// recording the rotation that makes them upright. Their baselines are
+// dropped.
+func (ocr *PageOCR) unrotate(degrees, width, height int) {
+	for i := range ocr.Words {
+		w := &ocr.Words[i]
+		x1, y1, x2, y2 := w.X1, w.Y1, w.X2, w.Y2
+		switch degrees {
+		case 90:
+			w.X1, w.Y1, w.X2, w.Y2 = y1, height-x2, y2, height-x1
+		case 180:
+			w.X1, w.Y1, w.X2, w.Y2 = width-x2, height-y2, width-x1, height-y1
+		case 270:
+			w.X1, w.Y1, w.X2, w.Y2 = width-y2, x1, width-y1, x2
+		}
+		w.Rotation = degrees
+		if degrees != 0 {
+			w.Baseline, w.BaselineSlope = 0, 0
+		}
+	}
+	ocr.ImgW, ocr.ImgH = width, height
+}
+
+// recognizeUpright detects the orientation of the page written to pngPath
+// and recognises it turned upright, ok is false if it is upright already
+// or the orientation is unknown
+func (t *Tesseract) recognizeUpright(goCtx context.Context, tessPath string, page RenderedPage, tempDir, pngPath string) (PageOCR, bool, error) {
+	rotation, err := detectOrientation(goCtx, tessPath, pngPath)
+	if err != nil {
+		if goCtx.Err() != nil {
+			return PageOCR{}, false, goCtx.Err()
+		}
+		osdWarning.Do(func() {
+			log.Warning.Printf("the orientation of the pages can't be detected, is osd.traineddata installed? %v", err)
+		})
+		return PageOCR{}, false, nil
+	}
+	if rotation == 0 {
+		return PageOCR{}, false, nil
+	}
+
+	log.Info.Printf("page %d is rotated, turning it %d degrees for OCR", page.Number, rotation)
+	bounds := page.Image.Bounds()
+	if err := writePNGFile(rotateImage(page.Image, rotation), pngPath); err != nil {
+		return PageOCR{}, false, err
+	}
+	ocr, err := ocrOnePage(goCtx, tessPath, t.Language, t.PSM, tempDir, pngPath, page.Number)
+	if err != nil {
+		return PageOCR{}, false, err
+	}
+	ocr.unrotate(rotation, bounds.Dx(), bounds.Dy())
+	return ocr, true, nil
+}
@@ -3,17 +3,27 @@ package rmconvert
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
 
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/metrics"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/tdewolff/canvas"
 	"golang.org/x/net/html"
 )
 
@@ -23,6 +33,19 @@ type Word struct {
 	X1, Y1     int // top-left (pixels)
 	X2, Y2     int // bottom-right (pixels)
 	Confidence int
+	// Rotation is the clockwise rotation in degrees, 0, 90, 180 or 270,
+	// that makes the word upright
+	Rotation int
+	// Line numbers the lines of the page from 1, the words of a line
+	// follow each other. 0 if unknown.
+	Line int
+	// Baseline is the y in pixels of the baseline of the line at X1, and
+	// BaselineSlope its slope, from hOCR. 0 if unknown.
+	Baseline      float64
+	BaselineSlope float64
+	// XSize is the height in pixels of the letters of the line from the
+	// descenders to the ascenders, from hOCR. 0 if unknown.
+	XSize float64
 }
 
 // PageOCR holds OCR results for one page
@@ -30,115 +53,46 @@ type PageOCR struct {
 	PageNumber int
 	ImgW, ImgH int // pixels
 	Words      []Word
+	// TimedOut is set on the pages without words because their OCR took
+	// longer than ExportOptions.OCRTimeout
+	TimedOut bool
+	// Typed is set when Words are the typed text of a page without ink,
+	// see RenderedPage.NoInk, which isn't OCR text
+	Typed bool
 }
 
 // ConvertRmdocToSearchablePDF creates a searchable PDF with OCR text layer
-func ConvertRmdocToSearchablePDF(rmdocPath, pdfPath string, dpi int, tessPath, lang string, psm int) error {
-	if dpi <= 0 {
-		dpi = 300
-	}
-	if tessPath == "" {
-		tessPath = "tesseract"
-	}
-	if lang == "" {
-		lang = "eng"
-	}
-	if psm <= 0 {
-		psm = 6
-	}
-
-	// Check if tesseract is available
-	if _, err := exec.LookPath(tessPath); err != nil {
-		fmt.Printf("Warning: tesseract not found, creating non-searchable PDF\n")
-		return ConvertRmdocToImagePDF(rmdocPath, pdfPath, dpi)
-	}
-
-	// Create temporary directory
-	tempDir, err := os.MkdirTemp("", "rmdoc_ocr_*")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	// Extract .rmdoc file
-	extractDir := filepath.Join(tempDir, "extracted")
-	err = extractZip(rmdocPath, extractDir)
-	if err != nil {
-		return fmt.Errorf("failed to extract .rmdoc: %v", err)
-	}
-
-	// Get page order
-	pageOrder, docDir, err := getPageOrderAndDocDir(extractDir)
-	if err != nil {
-		return fmt.Errorf("failed to get page order: %v", err)
-	}
-
-	if len(pageOrder) == 0 {
-		return fmt.Errorf("no pages found in document")
-	}
-
-	// Convert each page to PNG
-	var pngFiles []string
-	var ocrResults []PageOCR
-
-	for i, pageID := range pageOrder {
-		rmFile := filepath.Join(docDir, pageID+".rm")
-		if _, err := os.Stat(rmFile); err != nil {
-			fmt.Printf("Warning: page %s not found, skipping\n", pageID)
-			continue
-		}
-
-		pngPath := filepath.Join(tempDir, fmt.Sprintf("page_%04d.png", i+1))
-		err := convertRMToPNG(rmFile, pngPath, dpi)
-		if err != nil {
-			fmt.Printf("Warning: failed to convert page %s: %v\n", pageID, err)
-			continue
-		}
-
-		pngFiles = append(pngFiles, pngPath)
-
-		// Run OCR
-		fmt.Printf("Running OCR on page %d...\n", i+1)
-		ocr, err := ocrOnePage(tessPath, lang, psm, tempDir, pngPath, i+1)
-		if err != nil {
-			fmt.Printf("Warning: OCR failed for page %d: %v\n", i+1, err)
-			// Continue without OCR for this page
-		} else {
-			ocrResults = append(ocrResults, ocr)
-		}
-	}
+func ConvertRmdocToSearchablePDF(goCtx context.Context, rmdocPath, pdfPath string, dpi int, tessPath, lang string, psm int) error {
+	_, err := convertRmdocToSearchablePDF(goCtx, rmdocPath, pdfPath, pdfOptions(dpi, true, tessPath, lang, psm))
+	return err
+}
 
-	if len(pngFiles) == 0 {
-		return fmt.Errorf("no pages were successfully converted")
+// convertRmdocToSearchablePDF creates a searchable PDF and returns the OCR
+// results, which are empty if tesseract is missing
+func convertRmdocToSearchablePDF(goCtx context.Context, rmdocPath, pdfPath string, opts ExportOptions) ([]PageOCR, error) {
+	if !tesseractAvailable(opts) {
+		log.Warning.Println("tesseract not found, creating non-searchable PDF")
+		return nil, convertRmdocToImagePDF(goCtx, rmdocPath, pdfPath, opts)
 	}
 
-	// Create PDF from images
-	err = createPDFFromImages(pngFiles, pdfPath)
+	doc, err := OpenRmDoc(rmdocPath)
 	if err != nil {
-		return err
-	}
-
-	// Add OCR text layers if we have results
-	if len(ocrResults) > 0 {
-		fmt.Printf("Adding searchable text layer to %d pages...\n", len(ocrResults))
-		err = addOCRTextToPDF(pdfPath, ocrResults, dpi)
-		if err != nil {
-			fmt.Printf("Warning: failed to add OCR text layer: %v\n", err)
-			// PDF still exists, just without searchable text
-		}
+		return nil, fmt.Errorf("failed to open .rmdoc: %v", err)
 	}
+	defer doc.Close()
 
-	return nil
+	return NewPipeline(opts).SearchablePDF(goCtx, doc, pdfPath)
 }
 
-// ocrOnePage runs tesseract OCR on a PNG image
-func ocrOnePage(tessPath, lang string, psm int, tmpDir, pngPath string, pageNum int) (PageOCR, error) {
+// ocrOnePage runs tesseract OCR on a PNG image, tesseract is killed if goCtx
+// is cancelled
+func ocrOnePage(goCtx context.Context, tessPath, lang string, psm int, tmpDir, pngPath string, pageNum int) (PageOCR, error) {
 	pageTag := fmt.Sprintf("ocr_p%04d", pageNum)
 	hocrPath := filepath.Join(tmpDir, pageTag+".hocr")
 	outBase := strings.TrimSuffix(hocrPath, ".hocr")
 
 	// Run tesseract
-	cmd := exec.Command(tessPath,
+	cmd := exec.CommandContext(goCtx, tessPath,
 		pngPath,
 		outBase,
 		"-l", lang,
@@ -146,7 +100,11 @@ func ocrOnePage(tessPath, lang string, psm int, tmpDir, pngPath string, pageNum
 		"hocr",
 	)
 
+	// tesseract is killed when goCtx is done, its output isn't waited for
+	cmd.WaitDelay = tesseractWaitDelay
+	started := time.Now()
 	output, err := cmd.CombinedOutput()
+	metrics.OCRDuration.Observe(time.Since(started).Seconds())
 	if err != nil {
 		return PageOCR{}, fmt.Errorf("tesseract failed: %v: %s", err, string(output))
 	}
@@ -190,16 +148,26 @@ func parseHOCRWords(r *os.File) ([]Word, int, int, error) {
 
 	var words []Word
 	var imgW, imgH int
+	// the line of the words being walked, ocr_line and the other classes of
+	// lines of tesseract
+	line, lines := hocrLine{}, 0
 
 	reBBox := regexp.MustCompile(`bbox\s+(\d+)\s+(\d+)\s+(\d+)\s+(\d+)`)
 	reConf := regexp.MustCompile(`x_wconf\s+(\d+)`)
 
 	var walk func(*html.Node)
 	walk = func(n *html.Node) {
+		parentLine := line
 		if n.Type == html.ElementNode {
 			cls := getAttr(n, "class")
 			title := getAttr(n, "title")
 
+			switch cls {
+			case "ocr_line", "ocr_caption", "ocr_header", "ocr_textfloat":
+				lines++
+				line = parseHOCRLine(lines, title)
+			}
+
 			// Get page dimensions
 			if strings.Contains(cls, "ocr_page") {
 				if m := reBBox.FindStringSubmatch(title); m != nil {
@@ -223,14 +191,21 @@ func parseHOCRWords(r *os.File) ([]Word, int, int, error) {
 
 					txt := strings.TrimSpace(textContent(n))
 					if txt != "" {
-						words = append(words, Word{
+						word := Word{
 							Text:       txt,
 							X1:         x1,
 							Y1:         y1,
 							X2:         x2,
 							Y2:         y2,
 							Confidence: conf,
-						})
+							Line:       line.number,
+							XSize:      line.xSize,
+						}
+						if line.hasBaseline {
+							word.Baseline = line.baseline(x1)
+							word.BaselineSlope = line.slope
+						}
+						words = append(words, word)
 					}
 				}
 			}
@@ -239,12 +214,58 @@ func parseHOCRWords(r *os.File) ([]Word, int, int, error) {
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			walk(c)
 		}
+		line = parentLine
 	}
 
 	walk(doc)
 	return words, imgW, imgH, nil
 }
 
+var (
+	reHOCRBBox     = regexp.MustCompile(`bbox\s+(-?\d+)\s+(-?\d+)\s+(-?\d+)\s+(-?\d+)`)
+	reHOCRBaseline = regexp.MustCompile(`baseline\s+(-?[\d.]+)\s+(-?[\d.]+)`)
+	reHOCRXSize    = regexp.MustCompile(`x_size\s+([\d.]+)`)
+)
+
+// hocrLine is a line of hOCR, the baseline and letter size of its words
+type hocrLine struct {
+	number int
+	// x1 and y2 are the left and bottom of the line, from which the
+	// baseline is measured
+	x1, y2      int
+	hasBaseline bool
+	slope       float64
+	offset      float64
+	xSize       float64
+}
+
+// parseHOCRLine reads the title of a line, e.g. "bbox 10 10 300 40;
+// baseline 0.005 -7; x_size 30"
+func parseHOCRLine(number int, title string) hocrLine {
+	line := hocrLine{number: number}
+	if m := reHOCRXSize.FindStringSubmatch(title); m != nil {
+		line.xSize, _ = strconv.ParseFloat(m[1], 64)
+	}
+	box := reHOCRBBox.FindStringSubmatch(title)
+	m := reHOCRBaseline.FindStringSubmatch(title)
+	if box == nil || m == nil {
+		return line
+	}
+	line.x1, _ = strconv.Atoi(box[1])
+	line.y2, _ = strconv.Atoi(box[4])
+	slope, err1 := strconv.ParseFloat(m[1], 64)
+	offset, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 == nil && err2 == nil {
+		line.hasBaseline, line.slope, line.offset = true, slope, offset
+	}
+	return line
+}
+
+// baseline returns the y of the baseline of the line at x
+func (l hocrLine) baseline(x int) float64 {
+	return float64(l.y2) + l.offset + l.slope*float64(x-l.x1)
+}
+
 func getAttr(n *html.Node, key string) string {
 	for _, a := range n.Attr {
 		if a.Key == key {
@@ -281,24 +302,39 @@ func addOCRTextToPDF(pdfPath string, ocrResults []PageOCR, dpi int) error {
 		return fmt.Errorf("failed to get page dimensions: %v", err)
 	}
 
-	// NOTE: pdfcpu imports PNGs without DPI metadata as 72 DPI (1 pixel = 1 point)
-	// So we use 1:1 pixel-to-point mapping regardless of render DPI
-	pxToPt := 1.0
+	// the spaces between the words of a line are written too
+	var text strings.Builder
+	text.WriteString(" ")
+	for _, ocr := range ocrResults {
+		for _, word := range ocr.Words {
+			text.WriteString(word.Text)
+		}
+	}
+	subset, err := TextFont.subset(canvas.FontRegular, text.String(), true)
+	if err != nil {
+		return fmt.Errorf("failed to subset the font %s: %v", TextFont.Name, err)
+	}
+	fontRef, err := addPDFFont(ctx.XRefTable, subset)
+	if err != nil {
+		return fmt.Errorf("failed to embed the font %s: %v", TextFont.Name, err)
+	}
 
 	for _, ocr := range ocrResults {
-		if ocr.PageNumber > len(pageDims) {
+		if ocr.PageNumber > len(pageDims) || len(ocr.Words) == 0 {
 			continue
 		}
 
+		// the image fills the page: the PDFs written by imagePDF have one
+		// point per pixel, scanned PDFs any resolution
 		dim := pageDims[ocr.PageNumber-1]
-		pageHpt := dim.Height
-
-		stream := buildInvisibleTextStream(ocr, pageHpt, pxToPt)
-		if len(stream) == 0 {
-			continue
+		sx, sy := 1.0, 1.0
+		if ocr.ImgW > 0 && ocr.ImgH > 0 {
+			sx, sy = dim.Width/float64(ocr.ImgW), dim.Height/float64(ocr.ImgH)
 		}
 
-		err := appendTextStreamToPage(ctx, ocr.PageNumber, stream)
+		err := appendTextStreamToPage(ctx, ocr.PageNumber, *fontRef, func(fontName string) []byte {
+			return buildInvisibleTextStream(ocr, dim.Height, sx, sy, subset, fontName)
+		})
 		if err != nil {
 			return fmt.Errorf("failed to add text to page %d: %v", ocr.PageNumber, err)
 		}
@@ -307,8 +343,12 @@ func addOCRTextToPDF(pdfPath string, ocrResults []PageOCR, dpi int) error {
 	return api.WriteContextFile(ctx, pdfPath)
 }
 
-// buildInvisibleTextStream creates PDF content stream with invisible text
-func buildInvisibleTextStream(ocr PageOCR, pageHpt float64, pxToPt float64) []byte {
+// buildInvisibleTextStream creates PDF content stream with invisible text,
+// written with the glyphs of the font subset, the resource fontName of the
+// page. The pixels of the OCR are sx by sy points. The words of a line are
+// written together, separated by spaces, each stretched to its box, so that
+// viewers select and copy the line as a sentence.
+func buildInvisibleTextStream(ocr PageOCR, pageHpt float64, sx, sy float64, font *fontSubset, fontName string) []byte {
 	if len(ocr.Words) == 0 {
 		return nil
 	}
@@ -322,29 +362,69 @@ func buildInvisibleTextStream(ocr PageOCR, pageHpt float64, pxToPt float64) []by
 	fmt.Fprintln(w, "0 g")
 
 	lastFontSize := -1.0
-	for _, word := range ocr.Words {
-		// Convert OCR bounding box from pixels to PDF points (pxToPt = 1.0)
-		x1pt := float64(word.X1) * pxToPt
-		y1pt := float64(word.Y1) * pxToPt
-		y2pt := float64(word.Y2) * pxToPt
-
-		// Calculate text height for font sizing
-		hpt := y2pt - y1pt
-		fontSize := clamp(hpt*0.85, 4, 72)
+	for _, line := range textLines(ocr.Words) {
+		// Convert OCR bounding box of the line from pixels to PDF points
+		x1pt, x2pt := float64(line.box.X1)*sx, float64(line.box.X2)*sx
+		y1pt, y2pt := float64(line.box.Y1)*sy, float64(line.box.Y2)*sy
+		first := line.words[0]
+
+		// The text starts at the start of the baseline of the first word,
+		// at the bottom of the line for upright words, and runs in the
+		// direction dx, dy of the PDF coordinates. The height of the line
+		// sizes the font.
+		ox, oy, dx, dy, hpt := float64(first.X1)*sx, y2pt, 1, 0, y2pt-y1pt
+		switch first.Rotation {
+		case 90:
+			// written bottom to top
+			ox, oy, dx, dy, hpt = x2pt, float64(first.Y2)*sy, 0, 1, x2pt-x1pt
+		case 180:
+			ox, oy, dx, dy = float64(first.X2)*sx, y1pt, -1, 0
+		case 270:
+			// written top to bottom
+			ox, oy, dx, dy, hpt = x1pt, float64(first.Y1)*sy, 0, -1, x2pt-x1pt
+		}
+		lineFontSize := clamp(hpt*0.85, 4, 72)
 
 		// PDF coordinate system: (0,0) at bottom-left, Y increases upward
 		// OCR coordinates: (0,0) at top-left, Y increases downward
 		// pdfcpu embeds images with Y-flip, so we need to flip OCR coordinates
-		// Position text at baseline (bottom of bbox): y2
-		ypt := pageHpt - y2pt
-
-		if abs(fontSize-lastFontSize) > 0.25 {
-			fmt.Fprintf(w, "/F0 %.2f Tf\n", fontSize)
-			lastFontSize = fontSize
+		ypt := pageHpt - oy
+
+		// upright lines with an hOCR baseline are written on it, tilted by
+		// its slope; the words are longer along it by 1/cos
+		stretch := 1.0
+		if first.Rotation == 0 && first.Baseline != 0 {
+			ypt = pageHpt - first.Baseline*sy
+			if first.BaselineSlope != 0 {
+				sin, cos := math.Sincos(-math.Atan(first.BaselineSlope * sy / sx))
+				fmt.Fprintf(w, "%.4f %.4f %.4f %.4f %.2f %.2f Tm\n", cos, sin, -sin, cos, ox, ypt)
+				stretch = 1 / cos
+			} else {
+				fmt.Fprintf(w, "1 0 0 1 %.2f %.2f Tm\n", ox, ypt)
+			}
+		} else {
+			fmt.Fprintf(w, "%d %d %d %d %.2f %.2f Tm\n", dx, dy, -dy, dx, ox, ypt)
 		}
 
-		fmt.Fprintf(w, "1 0 0 1 %.2f %.2f Tm\n", x1pt, ypt)
-		fmt.Fprintf(w, "(%s) Tj\n", pdfEscapeString(word.Text))
+		for i, word := range line.words {
+			// the letters of the line size the words known to hOCR
+			fontSize := lineFontSize
+			if word.XSize > 0 && word.Rotation == 0 {
+				fontSize = clamp(word.XSize*sy, 4, 72)
+			}
+			if abs(fontSize-lastFontSize) > 0.25 {
+				fmt.Fprintf(w, "/%s %.2f Tf\n", fontName, fontSize)
+				lastFontSize = fontSize
+			}
+
+			start, end := word.along(sx, sy)
+			if i > 0 {
+				// the space spans the gap to the word
+				_, prevEnd := line.words[i-1].along(sx, sy)
+				writeStretched(w, font, " ", fontSize, (start-prevEnd)*stretch)
+			}
+			writeStretched(w, font, word.Text, fontSize, (end-start)*stretch)
+		}
 	}
 
 	fmt.Fprintln(w, "ET")
@@ -354,6 +434,62 @@ func buildInvisibleTextStream(ocr PageOCR, pageHpt float64, pxToPt float64) []by
 	return buf.Bytes()
 }
 
+// textLine is a line of words in the text layer
+type textLine struct {
+	words []Word
+	// box is the bounding box of the words
+	box Word
+}
+
+// textLines groups the words by line, a word without line being a line of
+// its own
+func textLines(words []Word) []textLine {
+	var lines []textLine
+	for _, word := range words {
+		if n := len(lines); n > 0 && word.Line != 0 {
+			last := &lines[n-1]
+			if prev := last.words[len(last.words)-1]; prev.Line == word.Line && prev.Rotation == word.Rotation {
+				last.words = append(last.words, word)
+				last.box.X1, last.box.Y1 = min(last.box.X1, word.X1), min(last.box.Y1, word.Y1)
+				last.box.X2, last.box.Y2 = max(last.box.X2, word.X2), max(last.box.Y2, word.Y2)
+				continue
+			}
+		}
+		lines = append(lines, textLine{words: []Word{word}, box: word})
+	}
+	return lines
+}
+
+// along returns where the word starts and ends in points along the
+// direction it is written in
+func (word Word) along(sx, sy float64) (float64, float64) {
+	switch word.Rotation {
+	case 90:
+		return -float64(word.Y2) * sy, -float64(word.Y1) * sy
+	case 180:
+		return -float64(word.X2) * sx, -float64(word.X1) * sx
+	case 270:
+		return float64(word.Y1) * sy, float64(word.Y2) * sy
+	}
+	return float64(word.X1) * sx, float64(word.X2) * sx
+}
+
+// writeStretched writes text scaled horizontally to be width points wide,
+// with its natural width if it has none
+func writeStretched(w io.Writer, font *fontSubset, text string, fontSize, width float64) {
+	natural := 0.0
+	for _, r := range text {
+		if g := int(font.glyphs[r]); g < len(font.widths) {
+			natural += float64(font.widths[g]) / 1000 * fontSize
+		}
+	}
+	scale := 100.0
+	if natural > 0 && width > 0 {
+		scale = width / natural * 100
+	}
+	fmt.Fprintf(w, "%.2f Tz <%s> Tj\n", scale, pdfGlyphString(font, text))
+}
+
 func clamp(v, lo, hi float64) float64 {
 	if v < lo {
 		return lo
@@ -371,50 +507,167 @@ func abs(v float64) float64 {
 	return v
 }
 
-func pdfEscapeString(s string) string {
+// pdfGlyphString returns the codes of the glyphs of text in the font
+// subset, as a hex string
+func pdfGlyphString(font *fontSubset, text string) string {
 	var b strings.Builder
-	for _, r := range s {
-		switch r {
-		case '\\':
-			b.WriteString(`\\`)
-		case '(':
-			b.WriteString(`\(`)
-		case ')':
-			b.WriteString(`\)`)
-		case '\n':
-			b.WriteString(`\n`)
-		case '\r':
-			b.WriteString(`\r`)
-		case '\t':
-			b.WriteString(`\t`)
-		default:
-			b.WriteRune(r)
-		}
+	for _, r := range text {
+		fmt.Fprintf(&b, "%04X", font.glyphs[r])
 	}
 	return b.String()
 }
 
-// appendTextStreamToPage adds text stream to PDF page
-func appendTextStreamToPage(ctx *model.Context, pageNr int, content []byte) error {
+// addPDFFont adds a font subset to the PDF, as a Type0 font whose codes
+// are the glyph IDs of the subset, with their characters for copy and
+// search
+func addPDFFont(x *model.XRefTable, font *fontSubset) (*types.IndirectRef, error) {
+	fontFile, err := x.NewStreamDictForBuf(font.data)
+	if err != nil {
+		return nil, err
+	}
+	fontFileKey, cidFontType := "FontFile2", "CIDFontType2"
+	if font.cff {
+		fontFile.InsertName("Subtype", "OpenType")
+		fontFileKey, cidFontType = "FontFile3", "CIDFontType0"
+	} else {
+		fontFile.InsertInt("Length1", len(font.data))
+	}
+	if err := fontFile.Encode(); err != nil {
+		return nil, err
+	}
+	fontFileRef, err := x.IndRefForNewObject(*fontFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the name of a subset starts with a tag of six capital letters
+	h := fnv.New32a()
+	for _, r := range font.runes {
+		fmt.Fprintf(h, "%c", r)
+	}
+	sum := h.Sum32()
+	var tag [6]byte
+	for i := range tag {
+		tag[i] = 'A' + byte(sum%26)
+		sum /= 26
+	}
+	name := string(tag[:]) + "+" + font.postScriptName
+
+	descriptorRef, err := x.IndRefForNewObject(types.Dict{
+		"Type":        types.Name("FontDescriptor"),
+		"FontName":    types.Name(name),
+		"Flags":       types.Integer(32), // non symbolic
+		"FontBBox":    types.NewIntegerArray(font.bbox[:]...),
+		"ItalicAngle": types.Float(font.italicAngle),
+		"Ascent":      types.Integer(font.ascent),
+		"Descent":     types.Integer(font.descent),
+		"CapHeight":   types.Integer(font.capHeight),
+		"StemV":       types.Integer(80),
+		fontFileKey:   *fontFileRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	widths := make(types.Array, len(font.widths))
+	for i, width := range font.widths {
+		widths[i] = types.Integer(width)
+	}
+	cidFont := types.Dict{
+		"Type":     types.Name("Font"),
+		"Subtype":  types.Name(cidFontType),
+		"BaseFont": types.Name(name),
+		"CIDSystemInfo": types.Dict{
+			"Registry":   types.StringLiteral("Adobe"),
+			"Ordering":   types.StringLiteral("Identity"),
+			"Supplement": types.Integer(0),
+		},
+		"FontDescriptor": *descriptorRef,
+		"W":              types.Array{types.Integer(0), widths},
+	}
+	if !font.cff {
+		cidFont["CIDToGIDMap"] = types.Name("Identity")
+	}
+	cidFontRef, err := x.IndRefForNewObject(cidFont)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmap bytes.Buffer
+	fmt.Fprintf(&cmap, "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	fmt.Fprintf(&cmap, "/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	fmt.Fprintf(&cmap, "/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	fmt.Fprintf(&cmap, "1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// at most 100 entries per block
+	for start := 1; start < len(font.runes); start += 100 {
+		end := min(start+100, len(font.runes))
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for glyph := start; glyph < end; glyph++ {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", glyph, utf16Hex(font.runes[glyph]))
+		}
+		fmt.Fprintf(&cmap, "endbfchar\n")
+	}
+	fmt.Fprintf(&cmap, "endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	toUnicode, err := x.NewStreamDictForBuf(cmap.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := toUnicode.Encode(); err != nil {
+		return nil, err
+	}
+	toUnicodeRef, err := x.IndRefForNewObject(*toUnicode)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.IndRefForNewObject(types.Dict{
+		"Type":            types.Name("Font"),
+		"Subtype":         types.Name("Type0"),
+		"BaseFont":        types.Name(name),
+		"Encoding":        types.Name("Identity-H"),
+		"DescendantFonts": types.Array{*cidFontRef},
+		"ToUnicode":       *toUnicodeRef,
+	})
+}
+
+// utf16Hex returns the UTF-16 code units of r as hex, for ToUnicode CMaps
+func utf16Hex(r rune) string {
+	if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+		return fmt.Sprintf("%04X%04X", r1, r2)
+	}
+	return fmt.Sprintf("%04X", r)
+}
+
+// appendTextStreamToPage adds the text stream built with the resource name
+// of the font font to PDF page, nothing if it is empty
+func appendTextStreamToPage(ctx *model.Context, pageNr int, font types.IndirectRef, build func(fontName string) []byte) error {
 	x := ctx.XRefTable
 
-	pageDict, pageIndRef, inh, err := x.PageDict(pageNr, false)
+	pageDict, pageIndRef, inherited, err := x.PageDict(pageNr, false)
 	if err != nil {
 		return err
 	}
 
-	// Ensure Helvetica font resource
-	if err := ensureHelveticaFont(x, pageDict, inh); err != nil {
+	// Ensure the font resource
+	fontName, err := ensureTextFont(x, pageDict, inherited, font)
+	if err != nil {
 		return err
 	}
+	content := build(fontName)
+	if len(content) == 0 {
+		return nil
+	}
 
-	// Create new stream dict properly
-	length := int64(len(content))
-	sd := types.NewStreamDict(types.Dict{}, length, nil, nil, nil)
-	sd.Content = content
-	sd.Raw = content
+	// Create new stream dict properly, pdfcpu writes the encoded stream
+	sd, err := x.NewStreamDictForBuf(content)
+	if err != nil {
+		return err
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
 
-	newIR, err := x.IndRefForNewObject(sd)
+	newIR, err := x.IndRefForNewObject(*sd)
 	if err != nil {
 		return err
 	}
@@ -443,30 +696,35 @@ func appendTextStreamToPage(ctx *model.Context, pageNr int, content []byte) erro
 	return nil
 }
 
-// ensureHelveticaFont ensures Helvetica font is available in page resources
-func ensureHelveticaFont(x *model.XRefTable, pageDict types.Dict, inh *model.InheritedPageAttrs) error {
+// ensureTextFont ensures the font of the text layer is available in page
+// resources and returns its name there
+func ensureTextFont(x *model.XRefTable, pageDict types.Dict, inherited *model.InheritedPageAttrs, font types.IndirectRef) (string, error) {
 	// Get or create Resources
 	resObj := pageDict["Resources"]
 	var resDict types.Dict
 
 	switch r := resObj.(type) {
 	case nil:
+		// the page gets its own copy of the resources of its parents
 		resDict = types.Dict(map[string]types.Object{})
+		if inherited != nil && inherited.Resources != nil {
+			resDict = inherited.Resources.Clone().(types.Dict)
+		}
 		pageDict["Resources"] = resDict
 	case types.Dict:
 		resDict = r
 	case types.IndirectRef:
 		o, err := x.Dereference(r)
 		if err != nil {
-			return err
+			return "", err
 		}
 		d, ok := o.(types.Dict)
 		if !ok {
-			return fmt.Errorf("Resources not a dict: %T", o)
+			return "", fmt.Errorf("Resources not a dict: %T", o)
 		}
 		resDict = d
 	default:
-		return fmt.Errorf("unsupported Resources type: %T", resObj)
+		return "", fmt.Errorf("unsupported Resources type: %T", resObj)
 	}
 
 	// Get or create Font dict
@@ -482,31 +740,28 @@ func ensureHelveticaFont(x *model.XRefTable, pageDict types.Dict, inh *model.Inh
 	case types.IndirectRef:
 		o, err := x.Dereference(f)
 		if err != nil {
-			return err
+			return "", err
 		}
 		d, ok := o.(types.Dict)
 		if !ok {
-			return fmt.Errorf("Font not a dict: %T", o)
+			return "", fmt.Errorf("Font not a dict: %T", o)
 		}
 		fontDict = d
 	default:
-		return fmt.Errorf("unsupported Font type: %T", fdObj)
+		return "", fmt.Errorf("unsupported Font type: %T", fdObj)
 	}
 
-	// Add Helvetica if not present
-	if _, ok := fontDict["F0"]; !ok {
-		helv := types.Dict(map[string]types.Object{
-			"Type":     types.Name("Font"),
-			"Subtype":  types.Name("Type1"),
-			"BaseFont": types.Name("Helvetica"),
-			"Encoding": types.Name("WinAnsiEncoding"),
-		})
-		ir, err := x.IndRefForNewObject(helv)
-		if err != nil {
-			return err
+	// Add the font, under another name if the page has a font F0
+	name := "F0"
+	for i := 1; ; i++ {
+		existing, ok := fontDict[name]
+		if !ok {
+			fontDict[name] = font
+			return name, nil
 		}
-		fontDict["F0"] = *ir
+		if ref, ok := existing.(types.IndirectRef); ok && ref.ObjectNumber == font.ObjectNumber {
+			return name, nil
+		}
+		name = fmt.Sprintf("F0_%d", i)
 	}
-
-	return nil
 }
@@ -0,0 +1,262 @@
+package rmconvert
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+const (
+	// deskewMaxAngle is the largest skew in degrees that deskewing corrects
+	deskewMaxAngle = 5.0
+	// deskewStep is the precision of the skew detection in degrees
+	deskewStep = 0.25
+	// deskewMaxPoints is the number of dark pixels sampled to detect the
+	// skew
+	deskewMaxPoints = 100000
+)
+
+// Preprocess cleans up the rendered pages before OCR, mostly for light
+// pencil strokes and scans
+type Preprocess struct {
+	// Deskew straightens the pages whose lines are tilted by up to 5
+	// degrees
+	Deskew bool
+	// Binarize turns the pages black and white with a threshold between
+	// the ink and the paper, so light strokes are as dark as the others
+	Binarize bool
+	// Despeckle removes the isolated dark pixels
+	Despeckle bool
+}
+
+// preprocess returns the Preprocess of the options
+func (o ExportOptions) preprocess() Preprocess {
+	return Preprocess{Deskew: o.OCRDeskew, Binarize: o.OCRBinarize, Despeckle: o.OCRDespeckle}
+}
+
+func (p Preprocess) enabled() bool {
+	return p.Deskew || p.Binarize || p.Despeckle
+}
+
+// Apply returns the preprocessed image and the skew in degrees it was
+// straightened by, positive when the lines went down to the right
+func (p Preprocess) Apply(src image.Image) (*image.Gray, float64) {
+	gray := toGray(src)
+	threshold := otsuThreshold(gray)
+	if p.Binarize {
+		binarize(gray, threshold)
+	}
+	if p.Despeckle {
+		despeckle(gray, threshold)
+	}
+	angle := 0.0
+	if p.Deskew {
+		angle = detectSkew(gray, threshold)
+		if angle != 0 {
+			gray = rotateGray(gray, angle)
+		}
+	}
+	return gray, angle
+}
+
+// preprocessEngine preprocesses the pages before recognising them with
+// its engine
+type preprocessEngine struct {
+	engine     OCREngine
+	preprocess Preprocess
+}
+
+// Recognize recognises the preprocessed page and returns the words in the
+// pixels of the page before deskewing
+func (e *preprocessEngine) Recognize(goCtx context.Context, page RenderedPage) (PageOCR, error) {
+	img, angle := e.preprocess.Apply(page.Image)
+	page.Image = img
+	ocr, err := e.engine.Recognize(goCtx, page)
+	if err != nil {
+		return PageOCR{}, err
+	}
+	if angle != 0 {
+		ocr.unskew(angle, img.Bounds().Dx(), img.Bounds().Dy())
+	}
+	return ocr, nil
+}
+
+// toGray returns a grayscale copy of an image, starting at 0, 0
+func toGray(src image.Image) *image.Gray {
+	b := src.Bounds()
+	if rgba, ok := src.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return grayImage(rgba)
+	}
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), src, b.Min, draw.Src)
+	return gray
+}
+
+// otsuThreshold returns the gray level separating the ink from the paper,
+// the pixels under it being ink
+func otsuThreshold(img *image.Gray) uint8 {
+	var histogram [256]int
+	for _, v := range img.Pix {
+		histogram[v]++
+	}
+	total := len(img.Pix)
+	sum := 0.0
+	for i, n := range histogram {
+		sum += float64(i * n)
+	}
+
+	best, threshold := -1.0, 128
+	sumDark, dark := 0.0, 0
+	for i, n := range histogram {
+		dark += n
+		if dark == 0 {
+			continue
+		}
+		light := total - dark
+		if light == 0 {
+			break
+		}
+		sumDark += float64(i * n)
+		meanDark, meanLight := sumDark/float64(dark), (sum-sumDark)/float64(light)
+		variance := float64(dark) * float64(light) * (meanDark - meanLight) * (meanDark - meanLight)
+		if variance > best {
+			best, threshold = variance, i+1
+		}
+	}
+	return uint8(min(threshold, 255))
+}
+
+// binarize turns the pixels under the threshold black and the others white
+func binarize(img *image.Gray, threshold uint8) {
+	for i, v := range img.Pix {
+		if v < threshold {
+			img.Pix[i] = 0
+		} else {
+			img.Pix[i] = 255
+		}
+	}
+}
+
+// despeckle turns white the dark pixels with at most one dark neighbour
+func despeckle(img *image.Gray, threshold uint8) {
+	b := img.Bounds()
+	dark := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < b.Dx() && y < b.Dy() && img.Pix[y*img.Stride+x] < threshold
+	}
+	var specks []int
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if !dark(x, y) {
+				continue
+			}
+			neighbours := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && dark(x+dx, y+dy) {
+						neighbours++
+					}
+				}
+			}
+			if neighbours <= 1 {
+				specks = append(specks, y*img.Stride+x)
+			}
+		}
+	}
+	for _, i := range specks {
+		img.Pix[i] = 255
+	}
+}
+
+// detectSkew returns the angle in degrees of the lines of dark pixels,
+// positive when they go down to the right: the rotation whose rows hold the
+// dark pixels the most unevenly. It returns 0 below the detection step.
+func detectSkew(img *image.Gray, threshold uint8) float64 {
+	b := img.Bounds()
+	var points []image.Point
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if img.Pix[y*img.Stride+x] < threshold {
+				points = append(points, image.Pt(x, y))
+			}
+		}
+	}
+	if len(points) == 0 {
+		return 0
+	}
+	step := max(1, len(points)/deskewMaxPoints)
+
+	score := func(angle float64) float64 {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		rows := map[int]int{}
+		for i := 0; i < len(points); i += step {
+			p := points[i]
+			rows[int(math.Floor(float64(p.Y)*cos-float64(p.X)*sin))]++
+		}
+		s := 0.0
+		for _, n := range rows {
+			s += float64(n * n)
+		}
+		return s
+	}
+
+	best, bestScore := 0.0, score(0)
+	for angle := -deskewMaxAngle; angle <= deskewMaxAngle; angle += deskewStep {
+		if s := score(angle); s > bestScore {
+			best, bestScore = angle, s
+		}
+	}
+	if math.Abs(best) < deskewStep {
+		return 0
+	}
+	return best
+}
+
+// rotateGray returns an image of the same size rotated about its center so
+// that lines tilted by angle degrees are level, filled with white
+func rotateGray(src *image.Gray, angle float64) *image.Gray {
+	b := src.Bounds()
+	dst := image.NewGray(b)
+	draw.Draw(dst, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			// the pixel of the source that lands on x, y
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx, sy := int(math.Floor(cx+dx*cos-dy*sin)), int(math.Floor(cy+dx*sin+dy*cos))
+			if sx >= 0 && sy >= 0 && sx < b.Dx() && sy < b.Dy() {
+				dst.Pix[y*dst.Stride+x] = src.Pix[sy*src.Stride+sx]
+			}
+		}
+	}
+	return dst
+}
+
+// unskew maps the words recognised on an image of width x height pixels
+// deskewed by rotateGray back to the image before, each to the bounding box
+// of its corners, with their baselines tilted back
+func (ocr *PageOCR) unskew(angle float64, width, height int) {
+	cx, cy := float64(width)/2, float64(height)/2
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	back := func(x, y float64) (float64, float64) {
+		dx, dy := x-cx, y-cy
+		return cx + dx*cos - dy*sin, cy + dx*sin + dy*cos
+	}
+	for i := range ocr.Words {
+		w := &ocr.Words[i]
+		x0, y0, x1, y1 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, c := range [][2]int{{w.X1, w.Y1}, {w.X2, w.Y1}, {w.X1, w.Y2}, {w.X2, w.Y2}} {
+			x, y := back(float64(c[0]), float64(c[1]))
+			x0, y0, x1, y1 = min(x0, x), min(y0, y), max(x1, x), max(y1, y)
+		}
+		bx, by := back(float64(w.X1), w.Baseline)
+		w.X1, w.Y1 = max(0, int(math.Floor(x0))), max(0, int(math.Floor(y0)))
+		w.X2, w.Y2 = min(width, int(math.Ceil(x1))), min(height, int(math.Ceil(y1)))
+		if w.Baseline != 0 {
+			w.BaselineSlope = math.Tan(math.Atan(w.BaselineSlope) + angle*math.Pi/180)
+			w.Baseline = by + w.BaselineSlope*(float64(w.X1)-bx)
+		}
+	}
+}
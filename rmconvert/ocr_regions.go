@@ -0,0 +1,136 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/tdewolff/canvas"
+)
+
+// ocrRegionGap is the distance in device pixels, about 5 mm, under which
+// strokes are recognised in the same region, and the margin around them
+const ocrRegionGap = 48
+
+// ocrRegionMaxCoverage is the part of the page the regions cover above
+// which the whole page is recognised at once
+const ocrRegionMaxCoverage = 0.6
+
+// inkRegions returns the areas of the page with strokes in device pixels:
+// the bounding boxes of the strokes grown by ocrRegionGap, merged while
+// they overlap. The eraser strokes are left out.
+func (page *Page) inkRegions() []canvas.Rect {
+	var regions []canvas.Rect
+	for i := range page.Strokes {
+		stroke := &page.Strokes[i]
+		if stroke.Tool == ToolEraser || len(stroke.Points) == 0 {
+			continue
+		}
+		r := canvas.Rect{X0: math.Inf(1), Y0: math.Inf(1), X1: math.Inf(-1), Y1: math.Inf(-1)}
+		for _, p := range stroke.Points {
+			r.X0, r.Y0 = min(r.X0, float64(p.X)), min(r.Y0, float64(p.Y))
+			r.X1, r.Y1 = max(r.X1, float64(p.X)), max(r.Y1, float64(p.Y))
+		}
+		margin := ocrRegionGap + float64(GetToolProperties(stroke.Tool, stroke.Color, stroke.Width).StrokeWidth)/2
+		regions = append(regions, canvas.Rect{X0: r.X0 - margin, Y0: r.Y0 - margin, X1: r.X1 + margin, Y1: r.Y1 + margin})
+	}
+
+	// merging two regions can make the result overlap a third one
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(regions); i++ {
+			for j := i + 1; j < len(regions); j++ {
+				a, b := regions[i], regions[j]
+				if a.X0 > b.X1 || b.X0 > a.X1 || a.Y0 > b.Y1 || b.Y0 > a.Y1 {
+					continue
+				}
+				regions[i] = canvas.Rect{X0: min(a.X0, b.X0), Y0: min(a.Y0, b.Y0), X1: max(a.X1, b.X1), Y1: max(a.Y1, b.Y1)}
+				regions = append(regions[:j], regions[j+1:]...)
+				merged = true
+				j--
+			}
+		}
+	}
+
+	// in reading order
+	sort.Slice(regions, func(i, j int) bool {
+		if regions[i].Y0 != regions[j].Y0 {
+			return regions[i].Y0 < regions[j].Y0
+		}
+		return regions[i].X0 < regions[j].X0
+	})
+	return regions
+}
+
+// hasInk tells whether the page has strokes, eraser strokes left out
+func (page *Page) hasInk() bool {
+	for i := range page.Strokes {
+		if page.Strokes[i].Tool != ToolEraser && len(page.Strokes[i].Points) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// imageInkRegions returns the ink regions of the page in the pixels of its
+// image rendered with the options, whose bounds are bounds. It returns nil
+// to recognise the whole page when the regions cover most of it.
+func (page *Page) imageInkRegions(opts ExportOptions, bounds image.Rectangle) []image.Rectangle {
+	region, _, dpi := page.renderRegion(opts)
+	scale := dpi / deviceDPI
+
+	var rects []image.Rectangle
+	area := 0
+	for _, r := range page.inkRegions() {
+		rect := image.Rect(
+			int(math.Floor((r.X0-region.X0)*scale)), int(math.Floor((r.Y0-region.Y0)*scale)),
+			int(math.Ceil((r.X1-region.X0)*scale)), int(math.Ceil((r.Y1-region.Y0)*scale)),
+		).Intersect(bounds)
+		if rect.Empty() {
+			continue
+		}
+		rects = append(rects, rect)
+		area += rect.Dx() * rect.Dy()
+	}
+	if float64(area) > ocrRegionMaxCoverage*float64(bounds.Dx()*bounds.Dy()) {
+		return nil
+	}
+	return rects
+}
+
+// ocrRegions recognises the regions of a page separately, each copied to
+// an image of its own, and returns their words in the pixels of the page
+func ocrRegions(goCtx context.Context, engine OCREngine, page RenderedPage) (PageOCR, error) {
+	bounds := page.Image.Bounds()
+	result := PageOCR{PageNumber: page.Number, ImgW: bounds.Dx(), ImgH: bounds.Dy()}
+	// the lines are numbered across the regions
+	lines := 0
+	for i, r := range page.Regions {
+		img := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(img, img.Bounds(), page.Image, r.Min, draw.Src)
+
+		ocr, err := engine.Recognize(goCtx, RenderedPage{Number: page.Number, ID: page.ID, Image: img})
+		if err != nil {
+			return PageOCR{}, fmt.Errorf("region %d: %v", i+1, err)
+		}
+		dx, dy := r.Min.X-bounds.Min.X, r.Min.Y-bounds.Min.Y
+		last := lines
+		for _, w := range ocr.Words {
+			w.X1, w.X2 = w.X1+dx, w.X2+dx
+			w.Y1, w.Y2 = w.Y1+dy, w.Y2+dy
+			if w.Baseline != 0 {
+				w.Baseline += float64(dy)
+			}
+			if w.Line != 0 {
+				w.Line += lines
+				last = max(last, w.Line)
+			}
+			result.Words = append(result.Words, w)
+		}
+		lines = last
+	}
+	return result, nil
+}
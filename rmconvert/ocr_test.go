@@ -2,16 +2,27 @@ package rmconvert
 
 import (
 	"archive/zip"
+	"context"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/tdewolff/canvas"
 )
 
 // TestOCRFunctionality validates that OCR pipeline works (tesseract runs, hOCR parsing)
-// Note: Text layer embedding to PDF has a known bug with pdfcpu that needs investigation
 func TestOCRFunctionality(t *testing.T) {
 	// Check if tesseract is available
 	if _, err := exec.LookPath("tesseract"); err != nil {
@@ -53,7 +64,7 @@ func TestOCRFunctionality(t *testing.T) {
 	}
 
 	// Run OCR on the PNG
-	ocr, err := ocrOnePage("tesseract", "eng", 6, tempDir, pngPath, 1)
+	ocr, err := ocrOnePage(context.Background(), "tesseract", "eng", 6, tempDir, pngPath, 1)
 	if err != nil {
 		t.Fatalf("OCR failed: %v", err)
 	}
@@ -69,7 +80,15 @@ func TestOCRFunctionality(t *testing.T) {
 	}
 
 	// Test that we can build the invisible text stream
-	stream := buildInvisibleTextStream(ocr, 792.0, 72.0/150.0)
+	var text strings.Builder
+	for _, word := range ocr.Words {
+		text.WriteString(word.Text)
+	}
+	subset, err := TextFont.subset(canvas.FontRegular, text.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := buildInvisibleTextStream(ocr, 792.0, 72.0/150.0, 72.0/150.0, subset, "F0")
 	if len(stream) > 0 {
 		t.Logf("Successfully built text stream (%d bytes)", len(stream))
 	}
@@ -88,7 +107,7 @@ func TestOCRFallback(t *testing.T) {
 	}
 
 	// Convert with invalid tesseract path (should fall back)
-	err = ConvertRmdocToSearchablePDF(rmdocPath, pdfPath, 150, "invalid_tesseract_path", "eng", 6)
+	err = ConvertRmdocToSearchablePDF(context.Background(), rmdocPath, pdfPath, 150, "invalid_tesseract_path", "eng", 6)
 	if err != nil {
 		t.Fatalf("Conversion with fallback failed: %v", err)
 	}
@@ -99,6 +118,431 @@ func TestOCRFallback(t *testing.T) {
 	}
 }
 
+// TestAddOCRTextToPDF validates that the text layer embeds a subset of the
+// font, with the characters of the words for copy and search
+func TestAddOCRTextToPDF(t *testing.T) {
+	strokes, err := os.ReadFile(filepath.Join("..", "encoding", "rm", "test_v5.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}]}}`),
+		"doc/p1.rm":   strokes,
+	})
+	pdfPath := filepath.Join(t.TempDir(), "out.pdf")
+	if err := ConvertRmdocToImagePDF(context.Background(), rmdocPath, pdfPath, 72); err != nil {
+		t.Fatal(err)
+	}
+
+	ocr := []PageOCR{{PageNumber: 1, ImgW: 447, ImgH: 596, Words: []Word{
+		{Text: "café", X1: 10, Y1: 10, X2: 60, Y2: 30},
+		{Text: "Привет", X1: 70, Y1: 10, X2: 150, Y2: 30},
+	}}}
+	if err := addOCRTextToPDF(pdfPath, ocr, 72); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.ValidateFile(pdfPath, nil); err != nil {
+		t.Fatalf("invalid PDF: %v", err)
+	}
+
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := ctx.XRefTable
+	pageDict, _, _, err := x.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := x.DereferenceDict(pageDict["Resources"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fonts, err := x.DereferenceDict(resources["Font"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	font, err := x.DereferenceDict(fonts["F0"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := font.NameEntry("BaseFont"); name == nil || !strings.HasSuffix(*name, "+DejaVuSansCondensed") {
+		t.Errorf("expected a subset of DejaVu Sans Condensed, got %v", font["BaseFont"])
+	}
+	toUnicode, _, err := x.DereferenceStreamDict(font["ToUnicode"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := toUnicode.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	// П and é are mapped back to their characters
+	for _, code := range []string{"<041F>", "<00E9>"} {
+		if !strings.Contains(string(toUnicode.Content), code) {
+			t.Errorf("expected %s in the ToUnicode map:\n%s", code, toUnicode.Content)
+		}
+	}
+}
+
+// fakeEngine recognises the same word on every page
+type fakeEngine struct {
+	pages []int
+}
+
+func (e *fakeEngine) Recognize(_ context.Context, page RenderedPage) (PageOCR, error) {
+	e.pages = append(e.pages, page.Number)
+	b := page.Image.Bounds()
+	return PageOCR{PageNumber: page.Number, ImgW: b.Dx(), ImgH: b.Dy(), Words: []Word{
+		{Text: "pipeline", X1: 10, Y1: 10, X2: 80, Y2: 30, Confidence: 90},
+	}}, nil
+}
+
+// TestPipeline validates that the stages run with another OCR engine
+func TestPipeline(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	engine := &fakeEngine{}
+	p := NewPipeline(ExportOptions{DPI: 72})
+	p.Engine = engine
+
+	pdfPath := filepath.Join(tempDir, "out.pdf")
+	results, err := p.SearchablePDF(context.Background(), doc, pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(engine.pages) != 1 || engine.pages[0] != 1 {
+		t.Fatalf("unexpected results %+v for pages %v", results, engine.pages)
+	}
+	if err := api.ValidateFile(pdfPath, nil); err != nil {
+		t.Fatalf("invalid PDF: %v", err)
+	}
+	ctx, err := api.ReadContextFile(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageDict, _, _, err := ctx.XRefTable.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, ok := pageDict["Contents"].(types.Array); !ok || len(contents) != 2 {
+		t.Errorf("expected the text layer after the image, got %v", pageDict["Contents"])
+	}
+
+	// the text alone, without a PDF
+	results, err = p.Text(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := ocrText(results); len(text) != 1 || text[0].Text != "pipeline" {
+		t.Errorf("unexpected text %+v", text)
+	}
+
+	// a PDF of images gets a text layer, the image of the page is OCR'd
+	imagePDF := filepath.Join(tempDir, "image.pdf")
+	if err := ConvertRmdocToImagePDF(context.Background(), rmdocPath, imagePDF, 72); err != nil {
+		t.Fatal(err)
+	}
+	outPath := filepath.Join(tempDir, "searchable.pdf")
+	results, err = p.OCRFile(context.Background(), imagePDF, outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ImgW == 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if err := api.ValidateFile(outPath, nil); err != nil {
+		t.Fatalf("invalid PDF: %v", err)
+	}
+}
+
+// TestOCRRegions validates that the strokes are recognised by area, the
+// words placed back on the page
+func TestOCRRegions(t *testing.T) {
+	line := func(x0, y0, x1, y1 float32) Stroke {
+		return Stroke{Tool: ToolFineliner, Width: 2, Points: []Point{{X: x0, Y: y0}, {X: x1, Y: y1}}}
+	}
+	page := &Page{Width: 1404, Height: 1872, Strokes: []Stroke{
+		line(200, 1000, 400, 1000),
+		// close enough to be merged with the first stroke
+		line(420, 1010, 600, 1010),
+		line(200, 200, 400, 200),
+		{Tool: ToolEraser, Width: 2, Points: []Point{{X: 1000, Y: 1500}}},
+	}}
+	regions := page.inkRegions()
+	if len(regions) != 2 {
+		t.Fatalf("expected 2 regions, got %v", regions)
+	}
+	if regions[0].Y0 > 200 || regions[1].X1 < 600 {
+		t.Errorf("unexpected regions %v", regions)
+	}
+
+	opts := ExportOptions{DPI: 226, OCRRegions: true}.withDefaults()
+	bounds := image.Rect(0, 0, 1404, 1872)
+	rects := page.imageInkRegions(opts, bounds)
+	if len(rects) != 2 || !rects[0].In(bounds) {
+		t.Fatalf("unexpected image regions %v", rects)
+	}
+
+	engine := &fakeEngine{}
+	ocr, err := ocrRegions(context.Background(), engine, RenderedPage{Number: 1, Image: image.NewRGBA(bounds), Regions: rects})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocr.Words) != 2 || ocr.ImgW != 1404 {
+		t.Fatalf("unexpected result %+v", ocr)
+	}
+	if w := ocr.Words[1]; w.X1 != rects[1].Min.X+10 || w.Y1 != rects[1].Min.Y+10 {
+		t.Errorf("word %+v not moved to its region %v", w, rects[1])
+	}
+
+	// a page full of strokes is recognised at once
+	for y := float32(0); y < 1872; y += 50 {
+		page.Strokes = append(page.Strokes, line(0, y, 1404, y))
+	}
+	if rects := page.imageInkRegions(opts, bounds); rects != nil {
+		t.Errorf("expected the whole page, got %v", rects)
+	}
+}
+
+// TestOCROrientation validates that the words recognised on a turned page
+// are placed back on the page, written in their direction
+func TestOCROrientation(t *testing.T) {
+	rotation, confidence, err := parseOSD([]byte("Page number: 0\nOrientation in degrees: 90\nRotate: 270\nOrientation confidence: 5.31\nScript: Latin\n"))
+	if err != nil || rotation != 270 || confidence != 5.31 {
+		t.Errorf("got rotation %d, confidence %v: %v", rotation, confidence, err)
+	}
+	if _, _, err := parseOSD([]byte("Too few characters. Skipping this page\n")); err == nil {
+		t.Error("expected an error without a rotation")
+	}
+
+	// a word at the top left of a 100x200 page written top to bottom is
+	// at the bottom left of the page turned 270 degrees
+	src := image.NewRGBA(image.Rect(0, 0, 100, 200))
+	src.Set(5, 10, color.Black)
+	rotated := rotateImage(src, 270)
+	if b := rotated.Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Fatalf("unexpected rotated size %v", b)
+	}
+	if _, _, _, a := rotated.At(10, 94).RGBA(); a == 0 {
+		t.Error("pixel not turned")
+	}
+
+	ocr := PageOCR{PageNumber: 1, ImgW: 200, ImgH: 100, Words: []Word{{Text: "down", X1: 5, Y1: 80, X2: 60, Y2: 98}}}
+	ocr.unrotate(270, 100, 200)
+	w := ocr.Words[0]
+	if ocr.ImgW != 100 || w.X1 != 2 || w.X2 != 20 || w.Y1 != 5 || w.Y2 != 60 || w.Rotation != 270 {
+		t.Fatalf("unexpected word %+v on %dx%d", w, ocr.ImgW, ocr.ImgH)
+	}
+
+	subset, err := TextFont.subset(canvas.FontRegular, "down", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := string(buildInvisibleTextStream(ocr, 200, 1, 1, subset, "F0"))
+	// from the top of the box, downward
+	if !strings.Contains(stream, "0 -1 1 0 2.00 195.00 Tm") {
+		t.Errorf("expected text written downward:\n%s", stream)
+	}
+}
+
+// TestOCRLines validates that the words of a line are written as a
+// sentence in the text layer
+func TestOCRLines(t *testing.T) {
+	hocr := `<html><body><div class="ocr_page" title="bbox 0 0 400 300">
+<span class="ocr_line" title="bbox 10 10 300 40; baseline 0.01 -5; x_size 24; x_descenders 5; x_ascenders 6">
+<span class="ocrx_word" title="bbox 10 10 80 40; x_wconf 91">hello</span>
+<span class="ocrx_word" title="bbox 100 12 200 40; x_wconf 88">world</span>
+</span>
+<span class="ocr_line" title="bbox 10 60 100 90">
+<span class="ocrx_word" title="bbox 10 60 100 90; x_wconf 75">again</span>
+</span>
+</div></body></html>`
+	hocrPath := filepath.Join(t.TempDir(), "page.hocr")
+	if err := os.WriteFile(hocrPath, []byte(hocr), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(hocrPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	words, imgW, imgH, err := parseHOCRWords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 3 || imgW != 400 || imgH != 300 {
+		t.Fatalf("unexpected words %+v on %dx%d", words, imgW, imgH)
+	}
+	if words[0].Line != 1 || words[1].Line != 1 || words[2].Line != 2 {
+		t.Errorf("unexpected lines %d %d %d", words[0].Line, words[1].Line, words[2].Line)
+	}
+	if words[0].Baseline != 35 || math.Abs(words[1].Baseline-35.9) > 1e-9 || words[0].XSize != 24 {
+		t.Errorf("unexpected baselines %+v", words[:2])
+	}
+	if words[2].Baseline != 0 || words[2].XSize != 0 {
+		t.Errorf("the line without baseline should have none, got %+v", words[2])
+	}
+
+	subset, err := TextFont.subset(canvas.FontRegular, " helloworldagain", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := string(buildInvisibleTextStream(PageOCR{PageNumber: 1, ImgW: 400, ImgH: 300, Words: words}, 300, 1, 1, subset, "F0"))
+	// one text position per line, the space between the words of the first
+	if n := strings.Count(stream, " Tm\n"); n != 2 {
+		t.Errorf("expected 2 lines, got %d:\n%s", n, stream)
+	}
+	if n := strings.Count(stream, " Tj\n"); n != 4 {
+		t.Errorf("expected 3 words and a space, got %d:\n%s", n, stream)
+	}
+	// the first line on its tilted baseline, sized by x_size
+	if !strings.Contains(stream, "1.0000 -0.0100 0.0100 1.0000 10.00 265.00 Tm\n") || !strings.Contains(stream, "/F0 24.00 Tf\n") {
+		t.Errorf("expected the first line on its baseline:\n%s", stream)
+	}
+	if !strings.Contains(stream, "1 0 0 1 10.00 210.00 Tm\n") {
+		t.Errorf("expected the second line at the bottom of its box:\n%s", stream)
+	}
+}
+
+func TestOCRPreprocess(t *testing.T) {
+	// a light gray line going down to the right by 3 degrees, and a speck
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	light := color.RGBA{R: 200, G: 200, B: 200, A: 255}
+	for x := 20; x < 380; x++ {
+		y := 100 + int(float64(x-200)*math.Tan(3*math.Pi/180))
+		for dy := 0; dy < 3; dy++ {
+			img.Set(x, y+dy, light)
+		}
+	}
+	img.Set(50, 20, light)
+
+	gray, angle := Preprocess{Deskew: true, Binarize: true, Despeckle: true}.Apply(img)
+	if math.Abs(angle-3) > 0.5 {
+		t.Errorf("expected a skew of 3 degrees, got %v", angle)
+	}
+	dark := 0
+	for _, v := range gray.Pix {
+		if v != 0 && v != 255 {
+			t.Fatalf("expected a black and white image, got %d", v)
+		}
+		if v == 0 {
+			dark++
+		}
+	}
+	if dark < 360*3/2 {
+		t.Errorf("expected the light line to be black, got %d dark pixels", dark)
+	}
+	if gray.GrayAt(50, 20).Y != 255 {
+		t.Errorf("expected the speck to be removed")
+	}
+
+	// the level line maps back to the tilted one
+	ocr := PageOCR{ImgW: 400, ImgH: 200, Words: []Word{{Text: "line", X1: 300, Y1: 95, X2: 380, Y2: 105}}}
+	ocr.unskew(3, 400, 200)
+	if w := ocr.Words[0]; w.Y2 <= 105 || w.Y1 <= 95 {
+		t.Errorf("expected the word lower on the tilted line, got %+v", w)
+	}
+
+	engine := &fakeEngine{}
+	p := NewPipeline(ExportOptions{OCRBinarize: true})
+	p.Engine = engine
+	if _, err := p.OCR(context.Background(), RenderedPage{Number: 1, Image: img}); err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.pages) != 1 {
+		t.Errorf("expected the engine to recognise the page")
+	}
+}
+
+// hungEngine never finishes recognising a page before its context is done
+type hungEngine struct{}
+
+func (hungEngine) Recognize(goCtx context.Context, _ RenderedPage) (PageOCR, error) {
+	<-goCtx.Done()
+	return PageOCR{}, goCtx.Err()
+}
+
+func TestOCRTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	p := NewPipeline(ExportOptions{DPI: 30, OCRTimeout: 10 * time.Millisecond})
+	p.Engine = hungEngine{}
+	if _, err := p.OCR(context.Background(), RenderedPage{Number: 1, Image: image.NewGray(image.Rect(0, 0, 10, 10))}); !errors.Is(err, ErrOCRTimeout) {
+		t.Errorf("expected ErrOCRTimeout, got %v", err)
+	}
+
+	// the run goes on without the words of the page
+	results, err := p.Text(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := TimedOutPages(results); len(pages) != 1 || pages[0] != 1 {
+		t.Errorf("expected page 1 to time out, got %+v", results)
+	}
+
+	// the cancellation of the run isn't a timeout
+	goCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Text(goCtx, doc); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the run to be cancelled, got %v", err)
+	}
+}
+
+func TestCheckTesseractLanguages(t *testing.T) {
+	langs, dir := parseTesseractLanguages("List of available languages in \"/usr/share/tessdata/\" (3):\neng\nfra\nosd\n")
+	if strings.Join(langs, ",") != "eng,fra,osd" || dir != "/usr/share/tessdata/" {
+		t.Errorf("unexpected languages %v in %q", langs, dir)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as tesseract")
+	}
+	tessPath := filepath.Join(t.TempDir(), "tesseract")
+	script := "#!/bin/sh\necho 'List of available languages in \"/tessdata/\" (2):'\necho eng\necho osd\n"
+	if err := os.WriteFile(tessPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	goCtx := context.Background()
+	if err := CheckTesseractLanguages(goCtx, ExportOptions{TesseractPath: tessPath, Language: "eng", OCRRotate: true}); err != nil {
+		t.Errorf("expected the languages to be installed, got %v", err)
+	}
+	err := CheckTesseractLanguages(goCtx, ExportOptions{TesseractPath: tessPath, Language: "eng+fra+chi_sim"})
+	var missing *MissingLanguagesError
+	if !errors.As(err, &missing) || strings.Join(missing.Missing, ",") != "fra,chi_sim" || missing.Dir != "/tessdata/" {
+		t.Fatalf("expected fra and chi_sim to be missing, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "tesseract-ocr-fra tesseract-ocr-chi-sim") {
+		t.Errorf("expected the packages to install, got %v", err)
+	}
+
+	err = CheckTesseractLanguages(goCtx, ExportOptions{TesseractPath: filepath.Join(t.TempDir(), "missing")})
+	if !errors.Is(err, ErrTesseractNotFound) {
+		t.Errorf("expected tesseract not to be found, got %v", err)
+	}
+}
+
 // createTestRmdoc creates a minimal .rmdoc file for testing
 func createTestRmdoc(destPath string) error {
 	// Create a ZIP file
@@ -0,0 +1,53 @@
+package rmconvert
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// ParseBackground parses the value of the -background flag: transparent,
+// a color name or #rrggbb. It returns nil for an empty value, the pages
+// being white, or black when inverted.
+func ParseBackground(s string) (color.Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return nil, nil
+	}
+	if s == "transparent" {
+		return color.RGBA{}, nil
+	}
+	c, ok := lookupColor(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid background %q, use transparent, #rrggbb, black, gray or white", s)
+	}
+	return c, nil
+}
+
+// backgroundColor returns the color the pages are filled with, white or
+// black when inverted if background is nil
+func backgroundColor(background color.Color, invert bool) color.Color {
+	switch {
+	case background != nil:
+		return background
+	case invert:
+		return color.Black
+	default:
+		return color.White
+	}
+}
+
+// isTransparent tells whether a background color is transparent
+func isTransparent(c color.Color) bool {
+	if c == nil {
+		return false
+	}
+	_, _, _, a := c.RGBA()
+	return a == 0
+}
+
+// svgColor returns the SVG notation of an opaque color
+func svgColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
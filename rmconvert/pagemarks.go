@@ -0,0 +1,148 @@
+package rmconvert
+
+import (
+	"fmt"
+	"image/color"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/tdewolff/canvas"
+)
+
+// The size of the header, footer and page number and their distance from
+// the edges of the page, in device pixels
+const (
+	pageMarkSize   = 24
+	pageMarkMargin = 40
+)
+
+// pageMarkColor is the color of the header, footer and page number
+var pageMarkColor = color.RGBA{0x77, 0x77, 0x77, 0xff}
+
+// pageMarks are the texts drawn on a page: the header at the top, the footer
+// and the page number at the bottom
+type pageMarks struct {
+	header, footer, number string
+}
+
+// pageMark is a text of the marks of a page, at the start, middle or end of
+// its baseline
+type pageMark struct {
+	X, Y  float64
+	Text  string
+	Align canvas.TextAlign
+}
+
+// ForDocument returns the options of a document, with {path}, {name} and
+// {date} of the header, footer and watermark replaced by its path in the
+// cloud, its name and the date it was last modified
+func (o ExportOptions) ForDocument(docPath string, modified time.Time) ExportOptions {
+	o.Header = expandDocument(o.Header, docPath, modified, false)
+	o.Footer = expandDocument(o.Footer, docPath, modified, false)
+	if o.Watermark != nil {
+		o.Watermark = o.Watermark.Expand(docPath, modified)
+	}
+	return o
+}
+
+// expandDocument replaces the placeholders of a document in s. escape
+// doubles the % of the values, for the texts of pdfcpu stamps.
+func expandDocument(s, docPath string, modified time.Time, escape bool) string {
+	value := func(v string) string {
+		if escape {
+			return strings.ReplaceAll(v, "%", "%%")
+		}
+		return v
+	}
+	return strings.NewReplacer(
+		"{path}", value(docPath),
+		"{name}", value(path.Base(docPath)),
+		"{date}", modified.Format("2006-01-02"),
+	).Replace(s)
+}
+
+// forPage returns the options of the page number of count pages, with the
+// page marks to draw
+func (o ExportOptions) forPage(number, count int) ExportOptions {
+	r := strings.NewReplacer("{page}", strconv.Itoa(number), "{pages}", strconv.Itoa(count))
+	o.marks = pageMarks{header: r.Replace(o.Header), footer: r.Replace(o.Footer)}
+	if o.PageNumbers {
+		o.marks.number = fmt.Sprintf("%d / %d", number, count)
+	}
+	return o
+}
+
+// layout places the marks on a region of a page, in device pixels
+func (m pageMarks) layout(r canvas.Rect) []pageMark {
+	var marks []pageMark
+	if m.header != "" {
+		marks = append(marks, pageMark{X: (r.X0 + r.X1) / 2, Y: r.Y0 + pageMarkMargin + pageMarkSize, Text: m.header, Align: canvas.Center})
+	}
+	if m.footer != "" {
+		marks = append(marks, pageMark{X: (r.X0 + r.X1) / 2, Y: r.Y1 - pageMarkMargin, Text: m.footer, Align: canvas.Center})
+	}
+	if m.number != "" {
+		marks = append(marks, pageMark{X: r.X1 - pageMarkMargin, Y: r.Y1 - pageMarkMargin, Text: m.number, Align: canvas.Right})
+	}
+	return marks
+}
+
+// drawPageMarks draws the marks over a canvas whose units are scale times
+// device pixels
+func drawPageMarks(c *canvas.Canvas, marks pageMarks, scale float64, invert bool) {
+	fill := pageMarkColor
+	if invert {
+		fill = invertColor(fill)
+	}
+	ctx := canvas.NewContext(c)
+	ctx.SetCoordSystem(canvas.CartesianIV)
+	face := textFace(pageMarkSize*scale, false, false, fill)
+	for _, mark := range marks.layout(canvas.Rect{X1: c.W / scale, Y1: c.H / scale}) {
+		ctx.DrawText(mark.X*scale, mark.Y*scale, canvas.NewTextLine(face, mark.Text, mark.Align))
+	}
+}
+
+// stampPageMarks stamps the header, footer and page numbers of the options
+// on the pages of a PDF file, for the annotated PDFs whose pages aren't
+// drawn
+func stampPageMarks(pdfPath string, opts ExportOptions) error {
+	// pdfcpu replaces %p and %P by the page number and count
+	pdfText := func(s string) string {
+		s = strings.ReplaceAll(s, "%", "%%")
+		return strings.NewReplacer("{page}", "%p", "{pages}", "%P").Replace(s)
+	}
+	const style = "font:Helvetica, points:9, scalefactor:1 abs, rotation:0, fillcolor:#777777"
+	number := ""
+	if opts.PageNumbers {
+		number = "%p / %P"
+	}
+	var stamps []*model.Watermark
+	for _, mark := range []struct{ text, position string }{
+		{pdfText(opts.Header), "position:tc, offset:0 -14"},
+		{pdfText(opts.Footer), "position:bc, offset:0 14"},
+		{number, "position:br, offset:-14 14"},
+	} {
+		if mark.text == "" {
+			continue
+		}
+		wm, err := api.TextWatermark(mark.text, style+", "+mark.position, true, false, types.POINTS)
+		if err != nil {
+			return err
+		}
+		stamps = append(stamps, wm)
+	}
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	for _, wm := range stamps {
+		if err := api.AddWatermarksFile(pdfPath, "", nil, wm, conf); err != nil {
+			return fmt.Errorf("failed to stamp the page marks: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package rmconvert
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tdewolff/canvas"
+)
+
+// sheetHeight is the height of the screen in device pixels. The pages of
+// notebooks scroll down past it, long typed text above all; their content
+// is exported on several pages of this height, see Page.Sheets.
+const sheetHeight = 1872
+
+// sheetSeparator separates the page ID from the number of the sheet in
+// the IDs of RmDoc.sheetIDs
+const sheetSeparator = "#"
+
+// typedLine is a line of typed text laid out by layoutTypedText, with the
+// extent of its letters in device pixels
+type typedLine struct {
+	spans       []typedTextSpan
+	top, bottom float64
+}
+
+// typedText returns the typed text of the page laid out, the lines of the
+// sheet for the pages of Sheets
+func (page *Page) typedText() []typedTextSpan {
+	if page.sheetSpans != nil {
+		return page.sheetSpans
+	}
+	return layoutTypedText(page.TextBox, float64(deviceWidth(page)))
+}
+
+// typedLines groups the typed text of the page into lines, from the top
+func (page *Page) typedLines() []typedLine {
+	spans := page.typedText()
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Y != spans[j].Y {
+			return spans[i].Y < spans[j].Y
+		}
+		return spans[i].X < spans[j].X
+	})
+
+	var lines []typedLine
+	for _, span := range spans {
+		metrics := textFace(span.Size, span.Bold, span.Italic, canvas.Black).Metrics()
+		top, bottom := span.Y-metrics.Ascent, span.Y+metrics.Descent
+		if n := len(lines); n > 0 && lines[n-1].spans[0].Y == span.Y {
+			line := &lines[n-1]
+			line.spans = append(line.spans, span)
+			line.top, line.bottom = min(line.top, top), max(line.bottom, bottom)
+			continue
+		}
+		lines = append(lines, typedLine{spans: []typedTextSpan{span}, top: top, bottom: bottom})
+	}
+	return lines
+}
+
+// sheetCuts returns the positions in device pixels where the page is cut
+// into sheets, from 0 to the bottom of its content: every sheetHeight,
+// moved up to the top of the line of typed text it would cut through
+func (page *Page) sheetCuts() []float64 {
+	bounds, ok := page.contentBounds()
+	if !ok || bounds.Y1 <= sheetHeight {
+		return []float64{0, sheetHeight}
+	}
+	lines := page.typedLines()
+
+	cuts := []float64{0}
+	for start := 0.0; bounds.Y1 > start+sheetHeight; {
+		end := start + sheetHeight
+		for _, line := range lines {
+			// a line taller than a sheet is cut anyway
+			if cut := math.Floor(line.top); line.top < end && line.bottom > end && cut > start {
+				end = cut
+			}
+		}
+		cuts = append(cuts, end)
+		start = end
+	}
+	return append(cuts, cuts[len(cuts)-1]+sheetHeight)
+}
+
+// Sheets returns the page split into pages of the screen height when its
+// content goes below the screen, the page alone otherwise. The lines of
+// typed text aren't split, the strokes crossing two sheets are drawn on
+// both.
+func (page *Page) Sheets() []*Page {
+	cuts := page.sheetCuts()
+	if len(cuts) <= 2 {
+		return []*Page{page}
+	}
+	lines := page.typedLines()
+
+	sheets := make([]*Page, len(cuts)-1)
+	for i := range sheets {
+		top, end := cuts[i], cuts[i+1]
+		sheet := &Page{
+			Width:    page.Width,
+			Height:   page.Height,
+			Layers:   page.Layers,
+			Template: page.Template,
+			top:      top,
+			// no typed text laid out again
+			sheetSpans: []typedTextSpan{},
+		}
+		if i == 0 {
+			sheet.Highlights = page.Highlights
+		}
+
+		for _, stroke := range page.Strokes {
+			if len(stroke.Points) == 0 {
+				continue
+			}
+			y0, y1 := math.Inf(1), math.Inf(-1)
+			for _, p := range stroke.Points {
+				y0, y1 = min(y0, float64(p.Y)), max(y1, float64(p.Y))
+			}
+			hw := float64(GetToolProperties(stroke.Tool, stroke.Color, stroke.Width).StrokeWidth) / 2
+			if y1+hw < top || y0-hw >= end {
+				continue
+			}
+			points := make([]Point, len(stroke.Points))
+			for j, p := range stroke.Points {
+				p.Y -= float32(top)
+				points[j] = p
+			}
+			stroke.Points = points
+			sheet.Strokes = append(sheet.Strokes, stroke)
+		}
+
+		var texts []string
+		for _, line := range lines {
+			// the first and last sheets take the lines above and below
+			if (i > 0 && line.top < top) || (i < len(sheets)-1 && line.top >= end) {
+				continue
+			}
+			var text strings.Builder
+			for _, span := range line.spans {
+				span.Y -= top
+				sheet.sheetSpans = append(sheet.sheetSpans, span)
+				text.WriteString(span.Text)
+			}
+			texts = append(texts, strings.TrimSpace(text.String()))
+		}
+		sheet.Text = strings.Join(texts, "\n")
+		sheets[i] = sheet
+	}
+	return sheets
+}
+
+// sheetIDs returns the page IDs followed by the IDs of their other sheets,
+// <page ID>#2 and so on, for the pages longer than the screen. Page
+// returns the sheets of these IDs.
+func (d *RmDoc) sheetIDs(ids []string) []string {
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, id)
+		if !d.HasPage(id) {
+			continue
+		}
+		page, err := d.Page(id)
+		if err != nil {
+			continue
+		}
+		for n := 2; n < len(page.sheetCuts()); n++ {
+			result = append(result, id+sheetSeparator+strconv.Itoa(n))
+		}
+	}
+	return result
+}
+
+// splitSheetID returns the page ID and the 1-based sheet number of an ID of
+// sheetIDs
+func splitSheetID(id string) (string, int) {
+	pageID, sheet, ok := strings.Cut(id, sheetSeparator)
+	if !ok {
+		return id, 1
+	}
+	n, err := strconv.Atoi(sheet)
+	if err != nil || n < 1 {
+		return id, 1
+	}
+	return pageID, n
+}
+
+// sheetPages returns the pages of Pages split into sheets, with the IDs
+// of sheetIDs
+func sheetPages(pageOrder []string, pages map[string]*Page) ([]string, map[string]*Page) {
+	order := make([]string, 0, len(pageOrder))
+	result := make(map[string]*Page, len(pages))
+	for _, id := range pageOrder {
+		for n, sheet := range pages[id].Sheets() {
+			sheetID := id
+			if n > 0 {
+				sheetID += sheetSeparator + strconv.Itoa(n+1)
+			}
+			order = append(order, sheetID)
+			result[sheetID] = sheet
+		}
+	}
+	return order, result
+}
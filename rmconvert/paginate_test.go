@@ -0,0 +1,125 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// longText returns typed text of n paragraphs, from the top of the page
+func longText(n int) *rm.Text {
+	text := &rm.Text{X: -468, Y: 94, Width: 936}
+	for i := 0; i < n; i++ {
+		text.Paragraphs = append(text.Paragraphs, rm.TextParagraph{Style: rm.StylePlain, Spans: []rm.TextSpan{{Text: fmt.Sprintf("Paragraph %d", i+1)}}})
+	}
+	return text
+}
+
+func TestSheets(t *testing.T) {
+	page := &Page{Width: 1404, Height: 1872, TextBox: longText(60), Strokes: []Stroke{
+		{Tool: ToolBallpoint, Width: 2, Points: []Point{{X: 100, Y: 1800}, {X: 200, Y: 1900}}},
+		{Tool: ToolBallpoint, Width: 2, Points: []Point{{X: 100, Y: 100}, {X: 200, Y: 200}}},
+	}}
+	lines := page.typedLines()
+	sheets := page.Sheets()
+	// 60 lines of 70 pixels
+	if len(sheets) != 3 {
+		t.Fatalf("expected 3 sheets, got %d", len(sheets))
+	}
+
+	count := 0
+	for i, sheet := range sheets {
+		for _, line := range sheet.typedLines() {
+			if line.top < 0 || line.bottom > sheetHeight {
+				t.Errorf("sheet %d: line %q cut at %v-%v", i+1, line.spans[0].Text, line.top, line.bottom)
+			}
+			count++
+		}
+		if sheet.TextBox != nil || sheet.Text == "" {
+			t.Errorf("sheet %d: unexpected text %q", i+1, sheet.Text)
+		}
+	}
+	if count != len(lines) {
+		t.Errorf("expected the %d lines on the sheets, got %d", len(lines), count)
+	}
+	if !strings.HasPrefix(sheets[0].Text, "Paragraph 1\nParagraph 2\n") || !strings.HasSuffix(sheets[2].Text, "Paragraph 60") {
+		t.Errorf("unexpected text %q ... %q", sheets[0].Text, sheets[2].Text)
+	}
+
+	// the stroke across the cut is on both sheets, moved up on the second
+	if len(sheets[0].Strokes) != 2 || len(sheets[1].Strokes) != 1 || len(sheets[2].Strokes) != 0 {
+		t.Fatalf("unexpected strokes %d, %d, %d", len(sheets[0].Strokes), len(sheets[1].Strokes), len(sheets[2].Strokes))
+	}
+	if y := sheets[1].Strokes[0].Points[1].Y; y != 1900-float32(sheets[1].top) || y <= 0 {
+		t.Errorf("unexpected point of the second sheet at %v", y)
+	}
+	if page.Strokes[0].Points[1].Y != 1900 {
+		t.Errorf("the strokes of the page were moved")
+	}
+
+	short := &Page{Width: 1404, Height: 1872, TextBox: longText(3)}
+	if sheets := short.Sheets(); len(sheets) != 1 || sheets[0] != short {
+		t.Errorf("expected the short page alone, got %d sheets", len(sheets))
+	}
+}
+
+// TestTypedPages validates that the pages without ink aren't recognised
+// and that long typed text is exported on several pages
+func TestTypedPages(t *testing.T) {
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}, {"id": "p2", "idx": {"value": "b"}}]}}`),
+		"doc/p1.rm":   encodeV6(nil, longText(60)),
+		"doc/p2.rm":   encodeV6(toolsPageLines([]rm.BrushType{4}, []rm.BrushColor{0}), nil),
+	})
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	if ids := doc.sheetIDs(doc.PageIDs()); strings.Join(ids, ",") != "p1,p1#2,p1#3,p2" {
+		t.Fatalf("unexpected sheets %v", ids)
+	}
+	sheet, err := doc.Page("p1#3")
+	if err != nil || !strings.HasSuffix(sheet.Text, "Paragraph 60") {
+		t.Fatalf("unexpected last sheet %v, %v", sheet, err)
+	}
+
+	engine := &fakeEngine{}
+	p := NewPipeline(ExportOptions{DPI: 72})
+	p.Engine = engine
+	pdfPath := filepath.Join(t.TempDir(), "out.pdf")
+	results, err := p.SearchablePDF(context.Background(), doc, pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || len(engine.pages) != 1 || engine.pages[0] != 4 {
+		t.Fatalf("expected the handwritten page only recognised, got pages %v", engine.pages)
+	}
+	if !results[0].Typed || len(results[0].Words) == 0 || results[0].Words[0].Text != "Paragraph" || results[3].Typed {
+		t.Errorf("expected the typed words of the first page, got %+v", results[0])
+	}
+	if text := ocrText(results); len(text) != 1 || text[0].Page != 4 {
+		t.Errorf("typed words returned as OCR text: %+v", text)
+	}
+	if n, err := api.PageCountFile(pdfPath); err != nil || n != 4 {
+		t.Errorf("expected 4 pages, got %d, %v", n, err)
+	}
+
+	exporter, err := LookupExporter("png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exporter.Export(context.Background(), rmdocPath, filepath.Join(t.TempDir(), "out.png"), ExportOptions{DPI: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 4 || len(result.Text) != 3 || result.Text[2].Page != 3 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
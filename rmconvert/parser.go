@@ -13,14 +13,14 @@ func ParseRMFile(filename string) (*Page, error) {
 	// Read file data
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Use the rm package to parse (supports v3, v5, and v6)
 	var rmData rm.Rm
 	err = rmData.UnmarshalBinary(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse rm file: %v", err)
+		return nil, fmt.Errorf("failed to parse rm file: %w", err)
 	}
 
 	// Convert to our Page format
@@ -30,38 +30,47 @@ func ParseRMFile(filename string) (*Page, error) {
 // convertRmToPage converts rm.Rm to our Page format
 func convertRmToPage(rmData *rm.Rm) *Page {
 	page := &Page{
-		Width:   1404,
-		Height:  1872,
-		Strokes: make([]Stroke, 0),
+		Width:  1404,
+		Height: 1872,
 	}
 
-	// Convert all layers and lines to strokes
+	lineCount := 0
 	for _, layer := range rmData.Layers {
+		lineCount += len(layer.Lines)
+	}
+	page.Strokes = make([]Stroke, 0, lineCount)
+
+	// Convert all layers and lines to strokes
+	for layerIndex, layer := range rmData.Layers {
+		name := layer.Name
+		if name == "" {
+			name = fmt.Sprintf("Layer %d", layerIndex+1)
+		}
+		page.Layers = append(page.Layers, Layer{Name: name, Hidden: layer.Hidden})
+
 		for _, line := range layer.Lines {
 			if len(line.Points) == 0 {
 				continue
 			}
 
-			stroke := Stroke{
+			// The points are shared with the parsed page, not copied
+			page.Strokes = append(page.Strokes, Stroke{
 				Tool:   mapBrushTypeToTool(line.BrushType),
 				Color:  mapBrushColorToColor(line.BrushColor),
 				Width:  float32(line.BrushSize),
-				Points: make([]Point, len(line.Points)),
-			}
+				Points: line.Points,
+				Layer:  layerIndex,
+			})
+		}
+	}
 
-			for i, p := range line.Points {
-				stroke.Points[i] = Point{
-					X:         p.X,
-					Y:         p.Y,
-					Speed:     p.Speed,
-					Direction: p.Direction,
-					Width:     p.Width,
-					Pressure:  p.Pressure,
-				}
-			}
+	if rmData.Text != nil {
+		page.Text = rmData.Text.Content
+		page.TextBox = rmData.Text
+	}
 
-			page.Strokes = append(page.Strokes, stroke)
-		}
+	for _, h := range rmData.Highlights {
+		page.Highlights = append(page.Highlights, PageHighlight{Text: h.Text, Color: HighlightColorName(h.Color)})
 	}
 
 	return page
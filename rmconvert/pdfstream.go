@@ -0,0 +1,216 @@
+package rmconvert
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// imagePDF writes a PDF of full-page images one page at a time, as the
+// pages are rendered, so that only the page being added is held in memory
+// whatever the number of pages. Like pdfcpu's ImportImagesFile, a page is
+// the size of its image, one point per pixel.
+type imagePDF struct {
+	file *os.File
+	w    *bufio.Writer
+	// n is the number of bytes written
+	n int64
+	// offsets are the offsets of the objects, of object i+1 at i
+	offsets []int64
+	// pages are the object numbers of the pages
+	pages []int
+}
+
+// The catalog and page tree are written last, once every page is known,
+// with these reserved object numbers
+const (
+	catalogObject = 1
+	pagesObject   = 2
+)
+
+// createImagePDF creates a PDF file to add pages to, with Close writing
+// its page tree
+func createImagePDF(path string) (*imagePDF, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &imagePDF{file: file, w: bufio.NewWriter(file), offsets: make([]int64, pagesObject)}
+	// the binary comment marks the file as binary for transfer tools
+	p.printf("%%PDF-1.7\n%%\xe2\xe3\xcf\xd3\n")
+	return p, nil
+}
+
+func (p *imagePDF) printf(format string, args ...any) {
+	n, _ := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+}
+
+func (p *imagePDF) write(b []byte) {
+	n, _ := p.w.Write(b)
+	p.n += int64(n)
+}
+
+// newObject returns the number of a new object
+func (p *imagePDF) newObject() int {
+	p.offsets = append(p.offsets, 0)
+	return len(p.offsets)
+}
+
+// beginObject records the offset of object num and starts it
+func (p *imagePDF) beginObject(num int) {
+	p.offsets[num-1] = p.n
+	p.printf("%d 0 obj\n", num)
+}
+
+// writeStream writes object num as a stream with the dictionary entries of
+// dict
+func (p *imagePDF) writeStream(num int, dict string, data []byte) {
+	p.beginObject(num)
+	p.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	p.write(data)
+	p.printf("\nendstream\nendobj\n")
+}
+
+// AddImage adds a page showing img. Grayscale images are embedded as such,
+// a third of the size of color ones.
+func (p *imagePDF) AddImage(img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return fmt.Errorf("empty image")
+	}
+
+	colorSpace := "/DeviceRGB"
+	if isGray(img) {
+		colorSpace = "/DeviceGray"
+	}
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	if err := writePixels(zw, img, colorSpace == "/DeviceGray"); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	imageNum := p.newObject()
+	p.writeStream(imageNum, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /FlateDecode", width, height, colorSpace), data.Bytes())
+	data.Reset()
+
+	contentNum := p.newObject()
+	p.writeStream(contentNum, "", fmt.Appendf(nil, "q %d 0 0 %d 0 0 cm /Im0 Do Q", width, height))
+
+	pageNum := p.newObject()
+	p.beginObject(pageNum)
+	p.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+		pagesObject, width, height, imageNum, contentNum)
+	p.pages = append(p.pages, pageNum)
+
+	return p.w.Flush()
+}
+
+// Abort closes the file of a PDF that won't be completed
+func (p *imagePDF) Abort() {
+	p.file.Close()
+}
+
+// Close writes the page tree, the cross-reference table and the trailer,
+// and closes the file
+func (p *imagePDF) Close() error {
+	defer p.file.Close()
+	if len(p.pages) == 0 {
+		return fmt.Errorf("no pages")
+	}
+
+	kids := make([]string, len(p.pages))
+	for i, num := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", num)
+	}
+	p.beginObject(pagesObject)
+	p.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(p.pages))
+	p.beginObject(catalogObject)
+	p.printf("<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", pagesObject)
+
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		p.printf("%010d 00000 n \n", offset)
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, catalogObject, xref)
+
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	return p.file.Close()
+}
+
+// isGray tells whether every pixel of an image is a shade of gray
+func isGray(img image.Image) bool {
+	switch img := img.(type) {
+	case *image.Gray:
+		return true
+	case *image.RGBA:
+		for i := 0; i < len(img.Pix); i += 4 {
+			if img.Pix[i] != img.Pix[i+1] || img.Pix[i] != img.Pix[i+2] {
+				return false
+			}
+		}
+		return true
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r != g || r != b {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writePixels writes the rows of an opaque image as 8 bit gray or RGB
+// samples
+func writePixels(w io.Writer, img image.Image, gray bool) error {
+	bounds := img.Bounds()
+	components := 3
+	if gray {
+		components = 1
+	}
+	row := make([]byte, bounds.Dx()*components)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		switch img := img.(type) {
+		case *image.Gray:
+			copy(row, img.Pix[img.PixOffset(bounds.Min.X, y):])
+		case *image.RGBA:
+			pix := img.Pix[img.PixOffset(bounds.Min.X, y):]
+			for x := range bounds.Dx() {
+				if gray {
+					row[x] = pix[4*x]
+				} else {
+					copy(row[3*x:3*x+3], pix[4*x:4*x+3])
+				}
+			}
+		default:
+			for x := range bounds.Dx() {
+				r, g, b, _ := img.At(bounds.Min.X+x, y).RGBA()
+				if gray {
+					row[x] = uint8(r >> 8)
+				} else {
+					row[3*x], row[3*x+1], row[3*x+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+				}
+			}
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
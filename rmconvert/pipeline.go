@@ -0,0 +1,321 @@
+package rmconvert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/juruen/rmapi/log"
+)
+
+// ErrOCRTimeout is returned by Pipeline.OCR for a page whose OCR took
+// longer than ExportOptions.OCRTimeout
+var ErrOCRTimeout = errors.New("OCR timed out")
+
+// tesseractWaitDelay is how long a killed tesseract is waited for
+const tesseractWaitDelay = 5 * time.Second
+
+// RenderedPage is a page of a document rasterized by Pipeline.Render
+type RenderedPage struct {
+	// Number is the 1-based number of the page in the document
+	Number int
+	ID     string
+	// Image is the page at the DPI of the options, only valid until the
+	// function given the page returns
+	Image image.Image
+	// Regions are the parts of the image with handwriting, recognised
+	// separately by OCR, the whole image if empty. They are set with
+	// OCRRegions.
+	Regions []image.Rectangle
+	// NoInk is set on the pages without strokes, typed text only or blank.
+	// They aren't recognised, TypedWords, the words of their typed text in
+	// the pixels of Image, are their text layer.
+	NoInk      bool
+	TypedWords []Word
+}
+
+// OCREngine recognises the words of a rendered page
+type OCREngine interface {
+	Recognize(goCtx context.Context, page RenderedPage) (PageOCR, error)
+}
+
+// Tesseract is the OCREngine running the tesseract command
+type Tesseract struct {
+	// Path is the tesseract executable, "tesseract" if empty
+	Path string
+	// Language is the tesseract language, e.g. "eng+fra"
+	Language string
+	// PSM is the tesseract page segmentation mode
+	PSM int
+	// DetectOrientation turns the pages whose text is sideways or upside
+	// down upright before recognising them, with tesseract's orientation
+	// detection (osd.traineddata)
+	DetectOrientation bool
+}
+
+// Recognize runs tesseract on a page, written to a temporary PNG file.
+// tesseract is killed if goCtx is cancelled.
+func (t *Tesseract) Recognize(goCtx context.Context, page RenderedPage) (PageOCR, error) {
+	path := t.Path
+	if path == "" {
+		path = "tesseract"
+	}
+	tempDir, err := os.MkdirTemp("", "rmdoc_ocr_*")
+	if err != nil {
+		return PageOCR{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pngPath := filepath.Join(tempDir, fmt.Sprintf("page_%04d.png", page.Number))
+	if err := writePNGFile(page.Image, pngPath); err != nil {
+		return PageOCR{}, fmt.Errorf("failed to write page %d for OCR: %v", page.Number, err)
+	}
+	if t.DetectOrientation {
+		ocr, ok, err := t.recognizeUpright(goCtx, path, page, tempDir, pngPath)
+		if ok || err != nil {
+			return ocr, err
+		}
+	}
+	return ocrOnePage(goCtx, path, t.Language, t.PSM, tempDir, pngPath, page.Number)
+}
+
+// Pipeline converts documents to searchable PDFs in stages that can be
+// used on their own: Render rasterizes the pages, OCR recognises their
+// words, after the Preprocess of the options, Assemble writes the pages to
+// a PDF and TextLayer adds the words to it as invisible text
+type Pipeline struct {
+	// Options are the rendering and OCR options, the defaults are applied
+	// by NewPipeline
+	Options ExportOptions
+	// Engine recognises the words, tesseract of the options if nil
+	Engine OCREngine
+}
+
+// NewPipeline returns the pipeline of the options, with tesseract
+func NewPipeline(opts ExportOptions) *Pipeline {
+	return &Pipeline{Options: opts.withDefaults()}
+}
+
+func (p *Pipeline) engine() OCREngine {
+	engine := p.Engine
+	if engine == nil {
+		engine = &Tesseract{
+			Path:              p.Options.TesseractPath,
+			Language:          p.Options.Language,
+			PSM:               p.Options.PSM,
+			DetectOrientation: p.Options.OCRRotate,
+		}
+	}
+	if preprocess := p.Options.preprocess(); preprocess.enabled() {
+		return &preprocessEngine{engine: engine, preprocess: preprocess}
+	}
+	return engine
+}
+
+// Render rasterizes the pages of a document in order, calling each with
+// every page. Pages missing from the archive are skipped, pages that can't
+// be parsed are blank, pages longer than the screen are rendered as
+// several, see Page.Sheets.
+func (p *Pipeline) Render(goCtx context.Context, doc *RmDoc, each func(RenderedPage) error) error {
+	pageOrder := doc.PageIDs()
+	if len(pageOrder) == 0 {
+		return fmt.Errorf("no pages found in document")
+	}
+	pageOrder = doc.sheetIDs(pageOrder)
+
+	number := 0
+	for _, pageID := range pageOrder {
+		if err := goCtx.Err(); err != nil {
+			return err
+		}
+		if !doc.HasPage(pageID) {
+			log.Warning.Printf("page %s not found, skipping", pageID)
+			continue
+		}
+
+		number++
+		page := pageOrBlank(doc, pageID)
+		opts := p.Options.forPage(number, len(pageOrder))
+		img := rasterize(page.renderWith(opts))
+		rendered := RenderedPage{Number: number, ID: pageID, Image: img}
+		if !page.hasInk() {
+			rendered.NoInk = true
+			rendered.TypedWords = page.typedWords(opts, img.Bounds())
+		} else if opts.OCRRegions {
+			rendered.Regions = page.imageInkRegions(opts, img.Bounds())
+		}
+		err := each(rendered)
+		releaseImage(img)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OCR recognises the words of a rendered page with the engine, in each
+// of its regions if it has some. The pages without ink aren't recognised,
+// their typed words are returned. It returns ErrOCRTimeout, and tesseract
+// is killed, when it takes longer than the OCRTimeout of the options.
+func (p *Pipeline) OCR(goCtx context.Context, page RenderedPage) (PageOCR, error) {
+	if page.NoInk {
+		b := page.Image.Bounds()
+		return PageOCR{PageNumber: page.Number, ImgW: b.Dx(), ImgH: b.Dy(), Words: page.TypedWords, Typed: true}, nil
+	}
+	log.Info.Printf("Running OCR on page %d", page.Number)
+	ctx := goCtx
+	if p.Options.OCRTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(goCtx, p.Options.OCRTimeout, ErrOCRTimeout)
+		defer cancel()
+	}
+
+	var ocr PageOCR
+	var err error
+	if len(page.Regions) > 0 {
+		ocr, err = ocrRegions(ctx, p.engine(), page)
+	} else {
+		ocr, err = p.engine().Recognize(ctx, page)
+	}
+	if err != nil && goCtx.Err() == nil && context.Cause(ctx) == ErrOCRTimeout {
+		return PageOCR{}, fmt.Errorf("page %d after %v: %w", page.Number, p.Options.OCRTimeout, ErrOCRTimeout)
+	}
+	return ocr, err
+}
+
+// recognize runs OCR on a page for the stages that go on without the words
+// of the pages whose OCR fails, logging the failure. The pages that timed
+// out are returned with TimedOut. Only the cancellation of goCtx is
+// returned as an error, ok is false if the page has no result.
+func (p *Pipeline) recognize(goCtx context.Context, page RenderedPage) (ocr PageOCR, ok bool, err error) {
+	ocr, err = p.OCR(goCtx, page)
+	if err == nil {
+		return ocr, true, nil
+	}
+	if goCtx.Err() != nil {
+		return PageOCR{}, false, goCtx.Err()
+	}
+	log.Warning.Printf("OCR failed for page %d: %v", page.Number, err)
+	if errors.Is(err, ErrOCRTimeout) {
+		b := page.Image.Bounds()
+		return PageOCR{PageNumber: page.Number, ImgW: b.Dx(), ImgH: b.Dy(), TimedOut: true}, true, nil
+	}
+	return PageOCR{}, false, nil
+}
+
+// TextLayer adds the recognised words to the pages of a PDF written by
+// Assemble as invisible text, to select and search them
+func (p *Pipeline) TextLayer(pdfPath string, results []PageOCR) error {
+	return addOCRTextToPDF(pdfPath, results, p.Options.DPI)
+}
+
+// PDFWriter writes rendered pages to a PDF, one image per page
+type PDFWriter struct {
+	pdf *imagePDF
+}
+
+// Assemble creates a PDF file to add the rendered pages to
+func (p *Pipeline) Assemble(pdfPath string) (*PDFWriter, error) {
+	pdf, err := createImagePDF(pdfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF: %v", err)
+	}
+	return &PDFWriter{pdf: pdf}, nil
+}
+
+// Add appends a page to the PDF
+func (w *PDFWriter) Add(page RenderedPage) error {
+	if err := w.pdf.AddImage(page.Image); err != nil {
+		return fmt.Errorf("failed to add page %s to PDF: %v", page.ID, err)
+	}
+	return nil
+}
+
+// Close completes the PDF, which must have pages
+func (w *PDFWriter) Close() error {
+	if len(w.pdf.pages) == 0 {
+		w.pdf.Abort()
+		return fmt.Errorf("no pages were successfully converted")
+	}
+	return w.pdf.Close()
+}
+
+// Abort removes the incomplete PDF
+func (w *PDFWriter) Abort() {
+	w.pdf.Abort()
+}
+
+// SearchablePDF writes the pages of a document to a PDF with the words
+// recognised on them as a text layer, and returns them. A page whose OCR
+// fails is kept without text, see TimedOutPages.
+func (p *Pipeline) SearchablePDF(goCtx context.Context, doc *RmDoc, pdfPath string) ([]PageOCR, error) {
+	w, err := p.Assemble(pdfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []PageOCR
+	err = p.Render(goCtx, doc, func(page RenderedPage) error {
+		if err := w.Add(page); err != nil {
+			return err
+		}
+		ocr, ok, err := p.recognize(goCtx, page)
+		if ok {
+			results = append(results, ocr)
+		}
+		return err
+	})
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if len(results) > 0 {
+		log.Info.Printf("Adding searchable text layer to %d pages", len(results))
+		if err := p.TextLayer(pdfPath, results); err != nil {
+			// the PDF is kept without searchable text
+			log.Warning.Printf("failed to add OCR text layer: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// Text recognises the words of the pages of a document without writing a
+// PDF. A page whose OCR fails has no words, see TimedOutPages.
+func (p *Pipeline) Text(goCtx context.Context, doc *RmDoc) ([]PageOCR, error) {
+	var results []PageOCR
+	err := p.Render(goCtx, doc, func(page RenderedPage) error {
+		ocr, ok, err := p.recognize(goCtx, page)
+		if ok {
+			results = append(results, ocr)
+		}
+		return err
+	})
+	return results, err
+}
+
+// TimedOutPages returns the numbers of the pages whose OCR timed out
+func TimedOutPages(results []PageOCR) []int {
+	var pages []int
+	for _, ocr := range results {
+		if ocr.TimedOut {
+			pages = append(pages, ocr.PageNumber)
+		}
+	}
+	return pages
+}
+
+// tesseractAvailable tells whether the tesseract of the options can be
+// run, the engine of a pipeline whose Engine is nil
+func tesseractAvailable(opts ExportOptions) bool {
+	_, err := exec.LookPath(opts.TesseractPath)
+	return err == nil
+}
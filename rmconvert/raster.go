@@ -0,0 +1,92 @@
+package rmconvert
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"sync"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers/rasterizer"
+)
+
+func init() {
+	// The strokes are polylines filled with the nonzero winding rule, so
+	// the overlaps of their outlines needn't be removed. Removing them
+	// ("settling") took most of the rendering time.
+	canvas.FastStroke = true
+}
+
+// rgbaPool keeps the images pages are rasterized on, several MB each at
+// print resolutions, for the next pages
+var rgbaPool sync.Pool
+
+// pngBuffers keeps the buffers of the PNG encoder across pages
+type pngBuffers struct {
+	pool sync.Pool
+}
+
+func (b *pngBuffers) Get() *png.EncoderBuffer {
+	buf, _ := b.pool.Get().(*png.EncoderBuffer)
+	return buf
+}
+
+func (b *pngBuffers) Put(buf *png.EncoderBuffer) {
+	b.pool.Put(buf)
+}
+
+var pngBufferPool = &pngBuffers{}
+
+// pngEncoder writes the PNG images given to the user
+var pngEncoder = &png.Encoder{BufferPool: pngBufferPool}
+
+// pagePNGEncoder writes the page images assembled into a PDF, which
+// compresses them again, so they are compressed as fast as possible
+var pagePNGEncoder = &png.Encoder{CompressionLevel: png.BestSpeed, BufferPool: pngBufferPool}
+
+// rasterize draws a canvas whose units are pixels on an image of the pool,
+// to be given back with releaseImage once encoded
+func rasterize(c *canvas.Canvas) *image.RGBA {
+	rect := image.Rect(0, 0, int(c.W+0.5), int(c.H+0.5))
+	img, _ := rgbaPool.Get().(*image.RGBA)
+	if img == nil || img.Rect != rect {
+		img = image.NewRGBA(rect)
+	} else {
+		clear(img.Pix)
+	}
+
+	ras := rasterizer.FromImage(img, canvas.DPMM(1), canvas.DefaultColorSpace)
+	c.RenderTo(ras)
+	ras.Close()
+	return img
+}
+
+// releaseImage gives an image of rasterize back to the pool
+func releaseImage(img *image.RGBA) {
+	rgbaPool.Put(img)
+}
+
+// writePNG rasterizes a canvas whose units are pixels and writes it as PNG
+// with enc, like renderers.PNG without allocating an image and encoder
+// buffers for each page
+func writePNG(w io.Writer, c *canvas.Canvas, enc *png.Encoder) error {
+	img := rasterize(c)
+	defer releaseImage(img)
+	return enc.Encode(w, img)
+}
+
+// grayImage converts an opaque image to grayscale, a quarter of its size
+func grayImage(src *image.RGBA) *image.Gray {
+	bounds := src.Bounds()
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		srcRow := src.Pix[src.PixOffset(bounds.Min.X, y):]
+		dstRow := dst.Pix[dst.PixOffset(bounds.Min.X, y):]
+		for x := range bounds.Dx() {
+			// the luminance of color.GrayModel
+			r, g, b := uint32(srcRow[4*x]), uint32(srcRow[4*x+1]), uint32(srcRow[4*x+2])
+			dstRow[x] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,425 @@
+package rmconvert
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// benchmarkPage returns a page of handwriting: lines of short strokes of
+// ~60 points each, about as dense as a full page of notes
+func benchmarkPage() *rm.Rm {
+	var lines []rm.Line
+	for row := 0; row < 30; row++ {
+		for word := 0; word < 12; word++ {
+			line := rm.Line{BrushType: rm.FinelinerV5, BrushColor: rm.Black, BrushSize: 2}
+			x0 := float32(80 + word*105)
+			y0 := float32(120 + row*58)
+			for i := 0; i < 60; i++ {
+				t := float64(i) / 59
+				line.Points = append(line.Points, rm.Point{
+					X:        x0 + float32(t*90),
+					Y:        y0 + float32(15*math.Sin(t*4*math.Pi)),
+					Width:    2,
+					Pressure: 0.8,
+				})
+			}
+			lines = append(lines, line)
+		}
+	}
+	return &rm.Rm{Version: rm.V5, Layers: []rm.Layer{{Lines: lines}}}
+}
+
+func BenchmarkConvertRmToPage(b *testing.B) {
+	data := benchmarkPage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		convertRmToPage(data)
+	}
+}
+
+func BenchmarkRenderPage(b *testing.B) {
+	page := convertRmToPage(benchmarkPage())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := page.ConvertToPNG(io.Discard, 300); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderPDFPage(b *testing.B) {
+	page := convertRmToPage(benchmarkPage())
+	opts := ExportOptions{DPI: 300}.withDefaults()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := writePNG(io.Discard, page.renderWith(opts), pagePNGEncoder); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderToImage(b *testing.B) {
+	page := convertRmToPage(benchmarkPage())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := page.RenderToImage(300); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRenderToImage(t *testing.T) {
+	img, err := CreateTestPage().RenderToImage(226)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("got %T, want *image.Gray", img)
+	}
+	if got := gray.Bounds(); got != image.Rect(0, 0, 1404, 1872) {
+		t.Errorf("bounds %v, want 1404x1872", got)
+	}
+	if got := gray.GrayAt(10, 10); got != (color.Gray{Y: 0xff}) {
+		t.Errorf("background %v, want white", got)
+	}
+	// on the horizontal ballpoint stroke from (600, 800) to (1200, 800)
+	if got := gray.GrayAt(900, 800); got.Y > 0x40 {
+		t.Errorf("stroke %v, want black", got)
+	}
+}
+
+func TestConvertRmdocToImagePDF(t *testing.T) {
+	strokes, err := os.ReadFile(filepath.Join("..", "encoding", "rm", "test_v5.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}, {"id": "p2", "idx": {"value": "b"}}, {"id": "p3", "idx": {"value": "c"}}]}}`),
+		"doc/p1.rm":   strokes,
+		"doc/p2.rm":   []byte("not a page"),
+		"doc/p3.rm":   strokes,
+	})
+
+	// without a budget, and with a budget forcing grayscale pages one at a
+	// time
+	defer func(budget int64) { MemoryBudget = budget }(MemoryBudget)
+	for _, budget := range []int64{0, 3 << 20} {
+		MemoryBudget = budget
+		pdfPath := filepath.Join(t.TempDir(), "out.pdf")
+		if err := ConvertRmdocToImagePDF(context.Background(), rmdocPath, pdfPath, 72); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.ValidateFile(pdfPath, nil); err != nil {
+			t.Fatalf("budget %d: invalid PDF: %v", budget, err)
+		}
+		dims, err := api.PageDimsFile(pdfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the page that can't be parsed is blank
+		if len(dims) != 3 {
+			t.Fatalf("budget %d: got %d pages, want 3", budget, len(dims))
+		}
+		// one point per pixel, 1404x1872 at 226 DPI rendered at 72 DPI
+		if dims[0].Width != 447 || dims[0].Height != 596 {
+			t.Errorf("budget %d: got page size %v, want 447x596", budget, dims[0])
+		}
+	}
+}
+
+func TestPlanRendering(t *testing.T) {
+	defer func(budget int64) { MemoryBudget = budget }(MemoryBudget)
+	full := pageMemory(300, false)
+	tests := []struct {
+		budget int64
+		pages  int
+		want   renderPlan
+	}{
+		{0, 1, renderPlan{workers: 1}},
+		{full, 10, renderPlan{workers: 1}},
+		{2*full + full/2, 10, renderPlan{workers: min(2, runtime.NumCPU())}},
+		{full - 1, 10, renderPlan{workers: 1, gray: true}},
+		{1 << 20, 10, renderPlan{workers: 1, gray: true}},
+	}
+	for _, tt := range tests {
+		MemoryBudget = tt.budget
+		if got := planRendering(300, tt.pages); got != tt.want {
+			t.Errorf("budget %d, %d pages: got %+v, want %+v", tt.budget, tt.pages, got, tt.want)
+		}
+	}
+}
+
+func TestImagePDFColor(t *testing.T) {
+	gray := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	colored := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	colored.Set(1, 1, color.RGBA{R: 0xff, G: 0xff, A: 0xff})
+	if !isGray(gray) || isGray(colored) {
+		t.Errorf("isGray: got %v for gray and %v for colored", isGray(gray), isGray(colored))
+	}
+
+	pdfPath := filepath.Join(t.TempDir(), "out.pdf")
+	pdf, err := createImagePDF(pdfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range []image.Image{gray, colored} {
+		if err := pdf.AddImage(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pdf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := api.PageCountFile(pdfPath); err != nil || n != 2 {
+		t.Errorf("got %d pages, %v", n, err)
+	}
+}
+
+func TestDebugRender(t *testing.T) {
+	// two horizontal strokes, the pressure of the first rising from left
+	// to right
+	var first, second rm.Line
+	for i := 0; i <= 10; i++ {
+		first.Points = append(first.Points, rm.Point{X: float32(200 + 100*i), Y: 400, Width: 2, Pressure: float32(i) / 10})
+		second.Points = append(second.Points, rm.Point{X: float32(200 + 100*i), Y: 800, Width: 2, Pressure: 0.5})
+	}
+	first.BrushType, second.BrushType = rm.FinelinerV5, rm.FinelinerV5
+	first.BrushSize, second.BrushSize = 2, 2
+	page := convertRmToPage(&rm.Rm{Version: rm.V5, Layers: []rm.Layer{{Lines: []rm.Line{first, second}}}})
+
+	tests := []struct {
+		mode      DebugRender
+		x, y      int
+		red, blue bool
+	}{
+		{DebugRenderPressure, 210, 400, false, true},
+		{DebugRenderPressure, 1190, 400, true, false},
+		{DebugRenderOrder, 700, 400, false, true},
+		{DebugRenderOrder, 700, 800, true, false},
+	}
+	for _, tt := range tests {
+		img := rasterize(page.renderWith(ExportOptions{DPI: 226, DebugRender: tt.mode}))
+		c := img.RGBAAt(tt.x, tt.y)
+		releaseImage(img)
+		if tt.red && (c.R < 0xc0 || c.B > 0x40) || tt.blue && (c.B < 0xc0 || c.R > 0x40) {
+			t.Errorf("%s at (%d, %d): got %v", tt.mode, tt.x, tt.y, c)
+		}
+	}
+
+	if _, err := ParseDebugRender("direction"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestInvert(t *testing.T) {
+	line := rm.Line{BrushType: rm.FinelinerV5, BrushSize: 2}
+	for i := 0; i <= 10; i++ {
+		line.Points = append(line.Points, rm.Point{X: float32(200 + 100*i), Y: 400, Width: 2, Pressure: 0.5})
+	}
+	page := convertRmToPage(&rm.Rm{Version: rm.V5, Layers: []rm.Layer{{Lines: []rm.Line{line}}}})
+
+	img := rasterize(page.renderWith(ExportOptions{DPI: 226, Invert: true}))
+	background, stroke := img.RGBAAt(100, 100), img.RGBAAt(700, 400)
+	releaseImage(img)
+	if background.R > 0x10 || background.G > 0x10 || background.B > 0x10 {
+		t.Errorf("background: got %v, want black", background)
+	}
+	if stroke.R < 0xf0 || stroke.G < 0xf0 || stroke.B < 0xf0 {
+		t.Errorf("stroke: got %v, want white", stroke)
+	}
+
+	tests := []struct {
+		in, want color.RGBA
+	}{
+		{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}},
+		{color.RGBA{119, 119, 119, 255}, color.RGBA{136, 136, 136, 255}},
+		// the yellow highlighter keeps its hue
+		{color.RGBA{255, 224, 102, 255}, color.RGBA{153, 122, 0, 255}},
+	}
+	for _, tt := range tests {
+		if got := invertColor(tt.in); got != tt.want {
+			t.Errorf("invertColor(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBackground(t *testing.T) {
+	page := CreateTestPage()
+	tests := []struct {
+		background string
+		want       color.RGBA
+	}{
+		{"", color.RGBA{255, 255, 255, 255}},
+		{"transparent", color.RGBA{}},
+		{"#336699", color.RGBA{0x33, 0x66, 0x99, 255}},
+	}
+	for _, tt := range tests {
+		background, err := ParseBackground(tt.background)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := rasterize(page.renderWith(ExportOptions{DPI: 100, Background: background}))
+		got := img.RGBAAt(1, 1)
+		releaseImage(img)
+		if got != tt.want {
+			t.Errorf("background %q: got %v, want %v", tt.background, got, tt.want)
+		}
+	}
+
+	if _, err := ParseBackground("blue"); err == nil {
+		t.Error("expected an error for an unknown color")
+	}
+}
+
+func TestCrop(t *testing.T) {
+	// a fineliner stroke from (200, 400) to (700, 600), 2 device pixels wide
+	line := rm.Line{BrushType: rm.FinelinerV5, BrushSize: 2, Points: []rm.Point{
+		{X: 200, Y: 400, Width: 2, Pressure: 0.5}, {X: 700, Y: 600, Width: 2, Pressure: 0.5}}}
+	page := convertRmToPage(&rm.Rm{Version: rm.V5, Layers: []rm.Layer{{Lines: []rm.Line{line}}}})
+
+	img := rasterize(page.renderWith(ExportOptions{DPI: 226, Crop: true, CropMargin: 50}))
+	size := img.Bounds().Size()
+	// a tenth along the stroke
+	onStroke := img.RGBAAt(101, 71)
+	releaseImage(img)
+	// the content plus the margins, the stroke width rounded
+	if size.X < 600 || size.X > 604 || size.Y < 300 || size.Y > 304 {
+		t.Errorf("cropped size: got %v, want about 602x302", size)
+	}
+	if onStroke.R > 0x40 {
+		t.Errorf("expected the stroke to start at the margin, got %v", onStroke)
+	}
+
+	var buf bytes.Buffer
+	if err := page.GenerateSVG(&buf, SVGOptions{Precision: 1, Crop: true, CropMargin: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `viewBox="149 349 602 302"`) {
+		t.Errorf("unexpected cropped SVG:\n%s", buf.String())
+	}
+
+	// blank pages are kept whole
+	blank := &Page{Width: 1404, Height: 1872}
+	if c := blank.renderWith(ExportOptions{DPI: 226, Crop: true}); c.W != 1404 || c.H != 1872 {
+		t.Errorf("blank page cropped to %gx%g", c.W, c.H)
+	}
+}
+
+func TestRenderSize(t *testing.T) {
+	page := CreateTestPage()
+	tests := []struct {
+		width, height int
+		want          image.Point
+	}{
+		{300, 0, image.Pt(300, 400)},
+		{0, 400, image.Pt(300, 400)},
+		// fits in both, keeping the 3:4 aspect ratio
+		{300, 300, image.Pt(225, 300)},
+	}
+	for _, tt := range tests {
+		img := rasterize(page.renderWith(ExportOptions{DPI: 300, Width: tt.width, Height: tt.height}))
+		got := img.Bounds().Size()
+		releaseImage(img)
+		if got != tt.want {
+			t.Errorf("%dx%d: got %v, want %v", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestPageMarks(t *testing.T) {
+	page := &Page{Width: 1404, Height: 1872}
+	opts := ExportOptions{DPI: 226, Header: "{name} {date}", Footer: "{page} of {pages}", PageNumbers: true}.
+		ForDocument("/Work/Plans", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)).forPage(2, 5)
+	if opts.marks != (pageMarks{header: "Plans 2024-03-01", footer: "2 of 5", number: "2 / 5"}) {
+		t.Fatalf("unexpected marks %+v", opts.marks)
+	}
+
+	img := rasterize(page.renderWith(opts))
+	defer releaseImage(img)
+	// the rows of the header, the footer and the page number
+	for _, region := range []image.Rectangle{
+		image.Rect(500, 40, 900, 64),
+		image.Rect(500, 1810, 900, 1832),
+		image.Rect(1250, 1810, 1364, 1832),
+	} {
+		drawn := false
+		for y := region.Min.Y; y < region.Max.Y && !drawn; y++ {
+			for x := region.Min.X; x < region.Max.X; x++ {
+				if img.RGBAAt(x, y).R < 0xc0 {
+					drawn = true
+					break
+				}
+			}
+		}
+		if !drawn {
+			t.Errorf("nothing drawn in %v", region)
+		}
+	}
+
+	var buf bytes.Buffer
+	svg := DefaultSVGOptions
+	svg.marks = opts.marks
+	if err := page.GenerateSVG(&buf, svg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<text x="1364" y="1832" text-anchor="end" xml:space="preserve">2 / 5</text>`) {
+		t.Errorf("page number missing from the SVG:\n%s", buf.String())
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	defer SetCalibration(nil)
+	opacity := float32(0.5)
+	if err := SetCalibration(map[string]config.ToolCalibration{
+		"pencil":      {Width: 2, Opacity: &opacity},
+		"highlighter": {Color: "#ff0000"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if props := GetToolProperties(ToolPencil, ColorBlack, 3); props.StrokeWidth != 6 || props.Opacity != 0.5 || props.Color != "black" {
+		t.Errorf("wrong pencil: %+v", props)
+	}
+	if props := GetToolProperties(ToolHighlighter, ColorBlack, 3); props.StrokeWidth != 9 || props.Opacity != 0.4 || props.Color != "#ff0000" {
+		t.Errorf("wrong highlighter: %+v", props)
+	}
+	if props := GetToolProperties(ToolFineliner, ColorBlack, 3); props.StrokeWidth != 3 || props.Opacity != 1 {
+		t.Errorf("wrong fineliner: %+v", props)
+	}
+
+	// the color and the opacity are drawn
+	page := &Page{Width: 1404, Height: 1872, Strokes: []Stroke{
+		{Tool: ToolHighlighter, Color: ColorBlack, Width: 10, Points: []Point{{X: 100, Y: 100}, {X: 500, Y: 100}}},
+	}}
+	img := rasterize(page.render(226))
+	defer releaseImage(img)
+	if c := img.RGBAAt(300, 100); c.R != 0xff || c.G < 0x90 || c.G > 0xb0 {
+		t.Errorf("got %v, want light red", c)
+	}
+
+	tooOpaque := float32(2)
+	for _, tools := range []map[string]config.ToolCalibration{
+		{"brush": {Width: 2}},
+		{"pencil": {Color: "blue"}},
+		{"pencil": {Opacity: &tooOpaque}},
+	} {
+		if err := SetCalibration(tools); err == nil {
+			t.Errorf("expected an error for %v", tools)
+		}
+	}
+}
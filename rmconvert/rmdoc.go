@@ -0,0 +1,248 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/juruen/rmapi/model"
+)
+
+// RmDoc reads the files of a .rmdoc archive in place, without extracting
+// it to disk. It can be opened from a file, any io.ReaderAt or a stream such
+// as a download response.
+type RmDoc struct {
+	// ID is the document ID, the name of its .content file
+	ID      string
+	Content ContentFile
+
+	closer    io.Closer
+	files     map[string]*zip.File
+	pages     map[string]*zip.File
+	templates map[string]string
+
+	// mu guards the inflated size of the archive, limited as a whole by
+	// the total size of ExtractLimits
+	mu        sync.Mutex
+	remaining int64
+	inflated  map[*zip.File]bool
+}
+
+// OpenRmDoc opens a .rmdoc file
+func OpenRmDoc(rmdocPath string) (*RmDoc, error) {
+	r, err := zip.OpenReader(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newRmDoc(rmdocPath, &r.Reader)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	d.closer = r
+	return d, nil
+}
+
+// NewRmDoc reads a .rmdoc archive of size bytes from r
+func NewRmDoc(r io.ReaderAt, size int64) (*RmDoc, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return newRmDoc("document", zr)
+}
+
+// ReadRmDoc reads a .rmdoc archive from a stream into memory, within the
+// total size of ExtractLimits
+func ReadRmDoc(r io.Reader) (*RmDoc, error) {
+	max := ExtractLimits.MaxTotalSize
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(data)) > max {
+		return nil, &ZipLimitError{"document", "total size", max}
+	}
+	return NewRmDoc(bytes.NewReader(data), int64(len(data)))
+}
+
+func newRmDoc(name string, zr *zip.Reader) (*RmDoc, error) {
+	limits := ExtractLimits
+	if err := limits.check(name, zr.File); err != nil {
+		return nil, err
+	}
+
+	d := &RmDoc{
+		files:     make(map[string]*zip.File, len(zr.File)),
+		pages:     make(map[string]*zip.File),
+		remaining: limits.MaxTotalSize,
+		inflated:  make(map[*zip.File]bool),
+	}
+	for _, f := range zr.File {
+		d.files[f.Name] = f
+		if !strings.Contains(f.Name, "/") && strings.HasSuffix(f.Name, ".content") {
+			d.ID = strings.TrimSuffix(f.Name, ".content")
+		}
+	}
+	if d.ID == "" {
+		return nil, fmt.Errorf("no .content file found: %w", model.ErrParse)
+	}
+
+	for _, f := range zr.File {
+		if path.Dir(f.Name) == d.ID && path.Ext(f.Name) == ".rm" {
+			d.pages[strings.TrimSuffix(path.Base(f.Name), ".rm")] = f
+		}
+	}
+
+	content, err := d.ReadFile("content")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &d.Content); err != nil {
+		return nil, fmt.Errorf("bad .content file: %v: %w", err, model.ErrParse)
+	}
+	d.readTemplates()
+	return d, nil
+}
+
+// Close releases the file opened by OpenRmDoc
+func (d *RmDoc) Close() error {
+	if d.closer == nil {
+		return nil
+	}
+	return d.closer.Close()
+}
+
+// ReadFile returns the content of a file of the document, named relative to
+// the document, e.g. "metadata" or "pagedata" for the files next to the
+// .content file, or "<page id>.rm" for a page
+func (d *RmDoc) ReadFile(name string) ([]byte, error) {
+	f, ok := d.files[d.ID+"."+name]
+	if !ok {
+		f, ok = d.files[d.ID+"/"+name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return d.read(f)
+}
+
+// read inflates a file of the archive within ExtractLimits
+func (d *RmDoc) read(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// the declared sizes were checked when opening, the inflated ones may
+	// differ. A file read again is not counted twice.
+	limits := ExtractLimits
+	d.mu.Lock()
+	counted := d.inflated[f]
+	remaining := limits.MaxTotalSize
+	if !counted {
+		remaining = d.remaining
+	}
+	d.mu.Unlock()
+
+	var buf bytes.Buffer
+	budget := remaining
+	if err := limits.limitedCopy(d.ID, &buf, r, &remaining); err != nil {
+		return nil, err
+	}
+	if counted {
+		return buf.Bytes(), nil
+	}
+
+	// concurrent reads share the budget, the total is checked again once
+	// the file is counted
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.inflated[f] {
+		d.inflated[f] = true
+		d.remaining -= budget - remaining
+	}
+	if limits.MaxTotalSize > 0 && d.remaining < 0 {
+		return nil, &ZipLimitError{d.ID, "total size", limits.MaxTotalSize}
+	}
+	return buf.Bytes(), nil
+}
+
+// PageIDs returns the IDs of the pages in document order, see
+// ContentFile.PageOrder
+func (d *RmDoc) PageIDs() []string {
+	rmFiles := make([]string, 0, len(d.pages))
+	for id := range d.pages {
+		rmFiles = append(rmFiles, id)
+	}
+	sort.Strings(rmFiles)
+	return d.Content.PageOrder(rmFiles)
+}
+
+// HasPage tells whether a page has a .rm file, pages that were never
+// written on don't
+func (d *RmDoc) HasPage(id string) bool {
+	pageID, _ := splitSheetID(id)
+	_, ok := d.pages[pageID]
+	return ok
+}
+
+// Page parses the .rm file of a page, the error wraps fs.ErrNotExist if the
+// page has none. The IDs of sheetIDs return a sheet of the page.
+func (d *RmDoc) Page(id string) (*Page, error) {
+	id, sheet := splitSheetID(id)
+	f, ok := d.pages[id]
+	if !ok {
+		return nil, fmt.Errorf("page %s: %w", id, fs.ErrNotExist)
+	}
+	data, err := d.read(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var rmData rm.Rm
+	if err := rmData.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("failed to parse rm file: %w", err)
+	}
+	page := convertRmToPage(&rmData)
+	page.Template = d.templates[id]
+	if sheet > 1 {
+		sheets := page.Sheets()
+		if sheet > len(sheets) {
+			return nil, fmt.Errorf("page %s has no sheet %d: %w", id, sheet, fs.ErrNotExist)
+		}
+		return sheets[sheet-1], nil
+	}
+	return page, nil
+}
+
+// Pages parses the pages of the document, in document order. The pages
+// without a .rm file are blank.
+func (d *RmDoc) Pages() ([]string, map[string]*Page, error) {
+	pageOrder := d.PageIDs()
+	pages := make(map[string]*Page, len(pageOrder))
+	for _, id := range pageOrder {
+		if !d.HasPage(id) {
+			pages[id] = &Page{Width: 1404, Height: 1872, Template: d.templates[id]}
+			continue
+		}
+		page, err := d.Page(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		pages[id] = page
+	}
+	return pageOrder, pages, nil
+}
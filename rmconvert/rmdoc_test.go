@@ -0,0 +1,294 @@
+package rmconvert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/tdewolff/canvas/renderers"
+)
+
+func TestReadRmDoc(t *testing.T) {
+	rmdocPath := filepath.Join(t.TempDir(), "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatalf("Failed to create test .rmdoc: %v", err)
+	}
+
+	// read from a stream, as from a download response
+	f, err := os.Open(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := ReadRmDoc(f)
+	if err != nil {
+		t.Fatalf("ReadRmDoc: %v", err)
+	}
+
+	if doc.ID != "test-doc" {
+		t.Errorf("got ID %q", doc.ID)
+	}
+	ids := doc.PageIDs()
+	if len(ids) != 1 || ids[0] != "test-page-1" || !doc.HasPage(ids[0]) {
+		t.Fatalf("got pages %v", ids)
+	}
+	page, err := doc.Page(ids[0])
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	if len(page.Strokes) == 0 {
+		t.Errorf("no strokes parsed")
+	}
+
+	if _, err := doc.Page("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for a missing page", err)
+	}
+	if _, err := doc.ReadFile("metadata"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for a missing file", err)
+	}
+}
+
+func TestBackgroundPages(t *testing.T) {
+	dir := t.TempDir()
+
+	// a two page PDF, annotated with a page inserted between them
+	var pdfFiles []string
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("bg%d.pdf", i))
+		var buf bytes.Buffer
+		if err := (&Page{Width: 1404, Height: 1872}).render(mmPerInch).Write(&buf, renderers.PDF()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		pdfFiles = append(pdfFiles, path)
+	}
+	backgroundPath := filepath.Join(dir, "bg.pdf")
+	if err := MergePDFs(pdfFiles, backgroundPath); err != nil {
+		t.Fatal(err)
+	}
+	background, _ := os.ReadFile(backgroundPath)
+
+	content := `{"fileType": "pdf", "cPages": {"pages": [
+		{"id": "p1", "redir": {"value": 1}},
+		{"id": "p2"},
+		{"id": "p3", "redir": {"value": 0}}]}}`
+	strokes, err := os.ReadFile(filepath.Join("..", "encoding", "rm", "test_v5.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(content),
+		"doc.pdf":     background,
+		"doc/p1.rm":   strokes,
+	})
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	if got := doc.BackgroundPages(); fmt.Sprint(got) != "[1 -1 0]" {
+		t.Errorf("got background pages %v", got)
+	}
+	if _, ok := doc.BackgroundPDF(); !ok {
+		t.Fatal("background PDF not found")
+	}
+
+	outPath := filepath.Join(dir, "out.pdf")
+	if err := ComposeAnnotatedPDF(context.Background(), doc, background, outPath); err != nil {
+		t.Fatalf("ComposeAnnotatedPDF: %v", err)
+	}
+	if n, err := api.PageCountFile(outPath); err != nil || n != 3 {
+		t.Errorf("got %d pages, %v", n, err)
+	}
+
+	// files written before cPages
+	legacy := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"fileType": "pdf", "redirectionPageMap": [0, -1, 1]}`),
+		"doc/a.rm":    nil,
+		"doc/b.rm":    nil,
+		"doc/c.rm":    nil,
+	})
+	if doc, err = OpenRmDoc(legacy); err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if got := doc.BackgroundPages(); fmt.Sprint(got) != "[0 -1 1]" {
+		t.Errorf("got legacy background pages %v", got)
+	}
+}
+
+func TestOrderedPages(t *testing.T) {
+	// p3 was moved to the front, p2 was deleted
+	content := `{"cPages": {"pages": [
+		{"id": "p1", "idx": {"timestamp": "1:2", "value": "ba"}},
+		{"id": "p2", "idx": {"timestamp": "1:2", "value": "bb"}, "deleted": {"timestamp": "1:5", "value": 1}},
+		{"id": "p3", "idx": {"timestamp": "1:7", "value": "aZ"}, "verticalScroll": {"timestamp": "1:8", "value": 120.5}},
+		{"id": "p4", "idx": {"timestamp": "1:2", "value": "bc"}}]}}`
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(content),
+		"doc/p2.rm":   nil,
+	})
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	if got := doc.PageIDs(); fmt.Sprint(got) != "[p3 p1 p4]" {
+		t.Errorf("got pages %v", got)
+	}
+	if n, err := PageCount(rmdocPath); err != nil || n != 3 {
+		t.Errorf("got page count %d, %v", n, err)
+	}
+}
+
+func TestLegacyZipPageOrder(t *testing.T) {
+	strokes, err := os.ReadFile(filepath.Join("..", "encoding", "rm", "test_v3.rm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// numbered pages, without a pages list
+	numbered := writeTestZip(t, map[string][]byte{
+		"doc.content":           []byte(`{"fileType": "notebook", "pageCount": 3}`),
+		"doc.pagedata":          []byte("Blank\nBlank\nBlank\n"),
+		"doc/0.rm":              strokes,
+		"doc/2.rm":              strokes,
+		"doc/10.rm":             strokes,
+		"doc/0-metadata.json":   []byte(`{"layers": [{"name": "Layer 1"}]}`),
+		"doc.thumbnails/0.jpg":  nil,
+		"doc.highlights/0.json": nil,
+	})
+	extractDir := t.TempDir()
+	if err := extractZip(numbered, extractDir); err != nil {
+		t.Fatal(err)
+	}
+	pageOrder, docDir, err := getPageOrderAndDocDir(extractDir)
+	if err != nil {
+		t.Fatalf("getPageOrderAndDocDir: %v", err)
+	}
+	if fmt.Sprint(pageOrder) != "[0 2 10]" || filepath.Base(docDir) != "doc" {
+		t.Errorf("got pages %v in %s", pageOrder, docDir)
+	}
+
+	// pages list of the files written before cPages
+	listed := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"fileType": "notebook", "pages": ["b", "a"]}`),
+		"doc/a.rm":    strokes,
+		"doc/b.rm":    strokes,
+	})
+	doc, err := OpenRmDoc(listed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if got := doc.PageIDs(); fmt.Sprint(got) != "[b a]" {
+		t.Errorf("got pages %v", got)
+	}
+	if _, pages, err := doc.Pages(); err != nil || len(pages["a"].Strokes) == 0 {
+		t.Errorf("legacy pages not parsed: %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	content := `{"fileType": "notebook", "cPages": {"pages": [
+		{"id": "p1", "idx": {"value": "ba"}, "template": {"timestamp": "1:1", "value": "Lined"}},
+		{"id": "p2", "idx": {"value": "bb"}, "template": {"timestamp": "1:1", "value": "Blank"}}]}}`
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(content),
+		"doc/p1.rm":   nil,
+	})
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if got := doc.Templates(); fmt.Sprint(got) != "[Lined Blank]" {
+		t.Errorf("got templates %v", got)
+	}
+
+	// files written before cPages
+	legacy := writeTestZip(t, map[string][]byte{
+		"doc.content":  []byte(`{"fileType": "notebook", "pages": ["b", "a"]}`),
+		"doc.pagedata": []byte("P Grid medium\nBlank\n"),
+	})
+	if doc, err = OpenRmDoc(legacy); err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if got := doc.Templates(); fmt.Sprint(got) != "[P Grid medium Blank]" {
+		t.Errorf("got legacy templates %v", got)
+	}
+
+	// a gray template is drawn under the page
+	templatesDir := t.TempDir()
+	gray := image.NewGray(image.Rect(0, 0, 1404, 1872))
+	for i := range gray.Pix {
+		gray.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templatesDir, "Lined.png"), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"Lined", "Blank", "Missing"} {
+		page := &Page{Width: 1404, Height: 1872, Template: name}
+		buf.Reset()
+		if err := page.renderWith(ExportOptions{DPI: 30, TemplatesDir: templatesDir}).Write(&buf, renderers.PNG()); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := img.Bounds()
+		r, _, _, _ := img.At(b.Dx()/2, b.Dy()/2).RGBA()
+		if drawn := r < 0xf000; drawn != (name == "Lined") {
+			t.Errorf("template %s: got center red %#x", name, r)
+		}
+	}
+}
+
+func TestDocumentInfo(t *testing.T) {
+	content := `{"fileType": "pdf", "orientation": "landscape", "cPages": {"pages": [
+		{"id": "p1", "idx": {"value": "ba"}, "redir": {"value": 0}, "template": {"value": "Blank"}},
+		{"id": "p2", "idx": {"value": "bb"}, "template": {"value": "Lined"}}]}}`
+	rmdocPath := writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(content),
+		"doc.pdf":     []byte("%PDF-1.7"),
+		"doc/p2.rm":   nil,
+	})
+
+	info, err := ReadDocumentInfo(rmdocPath)
+	if err != nil {
+		t.Fatalf("ReadDocumentInfo: %v", err)
+	}
+	if info.ID != "doc" || info.FileType != "pdf" || info.Orientation != "landscape" || !info.HasBackgroundPDF {
+		t.Errorf("got %+v", info)
+	}
+	want := []PageInfo{
+		{ID: "p1", Template: "Blank", BackgroundPage: 0},
+		{ID: "p2", Template: "Lined", HasStrokes: true, BackgroundPage: -1},
+	}
+	if fmt.Sprint(info.Pages) != fmt.Sprint(want) {
+		t.Errorf("got pages %+v", info.Pages)
+	}
+
+	if ids, err := GetPageOrder(rmdocPath); err != nil || fmt.Sprint(ids) != "[p1 p2]" {
+		t.Errorf("got page order %v, %v", ids, err)
+	}
+}
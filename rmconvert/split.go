@@ -0,0 +1,327 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// QuickSheetsName is the name of the Quick sheets notebook of the device,
+// at the root of the library, to which the pages written from the sidebar
+// are added
+const QuickSheetsName = "Quick sheets"
+
+// SessionGap is the time between two pages above which SplitSession starts
+// a new part
+const SessionGap = 2 * time.Hour
+
+// SplitBy is how a document is split into parts, see RmDoc.Parts
+type SplitBy string
+
+const (
+	SplitDay     SplitBy = "day"
+	SplitWeek    SplitBy = "week"
+	SplitMonth   SplitBy = "month"
+	SplitSession SplitBy = "session"
+)
+
+// ParseSplitBy parses day, week, month or session
+func ParseSplitBy(s string) (SplitBy, error) {
+	switch by := SplitBy(s); by {
+	case SplitDay, SplitWeek, SplitMonth, SplitSession:
+		return by, nil
+	}
+	return "", fmt.Errorf("unknown split %q, use day, week, month or session", s)
+}
+
+// DocumentPart is a part of a document split by RmDoc.Parts
+type DocumentPart struct {
+	// Label names the part after its period: 2006-01-02, 2006-W01, 2006-01
+	// or 2006-01-02 15.04 for the sessions, undated for a document without
+	// page times
+	Label string
+	// Pages are the IDs of the pages of the part, in document order
+	Pages []string
+}
+
+// pageTimes returns the time each page was last written on, from the
+// .content file, in document order. The pages without a time take the one
+// of the page before them, or after for the first ones. It returns nil if
+// no page has a time.
+func (d *RmDoc) pageTimes() []time.Time {
+	modified := make(map[string]time.Time)
+	for _, page := range d.Content.CPages.Pages {
+		if ms, err := strconv.ParseInt(page.Modified, 10, 64); err == nil && ms > 0 {
+			modified[page.ID] = time.UnixMilli(ms)
+		}
+	}
+	if len(modified) == 0 {
+		return nil
+	}
+
+	ids := d.PageIDs()
+	times := make([]time.Time, len(ids))
+	var last time.Time
+	for i, id := range ids {
+		if t, ok := modified[id]; ok {
+			last = t
+		}
+		times[i] = last
+	}
+	// the first pages without a time
+	for i := len(ids) - 1; i >= 0; i-- {
+		if times[i].IsZero() {
+			times[i] = last
+		} else {
+			last = times[i]
+		}
+	}
+	return times
+}
+
+// Parts splits the pages of the document by the period of the time they
+// were last written on, in the time zone loc, or into sessions of pages
+// written less than SessionGap apart. The parts are in chronological
+// order, a document without page times is a single undated part.
+func (d *RmDoc) Parts(by SplitBy, loc *time.Location) []DocumentPart {
+	ids := d.PageIDs()
+	times := d.pageTimes()
+	if times == nil {
+		return []DocumentPart{{Label: "undated", Pages: ids}}
+	}
+
+	labels := make([]string, len(ids))
+	switch by {
+	case SplitSession:
+		// the sessions are found on the pages in chronological order, each
+		// named after its first page
+		order := make([]int, len(ids))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return times[order[i]].Before(times[order[j]]) })
+		label := ""
+		for n, i := range order {
+			if n == 0 || times[i].Sub(times[order[n-1]]) > SessionGap {
+				label = times[i].In(loc).Format("2006-01-02 15.04")
+			}
+			labels[i] = label
+		}
+	default:
+		for i, t := range times {
+			labels[i] = periodLabel(t.In(loc), by)
+		}
+	}
+
+	byLabel := make(map[string]*DocumentPart)
+	var parts []*DocumentPart
+	for i, id := range ids {
+		part, ok := byLabel[labels[i]]
+		if !ok {
+			part = &DocumentPart{Label: labels[i]}
+			byLabel[labels[i]] = part
+			parts = append(parts, part)
+		}
+		part.Pages = append(part.Pages, id)
+	}
+	// the labels sort in chronological order
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i].Label < parts[j].Label })
+
+	result := make([]DocumentPart, len(parts))
+	for i, part := range parts {
+		result[i] = *part
+	}
+	return result
+}
+
+// periodLabel returns the day, ISO week or month of a time
+func periodLabel(t time.Time, by SplitBy) string {
+	switch by {
+	case SplitWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case SplitMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// WritePart writes a .rmdoc archive of the document with only the given
+// pages, to export them on their own. The files of the other pages are
+// left out, the .content file lists the given pages only.
+func (d *RmDoc) WritePart(w io.Writer, pages []string) error {
+	keep := make(map[string]bool, len(pages))
+	for _, id := range pages {
+		keep[id] = true
+	}
+	all := make(map[string]bool)
+	for _, id := range d.PageIDs() {
+		all[id] = true
+	}
+
+	content, err := d.ReadFile("content")
+	if err != nil {
+		return err
+	}
+	content, err = filterContentPages(content, keep)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(d.files))
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f := d.files[name]
+		if name == d.ID+".content" {
+			fw, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(content); err != nil {
+				return err
+			}
+			continue
+		}
+		// the files of a page are named after it in the folders of the
+		// document: <id>/<page>.rm, <id>/<page>-metadata.json,
+		// <id>.thumbnails/<page>.png...
+		if strings.Contains(name, "/") {
+			stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+			stem = strings.TrimSuffix(stem, "-metadata")
+			if all[stem] && !keep[stem] {
+				continue
+			}
+		}
+		if err := zw.Copy(f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// filterContentPages returns a .content file with the given pages only,
+// keeping its other fields
+func filterContentPages(data []byte, keep map[string]bool) ([]byte, error) {
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("bad .content file: %v: %w", err, model.ErrParse)
+	}
+
+	count := 0
+	if raw, ok := content["cPages"]; ok {
+		var cPages map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &cPages); err != nil {
+			return nil, fmt.Errorf("bad cPages: %v: %w", err, model.ErrParse)
+		}
+		var pages []json.RawMessage
+		if err := json.Unmarshal(cPages["pages"], &pages); err != nil {
+			return nil, fmt.Errorf("bad cPages: %v: %w", err, model.ErrParse)
+		}
+		var kept []json.RawMessage
+		for _, page := range pages {
+			var p ContentPage
+			if err := json.Unmarshal(page, &p); err == nil && keep[p.ID] {
+				kept = append(kept, page)
+				if !p.IsDeleted() {
+					count++
+				}
+			}
+		}
+		cPages["pages"], _ = json.Marshal(kept)
+		content["cPages"], _ = json.Marshal(cPages)
+	}
+
+	// older files list the pages and their background PDF page
+	var pages []string
+	if err := json.Unmarshal(content["pages"], &pages); err == nil && len(pages) > 0 {
+		var redirections []int
+		json.Unmarshal(content["redirectionPageMap"], &redirections)
+		var kept []string
+		var keptRedirections []int
+		for i, id := range pages {
+			if !keep[id] {
+				continue
+			}
+			kept = append(kept, id)
+			if i < len(redirections) {
+				keptRedirections = append(keptRedirections, redirections[i])
+			}
+		}
+		content["pages"], _ = json.Marshal(kept)
+		if len(redirections) > 0 {
+			content["redirectionPageMap"], _ = json.Marshal(keptRedirections)
+		}
+		count = len(kept)
+	}
+
+	content["pageCount"], _ = json.Marshal(count)
+	return json.MarshalIndent(content, "", "    ")
+}
+
+// SplitExport exports the parts of a document to the folder dir with the
+// exporter, one export per part named after its label, e.g.
+// dir/2024-05-01.pdf. The pages of the text of the result are numbered
+// across the parts, in the order of the parts.
+func SplitExport(goCtx context.Context, exporter Exporter, rmdocPath, dir string, by SplitBy, opts ExportOptions) (*ExportResult, error) {
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .rmdoc: %v", err)
+	}
+	defer doc.Close()
+
+	tempDir, err := os.MkdirTemp("", "rmdoc_split_*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	result := &ExportResult{}
+	pages := 0
+	for _, part := range doc.Parts(by, time.Local) {
+		if err := goCtx.Err(); err != nil {
+			return nil, err
+		}
+
+		partPath := filepath.Join(tempDir, part.Label+"."+util.RMDOC)
+		err := util.WriteFileAtomic(partPath, func(w io.Writer) error {
+			return doc.WritePart(w, part.Pages)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("part %s: %v", part.Label, err)
+		}
+		outPath := filepath.Join(dir, part.Label+"."+exporter.Extensions()[0])
+		partResult, err := exporter.Export(goCtx, partPath, outPath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("part %s: %v", part.Label, err)
+		}
+		os.Remove(partPath)
+
+		result.Files = append(result.Files, partResult.Files...)
+		for _, text := range partResult.Text {
+			text.Page += pages
+			result.Text = append(result.Text, text)
+		}
+		for _, page := range partResult.OCRTimeouts {
+			result.OCRTimeouts = append(result.OCRTimeouts, page+pages)
+		}
+		pages += len(part.Pages)
+	}
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// quickSheets writes a notebook whose pages were written on May 1st and
+// 3rd, out of order, the fourth page without a time
+func quickSheets(t *testing.T) string {
+	t.Helper()
+	modified := func(day, hour, minute int) string {
+		return fmt.Sprint(time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC).UnixMilli())
+	}
+	content := fmt.Sprintf(`{"fileType": "notebook", "pageCount": 5, "cPages": {"pages": [
+		{"id": "p1", "idx": {"value": "a"}, "modifed": "%s"},
+		{"id": "p2", "idx": {"value": "b"}, "modifed": "%s"},
+		{"id": "p3", "idx": {"value": "c"}, "modifed": "%s"},
+		{"id": "p4", "idx": {"value": "d"}},
+		{"id": "p5", "idx": {"value": "e"}, "modifed": "%s"}
+	]}}`, modified(1, 9, 0), modified(3, 10, 0), modified(1, 9, 30), modified(1, 15, 0))
+
+	files := map[string][]byte{
+		"doc.content":           []byte(content),
+		"doc.metadata":          []byte(`{"visibleName": "Quick sheets"}`),
+		"doc.thumbnails/p2.png": []byte("png"),
+	}
+	for _, id := range []string{"p1", "p2", "p3", "p4", "p5"} {
+		files["doc/"+id+".rm"] = encodeV6(toolsPageLines([]rm.BrushType{4}, []rm.BrushColor{0}), nil)
+		files["doc/"+id+"-metadata.json"] = []byte("{}")
+	}
+	return writeTestZip(t, files)
+}
+
+func TestParts(t *testing.T) {
+	doc, err := OpenRmDoc(quickSheets(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	for _, tt := range []struct {
+		by   SplitBy
+		want string
+	}{
+		{SplitDay, "2024-05-01: p1 p3 p4 p5, 2024-05-03: p2"},
+		{SplitWeek, "2024-W18: p1 p2 p3 p4 p5"},
+		{SplitMonth, "2024-05: p1 p2 p3 p4 p5"},
+		{SplitSession, "2024-05-01 09.00: p1 p3 p4, 2024-05-01 15.00: p5, 2024-05-03 10.00: p2"},
+	} {
+		var got []string
+		for _, part := range doc.Parts(tt.by, time.UTC) {
+			got = append(got, part.Label+": "+strings.Join(part.Pages, " "))
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.by, strings.Join(got, ", "), tt.want)
+		}
+	}
+
+	if _, err := ParseSplitBy("year"); err == nil {
+		t.Error("no error for an unknown split")
+	}
+}
+
+func TestSplitExport(t *testing.T) {
+	rmdocPath := quickSheets(t)
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	partPath := filepath.Join(t.TempDir(), "part.rmdoc")
+	f, err := os.Create(partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.WritePart(f, []string{"p3", "p5"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	part, err := OpenRmDoc(partPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+	if ids := part.PageIDs(); strings.Join(ids, ",") != "p3,p5" {
+		t.Errorf("unexpected pages %v", ids)
+	}
+	if part.Content.PageCount != 2 || part.Content.FileType != "notebook" {
+		t.Errorf("unexpected content %+v", part.Content)
+	}
+	for name := range part.files {
+		if strings.Contains(name, "p1") || strings.Contains(name, "p2") {
+			t.Errorf("file %s of another page", name)
+		}
+	}
+	if _, err := part.ReadFile("metadata"); err != nil {
+		t.Error(err)
+	}
+
+	exporter, err := LookupExporter("pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	result, err := SplitExport(context.Background(), exporter, rmdocPath, dir, SplitMonth, ExportOptions{DPI: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0] != filepath.Join(dir, "2024-05.pdf") {
+		t.Fatalf("unexpected files %v", result.Files)
+	}
+	if n, err := api.PageCountFile(result.Files[0]); err != nil || n != 5 {
+		t.Errorf("expected 5 pages, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,215 @@
+package rmconvert
+
+import (
+	"math"
+	"sort"
+)
+
+// device resolution, used to convert lengths to millimeters
+const deviceDPI = 226.0
+
+// coverageCell is the size in device pixels of the cells used to estimate
+// the ink coverage
+const coverageCell = 4.0
+
+// ToolUsage is the use of a tool with a color
+type ToolUsage struct {
+	Tool         string  `json:"tool"`
+	Color        string  `json:"color"`
+	Strokes      int     `json:"strokes"`
+	PathLengthMM float64 `json:"path_length_mm"`
+}
+
+// PageStats are the statistics of a page
+type PageStats struct {
+	Page         int     `json:"page"`
+	Strokes      int     `json:"strokes"`
+	PathLengthMM float64 `json:"path_length_mm"`
+	// InkCoverage is the percentage of the page covered by ink
+	InkCoverage float64     `json:"ink_coverage"`
+	Tools       []ToolUsage `json:"tools"`
+}
+
+// DocumentStats are the statistics of a document, the ink coverage is the
+// average of its pages
+type DocumentStats struct {
+	Pages        int         `json:"pages"`
+	Strokes      int         `json:"strokes"`
+	PathLengthMM float64     `json:"path_length_mm"`
+	InkCoverage  float64     `json:"ink_coverage"`
+	Tools        []ToolUsage `json:"tools"`
+	PerPage      []PageStats `json:"per_page"`
+}
+
+// AnalyzeRmdoc computes the statistics of the pages of a .rmdoc file
+func AnalyzeRmdoc(rmdocPath string) (*DocumentStats, error) {
+	pageOrder, pages, err := loadRmdocPages(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DocumentStats{Pages: len(pageOrder)}
+	var tools []ToolUsage
+	for i, id := range pageOrder {
+		pageStats := AnalyzePage(pages[id])
+		pageStats.Page = i + 1
+
+		stats.Strokes += pageStats.Strokes
+		stats.PathLengthMM += pageStats.PathLengthMM
+		stats.InkCoverage += pageStats.InkCoverage
+		tools = append(tools, pageStats.Tools...)
+		stats.PerPage = append(stats.PerPage, pageStats)
+	}
+
+	if stats.Pages > 0 {
+		stats.InkCoverage /= float64(stats.Pages)
+	}
+	stats.Tools = mergeToolUsage(tools)
+
+	return stats, nil
+}
+
+// AnalyzePage computes the statistics of a page, erasers are not counted
+// as ink
+func AnalyzePage(page *Page) PageStats {
+	var stats PageStats
+	var tools []ToolUsage
+
+	width, height := page.Width, page.Height
+	if width <= 0 || height <= 0 {
+		width, height = 1404, 1872
+	}
+	grid := newCoverageGrid(float64(width), float64(height))
+
+	for _, stroke := range page.Strokes {
+		if stroke.Tool == ToolEraser || len(stroke.Points) == 0 {
+			continue
+		}
+
+		length := strokeLength(stroke) / deviceDPI * 25.4
+		props := GetToolProperties(stroke.Tool, stroke.Color, stroke.Width)
+
+		stats.Strokes++
+		stats.PathLengthMM += length
+		tools = append(tools, ToolUsage{
+			Tool:         props.Name,
+			Color:        colorName(stroke.Color),
+			Strokes:      1,
+			PathLengthMM: length,
+		})
+		grid.add(stroke, float64(props.StrokeWidth))
+	}
+
+	stats.InkCoverage = grid.coverage()
+	stats.Tools = mergeToolUsage(tools)
+	return stats
+}
+
+// strokeLength returns the length of a stroke in device pixels
+func strokeLength(stroke Stroke) float64 {
+	var length float64
+	for i := 1; i < len(stroke.Points); i++ {
+		dx := float64(stroke.Points[i].X - stroke.Points[i-1].X)
+		dy := float64(stroke.Points[i].Y - stroke.Points[i-1].Y)
+		length += math.Hypot(dx, dy)
+	}
+	return length
+}
+
+func colorName(c int) string {
+	switch c {
+	case ColorGray:
+		return "gray"
+	case ColorWhite:
+		return "white"
+	default:
+		return "black"
+	}
+}
+
+// mergeToolUsage sums the usage of each tool and color, most used first
+func mergeToolUsage(usage []ToolUsage) []ToolUsage {
+	byKey := make(map[string]*ToolUsage)
+	var keys []string
+	for _, u := range usage {
+		key := u.Tool + "/" + u.Color
+		total, ok := byKey[key]
+		if !ok {
+			total = &ToolUsage{Tool: u.Tool, Color: u.Color}
+			byKey[key] = total
+			keys = append(keys, key)
+		}
+		total.Strokes += u.Strokes
+		total.PathLengthMM += u.PathLengthMM
+	}
+
+	result := make([]ToolUsage, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *byKey[key])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Strokes != result[j].Strokes {
+			return result[i].Strokes > result[j].Strokes
+		}
+		if result[i].Tool != result[j].Tool {
+			return result[i].Tool < result[j].Tool
+		}
+		return result[i].Color < result[j].Color
+	})
+	return result
+}
+
+// coverageGrid estimates the area covered by strokes by marking the cells
+// of a coarse grid they pass through
+type coverageGrid struct {
+	cols, rows int
+	cells      []bool
+}
+
+func newCoverageGrid(width, height float64) *coverageGrid {
+	cols := int(math.Ceil(width / coverageCell))
+	rows := int(math.Ceil(height / coverageCell))
+	return &coverageGrid{cols: cols, rows: rows, cells: make([]bool, cols*rows)}
+}
+
+// add marks the cells within half the stroke width of its segments
+func (g *coverageGrid) add(stroke Stroke, width float64) {
+	radius := math.Max(width/2, coverageCell/2)
+	mark := func(x, y float64) {
+		minCol := int((x - radius) / coverageCell)
+		maxCol := int((x + radius) / coverageCell)
+		minRow := int((y - radius) / coverageCell)
+		maxRow := int((y + radius) / coverageCell)
+		for row := max(minRow, 0); row <= min(maxRow, g.rows-1); row++ {
+			for col := max(minCol, 0); col <= min(maxCol, g.cols-1); col++ {
+				g.cells[row*g.cols+col] = true
+			}
+		}
+	}
+
+	points := stroke.Points
+	mark(float64(points[0].X), float64(points[0].Y))
+	for i := 1; i < len(points); i++ {
+		x0, y0 := float64(points[i-1].X), float64(points[i-1].Y)
+		x1, y1 := float64(points[i].X), float64(points[i].Y)
+		steps := int(math.Hypot(x1-x0, y1-y0)/(coverageCell/2)) + 1
+		for s := 1; s <= steps; s++ {
+			t := float64(s) / float64(steps)
+			mark(x0+(x1-x0)*t, y0+(y1-y0)*t)
+		}
+	}
+}
+
+// coverage returns the percentage of marked cells
+func (g *coverageGrid) coverage() float64 {
+	if len(g.cells) == 0 {
+		return 0
+	}
+	n := 0
+	for _, c := range g.cells {
+		if c {
+			n++
+		}
+	}
+	return float64(n) * 100 / float64(len(g.cells))
+}
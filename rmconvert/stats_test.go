@@ -0,0 +1,31 @@
+package rmconvert
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnalyzePage(t *testing.T) {
+	page := &Page{Width: 1404, Height: 1872, Strokes: []Stroke{
+		{Tool: ToolBallpoint, Color: ColorBlack, Width: 2, Points: []Point{{X: 0, Y: 0}, {X: 226, Y: 0}}},
+		{Tool: ToolMarker, Color: ColorGray, Width: 2, Points: []Point{{X: 0, Y: 100}, {X: 0, Y: 326}}},
+		{Tool: ToolBallpoint, Color: ColorBlack, Width: 2, Points: []Point{{X: 0, Y: 200}, {X: 226, Y: 200}}},
+		{Tool: ToolEraser, Width: 2, Points: []Point{{X: 0, Y: 0}, {X: 1000, Y: 1000}}},
+	}}
+
+	stats := AnalyzePage(page)
+	if stats.Strokes != 3 {
+		t.Errorf("expected 3 strokes, got %d", stats.Strokes)
+	}
+	// three strokes of one inch
+	if math.Abs(stats.PathLengthMM-3*25.4) > 0.01 {
+		t.Errorf("wrong path length %f", stats.PathLengthMM)
+	}
+	if stats.InkCoverage <= 0 || stats.InkCoverage > 1 {
+		t.Errorf("unexpected ink coverage %f", stats.InkCoverage)
+	}
+	if len(stats.Tools) != 2 || stats.Tools[0].Tool != "ballpoint" || stats.Tools[0].Strokes != 2 ||
+		stats.Tools[1].Tool != "marker" || stats.Tools[1].Color != "gray" {
+		t.Errorf("wrong tool usage %+v", stats.Tools)
+	}
+}
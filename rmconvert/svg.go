@@ -0,0 +1,345 @@
+package rmconvert
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tdewolff/canvas"
+)
+
+// SVGOptions control the size of the generated SVG
+type SVGOptions struct {
+	// Tolerance is the maximum distance in device pixels between a stroke
+	// and its simplified path (Ramer-Douglas-Peucker), 0 keeps every point
+	Tolerance float64
+	// Precision is the number of decimals of the coordinates, negative for
+	// full precision
+	Precision int
+	// Absolute writes absolute path commands instead of relative ones
+	Absolute bool
+	// Invert draws the page white on black
+	Invert bool
+	// Background fills the page, see ParseBackground. If nil the page has
+	// no background, or a black one when inverted.
+	Background color.Color
+	// Crop trims the document to the strokes and typed text of the page,
+	// with a margin of CropMargin device pixels
+	Crop       bool
+	CropMargin float64
+
+	// marks are drawn over the page, see ExportOptions.forPage
+	marks pageMarks
+}
+
+// DefaultSVGOptions drop the points closer than half a device pixel from
+// the path and round coordinates to a tenth of a pixel
+var DefaultSVGOptions = SVGOptions{Tolerance: 0.5, Precision: 1}
+
+// GenerateSVG writes the page as an SVG document with one group per layer,
+// marked as layers for Inkscape. Coordinates are device pixels and the
+// document has the physical size of the page, or of its content when
+// cropped.
+func (page *Page) GenerateSVG(w io.Writer, opts SVGOptions) error {
+	width, height := page.Width, page.Height
+	if width <= 0 || height <= 0 {
+		width, height = 1404, 1872
+	}
+	view := canvas.Rect{X1: float64(width), Y1: float64(height)}
+	if bounds, ok := page.cropBounds(view.X1, view.Y1, opts.CropMargin); ok && opts.Crop {
+		view = bounds
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:inkscape=\"http://www.inkscape.org/namespaces/inkscape\" width=\"%.2fmm\" height=\"%.2fmm\" viewBox=\"%g %g %g %g\">\n",
+		view.W()/deviceDPI*25.4, view.H()/deviceDPI*25.4, view.X0, view.Y0, view.W(), view.H())
+	textColor := "black"
+	if opts.Invert {
+		textColor = "white"
+	}
+	if background := opts.Background; background != nil || opts.Invert {
+		if background = backgroundColor(background, opts.Invert); !isTransparent(background) {
+			fmt.Fprintf(bw, "<rect x=\"%g\" y=\"%g\" width=\"%g\" height=\"%g\" fill=\"%s\"/>\n", view.X0, view.Y0, view.W(), view.H(), svgColor(background))
+		}
+	}
+
+	layers := page.Layers
+	if len(layers) == 0 {
+		layers = []Layer{{Name: "Layer 1"}}
+	}
+	byLayer := make([][]*Stroke, len(layers))
+	for i := range page.Strokes {
+		stroke := &page.Strokes[i]
+		layer := stroke.Layer
+		if layer < 0 || layer >= len(layers) {
+			layer = 0
+		}
+		byLayer[layer] = append(byLayer[layer], stroke)
+	}
+
+	spans := page.typedText()
+	marks := opts.marks.layout(view)
+	if len(spans) > 0 || len(marks) > 0 {
+		fontSpans := spans
+		for _, mark := range marks {
+			fontSpans = append(fontSpans, typedTextSpan{Text: mark.Text})
+		}
+		if err := writeSVGFonts(bw, fontSpans); err != nil {
+			return err
+		}
+	}
+	if len(spans) > 0 {
+		fmt.Fprintf(bw, "<g id=\"text\" inkscape:groupmode=\"layer\" inkscape:label=\"Text\" font-family=\"'%s', sans-serif\" fill=\"%s\">\n", html.EscapeString(TextFont.Name), textColor)
+		for _, span := range spans {
+			writeSVGText(bw, span)
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+
+	for i, layer := range layers {
+		display := "inline"
+		if layer.Hidden {
+			display = "none"
+		}
+		// attributes common to the strokes are inherited from the layer
+		fmt.Fprintf(bw, "<g id=\"layer%d\" inkscape:groupmode=\"layer\" inkscape:label=\"%s\" style=\"display:%s\" fill=\"none\" stroke-linecap=\"round\" stroke-linejoin=\"round\">\n",
+			i+1, html.EscapeString(layer.Name), display)
+		for _, stroke := range byLayer[i] {
+			writeSVGStroke(bw, stroke, opts)
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+
+	if len(marks) > 0 {
+		fill := pageMarkColor
+		if opts.Invert {
+			fill = invertColor(fill)
+		}
+		fmt.Fprintf(bw, "<g id=\"marks\" font-family=\"'%s', sans-serif\" font-size=\"%d\" fill=\"%s\">\n", html.EscapeString(TextFont.Name), pageMarkSize, svgColor(fill))
+		for _, mark := range marks {
+			anchor := "middle"
+			if mark.Align == canvas.Right {
+				anchor = "end"
+			}
+			fmt.Fprintf(bw, "<text x=\"%g\" y=\"%g\" text-anchor=\"%s\" xml:space=\"preserve\">%s</text>\n", mark.X, mark.Y, anchor, html.EscapeString(mark.Text))
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// writeSVGFonts embeds the styles of TextFont used by the typed text, with
+// only the glyphs of its spans, so that the SVG looks the same everywhere.
+// The styles the font doesn't have are synthesized from the regular one by
+// the viewers.
+func writeSVGFonts(w *bufio.Writer, spans []typedTextSpan) error {
+	texts := map[canvas.FontStyle]string{}
+	for _, span := range spans {
+		style := fontStyle(span.Bold, span.Italic)
+		if _, ok := TextFont.files[style]; !ok {
+			style = canvas.FontRegular
+		}
+		texts[style] += span.Text
+	}
+	styles := make([]canvas.FontStyle, 0, len(texts))
+	for style := range texts {
+		styles = append(styles, style)
+	}
+	sort.Slice(styles, func(i, j int) bool { return styles[i] < styles[j] })
+
+	fmt.Fprintf(w, "<defs><style>\n")
+	for _, style := range styles {
+		subset, err := TextFont.subset(style, texts[style], false)
+		if err != nil {
+			return fmt.Errorf("failed to embed the font %s: %v", TextFont.Name, err)
+		}
+		mediaType, format := "font/ttf", "truetype"
+		if subset.cff {
+			mediaType, format = "font/otf", "opentype"
+		}
+		weight, fontStyle := "normal", "normal"
+		if style.Weight() == canvas.FontBold {
+			weight = "bold"
+		}
+		if style.Italic() {
+			fontStyle = "italic"
+		}
+		fmt.Fprintf(w, "@font-face { font-family: '%s'; font-weight: %s; font-style: %s; src: url(data:%s;base64,%s) format('%s'); }\n",
+			html.EscapeString(TextFont.Name), weight, fontStyle, mediaType, base64.StdEncoding.EncodeToString(subset.data), format)
+	}
+	fmt.Fprintf(w, "</style></defs>\n")
+	return nil
+}
+
+func writeSVGText(w *bufio.Writer, span typedTextSpan) {
+	fmt.Fprintf(w, "<text x=\"%g\" y=\"%g\" font-size=\"%g\"", roundTo(span.X, 1), roundTo(span.Y, 1), span.Size)
+	if span.Bold {
+		fmt.Fprintf(w, " font-weight=\"bold\"")
+	}
+	if span.Italic {
+		fmt.Fprintf(w, " font-style=\"italic\"")
+	}
+	fmt.Fprintf(w, " xml:space=\"preserve\">%s</text>\n", html.EscapeString(span.Text))
+}
+
+func writeSVGStroke(w *bufio.Writer, stroke *Stroke, opts SVGOptions) {
+	if len(stroke.Points) == 0 {
+		return
+	}
+
+	props := GetToolProperties(stroke.Tool, stroke.Color, stroke.Width)
+	strokeColor := props.Color
+	if opts.Invert {
+		strokeColor = svgColor(invertColor(parseColor(props.Color)))
+	}
+	fmt.Fprintf(w, "<path stroke=\"%s\" stroke-width=\"%g\"", strokeColor, props.StrokeWidth)
+	if props.Opacity < 1 {
+		fmt.Fprintf(w, " stroke-opacity=\"%g\"", props.Opacity)
+	}
+	fmt.Fprintf(w, " d=\"")
+	w.WriteString(svgPathData(simplifyPoints(stroke.Points, opts.Tolerance), opts))
+	fmt.Fprintf(w, "\"/>\n")
+}
+
+// svgPathData returns the path through the points, a dot is drawn as a
+// zero length line
+func svgPathData(points []Point, opts SVGOptions) string {
+	var b strings.Builder
+	// last rounded position, relative moves are computed from it so that
+	// rounding errors don't add up
+	var x, y float64
+
+	writeNumbers := func(values ...float64) {
+		for i, v := range values {
+			s := formatSVGNumber(v, opts.Precision)
+			if i > 0 && !strings.HasPrefix(s, "-") {
+				b.WriteByte(' ')
+			}
+			b.WriteString(s)
+		}
+	}
+
+	for i, p := range points {
+		px := roundTo(float64(p.X), opts.Precision)
+		py := roundTo(float64(p.Y), opts.Precision)
+		switch {
+		case i == 0:
+			b.WriteByte('M')
+			writeNumbers(px, py)
+		case opts.Absolute:
+			if i == 1 {
+				b.WriteByte('L')
+			} else {
+				b.WriteByte(' ')
+			}
+			writeNumbers(px, py)
+		default:
+			if i == 1 {
+				b.WriteByte('l')
+			} else if s := formatSVGNumber(px-x, opts.Precision); !strings.HasPrefix(s, "-") {
+				b.WriteByte(' ')
+			}
+			writeNumbers(px-x, py-y)
+		}
+		x, y = px, py
+	}
+
+	if len(points) == 1 {
+		b.WriteString("l0 0")
+	}
+	return b.String()
+}
+
+// roundTo rounds v to a number of decimals, negative for no rounding
+func roundTo(v float64, decimals int) float64 {
+	if decimals < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
+
+// formatSVGNumber formats a coordinate as short as possible: no trailing
+// zeros and no leading zero before the decimal point
+func formatSVGNumber(v float64, decimals int) string {
+	v = roundTo(v, decimals)
+	if v == 0 {
+		return "0"
+	}
+	// with full precision, float32 is the precision of the coordinates
+	s := strconv.FormatFloat(v, 'f', -1, 32)
+	if decimals >= 0 {
+		s = strconv.FormatFloat(v, 'f', decimals, 64)
+	}
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if strings.HasPrefix(s, "0.") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "-0.") {
+		s = "-" + s[2:]
+	}
+	return s
+}
+
+// simplifyPoints drops the points of a stroke that are within tolerance of
+// the line through their neighbours (Ramer-Douglas-Peucker)
+func simplifyPoints(points []Point, tolerance float64) []Point {
+	if tolerance <= 0 || len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// ranges still to simplify, iterative to bound the stack on long strokes
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		first, last := r[0], r[1]
+
+		maxDist, index := 0.0, -1
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > maxDist {
+				maxDist, index = d, i
+			}
+		}
+		if index >= 0 && maxDist > tolerance {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	result := make([]Point, 0, len(points))
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// segmentDistance returns the distance from p to the segment a-b
+func segmentDistance(p, a, b Point) float64 {
+	px, py := float64(p.X), float64(p.Y)
+	ax, ay := float64(a.X), float64(a.Y)
+	dx, dy := float64(b.X)-ax, float64(b.Y)-ay
+
+	lengthSq := dx*dx + dy*dy
+	if lengthSq == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	t := math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/lengthSq))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
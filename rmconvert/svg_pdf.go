@@ -0,0 +1,610 @@
+package rmconvert
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juruen/rmapi/util"
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers"
+)
+
+// ConvertSVGToPDF converts an SVG file to a one page PDF with the size of
+// the SVG document, keeping its vector paths
+func ConvertSVGToPDF(svgPath, pdfPath string) error {
+	f, err := os.Open(svgPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c, err := ParseSVG(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", svgPath, err)
+	}
+
+	return util.WriteFileAtomic(pdfPath, func(w io.Writer) error {
+		return c.Write(w, renderers.PDF())
+	})
+}
+
+// svgConverters are the arguments of the external tools that can convert
+// an SVG file to PDF, for the features ParseSVG doesn't support (text,
+// gradients...)
+var svgConverters = map[string]func(svgPath, pdfPath string) []string{
+	"rsvg-convert": func(svgPath, pdfPath string) []string {
+		return []string{"-f", "pdf", "-o", pdfPath, svgPath}
+	},
+	"inkscape": func(svgPath, pdfPath string) []string {
+		return []string{"--export-type=pdf", "--export-filename=" + pdfPath, svgPath}
+	},
+	"cairosvg": func(svgPath, pdfPath string) []string {
+		return []string{svgPath, "-o", pdfPath}
+	},
+}
+
+// SVGConverterNames returns the external tools ConvertSVGToPDFExternal can
+// run, sorted by name
+func SVGConverterNames() []string {
+	names := make([]string, 0, len(svgConverters))
+	for name := range svgConverters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConvertSVGToPDFExternal converts an SVG file to PDF with an external tool
+// (see SVGConverterNames), the tool is killed if goCtx is cancelled. An
+// empty tool uses the native ConvertSVGToPDF.
+func ConvertSVGToPDFExternal(goCtx context.Context, tool, svgPath, pdfPath string) error {
+	if tool == "" {
+		return ConvertSVGToPDF(svgPath, pdfPath)
+	}
+
+	args, ok := svgConverters[tool]
+	if !ok {
+		return fmt.Errorf("unknown svg converter %q (available: %s)", tool, strings.Join(SVGConverterNames(), ", "))
+	}
+	toolPath, err := exec.LookPath(tool)
+	if err != nil {
+		return fmt.Errorf("%s not found: %v", tool, err)
+	}
+
+	cmd := exec.CommandContext(goCtx, toolPath, args(svgPath, pdfPath)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", tool, err, string(output))
+	}
+	return nil
+}
+
+// ParseSVG draws an SVG document on a canvas whose units are millimeters.
+//
+// Shapes (path with every command, line, polyline, polygon, rect, circle,
+// ellipse), groups, transforms, solid fills and strokes, opacity and hidden
+// elements are supported. Text, images, gradients, clipping and <use>
+// references are ignored.
+func ParseSVG(r io.Reader) (*canvas.Canvas, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+
+	var c *canvas.Canvas
+	var ctx *canvas.Context
+	var styles []svgStyle
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			attrs := svgAttributes(t)
+
+			if c == nil {
+				if t.Name.Local != "svg" {
+					return nil, fmt.Errorf("expected an svg element, got %s", t.Name.Local)
+				}
+				var view canvas.Matrix
+				c, view = newSVGCanvas(attrs)
+				ctx = canvas.NewContext(c)
+				ctx.SetCoordSystem(canvas.CartesianIV)
+
+				style := defaultSVGStyle
+				style.view = view
+				styles = append(styles, style.apply(attrs))
+				continue
+			}
+
+			if svgIgnoredElements[t.Name.Local] {
+				if err := d.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			style := styles[len(styles)-1].apply(attrs)
+			if style.display == "none" {
+				if err := d.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			styles = append(styles, style)
+
+			if err := drawSVGShape(ctx, t.Name.Local, attrs, style); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			if len(styles) > 0 {
+				styles = styles[:len(styles)-1]
+			}
+		}
+	}
+
+	if c == nil {
+		return nil, fmt.Errorf("no svg element found")
+	}
+	return c, nil
+}
+
+// elements whose content is not drawn
+var svgIgnoredElements = map[string]bool{
+	"defs": true, "symbol": true, "clipPath": true, "mask": true, "pattern": true,
+	"marker": true, "linearGradient": true, "radialGradient": true, "filter": true,
+	"style": true, "script": true, "title": true, "desc": true, "metadata": true,
+	"text": true, "image": true, "foreignObject": true,
+}
+
+// svgStyle holds the presentation attributes in effect for an element
+type svgStyle struct {
+	fill, stroke     color.NRGBA
+	noFill, noStroke bool
+	strokeWidth      float64
+	// opacity is the product of the opacity of the element and its
+	// ancestors
+	opacity       float64
+	fillOpacity   float64
+	strokeOpacity float64
+	lineCap       string
+	lineJoin      string
+	visibility    string
+	display       string
+	// view maps the user units of the element to millimeters
+	view canvas.Matrix
+}
+
+var defaultSVGStyle = svgStyle{
+	fill:          color.NRGBA{0, 0, 0, 255},
+	noStroke:      true,
+	strokeWidth:   1,
+	opacity:       1,
+	fillOpacity:   1,
+	strokeOpacity: 1,
+	lineCap:       "butt",
+	lineJoin:      "miter",
+	visibility:    "visible",
+	view:          canvas.Identity,
+}
+
+// apply returns the style of a child element with the given attributes,
+// the style attribute has precedence over presentation attributes
+func (s svgStyle) apply(attrs map[string]string) svgStyle {
+	// not inherited
+	s.display = ""
+
+	props := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		props[k] = v
+	}
+	for _, decl := range strings.Split(attrs["style"], ";") {
+		if k, v, ok := strings.Cut(decl, ":"); ok {
+			props[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	if v, ok := props["fill"]; ok {
+		if col, none, ok := parseSVGColor(v); ok {
+			s.fill, s.noFill = col, none
+		}
+	}
+	if v, ok := props["stroke"]; ok {
+		if col, none, ok := parseSVGColor(v); ok {
+			s.stroke, s.noStroke = col, none
+		}
+	}
+	if v, ok := props["stroke-width"]; ok {
+		if w, err := parseSVGLength(v, 0); err == nil {
+			s.strokeWidth = w
+		}
+	}
+	if v, ok := props["opacity"]; ok {
+		s.opacity *= parseSVGOpacity(v)
+	}
+	if v, ok := props["fill-opacity"]; ok {
+		s.fillOpacity = parseSVGOpacity(v)
+	}
+	if v, ok := props["stroke-opacity"]; ok {
+		s.strokeOpacity = parseSVGOpacity(v)
+	}
+	if v, ok := props["stroke-linecap"]; ok {
+		s.lineCap = v
+	}
+	if v, ok := props["stroke-linejoin"]; ok {
+		s.lineJoin = v
+	}
+	if v, ok := props["visibility"]; ok {
+		s.visibility = v
+	}
+	if v, ok := props["display"]; ok {
+		s.display = v
+	}
+	if v, ok := attrs["transform"]; ok {
+		if m, err := parseSVGTransform(v); err == nil {
+			s.view = s.view.Mul(m)
+		}
+	}
+	return s
+}
+
+// newSVGCanvas creates a canvas with the size of the svg element and the
+// view mapping its viewBox to millimeters
+func newSVGCanvas(attrs map[string]string) (*canvas.Canvas, canvas.Matrix) {
+	var viewBox []float64
+	if v, ok := attrs["viewBox"]; ok {
+		viewBox, _ = parseSVGNumbers(v)
+	}
+	hasViewBox := len(viewBox) == 4 && viewBox[2] > 0 && viewBox[3] > 0
+
+	width, errW := parseSVGLength(attrs["width"], 0)
+	height, errH := parseSVGLength(attrs["height"], 0)
+	if errW != nil || width <= 0 || strings.HasSuffix(attrs["width"], "%") {
+		width = 0
+	}
+	if errH != nil || height <= 0 || strings.HasSuffix(attrs["height"], "%") {
+		height = 0
+	}
+	switch {
+	case hasViewBox && width == 0 && height == 0:
+		width, height = viewBox[2], viewBox[3]
+	case hasViewBox && width == 0:
+		width = height * viewBox[2] / viewBox[3]
+	case hasViewBox && height == 0:
+		height = width * viewBox[3] / viewBox[2]
+	case width == 0 || height == 0:
+		width, height = 300, 150
+	}
+
+	// user units are CSS pixels
+	const mmPerPx = 25.4 / 96
+	view := canvas.Identity.Scale(mmPerPx, mmPerPx)
+	if hasViewBox {
+		// preserveAspectRatio="xMidYMid meet", the default
+		scale := math.Min(width/viewBox[2], height/viewBox[3])
+		dx := (width - viewBox[2]*scale) / 2
+		dy := (height - viewBox[3]*scale) / 2
+		view = view.Translate(dx, dy).Scale(scale, scale).Translate(-viewBox[0], -viewBox[1])
+	}
+
+	return canvas.New(width*mmPerPx, height*mmPerPx), view
+}
+
+// drawSVGShape draws a shape element, other elements are ignored
+func drawSVGShape(ctx *canvas.Context, name string, attrs map[string]string, style svgStyle) error {
+	length := func(key string) float64 {
+		v, _ := parseSVGLength(attrs[key], 0)
+		return v
+	}
+
+	var path *canvas.Path
+	var x, y float64
+	switch name {
+	case "path":
+		if strings.TrimSpace(attrs["d"]) == "" {
+			return nil
+		}
+		p, err := canvas.ParseSVGPath(attrs["d"])
+		if err != nil {
+			return fmt.Errorf("bad path data: %v", err)
+		}
+		path = p
+	case "line":
+		path = &canvas.Path{}
+		path.MoveTo(length("x1"), length("y1"))
+		path.LineTo(length("x2"), length("y2"))
+	case "polyline", "polygon":
+		points, err := parseSVGNumbers(attrs["points"])
+		if err != nil || len(points) < 4 {
+			return nil
+		}
+		path = &canvas.Path{}
+		path.MoveTo(points[0], points[1])
+		for i := 2; i+1 < len(points); i += 2 {
+			path.LineTo(points[i], points[i+1])
+		}
+		if name == "polygon" {
+			path.Close()
+		}
+	case "rect":
+		w, h := length("width"), length("height")
+		if w <= 0 || h <= 0 {
+			return nil
+		}
+		x, y = length("x"), length("y")
+		if r := math.Max(length("rx"), length("ry")); r > 0 {
+			path = canvas.RoundedRectangle(w, h, math.Min(r, math.Min(w, h)/2))
+		} else {
+			path = canvas.Rectangle(w, h)
+		}
+	case "circle":
+		r := length("r")
+		if r <= 0 {
+			return nil
+		}
+		x, y = length("cx"), length("cy")
+		path = canvas.Circle(r)
+	case "ellipse":
+		rx, ry := length("rx"), length("ry")
+		if rx <= 0 || ry <= 0 {
+			return nil
+		}
+		x, y = length("cx"), length("cy")
+		path = canvas.Ellipse(rx, ry)
+	default:
+		return nil
+	}
+
+	if style.visibility == "hidden" || style.visibility == "collapse" {
+		return nil
+	}
+
+	ctx.Push()
+	defer ctx.Pop()
+
+	ctx.SetView(style.view)
+	ctx.SetFill(nil)
+	if !style.noFill {
+		ctx.SetFillColor(withOpacity(style.fill, style.opacity*style.fillOpacity))
+	}
+	ctx.SetStroke(nil)
+	if !style.noStroke {
+		ctx.SetStrokeColor(withOpacity(style.stroke, style.opacity*style.strokeOpacity))
+		ctx.SetStrokeWidth(style.strokeWidth)
+		switch style.lineCap {
+		case "round":
+			ctx.SetStrokeCapper(canvas.RoundCap)
+		case "square":
+			ctx.SetStrokeCapper(canvas.SquareCap)
+		default:
+			ctx.SetStrokeCapper(canvas.ButtCap)
+		}
+		switch style.lineJoin {
+		case "round":
+			ctx.SetStrokeJoiner(canvas.RoundJoin)
+		case "bevel":
+			ctx.SetStrokeJoiner(canvas.BevelJoin)
+		default:
+			ctx.SetStrokeJoiner(canvas.MiterJoin)
+		}
+	}
+
+	ctx.DrawPath(x, y, path)
+	return nil
+}
+
+func withOpacity(c color.NRGBA, opacity float64) color.NRGBA {
+	c.A = uint8(math.Round(float64(c.A) * clamp(opacity, 0, 1)))
+	return c
+}
+
+func svgAttributes(e xml.StartElement) map[string]string {
+	attrs := make(map[string]string, len(e.Attr))
+	for _, a := range e.Attr {
+		// namespaced attributes like inkscape:label are not presentation
+		// attributes, xlink:href is the only one that could matter
+		if a.Name.Space != "" && a.Name.Local != "href" {
+			continue
+		}
+		attrs[a.Name.Local] = a.Value
+	}
+	return attrs
+}
+
+// parseSVGLength parses a length in user units (CSS pixels), percentages
+// are relative to ref
+func parseSVGLength(s string, ref float64) (float64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"px", 1}, {"mm", 96 / 25.4}, {"cm", 96 / 2.54}, {"in", 96},
+		{"pt", 96.0 / 72}, {"pc", 16}, {"%", ref / 100},
+	}
+	scale := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * scale, nil
+}
+
+func parseSVGOpacity(s string) float64 {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 0.01
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 1
+	}
+	return clamp(v*scale, 0, 1)
+}
+
+// parseSVGNumbers parses a list of numbers separated by spaces or commas
+func parseSVGNumbers(s string) ([]float64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	result := make([]float64, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// parseSVGTransform parses a transform list, e.g.
+// "translate(10 20) rotate(45)"
+func parseSVGTransform(s string) (canvas.Matrix, error) {
+	m := canvas.Identity
+	for {
+		open := strings.IndexByte(s, '(')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(s, ')')
+		if end < open {
+			return m, fmt.Errorf("bad transform %q", s)
+		}
+		name := strings.Trim(strings.TrimSpace(s[:open]), ",")
+		args, err := parseSVGNumbers(s[open+1 : end])
+		if err != nil {
+			return m, err
+		}
+		s = s[end+1:]
+
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+		switch strings.TrimSpace(name) {
+		case "matrix":
+			if len(args) != 6 {
+				return m, fmt.Errorf("bad matrix transform")
+			}
+			m = m.Mul(canvas.Matrix{{args[0], args[2], args[4]}, {args[1], args[3], args[5]}})
+		case "translate":
+			m = m.Translate(arg(0, 0), arg(1, 0))
+		case "scale":
+			m = m.Scale(arg(0, 1), arg(1, arg(0, 1)))
+		case "rotate":
+			m = m.RotateAbout(arg(0, 0), arg(1, 0), arg(2, 0))
+		case "skewX":
+			m = m.Shear(math.Tan(arg(0, 0)*math.Pi/180), 0)
+		case "skewY":
+			m = m.Shear(0, math.Tan(arg(0, 0)*math.Pi/180))
+		default:
+			return m, fmt.Errorf("unknown transform %s", name)
+		}
+	}
+	return m, nil
+}
+
+// svgColors are the named colors most used in SVG files
+var svgColors = map[string]color.NRGBA{
+	"black":   {0, 0, 0, 255},
+	"white":   {255, 255, 255, 255},
+	"gray":    {128, 128, 128, 255},
+	"grey":    {128, 128, 128, 255},
+	"silver":  {192, 192, 192, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 128, 0, 255},
+	"lime":    {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"navy":    {0, 0, 128, 255},
+	"yellow":  {255, 255, 0, 255},
+	"orange":  {255, 165, 0, 255},
+	"purple":  {128, 0, 128, 255},
+	"magenta": {255, 0, 255, 255},
+	"fuchsia": {255, 0, 255, 255},
+	"cyan":    {0, 255, 255, 255},
+	"aqua":    {0, 255, 255, 255},
+	"maroon":  {128, 0, 0, 255},
+	"olive":   {128, 128, 0, 255},
+	"teal":    {0, 128, 128, 255},
+	"pink":    {255, 192, 203, 255},
+	"brown":   {165, 42, 42, 255},
+}
+
+// parseSVGColor parses a paint value: a color, none or transparent. ok is
+// false for values that are not supported (e.g. gradients), which keep the
+// inherited paint.
+func parseSVGColor(s string) (col color.NRGBA, none bool, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "none" || s == "transparent":
+		return col, true, true
+	case strings.HasPrefix(s, "#"):
+		hex := s[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var long strings.Builder
+			for _, c := range hex {
+				long.WriteRune(c)
+				long.WriteRune(c)
+			}
+			hex = long.String()
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		if len(hex) != 8 {
+			return col, false, false
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return col, false, false
+		}
+		return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, false, true
+	case strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba("):
+		inner := strings.TrimSuffix(s[strings.IndexByte(s, '(')+1:], ")")
+		parts := strings.FieldsFunc(inner, func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+		if len(parts) < 3 {
+			return col, false, false
+		}
+		channel := func(p string) uint8 {
+			if strings.HasSuffix(p, "%") {
+				v, _ := strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64)
+				return uint8(math.Round(clamp(v, 0, 100) * 2.55))
+			}
+			v, _ := strconv.ParseFloat(p, 64)
+			return uint8(math.Round(clamp(v, 0, 255)))
+		}
+		col = color.NRGBA{channel(parts[0]), channel(parts[1]), channel(parts[2]), 255}
+		if len(parts) > 3 {
+			col.A = uint8(math.Round(parseSVGOpacity(parts[3]) * 255))
+		}
+		return col, false, true
+	}
+
+	if c, found := svgColors[s]; found {
+		return c, false, true
+	}
+	return col, false, false
+}
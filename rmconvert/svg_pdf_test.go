@@ -0,0 +1,116 @@
+package rmconvert
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers/rasterizer"
+)
+
+func TestParseSVG(t *testing.T) {
+	// 96 px wide, 1 px is 1/96 in
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="1in" height="1in" viewBox="0 0 48 48">
+<g transform="translate(24 0)" style="fill:#ff0000">
+  <rect width="24" height="24"/>
+  <g display="none"><rect width="48" height="48" fill="blue"/></g>
+</g>
+<path d="M0 24C8 24 8 48 0 48z" fill="rgb(0, 0, 255)" opacity="0.5"/>
+<circle cx="36" cy="36" r="6" fill="none" stroke="lime" stroke-width="2"/>
+</svg>`
+
+	c, err := ParseSVG(strings.NewReader(svg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := c.Size(); math.Abs(w-25.4) > 1e-6 || math.Abs(h-25.4) > 1e-6 {
+		t.Fatalf("wrong size %gx%g", w, h)
+	}
+
+	// 2 pixels per user unit
+	img := rasterizer.Draw(c, canvas.DPI(96), canvas.DefaultColorSpace)
+	tests := []struct {
+		x, y    int
+		r, g, b uint32
+	}{
+		{72, 24, 0xff, 0, 0}, // translated rect
+		{24, 24, 0, 0, 0},    // not covered, the hidden rect is skipped
+		{2, 72, 0, 0, 0x80},  // half transparent curve
+		{72, 60, 0, 0xff, 0}, // stroke of the circle
+		{72, 72, 0, 0, 0},    // no fill
+	}
+	for _, tt := range tests {
+		r, g, b, _ := img.At(tt.x, tt.y).RGBA()
+		if r>>8 != tt.r || g>>8 != tt.g || abs(float64(b>>8)-float64(tt.b)) > 1 {
+			t.Errorf("pixel %d,%d: got %02x%02x%02x, want %02x%02x%02x", tt.x, tt.y, r>>8, g>>8, b>>8, tt.r, tt.g, tt.b)
+		}
+	}
+}
+
+func TestParseGeneratedSVG(t *testing.T) {
+	page := &Page{
+		Width:  1404,
+		Height: 1872,
+		Strokes: []Stroke{
+			{Tool: ToolFineliner, Width: 2, Points: []Point{{X: 10, Y: 10}, {X: 200, Y: 300}}},
+			{Tool: ToolHighlighter, Width: 2, Points: []Point{{X: 30, Y: 30}}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := page.GenerateSVG(&buf, DefaultSVGOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ParseSVG(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, h := c.Size(); math.Abs(w-1404/deviceDPI*25.4) > 0.01 || math.Abs(h-1872/deviceDPI*25.4) > 0.01 {
+		t.Errorf("wrong size %gx%g", w, h)
+	}
+	if c.Empty() {
+		t.Errorf("strokes not drawn")
+	}
+}
+
+func TestParseSVGTransform(t *testing.T) {
+	m, err := parseSVGTransform("translate(10,20) scale(2) rotate(90)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := m.Dot(canvas.Point{X: 1, Y: 0})
+	if math.Abs(p.X-10) > 1e-9 || math.Abs(p.Y-22) > 1e-9 {
+		t.Errorf("got %v", p)
+	}
+
+	if _, err := parseSVGTransform("spin(3)"); err == nil {
+		t.Errorf("expected an error for an unknown transform")
+	}
+}
+
+func TestConvertSVGToPDFExternal(t *testing.T) {
+	dir := t.TempDir()
+	svgPath := filepath.Join(dir, "drawing.svg")
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="10mm" height="10mm"><rect width="5" height="5"/></svg>`
+	if err := os.WriteFile(svgPath, []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// no tool, the native converter is used
+	pdfPath := filepath.Join(dir, "drawing.pdf")
+	if err := ConvertSVGToPDFExternal(context.Background(), "", svgPath, pdfPath); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(pdfPath); err != nil || !bytes.HasPrefix(data, []byte("%PDF")) {
+		t.Errorf("no PDF written: %v", err)
+	}
+
+	if err := ConvertSVGToPDFExternal(context.Background(), "convert", svgPath, pdfPath); err == nil {
+		t.Errorf("expected an error for an unknown converter")
+	}
+}
@@ -0,0 +1,158 @@
+package rmconvert
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/encoding/rm"
+)
+
+func TestGenerateSVGLayers(t *testing.T) {
+	page := &Page{
+		Width:  1404,
+		Height: 1872,
+		Layers: []Layer{{Name: "Layer 1"}, {Name: "Notes & sketches", Hidden: true}},
+		Strokes: []Stroke{
+			{Tool: ToolFineliner, Width: 2, Points: []Point{{X: 10, Y: 10}, {X: 20, Y: 20}}},
+			{Tool: ToolPencil, Width: 2, Layer: 1, Points: []Point{{X: 30, Y: 30}, {X: 40, Y: 40}}},
+			{Tool: ToolPencil, Width: 2, Layer: 1, Points: []Point{{X: 50, Y: 50}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := page.GenerateSVG(&buf, DefaultSVGOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Groups []struct {
+			Label string `xml:"label,attr"`
+			Mode  string `xml:"groupmode,attr"`
+			Style string `xml:"style,attr"`
+			Paths []struct {
+				D string `xml:"d,attr"`
+			} `xml:"path"`
+		} `xml:"g"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid SVG: %v", err)
+	}
+
+	if len(doc.Groups) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(doc.Groups))
+	}
+	if doc.Groups[0].Label != "Layer 1" || doc.Groups[0].Mode != "layer" || len(doc.Groups[0].Paths) != 1 {
+		t.Errorf("wrong first layer: %+v", doc.Groups[0])
+	}
+	if doc.Groups[1].Label != "Notes & sketches" || doc.Groups[1].Style != "display:none" || len(doc.Groups[1].Paths) != 2 {
+		t.Errorf("wrong second layer: %+v", doc.Groups[1])
+	}
+	if doc.Groups[0].Paths[0].D != "M10 10l10 10" {
+		t.Errorf("unexpected path %q", doc.Groups[0].Paths[0].D)
+	}
+
+	buf.Reset()
+	inverted := DefaultSVGOptions
+	inverted.Invert = true
+	if err := page.GenerateSVG(&buf, inverted); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<rect x="0" y="0" width="1404" height="1872" fill="#000000"/>`) || !strings.Contains(buf.String(), `stroke="#ffffff"`) {
+		t.Errorf("expected white strokes on a black page:\n%s", buf.String())
+	}
+}
+
+func TestGenerateSVGText(t *testing.T) {
+	page := &Page{Width: 1404, Height: 1872, TextBox: &rm.Text{X: -468, Y: 234, Width: 300, Paragraphs: []rm.TextParagraph{
+		{Style: rm.StyleHeading, Spans: []rm.TextSpan{{Text: "Title"}}},
+		{Style: rm.StylePlain, Spans: []rm.TextSpan{{Text: "a <b> "}, {Text: "word", Italic: true}, {Text: " and a paragraph wrapped at the width of the box"}}},
+	}}}
+
+	var buf bytes.Buffer
+	if err := page.GenerateSVG(&buf, DefaultSVGOptions); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Style  string `xml:"defs>style"`
+		Groups []struct {
+			Label string `xml:"label,attr"`
+			Texts []struct {
+				X      float64 `xml:"x,attr"`
+				Y      float64 `xml:"y,attr"`
+				Weight string  `xml:"font-weight,attr"`
+				Style  string  `xml:"font-style,attr"`
+				Text   string  `xml:",chardata"`
+			} `xml:"text"`
+		} `xml:"g"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid SVG: %v", err)
+	}
+	if len(doc.Groups) != 2 || doc.Groups[0].Label != "Text" {
+		t.Fatalf("expected a text layer, got %+v", doc.Groups)
+	}
+
+	texts := doc.Groups[0].Texts
+	if len(texts) < 5 {
+		t.Fatalf("expected the paragraph to wrap, got %+v", texts)
+	}
+	// the box is relative to the top center of the page
+	if texts[0].Text != "Title" || texts[0].Weight != "bold" || texts[0].X != 234 {
+		t.Errorf("wrong heading: %+v", texts[0])
+	}
+	if texts[1].Text != "a <b> " || texts[2].Text != "word" || texts[2].Style != "italic" {
+		t.Errorf("wrong spans: %+v", texts[1:3])
+	}
+	if last := texts[len(texts)-1]; last.Y <= texts[3].Y {
+		t.Errorf("expected the last line below the first one: %+v", texts)
+	}
+
+	// the bold, italic and regular styles are embedded, subsetted
+	if n := strings.Count(doc.Style, "@font-face"); n != 3 {
+		t.Errorf("expected 3 embedded styles, got %d: %.200s", n, doc.Style)
+	}
+	if len(doc.Style) > 100<<10 {
+		t.Errorf("expected subsetted fonts, got %d bytes", len(doc.Style))
+	}
+}
+
+func TestSVGPathData(t *testing.T) {
+	points := []Point{{X: 0.04, Y: 1}, {X: 10.26, Y: 1.5}, {X: 5, Y: 0.9}, {X: 5, Y: 0.9}}
+
+	tests := []struct {
+		opts SVGOptions
+		want string
+	}{
+		{SVGOptions{Precision: 1}, "M0 1l10.3 .5-5.3-.6 0 0"},
+		{SVGOptions{Precision: 0, Absolute: true}, "M0 1L10 2 5 1 5 1"},
+		{SVGOptions{Precision: -1, Absolute: true}, "M.04 1L10.26 1.5 5 .9 5 .9"},
+	}
+	for _, tt := range tests {
+		if got := svgPathData(points, tt.opts); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestSimplifyPoints(t *testing.T) {
+	// a straight line with noise below the tolerance and a corner
+	var points []Point
+	for i := 0; i <= 100; i++ {
+		points = append(points, Point{X: float32(i), Y: float32(i%2) * 0.2})
+	}
+	points = append(points, Point{X: 100, Y: 50})
+
+	simplified := simplifyPoints(points, 0.5)
+	if len(simplified) != 3 {
+		t.Fatalf("expected 3 points, got %d: %v", len(simplified), simplified)
+	}
+	if simplified[1].X != 100 || simplified[1].Y != 0 {
+		t.Errorf("corner not kept: %v", simplified[1])
+	}
+
+	if got := simplifyPoints(points, 0); len(got) != len(points) {
+		t.Errorf("tolerance 0 dropped points")
+	}
+}
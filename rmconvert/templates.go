@@ -0,0 +1,99 @@
+package rmconvert
+
+import (
+	"image"
+	_ "image/jpeg" // templates may be JPEG files
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/juruen/rmapi/log"
+)
+
+// BlankTemplate is the name of the template of the pages without one
+const BlankTemplate = "Blank"
+
+// templateExtensions are the image formats of the template files, the
+// device ships them as PNG
+var templateExtensions = []string{".png", ".jpg", ".jpeg"}
+
+// loadedTemplates caches the decoded template images by path, nil for the
+// templates that couldn't be loaded
+var loadedTemplates sync.Map
+
+// loadTemplate returns the image of a template of dir, e.g. the folder
+// /usr/share/remarkable/templates copied from the device. It returns nil
+// for blank pages and for the templates not found in dir.
+func loadTemplate(dir, name string) image.Image {
+	if dir == "" || name == "" || name == BlankTemplate {
+		return nil
+	}
+	// template names never contain a path
+	if strings.ContainsAny(name, `/\`) {
+		return nil
+	}
+
+	for _, ext := range templateExtensions {
+		path := filepath.Join(dir, name+ext)
+		if img, ok := loadedTemplates.Load(path); ok {
+			if img == nil {
+				return nil
+			}
+			return img.(image.Image)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			log.Warning.Printf("failed to read template %s: %v", path, err)
+			loadedTemplates.Store(path, nil)
+			return nil
+		}
+		loadedTemplates.Store(path, img)
+		return img
+	}
+
+	log.Warning.Printf("template %q not found in %s, the page is drawn blank", name, dir)
+	return nil
+}
+
+// Templates returns the template of each page in document order, from the
+// .content file or the .pagedata file of older documents. Blank pages have
+// BlankTemplate or an empty name.
+func (d *RmDoc) Templates() []string {
+	ids := d.PageIDs()
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = d.templates[id]
+	}
+	return names
+}
+
+// readTemplates maps the pages to their template
+func (d *RmDoc) readTemplates() {
+	d.templates = make(map[string]string)
+	if len(d.Content.CPages.Pages) > 0 {
+		for _, page := range d.Content.CPages.Pages {
+			d.templates[page.ID] = page.Template.Value
+		}
+		return
+	}
+
+	// one line per page, in page order
+	pagedata, err := d.ReadFile("pagedata")
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimRight(string(pagedata), "\n"), "\n")
+	for i, id := range d.PageIDs() {
+		if i < len(lines) {
+			d.templates[id] = strings.TrimSpace(lines[i])
+		}
+	}
+}
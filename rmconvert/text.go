@@ -0,0 +1,98 @@
+package rmconvert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/juruen/rmapi/log"
+)
+
+// Sources of the text of a page
+const (
+	TextSourceTyped = "typed"
+	TextSourceOCR   = "ocr"
+)
+
+// PageText is the text found on a page of a document
+type PageText struct {
+	Page   int // 1-based page number
+	Source string
+	Text   string
+}
+
+// ExtractTypedText returns the typed text of the pages of a .rmdoc file,
+// pages without text are left out
+func ExtractTypedText(rmdocPath string) ([]PageText, error) {
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .rmdoc: %v", err)
+	}
+	defer doc.Close()
+
+	return doc.TypedText()
+}
+
+// recognizedText returns the typed text of a document and the text that
+// OCR recognises on its pages, only the typed text if tesseract is missing,
+// and the pages whose OCR timed out
+func recognizedText(goCtx context.Context, doc *RmDoc, opts ExportOptions) ([]PageText, []int, error) {
+	text, err := doc.TypedText()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !tesseractAvailable(opts) {
+		log.Warning.Println("tesseract not found, only the typed text is exported")
+		return text, nil, nil
+	}
+	results, err := NewPipeline(opts).Text(goCtx, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(text, ocrText(results)...), TimedOutPages(results), nil
+}
+
+// TypedText returns the typed text of the pages, pages without text are
+// left out
+func (d *RmDoc) TypedText() ([]PageText, error) {
+	var result []PageText
+	for i, pageID := range d.PageIDs() {
+		if !d.HasPage(pageID) {
+			continue
+		}
+
+		page, err := d.Page(pageID)
+		if err != nil {
+			return nil, err
+		}
+
+		if text := strings.TrimSpace(page.Text); text != "" {
+			result = append(result, PageText{Page: i + 1, Source: TextSourceTyped, Text: text})
+		}
+	}
+
+	return result, nil
+}
+
+// ocrText joins the words recognised on each page
+func ocrText(results []PageOCR) []PageText {
+	var result []PageText
+	for _, ocr := range results {
+		if ocr.Typed {
+			continue
+		}
+		words := make([]string, 0, len(ocr.Words))
+		for _, w := range ocr.Words {
+			words = append(words, w.Text)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		result = append(result, PageText{
+			Page:   ocr.PageNumber,
+			Source: TextSourceOCR,
+			Text:   strings.Join(words, " "),
+		})
+	}
+	return result
+}
@@ -0,0 +1,279 @@
+package rmconvert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juruen/rmapi/util"
+	"github.com/tdewolff/canvas"
+)
+
+func init() {
+	RegisterExporter(textJSONExporter{})
+}
+
+// TextLayout is the text of a document with its position on the pages, the
+// typed text and, with OCR, the recognised handwriting
+type TextLayout struct {
+	Title string           `json:"title"`
+	Pages []TextLayoutPage `json:"pages"`
+
+	timedOut []int
+}
+
+// TextLayoutPage is the text of a page. The coordinates are device pixels
+// from the top left of the page, 226 per inch.
+type TextLayoutPage struct {
+	Page   int              `json:"page"` // 1-based page number
+	Width  float64          `json:"width"`
+	Height float64          `json:"height"`
+	Lines  []TextLayoutLine `json:"lines"`
+}
+
+// TextLayoutLine is a line of words
+type TextLayoutLine struct {
+	Text string `json:"text"`
+	// Source is TextSourceTyped or TextSourceOCR
+	Source string           `json:"source"`
+	BBox   [4]float64       `json:"bbox"` // x0, y0, x1, y1
+	Words  []TextLayoutWord `json:"words"`
+}
+
+// TextLayoutWord is a word of a line
+type TextLayoutWord struct {
+	Text string     `json:"text"`
+	BBox [4]float64 `json:"bbox"`
+	// Confidence is the OCR confidence from 0 to 100, none for typed text
+	Confidence *int `json:"confidence,omitempty"`
+}
+
+// textJSONExporter writes the text of the pages with its coordinates
+type textJSONExporter struct{}
+
+func (textJSONExporter) Name() string         { return "text-json" }
+func (textJSONExporter) Extensions() []string { return []string{"text.json"} }
+
+func (textJSONExporter) Export(goCtx context.Context, rmdocPath, outPath string, opts ExportOptions) (*ExportResult, error) {
+	opts = opts.withDefaults()
+
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .rmdoc: %v", err)
+	}
+	defer doc.Close()
+
+	layout, err := documentTextLayout(goCtx, doc, opts)
+	if err != nil {
+		return nil, err
+	}
+	layout.Title = strings.TrimSuffix(filepath.Base(outPath), ".text.json")
+
+	err = util.WriteFileAtomic(outPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(layout)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ExportResult{Files: []string{outPath}, Text: layout.pageTexts(), OCRTimeouts: layout.timedOut}, nil
+}
+
+// documentTextLayout returns the typed text of the pages and, with OCR,
+// the recognised words
+func documentTextLayout(goCtx context.Context, doc *RmDoc, opts ExportOptions) (*TextLayout, error) {
+	pageOrder, pages, err := doc.Pages()
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &TextLayout{Pages: make([]TextLayoutPage, len(pageOrder))}
+	// the pipeline numbers the pages of the archive only, the sheets of the
+	// long ones are mapped back to their page
+	type sheet struct {
+		page int
+		top  float64
+	}
+	var rendered []sheet
+	for i, id := range pageOrder {
+		page := pages[id]
+		// the whole page, as rendered for OCR
+		region, _, _ := page.renderRegion(ExportOptions{})
+		layout.Pages[i] = TextLayoutPage{
+			Page:   i + 1,
+			Width:  region.W(),
+			Height: region.H(),
+			Lines:  typedTextLines(page),
+		}
+		if doc.HasPage(id) {
+			for _, s := range page.Sheets() {
+				rendered = append(rendered, sheet{page: i, top: s.top})
+			}
+		}
+	}
+
+	if opts.OCR && tesseractAvailable(opts) {
+		// the words are mapped back to the whole page
+		opts.Crop, opts.Width, opts.Height = false, 0, 0
+		opts.Header, opts.Footer, opts.PageNumbers = "", "", false
+		results, err := NewPipeline(opts).Text(goCtx, doc)
+		if err != nil {
+			return nil, err
+		}
+		for _, ocr := range results {
+			// the typed text is already there
+			if ocr.PageNumber < 1 || ocr.PageNumber > len(rendered) || ocr.Typed {
+				continue
+			}
+			s := rendered[ocr.PageNumber-1]
+			page := &layout.Pages[s.page]
+			if ocr.TimedOut {
+				layout.timedOut = append(layout.timedOut, page.Page)
+			}
+			page.Lines = append(page.Lines, ocrTextLines(ocr, page.Width, s.top)...)
+		}
+	}
+	return layout, nil
+}
+
+// pageTexts returns the text of each page, as returned by the exporters
+func (l *TextLayout) pageTexts() []PageText {
+	var result []PageText
+	for _, page := range l.Pages {
+		for _, source := range []string{TextSourceTyped, TextSourceOCR} {
+			var lines []string
+			for _, line := range page.Lines {
+				if line.Source == source {
+					lines = append(lines, line.Text)
+				}
+			}
+			if len(lines) > 0 {
+				result = append(result, PageText{Page: page.Page, Source: source, Text: strings.Join(lines, "\n")})
+			}
+		}
+	}
+	return result
+}
+
+// typedTextLines lays out the typed text of a page into lines of words
+func typedTextLines(page *Page) []TextLayoutLine {
+	spans := page.typedText()
+	// the spans of a line, of different styles, share their baseline
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Y != spans[j].Y {
+			return spans[i].Y < spans[j].Y
+		}
+		return spans[i].X < spans[j].X
+	})
+
+	var lines []TextLayoutLine
+	lastY := 0.0
+	for _, span := range spans {
+		face := textFace(span.Size, span.Bold, span.Italic, canvas.Black)
+		metrics := face.Metrics()
+		y0, y1 := span.Y-metrics.Ascent, span.Y+metrics.Descent
+
+		var words []TextLayoutWord
+		offset := 0
+		for _, word := range strings.Fields(span.Text) {
+			start := offset + strings.Index(span.Text[offset:], word)
+			offset = start + len(word)
+			x0 := span.X + face.TextWidth(span.Text[:start])
+			words = append(words, TextLayoutWord{Text: word, BBox: [4]float64{x0, y0, x0 + face.TextWidth(word), y1}})
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		if n := len(lines); n > 0 && span.Y == lastY {
+			line := &lines[n-1]
+			line.Words = append(line.Words, words...)
+		} else {
+			lines = append(lines, TextLayoutLine{Source: TextSourceTyped, Words: words})
+		}
+		lastY = span.Y
+	}
+
+	for i := range lines {
+		lines[i].finish()
+	}
+	return lines
+}
+
+// typedWords returns the words of the typed text of a page in the pixels
+// of its image rendered with the options, whose bounds are bounds, one
+// line number per line
+func (page *Page) typedWords(opts ExportOptions, bounds image.Rectangle) []Word {
+	region, _, dpi := page.renderRegion(opts)
+	scale := dpi / deviceDPI
+
+	var words []Word
+	for i, line := range typedTextLines(page) {
+		for _, w := range line.Words {
+			word := Word{
+				Text:       w.Text,
+				X1:         int(math.Floor((w.BBox[0] - region.X0) * scale)),
+				Y1:         int(math.Floor((w.BBox[1] - region.Y0) * scale)),
+				X2:         int(math.Ceil((w.BBox[2] - region.X0) * scale)),
+				Y2:         int(math.Ceil((w.BBox[3] - region.Y0) * scale)),
+				Confidence: 100,
+				Line:       i + 1,
+			}
+			if image.Rect(word.X1, word.Y1, word.X2, word.Y2).Overlaps(bounds) {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// ocrTextLines returns the lines of the words recognised on a page of
+// pageWidth device pixels, or on its sheet starting top device pixels
+// below its top
+func ocrTextLines(ocr PageOCR, pageWidth, top float64) []TextLayoutLine {
+	scale := 1.0
+	if ocr.ImgW > 0 {
+		scale = pageWidth / float64(ocr.ImgW)
+	}
+
+	var lines []TextLayoutLine
+	for _, l := range textLines(ocr.Words) {
+		line := TextLayoutLine{Source: TextSourceOCR}
+		for _, w := range l.words {
+			confidence := w.Confidence
+			word := TextLayoutWord{
+				Text: w.Text,
+				BBox: [4]float64{float64(w.X1) * scale, float64(w.Y1)*scale + top, float64(w.X2) * scale, float64(w.Y2)*scale + top},
+			}
+			if confidence >= 0 {
+				word.Confidence = &confidence
+			}
+			line.Words = append(line.Words, word)
+		}
+		line.finish()
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// finish sets the text and the bounding box of a line from its words
+func (l *TextLayoutLine) finish() {
+	texts := make([]string, len(l.Words))
+	for i, w := range l.Words {
+		texts[i] = w.Text
+		if i == 0 {
+			l.BBox = w.BBox
+			continue
+		}
+		l.BBox[0], l.BBox[1] = min(l.BBox[0], w.BBox[0]), min(l.BBox[1], w.BBox[1])
+		l.BBox[2], l.BBox[3] = max(l.BBox[2], w.BBox[2]), max(l.BBox[3], w.BBox[3])
+	}
+	l.Text = strings.Join(texts, " ")
+}
@@ -0,0 +1,78 @@
+package rmconvert
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ThumbnailDPI is the default resolution of rendered thumbnails
+const ThumbnailDPI = 30
+
+// ExtractThumbnail writes a PNG thumbnail of the cover of a .rmdoc file to
+// w: the page chosen as the cover on the device (coverPageNumber of the
+// .content file), or else the first non-blank page. The thumbnail stored by
+// the device in the document is used when there is one, otherwise the page
+// is rendered at dpi.
+//
+// Pages without strokes or typed text are blank, if every page is the
+// first one is used (e.g. a PDF that hasn't been annotated, whose stored
+// thumbnail shows the PDF page).
+func ExtractThumbnail(rmdocPath string, w io.Writer, dpi int) error {
+	if dpi <= 0 {
+		dpi = ThumbnailDPI
+	}
+
+	doc, err := OpenRmDoc(rmdocPath)
+	if err != nil {
+		return fmt.Errorf("failed to open .rmdoc: %v", err)
+	}
+	defer doc.Close()
+
+	pageOrder := doc.PageIDs()
+	if len(pageOrder) == 0 {
+		return fmt.Errorf("no pages found in document")
+	}
+
+	pageID, page := coverPage(doc, pageOrder)
+
+	if stored, err := doc.ReadFile("thumbnails/" + pageID + ".png"); err == nil {
+		_, err = w.Write(stored)
+		return err
+	}
+
+	if page == nil {
+		page = &Page{Width: 1404, Height: 1872}
+	}
+	return page.ConvertToPNG(w, dpi)
+}
+
+// coverPage returns the page chosen as the cover on the device, or the
+// first non-blank page. The page is nil if it has no .rm file.
+func coverPage(doc *RmDoc, pageOrder []string) (string, *Page) {
+	if n := doc.Content.CoverPageNumber; n != nil && *n >= 0 && *n < len(pageOrder) {
+		pageID := pageOrder[*n]
+		page, _ := doc.Page(pageID)
+		return pageID, page
+	}
+	return firstNonBlankPage(doc, pageOrder)
+}
+
+// firstNonBlankPage returns the first page with strokes or text, or the
+// first page if they are all blank. The page is nil if it has no .rm file.
+func firstNonBlankPage(doc *RmDoc, pageOrder []string) (string, *Page) {
+	var first *Page
+	for i, pageID := range pageOrder {
+		page, err := doc.Page(pageID)
+		if err != nil {
+			continue
+		}
+		if len(page.Strokes) > 0 || strings.TrimSpace(page.Text) != "" {
+			return pageID, page
+		}
+		if i == 0 {
+			first = page
+		}
+	}
+	return pageOrder[0], first
+}
@@ -0,0 +1,112 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractThumbnail(t *testing.T) {
+	tempDir := t.TempDir()
+	rmdocPath := filepath.Join(tempDir, "test.rmdoc")
+	if err := createTestRmdoc(rmdocPath); err != nil {
+		t.Fatalf("Failed to create test .rmdoc: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExtractThumbnail(rmdocPath, &buf, 20); err != nil {
+		t.Fatalf("ExtractThumbnail failed: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("thumbnail is not a PNG: %v", err)
+	}
+	if img.Bounds().Dx() == 0 || img.Bounds().Dx() >= 1404 {
+		t.Errorf("unexpected thumbnail width %d", img.Bounds().Dx())
+	}
+
+	// the thumbnail stored by the device wins
+	stored := filepath.Join(tempDir, "stored.rmdoc")
+	if err := addToZip(rmdocPath, stored, "test-doc.thumbnails/test-page-1.png", []byte("stored")); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := ExtractThumbnail(stored, &buf, 20); err != nil {
+		t.Fatalf("ExtractThumbnail failed: %v", err)
+	}
+	if buf.String() != "stored" {
+		t.Errorf("stored thumbnail not used, got %d bytes", buf.Len())
+	}
+}
+
+func TestExtractThumbnailCoverPage(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		want    string
+	}{
+		{`{"pages": ["p1", "p2", "p3"]}`, "first"},
+		{`{"pages": ["p1", "p2", "p3"], "coverPageNumber": 2}`, "third"},
+		{`{"pages": ["p1", "p2", "p3"], "coverPageNumber": 0}`, "first"},
+		{`{"pages": ["p1", "p2", "p3"], "coverPageNumber": -1}`, "first"},
+		{`{"pages": ["p1", "p2", "p3"], "coverPageNumber": 5}`, "first"},
+	} {
+		rmdocPath := filepath.Join(t.TempDir(), "cover.rmdoc")
+		f, err := os.Create(rmdocPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := zip.NewWriter(f)
+		for name, data := range map[string]string{
+			"doc.content":           tc.content,
+			"doc.thumbnails/p1.png": "first",
+			"doc.thumbnails/p2.png": "second",
+			"doc.thumbnails/p3.png": "third",
+		} {
+			fw, _ := w.Create(name)
+			fw.Write([]byte(data))
+		}
+		w.Close()
+		f.Close()
+
+		var buf bytes.Buffer
+		if err := ExtractThumbnail(rmdocPath, &buf, 20); err != nil {
+			t.Fatalf("%s: %v", tc.content, err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s: got the %s page, want the %s", tc.content, buf.String(), tc.want)
+		}
+	}
+}
+
+// addToZip copies the zip src to dst with an extra file
+func addToZip(src, dst, name string, data []byte) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for _, file := range r.File {
+		if err := w.Copy(file); err != nil {
+			return err
+		}
+	}
+	fw, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
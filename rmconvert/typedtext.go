@@ -0,0 +1,130 @@
+package rmconvert
+
+import (
+	"image/color"
+
+	"github.com/juruen/rmapi/encoding/rm"
+	"github.com/tdewolff/canvas"
+)
+
+// paragraphFormat is how the paragraphs of a style are laid out, in device
+// pixels
+type paragraphFormat struct {
+	size       float64
+	lineHeight float64
+	bold       bool
+	// marker is drawn in the indentation of the first line
+	marker string
+	indent float64
+}
+
+// paragraphFormats approximate the paragraph styles of the device
+var paragraphFormats = map[rm.ParagraphStyle]paragraphFormat{
+	rm.StyleBasic:           {size: 32, lineHeight: 70},
+	rm.StylePlain:           {size: 32, lineHeight: 70},
+	rm.StyleHeading:         {size: 50, lineHeight: 100, bold: true},
+	rm.StyleBold:            {size: 32, lineHeight: 70, bold: true},
+	rm.StyleBullet:          {size: 32, lineHeight: 70, marker: "•", indent: 50},
+	rm.StyleBullet2:         {size: 32, lineHeight: 70, marker: "◦", indent: 100},
+	rm.StyleCheckbox:        {size: 32, lineHeight: 70, marker: "□", indent: 50},
+	rm.StyleCheckboxChecked: {size: 32, lineHeight: 70, marker: "■", indent: 50},
+}
+
+// typedTextSpan is a run of typed text on a line, at the position of the
+// start of its baseline in device pixels
+type typedTextSpan struct {
+	X, Y   float64
+	Text   string
+	Size   float64
+	Bold   bool
+	Italic bool
+}
+
+// textFace returns the face of typed text of size device pixels, the units
+// of the canvas being device pixels
+func textFace(size float64, bold, italic bool, fill color.Color) *canvas.FontFace {
+	// face sizes are in points of 25.4/72 canvas units
+	return TextFont.fontFamily().Face(size*72/25.4, fill, fontStyle(bold, italic))
+}
+
+// layoutTypedText lays out the paragraphs of typed text, wrapped at the
+// width of the text box. The position of the text box of v6 pages is
+// relative to the top center of the page.
+func layoutTypedText(text *rm.Text, pageWidth float64) []typedTextSpan {
+	if text == nil {
+		return nil
+	}
+	paragraphs := text.Paragraphs
+	if len(paragraphs) == 0 && text.Content != "" {
+		paragraphs = []rm.TextParagraph{{Style: rm.StylePlain, Spans: []rm.TextSpan{{Text: text.Content}}}}
+	}
+
+	left := text.X + pageWidth/2
+	width := float64(text.Width)
+	if width <= 0 {
+		width = pageWidth - left
+	}
+
+	var spans []typedTextSpan
+	y := text.Y
+	for _, paragraph := range paragraphs {
+		format, ok := paragraphFormats[paragraph.Style]
+		if !ok {
+			format = paragraphFormats[rm.StylePlain]
+		}
+		// the baseline of the first line
+		baseline := y + format.size
+		if format.marker != "" {
+			spans = append(spans, typedTextSpan{X: left + format.indent - 35, Y: baseline, Text: format.marker, Size: format.size})
+		}
+
+		var rt *canvas.RichText
+		for _, span := range paragraph.Spans {
+			face := textFace(format.size, format.bold || span.Bold, span.Italic, canvas.Black)
+			if rt == nil {
+				rt = canvas.NewRichText(face)
+			}
+			rt.WriteFace(face, span.Text)
+		}
+		lines := 1
+		if rt != nil {
+			lines = 0
+			layout := rt.ToText(width-format.indent, 0, canvas.Left, canvas.Top, nil)
+			layout.WalkLines(func(_ float64, line []canvas.TextSpan) {
+				for _, span := range line {
+					if !span.IsText() {
+						continue
+					}
+					spans = append(spans, typedTextSpan{
+						X:      left + format.indent + span.X,
+						Y:      baseline + float64(lines)*format.lineHeight,
+						Text:   span.Text,
+						Size:   format.size,
+						Bold:   span.Face.Style.Weight() == canvas.FontBold,
+						Italic: span.Face.Style.Italic(),
+					})
+				}
+				lines++
+			})
+		}
+		y += float64(max(lines, 1)) * format.lineHeight
+	}
+	return spans
+}
+
+// drawTypedText draws the typed text of a page in a color on a canvas whose
+// units are scale times device pixels
+func drawTypedText(ctx *canvas.Context, page *Page, scale float64, fill color.Color) {
+	for _, span := range page.typedText() {
+		face := textFace(span.Size*scale, span.Bold, span.Italic, fill)
+		ctx.DrawText(span.X*scale, span.Y*scale, canvas.NewTextLine(face, span.Text, canvas.Left))
+	}
+}
+
+// deviceWidth returns the width of a page in device pixels
+func deviceWidth(page *Page) float32 {
+	if page.Width <= 0 {
+		return 1404
+	}
+	return page.Width
+}
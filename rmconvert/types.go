@@ -3,19 +3,14 @@ package rmconvert
 import (
 	"fmt"
 	"image/color"
-	"math"
-	"strings"
+
+	"github.com/juruen/rmapi/encoding/rm"
 )
 
-// Point represents a point in a stroke with pressure, speed, direction, and width
-type Point struct {
-	X         float32
-	Y         float32
-	Speed     float32
-	Direction float32
-	Width     float32
-	Pressure  float32
-}
+// Point represents a point in a stroke with pressure, speed, direction, and
+// width. It is the point of the rm package, so that the points of a parsed
+// page are used as is.
+type Point = rm.Point
 
 // Stroke represents a drawing stroke with tool information and points
 type Stroke struct {
@@ -23,6 +18,13 @@ type Stroke struct {
 	Color  int     // Color index (0=black, 1=gray, 2=white)
 	Width  float32 // Base stroke width
 	Points []Point
+	Layer  int // Index in Page.Layers
+}
+
+// Layer is a layer of a page
+type Layer struct {
+	Name   string
+	Hidden bool
 }
 
 // Page represents a reMarkable page with all its strokes
@@ -30,6 +32,28 @@ type Page struct {
 	Width   float32
 	Height  float32
 	Strokes []Stroke
+	Layers  []Layer
+	// Text is the typed text of the page
+	Text string
+	// TextBox is the position and the paragraphs of Text, nil without
+	// typed text
+	TextBox *rm.Text
+	// Highlights are the highlights of the text of the underlying PDF or
+	// EPUB page
+	Highlights []PageHighlight
+	// Template is the name of the template drawn under the strokes
+	Template string
+
+	// top is the position in its page of a sheet of Sheets, in device
+	// pixels, and sheetSpans its typed text
+	top        float64
+	sheetSpans []typedTextSpan
+}
+
+// PageHighlight is a highlighted range of the text of a page
+type PageHighlight struct {
+	Text  string
+	Color string
 }
 
 // Tool type constants based on reMarkable format
@@ -101,7 +125,7 @@ func GetToolProperties(tool, color int, baseWidth float32) ToolProperties {
 		props.Name = "unknown"
 	}
 
-	return props
+	return calibrate(props)
 }
 
 // ScalePoint applies reMarkable to PDF coordinate transformation
@@ -121,43 +145,19 @@ func ScalePoint(p Point) Point {
 	}
 }
 
-// GetBoundingBox returns the bounding box of all strokes
+// GetBoundingBox returns the bounding box of the strokes and typed text in
+// points, with a padding of 10 points, the whole page if it is blank
 func (page *Page) GetBoundingBox() (minX, minY, maxX, maxY float32) {
-	if len(page.Strokes) == 0 {
+	bounds, ok := page.contentBounds()
+	if !ok {
 		return 0, 0, page.Width, page.Height
 	}
 
-	minX = math.MaxFloat32
-	minY = math.MaxFloat32
-	maxX = -math.MaxFloat32
-	maxY = -math.MaxFloat32
-
-	for _, stroke := range page.Strokes {
-		for _, point := range stroke.Points {
-			scaled := ScalePoint(point)
-			if scaled.X < minX {
-				minX = scaled.X
-			}
-			if scaled.Y < minY {
-				minY = scaled.Y
-			}
-			if scaled.X > maxX {
-				maxX = scaled.X
-			}
-			if scaled.Y > maxY {
-				maxY = scaled.Y
-			}
-		}
-	}
-
-	// Add padding
-	padding := float32(10)
-	minX -= padding
-	minY -= padding
-	maxX += padding
-	maxY += padding
-
-	return minX, minY, maxX, maxY
+	// reMarkable to PDF coordinates, like ScalePoint
+	const scale = 72.0 / 226.0
+	const padding = 10
+	return float32(bounds.X0*scale - padding), float32(bounds.Y0*scale - padding),
+		float32(bounds.X1*scale + padding), float32(bounds.Y1*scale + padding)
 }
 
 // String returns a string representation of the page
@@ -168,14 +168,8 @@ func (page *Page) String() string {
 
 // parseColor converts a color string to color.RGBA
 func parseColor(colorStr string) color.RGBA {
-	switch strings.ToLower(colorStr) {
-	case "black":
-		return color.RGBA{0, 0, 0, 255}
-	case "white":
-		return color.RGBA{255, 255, 255, 255}
-	case "#777777", "gray", "grey":
-		return color.RGBA{119, 119, 119, 255}
-	default:
-		return color.RGBA{0, 0, 0, 255}
+	if c, ok := lookupColor(colorStr); ok {
+		return c
 	}
+	return color.RGBA{0, 0, 0, 255}
 }
\ No newline at end of file
@@ -0,0 +1,47 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Validate parses every page of the document and reads its PDF or EPUB
+// file, if any, returning the problems found: one error per page that
+// doesn't parse and per file that can't be read. The pages without a .rm
+// file are blank, not errors.
+func (d *RmDoc) Validate() []error {
+	var problems []error
+	for i, id := range d.PageIDs() {
+		if !d.HasPage(id) {
+			continue
+		}
+		if _, err := d.Page(id); err != nil {
+			problems = append(problems, fmt.Errorf("page %d: %w", i+1, err))
+		}
+	}
+
+	if data, err := d.ReadFile("pdf"); err == nil {
+		conf := model.NewDefaultConfiguration()
+		conf.ValidationMode = model.ValidationRelaxed
+		if err := api.Validate(bytes.NewReader(data), conf); err != nil {
+			problems = append(problems, fmt.Errorf("pdf: %w", err))
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, fmt.Errorf("pdf: %w", err))
+	}
+
+	if data, err := d.ReadFile("epub"); err == nil {
+		if _, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err != nil {
+			problems = append(problems, fmt.Errorf("epub: %w", err))
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, fmt.Errorf("epub: %w", err))
+	}
+	return problems
+}
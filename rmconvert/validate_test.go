@@ -0,0 +1,42 @@
+package rmconvert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/encoding/rm"
+)
+
+func TestValidate(t *testing.T) {
+	good := encodeV6(toolsPageLines([]rm.BrushType{4}, []rm.BrushColor{0}), nil)
+	doc, err := OpenRmDoc(writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"fileType": "pdf", "cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}, {"id": "p2", "idx": {"value": "b"}}, {"id": "p3", "idx": {"value": "c"}}]}}`),
+		"doc/p1.rm":   good,
+		"doc/p3.rm":   good[:len(good)/2],
+		"doc.pdf":     []byte("%PDF-1.7\nnot a pdf"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	problems := doc.Validate()
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	if !strings.HasPrefix(problems[0].Error(), "page 3: ") || !strings.HasPrefix(problems[1].Error(), "pdf: ") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	notebook, err := OpenRmDoc(writeTestZip(t, map[string][]byte{
+		"doc.content": []byte(`{"cPages": {"pages": [{"id": "p1", "idx": {"value": "a"}}]}}`),
+		"doc/p1.rm":   good,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notebook.Close()
+	if problems := notebook.Validate(); len(problems) > 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+}
@@ -0,0 +1,71 @@
+package rmconvert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// DefaultWatermarkStyle stamps the text across the pages in light gray
+const DefaultWatermarkStyle = "font:Helvetica, scalefactor:0.8 rel, rotation:45, opacity:0.3, fillcolor:#808080"
+
+// Watermark is a text or an image stamped over the pages of the exported
+// PDFs
+type Watermark struct {
+	// Text is stamped unless Image is set. {date}, {path} and {name} are
+	// replaced by Expand, %p and %P by the page number and count.
+	Text string
+	// Image is a PNG, JPEG, TIFF or PDF file
+	Image string
+	// Style is the description of the stamp in pdfcpu's syntax, e.g.
+	// "position:br, scalefactor:0.2 rel, opacity:0.5", DefaultWatermarkStyle
+	// if empty
+	Style string
+}
+
+// Expand returns the watermark with the placeholders of its text replaced
+// for a document: {path} by its path in the cloud, {name} by its name and
+// {date} by the date it was last modified
+func (w *Watermark) Expand(docPath string, modified time.Time) *Watermark {
+	expanded := *w
+	expanded.Text = expandDocument(w.Text, docPath, modified, true)
+	return &expanded
+}
+
+// pdfWatermark returns the pdfcpu stamp of the watermark
+func (w *Watermark) pdfWatermark() (*model.Watermark, error) {
+	style := w.Style
+	if style == "" {
+		style = DefaultWatermarkStyle
+	}
+	if w.Image != "" {
+		return api.ImageWatermark(w.Image, style, true, false, types.POINTS)
+	}
+	return api.TextWatermark(w.Text, style, true, false, types.POINTS)
+}
+
+// Check tells whether the watermark can be stamped, to report an invalid
+// style or image before exporting
+func (w *Watermark) Check() error {
+	if _, err := w.pdfWatermark(); err != nil {
+		return fmt.Errorf("invalid watermark: %v", err)
+	}
+	return nil
+}
+
+// stampPDF stamps a watermark over every page of a PDF file
+func stampPDF(pdfPath string, w *Watermark) error {
+	wm, err := w.pdfWatermark()
+	if err != nil {
+		return fmt.Errorf("invalid watermark: %v", err)
+	}
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	if err := api.AddWatermarksFile(pdfPath, "", nil, wm, conf); err != nil {
+		return fmt.Errorf("failed to stamp the watermark: %v", err)
+	}
+	return nil
+}
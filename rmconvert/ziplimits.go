@@ -0,0 +1,110 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/juruen/rmapi/model"
+)
+
+// ZipLimits bounds what is extracted from a .rmdoc archive, so that a
+// malicious archive can't fill the temporary space. Zero disables a limit.
+type ZipLimits struct {
+	// MaxTotalSize is the max number of bytes extracted from an archive
+	MaxTotalSize int64
+	// MaxFileSize is the max size of a single extracted file
+	MaxFileSize int64
+	// MaxFiles is the max number of entries of an archive
+	MaxFiles int
+}
+
+// ExtractLimits are the limits applied when extracting documents, set by
+// RMAPI_EXTRACT_MAX_SIZE and RMAPI_EXTRACT_MAX_FILE_SIZE (in MB) and
+// RMAPI_EXTRACT_MAX_FILES
+var ExtractLimits = ZipLimits{
+	MaxTotalSize: 4 << 30,
+	MaxFileSize:  2 << 30,
+	MaxFiles:     50000,
+}
+
+func init() {
+	if mb, err := strconv.ParseInt(os.Getenv("RMAPI_EXTRACT_MAX_SIZE"), 10, 64); err == nil && mb >= 0 {
+		ExtractLimits.MaxTotalSize = mb << 20
+	}
+	if mb, err := strconv.ParseInt(os.Getenv("RMAPI_EXTRACT_MAX_FILE_SIZE"), 10, 64); err == nil && mb >= 0 {
+		ExtractLimits.MaxFileSize = mb << 20
+	}
+	if n, err := strconv.Atoi(os.Getenv("RMAPI_EXTRACT_MAX_FILES")); err == nil && n >= 0 {
+		ExtractLimits.MaxFiles = n
+	}
+}
+
+// ZipLimitError is returned when an archive exceeds one of the ZipLimits
+type ZipLimitError struct {
+	Archive string
+	// Limit is the name of the exceeded limit: "files", "file size" or
+	// "total size"
+	Limit string
+	Max   int64
+}
+
+func (e *ZipLimitError) Error() string {
+	return fmt.Sprintf("%s exceeds the extraction limit of %d for %s", e.Archive, e.Max, e.Limit)
+}
+
+// Unwrap makes errors.Is(err, model.ErrTooLarge) true
+func (e *ZipLimitError) Unwrap() error {
+	return model.ErrTooLarge
+}
+
+// check rejects an archive from the sizes declared by its entries
+func (l ZipLimits) check(archive string, files []*zip.File) error {
+	if l.MaxFiles > 0 && len(files) > l.MaxFiles {
+		return &ZipLimitError{archive, "files", int64(l.MaxFiles)}
+	}
+	var total uint64
+	for _, f := range files {
+		if l.MaxFileSize > 0 && f.UncompressedSize64 > uint64(l.MaxFileSize) {
+			return &ZipLimitError{archive, "file size", l.MaxFileSize}
+		}
+		total += f.UncompressedSize64
+		if l.MaxTotalSize > 0 && total > uint64(l.MaxTotalSize) {
+			return &ZipLimitError{archive, "total size", l.MaxTotalSize}
+		}
+	}
+	return nil
+}
+
+// limitedCopy copies a file of an archive, the declared sizes may be wrong
+// so the limits are enforced on the bytes actually inflated. remaining is
+// the total size still allowed and is decremented.
+func (l ZipLimits) limitedCopy(archive string, dst io.Writer, src io.Reader, remaining *int64) error {
+	max := int64(-1)
+	limit := ""
+	if l.MaxFileSize > 0 {
+		max, limit = l.MaxFileSize, "file size"
+	}
+	if l.MaxTotalSize > 0 && (max < 0 || *remaining < max) {
+		max, limit = *remaining, "total size"
+	}
+	if max < 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+
+	n, err := io.Copy(dst, io.LimitReader(src, max+1))
+	*remaining -= n
+	if err != nil {
+		return err
+	}
+	if n > max {
+		if limit == "file size" {
+			return &ZipLimitError{archive, limit, l.MaxFileSize}
+		}
+		return &ZipLimitError{archive, limit, l.MaxTotalSize}
+	}
+	return nil
+}
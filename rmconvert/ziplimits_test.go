@@ -0,0 +1,101 @@
+package rmconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "doc.rmdoc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return path
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	defer func(l ZipLimits) { ExtractLimits = l }(ExtractLimits)
+
+	path := writeTestZip(t, map[string][]byte{
+		"doc.content":  []byte("{}"),
+		"doc/page1.rm": bytes.Repeat([]byte{0}, 1<<20),
+	})
+
+	ExtractLimits = ZipLimits{MaxTotalSize: 2 << 20, MaxFileSize: 2 << 20, MaxFiles: 10}
+	if err := extractZip(path, t.TempDir()); err != nil {
+		t.Fatalf("extractZip within the limits: %v", err)
+	}
+
+	tests := []struct {
+		limits ZipLimits
+		limit  string
+	}{
+		{ZipLimits{MaxFiles: 1}, "files"},
+		{ZipLimits{MaxFileSize: 1 << 19}, "file size"},
+		{ZipLimits{MaxTotalSize: 1 << 20}, "total size"},
+	}
+	for _, tt := range tests {
+		ExtractLimits = tt.limits
+		err := extractZip(path, t.TempDir())
+		var limitErr *ZipLimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+			t.Errorf("limits %+v: got %v, want a %s limit error", tt.limits, err, tt.limit)
+		}
+	}
+}
+
+func TestLimitedCopyIgnoresDeclaredSize(t *testing.T) {
+	l := ZipLimits{MaxTotalSize: 10}
+	remaining := l.MaxTotalSize
+	var out bytes.Buffer
+	err := l.limitedCopy("doc.rmdoc", &out, bytes.NewReader(make([]byte, 11)), &remaining)
+	var limitErr *ZipLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "total size" {
+		t.Errorf("got %v, want a total size limit error", err)
+	}
+}
+
+func TestRmDocTotalSize(t *testing.T) {
+	page := bytes.Repeat([]byte{0}, 1<<10)
+	doc, err := OpenRmDoc(writeTestZip(t, map[string][]byte{
+		"doc.content": []byte("{}"),
+		"doc/a.rm":    page,
+		"doc/b.rm":    page,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+
+	// what is left of the total size once the .content file is read: a
+	// page but not two
+	doc.remaining = 1<<10 + 100
+	for i := 0; i < 2; i++ {
+		if _, err := doc.ReadFile("a.rm"); err != nil {
+			t.Fatalf("read %d of a page within the total size: %v", i+1, err)
+		}
+	}
+	_, err = doc.ReadFile("b.rm")
+	var limitErr *ZipLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "total size" {
+		t.Errorf("got %v, want a total size limit error over the archive", err)
+	}
+}
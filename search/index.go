@@ -0,0 +1,205 @@
+// Package search keeps a local full-text index of the typed and recognised
+// (OCR) text of documents, so that they can be searched without downloading
+// or converting them again.
+package search
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/juruen/rmapi/util"
+)
+
+const indexVersion = 1
+
+// Page is the text of a page of a document
+type Page struct {
+	Number int    `json:"page"`
+	Source string `json:"source"`
+	Text   string `json:"text"`
+}
+
+// Document is an indexed document
+type Document struct {
+	ID    string `json:"id"`
+	Path  string `json:"path"`
+	Pages []Page `json:"pages"`
+}
+
+// Index holds the text of the documents, keyed by document ID
+type Index struct {
+	Version   int                  `json:"version"`
+	Documents map[string]*Document `json:"documents"`
+	path      string
+}
+
+// Result is a page matching a query
+type Result struct {
+	DocumentID string `json:"id"`
+	Path       string `json:"path"`
+	Page       int    `json:"page"`
+	Source     string `json:"source"`
+	Snippet    string `json:"snippet"`
+	score      int
+}
+
+// DefaultPath returns the location of the index in the user cache dir
+func DefaultPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "rmapi", "search.json"), nil
+}
+
+// Open loads the index stored at path, an empty index is returned if there
+// is none yet or it was written by another version
+func Open(path string) (*Index, error) {
+	idx := &Index{
+		Version:   indexVersion,
+		Documents: make(map[string]*Document),
+		path:      path,
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored Index
+	if err := json.Unmarshal(b, &stored); err != nil || stored.Version != indexVersion {
+		return idx, nil
+	}
+	if stored.Documents != nil {
+		idx.Documents = stored.Documents
+	}
+	return idx, nil
+}
+
+// Update replaces the indexed text of a document, a document without text
+// is removed
+func (idx *Index) Update(doc Document) {
+	if len(doc.Pages) == 0 {
+		idx.Remove(doc.ID)
+		return
+	}
+	idx.Documents[doc.ID] = &doc
+}
+
+// Remove drops a document from the index
+func (idx *Index) Remove(id string) {
+	delete(idx.Documents, id)
+}
+
+// Save writes the index back to the file it was opened from
+func (idx *Index) Save() error {
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0700); err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomicPerm(idx.path, 0600, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(idx)
+	})
+}
+
+// Search returns the pages containing every word of the query (case
+// insensitive), best matches first
+func (idx *Index) Search(query string) []Result {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var results []Result
+	for _, doc := range idx.Documents {
+		for _, page := range doc.Pages {
+			text := strings.ToLower(page.Text)
+
+			score := 0
+			for _, term := range terms {
+				n := strings.Count(text, term)
+				if n == 0 {
+					score = 0
+					break
+				}
+				score += n
+			}
+			if score == 0 {
+				continue
+			}
+
+			results = append(results, Result{
+				DocumentID: doc.ID,
+				Path:       doc.Path,
+				Page:       page.Number,
+				Source:     page.Source,
+				Snippet:    snippet(page.Text, terms[0]),
+				score:      score,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Page < b.Page
+	})
+
+	return results
+}
+
+// snippetContext is the number of characters kept around the match
+const snippetContext = 40
+
+// snippet returns the text around the first occurrence of term, on a
+// single line
+func snippet(text, term string) string {
+	runes := []rune(text)
+	// lowercased rune by rune so that positions match runes
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	termRunes := []rune(term)
+
+	pos := 0
+	for i := 0; i+len(termRunes) <= len(lower); i++ {
+		if string(lower[i:i+len(termRunes)]) == term {
+			pos = i
+			break
+		}
+	}
+
+	start := max(pos-snippetContext, 0)
+	end := min(pos+len(termRunes)+snippetContext, len(runes))
+
+	// don't cut words
+	for start > 0 && start < pos && !unicode.IsSpace(runes[start-1]) {
+		start++
+	}
+	for end < len(runes) && end > pos+len(termRunes) && !unicode.IsSpace(runes[end]) {
+		end--
+	}
+
+	s := strings.Join(strings.FieldsFunc(string(runes[start:end]), unicode.IsSpace), " ")
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(runes) {
+		s += "..."
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package search
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.json")
+
+	idx, err := Open(path)
+	assert.Nil(t, err)
+
+	idx.Update(Document{ID: "1", Path: "/Notes/meeting", Pages: []Page{
+		{Number: 1, Source: "typed", Text: "Agenda: budget review"},
+		{Number: 2, Source: "ocr", Text: "Budget approved, budget owner is Alice"},
+	}})
+	idx.Update(Document{ID: "2", Path: "/Books/novel", Pages: []Page{
+		{Number: 7, Source: "typed", Text: "nothing to see here"},
+	}})
+	assert.Nil(t, idx.Save())
+
+	idx, err = Open(path)
+	assert.Nil(t, err)
+	assert.Len(t, idx.Documents, 2)
+
+	results := idx.Search("BUDGET")
+	assert.Len(t, results, 2)
+	assert.Equal(t, 2, results[0].Page)
+	assert.Equal(t, "ocr", results[0].Source)
+	assert.Equal(t, "/Notes/meeting", results[1].Path)
+	assert.Equal(t, "Agenda: budget review", results[1].Snippet)
+
+	assert.Len(t, idx.Search("budget alice"), 1)
+	assert.Len(t, idx.Search("budget novel"), 0)
+
+	idx.Update(Document{ID: "1"})
+	assert.Len(t, idx.Search("budget"), 0)
+}
+
+func TestSnippet(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog and keeps running\nuntil it reaches the forest where it finally rests"
+	assert.Equal(t, "...over the lazy dog and keeps running until it reaches the forest where it finally...",
+		snippet(text, "until"))
+}
@@ -1,16 +1,81 @@
 package shell
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
 )
 
+// accountInfo is the output of account -json
+type accountInfo struct {
+	User           string    `json:"user"`
+	Subscription   string    `json:"subscription,omitempty"`
+	SyncVersion    string    `json:"syncVersion"`
+	TokenExpiresAt time.Time `json:"tokenExpiresAt,omitempty"`
+	model.AccountStats
+}
+
 func accountCommand(ctx *Context) Command {
 	return Command{
-		Name: "account",
-		Help: "account info",
+		Name:  "account",
+		Help:  "account info: user, subscription, documents and storage used, sync state",
+		Usage: "[-json]",
+		Examples: []string{
+			"rmapi account",
+			"rmapi account -json",
+		},
 		Func: func(ctx *Context, args []string) error {
-			fmt.Printf("User: %s, SyncVersion: %v\n", ctx.UserInfo.User, ctx.UserInfo.SyncVersion)
-			return nil
+			flagSet := newFlagSet(ctx, "account")
+			asJSON := flagSet.Bool("json", false, "print the info as JSON")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			info := accountInfo{
+				User:           ctx.UserInfo.User,
+				Subscription:   ctx.UserInfo.Subscription,
+				SyncVersion:    ctx.UserInfo.SyncVersion.String(),
+				TokenExpiresAt: ctx.UserInfo.TokenExpiresAt,
+				AccountStats:   ctx.api.AccountStats(),
+			}
+
+			if *asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(info)
+			}
+
+			subscription := info.Subscription
+			if subscription == "" {
+				subscription = "unknown"
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(tw, "User:\t%s\n", info.User)
+			fmt.Fprintf(tw, "Subscription:\t%s\n", subscription)
+			fmt.Fprintf(tw, "SyncVersion:\t%s\n", info.SyncVersion)
+			fmt.Fprintf(tw, "Documents:\t%d (%d folders, %d entries in the trash)\n", info.Documents, info.Folders, info.Trashed)
+			fmt.Fprintf(tw, "Storage used:\t%s\n", util.FormatSize(info.Size))
+			fmt.Fprintf(tw, "Root hash:\t%s (generation %d)\n", info.RootHash, info.Generation)
+			fmt.Fprintf(tw, "Last sync:\t%s\n", formatTime(info.LastSync))
+			fmt.Fprintf(tw, "Last change:\t%s\n", formatTime(info.LastModified))
+			if !info.TokenExpiresAt.IsZero() {
+				fmt.Fprintf(tw, "Token expires:\t%s\n", formatTime(info.TokenExpiresAt))
+			}
+			return tw.Flush()
 		},
 	}
 }
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,292 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/juruen/rmapi/backup"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/util"
+)
+
+func archiveCommand(ctx *Context) Command {
+	return Command{
+		Name:  "archive",
+		Help:  "back up the whole library to a folder, .rmdoc and PDF files with a manifest, or check a backup with -verify",
+		Usage: "[options]",
+		Examples: []string{
+			"rmapi archive -o ~/remarkable-backup",
+			"rmapi archive -verify -o ~/remarkable-backup",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "archive")
+			outputDir := flagSet.String("o", "rmapi-archive", "archive directory")
+			pdf := flagSet.Bool("pdf", true, "export the documents to PDF next to their .rmdoc files")
+			dpi := flagSet.Int("dpi", 300, "render DPI of the PDFs")
+			verify := flagSet.Bool("verify", false, "compare the archive with the cloud and check its files instead of updating it")
+			full := flagSet.Bool("full", false, "with -verify, check the content of the files against their hash, not only their size")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			if len(flagSet.Args()) > 0 {
+				return errors.New("archive takes no arguments, it backs up the whole library")
+			}
+			dir := *outputDir
+
+			if *verify {
+				manifest, err := backup.Load(dir)
+				if err != nil {
+					return err
+				}
+				return verifyArchive(ctx, manifest, dir, *full)
+			}
+
+			manifest, err := backup.Load(dir)
+			if errors.Is(err, backup.ErrNoManifest) {
+				manifest = &backup.Manifest{}
+			} else if err != nil {
+				return err
+			}
+
+			var exporter rmconvert.Exporter
+			if *pdf {
+				if exporter, err = rmconvert.LookupExporter("pdf"); err != nil {
+					return err
+				}
+			}
+			exportOpts := rmconvert.ExportOptions{DPI: *dpi, TemplatesDir: templateLibrary()}
+			return updateArchive(ctx, manifest, dir, exporter, exportOpts)
+		},
+	}
+}
+
+// archiveEntries walks the library, trash excluded, calling fn with the
+// entry of each folder and document and the local path of its files in the
+// archive, without extension
+func archiveEntries(ctx *Context, fn func(node *model.Node, entry backup.Entry, localPath string) error) error {
+	tree := ctx.api.Filetree()
+	namer := filetree.DefaultLocalNamer.Cached()
+	return filetree.Walk(tree.Root(), filetree.WalkOptions{}, func(node *model.Node, currentPath []string) error {
+		if err := ctx.goCtx.Err(); err != nil {
+			return err
+		}
+		if node.IsRoot() {
+			return nil
+		}
+		if node.Id() == filetree.TrashID {
+			return filetree.SkipDir
+		}
+
+		remotePath, err := tree.NodeToPath(node)
+		if err != nil {
+			return err
+		}
+		dir := namer.Dir(node, len(currentPath))[1:]
+		localPath := path.Join(append(dir, namer.Name(node))...)
+		return fn(node, backup.NewEntry(node, remotePath), localPath)
+	})
+}
+
+// updateArchive downloads the documents changed since the archive was last
+// updated, moves the files of the documents moved in the library and
+// removes the files of the ones deleted. A document that fails to download
+// keeps its previous copy.
+func updateArchive(ctx *Context, old *backup.Manifest, dir string, exporter rmconvert.Exporter, exportOpts rmconvert.ExportOptions) error {
+	summary := &batchSummary{}
+	manifest := &backup.Manifest{Created: time.Now().UTC()}
+	visited := make(map[string]bool)
+
+	walkErr := archiveEntries(ctx, func(node *model.Node, entry backup.Entry, localPath string) error {
+		visited[entry.ID] = true
+		if entry.IsDirectory() {
+			manifest.Entries = append(manifest.Entries, entry)
+			return nil
+		}
+
+		prev := old.Entry(entry.ID)
+		if prev != nil && prev.Hash != "" && prev.Hash == entry.Hash && archivedFiles(dir, prev) {
+			files, err := moveArchivedFiles(dir, prev.Files, localPath)
+			if err != nil {
+				log.Error.Printf("failed to move %s: %v", entry.Path, err)
+				summary.failed(entry.Path, "move", err)
+				manifest.Entries = append(manifest.Entries, *prev)
+				return nil
+			}
+			entry.Files = files
+			manifest.Entries = append(manifest.Entries, entry)
+			summary.succeeded()
+			return nil
+		}
+
+		rmdocFile := localPath + "." + util.RMDOC
+		rmdocPath := filepath.Join(dir, filepath.FromSlash(rmdocFile))
+		fmt.Printf("downloading [%s]...", entry.Path)
+		err := os.MkdirAll(filepath.Dir(rmdocPath), 0755)
+		if err == nil {
+			err = ctx.api.FetchDocument(ctx.goCtx, entry.ID, rmdocPath)
+		}
+		if err == nil {
+			var f backup.File
+			if f, err = backup.HashFile(dir, rmdocFile); err == nil {
+				entry.Files = append(entry.Files, f)
+			}
+		}
+		if err != nil {
+			fmt.Println(" FAILED")
+			log.Error.Printf("failed to download %s: %v", entry.Path, err)
+			summary.failed(entry.Path, "download", err)
+			if prev != nil {
+				manifest.Entries = append(manifest.Entries, *prev)
+			}
+			return nil
+		}
+		fmt.Println(" OK")
+
+		if exporter != nil {
+			pdfFile := localPath + "." + util.PDF
+			fmt.Printf("converting [%s]...", entry.Path)
+			_, err := exporter.Export(ctx.goCtx, rmdocPath, filepath.Join(dir, filepath.FromSlash(pdfFile)), exportOpts)
+			var f backup.File
+			if err == nil {
+				f, err = backup.HashFile(dir, pdfFile)
+			}
+			if err != nil {
+				// the .rmdoc file is backed up all the same
+				fmt.Println(" FAILED")
+				summary.warned(entry.Path, "convert", err)
+			} else {
+				fmt.Println(" OK")
+				entry.Files = append(entry.Files, f)
+			}
+		}
+
+		manifest.Entries = append(manifest.Entries, entry)
+		summary.succeeded()
+		return nil
+	})
+
+	if walkErr != nil {
+		// the entries not reached yet keep their files
+		for _, entry := range old.Entries {
+			if !visited[entry.ID] {
+				manifest.Entries = append(manifest.Entries, entry)
+			}
+		}
+	} else {
+		removeArchivedFiles(dir, old, manifest)
+	}
+
+	if err := manifest.Save(dir); err != nil {
+		return err
+	}
+	summary.print(os.Stdout)
+	if walkErr != nil {
+		return fmt.Errorf("interrupted: %v", walkErr)
+	}
+	return summary.err()
+}
+
+// archivedFiles tells whether the files of an entry are in the archive
+func archivedFiles(dir string, entry *backup.Entry) bool {
+	if len(entry.Files) == 0 {
+		return false
+	}
+	for _, f := range entry.Files {
+		if err := f.Check(dir, false); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// moveArchivedFiles renames the files of a document moved or renamed in the
+// library to its new local path, keeping their extensions
+func moveArchivedFiles(dir string, files []backup.File, localPath string) ([]backup.File, error) {
+	moved := make([]backup.File, len(files))
+	for i, f := range files {
+		moved[i] = f
+		moved[i].Path = localPath + path.Ext(f.Path)
+		if f.Path == moved[i].Path {
+			continue
+		}
+		newPath := filepath.Join(dir, filepath.FromSlash(moved[i].Path))
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(dir, filepath.FromSlash(f.Path)), newPath); err != nil {
+			return nil, err
+		}
+		removeEmptyDirs(dir, path.Dir(f.Path))
+	}
+	return moved, nil
+}
+
+// removeArchivedFiles removes the files of the previous manifest that the
+// new one doesn't list, those of the deleted documents and of the old
+// versions of the changed ones whose name changed
+func removeArchivedFiles(dir string, old, manifest *backup.Manifest) {
+	kept := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		for _, f := range entry.Files {
+			kept[f.Path] = true
+		}
+	}
+	for _, entry := range old.Entries {
+		for _, f := range entry.Files {
+			if kept[f.Path] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, filepath.FromSlash(f.Path))); err != nil && !os.IsNotExist(err) {
+				log.Warning.Printf("failed to remove %s: %v", f.Path, err)
+				continue
+			}
+			removeEmptyDirs(dir, path.Dir(f.Path))
+		}
+	}
+}
+
+// removeEmptyDirs removes a folder of the archive and its parents as long
+// as they are empty
+func removeEmptyDirs(dir, rel string) {
+	for rel != "." && rel != "/" && rel != "" {
+		if os.Remove(filepath.Join(dir, filepath.FromSlash(rel))) != nil {
+			return
+		}
+		rel = path.Dir(rel)
+	}
+}
+
+// verifyArchive prints the differences between the archive and the cloud,
+// it fails if there are any
+func verifyArchive(ctx *Context, manifest *backup.Manifest, dir string, full bool) error {
+	var current []backup.Entry
+	err := archiveEntries(ctx, func(node *model.Node, entry backup.Entry, localPath string) error {
+		current = append(current, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	diffs := manifest.Verify(dir, current, full)
+	for _, diff := range diffs {
+		if diff.Err != nil {
+			fmt.Printf("%-8s %s: %v\n", diff.Status, diff.Entry.Path, diff.Err)
+		} else {
+			fmt.Printf("%-8s %s\n", diff.Status, diff.Entry.Path)
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("the archive of %s differs from the cloud in %d entries", manifest.Created.Local().Format("2006-01-02 15:04"), len(diffs))
+	}
+	fmt.Printf("the archive of %s matches the cloud, %d entries\n", manifest.Created.Local().Format("2006-01-02 15:04"), len(manifest.Entries))
+	return nil
+}
@@ -0,0 +1,125 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/integrations"
+)
+
+// Exit codes of batch commands, so that scripts can tell a partial failure
+// (some items failed) from a total failure (every item failed)
+const (
+	ExitPartialFailure = 2
+	ExitTotalFailure   = 3
+)
+
+// ExitError is returned by commands that want rmapi to exit with a specific code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+type batchFailure struct {
+	path  string
+	stage string
+	err   error
+}
+
+// batchSummary keeps track of the outcome of each item processed by a batch command
+type batchSummary struct {
+	ok       int
+	failures []batchFailure
+	// warnings are the problems of items that succeeded, e.g. pages
+	// whose OCR timed out
+	warnings []batchFailure
+}
+
+func (s *batchSummary) succeeded() {
+	s.ok++
+}
+
+func (s *batchSummary) failed(path, stage string, err error) {
+	s.failures = append(s.failures, batchFailure{path, stage, err})
+}
+
+// warned records a problem of an item that succeeded, it isn't a failure
+func (s *batchSummary) warned(path, stage string, err error) {
+	s.warnings = append(s.warnings, batchFailure{path, stage, err})
+}
+
+// formatPages lists page numbers for the summary, e.g. "2, 5"
+func formatPages(pages []int) string {
+	s := make([]string, len(pages))
+	for i, page := range pages {
+		s[i] = strconv.Itoa(page)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (s *batchSummary) total() int {
+	return s.ok + len(s.failures)
+}
+
+// print writes the counts and tables with the failed items and the
+// warnings
+func (s *batchSummary) print(w io.Writer) {
+	fmt.Fprintf(w, "\nSummary: %d processed, %d ok, %d failed\n", s.total(), s.ok, len(s.failures))
+	if len(s.failures) > 0 {
+		printBatchFailures(w, "ERROR", s.failures)
+	}
+	if len(s.warnings) > 0 {
+		fmt.Fprintln(w)
+		printBatchFailures(w, "WARNING", s.warnings)
+	}
+}
+
+func printBatchFailures(w io.Writer, column string, failures []batchFailure) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "STAGE\tPATH\t%s\n", column)
+	for _, f := range failures {
+		fmt.Fprintf(tw, "%s\t%s\t%v\n", f.stage, f.path, f.err)
+	}
+	tw.Flush()
+}
+
+// err returns nil if every item succeeded, or an *ExitError with
+// ExitPartialFailure or ExitTotalFailure
+func (s *batchSummary) err() error {
+	if len(s.failures) == 0 {
+		return nil
+	}
+
+	code := ExitPartialFailure
+	if s.ok == 0 {
+		code = ExitTotalFailure
+	}
+	return &ExitError{
+		Code: code,
+		Err:  fmt.Errorf("%d of %d items failed", len(s.failures), s.total()),
+	}
+}
+
+// webhookSummary returns the counts and failures for a webhook event
+func (s *batchSummary) webhookSummary() *integrations.WebhookSummary {
+	summary := &integrations.WebhookSummary{
+		Processed: s.total(),
+		OK:        s.ok,
+		Failed:    len(s.failures),
+	}
+	for _, f := range s.failures {
+		summary.Errors = append(summary.Errors, integrations.WebhookError{Path: f.path, Stage: f.stage, Error: f.err.Error()})
+	}
+	return summary
+}
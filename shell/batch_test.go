@@ -0,0 +1,47 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBatchSummaryExitCodes(t *testing.T) {
+	s := &batchSummary{}
+	s.succeeded()
+	if s.err() != nil {
+		t.Fatalf("unexpected error %v", s.err())
+	}
+
+	s.failed("a.rmdoc", "convert", errors.New("boom"))
+	var exitErr *ExitError
+	if !errors.As(s.err(), &exitErr) || exitErr.Code != ExitPartialFailure {
+		t.Errorf("expected partial failure, got %v", s.err())
+	}
+
+	s = &batchSummary{}
+	s.failed("a.rmdoc", "download", errors.New("boom"))
+	if !errors.As(s.err(), &exitErr) || exitErr.Code != ExitTotalFailure {
+		t.Errorf("expected total failure, got %v", s.err())
+	}
+
+	var buf bytes.Buffer
+	s.print(&buf)
+	if !strings.Contains(buf.String(), "a.rmdoc") || !strings.Contains(buf.String(), "boom") {
+		t.Errorf("failure missing from summary:\n%s", buf.String())
+	}
+
+	s = &batchSummary{}
+	s.succeeded()
+	s.warned("b.rmdoc", "ocr", fmt.Errorf("OCR timed out on pages %s", formatPages([]int{2, 5})))
+	if s.err() != nil {
+		t.Errorf("warnings shouldn't fail the batch, got %v", s.err())
+	}
+	buf.Reset()
+	s.print(&buf)
+	if !strings.Contains(buf.String(), "WARNING") || !strings.Contains(buf.String(), "pages 2, 5") {
+		t.Errorf("warning missing from summary:\n%s", buf.String())
+	}
+}
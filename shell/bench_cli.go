@@ -0,0 +1,115 @@
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/util"
+)
+
+// benchCommand measures the conversions on a sample document. It needs no
+// cloud access and isn't listed with the other commands, see RunBench.
+func benchCommand() Command {
+	return Command{
+		Name:  "bench",
+		Help:  "measure the speed and memory of the conversions on a sample document",
+		Usage: "[options]",
+		Examples: []string{
+			"rmapi bench",
+			"rmapi bench -format pdf,png -dpi 150,300 -pages 20",
+			"rmapi bench -json -cpuprofile cpu.out",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "bench")
+			formats := flagSet.String("format", "pdf,png,svg", "comma separated formats to convert to")
+			dpis := flagSet.String("dpi", "150,300", "comma separated resolutions to render at")
+			pages := flagSet.Int("pages", 10, "number of pages of the sample document")
+			jsonOutput := flagSet.Bool("json", false, "print one JSON object per conversion")
+			cpuProfile := flagSet.String("cpuprofile", "", "write a CPU profile of the conversions to this file")
+			memProfile := flagSet.String("memprofile", "", "write a heap profile after the conversions to this file")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			var resolutions []int
+			for _, s := range strings.Split(*dpis, ",") {
+				dpi, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil || dpi <= 0 {
+					return fmt.Errorf("invalid DPI %q", s)
+				}
+				resolutions = append(resolutions, dpi)
+			}
+
+			dir, err := os.MkdirTemp("", "rmapi-bench-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			rmdocPath := filepath.Join(dir, "sample.rmdoc")
+			if err := rmconvert.WriteSampleDocument(rmdocPath, *pages); err != nil {
+				return err
+			}
+
+			if *cpuProfile != "" {
+				f, err := os.Create(*cpuProfile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if err := pprof.StartCPUProfile(f); err != nil {
+					return err
+				}
+				defer pprof.StopCPUProfile()
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			if !*jsonOutput {
+				fmt.Fprintln(tw, "FORMAT\tDPI\tPAGES\tTIME\tPAGES/S\tALLOCATED\tPEAK RSS")
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			for _, format := range strings.Split(*formats, ",") {
+				for _, dpi := range resolutions {
+					result, err := rmconvert.Benchmark(ctx.goCtx, rmdocPath, dir, strings.TrimSpace(format), dpi)
+					if err != nil {
+						tw.Flush()
+						return err
+					}
+					if *jsonOutput {
+						encoder.Encode(result)
+						continue
+					}
+					fmt.Fprintf(tw, "%s\t%d\t%d\t%.2fs\t%.2f\t%s\t%s\n", result.Format, result.DPI, result.Pages,
+						result.Duration.Seconds(), result.PagesPerSecond, util.FormatSize(int64(result.Allocated)), util.FormatSize(result.PeakRSS))
+				}
+			}
+			tw.Flush()
+
+			if *memProfile != "" {
+				f, err := os.Create(*memProfile)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if err := pprof.WriteHeapProfile(f); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// RunBench runs the bench command with its arguments, without the cloud
+func RunBench(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, benchCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"bench"}, args...))
+}
@@ -1,11 +1,16 @@
 package shell
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"sort"
 
 	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/log"
 	"github.com/juruen/rmapi/model"
 )
 
@@ -13,16 +18,29 @@ import (
 type Command struct {
 	Name string
 	Help string
+	// Usage is the synopsis of the arguments, one line per form, e.g.
+	// "[options] <remote dir>"
+	Usage string
+	// Examples are command lines shown in the help
+	Examples []string
+	// Func runs the command, it parses its flags with the flag set of
+	// newFlagSet
 	Func func(ctx *Context, args []string) error
 }
 
 // Context holds the execution context for commands
 type Context struct {
+	// goCtx is cancelled on interrupt, long running commands should stop
+	goCtx          context.Context
 	node           *model.Node
 	api            api.ApiCtx
 	path           string
 	useHiddenFiles bool
-	UserInfo       api.UserInfo
+	// aliases of the config file, see config.LoadAliases
+	aliases map[string]string
+	// commands are the registered commands, for their help
+	commands map[string]Command
+	UserInfo api.UserInfo
 }
 
 func useHiddenFiles() bool {
@@ -33,45 +51,127 @@ func useHiddenFiles() bool {
 	return val != "0"
 }
 
-// RunCLI executes CLI commands without interactive shell
-func RunCLI(apiCtx api.ApiCtx, userInfo *api.UserInfo, args []string) error {
-	ctx := &Context{
+func newContext(goCtx context.Context, apiCtx api.ApiCtx, userInfo *api.UserInfo) *Context {
+	return &Context{
+		goCtx:          goCtx,
 		node:           apiCtx.Filetree().Root(),
 		api:            apiCtx,
 		path:           apiCtx.Filetree().Root().Name(),
 		useHiddenFiles: useHiddenFiles(),
+		aliases:        loadAliases(),
 		UserInfo:       *userInfo,
 	}
+}
+
+// loadAliases returns the aliases of the config file, none if it can't be
+// read
+func loadAliases() map[string]string {
+	configPath, err := config.ConfigPath()
+	if err != nil {
+		return nil
+	}
+	aliases, err := config.LoadAliases(configPath)
+	if err != nil {
+		log.Warning.Printf("no aliases: %v", err)
+	}
+	return aliases
+}
 
-	// Register all commands
+// registerCommands returns the commands of the CLI and the interactive shell
+func registerCommands(ctx *Context) map[string]Command {
 	commands := make(map[string]Command)
+	registerCommand(commands, lsCommand(ctx))
+	registerCommand(commands, cdCommand(ctx))
+	registerCommand(commands, pwdCommand(ctx))
+	registerCommand(commands, findCommand(ctx))
+	registerCommand(commands, duCommand(ctx))
 	registerCommand(commands, getCommand(ctx))
+	registerCommand(commands, putCommand(ctx))
+	registerCommand(commands, cpCommand(ctx))
 	registerCommand(commands, mgetCommand(ctx))
 	registerCommand(commands, mgetaCommand(ctx))
 	registerCommand(commands, versionCommand(ctx))
 	registerCommand(commands, getaCommand(ctx))
 	registerCommand(commands, accountCommand(ctx))
 	registerCommand(commands, refreshCommand(ctx))
+	registerCommand(commands, historyCommand(ctx))
+	registerCommand(commands, searchCommand(ctx))
+	registerCommand(commands, thumbsCommand(ctx))
+	registerCommand(commands, diffCommand(ctx))
+	registerCommand(commands, statsCommand(ctx))
+	registerCommand(commands, exportCommand(ctx))
+	registerCommand(commands, archiveCommand(ctx))
+	registerCommand(commands, restoreCommand(ctx))
+	registerCommand(commands, dedupeCommand(ctx))
+	registerCommand(commands, verifyCommand(ctx))
+	registerCommand(commands, queueCommand())
+	registerCommand(commands, encryptCommand())
+	registerCommand(commands, ocrCommand())
+	registerCommand(commands, templatesCommand())
+	registerCommand(commands, screenshotCommand())
+	ctx.commands = commands
+	return commands
+}
+
+// RunCLI executes CLI commands without interactive shell
+func RunCLI(goCtx context.Context, apiCtx api.ApiCtx, userInfo *api.UserInfo, args []string) error {
+	ctx := newContext(goCtx, apiCtx, userInfo)
+	commands := registerCommands(ctx)
+
+	if len(args) == 0 || args[0] == "help" {
+		if len(args) > 1 {
+			return printHelp(ctx, commands, args[1])
+		}
+		printUsage(ctx, commands)
+		return nil
+	}
+
+	return runCommand(ctx, commands, args)
+}
 
-	if len(args) == 0 {
-		printUsage(commands)
+// runCommand runs a command line split into arguments, the first one is
+// the name of a command or an alias
+func runCommand(ctx *Context, commands map[string]Command, args []string) error {
+	args = expandAlias(ctx, commands, args)
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s\n\nRun 'rmapi help' for usage", args[0])
+	}
+	err := cmd.Func(ctx, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return nil
 	}
+	return err
+}
 
-	cmdName := args[0]
-	cmd, ok := commands[cmdName]
+// expandAlias replaces an alias by its command line, followed by the other
+// arguments. Aliases don't override commands and aren't expanded
+// recursively.
+func expandAlias(ctx *Context, commands map[string]Command, args []string) []string {
+	if _, ok := commands[args[0]]; ok {
+		return args
+	}
+	alias, ok := ctx.aliases[args[0]]
 	if !ok {
-		return fmt.Errorf("unknown command: %s\n\nRun 'rmapi help' for usage", cmdName)
+		return args
 	}
 
-	return cmd.Func(ctx, args[1:])
+	expanded := parseArguments(alias)
+	if len(expanded) == 0 {
+		return args
+	}
+	for i := range expanded {
+		expanded[i] = unescapeSpaces(expanded[i])
+	}
+	return append(expanded, args[1:]...)
 }
 
 func registerCommand(commands map[string]Command, cmd Command) {
 	commands[cmd.Name] = cmd
 }
 
-func printUsage(commands map[string]Command) {
+func printUsage(ctx *Context, commands map[string]Command) {
 	fmt.Println("rmapi - reMarkable Cloud API CLI")
 	fmt.Println("\nUsage: rmapi <command> [options]")
 	fmt.Println("\nAvailable commands:")
@@ -88,5 +188,17 @@ func printUsage(commands map[string]Command) {
 		fmt.Printf("  %-12s %s\n", name, cmd.Help)
 	}
 
-	fmt.Println("\nFor command-specific help, use: rmapi <command> -h")
+	if len(ctx.aliases) > 0 {
+		fmt.Println("\nAliases:")
+		names = names[:0]
+		for name := range ctx.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %-12s %s\n", name, ctx.aliases[name])
+		}
+	}
+
+	fmt.Println("\nFor command-specific help, use: rmapi help <command> or rmapi <command> -h")
 }
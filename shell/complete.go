@@ -0,0 +1,97 @@
+package shell
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+)
+
+// shellCommands are handled by the interactive shell itself
+var shellCommands = []string{"exit", "help", "quit"}
+
+// completeLine returns the start of the last word of line and its
+// candidates: the command names for the first word, and the remote entries
+// of the current directory, or of the directory typed so far, for the
+// others. Directories end with a slash.
+func completeLine(ctx *Context, commands map[string]Command, line string) (int, []string) {
+	runes := []rune(line)
+	start := lastWordStart(runes)
+	word := string(runes[start:])
+
+	if strings.TrimSpace(string(runes[:start])) == "" {
+		return start, completeCommand(commands, ctx.aliases, shellCommands, word)
+	}
+
+	if strings.HasPrefix(word, "-") {
+		return start, nil
+	}
+	return start, completePath(ctx.api.Filetree(), ctx.node, ctx.useHiddenFiles, unescapeSpaces(word), escapeSpaces)
+}
+
+// completeCommand returns the names of the commands, aliases and extra
+// names starting with prefix
+func completeCommand(commands map[string]Command, aliases map[string]string, extra []string, prefix string) []string {
+	var candidates []string
+	for name := range commands {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	for name := range aliases {
+		if _, ok := commands[name]; !ok && strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, name := range extra {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// completePath returns the remote paths starting with prefix, relative to
+// current, quoted with quote
+func completePath(tree *filetree.FileTreeCtx, current *model.Node, hidden bool, prefix string, quote func(string) string) []string {
+	dir, base := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, base = prefix[:i+1], prefix[i+1:]
+	}
+
+	node := current
+	if dir != "" {
+		var err error
+		node, err = tree.NodeByPath(dir, current)
+		if err != nil || node.IsFile() {
+			return nil
+		}
+	}
+
+	var candidates []string
+	for _, child := range visibleChildren(node, hidden) {
+		name := child.DisplayName()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		candidate := quote(dir + name)
+		if child.IsDirectory() {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// lastWordStart returns the index of the word at the end of line, spaces
+// escaped with a backslash are part of the word
+func lastWordStart(line []rune) int {
+	for i := len(line) - 1; i >= 0; i-- {
+		if line[i] == ' ' && (i == 0 || line[i-1] != '\\') {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/juruen/rmapi/filetree"
+)
+
+// CompletionShells are the shells CompletionScript supports
+var CompletionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// CompletionScript returns the script registering the completion of rmapi
+// in a shell. The scripts call rmapi __complete <shell> <words>, see
+// Complete.
+func CompletionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	case "powershell":
+		return powershellCompletion, nil
+	}
+	return "", fmt.Errorf("unknown shell %q, use one of %s", shell, strings.Join(CompletionShells, ", "))
+}
+
+// Complete returns the candidates of the last of the words following rmapi
+// on a command line: the commands and aliases for the first word after the
+// global flags, the remote paths of tree for the others. bash and zsh get
+// the spaces of the paths escaped, fish and powershell quote them.
+func Complete(tree *filetree.FileTreeCtx, shell string, words []string) []string {
+	for len(words) > 1 && strings.HasPrefix(words[0], "-") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	word := words[len(words)-1]
+	if shell == "powershell" {
+		word = strings.Trim(word, `'"`)
+	}
+
+	if len(words) == 1 {
+		return completeCommand(registerCommands(&Context{}), loadAliases(), []string{"help"}, word)
+	}
+	if strings.HasPrefix(word, "-") || tree == nil {
+		return nil
+	}
+
+	quote := escapeSpaces
+	if shell == "fish" || shell == "powershell" {
+		quote = func(s string) string { return s }
+	}
+	return completePath(tree, tree.Root(), useHiddenFiles(), unescapeSpaces(word), quote)
+}
+
+const bashCompletion = `# rmapi completion for bash, load it with:
+#   source <(rmapi completion bash)
+_rmapi() {
+    local IFS=$'\n'
+    COMPREPLY=($(rmapi __complete bash "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+        compopt -o nospace
+    fi
+}
+complete -F _rmapi rmapi
+`
+
+const zshCompletion = `#compdef rmapi
+# rmapi completion for zsh, load it with:
+#   source <(rmapi completion zsh)
+_rmapi() {
+    local -a dirs others
+    local c
+    for c in "${(@f)$(rmapi __complete zsh "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -z $c ]] && continue
+        if [[ $c == */ ]]; then dirs+=("$c"); else others+=("$c"); fi
+    done
+    compadd -Q -S '' -- "${dirs[@]}"
+    compadd -Q -- "${others[@]}"
+}
+compdef _rmapi rmapi
+`
+
+const fishCompletion = `# rmapi completion for fish, load it with:
+#   rmapi completion fish | source
+function __rmapi_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    rmapi __complete fish $tokens[2..-1] 2>/dev/null
+end
+complete -c rmapi -f -a '(__rmapi_complete)'
+`
+
+const powershellCompletion = `# rmapi completion for PowerShell, load it with:
+#   rmapi completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName rmapi -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -eq '') { $words += '' }
+    rmapi __complete powershell @words 2>$null | ForEach-Object {
+        $text = if ($_ -match '\s') { "'$_'" } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+    }
+}
+`
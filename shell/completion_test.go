@@ -0,0 +1,24 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	tree := filetree.CreateFileTreeCtx()
+	tree.AddDocument(&model.Document{ID: "1", Name: "My Books", Type: model.DirectoryType})
+	tree.AddDocument(&model.Document{ID: "2", Parent: "1", Name: "Moby Dick", Type: model.DocumentType})
+	tree.AddDocument(&model.Document{ID: "3", Name: "Meetings", Type: model.DocumentType})
+	tree.FinishAdd()
+
+	assert.Equal(t, []string{"mget", "mgeta"}, Complete(&tree, "bash", []string{"-ni", "mge"}))
+	assert.Equal(t, []string{"Meetings", "My\\ Books/"}, Complete(&tree, "bash", []string{"get", "M"}))
+	assert.Equal(t, []string{"My\\ Books/Moby\\ Dick"}, Complete(&tree, "zsh", []string{"get", "My\\ Books/"}))
+	assert.Equal(t, []string{"My Books/Moby Dick"}, Complete(&tree, "fish", []string{"get", "My\\ Books/Mo"}))
+	assert.Equal(t, []string{"/My Books/"}, Complete(&tree, "powershell", []string{"get", "'/My"}))
+	assert.Empty(t, Complete(&tree, "bash", []string{"get", "-o"}))
+}
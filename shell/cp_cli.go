@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/transport"
+)
+
+func cpCommand(ctx *Context) Command {
+	return Command{
+		Name:  "cp",
+		Help:  "duplicate a remote document into the same or another directory",
+		Usage: "[options] <remote file> [remote dir]",
+		Examples: []string{
+			"rmapi cp -name \"Week 12\" /Templates/Weekly /Planner",
+			"rmapi cp -p /Notes/Meeting /Archive/2024",
+			"rmapi cp -queue /Templates/Weekly /Planner",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "cp")
+			name := flagSet.String("name", "", "name of the copy (default: the name of the document)")
+			createParents := flagSet.Bool("p", false, "create missing remote directories")
+			queued := flagSet.Bool("queue", false, "queue the copy when the cloud is unreachable, see 'rmapi queue'")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file")
+			}
+
+			// without the cloud, see RunQueued, the paths are resolved when
+			// the queue is flushed
+			queueCopy := func(srcPath, dstPath string) error {
+				op, err := queueChange(queue.Op{
+					Kind:          queue.Copy,
+					Target:        srcPath,
+					Dir:           dstPath,
+					CreateParents: *createParents,
+					Name:          *name,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to queue the copy of %s: %v", srcPath, err)
+				}
+				fmt.Printf("queued: copy of [%s] as operation %d, send it with 'rmapi queue flush'\n", srcPath, op.ID)
+				return nil
+			}
+			if ctx.api == nil {
+				if !*queued {
+					return errors.New("the cloud is unreachable")
+				}
+				if len(argRest) < 2 {
+					return errors.New("the destination dir is needed to queue a copy")
+				}
+				return queueCopy(absRemotePath(argRest[0]), absRemotePath(argRest[1]))
+			}
+
+			src, err := ctx.api.Filetree().NodeByPath(argRest[0], ctx.node)
+			if err != nil {
+				return err
+			}
+			if src.IsDirectory() {
+				return fmt.Errorf("%s is a directory", argRest[0])
+			}
+
+			dstDir := src.Parent
+			if len(argRest) > 1 {
+				node, err := ctx.api.Filetree().NodeByPath(argRest[1], ctx.node)
+				if err != nil && *createParents {
+					node, err = mkdirAll(ctx, argRest[1])
+				}
+				if err != nil {
+					return err
+				}
+				if node.IsFile() {
+					return fmt.Errorf("%s is not a directory", argRest[1])
+				}
+				dstDir = node
+			}
+			if dstDir == nil {
+				dstDir = ctx.api.Filetree().Root()
+			}
+
+			err = copyDocument(ctx, src, dstDir, *name)
+			if err != nil && *queued && transport.IsUnreachable(err) {
+				log.Warning.Printf("the cloud is unreachable: %v", err)
+				dstPath, _ := ctx.api.Filetree().NodeToPath(dstDir)
+				return queueCopy(filetree.UUIDPrefix+src.Id(), dstPath)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", argRest[0], err)
+			}
+			return nil
+		},
+	}
+}
+
+// copyDocument copies the document src into dstDir as name, the name of
+// src if empty, and adds the copy to the tree
+func copyDocument(ctx *Context, src, dstDir *model.Node, name string) error {
+	if name == "" {
+		name = src.Name()
+	}
+	if _, err := dstDir.FindByName(name); err == nil {
+		return fmt.Errorf("entry already exists (%s), pass another -name", name)
+	}
+
+	document, err := ctx.api.CopyDocument(src.Id(), dstDir.Id(), name, true)
+	if err != nil {
+		return err
+	}
+	ctx.api.Filetree().AddDocument(document)
+	return nil
+}
+
+// absRemotePath makes a remote path given without the cloud absolute, the
+// current directory being the root
+func absRemotePath(p string) string {
+	if strings.HasPrefix(p, "/") || filetree.HasUUIDPrefix(p) {
+		return p
+	}
+	return path.Join("/", p)
+}
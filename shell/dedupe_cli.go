@@ -0,0 +1,203 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juruen/rmapi/client"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+func dedupeCommand(ctx *Context) Command {
+	return Command{
+		Name:  "dedupe",
+		Help:  "find the documents with the same content, and move the extra copies to the trash or a folder",
+		Usage: "[options] [dir]",
+		Examples: []string{
+			"rmapi dedupe",
+			"rmapi dedupe -i /Imports",
+			"rmapi dedupe -auto -keep newest -move /Duplicates",
+			"rmapi dedupe -auto -queue",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "dedupe")
+			interactive := flagSet.Bool("i", false, "ask which copy of each document to keep")
+			auto := flagSet.Bool("auto", false, "keep one copy of each document, chosen by -keep, without asking")
+			keep := flagSet.String("keep", "oldest", "the copy kept by -auto and proposed first by -i: oldest or newest")
+			moveTo := flagSet.String("move", "", "move the extra copies to this folder, created if missing, instead of the trash")
+			queued := flagSet.Bool("queue", false, "queue the moves when the cloud is unreachable, see 'rmapi queue'")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			if *interactive && *auto {
+				return errors.New("-i and -auto are exclusive")
+			}
+			if *keep != "oldest" && *keep != "newest" {
+				return fmt.Errorf("invalid -keep %q, use oldest or newest", *keep)
+			}
+			argRest := flagSet.Args()
+			if len(argRest) > 1 {
+				return errors.New("too many arguments")
+			}
+
+			tree := ctx.api.Filetree()
+			node := tree.Root()
+			if len(argRest) == 1 {
+				var err error
+				if node, err = tree.NodeByPath(argRest[0], ctx.node); err != nil {
+					return err
+				}
+			}
+
+			groups := duplicateGroups(node, *keep == "newest")
+			if len(groups) == 0 {
+				fmt.Println("no duplicates")
+				return nil
+			}
+
+			answers := bufio.NewReader(stdinInput)
+			var extras []*model.Node
+			var size int64
+			for _, group := range groups {
+				fmt.Printf("%d copies of %q (%s):\n", len(group), group[0].Name(), util.FormatSize(group[0].Document.Size))
+				for i, doc := range group {
+					p, _ := tree.NodeToPath(doc)
+					modified, _ := doc.LastModified()
+					fmt.Printf("  %d  %s  %s\n", i+1, modified.Local().Format("2006-01-02 15:04"), p)
+				}
+
+				kept := 0
+				if *interactive {
+					var err error
+					if kept, err = askKept(answers, len(group)); err == io.EOF {
+						break
+					} else if err != nil {
+						return err
+					}
+					if kept < 0 {
+						continue
+					}
+				}
+				for i, doc := range group {
+					if i != kept {
+						extras = append(extras, doc)
+						size += doc.Document.Size
+					}
+				}
+			}
+			fmt.Printf("%d documents with copies, %d extra copies (%s)\n", len(groups), len(extras), util.FormatSize(size))
+			if (!*interactive && !*auto) || len(extras) == 0 {
+				return nil
+			}
+
+			dst := filetree.TrashID
+			if *moveTo != "" {
+				dir, err := mkdirAll(ctx, *moveTo)
+				if err != nil {
+					return err
+				}
+				dst = dir.Id()
+			}
+			ops := make([]model.BatchOp, len(extras))
+			for i, doc := range extras {
+				ops[i] = model.BatchOp{Kind: model.BatchMove, ID: doc.Id(), Parent: dst}
+			}
+			c := client.NewFromApi(ctx.api, &ctx.UserInfo)
+			if *queued {
+				q, err := openQueue()
+				if err != nil {
+					return err
+				}
+				c.SetQueue(q)
+			}
+			if err := c.Batch(ops); errors.Is(err, client.ErrQueued) {
+				log.Warning.Println(err)
+				fmt.Printf("queued: the move of %d copies, send it with 'rmapi queue flush'\n", len(extras))
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to move the copies: %v", err)
+			}
+			reloadCurrentDir(ctx)
+			if *moveTo != "" {
+				fmt.Printf("moved %d copies to %s\n", len(extras), *moveTo)
+			} else {
+				fmt.Printf("moved %d copies to the trash\n", len(extras))
+			}
+			return nil
+		},
+	}
+}
+
+// duplicateGroups returns the documents below node, trash excluded, with
+// the same content hash, grouped with the one to keep first: the oldest,
+// or the newest if newest is set. The groups are sorted by name.
+func duplicateGroups(node *model.Node, newest bool) [][]*model.Node {
+	byHash := make(map[string][]*model.Node)
+	filetree.Walk(node, filetree.WalkOptions{}, func(n *model.Node, _ []string) error {
+		if n.Id() == filetree.TrashID {
+			return filetree.SkipDir
+		}
+		if n.IsFile() && n.Document.ContentHash != "" {
+			byHash[n.Document.ContentHash] = append(byHash[n.Document.ContentHash], n)
+		}
+		return nil
+	})
+
+	var groups [][]*model.Node
+	for _, group := range byHash {
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			ti, _ := group[i].LastModified()
+			tj, _ := group[j].LastModified()
+			if newest {
+				return ti.After(tj)
+			}
+			return ti.Before(tj)
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i][0].Name() != groups[j][0].Name() {
+			return groups[i][0].Name() < groups[j][0].Name()
+		}
+		return groups[i][0].Id() < groups[j][0].Id()
+	})
+	return groups
+}
+
+// askKept asks which of n copies to keep, returning its index or -1 to
+// keep them all. io.EOF is returned when the user stops.
+func askKept(r *bufio.Reader, n int) (int, error) {
+	for {
+		fmt.Printf("keep [1-%d], s to skip, q to stop (1): ", n)
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return 0, err
+		}
+		switch answer := strings.TrimSpace(line); answer {
+		case "":
+			return 0, nil
+		case "s":
+			return -1, nil
+		case "q":
+			return 0, io.EOF
+		default:
+			if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= n {
+				return i - 1, nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "invalid answer %q\n", strings.TrimSpace(line))
+	}
+}
@@ -0,0 +1,55 @@
+package shell
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateGroups(t *testing.T) {
+	tree := filetree.CreateFileTreeCtx()
+	for _, doc := range []model.Document{
+		{ID: "inbox", Name: "Inbox", Type: model.DirectoryType},
+		{ID: "report", Name: "Report", Type: model.DocumentType, ContentHash: "h1", ModifiedClient: "2024-05-02T10:00:00Z"},
+		{ID: "report2", Name: "Report", Type: model.DocumentType, Parent: "inbox", ContentHash: "h1", ModifiedClient: "2024-05-01T10:00:00Z"},
+		{ID: "report3", Name: "Report copy", Type: model.DocumentType, Parent: "inbox", ContentHash: "h1", ModifiedClient: "2024-05-03T10:00:00Z"},
+		{ID: "blank", Name: "Blank", Type: model.DocumentType},
+		{ID: "blank2", Name: "Blank", Type: model.DocumentType, Parent: "inbox"},
+		{ID: "book", Name: "Book", Type: model.DocumentType, ContentHash: "h2"},
+		{ID: "trashed", Name: "Book", Type: model.DocumentType, Parent: filetree.TrashID, ContentHash: "h2"},
+	} {
+		tree.AddDocument(&doc)
+	}
+	tree.FinishAdd()
+
+	ids := func(groups [][]*model.Node) [][]string {
+		var result [][]string
+		for _, group := range groups {
+			var groupIDs []string
+			for _, node := range group {
+				groupIDs = append(groupIDs, node.Id())
+			}
+			result = append(result, groupIDs)
+		}
+		return result
+	}
+	assert.Equal(t, [][]string{{"report2", "report", "report3"}}, ids(duplicateGroups(tree.Root(), false)))
+	assert.Equal(t, [][]string{{"report3", "report", "report2"}}, ids(duplicateGroups(tree.Root(), true)))
+	assert.Equal(t, [][]string{{"report2", "report3"}}, ids(duplicateGroups(tree.NodeById("inbox"), false)))
+}
+
+func TestAskKept(t *testing.T) {
+	answers := bufio.NewReader(strings.NewReader("\n3\nx\n2\ns\nq\n"))
+	for _, want := range []int{0, 2, 1, -1} {
+		kept, err := askKept(answers, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, want, kept)
+	}
+	_, err := askKept(answers, 3)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,177 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/util"
+)
+
+func diffCommand(ctx *Context) Command {
+	return Command{
+		Name:  "diff",
+		Help:  "compare the pages and strokes of two versions of a notebook",
+		Usage: "[options] old.rmdoc new.rmdoc\n[options] <remote file> local.rmdoc\n[options] -version N <remote file>",
+		Examples: []string{
+			"rmapi diff -o diffs old.rmdoc new.rmdoc",
+			"rmapi diff -version 3 /Notes/Meeting",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "diff")
+			outputDir := flagSet.String("o", "", "write an image of each changed page to this directory")
+			dpi := flagSet.Int("dpi", 100, "DPI of the diff images")
+			version := flagSet.Int("version", 0, "compare a previous version (see history) with the current one")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			tmpDir, err := os.MkdirTemp("", "rmdiff")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpDir)
+
+			oldPath, newPath, err := diffSources(ctx, flagSet.Args(), *version, tmpDir)
+			if err != nil {
+				return err
+			}
+
+			diff, err := rmconvert.DiffRmdocs(oldPath, newPath)
+			if err != nil {
+				return err
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "PAGE\tSTATUS\tSTROKES\tADDED\tREMOVED")
+			for _, p := range diff.Pages {
+				fmt.Fprintf(tw, "%s\t%s\t%d -> %d\t+%d\t-%d\n",
+					pageNumbers(p.OldPage, p.NewPage), p.Status, p.OldStrokes(), p.NewStrokes(), len(p.Added), len(p.Removed))
+			}
+			tw.Flush()
+
+			if !diff.Changed() {
+				fmt.Println("\nno changes")
+				return nil
+			}
+			if *outputDir == "" {
+				return nil
+			}
+
+			if err := os.MkdirAll(*outputDir, 0755); err != nil {
+				return err
+			}
+			for _, p := range diff.Pages {
+				if p.Status == rmconvert.PageUnchanged {
+					continue
+				}
+				page := p.NewPage
+				if page == 0 {
+					page = p.OldPage
+				}
+				imgPath := filepath.Join(*outputDir, fmt.Sprintf("page_%03d.diff.png", page))
+				if p.Status == rmconvert.PageRemoved {
+					imgPath = filepath.Join(*outputDir, fmt.Sprintf("removed_page_%03d.diff.png", page))
+				}
+
+				fmt.Printf("writing [%s]...", imgPath)
+				if err := writeDiffImage(&p, imgPath, *dpi); err != nil {
+					fmt.Println(" FAILED")
+					return err
+				}
+				fmt.Println(" OK")
+			}
+
+			return nil
+		},
+	}
+}
+
+// diffSources returns the old and new .rmdoc files to compare, downloading
+// remote documents into tmpDir
+func diffSources(ctx *Context, args []string, version int, tmpDir string) (string, string, error) {
+	if version > 0 {
+		if len(args) != 1 {
+			return "", "", errors.New("-version takes a single remote file")
+		}
+		node, err := ctx.api.Filetree().NodeByPath(args[0], ctx.node)
+		if err != nil {
+			return "", "", err
+		}
+		if node.IsDirectory() {
+			return "", "", fmt.Errorf("%s is a directory", args[0])
+		}
+
+		oldPath := filepath.Join(tmpDir, "old."+util.RMDOC)
+		if err := ctx.api.FetchDocumentVersion(ctx.goCtx, node.Id(), version, oldPath); err != nil {
+			return "", "", fmt.Errorf("failed to download version %d: %v", version, err)
+		}
+		newPath := filepath.Join(tmpDir, "new."+util.RMDOC)
+		if err := ctx.api.FetchDocument(ctx.goCtx, node.Id(), newPath); err != nil {
+			return "", "", fmt.Errorf("failed to download %s: %v", args[0], err)
+		}
+		return oldPath, newPath, nil
+	}
+
+	if len(args) != 2 {
+		return "", "", errors.New("expected two documents to compare")
+	}
+
+	if isLocalRmdoc(args[0]) && isLocalRmdoc(args[1]) {
+		return args[0], args[1], nil
+	}
+	if !isLocalRmdoc(args[1]) {
+		return "", "", fmt.Errorf("%s is not a local .rmdoc file", args[1])
+	}
+
+	node, err := ctx.api.Filetree().NodeByPath(args[0], ctx.node)
+	if err != nil {
+		return "", "", err
+	}
+	if node.IsDirectory() {
+		return "", "", fmt.Errorf("%s is a directory", args[0])
+	}
+
+	remotePath := filepath.Join(tmpDir, "remote."+util.RMDOC)
+	if err := ctx.api.FetchDocument(ctx.goCtx, node.Id(), remotePath); err != nil {
+		return "", "", fmt.Errorf("failed to download %s: %v", args[0], err)
+	}
+	return args[1], remotePath, nil
+}
+
+func isLocalRmdoc(path string) bool {
+	// legacy documents are .zip archives with the same content
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != "."+util.RMDOC && ext != "."+util.ZIP {
+		return false
+	}
+	stat, err := os.Stat(path)
+	return err == nil && !stat.IsDir()
+}
+
+// pageNumbers formats the old and new number of a page, e.g. "3", "3 -> 4"
+// or "- -> 5" for an added page
+func pageNumbers(oldPage, newPage int) string {
+	if oldPage == newPage {
+		return fmt.Sprint(oldPage)
+	}
+	format := func(n int) string {
+		if n == 0 {
+			return "-"
+		}
+		return fmt.Sprint(n)
+	}
+	return format(oldPage) + " -> " + format(newPage)
+}
+
+func writeDiffImage(p *rmconvert.PageDiff, imgPath string, dpi int) error {
+	return util.WriteFileAtomic(imgPath, func(w io.Writer) error {
+		return p.RenderToPNG(w, dpi)
+	})
+}
@@ -0,0 +1,137 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// usage is the storage used by an entry, with its content for directories
+type usage struct {
+	size      int64
+	documents int
+}
+
+// duEntry is a line of the du report
+type duEntry struct {
+	path string
+	usage
+}
+
+func duCommand(ctx *Context) Command {
+	return Command{
+		Name:  "du",
+		Help:  "report the storage used by each directory, largest first",
+		Usage: "[options] [dir]",
+		Examples: []string{
+			"rmapi du /",
+			"rmapi du -d 1 -a -n 10 /Books",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "du")
+			depth := flagSet.Int("d", -1, "only the directories up to this depth below dir, all by default")
+			all := flagSet.Bool("a", false, "report the documents too")
+			top := flagSet.Int("n", 0, "only the n largest entries")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			node := ctx.node
+			if argRest := flagSet.Args(); len(argRest) > 0 {
+				var err error
+				node, err = ctx.api.Filetree().NodeByPath(argRest[0], ctx.node)
+				if err != nil {
+					return err
+				}
+			}
+			base, err := ctx.api.Filetree().NodeToPath(node)
+			if err != nil {
+				return err
+			}
+			if base == "" {
+				base = "/"
+			}
+
+			entries := diskUsage(node, base, *depth, *all, ctx.useHiddenFiles)
+			if *top > 0 && len(entries) > *top {
+				entries = entries[:*top]
+			}
+			total := treeUsage(node)[node]
+			return printDiskUsage(os.Stdout, entries, total)
+		},
+	}
+}
+
+// treeUsage returns the storage used by node and each entry under it. The
+// trash is left out, unless it's node.
+func treeUsage(node *model.Node) map[*model.Node]usage {
+	result := map[*model.Node]usage{}
+	var walk func(n *model.Node) usage
+	walk = func(n *model.Node) usage {
+		u := usage{size: n.Document.Size}
+		if n.IsFile() {
+			u.documents = 1
+		}
+		for _, child := range n.Children {
+			if child.Id() == filetree.TrashID {
+				continue
+			}
+			c := walk(child)
+			u.size += c.size
+			u.documents += c.documents
+		}
+		result[n] = u
+		return u
+	}
+	walk(node)
+	return result
+}
+
+// diskUsage returns the directories under node, node included, up to depth
+// levels below it (all with a negative depth) and, with all, the documents,
+// sorted by decreasing size
+func diskUsage(node *model.Node, base string, depth int, all, hidden bool) []duEntry {
+	usages := treeUsage(node)
+	entries := []duEntry{{path: base, usage: usages[node]}}
+
+	var walk func(dir *model.Node, dirPath string, level int)
+	walk = func(dir *model.Node, dirPath string, level int) {
+		for _, child := range visibleChildren(dir, hidden) {
+			if child.Id() == filetree.TrashID {
+				continue
+			}
+			childPath := path.Join(dirPath, child.DisplayName())
+			if child.IsDirectory() || all {
+				entries = append(entries, duEntry{path: childPath, usage: usages[child]})
+			}
+			if child.IsDirectory() && (depth < 0 || level < depth) {
+				walk(child, childPath, level+1)
+			}
+		}
+	}
+	if depth != 0 {
+		walk(node, base, 1)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].size > entries[j].size
+	})
+	return entries
+}
+
+// printDiskUsage writes the entries of du and the total
+func printDiskUsage(w io.Writer, entries []duEntry, total usage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%d docs\t\t%s\n", util.FormatSize(e.size), e.documents, e.path)
+	}
+	fmt.Fprintf(tw, "%s\t%d docs\t\t%s\n", util.FormatSize(total.size), total.documents, "total")
+	return tw.Flush()
+}
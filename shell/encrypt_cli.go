@@ -0,0 +1,163 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/vault"
+)
+
+// encryptCommand turns the encryption of the tokens and caches on and
+// off. It needs no cloud access, see RunEncrypt.
+func encryptCommand() Command {
+	return Command{
+		Name:  "encrypt",
+		Help:  "encrypt the tokens and caches stored on disk, with a key in the keychain of the OS or a passphrase",
+		Usage: "[options] on\noff\nstatus",
+		Examples: []string{
+			"rmapi encrypt on",
+			"rmapi encrypt -passphrase on",
+			"rmapi encrypt off",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "encrypt")
+			passphrase := flagSet.Bool("passphrase", false, "on: derive the key from a passphrase instead of storing it in the keychain")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) != 1 {
+				return errors.New("missing action: on, off or status")
+			}
+
+			configPath, err := config.ConfigPath()
+			if err != nil {
+				return err
+			}
+			settings, err := config.LoadEncryption(configPath)
+			if err != nil {
+				return err
+			}
+
+			switch argRest[0] {
+			case "status":
+				fmt.Println(encryptionStatus(settings))
+				return nil
+			case "on":
+				if settings != nil {
+					return fmt.Errorf("already encrypted (%s), run 'rmapi encrypt off' first", settings.Mode)
+				}
+				mode := vault.ModeKeychain
+				if *passphrase {
+					mode = vault.ModePassphrase
+				}
+				return encryptStorage(configPath, mode)
+			case "off":
+				if settings == nil {
+					return errors.New("the tokens and caches are not encrypted")
+				}
+				return decryptStorage(configPath, settings)
+			default:
+				return fmt.Errorf("unknown action %q, use on, off or status", argRest[0])
+			}
+		},
+	}
+}
+
+// encryptStorage creates a key and encrypts the tokens and caches with it.
+// The settings are written first: plain tokens can be read with them.
+func encryptStorage(configPath, mode string) error {
+	var settings *vault.Settings
+	var key *vault.Key
+	var err error
+	if mode == vault.ModeKeychain {
+		if settings, key, err = vault.Setup(vault.ModeKeychain, ""); err != nil {
+			log.Warning.Printf("can't use the keychain, falling back to a passphrase: %v", err)
+			mode = vault.ModePassphrase
+		}
+	}
+	if mode == vault.ModePassphrase {
+		passphrase := os.Getenv(vault.PassphraseEnv)
+		if passphrase == "" {
+			if passphrase, err = readNewPassphrase(); err != nil {
+				return err
+			}
+		}
+		if settings, key, err = vault.Setup(vault.ModePassphrase, passphrase); err != nil {
+			return err
+		}
+	}
+
+	tokens := config.LoadTokens(configPath)
+	if err := config.SaveEncryption(configPath, settings); err != nil {
+		return err
+	}
+	vault.DefaultKey = key
+	if tokens.DeviceToken != "" {
+		if err := config.SaveTokens(configPath, tokens); err != nil {
+			return err
+		}
+	}
+	if err := api.ResealCaches(nil, key); err != nil {
+		return fmt.Errorf("failed to encrypt the caches: %v", err)
+	}
+	fmt.Println(encryptionStatus(settings))
+	return nil
+}
+
+// decryptStorage stores the tokens and caches in plain again, the tokens
+// are written before the settings are removed
+func decryptStorage(configPath string, settings *vault.Settings) error {
+	key := vault.DefaultKey
+	if key == nil {
+		var err error
+		if key, err = vault.Unlock(settings, AskPassphrase); err != nil {
+			return err
+		}
+		vault.DefaultKey = key
+	}
+
+	tokens := config.LoadTokens(configPath)
+	vault.DefaultKey = nil
+	if tokens.DeviceToken != "" {
+		if err := config.SaveTokens(configPath, tokens); err != nil {
+			return err
+		}
+	}
+	if err := config.SaveEncryption(configPath, nil); err != nil {
+		return err
+	}
+	if err := api.ResealCaches(key, nil); err != nil {
+		return fmt.Errorf("failed to decrypt the caches: %v", err)
+	}
+	if err := vault.Remove(settings); err != nil {
+		log.Warning.Printf("failed to remove the key from the keychain: %v", err)
+	}
+	fmt.Println(encryptionStatus(nil))
+	return nil
+}
+
+func encryptionStatus(settings *vault.Settings) string {
+	switch {
+	case settings == nil:
+		return "the tokens and caches are stored in plain"
+	case settings.Mode == vault.ModeKeychain:
+		return "the tokens and caches are encrypted, with a key in the keychain"
+	default:
+		return "the tokens and caches are encrypted, with a passphrase"
+	}
+}
+
+// RunEncrypt runs the encrypt command with its arguments, without the
+// cloud
+func RunEncrypt(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, encryptCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"encrypt"}, args...))
+}
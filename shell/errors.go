@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/vault"
+)
+
+// ErrorHint returns what to do about an error, from its class (see the
+// errors of model), or "" when there is nothing to suggest
+func ErrorHint(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, model.ErrAuth):
+		return "the tokens were rejected, run 'rmapi reset' and authenticate again with a new one-time code"
+	case errors.Is(err, model.ErrConflict):
+		return "the cloud was changed by another device meanwhile, run the command again"
+	case errors.Is(err, model.ErrNotFound):
+		return "check the path with 'rmapi ls' or 'rmapi find', or run 'rmapi refresh' if it was added recently"
+	case errors.Is(err, model.ErrUnsupportedVersion):
+		return "the format is not supported by this version of rmapi, check for an update"
+	case errors.Is(err, model.ErrParse):
+		return "the data is malformed, run 'rmapi refresh -full' if it comes from the cache"
+	case errors.Is(err, model.ErrTooLarge):
+		return "raise the limits with RMAPI_EXTRACT_MAX_SIZE, RMAPI_EXTRACT_MAX_FILE_SIZE or RMAPI_EXTRACT_MAX_FILES"
+	case errors.Is(err, vault.ErrDecrypt):
+		return "the passphrase or the key of the keychain is wrong, set RMAPI_PASSPHRASE, or run 'rmapi reset' and authenticate again"
+	case transport.IsUnreachable(err):
+		return "check the network connection, 'rmapi put -queue' keeps uploads until 'rmapi queue flush'"
+	}
+	return ""
+}
+
+// printError writes an error and its hint to w
+func printError(w io.Writer, err error) {
+	fmt.Fprintln(w, "Error:", err)
+	if hint := ErrorHint(err); hint != "" {
+		fmt.Fprintln(w, "Hint:", hint)
+	}
+}
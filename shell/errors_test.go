@@ -0,0 +1,40 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHint(t *testing.T) {
+	notFound := fmt.Errorf("get: %w", &filetree.NotFoundError{Name: "Notes"})
+	assert.True(t, errors.Is(notFound, model.ErrNotFound))
+	assert.Contains(t, ErrorHint(notFound), "rmapi ls")
+
+	auth := fmt.Errorf("failed to create user token from device token: %w", transport.ErrUnauthorized)
+	assert.Contains(t, ErrorHint(auth), "rmapi reset")
+
+	conflict := &ExitError{Code: ExitTotalFailure, Err: errors.Join(errors.New("a.pdf: failed"), transport.ErrWrongGeneration)}
+	assert.Contains(t, ErrorHint(conflict), "run the command again")
+
+	docConflict := fmt.Errorf("mv: %w", &api.ConflictError{DocumentID: "doc1"})
+	assert.True(t, errors.Is(docConflict, model.ErrConflict))
+	assert.Contains(t, ErrorHint(docConflict), "run the command again")
+
+	integrity := &api.IntegrityError{DocumentID: "doc1", File: "doc1.pdf", Hash: "abc"}
+	assert.True(t, errors.Is(integrity, model.ErrParse))
+
+	limit := fmt.Errorf("convert: %w", &rmconvert.ZipLimitError{Archive: "a.rmdoc", Limit: "files", Max: 10})
+	assert.True(t, errors.Is(limit, model.ErrTooLarge))
+	assert.Contains(t, ErrorHint(limit), "RMAPI_EXTRACT_MAX_SIZE")
+
+	assert.Equal(t, "", ErrorHint(errors.New("disk full")))
+	assert.Equal(t, "", ErrorHint(nil))
+}
@@ -0,0 +1,146 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juruen/rmapi/config"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/integrations"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/util"
+)
+
+func exportCommand(ctx *Context) Command {
+	return Command{
+		Name:  "export",
+		Help:  "push the highlights and notes of documents to " + strings.Join(integrations.TargetNames(), ", "),
+		Usage: "-to <target> [options] <remote file or dir>",
+		Examples: []string{
+			"rmapi export -to readwise /Books",
+			"rmapi export -to notion -ocr /Notes/Meeting",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "export")
+			to := flagSet.String("to", "", "export target ("+strings.Join(integrations.TargetNames(), ", ")+")")
+			enableOCR := flagSet.Bool("ocr", false, "include the handwriting recognised with tesseract in the notes")
+			tessPath := flagSet.String("tess-path", "tesseract", "path to tesseract binary")
+			tessLang := flagSet.String("tess-lang", "eng", "tesseract language")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			if *to == "" {
+				return errors.New("missing export target, use -to")
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file or dir")
+			}
+			srcName := argRest[0]
+
+			configPath, err := config.ConfigPath()
+			if err != nil {
+				return err
+			}
+			exportConfig, err := config.LoadExportConfig(configPath)
+			if err != nil {
+				return err
+			}
+			target, err := integrations.NewTarget(*to, exportConfig)
+			if err != nil {
+				return err
+			}
+
+			node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+			if err != nil {
+				return err
+			}
+			tmpDir, err := os.MkdirTemp("", "rmexport")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpDir)
+
+			summary := &batchSummary{}
+			var docs []integrations.Document
+
+			walkFn := func(currentNode *model.Node, remotePath string, _ []string) error {
+				fmt.Printf("extracting [%s]...", remotePath)
+				doc, err := extractDocument(ctx, currentNode, tmpDir, *enableOCR, *tessPath, *tessLang)
+				if err != nil {
+					fmt.Println(" FAILED")
+					log.Error.Printf("failed to extract %s: %v", remotePath, err)
+					summary.failed(remotePath, "extract", err)
+					return nil
+				}
+				doc.Path = remotePath
+				fmt.Printf(" %d highlights, %d pages of notes\n", len(doc.Highlights), len(doc.Notes))
+
+				if !doc.Empty() {
+					docs = append(docs, *doc)
+				}
+				summary.succeeded()
+				return nil
+			}
+
+			if err := walkDocuments(ctx, node, filetree.WalkOptions{}, walkFn); err != nil {
+				summary.print(os.Stdout)
+				return fmt.Errorf("interrupted: %v", err)
+			}
+
+			if len(docs) > 0 {
+				fmt.Printf("pushing %d documents to %s...", len(docs), target.Name())
+				if err := target.Push(ctx.goCtx, docs); err != nil {
+					fmt.Println(" FAILED")
+					summary.print(os.Stdout)
+					return err
+				}
+				fmt.Println(" OK")
+			}
+
+			summary.print(os.Stdout)
+			return summary.err()
+		},
+	}
+}
+
+// extractDocument downloads the document into tmpDir and returns its
+// highlights and notes
+func extractDocument(ctx *Context, node *model.Node, tmpDir string, enableOCR bool, tessPath, tessLang string) (*integrations.Document, error) {
+	rmdocPath, err := fetchTemp(ctx, node, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(rmdocPath)
+
+	highlights, err := rmconvert.ExtractHighlights(rmdocPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []rmconvert.PageText
+	if enableOCR {
+		pdfPath := filepath.Join(tmpDir, node.Id()+"."+util.PDF)
+		defer os.Remove(pdfPath)
+		notes, err = rmconvert.ConvertRmdocToPDFWithText(ctx.goCtx, rmdocPath, pdfPath, 300, true, tessPath, tessLang, 6)
+	} else {
+		notes, err = rmconvert.ExtractTypedText(rmdocPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &integrations.Document{
+		ID:         node.Id(),
+		Title:      node.Name(),
+		Highlights: highlights,
+		Notes:      notes,
+	}, nil
+}
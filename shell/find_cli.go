@@ -0,0 +1,109 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+)
+
+func findCommand(ctx *Context) Command {
+	return Command{
+		Name:  "find",
+		Help:  "print the paths of the entries under a directory, optionally matching a regexp",
+		Usage: "[options] [dir] [regexp]",
+		Examples: []string{
+			"rmapi find /Work \"report\"",
+			"rmapi find -newer 7d -type f -ids / | rmapi mgeta -o out -",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "find")
+			newer := flagSet.String("newer", "", "only the entries modified within this duration (7d, 12h, 2w) or since this date (2006-01-02)")
+			kind := flagSet.String("type", "", "only the files (f) or directories (d)")
+			ids := flagSet.Bool("ids", false, "print the IDs instead of the paths")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			if *kind != "" && *kind != "f" && *kind != "d" {
+				return fmt.Errorf("invalid -type %q, use f or d", *kind)
+			}
+
+			var since time.Time
+			if *newer != "" {
+				var err error
+				if since, err = parseSince(*newer, time.Now()); err != nil {
+					return err
+				}
+			}
+
+			argRest := flagSet.Args()
+			node := ctx.node
+			if len(argRest) > 0 {
+				var err error
+				node, err = ctx.api.Filetree().NodeByPath(argRest[0], ctx.node)
+				if err != nil {
+					return err
+				}
+			}
+
+			var pattern *regexp.Regexp
+			if len(argRest) > 1 {
+				var err error
+				if pattern, err = regexp.Compile(argRest[1]); err != nil {
+					return err
+				}
+			}
+
+			return walkEntries(ctx, node, filetree.WalkOptions{}, func(currentNode *model.Node, entryPath string, _ []string) error {
+				switch {
+				case *kind == "f" && currentNode.IsDirectory(), *kind == "d" && currentNode.IsFile():
+					return nil
+				case pattern != nil && !pattern.MatchString(entryPath):
+					return nil
+				case !since.IsZero():
+					modified, err := currentNode.LastModified()
+					if err != nil || modified.Before(since) {
+						return nil
+					}
+				}
+
+				if *ids {
+					fmt.Println(currentNode.Id())
+				} else {
+					fmt.Println(entryPath)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// parseSince returns the time of a -newer value: a duration before now,
+// with the d (days) and w (weeks) units on top of those of
+// time.ParseDuration, or a date
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	for unit, day := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, unit); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return time.Time{}, fmt.Errorf("invalid duration %q", s)
+			}
+			return now.Add(-time.Duration(count) * day), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid duration %q, use e.g. 7d, 12h or 2006-01-02", s)
+	}
+	return now.Add(-d), nil
+}
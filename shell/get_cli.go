@@ -0,0 +1,91 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/util"
+)
+
+func getCommand(ctx *Context) Command {
+	return Command{
+		Name:  "get",
+		Help:  "copy remote files to local (- reads the paths from stdin)",
+		Usage: "[options] <remote file>... | -",
+		Examples: []string{
+			"rmapi get -o out /Books/Novel",
+			"rmapi get -version 2 /Notes/Meeting",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "get")
+			outputDir := flagSet.String("o", ".", "output directory")
+			version := flagSet.Int("version", 0, "fetch a previous version (see history)")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest, err := expandStdinArgs(flagSet.Args())
+			if err != nil {
+				return err
+			}
+			if len(argRest) == 0 {
+				return errors.New("missing source file")
+			}
+
+			fetch := func(srcName string) error {
+				node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+				if err != nil {
+					return err
+				}
+				if node.IsDirectory() {
+					return fmt.Errorf("%s is a directory", srcName)
+				}
+
+				localName := filetree.DefaultLocalNamer.Name(node)
+				fileName := fmt.Sprintf("%s.%s", localName, util.RMDOC)
+				if *version > 0 {
+					fileName = fmt.Sprintf("%s.v%d.%s", localName, *version, util.RMDOC)
+				}
+				dstPath := util.LongPath(filepath.Join(*outputDir, fileName))
+
+				fmt.Printf("downloading [%s]...", dstPath)
+
+				if *version > 0 {
+					err = ctx.api.FetchDocumentVersion(ctx.goCtx, node.Id(), *version, dstPath)
+				} else {
+					err = ctx.api.FetchDocument(ctx.goCtx, node.Id(), dstPath)
+				}
+				if err != nil {
+					fmt.Println(" FAILED")
+					return fmt.Errorf("failed to download file %s: %v", srcName, err)
+				}
+
+				fmt.Println(" OK")
+				return nil
+			}
+
+			if len(argRest) == 1 {
+				return fetch(argRest[0])
+			}
+
+			// several files, e.g. read from stdin
+			summary := &batchSummary{}
+			for _, srcName := range argRest {
+				if err := ctx.goCtx.Err(); err != nil {
+					return err
+				}
+				if err := fetch(srcName); err != nil {
+					summary.failed(srcName, "download", err)
+				} else {
+					summary.succeeded()
+				}
+			}
+			summary.print(os.Stdout)
+			return summary.err()
+		},
+	}
+}
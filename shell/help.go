@@ -0,0 +1,79 @@
+package shell
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// helpOutput is where the help of the commands is printed, replaced in
+// tests
+var helpOutput io.Writer = os.Stdout
+
+// newFlagSet returns the flag set of a command. Its help, printed on -h
+// and by help <command>, is generated from the flags and the Usage and
+// Examples of the command, so that it lists the actual flags.
+func newFlagSet(ctx *Context, name string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.Usage = func() {
+		cmd, ok := ctx.commands[name]
+		if !ok {
+			cmd = Command{Name: name}
+		}
+		printCommandHelp(helpOutput, cmd, flagSet)
+	}
+	return flagSet
+}
+
+// printCommandHelp writes the help of a command with the flags of flagSet
+func printCommandHelp(w io.Writer, cmd Command, flagSet *flag.FlagSet) {
+	for i, usage := range strings.Split(cmd.Usage, "\n") {
+		prefix := "Usage:"
+		if i > 0 {
+			prefix = "      "
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%s rmapi %s %s", prefix, cmd.Name, usage), " "))
+	}
+	if cmd.Help != "" {
+		fmt.Fprintf(w, "\n%s\n", cmd.Help)
+	}
+
+	hasFlags := false
+	flagSet.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "\nOptions:")
+		out := flagSet.Output()
+		flagSet.SetOutput(w)
+		flagSet.PrintDefaults()
+		flagSet.SetOutput(out)
+	}
+
+	if len(cmd.Examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, example := range cmd.Examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
+
+// printHelp prints the help of a command, generated by its flag set when
+// run with -h
+func printHelp(ctx *Context, commands map[string]Command, name string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		if alias, ok := ctx.aliases[name]; ok {
+			fmt.Fprintf(helpOutput, "%s is an alias of: %s\n", name, alias)
+			return nil
+		}
+		return fmt.Errorf("unknown command: %s", name)
+	}
+
+	err := cmd.Func(ctx, []string{"-h"})
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package shell
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandHelp(t *testing.T) {
+	var out bytes.Buffer
+	helpOutput = &out
+	defer func() { helpOutput = os.Stdout }()
+
+	ctx := &Context{aliases: map[string]string{"backup": "mgeta -i -o backup /"}}
+	commands := registerCommands(ctx)
+
+	for name := range commands {
+		out.Reset()
+		assert.NoError(t, runCommand(ctx, commands, []string{name, "-h"}), name)
+		assert.True(t, strings.HasPrefix(out.String(), "Usage: rmapi "+name), name)
+	}
+
+	out.Reset()
+	assert.NoError(t, printHelp(ctx, commands, "diff"))
+	help := out.String()
+	assert.Contains(t, help, "Usage: rmapi diff [options] old.rmdoc new.rmdoc\n")
+	assert.Contains(t, help, "      rmapi diff [options] -version N <remote file>\n")
+	assert.Contains(t, help, "\nOptions:\n")
+	assert.Contains(t, help, "-dpi int")
+	assert.Contains(t, help, "\nExamples:\n  rmapi diff -o diffs old.rmdoc new.rmdoc\n  rmapi diff -version 3 /Notes/Meeting\n")
+
+	out.Reset()
+	assert.NoError(t, printHelp(ctx, commands, "pwd"))
+	assert.Equal(t, "Usage: rmapi pwd\n\nprint the current directory\n", out.String())
+
+	out.Reset()
+	assert.NoError(t, printHelp(ctx, commands, "backup"))
+	assert.Equal(t, "backup is an alias of: mgeta -i -o backup /\n", out.String())
+
+	assert.EqualError(t, printHelp(ctx, commands, "nope"), "unknown command: nope")
+}
@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+)
+
+func historyCommand(ctx *Context) Command {
+	return Command{
+		Name:  "history",
+		Help:  "list previous versions of a document seen while syncing",
+		Usage: "<remote file>",
+		Examples: []string{
+			"rmapi history /Notes/Meeting",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "history")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file")
+			}
+			srcName := argRest[0]
+
+			node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+			if err != nil {
+				return err
+			}
+			if node.IsDirectory() {
+				return fmt.Errorf("%s is a directory", srcName)
+			}
+
+			versions, err := ctx.api.DocumentHistory(node.Id())
+			if err != nil {
+				return err
+			}
+
+			if len(versions) == 0 {
+				fmt.Println("no previous versions known")
+				return nil
+			}
+
+			fmt.Printf("%-8s %-8s %-22s %10s  %s\n", "VERSION", "META", "MODIFIED", "SIZE", "HASH")
+			for _, v := range versions {
+				fmt.Printf("%-8d %-8d %-22s %10d  %.12s\n", v.Number, v.Version, v.ModifiedClient, v.Size, v.Hash)
+			}
+			fmt.Printf("%-8s %-8d %-22s\n", "current", node.Version(), node.Document.ModifiedClient)
+			fmt.Println("\nUse 'get -version N' to download a previous version")
+
+			return nil
+		},
+	}
+}
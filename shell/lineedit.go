@@ -0,0 +1,205 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history of the shell
+const maxHistory = 500
+
+// lineEditor reads the lines of the interactive shell from a terminal in
+// raw mode: the cursor moves with the arrows, the history with up and down,
+// and tab completes the word before the cursor
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	// complete returns the start of the word to complete in line, the part
+	// of the line before the cursor, and its candidates
+	complete func(line string) (int, []string)
+}
+
+// lineState is the line being edited
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+// readLine reads a line, io.EOF on ctrl-d on an empty line. ctrl-c clears
+// the line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	l := &lineState{prompt: prompt}
+	historyPos := len(e.history)
+	e.refresh(l)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(l.buf)
+			e.addHistory(line)
+			return line, nil
+		case 3: // ctrl-c
+			fmt.Fprint(e.out, "^C\r\n")
+			l.buf, l.pos = nil, 0
+			historyPos = len(e.history)
+		case 4: // ctrl-d
+			if len(l.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			l.delete()
+		case 127, 8: // backspace
+			if l.pos > 0 {
+				l.pos--
+				l.delete()
+			}
+		case 1: // ctrl-a
+			l.pos = 0
+		case 5: // ctrl-e
+			l.pos = len(l.buf)
+		case 21: // ctrl-u
+			l.buf, l.pos = l.buf[l.pos:], 0
+		case '\t':
+			e.completeLine(l)
+		case 27:
+			switch e.readEscape() {
+			case 'A':
+				if historyPos > 0 {
+					historyPos--
+					l.set(e.history[historyPos])
+				}
+			case 'B':
+				if historyPos < len(e.history)-1 {
+					historyPos++
+					l.set(e.history[historyPos])
+				} else {
+					historyPos = len(e.history)
+					l.set("")
+				}
+			case 'C':
+				if l.pos < len(l.buf) {
+					l.pos++
+				}
+			case 'D':
+				if l.pos > 0 {
+					l.pos--
+				}
+			case 'H':
+				l.pos = 0
+			case 'F':
+				l.pos = len(l.buf)
+			case '~': // delete
+				l.delete()
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			l.buf = append(l.buf[:l.pos], append([]rune{r}, l.buf[l.pos:]...)...)
+			l.pos++
+		}
+		e.refresh(l)
+	}
+}
+
+// readEscape reads the rest of an escape sequence and returns its final
+// byte, e.g. 'A' for the up arrow
+func (e *lineEditor) readEscape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		// parameters such as the 3 of delete (ESC [ 3 ~)
+		if r < '0' || r > '9' {
+			return r
+		}
+	}
+}
+
+// completeLine completes the word before the cursor, or lists the
+// candidates when it can't be completed further
+func (e *lineEditor) completeLine(l *lineState) {
+	if e.complete == nil {
+		return
+	}
+	start, candidates := e.complete(string(l.buf[:l.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+
+	word := string(l.buf[start:l.pos])
+	completion := candidates[0]
+	if len(candidates) == 1 {
+		if !strings.HasSuffix(completion, "/") {
+			completion += " "
+		}
+	} else {
+		for _, c := range candidates[1:] {
+			completion = commonPrefix(completion, c)
+		}
+		if completion == word {
+			fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			return
+		}
+	}
+
+	rest := l.buf[l.pos:]
+	l.buf = append(append(append([]rune{}, l.buf[:start]...), []rune(completion)...), rest...)
+	l.pos = len(l.buf) - len(rest)
+}
+
+func (e *lineEditor) refresh(l *lineState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", l.prompt, string(l.buf))
+	if back := len(l.buf) - l.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+}
+
+// delete removes the character under the cursor
+func (l *lineState) delete() {
+	if l.pos < len(l.buf) {
+		l.buf = append(l.buf[:l.pos], l.buf[l.pos+1:]...)
+	}
+}
+
+func (l *lineState) set(line string) {
+	l.buf = []rune(line)
+	l.pos = len(l.buf)
+}
+
+func commonPrefix(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	i := 0
+	for i < len(ra) && i < len(rb) && ra[i] == rb[i] {
+		i++
+	}
+	return string(ra[:i])
+}
@@ -0,0 +1,51 @@
+package shell
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEditor(input string) *lineEditor {
+	return &lineEditor{
+		in:  bufio.NewReader(strings.NewReader(input)),
+		out: io.Discard,
+		complete: func(line string) (int, []string) {
+			start := lastWordStart([]rune(line))
+			var candidates []string
+			for _, c := range []string{"Notes/", "Notebook", "My\\ Books/"} {
+				if strings.HasPrefix(c, line[start:]) {
+					candidates = append(candidates, c)
+				}
+			}
+			return start, candidates
+		},
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	e := newTestEditor("ls\r" +
+		"get Note\tb\t\r" + // lists the candidates, then completes
+		"\x1b[A\x1b[A\r" + // history
+		"cd My\t\r" +
+		"abd\x1b[D\x1b[Dx\x1b[3~\r" + // insert and delete in the middle
+		"\x03ls\r")
+
+	for _, want := range []string{"ls", "get Notebook ", "ls", "cd My\\ Books/", "axd", "ls"} {
+		line, err := e.readLine("> ")
+		assert.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	_, err := e.readLine("> ")
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestLastWordStart(t *testing.T) {
+	assert.Equal(t, 0, lastWordStart([]rune("get")))
+	assert.Equal(t, 4, lastWordStart([]rune("get ")))
+	assert.Equal(t, 4, lastWordStart([]rune("get My\\ Bo")))
+}
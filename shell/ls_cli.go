@@ -0,0 +1,202 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+func lsCommand(ctx *Context) Command {
+	return Command{
+		Name:  "ls",
+		Help:  "list the entries of the current or given directory",
+		Usage: "[options] [dir]",
+		Examples: []string{
+			"rmapi ls /Books",
+			"rmapi ls -tree /Work",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "ls")
+			recursive := flagSet.Bool("R", false, "list the subdirectories recursively, with the sizes and modification dates")
+			tree := flagSet.Bool("tree", false, "like -R, drawn as a tree")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			node := ctx.node
+			if argRest := flagSet.Args(); len(argRest) > 0 {
+				var err error
+				node, err = ctx.api.Filetree().NodeByPath(argRest[0], ctx.node)
+				if err != nil {
+					return err
+				}
+			}
+
+			if node.IsFile() {
+				printEntry(node)
+				return nil
+			}
+			if *recursive || *tree {
+				path, err := ctx.api.Filetree().NodeToPath(node)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					path = "/"
+				}
+				return printTree(os.Stdout, node, path, ctx.useHiddenFiles, *tree)
+			}
+			for _, child := range visibleChildren(node, ctx.useHiddenFiles) {
+				printEntry(child)
+			}
+			return nil
+		},
+	}
+}
+
+func cdCommand(ctx *Context) Command {
+	return Command{
+		Name:  "cd",
+		Help:  "change the current directory (interactive shell)",
+		Usage: "[dir]",
+		Examples: []string{
+			"cd /Books",
+			"cd ..",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "cd")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			target := "/"
+			if argRest := flagSet.Args(); len(argRest) > 0 {
+				target = argRest[0]
+			}
+
+			node, err := ctx.api.Filetree().NodeByPath(target, ctx.node)
+			if err != nil {
+				return err
+			}
+			if node.IsFile() {
+				return errors.New("not a directory")
+			}
+
+			path, err := ctx.api.Filetree().NodeToPath(node)
+			if err != nil {
+				return err
+			}
+			ctx.node = node
+			ctx.path = path
+			return nil
+		},
+	}
+}
+
+func pwdCommand(ctx *Context) Command {
+	return Command{
+		Name: "pwd",
+		Help: "print the current directory",
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "pwd")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			fmt.Println(currentPath(ctx))
+			return nil
+		},
+	}
+}
+
+// currentPath returns the path of the current directory, "/" at the root
+func currentPath(ctx *Context) string {
+	if ctx.path == "" {
+		return "/"
+	}
+	return ctx.path
+}
+
+func printEntry(node *model.Node) {
+	kind := "f"
+	if node.IsDirectory() {
+		kind = "d"
+	}
+	fmt.Printf("[%s]\t%s\n", kind, node.DisplayName())
+}
+
+// printTree writes the entries under the directory node, titled path, with
+// their size and modification date: indented by level or, with box, drawn
+// as a tree. The sizes of the directories are those of their content. The
+// trash is left out.
+func printTree(w io.Writer, node *model.Node, path string, hidden, box bool) error {
+	usages := treeUsage(node)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	printTreeLine(tw, path, usages[node].size, node)
+
+	var list func(dir *model.Node, prefix string)
+	list = func(dir *model.Node, prefix string) {
+		var children []*model.Node
+		for _, child := range visibleChildren(dir, hidden) {
+			if child.Id() != filetree.TrashID {
+				children = append(children, child)
+			}
+		}
+		for i, child := range children {
+			branch, indent := "  ", "  "
+			if box {
+				branch, indent = "├── ", "│   "
+				if i == len(children)-1 {
+					branch, indent = "└── ", "    "
+				}
+			}
+			name := child.DisplayName()
+			if child.IsDirectory() {
+				name += "/"
+			}
+			printTreeLine(tw, prefix+branch+name, usages[child].size, child)
+			if child.IsDirectory() {
+				list(child, prefix+indent)
+			}
+		}
+	}
+	list(node, "")
+	return tw.Flush()
+}
+
+// printTreeLine writes a line of printTree, without the modification date
+// when it's unknown
+func printTreeLine(w io.Writer, name string, size int64, node *model.Node) {
+	// right aligned, e.g. 1023.9 MB
+	fmt.Fprintf(w, "%s\t%9s", name, util.FormatSize(size))
+	if modified, err := node.LastModified(); err == nil {
+		fmt.Fprintf(w, "  %s", modified.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintln(w)
+}
+
+// visibleChildren returns the children of a directory sorted by name,
+// without the hidden ones (starting with a dot) unless hidden is set, see
+// RMAPI_USE_HIDDEN_FILES
+func visibleChildren(node *model.Node, hidden bool) []*model.Node {
+	children := make([]*model.Node, 0, len(node.Children))
+	for _, child := range node.Children {
+		if !hidden && strings.HasPrefix(child.DisplayName(), ".") {
+			continue
+		}
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].DisplayName() < children[j].DisplayName()
+	})
+	return children
+}
@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTree returns a tree of a few documents and directories, and a
+// document in the trash
+func testTree() *filetree.FileTreeCtx {
+	tree := filetree.CreateFileTreeCtx()
+	for _, doc := range []model.Document{
+		{ID: "work", Name: "Work", Type: model.DirectoryType, ModifiedClient: "bad"},
+		{ID: "notes", Name: "Notes", Type: model.DocumentType, Parent: "work", Size: 2048},
+		{ID: "old", Name: "Old", Type: model.DirectoryType, Parent: "work", ModifiedClient: "bad"},
+		{ID: "paper", Name: "Paper", Type: model.DocumentType, Parent: "old", Size: 1024},
+		{ID: "todo", Name: "Todo", Type: model.DocumentType, ModifiedClient: "2024-05-01T10:00:00Z"},
+		{ID: "trashed", Name: "Trashed", Type: model.DocumentType, Parent: filetree.TrashID, Size: 4096},
+	} {
+		tree.AddDocument(&doc)
+	}
+	tree.FinishAdd()
+	return &tree
+}
+
+func TestPrintTree(t *testing.T) {
+	tree := testTree()
+
+	todo := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Local().Format("2006-01-02 15:04")
+
+	var buf bytes.Buffer
+	assert.NoError(t, printTree(&buf, tree.Root(), "/", false, false))
+	assert.Equal(t, `/               3.0 KB
+  Todo             0 B  `+todo+`
+  Work/         3.0 KB
+    Notes       2.0 KB
+    Old/        1.0 KB
+      Paper     1.0 KB
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, printTree(&buf, tree.Root(), "/", false, true))
+	assert.Equal(t, `/                     3.0 KB
+├── Todo                 0 B  `+todo+`
+└── Work/             3.0 KB
+    ├── Notes         2.0 KB
+    └── Old/          1.0 KB
+        └── Paper     1.0 KB
+`, buf.String())
+}
+
+func TestDiskUsage(t *testing.T) {
+	tree := testTree()
+
+	paths := func(entries []duEntry) []string {
+		var result []string
+		for _, e := range entries {
+			result = append(result, e.path)
+		}
+		return result
+	}
+	entries := diskUsage(tree.Root(), "/", -1, false, false)
+	assert.Equal(t, []string{"/", "/Work", "/Work/Old"}, paths(entries))
+	assert.Equal(t, usage{size: 3072, documents: 3}, entries[0].usage)
+	assert.Equal(t, usage{size: 1024, documents: 1}, entries[2].usage)
+
+	entries = diskUsage(tree.Root(), "/", 1, true, false)
+	assert.Equal(t, []string{"/", "/Work", "/Todo"}, paths(entries))
+
+	var buf bytes.Buffer
+	assert.NoError(t, printDiskUsage(&buf, entries[1:2], entries[0].usage))
+	assert.Equal(t, "  3.0 KB  2 docs  /Work\n  3.0 KB  3 docs  total\n", buf.String())
+}
@@ -1,189 +1,858 @@
 package shell
 
 import (
+	"context"
 	"errors"
-	"flag"
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/juruen/rmapi/config"
 	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/integrations"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/metrics"
 	"github.com/juruen/rmapi/model"
 	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/search"
 	"github.com/juruen/rmapi/util"
 )
 
-
 func mgetaCommand(ctx *Context) Command {
 	return Command{
-		Name: "mgeta",
-		Help: "recursively copy remote directory to local and convert to PDF",
+		Name:  "mgeta",
+		Help:  "recursively copy remote directory (or the documents read from stdin with -) to local and convert to PDF",
+		Usage: "[options] <remote dir or file>... | -",
+		Examples: []string{
+			"rmapi mgeta -i -o backup /",
+			"rmapi mgeta -flat -max-depth 1 -o out /Work",
+			"rmapi mgeta -ocr -o out /Notes",
+		},
 		Func: func(ctx *Context, args []string) error {
-			flagSet := flag.NewFlagSet("mgeta", flag.ContinueOnError)
-			incremental := flagSet.Bool("i", false, "incremental mode (only download/convert if modified)")
-			outputDir := flagSet.String("o", ".", "output directory")
-			removeDeleted := flagSet.Bool("d", false, "remove deleted/moved files from local")
-			skipConversion := flagSet.Bool("s", false, "skip PDF conversion, only download .rmdoc files")
-			dpi := flagSet.Int("dpi", 300, "render DPI (default: 300)")
-			enableOCR := flagSet.Bool("ocr", false, "enable OCR for searchable PDFs (requires tesseract)")
-			tessPath := flagSet.String("tess-path", "tesseract", "path to tesseract binary")
-			tessLang := flagSet.String("tess-lang", "eng", "tesseract language")
-			tessPSM := flagSet.Int("tess-psm", 6, "tesseract page segmentation mode")
-
-			if err := flagSet.Parse(args); err != nil {
+			opts, err := parseMgetaOptions(ctx, args)
+			if err != nil {
 				return err
 			}
+			return runMgeta(ctx, opts)
+		},
+	}
+}
+
+// mgetaOptions are the options of mgeta, parsed from its flags
+type mgetaOptions struct {
+	incremental    bool
+	verbose        bool
+	outputDir      string
+	removeDeleted  bool
+	flat           bool
+	maxDepth       int
+	skipConversion bool
+	splitBy        rmconvert.SplitBy
+	splitAll       bool
+	combine        bool
+	combineTOC     bool
+	index          bool
+	email          bool
+	sinkURLs       string
+	noLocal        bool
+	webhookURL     string
+	metricsAddr    string
+	exporter       rmconvert.Exporter
+	exportOpts     rmconvert.ExportOptions
+	exportConfig   config.ExportConfig
+	namer          filetree.LocalNamer
+	// sources are the remote paths to copy, with those read from stdin
+	sources []string
+}
 
+// parseMgetaOptions parses and checks the flags of mgeta
+func parseMgetaOptions(ctx *Context, args []string) (*mgetaOptions, error) {
+	opts := &mgetaOptions{}
+	exportOpts := &opts.exportOpts
+	exportOpts.SVG = &rmconvert.SVGOptions{}
 
-			target := path.Clean(*outputDir)
-			if *removeDeleted && target == "." {
-				return fmt.Errorf("set a folder explicitly with the -o flag when removing deleted (and not .)")
-			}
+	flagSet := newFlagSet(ctx, "mgeta")
+	flagSet.BoolVar(&opts.incremental, "i", false, "incremental mode (only download/convert if modified)")
+	flagSet.StringVar(&opts.outputDir, "o", ".", "output directory")
+	flagSet.BoolVar(&opts.verbose, "v", false, "verbose, print a line for each downloaded document")
+	flagSet.BoolVar(&opts.removeDeleted, "d", false, "remove deleted/moved files from local")
+	flagSet.BoolVar(&opts.flat, "flat", false, "write every document to the output directory, its name prefixed with its folders (Work_Meetings_Notes.pdf)")
+	flagSet.IntVar(&opts.maxDepth, "max-depth", 0, "only copy the documents up to this many folders deep, 1 for the documents of the source directory only (0 for no limit)")
+	flagSet.BoolVar(&opts.skipConversion, "s", false, "skip PDF conversion, only download .rmdoc files")
+	flagSet.IntVar(&exportOpts.DPI, "dpi", 300, "render DPI (default: 300)")
+	flagSet.BoolVar(&exportOpts.OCR, "ocr", false, "enable OCR for searchable PDFs (requires tesseract)")
+	flagSet.StringVar(&exportOpts.TesseractPath, "tess-path", "tesseract", "path to tesseract binary")
+	flagSet.StringVar(&exportOpts.Language, "tess-lang", "eng", "tesseract language")
+	flagSet.IntVar(&exportOpts.PSM, "tess-psm", 6, "tesseract page segmentation mode")
+	flagSet.BoolVar(&exportOpts.OCRRotate, "ocr-orientation", false, "with -ocr, turn the pages with sideways or upside down text upright before OCR (needs osd.traineddata)")
+	flagSet.BoolVar(&exportOpts.OCRRegions, "ocr-regions", false, "with -ocr, recognise each area of the pages with strokes instead of the whole pages")
+	flagSet.BoolVar(&exportOpts.OCRDeskew, "ocr-deskew", false, "with -ocr, straighten the pages whose lines are tilted before OCR")
+	flagSet.BoolVar(&exportOpts.OCRBinarize, "ocr-binarize", false, "with -ocr, turn the pages black and white before OCR, for light pencil strokes")
+	flagSet.BoolVar(&exportOpts.OCRDespeckle, "ocr-despeckle", false, "with -ocr, remove the isolated dots of the pages before OCR")
+	flagSet.DurationVar(&exportOpts.OCRTimeout, "ocr-timeout", 2*time.Minute, "with -ocr, stop the OCR of a page after this long and leave it without text (0 for no limit)")
+	flagSet.StringVar(&exportOpts.TemplatesDir, "templates", "", "folder of the page template images, e.g. /usr/share/remarkable/templates copied from the device (default: the template library of templates get, if any)")
+	split := flagSet.String("split", "", "export the Quick sheets notebook to a folder of its name, one file per day, week, month or session (pages written less than 2 hours apart)")
+	flagSet.BoolVar(&opts.splitAll, "split-all", false, "with -split, split every document, not only Quick sheets")
+	flagSet.BoolVar(&opts.combine, "combine", false, "merge the PDFs of each folder into <folder>.combined.pdf with bookmarks")
+	flagSet.BoolVar(&opts.combineTOC, "combine-toc", true, "start the combined PDFs with a table of contents linked to the documents")
+	flagSet.BoolVar(&opts.index, "index", true, "index the typed and OCR text of the documents (see search)")
+	format := flagSet.String("format", "pdf", "output format ("+strings.Join(rmconvert.ExporterNames(), ", ")+")")
+	flagSet.Float64Var(&exportOpts.SVG.Tolerance, "svg-tolerance", rmconvert.DefaultSVGOptions.Tolerance, "svg: drop the points closer than this to the simplified stroke, in device pixels (0 keeps all)")
+	flagSet.IntVar(&exportOpts.SVG.Precision, "svg-precision", rmconvert.DefaultSVGOptions.Precision, "svg: decimals of the coordinates (-1 for full precision)")
+	flagSet.BoolVar(&exportOpts.SVG.Absolute, "svg-absolute", false, "svg: write absolute path commands instead of relative ones")
+	flagSet.BoolVar(&opts.email, "email", false, "email the converted files (SMTP settings in the export.email section of the config file)")
+	flagSet.StringVar(&opts.sinkURLs, "sink", "", "comma separated storages the converted files are uploaded to ("+strings.Join(integrations.SinkSchemes(), ", ")+"), e.g. s3://bucket/prefix")
+	flagSet.BoolVar(&opts.noLocal, "no-local", false, "remove the converted files once uploaded to the -sink storages, only the .rmdoc files are kept")
+	flagSet.StringVar(&opts.webhookURL, "webhook", "", "URL notified of the converted and failed documents (default: export.webhook.url of the config file)")
+	filenames := flagSet.String("filenames", "os", "local file name rules: os, portable (valid on Windows, macOS and Linux) or posix")
+	replaceChar := flagSet.String("replace-char", "_", "replacement of the characters not allowed in local file names")
+	flagSet.StringVar(&opts.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on /metrics of this address while syncing, e.g. :9090")
+	debugRender := flagSet.String("debug-render", "", "color the strokes by pressure, speed or order, to debug the rendering")
+	flagSet.BoolVar(&exportOpts.Invert, "invert", false, "draw the pages white on black, for dark screens")
+	background := flagSet.String("background", "", "page color of the PDF, image and svg formats: transparent, white, black or #rrggbb")
+	flagSet.BoolVar(&exportOpts.Crop, "crop", false, "trim the pages of the PDF, image and svg formats to their content")
+	flagSet.Float64Var(&exportOpts.CropMargin, "crop-margin", rmconvert.DefaultCropMargin, "margin around the content of the cropped pages, in device pixels")
+	flagSet.IntVar(&exportOpts.Width, "width", 0, "width of the pages of the image formats in pixels, instead of -dpi, keeping the aspect ratio")
+	flagSet.IntVar(&exportOpts.Height, "height", 0, "height of the pages of the image formats in pixels, instead of -dpi, keeping the aspect ratio")
+	flagSet.BoolVar(&exportOpts.PageNumbers, "page-numbers", false, "draw the page number and count at the bottom of the pages")
+	flagSet.StringVar(&exportOpts.Header, "header", "", "draw this text at the top of the pages, {page}, {pages}, {date}, {path} and {name} are replaced")
+	flagSet.StringVar(&exportOpts.Footer, "footer", "", "draw this text at the bottom of the pages, with the placeholders of -header")
+	watermarkText := flagSet.String("watermark", "", "pdf: stamp this text on the pages, {date}, {path} and {name} are replaced (default export.watermark of the config file)")
+	watermarkImage := flagSet.String("watermark-image", "", "pdf: stamp this image or PDF on the pages instead of a text")
+	flagSet.BoolVar(&exportOpts.AttachSource, "attach-source", false, "pdf: embed the .rmdoc in the exported PDF as a file attachment")
+	watermarkStyle := flagSet.String("watermark-style", "", "pdf: pdfcpu description of the watermark, e.g. \"position:br, scalefactor:0.2 rel\" (default \""+rmconvert.DefaultWatermarkStyle+"\")")
 
-			argRest := flagSet.Args()
-			if len(argRest) == 0 {
-				return errors.New("missing source dir")
-			}
-			srcName := argRest[0]
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if opts.exporter, err = rmconvert.LookupExporter(*format); err != nil {
+		return nil, err
+	}
+	if exportOpts.Width < 0 || exportOpts.Height < 0 {
+		return nil, fmt.Errorf("invalid size %dx%d", exportOpts.Width, exportOpts.Height)
+	}
+	if exportOpts.DebugRender, err = rmconvert.ParseDebugRender(*debugRender); err != nil {
+		return nil, err
+	}
+	if exportOpts.Background, err = rmconvert.ParseBackground(*background); err != nil {
+		return nil, err
+	}
+	if *split != "" {
+		if opts.splitBy, err = rmconvert.ParseSplitBy(*split); err != nil {
+			return nil, err
+		}
+	} else if opts.splitAll {
+		return nil, errors.New("-split-all needs -split")
+	}
+	rules, err := util.ParseFilenameRules(*filenames)
+	if err != nil {
+		return nil, err
+	}
+	if *replaceChar != "" && *replaceChar != util.SanitizeFilename(*replaceChar, util.FilenameRulesPortable, "") {
+		return nil, fmt.Errorf("invalid -replace-char %q, it is not allowed in file names", *replaceChar)
+	}
+	opts.namer = filetree.LocalNamer{Rules: rules, Replacement: *replaceChar}.Cached()
+	if exportOpts.TemplatesDir == "" {
+		exportOpts.TemplatesDir = templateLibrary()
+	}
+
+	if (opts.email || opts.sinkURLs != "") && opts.skipConversion {
+		return nil, errors.New("-email and -sink need the conversion, they can't be used with -s")
+	}
+	if opts.noLocal && (opts.sinkURLs == "" || opts.combine) {
+		return nil, errors.New("-no-local needs -sink and can't be used with -combine")
+	}
+	if opts.maxDepth < 0 {
+		return nil, fmt.Errorf("invalid -max-depth %d", opts.maxDepth)
+	}
+
+	configPath, err := config.ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if opts.exportConfig, err = config.LoadExportConfig(configPath); err != nil {
+		return nil, err
+	}
+
+	watermark := &rmconvert.Watermark{Text: *watermarkText, Image: *watermarkImage, Style: *watermarkStyle}
+	if watermark.Text == "" && watermark.Image == "" {
+		watermark.Text, watermark.Image = opts.exportConfig.Watermark.Text, opts.exportConfig.Watermark.Image
+		if watermark.Style == "" {
+			watermark.Style = opts.exportConfig.Watermark.Style
+		}
+	}
+	if watermark.Text != "" || watermark.Image != "" {
+		if err := watermark.Check(); err != nil {
+			return nil, err
+		}
+		exportOpts.Watermark = watermark
+	}
+
+	if opts.removeDeleted && filepath.Clean(opts.outputDir) == "." {
+		return nil, fmt.Errorf("set a folder explicitly with the -o flag when removing deleted (and not .)")
+	}
+	if opts.removeDeleted && hasStdinArg(flagSet.Args()) {
+		return nil, errors.New("-d can't be used with paths read from stdin")
+	}
+	if opts.sources, err = expandStdinArgs(flagSet.Args()); err != nil {
+		return nil, err
+	}
+	if len(opts.sources) == 0 {
+		return nil, errors.New("missing source dir")
+	}
+	if opts.removeDeleted && len(opts.sources) > 1 {
+		return nil, errors.New("-d needs a single source dir")
+	}
+	return opts, nil
+}
+
+// mgetaSync is the state of a run of mgeta
+type mgetaSync struct {
+	ctx         *Context
+	opts        *mgetaOptions
+	target      string
+	mailer      *integrations.Mailer
+	webhook     *integrations.Webhook
+	sinks       []integrations.Sink
+	searchIndex *search.Index
+	flatNames   map[*model.Node]string
+	// srcName is the source being walked, as given on the command line
+	srcName string
+	// fileMap holds the local files and folders of the remote entries,
+	// the others are removed by -d
+	fileMap map[string]struct{}
+	// combined holds the converted PDFs per local folder, for -combine
+	combined map[string][]rmconvert.CombineInput
+	summary  *batchSummary
+}
+
+// mgetaDocument is a document being synced and its local paths
+type mgetaDocument struct {
+	node         *model.Node
+	remotePath   string
+	lastModified time.Time
+	rmdocPath    string
+	outPath      string
+	// splitDir is the folder the document is exported to by -split
+	splitDir string
+	// exported are the files of a previous conversion
+	exported []string
+}
+
+// runMgeta copies the sources of opts to the output directory and
+// converts them
+func runMgeta(ctx *Context, opts *mgetaOptions) error {
+	if opts.exportOpts.OCR && !opts.skipConversion {
+		// a missing language would fail the OCR of every page
+		err := rmconvert.CheckTesseractLanguages(ctx.goCtx, opts.exportOpts)
+		if errors.Is(err, rmconvert.ErrTesseractNotFound) {
+			log.Warning.Printf("tesseract not found at %s, the documents are converted without OCR", opts.exportOpts.TesseractPath)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	s := &mgetaSync{
+		ctx:  ctx,
+		opts: opts,
+		// deep folders may exceed 260 characters on Windows
+		target:   util.LongPath(filepath.Clean(opts.outputDir)),
+		combined: make(map[string][]rmconvert.CombineInput),
+		summary:  &batchSummary{},
+	}
+	s.fileMap = map[string]struct{}{s.target: {}}
+
+	var err error
+	if opts.email {
+		if s.mailer, err = integrations.NewMailer(opts.exportConfig.Email); err != nil {
+			return err
+		}
+	}
+	if opts.webhookURL != "" || opts.exportConfig.Webhook.URL != "" {
+		if s.webhook, err = integrations.NewWebhook(opts.webhookURL, opts.exportConfig.Webhook); err != nil {
+			return err
+		}
+	}
+	started := time.Now()
+
+	if opts.metricsAddr != "" {
+		server, err := metrics.Serve(opts.metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+	}
+
+	for _, sinkURL := range strings.Split(opts.sinkURLs, ",") {
+		if sinkURL = strings.TrimSpace(sinkURL); sinkURL == "" {
+			continue
+		}
+		sink, err := integrations.NewSink(sinkURL, opts.exportConfig)
+		if err != nil {
+			return err
+		}
+		s.sinks = append(s.sinks, sink)
+	}
+
+	// a single directory is mirrored, documents (e.g. read from stdin) are
+	// written to the output directory
+	sources := make([]*model.Node, len(opts.sources))
+	for i, srcName := range opts.sources {
+		sources[i], err = ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+		if err != nil {
+			return err
+		}
+		if len(opts.sources) == 1 && sources[i].IsFile() {
+			return fmt.Errorf("%s is not a directory", srcName)
+		}
+	}
+
+	if opts.index {
+		if s.searchIndex, err = openSearchIndex(); err != nil {
+			log.Warning.Printf("not indexing, failed to open the search index: %v", err)
+		}
+	}
+
+	if opts.flat {
+		if s.flatNames, err = flattenNames(ctx, opts.namer, sources, opts.maxDepth); err != nil {
+			return err
+		}
+	}
+
+	// an interrupted walk hasn't seen every file, don't combine or remove
+	// anything based on it
+	var walkErr error
+	for i, node := range sources {
+		s.srcName = opts.sources[i]
+		if walkErr = walkEntries(ctx, node, filetree.WalkOptions{MaxDepth: opts.maxDepth}, s.syncEntry); walkErr != nil {
+			break
+		}
+	}
+
+	if s.searchIndex != nil {
+		if err := s.searchIndex.Save(); err != nil {
+			log.Warning.Printf("failed to save the search index: %v", err)
+		}
+	}
+
+	if walkErr != nil {
+		s.summary.print(os.Stdout)
+		return fmt.Errorf("interrupted: %v", walkErr)
+	}
+
+	if opts.combine && !opts.skipConversion {
+		s.combinePDFs()
+	}
+	if opts.removeDeleted {
+		s.removeDeleted()
+	}
+
+	s.summary.print(os.Stdout)
+	s.notify(integrations.WebhookEvent{
+		Event:    integrations.EventSyncCompleted,
+		Duration: time.Since(started).Seconds(),
+		Summary:  s.summary.webhookSummary(),
+	})
+	return s.summary.err()
+}
+
+// keep records local files of the remote entries, so that -d doesn't
+// remove them
+func (s *mgetaSync) keep(paths ...string) {
+	for _, p := range paths {
+		s.fileMap[p] = struct{}{}
+	}
+}
+
+// localPath returns the local name and folders, below the output directory,
+// of an entry of the walk
+func (s *mgetaSync) localPath(node *model.Node, walkPath []string) (string, []string) {
+	if s.opts.flat {
+		return s.flatNames[node], nil
+	}
+	idxDir := 0
+	if s.srcName == "." && len(walkPath) > 0 {
+		idxDir = 1
+	}
+	return s.opts.namer.Name(node), s.opts.namer.Dir(node, len(walkPath))[idxDir:]
+}
+
+// syncEntry creates the local folder of an entry of the walk and, for a
+// document, downloads and converts it
+func (s *mgetaSync) syncEntry(node *model.Node, remotePath string, walkPath []string) error {
+	if s.opts.flat && node.IsDirectory() {
+		return nil
+	}
+	localName, localDir := s.localPath(node, walkPath)
+	doc := &mgetaDocument{
+		node:       node,
+		remotePath: remotePath,
+		rmdocPath:  filepath.Join(s.target, filepath.Join(localDir...), fmt.Sprintf("%s.%s", localName, util.RMDOC)),
+		outPath:    filepath.Join(s.target, filepath.Join(localDir...), fmt.Sprintf("%s.%s", localName, s.opts.exporter.Extensions()[0])),
+	}
+
+	// the split documents are exported to a folder of their name, one file
+	// per part
+	if s.opts.splitBy != "" && node.IsFile() && (s.opts.splitAll || isQuickSheets(node)) {
+		doc.splitDir = filepath.Join(s.target, filepath.Join(localDir...), localName)
+	}
+	doc.exported = exportedFiles(doc.outPath)
+	if doc.splitDir != "" {
+		s.keep(doc.splitDir)
+		doc.exported = splitFiles(doc.splitDir)
+	}
+	s.keep(doc.rmdocPath)
+	s.keep(doc.exported...)
 
-			node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.api.Filetree().Root())
-			if err != nil || node.IsFile() {
-				return errors.New("directory doesn't exist")
+	dir := filepath.Dir(doc.rmdocPath)
+	s.keep(dir)
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		log.Error.Printf("failed to create %s: %v", dir, err)
+		s.summary.failed(dir, "mkdir", err)
+		if node.IsDirectory() {
+			return filetree.SkipDir
+		}
+		return nil
+	}
+
+	if node.IsDirectory() {
+		return nil
+	}
+	s.syncDocument(doc)
+	return nil
+}
+
+// syncDocument downloads and converts a document, and records the outcome
+func (s *mgetaSync) syncDocument(doc *mgetaDocument) {
+	started := time.Now()
+	if s.searchIndex != nil {
+		if indexed, ok := s.searchIndex.Documents[doc.node.Id()]; ok {
+			indexed.Path = doc.remotePath
+		}
+	}
+
+	var err error
+	doc.lastModified, err = doc.node.LastModified()
+	if err != nil {
+		log.Warning.Printf("%v for %s", err, doc.rmdocPath)
+		doc.lastModified = time.Now()
+	}
+
+	downloaded, err := s.download(doc)
+	if err != nil {
+		s.documentFailed(doc, started, "download", err)
+		return
+	}
+	if s.opts.skipConversion {
+		s.documentSynced(doc, started, nil)
+		return
+	}
+
+	converted, stage, err := s.convert(doc, downloaded)
+	s.addCombined(doc)
+	if err != nil {
+		s.documentFailed(doc, started, stage, err)
+		return
+	}
+	s.documentSynced(doc, started, converted)
+}
+
+// download downloads a document unless, with -i, the local copy is up to
+// date. It tells whether the document was downloaded.
+func (s *mgetaSync) download(doc *mgetaDocument) (bool, error) {
+	if s.opts.incremental {
+		if stat, err := os.Stat(doc.rmdocPath); err == nil && !doc.lastModified.After(stat.ModTime()) {
+			return false, nil
+		}
+	}
+
+	// the progress of large downloads is displayed by FetchDocument
+	if s.opts.verbose {
+		fmt.Printf("downloading [%s]...", doc.rmdocPath)
+	}
+	if err := s.ctx.api.FetchDocument(s.ctx.goCtx, doc.node.Document.ID, doc.rmdocPath); err != nil {
+		if s.opts.verbose {
+			fmt.Println(" FAILED")
+		}
+		log.Error.Printf("failed to download %s: %v", doc.rmdocPath, err)
+		return false, err
+	}
+	if s.opts.verbose {
+		fmt.Println(" OK")
+	}
+
+	if err := os.Chtimes(doc.rmdocPath, doc.lastModified, doc.lastModified); err != nil {
+		log.Warning.Printf("can't set lastModified for %s: %v", doc.rmdocPath, err)
+	}
+
+	if s.searchIndex != nil && s.opts.skipConversion {
+		text, err := rmconvert.ExtractTypedText(doc.rmdocPath)
+		if err != nil {
+			log.Warning.Printf("failed to extract the text of %s: %v", doc.rmdocPath, err)
+		} else {
+			indexDocument(s.searchIndex, doc.node, doc.remotePath, text)
+		}
+	}
+	return true, nil
+}
+
+// convert converts a document unless its converted files are up to date,
+// then emails them and uploads them to the sinks. It returns the result of
+// the conversion, nil if there was none, and the stage that failed.
+func (s *mgetaSync) convert(doc *mgetaDocument, downloaded bool) (*rmconvert.ExportResult, string, error) {
+	opts := s.opts
+	if opts.noLocal {
+		// no converted files to compare with, the document is converted
+		// when it is downloaded
+		if !downloaded {
+			return nil, "", nil
+		}
+	} else if opts.incremental && len(doc.exported) > 0 {
+		outStat, outErr := os.Stat(doc.exported[0])
+		rmdocStat, rmdocErr := os.Stat(doc.rmdocPath)
+		if outErr == nil && rmdocErr == nil && !rmdocStat.ModTime().After(outStat.ModTime()) {
+			return nil, "", nil
+		}
+	}
+
+	if opts.exportOpts.OCR {
+		fmt.Printf("converting [%s] to %s (DPI: %d, OCR: %s)...", doc.rmdocPath, opts.exporter.Name(), opts.exportOpts.DPI, opts.exportOpts.Language)
+	} else {
+		fmt.Printf("converting [%s] to %s (DPI: %d)...", doc.rmdocPath, opts.exporter.Name(), opts.exportOpts.DPI)
+	}
+	exportOpts := opts.exportOpts.ForDocument(doc.remotePath, doc.lastModified)
+	var result *rmconvert.ExportResult
+	var err error
+	if doc.splitDir != "" {
+		result, err = exportParts(s.ctx.goCtx, opts.exporter, doc.rmdocPath, doc.splitDir, opts.splitBy, exportOpts)
+	} else {
+		result, err = opts.exporter.Export(s.ctx.goCtx, doc.rmdocPath, doc.outPath, exportOpts)
+	}
+	if err != nil {
+		fmt.Println(" FAILED")
+		log.Error.Printf("failed to convert %s: %v", doc.rmdocPath, err)
+		return nil, "convert", err
+	}
+	fmt.Println(" OK")
+
+	if len(result.OCRTimeouts) > 0 {
+		s.summary.warned(doc.rmdocPath, "ocr", fmt.Errorf("OCR timed out on pages %s, left without text", formatPages(result.OCRTimeouts)))
+	}
+	s.keep(result.Files...)
+	if s.searchIndex != nil {
+		indexDocument(s.searchIndex, doc.node, doc.remotePath, result.Text)
+	}
+
+	// only new conversions are sent, so that -i doesn't send the unchanged
+	// documents again
+	if err := s.email(doc, result.Files); err != nil {
+		return result, "email", err
+	}
+	if len(s.sinks) > 0 {
+		if err := uploadToSinks(s.ctx, s.sinks, s.target, result.Files, opts.noLocal); err != nil {
+			return result, "upload", err
+		}
+	}
+	return result, "", nil
+}
+
+// email sends the converted files of a document with -email
+func (s *mgetaSync) email(doc *mgetaDocument, files []string) error {
+	if s.mailer == nil {
+		return nil
+	}
+	fmt.Printf("emailing [%s]...", doc.outPath)
+	if err := s.mailer.Send(s.ctx.goCtx, doc.node.Name(), files); err != nil {
+		fmt.Println(" FAILED")
+		log.Error.Printf("failed to email %s: %v", doc.outPath, err)
+		return err
+	}
+	fmt.Println(" OK")
+	return nil
+}
+
+// addCombined adds the PDFs of a document to those merged by -combine in
+// its folder
+func (s *mgetaSync) addCombined(doc *mgetaDocument) {
+	if s.opts.exporter.Name() != "pdf" {
+		return
+	}
+	dir := filepath.Dir(doc.rmdocPath)
+	if _, err := os.Stat(doc.outPath); err == nil {
+		s.combined[dir] = append(s.combined[dir], rmconvert.CombineInput{
+			Title: doc.node.Name(),
+			Path:  doc.outPath,
+		})
+	}
+	if doc.splitDir != "" {
+		for _, f := range splitFiles(doc.splitDir) {
+			s.combined[dir] = append(s.combined[dir], rmconvert.CombineInput{
+				Title: doc.node.Name() + " " + strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)),
+				Path:  f,
+			})
+		}
+	}
+}
+
+// documentSynced records a document that was synced, converted unless
+// converted is nil
+func (s *mgetaSync) documentSynced(doc *mgetaDocument, started time.Time, converted *rmconvert.ExportResult) {
+	s.summary.succeeded()
+	metrics.DocumentsSynced.Inc()
+	if converted == nil {
+		return
+	}
+	pages, err := rmconvert.PageCount(doc.rmdocPath)
+	if err != nil {
+		log.Warning.Printf("failed to count the pages of %s: %v", doc.rmdocPath, err)
+	}
+	metrics.PagesRendered.Add(float64(pages))
+	s.notify(integrations.WebhookEvent{
+		Event:    integrations.EventDocumentConverted,
+		Document: webhookDocument(doc),
+		Pages:    pages,
+		Outputs:  converted.Files,
+		Duration: time.Since(started).Seconds(),
+	})
+}
+
+// documentFailed records a document whose sync failed at stage
+func (s *mgetaSync) documentFailed(doc *mgetaDocument, started time.Time, stage string, err error) {
+	s.summary.failed(doc.rmdocPath, stage, err)
+	metrics.DocumentsFailed.WithLabel(stage).Inc()
+	message := err.Error()
+	if stage != "download" {
+		message = fmt.Sprintf("%s: %v", stage, err)
+	}
+	s.notify(integrations.WebhookEvent{
+		Event:    integrations.EventDocumentFailed,
+		Document: webhookDocument(doc),
+		Duration: time.Since(started).Seconds(),
+		Error:    message,
+	})
+}
+
+// webhookDocument returns the document of the webhook events of doc
+func webhookDocument(doc *mgetaDocument) *integrations.WebhookDocument {
+	return &integrations.WebhookDocument{ID: doc.node.Id(), Name: doc.node.Name(), Path: doc.remotePath}
+}
+
+// notify sends an event to the webhook, a failure doesn't fail the sync
+func (s *mgetaSync) notify(event integrations.WebhookEvent) {
+	if s.webhook == nil {
+		return
+	}
+	if err := s.webhook.Send(s.ctx.goCtx, event); err != nil {
+		log.Warning.Printf("failed to notify the webhook: %v", err)
+	}
+}
+
+// combinePDFs merges the converted PDFs of each folder for -combine
+func (s *mgetaSync) combinePDFs() {
+	dirs := make([]string, 0, len(s.combined))
+	for dir := range s.combined {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		inputs := s.combined[dir]
+		sort.Slice(inputs, func(i, j int) bool { return inputs[i].Title < inputs[j].Title })
+
+		combinedPath := combinedPDFPath(dir)
+		s.keep(combinedPath)
+
+		fmt.Printf("combining %d documents into [%s]...", len(inputs), combinedPath)
+		if err := rmconvert.CombinePDFs(inputs, combinedPath, s.opts.combineTOC); err != nil {
+			fmt.Println(" FAILED")
+			log.Error.Printf("failed to combine %s: %v", combinedPath, err)
+			s.summary.failed(combinedPath, "combine", err)
+			continue
+		}
+		fmt.Println(" OK")
+		if err := uploadToSinks(s.ctx, s.sinks, s.target, []string{combinedPath}, false); err != nil {
+			s.summary.failed(combinedPath, "upload", err)
+		}
+	}
+}
+
+// removeDeleted removes the local files and folders of the output
+// directory that no remote entry has, for -d
+func (s *mgetaSync) removeDeleted() {
+	filepath.Walk(s.target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Warning.Printf("can't read %s: %v", path, err)
+			return nil
+		}
+		//just to be sure
+		if path == s.target {
+			return nil
+		}
+		if _, ok := s.fileMap[path]; ok {
+			return nil
+		}
+		if info.IsDir() {
+			fmt.Println("Removing folder ", path)
+			if err := os.RemoveAll(path); err != nil {
+				log.Error.Printf("error removing folder: %v", err)
 			}
+			return filepath.SkipDir
+		}
+
+		fmt.Println("Removing ", path)
+		if err := os.Remove(path); err != nil {
+			log.Error.Printf("error removing file: %v", err)
+		}
+		return nil
+	})
+}
+
+// uploadToSinks uploads files of the target directory to the sinks, at
+// the same relative path, and removes them if removeLocal is set and every
+// upload succeeded
+func uploadToSinks(ctx *Context, sinks []integrations.Sink, target string, files []string, removeLocal bool) error {
+	var firstErr error
+	for _, f := range files {
+		rel, err := filepath.Rel(target, f)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
 
-			fileMap := make(map[string]struct{})
-			fileMap[target] = struct{}{}
-
-			visitor := filetree.FileTreeVistor{
-				func(currentNode *model.Node, currentPath []string) bool {
-					idxDir := 0
-					if srcName == "." && len(currentPath) > 0 {
-						idxDir = 1
-					}
-
-					fileName := fmt.Sprintf("%s.%s", currentNode.Name(), util.RMDOC)
-					pdfFileName := fmt.Sprintf("%s.pdf", currentNode.Name())
-
-					rmdocPath := path.Join(target, filetree.BuildPath(currentPath[idxDir:], fileName))
-					pdfPath := path.Join(target, filetree.BuildPath(currentPath[idxDir:], pdfFileName))
-
-					fileMap[rmdocPath] = struct{}{}
-					fileMap[pdfPath] = struct{}{}
-
-					dir := path.Dir(rmdocPath)
-					fileMap[dir] = struct{}{}
-
-					os.MkdirAll(dir, 0766)
-
-					if currentNode.IsDirectory() {
-						return filetree.ContinueVisiting
-					}
-
-					lastModified, err := currentNode.LastModified()
-					if err != nil {
-						fmt.Printf("%v for %s\n", err, rmdocPath)
-						lastModified = time.Now()
-					}
-
-					// Check if we need to download/convert based on timestamps
-					needsUpdate := true
-					if *incremental {
-						stat, err := os.Stat(rmdocPath)
-						if err == nil {
-							localMod := stat.ModTime()
-							if !lastModified.After(localMod) {
-								needsUpdate = false
-							}
-						}
-					}
-
-					if needsUpdate {
-						fmt.Printf("downloading [%s]...", rmdocPath)
-
-						err = ctx.api.FetchDocument(currentNode.Document.ID, rmdocPath)
-						if err != nil {
-							fmt.Printf(" FAILED: %v\n", err)
-							return filetree.ContinueVisiting
-						}
-
-						fmt.Println(" OK")
-
-						err = os.Chtimes(rmdocPath, lastModified, lastModified)
-						if err != nil {
-							fmt.Printf("warning: can't set lastModified for %s: %v\n", rmdocPath, err)
-						}
-					}
-
-					// Convert to PDF if not skipping conversion
-					if !*skipConversion {
-						// Check if PDF needs update
-						needsPdfUpdate := true
-						if *incremental {
-							stat, err := os.Stat(pdfPath)
-							if err == nil {
-								pdfMod := stat.ModTime()
-								rmdocStat, rmdocErr := os.Stat(rmdocPath)
-								if rmdocErr == nil && !rmdocStat.ModTime().After(pdfMod) {
-									needsPdfUpdate = false
-								}
-							}
-						}
-
-						if needsPdfUpdate {
-							if *enableOCR {
-								fmt.Printf("converting [%s] to searchable PDF (DPI: %d, OCR: %s)...", rmdocPath, *dpi, *tessLang)
-							} else {
-								fmt.Printf("converting [%s] to PDF (DPI: %d)...", rmdocPath, *dpi)
-							}
-							err = rmconvert.ConvertRmdocToPDF(rmdocPath, pdfPath, *dpi, *enableOCR, *tessPath, *tessLang, *tessPSM)
-							if err != nil {
-								fmt.Printf(" FAILED: %v\n", err)
-							} else {
-								fmt.Println(" OK")
-							}
-						}
-					}
-
-					return filetree.ContinueVisiting
-				},
+		for _, sink := range sinks {
+			fmt.Printf("uploading [%s] to %s...", rel, sink.Name())
+			if err := sink.Put(ctx.goCtx, rel, f); err != nil {
+				fmt.Println(" FAILED")
+				log.Error.Printf("failed to upload %s: %v", f, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
 			}
+			fmt.Println(" OK")
+		}
+	}
 
-			filetree.WalkTree(node, visitor)
-
-			if *removeDeleted {
-				filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
-					if err != nil {
-						fmt.Printf("warning: can't read %s: %v\n", path, err)
-						return nil
-					}
-					//just to be sure
-					if path == target {
-						return nil
-					}
-					if _, ok := fileMap[path]; !ok {
-						var err error
-						if info.IsDir() {
-							fmt.Println("Removing folder ", path)
-							err = os.RemoveAll(path)
-							if err != nil {
-								fmt.Printf("error removing folder: %v\n", err)
-							}
-							return filepath.SkipDir
-						}
-
-						fmt.Println("Removing ", path)
-						err = os.Remove(path)
-						if err != nil {
-							fmt.Printf("error removing file: %v\n", err)
-						}
-					}
-					return nil
-				})
+	if removeLocal && firstErr == nil {
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				log.Warning.Printf("failed to remove %s: %v", f, err)
 			}
+		}
+	}
+	return firstErr
+}
 
+// exportedFiles returns the existing files written by an export to outPath,
+// either outPath itself or its pages (see rmconvert.PagePath)
+func exportedFiles(outPath string) []string {
+	if _, err := os.Stat(outPath); err == nil {
+		return []string{outPath}
+	}
+
+	var files []string
+	for page := 1; ; page++ {
+		pagePath := rmconvert.PagePath(outPath, page)
+		if _, err := os.Stat(pagePath); err != nil {
+			break
+		}
+		files = append(files, pagePath)
+	}
+	return files
+}
+
+// isQuickSheets tells whether a document is the Quick sheets notebook of
+// the device, at the root of the library
+func isQuickSheets(node *model.Node) bool {
+	return node.Name() == rmconvert.QuickSheetsName && node.Document.Parent == ""
+}
+
+// splitFiles returns the files of the folder a document was split to by
+// -split, in the order of its parts
+func splitFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files
+}
+
+// exportParts exports the parts of a document split by -split to dir and
+// removes the files of the parts it no longer has, e.g. when its pages were
+// deleted
+func exportParts(goCtx context.Context, exporter rmconvert.Exporter, rmdocPath, dir string, by rmconvert.SplitBy, opts rmconvert.ExportOptions) (*rmconvert.ExportResult, error) {
+	if err := os.MkdirAll(dir, 0766); err != nil {
+		return nil, err
+	}
+	result, err := rmconvert.SplitExport(goCtx, exporter, rmdocPath, dir, by, opts)
+	if err != nil {
+		return nil, err
+	}
+	written := make(map[string]bool, len(result.Files))
+	for _, f := range result.Files {
+		written[f] = true
+	}
+	for _, f := range splitFiles(dir) {
+		if !written[f] {
+			os.Remove(f)
+		}
+	}
+	return result, nil
+}
+
+// flatName returns the local name of a document for -flat, prefixed with
+// the folders below the source directory (dir[0]) so that documents of the
+// same name in different folders don't collide
+func flatName(dir []string, name string) string {
+	var parts []string
+	if len(dir) > 1 {
+		parts = append(parts, dir[1:]...)
+	}
+	return strings.Join(append(parts, name), "_")
+}
+
+// flattenNames returns the flatName of the documents of the sources. The
+// names that still collide, ignoring case, e.g. Work/Notes and a Work_Notes
+// document, are suffixed with the start of the ID as LocalNamer does, so
+// that they stay the same from run to run.
+func flattenNames(ctx *Context, namer filetree.LocalNamer, sources []*model.Node, maxDepth int) (map[*model.Node]string, error) {
+	names := make(map[*model.Node]string)
+	count := make(map[string]int)
+	for _, source := range sources {
+		err := walkDocuments(ctx, source, filetree.WalkOptions{MaxDepth: maxDepth}, func(node *model.Node, _ string, path []string) error {
+			name := flatName(namer.Dir(node, len(path)), namer.Name(node))
+			names[node] = name
+			count[strings.ToLower(name)]++
 			return nil
-		},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for node, name := range names {
+		if count[strings.ToLower(name)] > 1 {
+			names[node] = filetree.WithID(name, node)
+		}
+	}
+	return names, nil
+}
+
+// combinedPDFPath returns the path of the folder-level PDF written by -combine
+func combinedPDFPath(dir string) string {
+	name := filepath.Base(dir)
+	if name == "." || name == string(filepath.Separator) || strings.HasSuffix(name, ":"+string(filepath.Separator)) {
+		name = "combined"
 	}
+	return filepath.Join(dir, name+".combined.pdf")
 }
@@ -0,0 +1,228 @@
+package shell
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeApi is an api.ApiCtx over a file tree in memory, the methods the
+// tests don't override panic
+type fakeApi struct {
+	api.ApiCtx
+	tree *filetree.FileTreeCtx
+}
+
+func (f *fakeApi) Filetree() *filetree.FileTreeCtx {
+	return f.tree
+}
+
+// FetchDocument writes the ID of the document as its content
+func (f *fakeApi) FetchDocument(goCtx context.Context, docId, dstPath string) error {
+	return os.WriteFile(dstPath, []byte(docId), 0644)
+}
+
+// testContext returns a context of the commands over tree, with the config
+// and the caches in temporary folders
+func testContext(t *testing.T, tree *filetree.FileTreeCtx) *Context {
+	t.Setenv("RMAPI_CONFIG", filepath.Join(t.TempDir(), "rmapi.conf"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	return &Context{goCtx: context.Background(), api: &fakeApi{tree: tree}, node: tree.Root(), commands: map[string]Command{}}
+}
+
+// localFiles returns the files under dir, relative to it
+func localFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(files)
+	return files
+}
+
+func TestFlatName(t *testing.T) {
+	for _, tc := range []struct {
+		dir  []string
+		name string
+		want string
+	}{
+		{nil, "Notes", "Notes"},
+		{[]string{"Work"}, "Notes", "Notes"},
+		{[]string{"Work", "Meetings"}, "Notes", "Meetings_Notes"},
+		{[]string{"", "Work", "Meetings"}, "Notes", "Work_Meetings_Notes"},
+	} {
+		assert.Equal(t, tc.want, flatName(tc.dir, tc.name), "%v %s", tc.dir, tc.name)
+	}
+}
+
+func TestFlattenNames(t *testing.T) {
+	tree := filetree.CreateFileTreeCtx()
+	for _, doc := range []model.Document{
+		{ID: "a", Name: "a", Type: model.DirectoryType},
+		{ID: "ab", Name: "a_b", Type: model.DirectoryType},
+		{ID: "b", Name: "b", Type: model.DirectoryType, Parent: "a"},
+		{ID: "ab-c-0000001", Name: "c", Type: model.DocumentType, Parent: "ab"},
+		{ID: "a-bc-0000002", Name: "b_c", Type: model.DocumentType, Parent: "a"},
+		{ID: "work", Name: "Work", Type: model.DirectoryType},
+		{ID: "work-notes-3", Name: "Notes", Type: model.DocumentType, Parent: "work"},
+		{ID: "notes-00000004", Name: "work_notes", Type: model.DocumentType},
+		{ID: "todo", Name: "Todo", Type: model.DocumentType, Parent: "b"},
+	} {
+		tree.AddDocument(&doc)
+	}
+	tree.FinishAdd()
+	ctx := testContext(t, &tree)
+
+	names, err := flattenNames(ctx, filetree.DefaultLocalNamer, []*model.Node{tree.Root()}, 0)
+	assert.NoError(t, err)
+	byID := map[string]string{}
+	for node, name := range names {
+		byID[node.Id()] = name
+	}
+	assert.Equal(t, map[string]string{
+		"ab-c-0000001":   "a_b_c~ab-c-000",
+		"a-bc-0000002":   "a_b_c~a-bc-000",
+		"work-notes-3":   "Work_Notes~work-not",
+		"notes-00000004": "work_notes~notes-00",
+		"todo":           "a_b_Todo",
+	}, byID)
+
+	// the documents past -max-depth have no name
+	names, err = flattenNames(ctx, filetree.DefaultLocalNamer, []*model.Node{tree.Root()}, 2)
+	assert.NoError(t, err)
+	assert.Len(t, names, 4)
+}
+
+func TestMgetaMaxDepth(t *testing.T) {
+	tree := testTree()
+	ctx := testContext(t, tree)
+	mgeta := mgetaCommand(ctx)
+
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-s", "/Work"}, []string{"Work/Notes.rmdoc", "Work/Old/Paper.rmdoc"}},
+		{[]string{"-s", "-max-depth", "1", "/Work"}, []string{"Work/Notes.rmdoc"}},
+		{[]string{"-s", "-flat", "/Work"}, []string{"Notes.rmdoc", "Old_Paper.rmdoc"}},
+		{[]string{"-s", "-flat", "-max-depth", "1", "/Work"}, []string{"Notes.rmdoc"}},
+	} {
+		out := t.TempDir()
+		err := mgeta.Func(ctx, append([]string{"-index=false", "-o", out}, tc.args...))
+		assert.NoError(t, err, "%v", tc.args)
+		assert.Equal(t, tc.want, localFiles(t, out), "%v", tc.args)
+	}
+	assert.Error(t, mgeta.Func(ctx, []string{"-max-depth", "-1", "/Work"}))
+}
+
+func TestParseMgetaOptions(t *testing.T) {
+	tree := filetree.CreateFileTreeCtx()
+	ctx := testContext(t, &tree)
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"/"}, ""},
+		{nil, "missing source dir"},
+		{[]string{"-format", "doc", "/"}, "unknown format"},
+		{[]string{"-split-all", "/"}, "-split-all needs -split"},
+		{[]string{"-s", "-email", "/"}, "-email and -sink need the conversion, they can't be used with -s"},
+		{[]string{"-no-local", "/"}, "-no-local needs -sink and can't be used with -combine"},
+		{[]string{"-max-depth", "-1", "/"}, "invalid -max-depth -1"},
+		{[]string{"-width", "-1", "/"}, "invalid size -1x0"},
+		{[]string{"-d", "/"}, "set a folder explicitly with the -o flag when removing deleted (and not .)"},
+		{[]string{"-d", "-o", "out", "/", "/Work"}, "-d needs a single source dir"},
+	} {
+		opts, err := parseMgetaOptions(ctx, tc.args)
+		if tc.err == "" {
+			assert.NoError(t, err, "%v", tc.args)
+			continue
+		}
+		assert.Nil(t, opts, "%v", tc.args)
+		if assert.Error(t, err, "%v", tc.args) {
+			assert.Contains(t, err.Error(), tc.err, "%v", tc.args)
+		}
+	}
+
+	opts, err := parseMgetaOptions(ctx, []string{"-dpi", "150", "-svg-precision", "1", "-flat", "-o", "out", "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, 150, opts.exportOpts.DPI)
+	assert.Equal(t, 1, opts.exportOpts.SVG.Precision)
+	assert.True(t, opts.flat)
+	assert.Equal(t, "out", opts.outputDir)
+	assert.Equal(t, []string{"/"}, opts.sources)
+}
+
+func TestCombinedPDFPath(t *testing.T) {
+	for _, tc := range []struct {
+		dir  string
+		want string
+	}{
+		{filepath.Join("out", "Work"), filepath.Join("out", "Work", "Work.combined.pdf")},
+		{"out", filepath.Join("out", "out.combined.pdf")},
+		{".", "combined.combined.pdf"},
+		{string(filepath.Separator), filepath.Join(string(filepath.Separator), "combined.combined.pdf")},
+	} {
+		assert.Equal(t, tc.want, combinedPDFPath(tc.dir), tc.dir)
+	}
+}
+
+func TestMgetaCombine(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "page.png")
+	f, err := os.Create(img)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, image.NewGray(image.Rect(0, 0, 10, 10))))
+	f.Close()
+
+	work := filepath.Join(dir, "Work")
+	assert.NoError(t, os.Mkdir(work, 0755))
+	for _, name := range []string{"Notes", "Agenda"} {
+		assert.NoError(t, rmconvert.ConvertImagesToPDF([]string{img}, filepath.Join(work, name+".pdf")))
+	}
+
+	s := &mgetaSync{
+		opts:     &mgetaOptions{combineTOC: true},
+		target:   dir,
+		fileMap:  map[string]struct{}{},
+		summary:  &batchSummary{},
+		combined: map[string][]rmconvert.CombineInput{},
+	}
+	for _, name := range []string{"Notes", "Agenda"} {
+		s.combined[work] = append(s.combined[work], rmconvert.CombineInput{Title: name, Path: filepath.Join(work, name+".pdf")})
+	}
+	// a folder whose PDFs can't be merged fails alone
+	broken := filepath.Join(dir, "Broken")
+	s.combined[broken] = []rmconvert.CombineInput{{Title: "Missing", Path: filepath.Join(broken, "Missing.pdf")}}
+	s.combinePDFs()
+
+	combined := filepath.Join(work, "Work.combined.pdf")
+	assert.Contains(t, s.fileMap, combined)
+	assert.Equal(t, []string{"Agenda", "Notes"}, []string{s.combined[work][0].Title, s.combined[work][1].Title})
+	count, err := pdfapi.PageCountFile(combined)
+	assert.NoError(t, err)
+	// the table of contents and a page per document
+	assert.Equal(t, 3, count)
+
+	if assert.Len(t, s.summary.failures, 1) {
+		assert.Equal(t, filepath.Join(broken, "Broken.combined.pdf"), s.summary.failures[0].path)
+		assert.Equal(t, "combine", s.summary.failures[0].stage)
+	}
+}
@@ -0,0 +1,84 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juruen/rmapi/rmconvert"
+)
+
+// ocrCommand adds a text layer to a local PDF of scanned pages. It needs no
+// cloud access, see RunOCR.
+func ocrCommand() Command {
+	return Command{
+		Name:  "ocr",
+		Help:  "make a local PDF of scanned pages searchable with tesseract",
+		Usage: "[options] input.pdf [output.pdf]",
+		Examples: []string{
+			"rmapi ocr scan.pdf scan.searchable.pdf",
+			"rmapi ocr -tess-lang eng+fra notes.pdf",
+			"rmapi ocr -deskew -despeckle scan.pdf",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "ocr")
+			tessPath := flagSet.String("tess-path", "tesseract", "path to tesseract binary")
+			tessLang := flagSet.String("tess-lang", "eng", "tesseract language")
+			tessPSM := flagSet.Int("tess-psm", 6, "tesseract page segmentation mode")
+			timeout := flagSet.Duration("timeout", 2*time.Minute, "stop the OCR of a page after this long and leave it without text (0 for no limit)")
+			deskew := flagSet.Bool("deskew", false, "straighten the pages whose lines are tilted before OCR")
+			binarize := flagSet.Bool("binarize", false, "turn the pages black and white before OCR")
+			despeckle := flagSet.Bool("despeckle", false, "remove the isolated dots of the pages before OCR")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 || len(argRest) > 2 {
+				return errors.New("usage: ocr [options] input.pdf [output.pdf]")
+			}
+			// the input is replaced without an output
+			inPath, outPath := argRest[0], argRest[0]
+			if len(argRest) == 2 {
+				outPath = argRest[1]
+			}
+
+			opts := rmconvert.ExportOptions{
+				TesseractPath: *tessPath,
+				Language:      *tessLang,
+				PSM:           *tessPSM,
+				OCRDeskew:     *deskew,
+				OCRBinarize:   *binarize,
+				OCRDespeckle:  *despeckle,
+				OCRTimeout:    *timeout,
+			}
+			if err := rmconvert.CheckTesseractLanguages(ctx.goCtx, opts); err != nil {
+				return err
+			}
+			pipeline := rmconvert.NewPipeline(opts)
+			results, err := pipeline.OCRFile(ctx.goCtx, inPath, outPath)
+			if err != nil {
+				return err
+			}
+
+			words := 0
+			for _, page := range results {
+				words += len(page.Words)
+			}
+			fmt.Printf("recognised %d words on %d pages, written to %s\n", words, len(results), outPath)
+			if timedOut := rmconvert.TimedOutPages(results); len(timedOut) > 0 {
+				fmt.Printf("OCR timed out on pages %s, left without text\n", formatPages(timedOut))
+			}
+			return nil
+		},
+	}
+}
+
+// RunOCR runs the ocr command with its arguments, without the cloud
+func RunOCR(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, ocrCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"ocr"}, args...))
+}
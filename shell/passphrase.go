@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// passphraseInput reads the passphrases, shared so that the lines read
+// ahead aren't lost between two prompts
+var passphraseInput = bufio.NewReader(os.Stdin)
+
+// AskPassphrase asks the passphrase of the encrypted storage, see
+// vault.Unlock
+func AskPassphrase() (string, error) {
+	return promptPassphrase("Passphrase: ")
+}
+
+// promptPassphrase asks a passphrase on the terminal without echoing it. A
+// plain line is read when stdin isn't a terminal.
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		line, err := passphraseInput.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer func() {
+		restore()
+		fmt.Fprint(os.Stderr, "\r\n")
+	}()
+	return readPassphrase(passphraseInput)
+}
+
+// readPassphrase reads the keys of a passphrase typed on a terminal in raw
+// mode until enter
+func readPassphrase(in *bufio.Reader) (string, error) {
+	var buf []rune
+	for {
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+		case 3: // ctrl-c
+			return "", errors.New("interrupted")
+		case 4: // ctrl-d
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+		case 127, 8: // backspace
+			if len(buf) > 0 {
+				buf = buf[:len(buf)-1]
+			}
+		case 21: // ctrl-u
+			buf = nil
+		default:
+			if r >= ' ' {
+				buf = append(buf, r)
+			}
+		}
+	}
+}
+
+// readNewPassphrase asks a new passphrase twice
+func readNewPassphrase() (string, error) {
+	passphrase, err := promptPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	again, err := promptPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", errors.New("the passphrases don't match")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,198 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/juruen/rmapi/client"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/transport"
+)
+
+func putCommand(ctx *Context) Command {
+	return Command{
+		Name:  "put",
+		Help:  "copy a local document (pdf, epub, rmdoc, png, jpg, svg) to cloud",
+		Usage: "[options] <local file> [remote dir]",
+		Examples: []string{
+			"rmapi put paper.pdf /Papers",
+			"rmapi put -p -tags work,todo notes.rmdoc /Work/2024",
+			"rmapi put -queue paper.pdf /Papers",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "put")
+			name := flagSet.String("name", "", "remote document name (default: local file name)")
+			tags := flagSet.String("tags", "", "comma separated list of document tags")
+			pinned := flagSet.Bool("pinned", false, "mark the document as favorite")
+			coverpage := flagSet.Int("coverpage", -1, "set coverpage (0 to disable, 1 to use the first page)")
+			createParents := flagSet.Bool("p", false, "create missing remote directories")
+			queued := flagSet.Bool("queue", false, "queue the upload when the cloud is unreachable, see 'rmapi queue'")
+			svgConverter := flagSet.String("svg-converter", "", "external tool converting svg files ("+strings.Join(rmconvert.SVGConverterNames(), ", ")+"), built-in converter by default")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file")
+			}
+			srcName := argRest[0]
+
+			opts := &model.UploadOptions{
+				Name:   *name,
+				Pinned: *pinned,
+			}
+			if *tags != "" {
+				for _, tag := range strings.Split(*tags, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						opts.Tags = append(opts.Tags, tag)
+					}
+				}
+			}
+			if *coverpage >= 0 {
+				opts.Coverpage = coverpage
+			}
+
+			docName := client.UploadName(srcName, opts)
+
+			// without the cloud, see RunQueued, the remote dir is checked
+			// when the queue is flushed
+			dstPath := "/"
+			var dstDir *model.Node
+			if len(argRest) > 1 {
+				dstPath = argRest[1]
+			}
+			if ctx.api == nil {
+				if !*queued {
+					return errors.New("the cloud is unreachable")
+				}
+				dstPath = absRemotePath(dstPath)
+			} else {
+				dstDir = ctx.node
+				if len(argRest) > 1 {
+					node, err := ctx.api.Filetree().NodeByPath(argRest[1], ctx.node)
+					if err != nil && *createParents {
+						node, err = mkdirAll(ctx, argRest[1])
+					}
+					if err != nil {
+						return err
+					}
+					if node.IsFile() {
+						return fmt.Errorf("%s is not a directory", argRest[1])
+					}
+					dstDir = node
+				}
+				if _, err := dstDir.FindByName(docName); err == nil {
+					return fmt.Errorf("entry already exists (%s)", docName)
+				}
+				dstPath, _ = ctx.api.Filetree().NodeToPath(dstDir)
+			}
+
+			// the queued images and svg files are converted now, the tool
+			// may not be there when the queue is flushed
+			queueUpload := func() error {
+				uploadPath, cleanup, err := client.PrepareUpload(ctx.goCtx, srcName, docName, *svgConverter)
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				op, err := queueOp(queue.Op{
+					Source:        srcName,
+					Dir:           dstPath,
+					CreateParents: *createParents,
+					Name:          docName,
+					Tags:          opts.Tags,
+					Pinned:        opts.Pinned,
+					Coverpage:     opts.Coverpage,
+				}, uploadPath)
+				if err != nil {
+					return fmt.Errorf("failed to queue %s: %v", srcName, err)
+				}
+				fmt.Printf("queued: [%s] as upload %d, send it with 'rmapi queue flush'\n", srcName, op.ID)
+				return nil
+			}
+			if ctx.api == nil {
+				return queueUpload()
+			}
+
+			fmt.Printf("uploading: [%s]...", srcName)
+
+			c := client.NewFromApi(ctx.api, &ctx.UserInfo)
+			c.SetSVGConverter(*svgConverter)
+			_, err := c.UploadInto(ctx.goCtx, srcName, dstDir, opts)
+			if err != nil {
+				fmt.Println(" FAILED")
+				if *queued && transport.IsUnreachable(err) {
+					log.Warning.Printf("the cloud is unreachable: %v", err)
+					return queueUpload()
+				}
+				return fmt.Errorf("failed to upload file %s: %v", srcName, err)
+			}
+
+			fmt.Println(" OK")
+			return nil
+		},
+	}
+}
+
+// mkdirAll creates the remote directory given by path along with any missing
+// parents and returns its node
+func mkdirAll(ctx *Context, path string) (*model.Node, error) {
+	node := ctx.node
+	if strings.HasPrefix(path, "/") {
+		node = ctx.api.Filetree().Root()
+	}
+
+	names := strings.Split(path, "/")
+	if filetree.HasUUIDPrefix(names[0]) {
+		var err error
+		node, err = ctx.api.Filetree().NodeByPath(names[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		if node.IsFile() {
+			return nil, fmt.Errorf("%s is not a directory", names[0])
+		}
+		names = names[1:]
+	}
+
+	for _, name := range names {
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			if node.Parent != nil {
+				node = node.Parent
+			}
+			continue
+		}
+
+		child, err := node.FindByName(name)
+		if err == nil {
+			if child.IsFile() {
+				return nil, fmt.Errorf("%s is not a directory", name)
+			}
+			node = child
+			continue
+		}
+
+		document, err := ctx.api.CreateDir(node.Id(), name, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", name, err)
+		}
+		ctx.api.Filetree().AddDocument(document)
+
+		node = ctx.api.Filetree().NodeById(document.ID)
+		if node == nil {
+			return nil, fmt.Errorf("failed to create directory %s", name)
+		}
+	}
+
+	return node, nil
+}
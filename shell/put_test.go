@@ -0,0 +1,77 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// putApi records the content of the uploaded files by document name
+type putApi struct {
+	fakeApi
+	uploaded map[string][]byte
+}
+
+func (f *putApi) UploadDocument(goCtx context.Context, parentId string, sourceDocPath string, notify bool, opts *model.UploadOptions) (*model.Document, error) {
+	content, err := os.ReadFile(sourceDocPath)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := util.DocPathToName(sourceDocPath)
+	if opts.Name != "" {
+		name = opts.Name
+	}
+	f.uploaded[name] = content
+	return &model.Document{ID: "doc-" + name, Parent: parentId, Name: name, Type: model.DocumentType}, nil
+}
+
+func TestPutImages(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewGray(image.Rect(0, 0, 20, 10))
+	var pngData, jpegData bytes.Buffer
+	assert.NoError(t, png.Encode(&pngData, img))
+	assert.NoError(t, jpeg.Encode(&jpegData, img, nil))
+
+	for _, tc := range []struct {
+		file    string
+		content []byte
+		args    []string
+		name    string
+		err     string
+	}{
+		{"photo.png", pngData.Bytes(), nil, "photo", ""},
+		{"scan.jpg", jpegData.Bytes(), []string{"-name", "Receipt"}, "Receipt", ""},
+		{"Todo.png", pngData.Bytes(), nil, "", "entry already exists (Todo)"},
+		{"broken.png", []byte("not an image"), nil, "", "failed to read image"},
+	} {
+		ctx := testContext(t, testTree())
+		api := &putApi{fakeApi: *ctx.api.(*fakeApi), uploaded: map[string][]byte{}}
+		ctx.api = api
+
+		src := filepath.Join(dir, tc.file)
+		assert.NoError(t, os.WriteFile(src, tc.content, 0644))
+		err := putCommand(ctx).Func(ctx, append(tc.args, src))
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.file)
+			assert.Empty(t, api.uploaded, tc.file)
+			continue
+		}
+		assert.NoError(t, err, tc.file)
+
+		// the images are uploaded as a PDF
+		assert.Equal(t, "pdf", util.DetectFileType(api.uploaded[tc.name]), tc.file)
+		node, err := api.tree.NodeByPath("/"+tc.name, api.tree.Root())
+		if assert.NoError(t, err, tc.file) {
+			assert.Equal(t, "doc-"+tc.name, node.Id())
+		}
+	}
+}
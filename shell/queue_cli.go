@@ -0,0 +1,258 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/juruen/rmapi/client"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/util"
+)
+
+// queueCommand manages the uploads and metadata changes queued by the
+// -queue option of put, cp, dedupe and restore. Only flush needs the cloud,
+// see RunQueue.
+func queueCommand() Command {
+	return Command{
+		Name:  "queue",
+		Help:  "list, remove or send the uploads and changes queued with -queue while the cloud was unreachable",
+		Usage: "ls\nrm <id>...\nflush",
+		Examples: []string{
+			"rmapi queue ls",
+			"rmapi queue rm 2",
+			"rmapi queue flush",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "queue")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing action: ls, rm or flush")
+			}
+
+			q, err := openQueue()
+			if err != nil {
+				return err
+			}
+
+			switch argRest[0] {
+			case "ls":
+				if len(q.Ops) == 0 {
+					fmt.Println("no queued operations")
+					return nil
+				}
+				for _, op := range q.Ops {
+					size := ""
+					if op.File != "" {
+						if info, err := os.Stat(q.Path(op)); err == nil {
+							size = util.FormatSize(info.Size())
+						}
+					}
+					fmt.Printf("%d\t%s\t%s\t%s\n", op.ID, op.Created.Local().Format("2006-01-02 15:04"), size, op)
+					if op.Attempts > 0 {
+						fmt.Printf("\t%d failed attempts, last: %s\n", op.Attempts, op.LastError)
+					}
+				}
+				return nil
+			case "rm":
+				if len(argRest) < 2 {
+					return errors.New("missing operation id")
+				}
+				for _, arg := range argRest[1:] {
+					id, err := strconv.Atoi(arg)
+					if err != nil {
+						return fmt.Errorf("invalid operation id %q", arg)
+					}
+					if err := q.Remove(id); err != nil {
+						return err
+					}
+				}
+				return q.Save()
+			case "flush":
+				if ctx.api == nil {
+					return errors.New("the cloud is unreachable")
+				}
+				return flushQueue(ctx, q)
+			default:
+				return fmt.Errorf("unknown action %q, use ls, rm or flush", argRest[0])
+			}
+		},
+	}
+}
+
+func openQueue() (*queue.Queue, error) {
+	dir, err := queue.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return queue.Open(dir)
+}
+
+// QueuedOps returns the number of uploads and changes in the queue
+func QueuedOps() int {
+	q, err := openQueue()
+	if err != nil {
+		log.Trace.Println(err)
+		return 0
+	}
+	return len(q.Ops)
+}
+
+// queueOp adds the upload of the file at path to the queue
+func queueOp(op queue.Op, path string) (queue.Op, error) {
+	q, err := openQueue()
+	if err != nil {
+		return op, err
+	}
+	return q.Add(op, path)
+}
+
+// queueChange adds a metadata change to the queue
+func queueChange(op queue.Op) (queue.Op, error) {
+	q, err := openQueue()
+	if err != nil {
+		return op, err
+	}
+	return q.AddChange(op)
+}
+
+// flushQueue sends the queued operations in order. The operations that fail
+// stay in the queue, flushing stops when the cloud is unreachable again.
+func flushQueue(ctx *Context, q *queue.Queue) error {
+	summary := &batchSummary{}
+	pending := append([]queue.Op(nil), q.Ops...)
+	for _, op := range pending {
+		if err := ctx.goCtx.Err(); err != nil {
+			summary.print(os.Stdout)
+			return fmt.Errorf("interrupted: %v", err)
+		}
+
+		remotePath := path.Join(op.Dir, op.Name)
+		stage := "upload"
+		if op.Kind == queue.Upload {
+			fmt.Printf("uploading: [%s]...", remotePath)
+		} else {
+			stage = string(op.Kind)
+			remotePath = op.String()
+			fmt.Printf("sending: [%s]...", remotePath)
+		}
+		err := sendQueued(ctx, q, op)
+		if err != nil {
+			fmt.Println(" FAILED")
+			q.Failed(op.ID, err)
+			if serr := q.Save(); serr != nil {
+				log.Error.Printf("failed to save the queue: %v", serr)
+			}
+			if transport.IsUnreachable(err) {
+				summary.print(os.Stdout)
+				return fmt.Errorf("the cloud is unreachable, %d operations left in the queue: %w", len(q.Ops), err)
+			}
+			summary.failed(remotePath, stage, err)
+			continue
+		}
+		fmt.Println(" OK")
+		summary.succeeded()
+
+		if err := q.Remove(op.ID); err != nil {
+			log.Warning.Printf("failed to remove operation %d from the queue: %v", op.ID, err)
+		}
+		if err := q.Save(); err != nil {
+			return fmt.Errorf("failed to save the queue: %v", err)
+		}
+	}
+	if len(pending) == 0 {
+		fmt.Println("no queued operations")
+		return nil
+	}
+	summary.print(os.Stdout)
+	return summary.err()
+}
+
+// sendQueued applies a queued operation
+func sendQueued(ctx *Context, q *queue.Queue, op queue.Op) error {
+	switch op.Kind {
+	case queue.Upload:
+		return uploadQueued(ctx, q, op)
+	case queue.Copy:
+		return copyQueued(ctx, op)
+	case queue.Mkdir:
+		_, err := mkdirAll(ctx, path.Join(op.Dir, op.Name))
+		return err
+	case queue.Batch:
+		if err := ctx.api.Batch(op.Batch, true); err != nil {
+			return err
+		}
+		reloadCurrentDir(ctx)
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q, queued by a newer rmapi", op.Kind)
+	}
+}
+
+// copyQueued copies the document of a queued copy into its folder
+func copyQueued(ctx *Context, op queue.Op) error {
+	tree := ctx.api.Filetree()
+	src, err := tree.NodeByPath(op.Target, tree.Root())
+	if err != nil {
+		return err
+	}
+	dir, err := tree.NodeByPath(op.Dir, tree.Root())
+	if err != nil && op.CreateParents {
+		dir, err = mkdirAll(ctx, op.Dir)
+	}
+	if err != nil {
+		return err
+	}
+	return copyDocument(ctx, src, dir, op.Name)
+}
+
+// uploadQueued uploads the document of a queued operation to its folder
+func uploadQueued(ctx *Context, q *queue.Queue, op queue.Op) error {
+	tree := ctx.api.Filetree()
+	dir, err := tree.NodeByPath(op.Dir, tree.Root())
+	if err != nil && op.CreateParents {
+		dir, err = mkdirAll(ctx, op.Dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := &model.UploadOptions{
+		Name:      op.Name,
+		Tags:      op.Tags,
+		Pinned:    op.Pinned,
+		Coverpage: op.Coverpage,
+	}
+	_, err = client.NewFromApi(ctx.api, &ctx.UserInfo).UploadInto(ctx.goCtx, q.Path(op), dir, opts)
+	return err
+}
+
+// RunQueue runs the queue command with its arguments, without the cloud:
+// queued operations can be listed and removed offline
+func RunQueue(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, queueCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"queue"}, args...))
+}
+
+// QueuedCommands are the commands that can run without the cloud with
+// -queue, see RunQueued
+var QueuedCommands = []string{"put", "cp"}
+
+// RunQueued runs put or cp with their arguments while the cloud is
+// unreachable, the operation is queued if -queue is given
+func RunQueued(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, putCommand(ctx))
+	registerCommand(ctx.commands, cpCommand(ctx))
+	return runCommand(ctx, ctx.commands, args)
+}
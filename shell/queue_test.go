@@ -0,0 +1,85 @@
+package shell
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueApi applies copies, folder creations and batches to the tree, or
+// fails as if the cloud was unreachable
+type queueApi struct {
+	fakeApi
+	unreachable bool
+	batches     [][]model.BatchOp
+}
+
+var errUnreachable = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func (f *queueApi) CopyDocument(docId, parentId, name string, notify bool) (*model.Document, error) {
+	if f.unreachable {
+		return nil, errUnreachable
+	}
+	return &model.Document{ID: "copy-" + docId, Parent: parentId, Name: name, Type: model.DocumentType}, nil
+}
+
+func (f *queueApi) CreateDir(parentId, name string, notify bool) (*model.Document, error) {
+	if f.unreachable {
+		return nil, errUnreachable
+	}
+	return &model.Document{ID: "dir-" + name, Parent: parentId, Name: name, Type: model.DirectoryType}, nil
+}
+
+func (f *queueApi) Batch(ops []model.BatchOp, notify bool) error {
+	if f.unreachable {
+		return errUnreachable
+	}
+	f.batches = append(f.batches, ops)
+	return nil
+}
+
+func TestQueuedChanges(t *testing.T) {
+	tree := testTree()
+	ctx := testContext(t, tree)
+	t.Setenv("RMAPI_QUEUE", t.TempDir())
+	api := &queueApi{fakeApi: fakeApi{tree: tree}, unreachable: true}
+	ctx.api = api
+	registerCommand(ctx.commands, cpCommand(ctx))
+
+	// without -queue the copy fails
+	assert.Error(t, runCommand(ctx, ctx.commands, []string{"cp", "/Todo", "/Work"}))
+	assert.NoError(t, runCommand(ctx, ctx.commands, []string{"cp", "-queue", "-name", "Todo 2", "/Todo", "/Work"}))
+	_, err := queueChange(queue.Op{Kind: queue.Mkdir, Dir: "/Work", Name: "New"})
+	assert.NoError(t, err)
+	_, err = queueChange(queue.Op{Kind: queue.Batch, Batch: []model.BatchOp{{Kind: model.BatchDelete, ID: "paper"}}})
+	assert.NoError(t, err)
+
+	q, err := openQueue()
+	assert.NoError(t, err)
+	if assert.Len(t, q.Ops, 3) {
+		// the paths of the copy are found before the cloud fails
+		assert.Equal(t, "copy uuid:todo -> /Work/Todo 2", q.Ops[0].String())
+	}
+
+	// the operations stay queued while the cloud is unreachable
+	assert.Error(t, flushQueue(ctx, q))
+	assert.Len(t, q.Ops, 3)
+	assert.Equal(t, 1, q.Ops[0].Attempts)
+
+	api.unreachable = false
+	assert.NoError(t, flushQueue(ctx, q))
+	assert.Empty(t, q.Ops)
+	copied, err := tree.NodeByPath("/Work/Todo 2", tree.Root())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "copy-todo", copied.Id())
+	}
+	dir, err := tree.NodeByPath("/Work/New", tree.Root())
+	if assert.NoError(t, err) {
+		assert.True(t, dir.IsDirectory())
+	}
+	assert.Equal(t, [][]model.BatchOp{{{Kind: model.BatchDelete, ID: "paper"}}}, api.batches)
+}
@@ -0,0 +1,49 @@
+package shell
+
+import (
+	"fmt"
+)
+
+func refreshCommand(ctx *Context) Command {
+	return Command{
+		Name:  "refresh",
+		Help:  "refresh the cached file tree (only changed entries are fetched)",
+		Usage: "[-full]",
+		Examples: []string{
+			"rmapi refresh -full",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "refresh")
+			full := flagSet.Bool("full", false, "discard the cache and rebuild the whole tree")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			refresh := ctx.api.Refresh
+			if *full {
+				refresh = ctx.api.RefreshFull
+			}
+
+			hash, generation, err := refresh()
+			if err != nil {
+				return fmt.Errorf("failed to refresh: %v", err)
+			}
+
+			reloadCurrentDir(ctx)
+			fmt.Printf("root hash: %s\ngeneration: %d\n", hash, generation)
+			return nil
+		},
+	}
+}
+
+// reloadCurrentDir points the shell to its current directory in the tree
+// reloaded by the api, the root if it no longer exists
+func reloadCurrentDir(ctx *Context) {
+	root := ctx.api.Filetree().Root()
+	if node, err := ctx.api.Filetree().NodeByPath(currentPath(ctx), root); err == nil && node.IsDirectory() {
+		ctx.node = node
+	} else {
+		ctx.node, ctx.path = root, root.Name()
+	}
+}
@@ -0,0 +1,55 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// refreshApi replaces its tree when refreshed, the full refresh with a tree
+// rebuilt without the Work folder
+type refreshApi struct {
+	fakeApi
+	refreshed string
+}
+
+func (f *refreshApi) Refresh() (string, int64, error) {
+	f.refreshed = "incremental"
+	f.tree = testTree()
+	return "root", 1, nil
+}
+
+func (f *refreshApi) RefreshFull() (string, int64, error) {
+	f.refreshed = "full"
+	tree := filetree.CreateFileTreeCtx()
+	tree.AddDocument(&model.Document{ID: "todo", Name: "Todo", Type: model.DocumentType})
+	tree.FinishAdd()
+	f.tree = &tree
+	return "root", 2, nil
+}
+
+func TestRefresh(t *testing.T) {
+	for _, tc := range []struct {
+		args      []string
+		refreshed string
+		path      string
+	}{
+		{nil, "incremental", "/Work"},
+		{[]string{"-full"}, "full", "/"},
+	} {
+		ctx := testContext(t, testTree())
+		api := &refreshApi{fakeApi: fakeApi{tree: testTree()}}
+		ctx.api = api
+		ctx.node, ctx.path = api.tree.NodeById("work"), "/Work"
+
+		assert.NoError(t, refreshCommand(ctx).Func(ctx, tc.args), "%v", tc.args)
+		assert.Equal(t, tc.refreshed, api.refreshed, "%v", tc.args)
+		// the current directory is looked up in the new tree
+		assert.Equal(t, tc.path, currentPath(ctx), "%v", tc.args)
+		node, err := api.tree.NodeByPath(currentPath(ctx), api.tree.Root())
+		assert.NoError(t, err, "%v", tc.args)
+		assert.Same(t, node, ctx.node, "%v", tc.args)
+	}
+}
@@ -0,0 +1,224 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/juruen/rmapi/backup"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/queue"
+	"github.com/juruen/rmapi/transport"
+	"github.com/juruen/rmapi/util"
+)
+
+func restoreCommand(ctx *Context) Command {
+	return Command{
+		Name:  "restore",
+		Help:  "upload the folders and documents of an archive missing from the cloud",
+		Usage: "[options] <archive dir or manifest.json>",
+		Examples: []string{
+			"rmapi restore ~/remarkable-backup",
+			"rmapi restore -n ~/remarkable-backup/manifest.json",
+			"rmapi restore -queue ~/remarkable-backup",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "restore")
+			dryRun := flagSet.Bool("n", false, "list what would be restored without uploading anything")
+			queued := flagSet.Bool("queue", false, "queue the rest of the archive when the cloud becomes unreachable, see 'rmapi queue'")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) != 1 {
+				return errors.New("missing archive dir")
+			}
+			dir := argRest[0]
+			if filepath.Base(dir) == backup.ManifestName {
+				dir = filepath.Dir(dir)
+			}
+			manifest, err := backup.Load(dir)
+			if err != nil {
+				return err
+			}
+			return restoreArchive(ctx, manifest, dir, restoreOptions{dryRun: *dryRun, queued: *queued})
+		},
+	}
+}
+
+// restoreOptions are the options of restore
+type restoreOptions struct {
+	dryRun bool
+	// queued queues the entries left once the cloud is unreachable
+	queued bool
+}
+
+// restoreArchive creates the folders and uploads the documents of the
+// archive that the cloud doesn't have, found by ID or by name in their
+// folder. Documents keep their ID, the folders created get new ones.
+func restoreArchive(ctx *Context, manifest *backup.Manifest, dir string, opts restoreOptions) error {
+	tree := ctx.api.Filetree()
+	summary := &batchSummary{}
+	existing, queuedCount := 0, 0
+	offline := false
+
+	// cloud IDs of the archived folders, the entries are sorted by path so
+	// folders come before their content
+	ids := map[string]string{"": ""}
+	// remote paths of the queued folders, their content is queued too
+	queuedDirs := map[string]string{}
+	for _, entry := range manifest.Entries {
+		if err := ctx.goCtx.Err(); err != nil {
+			summary.print(os.Stdout)
+			return fmt.Errorf("interrupted: %v", err)
+		}
+
+		if parentPath, ok := queuedDirs[entry.Parent]; ok {
+			if err := queueRestore(entry, dir, parentPath, queuedDirs); err != nil {
+				summary.failed(entry.Path, "queue", err)
+				continue
+			}
+			queuedCount++
+			continue
+		}
+
+		parentID, ok := ids[entry.Parent]
+		if !ok {
+			summary.failed(entry.Path, "restore", errors.New("its folder wasn't restored"))
+			continue
+		}
+		parent := tree.Root()
+		if parentID != "" {
+			parent = tree.NodeById(parentID)
+		}
+		// the entries are queued once the cloud is unreachable
+		queueEntry := func(err error) {
+			if !offline {
+				log.Warning.Printf("the cloud is unreachable, queueing the rest of the archive: %v", err)
+				offline = true
+			}
+			parentPath, _ := tree.NodeToPath(parent)
+			if err := queueRestore(entry, dir, parentPath, queuedDirs); err != nil {
+				summary.failed(entry.Path, "queue", err)
+				return
+			}
+			queuedCount++
+		}
+
+		if node := tree.NodeById(entry.ID); node != nil {
+			ids[entry.ID] = entry.ID
+			existing++
+			continue
+		}
+		if parent != nil {
+			if node, err := parent.FindByName(entry.Name); err == nil && node.IsDirectory() == entry.IsDirectory() {
+				ids[entry.ID] = node.Id()
+				existing++
+				continue
+			}
+		}
+
+		if opts.dryRun {
+			fmt.Printf("would restore [%s]\n", entry.Path)
+			ids[entry.ID] = entry.ID
+			continue
+		}
+		if offline {
+			queueEntry(nil)
+			continue
+		}
+
+		if entry.IsDirectory() {
+			fmt.Printf("creating [%s]...", entry.Path)
+			doc, err := ctx.api.CreateDir(parentID, entry.Name, false)
+			if err != nil {
+				fmt.Println(" FAILED")
+				if opts.queued && transport.IsUnreachable(err) {
+					queueEntry(err)
+					continue
+				}
+				log.Error.Printf("failed to create %s: %v", entry.Path, err)
+				summary.failed(entry.Path, "mkdir", err)
+				continue
+			}
+			fmt.Println(" OK")
+			tree.AddDocument(doc)
+			ids[entry.ID] = doc.ID
+			summary.succeeded()
+			continue
+		}
+
+		f := entry.File(util.RMDOC)
+		if f == nil {
+			summary.failed(entry.Path, "restore", errors.New("no .rmdoc file in the archive"))
+			continue
+		}
+		if err := f.Check(dir, true); err != nil {
+			summary.failed(entry.Path, "restore", err)
+			continue
+		}
+		fmt.Printf("uploading [%s]...", entry.Path)
+		doc, err := ctx.api.UploadDocument(ctx.goCtx, parentID, filepath.Join(dir, filepath.FromSlash(f.Path)), false, &model.UploadOptions{Name: entry.Name})
+		if err != nil {
+			fmt.Println(" FAILED")
+			if opts.queued && transport.IsUnreachable(err) {
+				queueEntry(err)
+				continue
+			}
+			log.Error.Printf("failed to upload %s: %v", entry.Path, err)
+			summary.failed(entry.Path, "upload", err)
+			continue
+		}
+		fmt.Println(" OK")
+		tree.AddDocument(doc)
+		summary.succeeded()
+	}
+
+	if summary.ok > 0 {
+		if err := ctx.api.SyncComplete(); err != nil {
+			log.Warning.Printf("failed to notify the devices: %v", err)
+		}
+	}
+	if existing > 0 {
+		fmt.Printf("%d entries already in the cloud\n", existing)
+	}
+	if queuedCount > 0 {
+		fmt.Printf("%d entries queued, send them with 'rmapi queue flush'\n", queuedCount)
+	}
+	summary.print(os.Stdout)
+	return summary.err()
+}
+
+// queueRestore queues the creation of an archived folder or the upload of
+// an archived document into the remote folder parentPath. The remote path
+// of a queued folder is added to queuedDirs.
+func queueRestore(entry backup.Entry, dir, parentPath string, queuedDirs map[string]string) error {
+	if entry.IsDirectory() {
+		op, err := queueChange(queue.Op{Kind: queue.Mkdir, Dir: parentPath, Name: entry.Name})
+		if err != nil {
+			return err
+		}
+		queuedDirs[entry.ID] = path.Join(parentPath, entry.Name)
+		fmt.Printf("queued: [%s] as operation %d\n", entry.Path, op.ID)
+		return nil
+	}
+
+	f := entry.File(util.RMDOC)
+	if f == nil {
+		return errors.New("no .rmdoc file in the archive")
+	}
+	if err := f.Check(dir, true); err != nil {
+		return err
+	}
+	src := filepath.Join(dir, filepath.FromSlash(f.Path))
+	op, err := queueOp(queue.Op{Source: src, Dir: parentPath, CreateParents: true, Name: entry.Name}, src)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued: [%s] as operation %d\n", entry.Path, op.ID)
+	return nil
+}
@@ -0,0 +1,122 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"time"
+
+	"github.com/juruen/rmapi/device"
+	"github.com/juruen/rmapi/util"
+)
+
+// screenshotCommand saves the screen of the tablet over SSH. It needs no
+// cloud access, see RunScreenshot.
+func screenshotCommand() Command {
+	return Command{
+		Name:  "screenshot",
+		Help:  "save the screen of the tablet as a PNG over SSH, or follow it with -live",
+		Usage: "[options] [file.png]",
+		Examples: []string{
+			"rmapi screenshot",
+			"rmapi screenshot -landscape slide.png",
+			"rmapi screenshot -live -interval 500ms screen.png",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "screenshot")
+			host := flagSet.String("host", device.DefaultHost, "user and address of the tablet, on the USB network by default")
+			identity := flagSet.String("i", "", "ssh key file")
+			landscape := flagSet.Bool("landscape", false, "keep the reMarkable 2 screen in landscape")
+			live := flagSet.Bool("live", false, "rewrite the file each time the screen changes, until interrupted")
+			interval := flagSet.Duration("interval", time.Second, "live: time between two captures")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) > 1 {
+				return errors.New("too many arguments")
+			}
+			if *interval <= 0 {
+				return errors.New("the interval must be positive")
+			}
+			output := time.Now().Format("screenshot-2006-01-02-150405.png")
+			if len(argRest) == 1 {
+				output = argRest[0]
+			}
+
+			dev := &device.Device{Host: *host}
+			if *identity != "" {
+				dev.Options = []string{"-i", *identity}
+			}
+			screen, err := dev.Screen(ctx.goCtx)
+			if err != nil {
+				return err
+			}
+
+			if !*live {
+				img, err := screen.Capture(ctx.goCtx, *landscape)
+				if err != nil {
+					return err
+				}
+				if err := util.WriteFileAtomic(output, func(w io.Writer) error { return png.Encode(w, img) }); err != nil {
+					return err
+				}
+				fmt.Println("saved", output)
+				return nil
+			}
+
+			fmt.Printf("following the screen in %s, interrupt to stop\n", output)
+			return followScreen(ctx.goCtx, screen, output, *landscape, *interval)
+		},
+	}
+}
+
+// followScreen captures the screen every interval and rewrites output when
+// it changed, until goCtx is done. The file is replaced at once, viewers
+// reloading it never read a partial frame.
+func followScreen(goCtx context.Context, screen *device.Screen, output string, landscape bool, interval time.Duration) error {
+	var last []byte
+	frames := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		data, err := screen.CaptureRaw(goCtx)
+		if goCtx.Err() != nil {
+			fmt.Printf("%d frames saved\n", frames)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, last) {
+			img, err := device.DecodeFrame(screen.Framebuffer(), data, landscape)
+			if err != nil {
+				return err
+			}
+			if err := util.WriteFileAtomic(output, func(w io.Writer) error { return png.Encode(w, img) }); err != nil {
+				return err
+			}
+			last = data
+			frames++
+		}
+
+		select {
+		case <-goCtx.Done():
+			fmt.Printf("%d frames saved\n", frames)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunScreenshot runs the screenshot command with its arguments, without
+// the cloud
+func RunScreenshot(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, screenshotCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"screenshot"}, args...))
+}
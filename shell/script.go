@@ -0,0 +1,64 @@
+package shell
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/log"
+)
+
+// RunScript runs the commands of a script, one per line like in the shell.
+// Blank lines and lines starting with # are skipped, cd changes the
+// directory of the following commands and exit stops the script. The
+// script stops at the first failing command unless keepGoing is set, the
+// failures are then reported at the end.
+func RunScript(goCtx context.Context, apiCtx api.ApiCtx, userInfo *api.UserInfo, r io.Reader, name string, keepGoing bool) error {
+	ctx := newContext(goCtx, apiCtx, userInfo)
+	commands := registerCommands(ctx)
+	return runScript(ctx, commands, r, name, keepGoing)
+}
+
+func runScript(ctx *Context, commands map[string]Command, r io.Reader, name string, keepGoing bool) error {
+	scanner := bufio.NewScanner(r)
+	lineNo, failed := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		if err := ctx.goCtx.Err(); err != nil {
+			return err
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args := parseArguments(line)
+		for i := range args {
+			args[i] = unescapeSpaces(args[i])
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+
+		err := runCommand(ctx, commands, args)
+		if err == nil {
+			continue
+		}
+		if !keepGoing {
+			return fmt.Errorf("%s:%d: %w", name, lineNo, err)
+		}
+		log.Error.Printf("%s:%d: %v", name, lineNo, err)
+		failed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%s: %d commands failed", name, failed)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunScript(t *testing.T) {
+	var ran []string
+	commands := map[string]Command{
+		"echo": {Name: "echo", Func: func(ctx *Context, args []string) error {
+			ran = append(ran, strings.Join(args, " "))
+			return nil
+		}},
+		"fail": {Name: "fail", Func: func(ctx *Context, args []string) error {
+			return errors.New("failed")
+		}},
+	}
+	ctx := &Context{
+		goCtx:   context.Background(),
+		aliases: map[string]string{"greet": "echo hello", "echo": "fail"},
+	}
+	script := "# a comment\n\necho My\\ Notes\ngreet world\nfail\necho after\nexit\necho never\n"
+
+	err := runScript(ctx, commands, strings.NewReader(script), "test.rmapi", false)
+	assert.EqualError(t, err, "test.rmapi:5: failed")
+	assert.Equal(t, []string{"My Notes", "hello world"}, ran)
+
+	ran = nil
+	err = runScript(ctx, commands, strings.NewReader(script), "test.rmapi", true)
+	assert.EqualError(t, err, "test.rmapi: 1 commands failed")
+	assert.Equal(t, []string{"My Notes", "hello world", "after"}, ran)
+}
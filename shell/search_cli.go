@@ -0,0 +1,103 @@
+package shell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/search"
+)
+
+func searchCommand(ctx *Context) Command {
+	return Command{
+		Name:  "search",
+		Help:  "search the text of the documents indexed by mgeta",
+		Usage: "[options] <query>",
+		Examples: []string{
+			"rmapi search \"quarterly report\"",
+			"rmapi search -n 0 -json budget",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "search")
+			limit := flagSet.Int("n", 20, "maximum number of results (0 for all)")
+			jsonOutput := flagSet.Bool("json", false, "print the results as JSON")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			query := strings.Join(flagSet.Args(), " ")
+			if strings.TrimSpace(query) == "" {
+				return errors.New("missing query")
+			}
+
+			idx, err := openSearchIndex()
+			if err != nil {
+				return fmt.Errorf("failed to open the search index: %v", err)
+			}
+			if len(idx.Documents) == 0 {
+				return errors.New("the search index is empty, run mgeta to build it")
+			}
+
+			// documents deleted since they were indexed are left out and
+			// the paths of the others are kept up to date
+			var results []search.Result
+			for _, r := range idx.Search(query) {
+				node := ctx.api.Filetree().NodeById(r.DocumentID)
+				if node == nil {
+					continue
+				}
+				if p, err := ctx.api.Filetree().NodeToPath(node); err == nil {
+					r.Path = p
+				}
+				results = append(results, r)
+				if *limit > 0 && len(results) == *limit {
+					break
+				}
+			}
+
+			if *jsonOutput {
+				if results == nil {
+					results = []search.Result{}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(results)
+			}
+
+			if len(results) == 0 {
+				fmt.Println("no matches")
+				return nil
+			}
+
+			for _, r := range results {
+				fmt.Printf("%s (page %d, %s)\n    %s\n", r.Path, r.Page, r.Source, r.Snippet)
+			}
+			return nil
+		},
+	}
+}
+
+func openSearchIndex() (*search.Index, error) {
+	path, err := search.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return search.Open(path)
+}
+
+// indexDocument replaces the indexed text of a document
+func indexDocument(idx *search.Index, node *model.Node, remotePath string, text []rmconvert.PageText) {
+	doc := search.Document{
+		ID:   node.Id(),
+		Path: remotePath,
+	}
+	for _, t := range text {
+		doc.Pages = append(doc.Pages, search.Page{Number: t.Page, Source: t.Source, Text: t.Text})
+	}
+	idx.Update(doc)
+}
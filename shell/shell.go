@@ -0,0 +1,89 @@
+package shell
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/juruen/rmapi/api"
+)
+
+// RunShell runs the interactive shell: commands are read from stdin and run
+// in the current directory, changed with cd, until exit or end of input.
+// Every CLI command is available. On a terminal lines are edited with
+// history and tab completion of the commands and remote paths.
+func RunShell(apiCtx api.ApiCtx, userInfo *api.UserInfo) error {
+	ctx := newContext(context.Background(), apiCtx, userInfo)
+	commands := registerCommands(ctx)
+
+	stdin := bufio.NewReader(os.Stdin)
+	editor := &lineEditor{
+		in:  stdin,
+		out: os.Stdout,
+		complete: func(line string) (int, []string) {
+			return completeLine(ctx, commands, line)
+		},
+	}
+
+	for {
+		line, err := readCommandLine(editor, stdin, fmt.Sprintf("[%s]>", currentPath(ctx)))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		args := parseArguments(line)
+		if len(args) == 0 {
+			continue
+		}
+		for i := range args {
+			args[i] = unescapeSpaces(args[i])
+		}
+
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			if len(args) > 1 {
+				err = printHelp(ctx, commands, args[1])
+			} else {
+				printUsage(ctx, commands)
+			}
+			if err != nil {
+				printError(os.Stderr, err)
+			}
+			continue
+		}
+
+		// ctrl-c interrupts the command, not the shell
+		goCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		ctx.goCtx = goCtx
+		err = runCommand(ctx, commands, args)
+		stop()
+		if err != nil {
+			printError(os.Stderr, err)
+		}
+	}
+}
+
+// readCommandLine reads a line with the line editor if stdin is a terminal,
+// or as is from a pipe, without prompt
+func readCommandLine(editor *lineEditor, stdin *bufio.Reader, prompt string) (string, error) {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err == nil {
+		defer restore()
+		return editor.readLine(prompt)
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
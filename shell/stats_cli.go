@@ -0,0 +1,172 @@
+package shell
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+)
+
+// documentStats are the statistics of a document as output by stats
+type documentStats struct {
+	Document string `json:"document"`
+	*rmconvert.DocumentStats
+}
+
+func statsCommand(ctx *Context) Command {
+	return Command{
+		Name:  "stats",
+		Help:  "report pages, strokes, pen usage and ink coverage of documents",
+		Usage: "[options] <remote file or dir>",
+		Examples: []string{
+			"rmapi stats /Notes",
+			"rmapi stats -format csv -o stats.csv /",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "stats")
+			format := flagSet.String("format", "text", "output format: text, json or csv")
+			output := flagSet.String("o", "", "write to this file instead of stdout")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file or dir")
+			}
+			srcName := argRest[0]
+
+			var write func(io.Writer, []documentStats) error
+			switch *format {
+			case "text":
+				write = writeStatsText
+			case "json":
+				write = writeStatsJSON
+			case "csv":
+				write = writeStatsCSV
+			default:
+				return fmt.Errorf("unknown format: %s", *format)
+			}
+
+			var all []documentStats
+			summary := &batchSummary{}
+
+			if isLocalRmdoc(srcName) {
+				stats, err := rmconvert.AnalyzeRmdoc(srcName)
+				if err != nil {
+					return err
+				}
+				all = append(all, documentStats{srcName, stats})
+			} else {
+				node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+				if err != nil {
+					return err
+				}
+				tmpDir, err := os.MkdirTemp("", "rmstats")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(tmpDir)
+
+				err = walkDocuments(ctx, node, filetree.WalkOptions{}, func(currentNode *model.Node, remotePath string, _ []string) error {
+					stats, err := remoteStats(ctx, currentNode, tmpDir)
+					if err != nil {
+						log.Error.Printf("failed to analyze %s: %v", remotePath, err)
+						summary.failed(remotePath, "stats", err)
+						return nil
+					}
+					summary.succeeded()
+					all = append(all, documentStats{remotePath, stats})
+					return nil
+				})
+				if err != nil {
+					return fmt.Errorf("interrupted: %v", err)
+				}
+			}
+
+			out := io.Writer(os.Stdout)
+			if *output != "" {
+				f, err := os.Create(*output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+
+			if err := write(out, all); err != nil {
+				return err
+			}
+
+			if len(summary.failures) > 0 {
+				summary.print(os.Stderr)
+			}
+			return summary.err()
+		},
+	}
+}
+
+// remoteStats downloads a document into tmpDir and analyzes it
+func remoteStats(ctx *Context, node *model.Node, tmpDir string) (*rmconvert.DocumentStats, error) {
+	rmdocPath, err := fetchTemp(ctx, node, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(rmdocPath)
+	return rmconvert.AnalyzeRmdoc(rmdocPath)
+}
+
+func writeStatsText(w io.Writer, all []documentStats) error {
+	for _, d := range all {
+		fmt.Fprintf(w, "%s\n", d.Document)
+		fmt.Fprintf(w, "  pages: %d, strokes: %d, path length: %.0f mm, ink coverage: %.1f%%\n",
+			d.Pages, d.Strokes, d.PathLengthMM, d.InkCoverage)
+		for _, t := range d.Tools {
+			fmt.Fprintf(w, "  %-12s %-6s %6d strokes %8.0f mm\n", t.Tool, t.Color, t.Strokes, t.PathLengthMM)
+		}
+	}
+	return nil
+}
+
+func writeStatsJSON(w io.Writer, all []documentStats) error {
+	if all == nil {
+		all = []documentStats{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(all)
+}
+
+// writeStatsCSV writes a row per page, the tools column lists the strokes
+// per tool/color (e.g. "ballpoint/black:12;marker/gray:3")
+func writeStatsCSV(w io.Writer, all []documentStats) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"document", "page", "strokes", "path_length_mm", "ink_coverage", "tools"})
+	for _, d := range all {
+		for _, p := range d.PerPage {
+			tools := make([]string, 0, len(p.Tools))
+			for _, t := range p.Tools {
+				tools = append(tools, fmt.Sprintf("%s/%s:%d", t.Tool, t.Color, t.Strokes))
+			}
+			cw.Write([]string{
+				d.Document,
+				fmt.Sprint(p.Page),
+				fmt.Sprint(p.Strokes),
+				fmt.Sprintf("%.1f", p.PathLengthMM),
+				fmt.Sprintf("%.2f", p.InkCoverage),
+				strings.Join(tools, ";"),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
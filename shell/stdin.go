@@ -0,0 +1,63 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/juruen/rmapi/filetree"
+)
+
+// stdinArg is the argument replaced by the lines of stdin
+const stdinArg = "-"
+
+// stdinInput is read for the stdinArg arguments, replaced by tests
+var stdinInput io.Reader = os.Stdin
+
+// expandStdinArgs replaces a "-" argument by the paths read from stdin, one
+// per line, so that commands take the output of another command, e.g.
+// rmapi find -newer 7d . | rmapi get -. Lines that are a bare document ID
+// are read as uuid:<id>.
+func expandStdinArgs(args []string) ([]string, error) {
+	var result []string
+	read := false
+	for _, arg := range args {
+		if arg != stdinArg {
+			result = append(result, arg)
+			continue
+		}
+		if read {
+			return nil, errors.New("stdin can only be read once")
+		}
+		read = true
+
+		scanner := bufio.NewScanner(stdinInput)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if _, err := uuid.Parse(line); err == nil {
+				line = filetree.UUIDPrefix + line
+			}
+			result = append(result, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// hasStdinArg tells whether the arguments read stdin
+func hasStdinArg(args []string) bool {
+	for _, arg := range args {
+		if arg == stdinArg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package shell
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandStdinArgs(t *testing.T) {
+	defer func(r io.Reader) { stdinInput = r }(stdinInput)
+	stdinInput = strings.NewReader("/Books/My Book\n\n  a1b2c3d4-0000-4000-8000-000000000001 \n")
+
+	args, err := expandStdinArgs([]string{"-", "/Notes"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/Books/My Book", "uuid:a1b2c3d4-0000-4000-8000-000000000001", "/Notes"}, args)
+
+	_, err = expandStdinArgs([]string{"-", "-"})
+	assert.Error(t, err)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	since, err := parseSince("7d", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -7), since)
+
+	since, err = parseSince("2w", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -14), since)
+
+	since, err = parseSince("90m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-90*time.Minute), since)
+
+	since, err = parseSince("2024-01-31", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local), since)
+
+	_, err = parseSince("yesterday", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/juruen/rmapi/device"
+	"github.com/juruen/rmapi/util"
+)
+
+// templatesCommand manages the page templates of the tablet over SSH. It
+// needs no cloud access, see RunTemplates.
+func templatesCommand() Command {
+	return Command{
+		Name:  "templates",
+		Help:  "list, download and upload the page templates of the tablet over SSH",
+		Usage: "[options] ls\n[options] get\n[options] put <template.png> [template.svg]",
+		Examples: []string{
+			"rmapi templates ls",
+			"rmapi templates get",
+			"rmapi templates -name \"Dot grid 5mm\" -category Grids -restart put dots.png",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "templates")
+			host := flagSet.String("host", device.DefaultHost, "user and address of the tablet, on the USB network by default")
+			identity := flagSet.String("i", "", "ssh key file")
+			output := flagSet.String("o", "", "get: folder of the templates (default: the template library, see RMAPI_TEMPLATES)")
+			name := flagSet.String("name", "", "put: name shown by the tablet (default: the file name)")
+			categories := flagSet.String("category", "Perso", "put: comma separated categories of the template")
+			landscape := flagSet.Bool("landscape", false, "put: the template is for landscape pages")
+			restart := flagSet.Bool("restart", false, "put: restart the interface of the tablet to show the template")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing action: ls, get or put")
+			}
+
+			dev := &device.Device{Host: *host}
+			if *identity != "" {
+				dev.Options = []string{"-i", *identity}
+			}
+
+			switch argRest[0] {
+			case "ls":
+				idx, err := dev.TemplateIndex(ctx.goCtx)
+				if err != nil {
+					return err
+				}
+				templates, err := idx.Templates()
+				if err != nil {
+					return err
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				for _, t := range templates {
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Filename, t.Name, strings.Join(t.Categories, ", "))
+				}
+				return tw.Flush()
+
+			case "get":
+				dir := *output
+				if dir == "" {
+					var err error
+					if dir, err = device.LibraryDir(); err != nil {
+						return err
+					}
+				}
+				files, err := dev.DownloadTemplates(ctx.goCtx, dir)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("downloaded %d files to %s\n", len(files), dir)
+				return nil
+
+			case "put":
+				files := argRest[1:]
+				if len(files) == 0 {
+					return errors.New("missing template file")
+				}
+				for _, file := range files {
+					if ext := strings.ToLower(filepath.Ext(file)); ext != ".png" && ext != ".svg" {
+						return fmt.Errorf("%s: templates are .png or .svg files", file)
+					}
+				}
+				filename := strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0]))
+				t := device.Template{Name: *name, Filename: filename, Landscape: *landscape}
+				if t.Name == "" {
+					t.Name = filename
+				}
+				for _, category := range strings.Split(*categories, ",") {
+					if category = strings.TrimSpace(category); category != "" {
+						t.Categories = append(t.Categories, category)
+					}
+				}
+
+				if err := dev.UploadTemplate(ctx.goCtx, files, t); err != nil {
+					return err
+				}
+				// kept in the library to render the pages that use it
+				if err := copyToLibrary(files); err != nil {
+					return err
+				}
+				if *restart {
+					return dev.Restart(ctx.goCtx)
+				}
+				fmt.Println("template uploaded, restart the tablet or pass -restart to show it")
+				return nil
+
+			default:
+				return fmt.Errorf("unknown action %s, use ls, get or put", argRest[0])
+			}
+		},
+	}
+}
+
+// templateLibrary returns the template library folder if it exists, empty
+// otherwise
+func templateLibrary() string {
+	dir, err := device.LibraryDir()
+	if err != nil {
+		return ""
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+// copyToLibrary copies template files to the template library
+func copyToLibrary(files []string) error {
+	dir, err := device.LibraryDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := util.CopyFile(file, filepath.Join(dir, filepath.Base(file))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunTemplates runs the templates command with its arguments, without the
+// cloud
+func RunTemplates(goCtx context.Context, args []string) error {
+	ctx := &Context{goCtx: goCtx, commands: map[string]Command{}}
+	registerCommand(ctx.commands, templatesCommand())
+	return runCommand(ctx, ctx.commands, append([]string{"templates"}, args...))
+}
@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+	"github.com/juruen/rmapi/util"
+)
+
+func thumbsCommand(ctx *Context) Command {
+	return Command{
+		Name:  "thumbs",
+		Help:  "write a thumbnail of each document ({name}.thumb.png)",
+		Usage: "[options] <remote file or dir>",
+		Examples: []string{
+			"rmapi thumbs -i -o thumbs /",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "thumbs")
+			outputDir := flagSet.String("o", ".", "output directory")
+			dpi := flagSet.Int("dpi", rmconvert.ThumbnailDPI, "render DPI, when the document has no stored thumbnail")
+			incremental := flagSet.Bool("i", false, "incremental mode (only update thumbnails of modified documents)")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
+			argRest := flagSet.Args()
+			if len(argRest) == 0 {
+				return errors.New("missing source file or dir")
+			}
+			srcName := argRest[0]
+
+			node, err := ctx.api.Filetree().NodeByPath(srcName, ctx.node)
+			if err != nil {
+				return err
+			}
+
+			target := path.Clean(*outputDir)
+
+			tmpDir, err := os.MkdirTemp("", "rmthumbs")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpDir)
+
+			summary := &batchSummary{}
+			namer := filetree.DefaultLocalNamer.Cached()
+
+			walkFn := func(currentNode *model.Node, _ string, currentPath []string) error {
+				idxDir := 0
+				if srcName == "." && len(currentPath) > 0 {
+					idxDir = 1
+				}
+				thumbName := fmt.Sprintf("%s.thumb.png", namer.Name(currentNode))
+				thumbPath := path.Join(target, filetree.BuildPath(namer.Dir(currentNode, len(currentPath))[idxDir:], thumbName))
+
+				lastModified, err := currentNode.LastModified()
+				if *incremental && err == nil {
+					if stat, err := os.Stat(thumbPath); err == nil && !lastModified.After(stat.ModTime()) {
+						return nil
+					}
+				}
+
+				fmt.Printf("thumbnail [%s]...", thumbPath)
+				if err := writeThumbnail(ctx, currentNode, tmpDir, thumbPath, *dpi); err != nil {
+					fmt.Println(" FAILED")
+					log.Error.Printf("failed to write thumbnail %s: %v", thumbPath, err)
+					summary.failed(thumbPath, "thumbnail", err)
+					return nil
+				}
+				fmt.Println(" OK")
+				summary.succeeded()
+				return nil
+			}
+
+			if err := walkDocuments(ctx, node, filetree.WalkOptions{}, walkFn); err != nil {
+				summary.print(os.Stdout)
+				return fmt.Errorf("interrupted: %v", err)
+			}
+
+			summary.print(os.Stdout)
+			return summary.err()
+		},
+	}
+}
+
+// writeThumbnail downloads the document into tmpDir and writes its thumbnail
+func writeThumbnail(ctx *Context, node *model.Node, tmpDir, thumbPath string, dpi int) error {
+	rmdocPath, err := fetchTemp(ctx, node, tmpDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(rmdocPath)
+
+	return util.WriteFileAtomic(thumbPath, func(w io.Writer) error {
+		return rmconvert.ExtractThumbnail(rmdocPath, w, dpi)
+	})
+}
@@ -0,0 +1,100 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/juruen/rmapi/api"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/rmconvert"
+)
+
+func verifyCommand(ctx *Context) Command {
+	return Command{
+		Name:  "verify",
+		Help:  "check that the documents in the cloud are complete, match their hashes and parse",
+		Usage: "[options] [path]",
+		Examples: []string{
+			"rmapi verify",
+			"rmapi verify -quick /Work",
+		},
+		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "verify")
+			quick := flagSet.Bool("quick", false, "only check that the files of the documents are stored, without downloading them")
+
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+			argRest := flagSet.Args()
+			if len(argRest) > 1 {
+				return errors.New("too many arguments")
+			}
+
+			node := ctx.api.Filetree().Root()
+			if len(argRest) == 1 {
+				var err error
+				if node, err = ctx.api.Filetree().NodeByPath(argRest[0], ctx.node); err != nil {
+					return err
+				}
+			}
+			summary := &batchSummary{}
+			walkFn := func(currentNode *model.Node, remotePath string, _ []string) error {
+				fmt.Printf("checking [%s]...", remotePath)
+				stage, err := verifyDocument(ctx, currentNode.Id(), *quick)
+				if err != nil {
+					fmt.Println(" FAILED")
+					summary.failed(remotePath, stage, err)
+					return nil
+				}
+				fmt.Println(" OK")
+				summary.succeeded()
+				return nil
+			}
+
+			if err := walkDocuments(ctx, node, filetree.WalkOptions{}, walkFn); err != nil {
+				summary.print(os.Stdout)
+				return fmt.Errorf("interrupted: %v", err)
+			}
+			summary.print(os.Stdout)
+			return summary.err()
+		},
+	}
+}
+
+// verifyDocument checks a document in the cloud, quickly its files only,
+// and returns the stage that failed: integrity for the files missing or
+// not matching their hash, parse for the pages and files that can't be read
+func verifyDocument(ctx *Context, id string, quick bool) (string, error) {
+	if quick {
+		return "integrity", ctx.api.CheckDocument(ctx.goCtx, id, nil)
+	}
+
+	f, err := os.CreateTemp("", "rmverify")
+	if err != nil {
+		return "integrity", err
+	}
+	defer os.Remove(f.Name())
+	err = ctx.api.CheckDocument(ctx.goCtx, id, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		var integrity *api.IntegrityError
+		if errors.As(err, &integrity) {
+			return "integrity", fmt.Errorf("%s doesn't match its hash", integrity.File)
+		}
+		return "integrity", err
+	}
+
+	doc, err := rmconvert.OpenRmDoc(f.Name())
+	if err != nil {
+		return "parse", err
+	}
+	defer doc.Close()
+	if problems := doc.Validate(); len(problems) > 0 {
+		return "parse", errors.Join(problems...)
+	}
+	return "", nil
+}
@@ -11,6 +11,11 @@ func versionCommand(ctx *Context) Command {
 		Name: "version",
 		Help: "show rmapi version",
 		Func: func(ctx *Context, args []string) error {
+			flagSet := newFlagSet(ctx, "version")
+			if err := flagSet.Parse(args); err != nil {
+				return err
+			}
+
 			fmt.Println("rmapi version:", version.Version)
 			return nil
 		},
@@ -0,0 +1,61 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/juruen/rmapi/util"
+)
+
+// walkFunc is called by walkEntries with an entry, its remote path and
+// the path of Walk
+type walkFunc func(entry *model.Node, remotePath string, walkPath []string) error
+
+// walkEntries walks the entries under node for the commands processing a
+// folder: the trash is left out unless it is node, and the walk stops with
+// the error of the context when the command is interrupted
+func walkEntries(ctx *Context, node *model.Node, opts filetree.WalkOptions, fn walkFunc) error {
+	remoteRoot, err := ctx.api.Filetree().NodeToPath(node)
+	if err != nil {
+		return err
+	}
+	return filetree.Walk(node, opts, func(entry *model.Node, walkPath []string) error {
+		if err := ctx.goCtx.Err(); err != nil {
+			return err
+		}
+		if entry.Id() == filetree.TrashID && entry != node {
+			return filetree.SkipDir
+		}
+
+		remotePath := remoteRoot
+		if entry != node {
+			remotePath = path.Join(remoteRoot, path.Join(walkPath[1:]...), entry.DisplayName())
+		}
+		return fn(entry, remotePath, walkPath)
+	})
+}
+
+// walkDocuments is walkEntries for the documents only
+func walkDocuments(ctx *Context, node *model.Node, opts filetree.WalkOptions, fn walkFunc) error {
+	return walkEntries(ctx, node, opts, func(entry *model.Node, remotePath string, walkPath []string) error {
+		if entry.IsDirectory() {
+			return nil
+		}
+		return fn(entry, remotePath, walkPath)
+	})
+}
+
+// fetchTemp downloads a document into tmpDir and returns the path of the
+// .rmdoc file, to be removed by the caller
+func fetchTemp(ctx *Context, node *model.Node, tmpDir string) (string, error) {
+	rmdocPath := filepath.Join(tmpDir, node.Id()+"."+util.RMDOC)
+	if err := ctx.api.FetchDocument(ctx.goCtx, node.Id(), rmdocPath); err != nil {
+		os.Remove(rmdocPath)
+		return "", fmt.Errorf("download failed: %v", err)
+	}
+	return rmdocPath, nil
+}
@@ -0,0 +1,40 @@
+package shell
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkDocuments(t *testing.T) {
+	tree := testTree()
+	ctx := testContext(t, tree)
+
+	walk := func(node *model.Node, opts filetree.WalkOptions) []string {
+		var paths []string
+		err := walkDocuments(ctx, node, opts, func(doc *model.Node, remotePath string, _ []string) error {
+			paths = append(paths, remotePath)
+			return nil
+		})
+		assert.NoError(t, err)
+		return paths
+	}
+
+	// the trash is left out, unless it is walked
+	assert.Equal(t, []string{"/Todo", "/Work/Notes", "/Work/Old/Paper"}, walk(tree.Root(), filetree.WalkOptions{}))
+	assert.Equal(t, []string{"/trash/Trashed"}, walk(tree.NodeById(filetree.TrashID), filetree.WalkOptions{}))
+	assert.Equal(t, []string{"/Work/Notes"}, walk(tree.NodeById("work"), filetree.WalkOptions{MaxDepth: 1}))
+	assert.Equal(t, []string{"/Work/Notes"}, walk(tree.NodeById("notes"), filetree.WalkOptions{}))
+
+	goCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.goCtx = goCtx
+	err := walkDocuments(ctx, tree.Root(), filetree.WalkOptions{}, func(*model.Node, string, []string) error {
+		t.Error("walked after the interruption")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
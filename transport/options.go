@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Options tune the http client used for the cloud, zero values use the
+// defaults
+type Options struct {
+	// Timeout bounds a whole request, including reading the body of a
+	// download, 5 minutes by default, negative for no limit
+	Timeout time.Duration
+	// DialTimeout bounds the connection to the server, 30s by default
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout is 10s by default
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the response once the
+	// request is sent, no limit by default
+	ResponseHeaderTimeout time.Duration
+	// KeepAlive is the period of the TCP keep-alives, 30s by default,
+	// negative to disable them
+	KeepAlive time.Duration
+	// IdleConnTimeout closes the connections idle for longer, 90s by
+	// default
+	IdleConnTimeout time.Duration
+	// MaxIdleConns is the size of the connection pool, 100 by default
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is MaxConcurrent by default so that concurrent
+	// transfers reuse their connections
+	MaxIdleConnsPerHost int
+	// DisableKeepAlives opens a connection per request
+	DisableKeepAlives bool
+	// Proxy is the proxy of every request, by default the one of the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+	Proxy *url.URL
+}
+
+// DefaultOptions are the options of CreateHttpClientCtx, the command line
+// flags change them
+var DefaultOptions Options
+
+// NewHTTPClient returns an http client with its own connection pool
+func NewHTTPClient(opts Options) *http.Client {
+	opts = opts.withDefaults()
+
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+	}
+
+	timeout := opts.Timeout
+	if timeout < 0 {
+		timeout = 0
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+func (opts Options) withDefaults() Options {
+	setDuration := func(d *time.Duration, value time.Duration) {
+		if *d == 0 {
+			*d = value
+		}
+	}
+	setDuration(&opts.Timeout, 5*time.Minute)
+	setDuration(&opts.DialTimeout, 30*time.Second)
+	setDuration(&opts.TLSHandshakeTimeout, 10*time.Second)
+	setDuration(&opts.KeepAlive, 30*time.Second)
+	setDuration(&opts.IdleConnTimeout, 90*time.Second)
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = 100
+	}
+	if opts.MaxIdleConnsPerHost == 0 {
+		opts.MaxIdleConnsPerHost = MaxConcurrent
+	}
+	return opts
+}
@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client := NewHTTPClient(Options{})
+	tr := client.Transport.(*http.Transport)
+	if client.Timeout != 5*time.Minute || tr.MaxIdleConnsPerHost != MaxConcurrent || tr.TLSHandshakeTimeout != 10*time.Second {
+		t.Errorf("defaults not applied: %v %+v", client.Timeout, tr)
+	}
+
+	proxy, _ := url.Parse("http://proxy.example.com:3128")
+	client = NewHTTPClient(Options{Timeout: -1, MaxIdleConnsPerHost: 4, Proxy: proxy})
+	tr = client.Transport.(*http.Transport)
+	if client.Timeout != 0 || tr.MaxIdleConnsPerHost != 4 {
+		t.Errorf("options not applied: %v %+v", client.Timeout, tr)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://internal.cloud.remarkable.com/sync", nil)
+	if u, err := tr.Proxy(req); err != nil || u.String() != proxy.String() {
+		t.Errorf("unexpected proxy %v %v", u, err)
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	// nothing listens on a closed port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = http.Get("http://" + addr)
+	if !IsUnreachable(err) {
+		t.Errorf("connection refused not unreachable: %v", err)
+	}
+	if IsUnreachable(ErrUnauthorized) || IsUnreachable(nil) {
+		t.Error("http errors are not unreachable")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr, nil)
+	if _, err := http.DefaultClient.Do(req); IsUnreachable(err) {
+		t.Errorf("interrupted request unreachable: %v", err)
+	}
+}
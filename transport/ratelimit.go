@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/metrics"
+)
+
+// MaxConcurrent is the max number of requests in flight, set by
+// RMAPI_CONCURRENT
+var MaxConcurrent = 20
+
+// MaxRetries is the number of times a request throttled (429) or failed
+// with a 5xx status is retried, set by RMAPI_MAX_RETRIES
+var MaxRetries = 5
+
+// retry delays, doubled on each attempt up to maxBackoff
+var (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+var requestLimiter *limiter
+
+func init() {
+	if u, err := strconv.Atoi(os.Getenv("RMAPI_CONCURRENT")); err == nil && u > 0 {
+		MaxConcurrent = u
+	}
+	if u, err := strconv.Atoi(os.Getenv("RMAPI_MAX_RETRIES")); err == nil && u >= 0 {
+		MaxRetries = u
+	}
+	rps, _ := strconv.ParseFloat(os.Getenv("RMAPI_RPS"), 64)
+	SetRateLimit(rps, MaxConcurrent)
+}
+
+// SetRateLimit paces the requests to the cloud to rps requests per second,
+// 0 for no limit, with at most concurrent requests in flight
+func SetRateLimit(rps float64, concurrent int) {
+	requestLimiter = newLimiter(rps, concurrent)
+}
+
+// limiter spaces the start of the requests and bounds the requests in
+// flight
+type limiter struct {
+	interval time.Duration
+	slots    chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newLimiter(rps float64, concurrent int) *limiter {
+	l := &limiter{}
+	if rps > 0 {
+		l.interval = time.Duration(float64(time.Second) / rps)
+	}
+	if concurrent > 0 {
+		l.slots = make(chan struct{}, concurrent)
+	}
+	return l
+}
+
+// acquire waits for a slot and the turn of the request, release must be
+// called once the response body is closed, see releaseOnClose
+func (l *limiter) acquire(goCtx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-goCtx.Done():
+			return goCtx.Err()
+		}
+	}
+
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if err := sleep(goCtx, start.Sub(now)); err != nil {
+		l.release()
+		return err
+	}
+	return nil
+}
+
+func (l *limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// releaseOnClose returns body releasing the slot of its request once it is
+// closed or read to the end, the connection is busy until then
+func (l *limiter) releaseOnClose(body io.ReadCloser) io.ReadCloser {
+	return &slotBody{ReadCloser: body, release: l.release}
+}
+
+// slotBody is a response body holding the slot of its request
+type slotBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *slotBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// replayableBody returns the body of a request reading r from its current
+// offset and a GetBody for the request that reads it again from there, so
+// that the request can be retried. The transport may still be sending the
+// previous body when the response is received, a new one waits for it to
+// be closed.
+func replayableBody(r io.ReadSeeker) (io.ReadCloser, func() (io.ReadCloser, error), error) {
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mu sync.Mutex
+	current := newSeekBody(r)
+	getBody := func() (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		<-current.closed
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		current = newSeekBody(r)
+		return current, nil
+	}
+	return current, getBody, nil
+}
+
+// seekBody is a request body over a shared reader, which is left open
+type seekBody struct {
+	io.Reader
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newSeekBody(r io.Reader) *seekBody {
+	return &seekBody{Reader: r, closed: make(chan struct{})}
+}
+
+func (b *seekBody) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
+
+// sleep waits for d or until goCtx is cancelled
+func sleep(goCtx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-goCtx.Done():
+		return goCtx.Err()
+	}
+}
+
+// shouldRetry tells if a response is throttling or a server error
+func shouldRetry(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryDelay returns the delay before an attempt (from 0), the
+// Retry-After header of the response if any or an exponential backoff
+// with jitter
+func retryDelay(attempt int, response *http.Response) time.Duration {
+	if after := response.Header.Get("Retry-After"); after != "" {
+		if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, maxBackoff)
+		}
+		if date, err := http.ParseTime(after); err == nil {
+			return min(max(time.Until(date), 0), maxBackoff)
+		}
+	}
+
+	delay := minBackoff << attempt
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	// between half and the full delay, so that parallel requests don't
+	// retry together
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do sends a request paced by the limiter. Throttled requests and server
+// errors are retried with a backoff when the body can be sent again.
+func (ctx HttpClientCtx) do(request *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := requestLimiter.acquire(request.Context()); err != nil {
+			return nil, err
+		}
+		if request.Body != nil && request.Body != http.NoBody {
+			request.Body = &countingReadCloser{request.Body, metrics.BytesTransferred.WithLabel("upload")}
+		}
+		response, err := ctx.Client.Do(request)
+		if err != nil {
+			requestLimiter.release()
+			return nil, err
+		}
+		response.Body = requestLimiter.releaseOnClose(response.Body)
+
+		replayable := request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+		if !shouldRetry(response.StatusCode) || attempt >= MaxRetries || !replayable {
+			return response, nil
+		}
+
+		delay := retryDelay(attempt, response)
+		log.Warning.Printf("request failed with status %d, retrying in %v", response.StatusCode, delay.Round(time.Millisecond))
+		io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		response.Body.Close()
+
+		if err := sleep(request.Context(), delay); err != nil {
+			return nil, err
+		}
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestRetries(t *testing.T) {
+	minBackoff = time.Millisecond
+	defer func() { minBackoff = time.Second }()
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch len(bodies) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	ctx := HttpClientCtx{Client: server.Client()}
+	response, err := ctx.RequestContext(context.Background(), EmptyBearer, http.MethodPut, server.URL, strings.NewReader("blob"), nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if strings.Join(bodies, ",") != "blob,blob,blob" {
+		t.Errorf("unexpected requests %q", bodies)
+	}
+
+	// client errors are not retried
+	bodies = nil
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bodies = append(bodies, "")
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	response, err = ctx.RequestContext(context.Background(), EmptyBearer, http.MethodGet, server.URL, nil, nil, 0)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	response.Body.Close()
+	if len(bodies) != 1 {
+		t.Errorf("expected 1 request, got %d", len(bodies))
+	}
+}
+
+func TestRequestRetriesSeeker(t *testing.T) {
+	minBackoff = time.Millisecond
+	defer func() { minBackoff = time.Second }()
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// a reader net/http can't send again by itself, as the progress
+	// reader of the uploads, read from its current offset
+	body := struct{ io.ReadSeeker }{strings.NewReader("skip:blob")}
+	body.Seek(5, io.SeekStart)
+
+	ctx := HttpClientCtx{Client: server.Client()}
+	response, err := ctx.RequestContext(context.Background(), EmptyBearer, http.MethodPut, server.URL, body, nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if strings.Join(bodies, ",") != "blob,blob" {
+		t.Errorf("unexpected requests %q", bodies)
+	}
+}
+
+func TestSlotReleasedOnClose(t *testing.T) {
+	SetRateLimit(0, 1)
+	defer SetRateLimit(0, MaxConcurrent)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "blob")
+	}))
+	defer server.Close()
+
+	ctx := HttpClientCtx{Client: server.Client()}
+	body, err := ctx.GetStreamContext(context.Background(), EmptyBearer, server.URL, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the slot is held while the body is read
+	goCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := requestLimiter.acquire(goCtx); err == nil {
+		t.Fatalf("slot released before the body was closed")
+	}
+
+	body.Close()
+	goCtx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := requestLimiter.acquire(goCtx); err != nil {
+		t.Fatalf("slot not released by the body: %v", err)
+	}
+	requestLimiter.release()
+}
+
+func TestLimiterPacing(t *testing.T) {
+	l := newLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		l.release()
+	}
+	// the first request starts right away, the next ones every 10ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("requests not paced: %v", elapsed)
+	}
+
+	l.acquire(context.Background())
+	goCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(goCtx); err == nil {
+		t.Errorf("expected the context error while the slot is taken")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	response := &http.Response{Header: http.Header{"Retry-After": []string{"7"}}}
+	if d := retryDelay(0, response); d != 7*time.Second {
+		t.Errorf("Retry-After ignored: %v", d)
+	}
+	response.Header = http.Header{}
+	for attempt := 0; attempt < 10; attempt++ {
+		d := retryDelay(attempt, response)
+		want := minBackoff << attempt
+		if want > maxBackoff {
+			want = maxBackoff
+		}
+		if d < want/2 || d > want {
+			t.Errorf("attempt %d: delay %v not in [%v, %v]", attempt, d, want/2, want)
+		}
+	}
+}
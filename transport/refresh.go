@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/model"
+)
+
+// refreshMargin is how long before its expiry a user token is renewed
+var refreshMargin = 5 * time.Minute
+
+// TokenRefresher renews the user token when it is about to expire or is
+// rejected, so that long sessions outlive it. Concurrent requests share a
+// single renewal. The copies of an HttpClientCtx share their refresher.
+type TokenRefresher struct {
+	// Renew returns a new user token from the device token
+	Renew func(tokens model.AuthTokens) (string, error)
+	// OnRefresh is called with the renewed tokens, e.g. to save them
+	OnRefresh func(tokens model.AuthTokens)
+
+	mu     sync.Mutex
+	tokens model.AuthTokens
+	expiry time.Time
+
+	// refreshMu serializes the renewals
+	refreshMu sync.Mutex
+}
+
+// NewTokenRefresher returns a refresher of the tokens
+func NewTokenRefresher(tokens model.AuthTokens, renew func(model.AuthTokens) (string, error)) *TokenRefresher {
+	return &TokenRefresher{Renew: renew, tokens: tokens, expiry: tokenExpiry(tokens.UserToken)}
+}
+
+// Tokens returns the current tokens
+func (r *TokenRefresher) Tokens() model.AuthTokens {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens
+}
+
+// userToken returns the user token, renewed first if it expires soon. A
+// failed renewal is logged and the current token is returned.
+func (r *TokenRefresher) userToken() string {
+	r.mu.Lock()
+	token, expiry := r.tokens.UserToken, r.expiry
+	r.mu.Unlock()
+
+	if expiry.IsZero() || time.Until(expiry) > refreshMargin {
+		return token
+	}
+	renewed, err := r.Refresh(token)
+	if err != nil {
+		log.Warning.Printf("failed to renew the user token: %v", err)
+		return token
+	}
+	return renewed
+}
+
+// Refresh renews the user token unless it changed since stale was read,
+// in which case another request renewed it already
+func (r *TokenRefresher) Refresh(stale string) (string, error) {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+
+	tokens := r.Tokens()
+	if tokens.UserToken != stale {
+		return tokens.UserToken, nil
+	}
+
+	log.Info.Println("renewing the user token")
+	token, err := r.Renew(tokens)
+	if err != nil {
+		return "", err
+	}
+	tokens.UserToken = token
+
+	r.mu.Lock()
+	r.tokens = tokens
+	r.expiry = tokenExpiry(token)
+	r.mu.Unlock()
+
+	if r.OnRefresh != nil {
+		r.OnRefresh(tokens)
+	}
+	return token, nil
+}
+
+// tokenExpiry returns the expiry of a JWT, zero if unknown
+func tokenExpiry(token string) time.Time {
+	claims := jwt.StandardClaims{}
+	if _, _, err := (&jwt.Parser{}).ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
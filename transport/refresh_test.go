@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/juruen/rmapi/model"
+)
+
+func TestRefreshUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	var renewals int32
+	var saved model.AuthTokens
+	refresher := NewTokenRefresher(model.AuthTokens{DeviceToken: "device", UserToken: "old"}, func(tokens model.AuthTokens) (string, error) {
+		atomic.AddInt32(&renewals, 1)
+		if tokens.DeviceToken != "device" {
+			t.Errorf("unexpected device token %q", tokens.DeviceToken)
+		}
+		time.Sleep(10 * time.Millisecond)
+		return "new", nil
+	})
+	refresher.OnRefresh = func(tokens model.AuthTokens) { saved = tokens }
+	ctx := HttpClientCtx{Client: server.Client(), Refresher: refresher}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := ctx.RequestContext(context.Background(), UserBearer, http.MethodPut, server.URL, strings.NewReader("body"), nil, 4)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if renewals != 1 {
+		t.Errorf("expected a single renewal, got %d", renewals)
+	}
+	if saved.UserToken != "new" || refresher.Tokens().UserToken != "new" {
+		t.Errorf("renewed token not saved: %+v", saved)
+	}
+}
+
+func TestRefreshBeforeExpiry(t *testing.T) {
+	expiring, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresher := NewTokenRefresher(model.AuthTokens{UserToken: expiring}, func(model.AuthTokens) (string, error) {
+		return "renewed", nil
+	})
+	if token := refresher.userToken(); token != "renewed" {
+		t.Errorf("token expiring in a minute not renewed: %q", token)
+	}
+	// without a known expiry, the token is renewed only when rejected
+	if token := refresher.userToken(); token != "renewed" {
+		t.Errorf("unexpected token %q", token)
+	}
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -8,12 +9,14 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/juruen/rmapi/log"
+	"github.com/juruen/rmapi/metrics"
 	"github.com/juruen/rmapi/model"
 	"github.com/juruen/rmapi/util"
 )
@@ -24,10 +27,12 @@ type BodyString struct {
 	Content string
 }
 
-var ErrUnauthorized = errors.New("401 Unauthorized")
-var ErrConflict = errors.New("409 Conflict")
-var ErrWrongGeneration = errors.New("412 wrong generation")
-var ErrNotFound = errors.New("not found")
+// The errors of the requests wrap the error classes of model, e.g.
+// errors.Is(ErrUnauthorized, model.ErrAuth)
+var ErrUnauthorized = fmt.Errorf("401 Unauthorized: %w", model.ErrAuth)
+var ErrConflict = fmt.Errorf("409 Conflict: %w", model.ErrConflict)
+var ErrWrongGeneration = fmt.Errorf("412 wrong generation: %w", model.ErrConflict)
+var ErrNotFound = model.ErrNotFound
 
 var RmapiUserAGent = "rmapi"
 
@@ -44,16 +49,19 @@ const (
 type HttpClientCtx struct {
 	Client *http.Client
 	Tokens model.AuthTokens
+	// Refresher renews the user token, its token is used instead of
+	// Tokens.UserToken when set
+	Refresher *TokenRefresher
 }
 
 func CreateHttpClientCtx(tokens model.AuthTokens) HttpClientCtx {
-	var httpClient = &http.Client{Timeout: 5 * 60 * time.Second}
-
-	return HttpClientCtx{httpClient, tokens}
+	return HttpClientCtx{Client: NewHTTPClient(DefaultOptions), Tokens: tokens}
 }
 
-func (ctx HttpClientCtx) addAuthorization(req *http.Request, authType AuthType) {
-	var header string
+// addAuthorization sets the Authorization header and returns the user
+// token used, if any
+func (ctx HttpClientCtx) addAuthorization(req *http.Request, authType AuthType) string {
+	var header, userToken string
 
 	switch authType {
 	case EmptyBearer:
@@ -61,10 +69,15 @@ func (ctx HttpClientCtx) addAuthorization(req *http.Request, authType AuthType)
 	case DeviceBearer:
 		header = fmt.Sprintf("Bearer %s", ctx.Tokens.DeviceToken)
 	case UserBearer:
-		header = fmt.Sprintf("Bearer %s", ctx.Tokens.UserToken)
+		userToken = ctx.Tokens.UserToken
+		if ctx.Refresher != nil {
+			userToken = ctx.Refresher.userToken()
+		}
+		header = fmt.Sprintf("Bearer %s", userToken)
 	}
 
 	req.Header.Add("Authorization", header)
+	return userToken
 }
 
 func (ctx HttpClientCtx) Get(authType AuthType, url string, body interface{}, target interface{}) error {
@@ -91,33 +104,46 @@ func (ctx HttpClientCtx) Get(authType AuthType, url string, body interface{}, ta
 const RmFileNameHeader = "rm-filename"
 
 func (ctx HttpClientCtx) GetStream(authType AuthType, url string, name string) (io.ReadCloser, error) {
+	return ctx.GetStreamContext(context.Background(), authType, url, name)
+}
+
+// GetStreamContext is like GetStream, the request is aborted when goCtx is cancelled
+func (ctx HttpClientCtx) GetStreamContext(goCtx context.Context, authType AuthType, url string, name string) (io.ReadCloser, error) {
 	headers := map[string]string{
 		RmFileNameHeader: name,
 	}
-	response, err := ctx.Request(authType, http.MethodGet, url, strings.NewReader(""), headers, 0)
+	response, err := ctx.RequestContext(goCtx, authType, http.MethodGet, url, strings.NewReader(""), headers, 0)
 	if err != nil {
+		if response != nil {
+			response.Body.Close()
+		}
 		return nil, err
 	}
 	return response.Body, err
 }
 
 func (ctx HttpClientCtx) Post(authType AuthType, url string, reqBody, resp interface{}) error {
-	return ctx.httpRawReq(authType, http.MethodPost, url, reqBody, resp, nil)
+	return ctx.httpRawReq(context.Background(), authType, http.MethodPost, url, reqBody, resp, nil)
 }
 
 func (ctx HttpClientCtx) Put(authType AuthType, url string, reqBody, resp interface{}, headers map[string]string) error {
-	return ctx.httpRawReq(authType, http.MethodPut, url, reqBody, resp, headers)
+	return ctx.httpRawReq(context.Background(), authType, http.MethodPut, url, reqBody, resp, headers)
 }
 
 func (ctx HttpClientCtx) PutStream(authType AuthType, url string, reqBody io.Reader, name string) error {
+	return ctx.PutStreamContext(context.Background(), authType, url, reqBody, name)
+}
+
+// PutStreamContext is like PutStream, the request is aborted when goCtx is cancelled
+func (ctx HttpClientCtx) PutStreamContext(goCtx context.Context, authType AuthType, url string, reqBody io.Reader, name string) error {
 	headers := map[string]string{
 		RmFileNameHeader: name,
 	}
-	return ctx.httpRawReq(authType, http.MethodPut, url, reqBody, nil, headers)
+	return ctx.httpRawReq(goCtx, authType, http.MethodPut, url, reqBody, nil, headers)
 }
 
 func (ctx HttpClientCtx) Delete(authType AuthType, url string, reqBody, resp interface{}) error {
-	return ctx.httpRawReq(authType, http.MethodDelete, url, reqBody, resp, nil)
+	return ctx.httpRawReq(context.Background(), authType, http.MethodDelete, url, reqBody, resp, nil)
 }
 
 var table = crc32.MakeTable(crc32.Castagnoli)
@@ -143,7 +169,7 @@ func generateCRC32CFromReader(reader io.Reader) (string, error) {
 	return encodedChecksum, nil
 }
 
-func (ctx HttpClientCtx) httpRawReq(authType AuthType, verb, url string, reqBody, resp interface{}, headers map[string]string) error {
+func (ctx HttpClientCtx) httpRawReq(goCtx context.Context, authType AuthType, verb, url string, reqBody, resp interface{}, headers map[string]string) error {
 	var contentBody io.Reader
 
 	if headers == nil {
@@ -183,7 +209,7 @@ func (ctx HttpClientCtx) httpRawReq(authType AuthType, verb, url string, reqBody
 			return fmt.Errorf("cannot seek %v", err)
 		}
 	}
-	response, err := ctx.Request(authType, verb, url, contentBody, headers, length)
+	response, err := ctx.RequestContext(goCtx, authType, verb, url, contentBody, headers, length)
 
 	if response != nil {
 		defer response.Body.Close()
@@ -219,12 +245,24 @@ func (ctx HttpClientCtx) httpRawReq(authType AuthType, verb, url string, reqBody
 }
 
 func (ctx HttpClientCtx) Request(authType AuthType, verb, url string, body io.Reader, headers map[string]string, length int64) (*http.Response, error) {
-	request, err := http.NewRequest(verb, url, body)
+	return ctx.RequestContext(context.Background(), authType, verb, url, body, headers, length)
+}
+
+// RequestContext is like Request, the request is aborted when goCtx is cancelled
+func (ctx HttpClientCtx) RequestContext(goCtx context.Context, authType AuthType, verb, url string, body io.Reader, headers map[string]string, length int64) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(goCtx, verb, url, body)
 	if err != nil {
 		return nil, err
 	}
+	// net/http only sends the bytes and strings readers again, e.g. not
+	// the files wrapped to show the upload progress
+	if seeker, ok := body.(io.ReadSeeker); ok && request.GetBody == nil {
+		if request.Body, request.GetBody, err = replayableBody(seeker); err != nil {
+			return nil, err
+		}
+	}
 
-	ctx.addAuthorization(request, authType)
+	userToken := ctx.addAuthorization(request, authType)
 	request.Header["user-agent"] = []string{RmapiUserAGent}
 
 	if headers != nil {
@@ -247,12 +285,17 @@ func (ctx HttpClientCtx) Request(authType AuthType, verb, url string, body io.Re
 		}
 	}
 
-	response, err := ctx.Client.Do(request)
+	response, err := ctx.do(request)
+	if err == nil && response.StatusCode == http.StatusUnauthorized && authType == UserBearer && ctx.Refresher != nil {
+		response, err = ctx.retryUnauthorized(request, response, userToken)
+	}
 
 	if err != nil {
 		log.Error.Println("http request failed with", err)
+		metrics.APIErrors.WithLabel("0").Inc()
 		return nil, err
 	}
+	response.Body = &countingReadCloser{response.Body, metrics.BytesTransferred.WithLabel("download")}
 
 	if log.TracingEnabled {
 		defer response.Body.Close()
@@ -266,10 +309,13 @@ func (ctx HttpClientCtx) Request(authType AuthType, verb, url string, body io.Re
 	} else {
 		log.Trace.Printf("request failed with status %d\n", response.StatusCode)
 	}
+	metrics.APIErrors.WithLabel(strconv.Itoa(response.StatusCode)).Inc()
 
 	switch response.StatusCode {
 	case http.StatusUnauthorized:
 		return response, ErrUnauthorized
+	case http.StatusNotFound:
+		return response, fmt.Errorf("404 Not Found: %w", ErrNotFound)
 	case http.StatusConflict:
 		return response, ErrConflict
 	case http.StatusPreconditionFailed:
@@ -288,3 +334,55 @@ func IsHTTPStatusOK(status int) bool {
 		return false
 	}
 }
+
+// IsUnreachable tells whether a request failed because the cloud couldn't
+// be reached: no network, name resolution failure, connection refused,
+// reset or timed out. Interrupted requests are not unreachable.
+func IsUnreachable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// countingReadCloser counts the bytes read in a metric
+type countingReadCloser struct {
+	io.ReadCloser
+	counter metrics.LabeledCounter
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+// retryUnauthorized renews the user token rejected by the server and sends
+// the request again, if its body can be sent again
+func (ctx HttpClientCtx) retryUnauthorized(request *http.Request, response *http.Response, stale string) (*http.Response, error) {
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return response, nil
+	}
+	token, err := ctx.Refresher.Refresh(stale)
+	if err != nil {
+		log.Warning.Printf("failed to renew the user token: %v", err)
+		return response, nil
+	}
+	response.Body.Close()
+
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		request.Body = body
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return ctx.do(request)
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PartialSuffix ends the name of a file while it is being written
+const PartialSuffix = ".partial"
+
+// umask is applied to the mode of the files created by CreatePartial, it is
+// read from the process on unix
+var umask os.FileMode = 0022
+
+// CreatePartial creates an empty file next to path, with a unique name
+// ending in PartialSuffix so that concurrent writers of the same path don't
+// share it. It is created with perm (before umask) and is renamed over path
+// once complete.
+func CreatePartial(path string, perm os.FileMode) (*os.File, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*"+PartialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(perm &^ umask); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// PartialPath is CreatePartial for the tools writing a file by its name, the
+// file is closed and its name returned
+func PartialPath(path string) (string, error) {
+	f, err := CreatePartial(path, 0666)
+	if err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// WritePathAtomic is WriteFileAtomic for the tools writing a file by its
+// name: write is given the path of a partial file, renamed over path once
+// write succeeds
+func WritePathAtomic(path string, write func(partialPath string) error) error {
+	partialPath, err := PartialPath(path)
+	if err != nil {
+		return err
+	}
+	if err := write(partialPath); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return nil
+}
+
+// WriteFileAtomic writes a file through a temporary file next to it, renamed
+// over path once write succeeds, so that an interrupted run never leaves a
+// truncated file behind
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	return WriteFileAtomicPerm(path, 0666, write)
+}
+
+// WriteFileAtomicPerm is WriteFileAtomic creating the file with perm (before
+// umask), e.g. 0600 for the files holding tokens
+func WriteFileAtomicPerm(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	f, err := CreatePartial(path, perm)
+	if err != nil {
+		return err
+	}
+	partialPath := f.Name()
+
+	err = write(f)
+	if err == nil {
+		// the content is on disk before the rename makes it visible
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "doc.pdf")
+
+	err := WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, "complete")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+
+	// an interrupted write keeps the previous file and removes the partial one
+	err = WriteFileAtomic(path, func(w io.Writer) error {
+		io.WriteString(w, "trunc")
+		return errors.New("interrupted")
+	})
+	if err == nil {
+		t.Fatalf("expected the write error")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "complete" {
+		t.Errorf("got %q, %v, want the previous content", b, err)
+	}
+	if partials, _ := filepath.Glob(path + ".*" + PartialSuffix); len(partials) > 0 {
+		t.Errorf("partial files left behind: %v", partials)
+	}
+}
+
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.pdf")
+
+	// each writer has its own partial file, the last rename wins
+	contents := []string{"first writer", "second writer"}
+	var wg sync.WaitGroup
+	for _, content := range contents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := WriteFileAtomic(path, func(w io.Writer) error {
+				for i := 0; i < 100; i++ {
+					if _, err := io.WriteString(w, content); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("WriteFileAtomic: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != strings.Repeat(contents[0], 100) && s != strings.Repeat(contents[1], 100) {
+		t.Errorf("writes mixed up: %q", s)
+	}
+}
+
+func TestWriteFileAtomicPerm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file modes on windows")
+	}
+	path := filepath.Join(t.TempDir(), "tokens")
+
+	err := WriteFileAtomicPerm(path, 0600, func(w io.Writer) error {
+		_, err := io.WriteString(w, "secret")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WriteFileAtomicPerm: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("got mode %o, want 600", mode)
+	}
+}
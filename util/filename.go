@@ -0,0 +1,95 @@
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// FilenameRules are the file names a local file system accepts
+type FilenameRules int
+
+const (
+	// FilenameRulesOS are the rules of the running system
+	FilenameRulesOS FilenameRules = iota
+	// FilenameRulesPortable are the rules of Windows, the strictest, so
+	// that the files can be copied to any system
+	FilenameRulesPortable
+	// FilenameRulesPOSIX only replace the path separator and control
+	// characters
+	FilenameRulesPOSIX
+)
+
+// ParseFilenameRules parses os, portable or posix
+func ParseFilenameRules(s string) (FilenameRules, error) {
+	switch s {
+	case "os", "":
+		return FilenameRulesOS, nil
+	case "portable", "windows":
+		return FilenameRulesPortable, nil
+	case "posix":
+		return FilenameRulesPOSIX, nil
+	}
+	return FilenameRulesOS, fmt.Errorf("unknown file name rules: %s (os, portable or posix)", s)
+}
+
+// maxFilenameBytes leaves room for the extensions added to a name within
+// the 255 bytes most file systems allow
+const maxFilenameBytes = 200
+
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename returns a name usable as a local file name: the
+// characters the rules reject are replaced with replacement, and with
+// the Windows rules trailing dots and spaces are dropped and reserved
+// names (CON, NUL...) suffixed. Unicode characters such as emoji are
+// kept. Long names are truncated.
+func SanitizeFilename(name string, rules FilenameRules, replacement string) string {
+	invalid := "/"
+	switch {
+	case rules == FilenameRulesOS && runtime.GOOS == "windows":
+		rules = FilenameRulesPortable
+	case rules == FilenameRulesOS && runtime.GOOS == "darwin":
+		// shown as / by the Finder
+		invalid = "/:"
+	}
+	if rules == FilenameRulesPortable {
+		invalid = `/\<>:"|?*`
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(invalid, r) || r == utf8.RuneError {
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+
+	if rules == FilenameRulesPortable {
+		name = strings.TrimRight(name, ". ")
+		base, _, _ := strings.Cut(name, ".")
+		if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+			name = "_" + name
+		}
+	}
+
+	if len(name) > maxFilenameBytes {
+		cut := maxFilenameBytes
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules FilenameRules
+		want  string
+	}{
+		{"Notes 📓 été", FilenameRulesPortable, "Notes 📓 été"},
+		{"2024/05/01: standup", FilenameRulesPOSIX, "2024_05_01: standup"},
+		{"2024/05/01: standup", FilenameRulesPortable, "2024_05_01_ standup"},
+		{`what? "quotes" <tags>|*\`, FilenameRulesPortable, "what_ _quotes_ _tags____"},
+		{"line\nbreak", FilenameRulesPOSIX, "line_break"},
+		{"trailing dots...", FilenameRulesPortable, "trailing dots"},
+		{"trailing dots...", FilenameRulesPOSIX, "trailing dots..."},
+		{"con", FilenameRulesPortable, "_con"},
+		{"LPT1.notes", FilenameRulesPortable, "_LPT1.notes"},
+		{"console", FilenameRulesPortable, "console"},
+		{"..", FilenameRulesPOSIX, "_"},
+		{"...", FilenameRulesPortable, "_"},
+		{"", FilenameRulesPOSIX, "_"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFilename(tt.name, tt.rules, "_"); got != tt.want {
+			t.Errorf("SanitizeFilename(%q, %d) = %q, want %q", tt.name, tt.rules, got, tt.want)
+		}
+	}
+
+	long := SanitizeFilename(strings.Repeat("é", 150), FilenameRulesPOSIX, "_")
+	if len(long) > maxFilenameBytes || !strings.HasPrefix(strings.Repeat("é", 150), long) {
+		t.Errorf("long name not truncated on a character boundary: %d bytes", len(long))
+	}
+
+	if _, err := ParseFilenameRules("dos"); err == nil {
+		t.Errorf("expected an error for unknown rules")
+	}
+}
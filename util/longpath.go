@@ -0,0 +1,16 @@
+package util
+
+import "strings"
+
+// windowsLongPath returns the \\?\ form of an absolute Windows path, which
+// isn't limited to MAX_PATH (260) characters
+func windowsLongPath(abs string) string {
+	switch {
+	case strings.HasPrefix(abs, `\\?\`):
+		return abs
+	case strings.HasPrefix(abs, `\\`):
+		// UNC path \\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package util
+
+// LongPath returns the path unchanged, only Windows limits the length of
+// the paths
+func LongPath(p string) string {
+	return p
+}
@@ -0,0 +1,16 @@
+package util
+
+import "testing"
+
+func TestWindowsLongPath(t *testing.T) {
+	tests := map[string]string{
+		`C:\Users\me\remarkable`:   `\\?\C:\Users\me\remarkable`,
+		`\\nas\share\remarkable`:   `\\?\UNC\nas\share\remarkable`,
+		`\\?\C:\Users\me\document`: `\\?\C:\Users\me\document`,
+	}
+	for path, want := range tests {
+		if got := windowsLongPath(path); got != want {
+			t.Errorf("windowsLongPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package util
+
+import "path/filepath"
+
+// LongPath returns the absolute \\?\ form of a local path so that deep
+// folders aren't limited to 260 characters. The paths under it must be
+// joined with filepath.Join: / isn't a separator in this form.
+func LongPath(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	return windowsLongPath(abs)
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressEnabled controls whether transfers display their progress.
+// It is on for interactive terminals and can be turned off with --quiet
+var ProgressEnabled = isTerminal(os.Stdout)
+
+const (
+	// transfers smaller than this finish too fast to be worth a progress display
+	progressMinSize  = 1 << 20
+	progressInterval = 200 * time.Millisecond
+)
+
+// Progress displays the percentage, size and rate of a transfer of a known
+// total size. It is drawn in place after whatever the caller printed last on
+// the line (e.g. "downloading [file]...") and erased by Done, so the caller
+// can finish the line with its usual OK/FAILED.
+//
+// A nil *Progress is valid and does nothing.
+type Progress struct {
+	mu    sync.Mutex
+	out   io.Writer
+	total int64
+	done  int64
+	start time.Time
+	last  time.Time
+	width int
+}
+
+// NewProgress returns a progress display for a transfer of total bytes, or
+// nil if progress is disabled or the transfer is small
+func NewProgress(total int64) *Progress {
+	if !ProgressEnabled || total < progressMinSize {
+		return nil
+	}
+	return &Progress{
+		out:   os.Stdout,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// Reader wraps r so that reads from it are accounted in the progress.
+// If r is an io.ReadSeeker so is the returned reader, as uploads rely on it
+// to compute the checksum and content length.
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &progressReadSeeker{progressReader{r: rs, p: p}, rs}
+	}
+	return &progressReader{r: r, p: p}
+}
+
+// Done erases the progress display
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.draw("")
+}
+
+func (p *Progress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += n
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval && p.done < p.total {
+		return
+	}
+	p.last = now
+	p.draw(p.status())
+}
+
+func (p *Progress) status() string {
+	percent := p.done * 100 / p.total
+	if percent > 100 {
+		percent = 100
+	}
+
+	var rate int64
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = int64(float64(p.done) / elapsed)
+	}
+
+	return fmt.Sprintf(" %3d%% %s/%s %s/s", percent, FormatSize(p.done), FormatSize(p.total), FormatSize(rate))
+}
+
+// draw replaces the previous status with s using backspaces, so the text
+// before it on the line is kept
+func (p *Progress) draw(s string) {
+	var b strings.Builder
+	b.WriteString(strings.Repeat("\b", p.width))
+	b.WriteString(s)
+	if pad := p.width - len(s); pad > 0 {
+		b.WriteString(strings.Repeat(" ", pad))
+		b.WriteString(strings.Repeat("\b", pad))
+	}
+	fmt.Fprint(p.out, b.String())
+	p.width = len(s)
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+	// bytes accounted by this reader
+	n int64
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.n += int64(n)
+		pr.p.add(int64(n))
+	}
+	return n, err
+}
+
+type progressReadSeeker struct {
+	progressReader
+	s io.Seeker
+}
+
+// Seek rewinds the accounted bytes so that reading the data again (e.g.
+// after computing a checksum) is not counted twice
+func (pr *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := pr.s.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	pr.p.mu.Lock()
+	pr.p.done += pos - pr.n
+	pr.p.mu.Unlock()
+	pr.n = pos
+	return pos, nil
+}
+
+// FormatSize formats a byte count in a human readable way (e.g. 1.5 MB)
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024, optionally followed by B), e.g. 512M or 1.5G. "" is 0.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(s, "B")
+	multiplier := int64(1)
+	if s == "" {
+		return 0, errors.New("invalid size: missing number")
+	}
+	if i := strings.IndexByte("KMG", s[len(s)-1]); i >= 0 {
+		multiplier = 1 << (10 * (i + 1))
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
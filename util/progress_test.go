@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", FormatSize(512))
+	assert.Equal(t, "1.0 KB", FormatSize(1024))
+	assert.Equal(t, "1.5 MB", FormatSize(1536*1024))
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"": 0, "0": 0, "512": 512, "4k": 4096, "512M": 512 << 20, "1.5GB": 3 << 29} {
+		n, err := ParseSize(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, want, n, s)
+	}
+	_, err := ParseSize("lots")
+	assert.NotNil(t, err)
+	_, err = ParseSize("B")
+	assert.NotNil(t, err)
+}
+
+func TestProgressReader(t *testing.T) {
+	var out bytes.Buffer
+	p := &Progress{out: &out, total: 4}
+
+	data, err := io.ReadAll(p.Reader(strings.NewReader("data")))
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Contains(t, out.String(), "100%")
+
+	out.Reset()
+	p.Done()
+	assert.Equal(t, 0, p.width)
+	assert.NotContains(t, out.String(), "%")
+}
+
+func TestProgressReadSeeker(t *testing.T) {
+	p := &Progress{out: io.Discard, total: 4}
+
+	r, ok := p.Reader(strings.NewReader("data")).(io.ReadSeeker)
+	assert.True(t, ok)
+
+	_, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), p.done)
+
+	_, err = r.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), p.done)
+}
+
+func TestNilProgress(t *testing.T) {
+	var p *Progress
+	r := strings.NewReader("data")
+	assert.Equal(t, io.Reader(r), p.Reader(r))
+	p.Done()
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package util
+
+// PeakRSS returns 0, the peak resident set size is only known on unix
+func PeakRSS() int64 {
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+func init() {
+	// the umask can only be read by setting it
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	umask = os.FileMode(mask)
+}
@@ -15,6 +15,10 @@ const (
 	RM    = "rm"
 	EPUB  = "epub"
 	RMDOC = "rmdoc"
+	PNG   = "png"
+	JPG   = "jpg"
+	JPEG  = "jpeg"
+	SVG   = "svg"
 )
 
 var supportedExt = map[string]bool{
@@ -25,10 +29,61 @@ var supportedExt = map[string]bool{
 	RMDOC: true,
 }
 
+var imageExt = map[string]bool{
+	PNG:  true,
+	JPG:  true,
+	JPEG: true,
+}
+
 func IsFileTypeSupported(ext string) bool {
 	return supportedExt[ext]
 }
 
+// IsImageType reports whether ext is an image format that can be wrapped
+// into a PDF before upload
+func IsImageType(ext string) bool {
+	return imageExt[ext]
+}
+
+// rmLinesHeader starts the .rm files of the pages of notebooks
+const rmLinesHeader = "reMarkable .lines file"
+
+// DetectFileType returns the extension of the document format of data from
+// its first bytes: pdf, epub, rmdoc for the other zip archives or rm, empty
+// if the format is unknown
+func DetectFileType(data []byte) string {
+	switch {
+	case bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")):
+		// the header may follow a few bytes of garbage
+		return PDF
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if zipFirstFile(data, "mimetype", "application/epub+zip") {
+			return EPUB
+		}
+		return RMDOC
+	case bytes.HasPrefix(data, []byte(rmLinesHeader)):
+		return RM
+	}
+	return ""
+}
+
+// zipFirstFile tells whether the first file of a zip archive, stored
+// uncompressed, has the given name and content, as the mimetype file of EPUB
+// archives
+func zipFirstFile(data []byte, name, content string) bool {
+	const localHeaderLen = 30
+	if len(data) < localHeaderLen {
+		return false
+	}
+	nameLen := int(data[26]) | int(data[27])<<8
+	extraLen := int(data[28]) | int(data[29])<<8
+	start := localHeaderLen + nameLen + extraLen
+	if len(data) < start {
+		return false
+	}
+	return string(data[localHeaderLen:localHeaderLen+nameLen]) == name && bytes.HasPrefix(data[start:], []byte(content))
+}
+
 // DocPathToName extracts the file name and file extension (without .) from a given path
 func DocPathToName(p string) (name string, ext string) {
 	tmpExt := path.Ext(p)
@@ -50,6 +105,7 @@ func ToIOReader(source interface{}) (io.Reader, error) {
 	return bytes.NewReader(content), err
 }
 
+// CopyFile copies src to dst, which is only replaced once the copy is complete
 func CopyFile(src, dst string) (int64, error) {
 	r, err := os.Open(src)
 	if err != nil {
@@ -57,13 +113,11 @@ func CopyFile(src, dst string) (int64, error) {
 	}
 	defer r.Close()
 
-	w, err := os.Create(dst)
-	if err != nil {
-		return 0, err
-	}
-	defer w.Close()
-
-	n, err := io.Copy(w, r)
+	var n int64
+	err = WriteFileAtomic(dst, func(w io.Writer) error {
+		n, err = io.Copy(w, r)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
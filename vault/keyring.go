@@ -0,0 +1,31 @@
+package vault
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// keyringService is the service of the secrets of rmapi in the keychain
+const keyringService = "rmapi"
+
+// ErrNoKeyring is returned by SystemKeyring when the OS has no keychain
+// that rmapi can use
+var ErrNoKeyring = errors.New("no keychain available")
+
+// Keyring stores secrets by name, see SystemKeyring
+type Keyring interface {
+	// Get returns a secret, an error wrapping model.ErrNotFound if missing
+	Get(name string) (string, error)
+	// Set stores a secret, replacing the previous one
+	Set(name, secret string) error
+	// Delete removes a secret, missing secrets are not an error
+	Delete(name string) error
+}
+
+// lookTool returns ErrNoKeyring if a command line tool isn't installed
+func lookTool(name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return ErrNoKeyring
+	}
+	return nil
+}
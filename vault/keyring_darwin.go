@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/juruen/rmapi/model"
+)
+
+// exit status of security when the item doesn't exist
+const securityNotFound = 44
+
+// keychain keeps the secrets in the login keychain with the security tool
+type keychain struct{}
+
+// SystemKeyring returns the login keychain of macOS
+func SystemKeyring() (Keyring, error) {
+	if err := lookTool("security"); err != nil {
+		return nil, err
+	}
+	return keychain{}, nil
+}
+
+func (keychain) Get(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+		return "", fmt.Errorf("no secret %s in the keychain: %w", name, model.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("security: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (k keychain) Set(name, secret string) error {
+	// the command is read from stdin in interactive mode so that the secret
+	// doesn't show up in the arguments of the process
+	args := []string{"add-generic-password", "-U", "-s", keyringService, "-a", name, "-l", "rmapi " + name, "-w", secret}
+	for i, arg := range args {
+		args[i] = securityQuote(arg)
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(strings.Join(args, " ") + "\n")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		// interactive mode exits with 0 even if the command failed
+		if stored, getErr := k.Get(name); getErr != nil || stored != secret {
+			err = errors.New("add-generic-password failed")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("security: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for the command line of security -i
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func (keychain) Delete(name string) error {
+	err := exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", name).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("security: %v", err)
+	}
+	return nil
+}
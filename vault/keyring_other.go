@@ -0,0 +1,8 @@
+//go:build !darwin && !windows && !linux && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package vault
+
+// SystemKeyring isn't supported, the key is derived from a passphrase
+func SystemKeyring() (Keyring, error) {
+	return nil, ErrNoKeyring
+}
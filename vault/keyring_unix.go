@@ -0,0 +1,62 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/juruen/rmapi/model"
+)
+
+// secretService keeps the secrets in the Secret Service of the desktop
+// (GNOME Keyring, KWallet) with the secret-tool of libsecret
+type secretService struct{}
+
+// SystemKeyring returns the Secret Service of the desktop session
+func SystemKeyring() (Keyring, error) {
+	if err := lookTool("secret-tool"); err != nil {
+		return nil, err
+	}
+	// the service is reached over the session bus
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, ErrNoKeyring
+	}
+	return secretService{}, nil
+}
+
+func (secretService) Get(name string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keyringService, "account", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// lookup fails without output when the secret doesn't exist
+	if err != nil && len(out) == 0 && stderr.Len() == 0 {
+		return "", fmt.Errorf("no secret %s in the keyring: %w", name, model.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("secret-tool: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (secretService) Set(name, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "rmapi "+name, "service", keyringService, "account", name)
+	// the secret is read from stdin, not given as an argument
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (secretService) Delete(name string) error {
+	// clear succeeds when there is nothing to remove
+	if out, err := exec.Command("secret-tool", "clear", "service", keyringService, "account", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
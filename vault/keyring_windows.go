@@ -0,0 +1,100 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/juruen/rmapi/model"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// cryptprotectUIForbidden fails instead of prompting the user
+const cryptprotectUIForbidden = 0x1
+
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(b)), data: &b[0]}
+}
+
+func (b *dataBlob) bytes() []byte {
+	out := make([]byte, b.size)
+	copy(out, unsafe.Slice(b.data, b.size))
+	return out
+}
+
+// dpapi keeps the secrets in files of the user config dir encrypted with
+// the data protection API, only the Windows user can decrypt them
+type dpapi struct {
+	dir string
+}
+
+// SystemKeyring returns the secrets protected by the Windows account
+func SystemKeyring() (Keyring, error) {
+	if err := procCryptProtectData.Find(); err != nil {
+		return nil, ErrNoKeyring
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, ErrNoKeyring
+	}
+	return dpapi{dir: filepath.Join(configDir, "rmapi", "keyring")}, nil
+}
+
+func (k dpapi) path(name string) string {
+	return filepath.Join(k.dir, name)
+}
+
+func (k dpapi) Get(name string) (string, error) {
+	b, err := os.ReadFile(k.path(name))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no secret %s in the keyring: %w", name, model.ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newBlob(b))), 0, 0, 0, 0, cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return "", fmt.Errorf("CryptUnprotectData: %v", err)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+	return string(out.bytes()), nil
+}
+
+func (k dpapi) Set(name, secret string) error {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newBlob([]byte(secret)))), 0, 0, 0, 0, cryptprotectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return fmt.Errorf("CryptProtectData: %v", err)
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.data)))
+
+	if err := os.MkdirAll(k.dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(k.path(name), out.bytes(), 0600)
+}
+
+func (k dpapi) Delete(name string) error {
+	if err := os.Remove(k.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
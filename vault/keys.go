@@ -0,0 +1,157 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// ModeKeychain keeps a random key in the keychain of the OS
+	ModeKeychain = "keychain"
+	// ModePassphrase derives the key from a passphrase
+	ModePassphrase = "passphrase"
+
+	// PassphraseEnv holds the passphrase, so that it isn't asked
+	PassphraseEnv = "RMAPI_PASSPHRASE"
+
+	keyringName = "storage-key"
+	// iterations of PBKDF2-SHA256, as recommended by OWASP
+	pbkdf2Iterations = 600000
+	checkText        = "rmapi"
+)
+
+// Settings tell how to get the key of the encrypted storage, they are kept
+// in the config file
+type Settings struct {
+	Mode string `yaml:"mode"`
+	// Salt of the passphrase, base64
+	Salt string `yaml:"salt,omitempty"`
+	// Check is a known text sealed with the key, base64, telling a wrong
+	// key or passphrase from corrupt data
+	Check string `yaml:"check"`
+}
+
+// Setup creates a key stored with mode: in the keychain, or derived from
+// passphrase. It returns the settings finding the key again.
+func Setup(mode, passphrase string) (*Settings, *Key, error) {
+	settings := &Settings{Mode: mode}
+	var key *Key
+	switch mode {
+	case ModeKeychain:
+		keyring, err := SystemKeyring()
+		if err != nil {
+			return nil, nil, err
+		}
+		if key, err = NewKey(); err != nil {
+			return nil, nil, err
+		}
+		if err := keyring.Set(keyringName, base64.StdEncoding.EncodeToString(key[:])); err != nil {
+			return nil, nil, fmt.Errorf("failed to store the key in the keychain: %v", err)
+		}
+	case ModePassphrase:
+		if passphrase == "" {
+			return nil, nil, errors.New("empty passphrase")
+		}
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		var err error
+		if key, err = deriveKey(passphrase, salt); err != nil {
+			return nil, nil, err
+		}
+		settings.Salt = base64.StdEncoding.EncodeToString(salt)
+	default:
+		return nil, nil, fmt.Errorf("unknown encryption mode %q, use %s or %s", mode, ModeKeychain, ModePassphrase)
+	}
+
+	check, err := Seal(key, []byte(checkText))
+	if err != nil {
+		return nil, nil, err
+	}
+	settings.Check = base64.StdEncoding.EncodeToString(check)
+	return settings, key, nil
+}
+
+// Unlock returns the key of settings. The passphrase is read from
+// RMAPI_PASSPHRASE, or asked when ask isn't nil.
+func Unlock(settings *Settings, ask func() (string, error)) (*Key, error) {
+	var key *Key
+	switch settings.Mode {
+	case ModeKeychain:
+		keyring, err := SystemKeyring()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := keyring.Get(keyringName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the key from the keychain: %w", err)
+		}
+		b, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil || len(b) != len(Key{}) {
+			return nil, fmt.Errorf("invalid key in the keychain: %w", ErrDecrypt)
+		}
+		key = new(Key)
+		copy(key[:], b)
+	case ModePassphrase:
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			if ask == nil {
+				return nil, fmt.Errorf("the storage is encrypted with a passphrase, set %s", PassphraseEnv)
+			}
+			var err error
+			if passphrase, err = ask(); err != nil {
+				return nil, err
+			}
+		}
+		salt, err := base64.StdEncoding.DecodeString(settings.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid salt: %v", err)
+		}
+		if key, err = deriveKey(passphrase, salt); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %q", settings.Mode)
+	}
+
+	check, err := base64.StdEncoding.DecodeString(settings.Check)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check: %v", err)
+	}
+	if text, err := Open(key, check); err != nil || !bytes.Equal(text, []byte(checkText)) {
+		if settings.Mode == ModePassphrase {
+			return nil, fmt.Errorf("wrong passphrase: %w", ErrDecrypt)
+		}
+		return nil, fmt.Errorf("the key of the keychain doesn't match: %w", ErrDecrypt)
+	}
+	return key, nil
+}
+
+// Remove deletes the key of settings from the keychain, if it's there
+func Remove(settings *Settings) error {
+	if settings.Mode != ModeKeychain {
+		return nil
+	}
+	keyring, err := SystemKeyring()
+	if err != nil {
+		return err
+	}
+	return keyring.Delete(keyringName)
+}
+
+func deriveKey(passphrase string, salt []byte) (*Key, error) {
+	b, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, len(Key{}))
+	if err != nil {
+		return nil, err
+	}
+	key := new(Key)
+	copy(key[:], b)
+	return key, nil
+}
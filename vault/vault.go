@@ -0,0 +1,241 @@
+// Package vault encrypts the tokens and caches that rmapi keeps on disk.
+// The key is stored in the keychain of the OS or derived from a
+// passphrase, see Setup and Unlock. Data is sealed with AES-256-GCM in
+// chunks, so that large files are encrypted and decrypted as streams.
+package vault
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// magic starts the sealed data, followed by the nonce prefix
+	magic      = "RMENC001"
+	prefixSize = 8
+	headerSize = len(magic) + prefixSize
+	// chunkSize is the size of the plain chunks, all but the last are full
+	chunkSize = 64 << 10
+	overhead  = 16
+)
+
+// ErrDecrypt is returned when sealed data can't be decrypted: wrong key,
+// truncated or modified data
+var ErrDecrypt = errors.New("wrong key or corrupt data")
+
+// DefaultKey is the key of the encrypted storage of this process, set by
+// main once unlocked. The tokens and caches are stored in plain when nil.
+var DefaultKey *Key
+
+// Key is an AES-256 key
+type Key [32]byte
+
+// NewKey returns a random key
+func NewKey() (*Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (k *Key) aead() cipher.AEAD {
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		// only for keys of another size
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// IsSealed tells whether data was sealed by this package
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// IsSealedFile tells whether the file at path was sealed by this package
+func IsSealedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return IsSealed(head)
+}
+
+// PlainSize returns the size of the plain data of sealed data of
+// sealedSize bytes
+func PlainSize(sealedSize int64) int64 {
+	body := sealedSize - int64(headerSize)
+	chunks := body/(chunkSize+overhead) + 1
+	return body - chunks*overhead
+}
+
+// Seal encrypts data with key
+func Seal(key *Key, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open decrypts data sealed with key
+func Open(key *Key, sealed []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// nonce returns the nonce of a chunk: the random prefix of the stream and
+// the chunk number
+func nonce(prefix []byte, counter uint32) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixSize:], counter)
+	return n
+}
+
+// additional data of the chunks, the last one is marked so that a
+// truncated stream isn't taken for a complete one
+var (
+	middleChunk = []byte{0}
+	lastChunk   = []byte{1}
+)
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+// NewWriter returns a writer sealing the data written to w with key, the
+// last chunk is written by Close
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(magic), prefix...)); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: key.aead(), prefix: prefix, buf: make([]byte, 0, chunkSize+overhead)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to a closed vault writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == chunkSize {
+			if err := w.flush(middleChunk); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush seals the buffered chunk, a full chunk is never the last one
+func (w *writer) flush(ad []byte) error {
+	if w.counter == 1<<32-1 {
+		return errors.New("vault stream too long")
+	}
+	sealed := w.aead.Seal(w.buf[:0], nonce(w.prefix, w.counter), w.buf, ad)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Close writes the last chunk, it doesn't close the underlying writer
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(lastChunk)
+}
+
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// NewReader returns a reader decrypting data sealed with key. Reading
+// fails with ErrDecrypt when the data doesn't match the key or was cut.
+func NewReader(r io.Reader, key *Key) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || !IsSealed(header) {
+		return nil, fmt.Errorf("not sealed data: %w", ErrDecrypt)
+	}
+	return &reader{r: r, aead: key.aead(), prefix: header[len(magic):], buf: make([]byte, chunkSize+overhead)}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.r, r.buf)
+		ad := middleChunk
+		switch {
+		case err == nil:
+		case err == io.ErrUnexpectedEOF || err == io.EOF:
+			// the last chunk is the only one shorter than a full chunk
+			ad = lastChunk
+			r.done = true
+		default:
+			return 0, err
+		}
+		if n < overhead {
+			return 0, fmt.Errorf("truncated data: %w", ErrDecrypt)
+		}
+		plain, err := r.aead.Open(r.buf[:0], nonce(r.prefix, r.counter), r.buf[:n], ad)
+		if err != nil {
+			return 0, ErrDecrypt
+		}
+		r.counter++
+		r.plain = plain
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
@@ -0,0 +1,88 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewKey()
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		data := bytes.Repeat([]byte{byte(size)}, size)
+		sealed, err := Seal(key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(sealed) || (size >= 64 && bytes.Contains(sealed, data[:64])) {
+			t.Errorf("%d bytes: not sealed", size)
+		}
+		if got := PlainSize(int64(len(sealed))); got != int64(size) {
+			t.Errorf("%d bytes: plain size %d", size, got)
+		}
+		plain, err := Open(key, sealed)
+		if err != nil || !bytes.Equal(plain, data) {
+			t.Errorf("%d bytes: round trip failed: %v", size, err)
+		}
+
+		if _, err := Open(other, sealed); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("%d bytes: opened with another key: %v", size, err)
+		}
+		// cut at the end of a chunk, or in the middle of the last one
+		for _, cut := range []int{headerSize + chunkSize + overhead, len(sealed) - 1} {
+			if cut >= len(sealed) || cut < headerSize {
+				continue
+			}
+			if _, err := Open(key, sealed[:cut]); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("%d bytes cut at %d: %v", size, cut, err)
+			}
+		}
+	}
+
+	// small writes are buffered into chunks
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, key)
+	data := bytes.Repeat([]byte("0123456789"), chunkSize/5)
+	for i := 0; i < len(data); i += 7 {
+		w.Write(data[i:min(i+7, len(data))])
+	}
+	w.Close()
+	r, err := NewReader(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stream round trip failed: %v", err)
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+	settings, key, err := Setup(ModePassphrase, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask := func(passphrase string) func() (string, error) {
+		return func() (string, error) { return passphrase, nil }
+	}
+	unlocked, err := Unlock(settings, ask("correct horse"))
+	if err != nil || *unlocked != *key {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if _, err := Unlock(settings, ask("wrong")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a wrong passphrase, got %v", err)
+	}
+	if _, err := Unlock(settings, nil); err == nil {
+		t.Errorf("unlocked without a passphrase")
+	}
+	t.Setenv(PassphraseEnv, "correct horse")
+	if _, err := Unlock(settings, nil); err != nil {
+		t.Errorf("passphrase of the environment not used: %v", err)
+	}
+}